@@ -21,7 +21,8 @@ TOOLS_DIR = ROOT_DIR / "tools" / "mls_harness"
 WASM_PATH = WEB_DIR / "vendor" / "mls_harness.wasm"
 WASM_EXEC = WEB_DIR / "vendor" / "wasm_exec.js"
 VECTORS_JSON = VECTORS_DIR / "dm_smoke_v1.json"
-ROOM_TRANSCRIPT_JSON = VECTORS_DIR / "room_seeded_bootstrap_v1.json"
+ROOM_TRANSCRIPT_JSON = VECTORS_DIR / "room_seeded_bootstrap_v2.json"
+WG_VECTORS_DIR = ROOT_DIR / "tools" / "mls_harness" / "vectors" / "mlswg"
 HELPERS_DIR = Path(__file__).resolve().parent / "helpers"
 sys.path.insert(0, str(HELPERS_DIR))
 from chromium_cdp import (  # noqa: E402
@@ -280,12 +281,18 @@ def _prepare_smoke_assets(temp_root: Path) -> tuple[Path, Path]:
     shutil.copy(WEB_DIR / "mls_vectors_loader.js", temp_root / "mls_vectors_loader.js")
     shutil.copy(VECTORS_JSON, vectors_dir / VECTORS_JSON.name)
     shutil.copy(ROOM_TRANSCRIPT_JSON, vectors_dir / ROOM_TRANSCRIPT_JSON.name)
+    wg_vectors_dir = vectors_dir / "mlswg"
+    wg_vectors_dir.mkdir(parents=True, exist_ok=True)
+    for name in ("crypto-basics.json", "tree-math.json"):
+        shutil.copy(WG_VECTORS_DIR / name, wg_vectors_dir / name)
     (temp_root / "favicon.ico").write_bytes(b"")
 
     module_file = temp_root / "phase5_browser_runtime_smoke.js"
     module_file.write_text(
         """import {
   verify_vectors_from_url,
+  verify_wg_vectors,
+  mls_capabilities,
   dm_create_participant,
   dm_init,
   dm_join,
@@ -416,7 +423,24 @@ const run = async () => {
     throw new Error(`vector verify failed: ${JSON.stringify(vectors)}`);
   }
 
-  const roomTranscriptResp = await fetch('./vectors/room_seeded_bootstrap_v1.json');
+  const wg_input = {};
+  for (const [key, name] of [['crypto_basics', 'crypto-basics'], ['tree_math', 'tree-math']]) {
+    const resp = await fetch(`./vectors/mlswg/${name}.json`);
+    if (!resp.ok) {
+      throw new Error(`${name} fetch failed: ${resp.status}`);
+    }
+    wg_input[key] = await resp.text();
+  }
+  const wg_vectors = await verify_wg_vectors(wg_input);
+  if (!wg_vectors || wg_vectors.ok !== true) {
+    throw new Error(`MLSWG vector verify failed: ${JSON.stringify(wg_vectors)}`);
+  }
+  const capabilities = await mls_capabilities();
+  if (!capabilities || !capabilities.operations || !capabilities.operations.dmEncrypt) {
+    throw new Error(`capabilities missing dmEncrypt: ${JSON.stringify(capabilities)}`);
+  }
+
+  const roomTranscriptResp = await fetch('./vectors/room_seeded_bootstrap_v2.json');
   if (!roomTranscriptResp.ok) {
     throw new Error(`room transcript fetch failed: ${roomTranscriptResp.status}`);
   }
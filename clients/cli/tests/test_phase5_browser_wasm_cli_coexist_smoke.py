@@ -980,15 +980,15 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     await send({ v: 1, t: 'conv.subscribe', id: 'sub-dm', body: { conv_id: payload.conv_ids.dm, from_seq: 1 } });
     await send({ v: 1, t: 'conv.subscribe', id: 'sub-room', body: { conv_id: payload.conv_ids.room, from_seq: 1 } });
 
-    const alice_dm_create = ensureOk(globalThis.dmCreateParticipant('alice-dm', payload.seed_dm));
-    const alice_room_create = ensureOk(globalThis.dmCreateParticipant('alice-room', payload.seed_room));
-    const guest_create = ensureOk(globalThis.dmCreateParticipant('room-guest', payload.seed_guest));
+    const alice_dm_create = ensureOk(await globalThis.dmCreateParticipant('alice-dm', payload.seed_dm));
+    const alice_room_create = ensureOk(await globalThis.dmCreateParticipant('alice-room', payload.seed_room));
+    const guest_create = ensureOk(await globalThis.dmCreateParticipant('room-guest', payload.seed_guest));
 
     let alice_dm_participant = alice_dm_create.participant_b64;
     let alice_room_participant = alice_room_create.participant_b64;
 
     const dm_init = ensureOk(
-      globalThis.dmInit(alice_dm_participant, payload.bob_keypackages.dm, payload.group_ids.dm, payload.seed_dm_init),
+      await globalThis.dmInit(alice_dm_participant, payload.bob_keypackages.dm, payload.group_ids.dm, payload.seed_dm_init),
     );
     alice_dm_participant = dm_init.participant_b64;
 
@@ -1005,7 +1005,7 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     await expectEvent(payload.conv_ids.dm, dm_welcome_seq);
 
     const room_init = ensureOk(
-      globalThis.groupInit(
+      await globalThis.groupInit(
         alice_room_participant,
         [payload.bob_keypackages.room, guest_create.keypackage_b64],
         payload.group_ids.room,
@@ -1034,7 +1034,7 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     const dm_commit_event = await expectEvent(payload.conv_ids.dm, dm_commit_seq);
     const dm_commit_env_payload = unpackEnv(dm_commit_event.body.env).payload_b64;
     alice_dm_participant = ensureOk(
-      globalThis.dmCommitApply(alice_dm_participant, dm_commit_env_payload),
+      await globalThis.dmCommitApply(alice_dm_participant, dm_commit_env_payload),
       'dm commit apply',
     ).participant_b64;
 
@@ -1047,11 +1047,11 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     const room_commit_event = await expectEvent(payload.conv_ids.room, room_commit_seq);
     const room_commit_env_payload = unpackEnv(room_commit_event.body.env).payload_b64;
     alice_room_participant = ensureOk(
-      globalThis.dmCommitApply(alice_room_participant, room_commit_env_payload),
+      await globalThis.dmCommitApply(alice_room_participant, room_commit_env_payload),
       'room commit apply',
     ).participant_b64;
 
-    const dm_cipher = ensureOk(globalThis.dmEncrypt(alice_dm_participant, payload.plaintext.dm_send)).ciphertext_b64;
+    const dm_cipher = ensureOk(await globalThis.dmEncrypt(alice_dm_participant, payload.plaintext.dm_send)).ciphertext_b64;
     const dm_env = packEnv(3, dm_cipher);
     const dm_seq = await sendWithAck({
       v: 1,
@@ -1071,7 +1071,7 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     );
     const dm_reply_payload = unpackEnv(dm_reply_event.body.env).payload_b64;
     const dm_reply_plain = ensureOk(
-      globalThis.dmDecrypt(alice_dm_participant, dm_reply_payload),
+      await globalThis.dmDecrypt(alice_dm_participant, dm_reply_payload),
       'dm decrypt',
     ).plaintext;
     if (dm_reply_plain !== payload.plaintext.dm_reply) {
@@ -1090,7 +1090,7 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
 
     await new Promise((resolve) => setTimeout(resolve, 500));
 
-    const room_cipher = ensureOk(globalThis.dmEncrypt(alice_room_participant, payload.plaintext.room_send)).ciphertext_b64;
+    const room_cipher = ensureOk(await globalThis.dmEncrypt(alice_room_participant, payload.plaintext.room_send)).ciphertext_b64;
     const room_env = packEnv(3, room_cipher);
     const room_seq = await sendWithAck({
       v: 1,
@@ -1110,7 +1110,7 @@ class Phase5BrowserWasmCliCoexistSmokeTests(unittest.IsolatedAsyncioTestCase):
     );
     const room_reply_payload = unpackEnv(room_reply_event.body.env).payload_b64;
     const room_reply_plain = ensureOk(
-      globalThis.dmDecrypt(alice_room_participant, room_reply_payload),
+      await globalThis.dmDecrypt(alice_room_participant, room_reply_payload),
       'room decrypt',
     ).plaintext;
     if (room_reply_plain !== payload.plaintext.room_reply) {
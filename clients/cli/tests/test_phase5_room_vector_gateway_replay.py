@@ -382,7 +382,7 @@ class _Phase5GatewayReplayBase(unittest.IsolatedAsyncioTestCase):
 
 class Phase5RoomVectorGatewayReplayTests(_Phase5GatewayReplayBase):
     async def test_room_vector_replay_via_gateway_sse(self) -> None:
-        vector = _load_vector("room_seeded_bootstrap_v1.json")
+        vector = _load_vector("room_seeded_bootstrap_v2.json")
         conv_id = vector["conv_id"]
         events = vector["events"]
 
@@ -478,7 +478,7 @@ class Phase5RoomVectorGatewayReplayTests(_Phase5GatewayReplayBase):
         )
 
     async def test_room_vector_replay_via_gateway_ws(self) -> None:
-        vector = _load_vector("room_seeded_bootstrap_v1.json")
+        vector = _load_vector("room_seeded_bootstrap_v2.json")
         conv_id = vector["conv_id"]
         events = vector["events"]
 
@@ -660,7 +660,7 @@ class Phase5RoomVectorGatewayReplayTests(_Phase5GatewayReplayBase):
 
 class Phase5DmVectorGatewayReplayTests(_Phase5GatewayReplayBase):
     async def test_dm_vector_replay_via_gateway_sse(self) -> None:
-        vector = _load_vector("interop_transcript_seeded_smoke_v2.json")
+        vector = _load_vector("interop_transcript_seeded_smoke_v3.json")
         conv_id = vector["conv_id"]
         events = vector["events"]
 
@@ -745,7 +745,7 @@ class Phase5DmVectorGatewayReplayTests(_Phase5GatewayReplayBase):
         )
 
     async def test_dm_vector_replay_via_gateway_ws(self) -> None:
-        vector = _load_vector("interop_transcript_seeded_smoke_v2.json")
+        vector = _load_vector("interop_transcript_seeded_smoke_v3.json")
         conv_id = vector["conv_id"]
         events = vector["events"]
 
@@ -815,8 +815,8 @@ class Phase5DmVectorGatewayReplayTests(_Phase5GatewayReplayBase):
 
 class Phase5CoexistGatewayReplayTests(_Phase5GatewayReplayBase):
     async def test_coexist_dm_room_interleaved_replay_via_gateway_ws(self) -> None:
-        dm_vector = _load_vector("interop_transcript_seeded_smoke_v2.json")
-        room_vector = _load_vector("room_seeded_bootstrap_v1.json")
+        dm_vector = _load_vector("interop_transcript_seeded_smoke_v3.json")
+        room_vector = _load_vector("room_seeded_bootstrap_v2.json")
         dm_conv_id = dm_vector["conv_id"]
         room_conv_id = room_vector["conv_id"]
         dm_events = dm_vector["events"]
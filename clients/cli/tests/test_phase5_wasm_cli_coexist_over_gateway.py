@@ -27,24 +27,155 @@ WEB_README = WEB_DIR / "README.md"
 
 REQUIRED_GLOBALS = {
     "verifyVectors",
+    "generateVectors",
+    "verifyWGVectors",
+    "mlsInit",
+    "mlsPing",
+    "mlsCapabilities",
+    "mlsBench",
+    "mlsRecordTranscript",
+    "mlsExportTranscript",
+    "replayTranscript",
+    "registerStateListener",
+    "unregisterStateListener",
     "dmCreateParticipant",
     "dmInit",
+    "groupInit",
     "dmJoin",
     "dmCommitApply",
+    "groupAdd",
+    "dmRemove",
+    "dmUpdate",
+    "dmMaybeRotate",
+    "dmProposeAdd",
+    "dmProposeRemove",
+    "dmProposeUpdate",
+    "dmLeave",
+    "dmCommitPending",
+    "dmHandleProposal",
+    "dmInfo",
+    "dmEpoch",
+    "dmNextOutgoingGeneration",
+    "dmLastDecryptedGeneration",
+    "dmExportSecret",
+    "dmHistoryKey",
+    "dmGroups",
+    "dmListGroups",
+    "dmPendingCommits",
+    "dmApplyPending",
+    "dmDiscardPending",
+    "dmSetRetention",
+    "dmSetPadding",
+    "dmSetRotation",
+    "dmSetAdmins",
+    "dmKeyPackagePool",
+    "dmSplitWelcome",
+    "dmKeyPackageHash",
+    "dmValidateKeyPackage",
+    "dmInspectArtifact",
+    "dmDeriveGroupID",
+    "dmIdentityMessage",
+    "dmBindIdentity",
+    "dmSetStateKey",
+    "dmDestroy",
+    "dmSetLegacySeededRand",
+    "dmSetArtifactEnvelopes",
+    "dmSealParticipant",
+    "dmOpenParticipant",
+    "dmExportBackup",
+    "dmImportBackup",
     "dmEncrypt",
     "dmDecrypt",
-    "groupInit",
-    "groupAdd",
+    "dmEncryptMessage",
+    "dmDecryptMessage",
+    "dmEncryptContent",
+    "dmEncryptBatch",
+    "dmDecryptBatch",
+    "dmEncryptStream",
+    "dmDecryptStream",
+    "dmEncryptStreamBegin",
+    "dmSealStreamChunk",
+    "dmOpenStreamChunk",
+    "dmOpenSession",
+    "dmSessionEncrypt",
+    "dmSessionEncryptMessage",
+    "dmSessionDecrypt",
+    "dmSessionEncryptBatch",
+    "dmSessionDecryptBatch",
+    "dmSessionCommitApply",
+    "dmSessionInfo",
+    "dmSessionSave",
+    "dmCloseSession",
 }
 
 EXPECTED_LOADER_GLOBALS = {
     "verifyVectors",
+    "generateVectors",
+    "verifyWGVectors",
+    "mlsInit",
+    "mlsPing",
+    "mlsCapabilities",
+    "mlsBench",
+    "mlsRecordTranscript",
+    "mlsExportTranscript",
+    "replayTranscript",
+    "registerStateListener",
+    "unregisterStateListener",
     "dmCreateParticipant",
     "dmInit",
     "dmJoin",
     "dmCommitApply",
     "dmEncrypt",
     "dmDecrypt",
+    "dmDecryptMessage",
+    "dmDecryptBatch",
+    "dmEncryptStream",
+    "dmDecryptStream",
+    "dmEncryptStreamBegin",
+    "dmSealStreamChunk",
+    "dmOpenStreamChunk",
+    "dmOpenSession",
+    "dmSessionEncrypt",
+    "dmSessionEncryptMessage",
+    "dmSessionDecrypt",
+    "dmSessionEncryptBatch",
+    "dmSessionDecryptBatch",
+    "dmSessionCommitApply",
+    "dmSessionInfo",
+    "dmSessionSave",
+    "dmCloseSession",
+    "dmDiscardPending",
+    "dmEncryptBatch",
+    "dmEncryptContent",
+    "dmEncryptMessage",
+    "dmExportSecret",
+    "dmGroups",
+    "dmListGroups",
+    "dmHistoryKey",
+    "dmInfo",
+    "dmEpoch",
+    "dmNextOutgoingGeneration",
+    "dmLastDecryptedGeneration",
+    "dmKeyPackagePool",
+    "dmRemove",
+    "dmUpdate",
+    "dmLeave",
+    "dmMaybeRotate",
+    "dmPendingCommits",
+    "dmInspectArtifact",
+    "dmSetAdmins",
+    "dmSetArtifactEnvelopes",
+    "dmSetPadding",
+    "dmSetRetention",
+    "dmSetRotation",
+    "dmSetStateKey",
+    "dmDestroy",
+    "dmDeriveGroupID",
+    "dmIdentityMessage",
+    "dmBindIdentity",
+    "dmSplitWelcome",
+    "dmKeyPackageHash",
+    "dmValidateKeyPackage",
 }
 
 EXPECTED_VECTORS_UI_GLOBALS = {
@@ -54,6 +185,7 @@ EXPECTED_VECTORS_UI_GLOBALS = {
     "dmDecrypt",
     "groupInit",
     "groupAdd",
+    "dmSetLegacySeededRand",
 }
 
 DISALLOWED_WEB_FILES = {
@@ -102,7 +234,7 @@ def _sha256_bytes(data: bytes) -> str:
 class Phase5WasmCliCoexistOverGatewayTests(unittest.TestCase):
     def test_wasm_global_api_contract(self) -> None:
         text = _read_text(WASM_MAIN)
-        found = _extract_matches(r'js\.Global\(\)\.Set\("([^"]+)"', text)
+        found = _extract_matches(r'(?:js\.Global\(\)\.Set|setAsync|setSync)\("([^"]+)"', text)
         _assert_set_match("wasm globals", found, REQUIRED_GLOBALS)
 
     def test_web_loader_contract(self) -> None:
@@ -1,16 +1,18 @@
 import base64
 import hashlib
 import json
+import os
 import tempfile
 import unittest
 from pathlib import Path
+from unittest import mock
 
 from cli_app import dm_envelope, mls_poc
 
 
 def _load_seeded_vector() -> dict:
     repo_root = mls_poc.find_repo_root()
-    vector_path = repo_root / "clients" / "web" / "vectors" / "dm_seeded_welcome_commit_v1.json"
+    vector_path = repo_root / "clients" / "web" / "vectors" / "dm_seeded_welcome_commit_v2.json"
     with vector_path.open("r", encoding="utf-8") as handle:
         return json.load(handle)
 
@@ -77,8 +79,13 @@ class WebInteropDmVectorTests(unittest.TestCase):
             self.assertEqual(msg_id_hex, vector["msg_id_hex"])
 
     def test_seeded_dm_welcome_commit_vector(self):
+        # The seeded vectors predate the per-call DRBG; replay them with the
+        # crypto/rand override they were recorded under.
+        legacy_rand = mock.patch.dict(os.environ, {"MLS_HARNESS_LEGACY_SEEDED_RAND": "1"})
+        legacy_rand.start()
+        self.addCleanup(legacy_rand.stop)
         vector = _load_seeded_vector()
-        self.assertEqual(vector["name"], "dm_seeded_welcome_commit_v1")
+        self.assertEqual(vector["name"], "dm_seeded_welcome_commit_v2")
 
         seeds = vector["seeds"]
         group_id_b64 = vector["group_id_b64"]
@@ -1,7 +1,9 @@
 import json
+import os
 import tempfile
 import unittest
 from pathlib import Path
+from unittest import mock
 
 from cli_app import dm_envelope, mls_poc
 from cli_app.interop_transcript import (
@@ -16,7 +18,7 @@ class WebInteropRoomVectorTests(unittest.TestCase):
     def setUp(self) -> None:
         self.repo_root = Path(__file__).resolve().parents[3]
         self.vector_path = (
-            self.repo_root / "clients" / "web" / "vectors" / "room_seeded_bootstrap_v1.json"
+            self.repo_root / "clients" / "web" / "vectors" / "room_seeded_bootstrap_v2.json"
         )
 
     def _load_vector(self) -> dict:
@@ -71,6 +73,10 @@ class WebInteropRoomVectorTests(unittest.TestCase):
         self.assertEqual(seen_seqs, {1, 2, 3, 4, 5})
 
     def test_seeded_room_app_env_decrypts(self) -> None:
+        # Recorded under the legacy crypto/rand override.
+        legacy_rand = mock.patch.dict(os.environ, {"MLS_HARNESS_LEGACY_SEEDED_RAND": "1"})
+        legacy_rand.start()
+        self.addCleanup(legacy_rand.stop)
         vector = self._load_vector()
         seeds = vector["seeds"]
         group_id_b64 = vector["group_id_b64"]
@@ -1,7 +1,10 @@
+import hashlib
 import json
+import os
 import tempfile
 import unittest
 from pathlib import Path
+from unittest import mock
 
 from cli_app import dm_envelope, mls_poc
 from cli_app.interop_transcript import (
@@ -18,6 +21,7 @@ class WebInteropTranscriptVectorTests(unittest.TestCase):
         self.vector_paths = [
             self.repo_root / "clients" / "web" / "vectors" / "interop_transcript_smoke_v1.json",
             self.repo_root / "clients" / "web" / "vectors" / "interop_transcript_seeded_smoke_v2.json",
+            self.repo_root / "clients" / "web" / "vectors" / "interop_transcript_seeded_smoke_v3.json",
         ]
 
     def _load_vector(self, vector_path: Path) -> dict:
@@ -176,19 +180,23 @@ class WebInteropTranscriptVectorTests(unittest.TestCase):
                 self.assertEqual(digest_b64, vector["digest_sha256_b64"])
 
     def test_seeded_transcript_app_env_decrypts(self) -> None:
+        # The seeded envs only reproduce with the legacy crypto/rand override.
+        legacy_rand = mock.patch.dict(os.environ, {"MLS_HARNESS_LEGACY_SEEDED_RAND": "1"})
+        legacy_rand.start()
+        self.addCleanup(legacy_rand.stop)
         seeded_vector_path = (
             self.repo_root
             / "clients"
             / "web"
             / "vectors"
-            / "interop_transcript_seeded_smoke_v2.json"
+            / "interop_transcript_seeded_smoke_v3.json"
         )
         dm_seeded_vector_path = (
             self.repo_root
             / "clients"
             / "web"
             / "vectors"
-            / "dm_seeded_welcome_commit_v1.json"
+            / "dm_seeded_welcome_commit_v2.json"
         )
         seeded_vector = self._load_vector(seeded_vector_path)
         dm_seeded_vector = self._load_vector(dm_seeded_vector_path)
@@ -291,7 +299,7 @@ class Phase5CoexistBundleVectorTests(unittest.TestCase):
     def setUp(self) -> None:
         self.repo_root = Path(__file__).resolve().parents[3]
         self.vector_path = (
-            self.repo_root / "clients" / "web" / "vectors" / "phase5_coexist_bundle_seeded_v1.json"
+            self.repo_root / "clients" / "web" / "vectors" / "phase5_coexist_bundle_seeded_v2.json"
         )
 
     def _load_vector(self, vector_path: Path) -> dict:
@@ -353,5 +361,24 @@ class Phase5CoexistBundleVectorTests(unittest.TestCase):
                     self._assert_peer_tokens_types(section["peer_tokens"])
 
 
+class FrozenVectorTests(unittest.TestCase):
+    # Superseded vectors stay byte-for-byte as released; a change to what the
+    # harness emits gets a new versioned file next to them instead.
+    FROZEN_SHA256 = {
+        "interop_transcript_smoke_v1.json": "49a267d19bb369be1afc0119dd2b8e8df940c6b4ba034ef540dedfdb65fd55cf",
+        "interop_transcript_seeded_smoke_v2.json": "41fb842c50c04d3ccba051526b90d214ccba2a1f0f702006d36b8ecc4ce2a890",
+        "dm_seeded_welcome_commit_v1.json": "edae7f0bca84ce5d6e0598388bfcb3bdd7706fad814c8dc8bb442b65fc954f6a",
+        "room_seeded_bootstrap_v1.json": "c6d5552a55093d695f991f966c0b8acefa99c8f226670fc8311506afa2ef68ae",
+        "phase5_coexist_bundle_seeded_v1.json": "8f914eb00477eea85a4812e33b9b4512682d5be4525c8a00b8e05bdad2a62181",
+    }
+
+    def test_frozen_vectors_unchanged(self) -> None:
+        vectors_dir = Path(__file__).resolve().parents[3] / "clients" / "web" / "vectors"
+        for name, expected in self.FROZEN_SHA256.items():
+            with self.subTest(vector=name):
+                digest = hashlib.sha256((vectors_dir / name).read_bytes()).hexdigest()
+                self.assertEqual(digest, expected)
+
+
 if __name__ == "__main__":
     unittest.main()
@@ -28,6 +28,97 @@ export const ensure_wasm_ready = async () => {
 await load_wasm();
 };
 
+export const mls_init = async (options) => {
+await load_wasm();
+return globalThis.mlsInit(options);
+};
+
+export const mls_ping = async () => {
+await load_wasm();
+return globalThis.mlsPing();
+};
+
+// mls_capabilities lists what the loaded module offers, operations included,
+// so a page checks for a binding before calling it.
+export const mls_capabilities = async () => {
+await load_wasm();
+return globalThis.mlsCapabilities();
+};
+
+// mls_bench times the dm operations in this browser; opts is {iterations,
+// message_bytes, batch, cipher_suite}, each optional.
+export const mls_bench = async (opts = {}) => {
+await load_wasm();
+return globalThis.mlsBench(opts);
+};
+
+// A transcript records the dm calls made while recording, secrets included;
+// attach it to a bug report only from a deterministic test module.
+export const mls_record_transcript = async (enabled) => {
+await load_wasm();
+return globalThis.mlsRecordTranscript(enabled);
+};
+
+export const mls_export_transcript = async () => {
+await load_wasm();
+return globalThis.mlsExportTranscript();
+};
+
+export const replay_transcript = async (transcript_json) => {
+await load_wasm();
+return globalThis.replayTranscript(transcript_json);
+};
+
+// create_mls_worker starts mls_worker.js and returns {call, ping, terminate}.
+// call(name, args, transfer) runs the binding name in the worker and resolves
+// with its result; pass the ArrayBuffers of Uint8Array arguments the page no
+// longer needs as transfer to move them instead of copying.
+export const create_mls_worker = (worker_url = 'mls_worker.js') => {
+const worker = new Worker(worker_url);
+const pending_calls = new Map();
+let next_id = 1;
+worker.onmessage = (event) => {
+const { id, result, error } = event.data;
+const pending_call = pending_calls.get(id);
+if (!pending_call) {
+return;
+}
+pending_calls.delete(id);
+if (error !== undefined) {
+pending_call.reject(new Error(error));
+} else {
+pending_call.resolve(result);
+}
+};
+const call = (name, args = [], transfer = []) => new Promise((resolve, reject) => {
+const id = next_id;
+next_id += 1;
+pending_calls.set(id, { resolve, reject });
+worker.postMessage({ id, name, args }, transfer);
+});
+return {
+call,
+ping: () => call('mlsPing'),
+terminate: () => {
+worker.terminate();
+for (const pending_call of pending_calls.values()) {
+pending_call.reject(new Error('MLS worker terminated'));
+}
+pending_calls.clear();
+},
+};
+};
+
+export const register_state_listener = async (callback) => {
+await load_wasm();
+return globalThis.registerStateListener(callback);
+};
+
+export const unregister_state_listener = async (listener) => {
+await load_wasm();
+return globalThis.unregisterStateListener(listener);
+};
+
 export const verify_vectors_from_url = async (vector_url) => {
 await load_wasm();
 const response = await fetch(vector_url);
@@ -39,32 +130,300 @@ const output = globalThis.verifyVectors(vector_body);
 return output;
 };
 
-export const dm_create_participant = async (name, seed_int) => {
+export const verify_vectors = async (vector_json) => {
+await load_wasm();
+return globalThis.verifyVectors(vector_json);
+};
+
+// input is one MLSWG vector file, or {crypto_basics, tree_math}, as a JSON
+// string or an object.
+export const verify_wg_vectors = async (input) => {
+await load_wasm();
+return globalThis.verifyWGVectors(input);
+};
+
+export const generate_vectors = async (name, iterations, opts = null) => {
+await load_wasm();
+if (opts) {
+return globalThis.generateVectors(name, iterations, opts);
+}
+return globalThis.generateVectors(name, iterations);
+};
+
+export const dm_create_participant = async (name, seed_int, cipher_suite = null) => {
 await load_wasm();
+if (cipher_suite) {
+return globalThis.dmCreateParticipant('', name, seed_int, cipher_suite);
+}
 return globalThis.dmCreateParticipant(name, seed_int);
 };
 
-export const dm_init = async (participant_b64, peer_keypackage_b64, group_id_b64, seed_int) => {
+export const dm_init = async (participant_b64, peer_keypackage_b64, group_id_b64, seed_int, cipher_suite = null) => {
+await load_wasm();
+return globalThis.dmInit(participant_b64, peer_keypackage_b64, group_id_b64, seed_int, cipher_suite);
+};
+
+export const dm_join = async (participant_b64, welcome_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmJoin(participant_b64, welcome_b64, group_id_b64);
+};
+
+export const dm_commit_apply = async (participant_b64, commit_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmCommitApply(participant_b64, commit_b64, group_id_b64);
+};
+
+export const dm_encrypt = async (participant_b64, plaintext, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmEncrypt(participant_b64, plaintext, group_id_b64);
+};
+
+export const dm_decrypt = async (participant_b64, ciphertext_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmDecrypt(participant_b64, ciphertext_b64, group_id_b64);
+};
+
+export const dm_encrypt_batch = async (participant_b64, plaintexts, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmEncryptBatch(participant_b64, plaintexts, group_id_b64);
+};
+
+export const dm_decrypt_batch = async (participant_b64, ciphertexts, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmDecryptBatch(participant_b64, ciphertexts, group_id_b64);
+};
+
+export const dm_encrypt_stream = async (participant_b64, data, group_id_b64 = null, chunk_size = null) => {
+await load_wasm();
+return globalThis.dmEncryptStream(participant_b64, data, group_id_b64, chunk_size);
+};
+
+export const dm_decrypt_stream = async (participant_b64, envelope_b64, ciphertext, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmDecryptStream(participant_b64, envelope_b64, ciphertext, group_id_b64);
+};
+
+export const dm_encrypt_stream_begin = async (participant_b64, size, group_id_b64 = null, chunk_size = null) => {
+await load_wasm();
+return globalThis.dmEncryptStreamBegin(participant_b64, size, group_id_b64, chunk_size);
+};
+
+export const dm_seal_stream_chunk = async (attachment, index, chunk) => {
+await load_wasm();
+return globalThis.dmSealStreamChunk(attachment, index, chunk);
+};
+
+export const dm_open_stream_chunk = async (attachment, index, chunk) => {
+await load_wasm();
+return globalThis.dmOpenStreamChunk(attachment, index, chunk);
+};
+
+export const dm_open_session = async (participant_b64) => {
+await load_wasm();
+return globalThis.dmOpenSession(participant_b64);
+};
+
+export const dm_session_encrypt = async (session, plaintext, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionEncrypt(session, plaintext, group_id_b64);
+};
+
+export const dm_session_encrypt_message = async (session, message, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionEncryptMessage(session, message, group_id_b64);
+};
+
+export const dm_session_decrypt = async (session, ciphertext_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionDecrypt(session, ciphertext_b64, group_id_b64);
+};
+
+export const dm_session_encrypt_batch = async (session, plaintexts, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionEncryptBatch(session, plaintexts, group_id_b64);
+};
+
+export const dm_session_decrypt_batch = async (session, ciphertexts, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionDecryptBatch(session, ciphertexts, group_id_b64);
+};
+
+export const dm_session_commit_apply = async (session, commit_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionCommitApply(session, commit_b64, group_id_b64);
+};
+
+export const dm_session_info = async (session, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSessionInfo(session, group_id_b64);
+};
+
+export const dm_session_save = async (session) => {
+await load_wasm();
+return globalThis.dmSessionSave(session);
+};
+
+export const dm_close_session = async (session) => {
+await load_wasm();
+return globalThis.dmCloseSession(session);
+};
+
+export const dm_info = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmInfo(participant_b64, group_id_b64);
+};
+
+export const dm_epoch = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmEpoch(participant_b64, group_id_b64);
+};
+
+export const dm_next_outgoing_generation = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmNextOutgoingGeneration(participant_b64, group_id_b64);
+};
+
+export const dm_last_decrypted_generation = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmLastDecryptedGeneration(participant_b64, group_id_b64);
+};
+
+export const dm_encrypt_message = async (participant_b64, message, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmEncryptMessage(participant_b64, message, group_id_b64);
+};
+
+export const dm_decrypt_message = async (participant_b64, ciphertext_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmDecryptMessage(participant_b64, ciphertext_b64, group_id_b64);
+};
+
+export const dm_encrypt_content = async (participant_b64, content, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmEncryptContent(participant_b64, content, group_id_b64);
+};
+
+export const dm_export_secret = async (participant_b64, label, context_b64, length, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmExportSecret(participant_b64, label, context_b64, length, group_id_b64);
+};
+
+export const dm_history_key = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmHistoryKey(participant_b64, group_id_b64);
+};
+
+export const dm_groups = async (participant_b64) => {
+await load_wasm();
+return globalThis.dmGroups(participant_b64);
+};
+
+export const dm_list_groups = async (participant_b64) => {
+await load_wasm();
+return globalThis.dmListGroups(participant_b64);
+};
+
+export const dm_remove = async (participant_b64, member, seed_int, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmRemove(participant_b64, member, seed_int, group_id_b64);
+};
+
+export const dm_update = async (participant_b64, seed_int, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmUpdate(participant_b64, seed_int, group_id_b64);
+};
+
+export const dm_leave = async (participant_b64, seed_int, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmLeave(participant_b64, seed_int, group_id_b64);
+};
+
+export const dm_pending_commits = async (participant_b64, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmPendingCommits(participant_b64, group_id_b64);
+};
+
+export const dm_discard_pending = async (participant_b64, commit_hash, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmDiscardPending(participant_b64, commit_hash, group_id_b64);
+};
+
+export const dm_set_retention = async (participant_b64, policy) => {
+await load_wasm();
+return globalThis.dmSetRetention(participant_b64, policy);
+};
+
+export const dm_set_padding = async (participant_b64, policy) => {
+await load_wasm();
+return globalThis.dmSetPadding(participant_b64, policy);
+};
+
+export const dm_set_rotation = async (participant_b64, policy) => {
+await load_wasm();
+return globalThis.dmSetRotation(participant_b64, policy);
+};
+
+export const dm_maybe_rotate = async (participant_b64, seed_int, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmMaybeRotate(participant_b64, seed_int, group_id_b64);
+};
+
+export const dm_set_admins = async (participant_b64, admins, group_id_b64 = null) => {
+await load_wasm();
+return globalThis.dmSetAdmins(participant_b64, admins, group_id_b64);
+};
+
+export const dm_keypackage_pool = async (participant_b64, count, seed_int) => {
+await load_wasm();
+return globalThis.dmKeyPackagePool(participant_b64, count, seed_int);
+};
+
+export const dm_split_welcome = async (welcome_b64) => {
+await load_wasm();
+return globalThis.dmSplitWelcome(welcome_b64);
+};
+
+export const dm_keypackage_hash = async (keypackage_b64) => {
+await load_wasm();
+return globalThis.dmKeyPackageHash(keypackage_b64);
+};
+
+export const dm_validate_keypackage = async (keypackage_b64) => {
+await load_wasm();
+return globalThis.dmValidateKeyPackage(keypackage_b64);
+};
+
+export const dm_inspect_artifact = async (artifact_b64) => {
+await load_wasm();
+return globalThis.dmInspectArtifact(artifact_b64);
+};
+
+export const dm_derive_group_id = async (participant_b64, nonce_b64) => {
+await load_wasm();
+return globalThis.dmDeriveGroupID(participant_b64, nonce_b64);
+};
+
+export const dm_identity_message = async (participant_b64) => {
 await load_wasm();
-return globalThis.dmInit(participant_b64, peer_keypackage_b64, group_id_b64, seed_int);
+return globalThis.dmIdentityMessage(participant_b64);
 };
 
-export const dm_join = async (participant_b64, welcome_b64) => {
+export const dm_bind_identity = async (participant_b64, user_id, signature_b64) => {
 await load_wasm();
-return globalThis.dmJoin(participant_b64, welcome_b64);
+return globalThis.dmBindIdentity(participant_b64, user_id, signature_b64);
 };
 
-export const dm_commit_apply = async (participant_b64, commit_b64) => {
+export const dm_set_state_key = async (key) => {
 await load_wasm();
-return globalThis.dmCommitApply(participant_b64, commit_b64);
+return globalThis.dmSetStateKey(key);
 };
 
-export const dm_encrypt = async (participant_b64, plaintext) => {
+export const dm_destroy = async (participant_b64) => {
 await load_wasm();
-return globalThis.dmEncrypt(participant_b64, plaintext);
+return globalThis.dmDestroy(participant_b64);
 };
 
-export const dm_decrypt = async (participant_b64, ciphertext_b64) => {
+export const dm_set_artifact_envelopes = async (enabled) => {
 await load_wasm();
-return globalThis.dmDecrypt(participant_b64, ciphertext_b64);
+return globalThis.dmSetArtifactEnvelopes(enabled);
 };
@@ -0,0 +1,50 @@
+// Classic Web Worker that runs the MLS WASM module off the page's thread.
+// The page posts {id, name, args} and gets back {id, result} or {id, error}.
+// name is a module binding such as dmEncrypt or dmEncryptBytes; the worker
+// awaits it and transfers every Uint8Array in the result, so Bytes bindings
+// hand participant state to the page without a copy. Start it through
+// create_mls_worker in mls_vectors_loader.js.
+importScripts('vendor/wasm_exec.js');
+
+const wasm_path = 'vendor/mls_harness.wasm';
+const binding_pattern = /^(dm|group|mls|syncDm|syncGroup)[A-Za-z0-9]*$|^verifyVectors$/;
+
+const wasm_ready = (async () => {
+const go = new Go();
+const response = await fetch(wasm_path);
+if (!response.ok) {
+throw new Error(`WASM module not built (status ${response.status})`);
+}
+const result = await WebAssembly.instantiate(await response.arrayBuffer(), go.importObject);
+go.run(result.instance);
+})();
+
+const collect_transfers = (value, transfers) => {
+if (value instanceof Uint8Array) {
+if (value.byteOffset === 0 && value.byteLength === value.buffer.byteLength) {
+transfers.add(value.buffer);
+}
+return;
+}
+if (value && typeof value === 'object') {
+for (const entry of Object.values(value)) {
+collect_transfers(entry, transfers);
+}
+}
+};
+
+self.onmessage = async (event) => {
+const { id, name, args } = event.data || {};
+try {
+await wasm_ready;
+if (typeof name !== 'string' || !binding_pattern.test(name) || typeof self[name] !== 'function') {
+throw new Error(`unknown binding ${name}`);
+}
+const result = await self[name](...(Array.isArray(args) ? args : []));
+const transfers = new Set();
+collect_transfers(result, transfers);
+self.postMessage({ id, result }, [...transfers]);
+} catch (error) {
+self.postMessage({ id, error: error instanceof Error ? error.message : String(error) });
+}
+};
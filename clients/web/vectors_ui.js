@@ -1,10 +1,14 @@
-import { verify_vectors_from_url } from './mls_vectors_loader.js';
+import { generate_vectors, verify_vectors, verify_vectors_from_url } from './mls_vectors_loader.js';
 
 const run_vectors_btn = document.getElementById('run_vectors');
 const vector_status = document.getElementById('vector_status');
 const vector_output = document.getElementById('vector_output');
+const generate_vector_btn = document.getElementById('generate_vector');
+const generate_vector_name = document.getElementById('generate_vector_name');
+const generate_vector_iterations = document.getElementById('generate_vector_iterations');
+const generate_vector_download = document.getElementById('generate_vector_download');
 const vector_path = 'vectors/dm_smoke_v1.json';
-const room_vector_path = 'vectors/room_seeded_bootstrap_v1.json';
+const room_vector_path = 'vectors/room_seeded_bootstrap_v2.json';
 
 const bytes_to_base64 = (bytes) => {
 let binary = '';
@@ -136,20 +140,20 @@ if (!group_id_b64 || typeof group_id_b64 !== 'string') {
 return { ok: false, error: 'room vector missing group_id_b64' };
 }
 const owner = require_ok(
-dm_create_participant('owner', seeds.owner_keypackage_seed),
+await dm_create_participant('owner', seeds.owner_keypackage_seed),
 'owner keypackage'
 );
 const peer_one = require_ok(
-dm_create_participant('peer_one', seeds.peer_one_keypackage_seed),
+await dm_create_participant('peer_one', seeds.peer_one_keypackage_seed),
 'peer_one keypackage'
 );
 const peer_two = require_ok(
-dm_create_participant('peer_two', seeds.peer_two_keypackage_seed),
+await dm_create_participant('peer_two', seeds.peer_two_keypackage_seed),
 'peer_two keypackage'
 );
 
 const init_result = require_ok(
-group_init(
+await group_init(
 owner.participant_b64,
 [peer_one.keypackage_b64, peer_two.keypackage_b64],
 group_id_b64,
@@ -164,30 +168,30 @@ compare_env(get_event_by_seq(room_vector.events, 1), init_welcome_env_b64, 'init
 compare_env(get_event_by_seq(room_vector.events, 2), init_commit_env_b64, 'init commit');
 
 const peer_one_join = require_ok(
-dm_join(peer_one.participant_b64, init_result.welcome_b64),
+await dm_join(peer_one.participant_b64, init_result.welcome_b64),
 'peer_one join'
 );
 const peer_two_join = require_ok(
-dm_join(peer_two.participant_b64, init_result.welcome_b64),
+await dm_join(peer_two.participant_b64, init_result.welcome_b64),
 'peer_two join'
 );
 
 const owner_commit = require_ok(
-dm_commit_apply(init_result.participant_b64, init_result.commit_b64),
+await dm_commit_apply(init_result.participant_b64, init_result.commit_b64),
 'owner commit apply'
 );
 const peer_one_commit = require_ok(
-dm_commit_apply(peer_one_join.participant_b64, init_result.commit_b64),
+await dm_commit_apply(peer_one_join.participant_b64, init_result.commit_b64),
 'peer_one commit apply'
 );
 const peer_two_commit = require_ok(
-dm_commit_apply(peer_two_join.participant_b64, init_result.commit_b64),
+await dm_commit_apply(peer_two_join.participant_b64, init_result.commit_b64),
 'peer_two commit apply'
 );
 
 const app_event = check_event_kind(get_event_by_seq(room_vector.events, 3), 3, 'app');
 const app_decrypt = require_ok(
-dm_decrypt(owner_commit.participant_b64, app_event.payload_b64),
+await dm_decrypt(owner_commit.participant_b64, app_event.payload_b64),
 'app decrypt'
 );
 if (app_decrypt.plaintext !== room_vector.app_plaintext) {
@@ -195,12 +199,12 @@ return { ok: false, error: 'app plaintext mismatch' };
 }
 
 const peer_two_rotate = require_ok(
-dm_create_participant(peer_two_commit.participant_b64, 'peer_two', seeds.peer_two_add_keypackage_seed),
+await dm_create_participant(peer_two_commit.participant_b64, 'peer_two', seeds.peer_two_add_keypackage_seed),
 'peer_two rotate'
 );
 
 const add_result = require_ok(
-group_add(owner_commit.participant_b64, [peer_two_rotate.keypackage_b64], seeds.group_add_seed),
+await group_add(owner_commit.participant_b64, [peer_two_rotate.keypackage_b64], seeds.group_add_seed),
 'group add'
 );
 
@@ -216,6 +220,21 @@ compare_env(add_commit_event, add_commit_env_b64, 'add commit');
 return { ok: true };
 };
 
+// The room vector was recorded with seeded calls overriding crypto/rand, so
+// its Welcomes and Commits only replay byte for byte in that mode.
+const run_room_replay_legacy_rand = async () => {
+const set_legacy_seeded_rand = window.dmSetLegacySeededRand;
+if (typeof set_legacy_seeded_rand !== 'function') {
+return { ok: false, error: 'wasm exports missing' };
+}
+await set_legacy_seeded_rand(true);
+try {
+return await run_room_replay();
+} finally {
+await set_legacy_seeded_rand(false);
+}
+};
+
 const render_result = (result) => {
 if (!result) {
 vector_status.textContent = 'failed';
@@ -237,7 +256,7 @@ vector_status.textContent = 'running...';
 vector_output.textContent = '';
 try {
 const dm_result = await verify_vectors_from_url(vector_path);
-const room_result = await run_room_replay();
+const room_result = await run_room_replay_legacy_rand();
 const dm_status = dm_result && dm_result.ok ? 'ok' : 'failed';
 const room_status = room_result && room_result.ok ? 'ok' : 'failed';
 const summary_lines = [];
@@ -266,3 +285,42 @@ vector_output.textContent = `error=${message}`;
 if (run_vectors_btn) {
 run_vectors_btn.addEventListener('click', handle_run_vectors);
 }
+
+// Mints a vector in the browser, checks it round-trips through verifyVectors,
+// and offers the JSON for download so it can be committed as a regression
+// capture.
+const handle_generate_vector = async () => {
+vector_status.textContent = 'generating...';
+vector_output.textContent = '';
+generate_vector_download.hidden = true;
+try {
+const name = generate_vector_name.value.trim();
+const iterations = Number.parseInt(generate_vector_iterations.value, 10);
+const generated = await generate_vectors(name, iterations);
+if (!generated || !generated.ok) {
+render_result({ ok: false, error: generated ? generated.error : 'empty result' });
+return;
+}
+const verified = await verify_vectors(generated.vector_json);
+const verified_status = verified && verified.ok ? 'ok' : 'failed';
+render_result({
+ok: Boolean(verified && verified.ok),
+digest: generated.digest,
+summary: `generated=${name} digest=${generated.digest} round_trip=${verified_status}\n${generated.vector_json}`
+});
+if (generate_vector_download.href) {
+URL.revokeObjectURL(generate_vector_download.href);
+}
+generate_vector_download.href = URL.createObjectURL(new Blob([generated.vector_json], { type: 'application/json' }));
+generate_vector_download.download = `${name}.json`;
+generate_vector_download.hidden = false;
+} catch (err) {
+vector_status.textContent = 'failed';
+const message = err && err.message ? err.message : String(err);
+vector_output.textContent = `error=${message}`;
+}
+};
+
+if (generate_vector_btn) {
+generate_vector_btn.addEventListener('click', handle_generate_vector);
+}
@@ -0,0 +1,70 @@
+import hashlib
+import json
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+SUITES = "X25519_AES128GCM_SHA256_Ed25519,X25519_CHACHA20POLY1305_SHA256_Ed25519"
+
+
+class TestMLSHarnessCorpus(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def _run(self, args):
+        return run_harness(
+            args,
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+
+    def test_corpus_manifest_matches_samples_and_verifies(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            out = Path(tmp) / "corpus"
+            proc = self._run(["corpus", "--out", str(out), "--suites", SUITES])
+            self.assertEqual(proc.returncode, 0, msg=f"stdout:\n{proc.stdout}\nstderr:\n{proc.stderr}")
+
+            manifest = json.loads((out / "manifest.json").read_text())
+            self.assertEqual(manifest["format_version"], 1)
+            samples = manifest["samples"]
+            self.assertEqual({s["cipher_suite"] for s in samples}, set(SUITES.split(",")))
+            self.assertEqual(
+                {s["kind"] for s in samples},
+                {"keypackage", "welcome", "proposal", "commit", "ciphertext"},
+            )
+            for sample in samples:
+                data = (out / sample["path"]).read_bytes()
+                self.assertEqual(len(data), sample["bytes"])
+                self.assertEqual(hashlib.sha256(data).hexdigest(), sample["sha256_hex"])
+
+            again = Path(tmp) / "again"
+            proc = self._run(["corpus", "--out", str(again), "--suites", SUITES])
+            self.assertEqual(proc.returncode, 0, msg=proc.stderr)
+            self.assertEqual((again / "manifest.json").read_bytes(), (out / "manifest.json").read_bytes())
+
+            proc = self._run(["corpus", "--verify", str(out)])
+            self.assertEqual(proc.returncode, 0, msg=f"stdout:\n{proc.stdout}\nstderr:\n{proc.stderr}")
+            self.assertIn(f"corpus: PASS (samples={len(samples)}", proc.stdout)
+
+            proc = self._run(["fuzz", "--corpus", str(out), "--iterations", "200", "--crash-dir", str(Path(tmp) / "crashes")])
+            self.assertEqual(proc.returncode, 0, msg=f"stdout:\n{proc.stdout}\nstderr:\n{proc.stderr}")
+
+            tampered = out / samples[0]["path"]
+            tampered.write_bytes(tampered.read_bytes() + b"\x00")
+            proc = self._run(["corpus", "--verify", str(out)])
+            self.assertEqual(proc.returncode, 1)
+            self.assertIn("hash does not match the manifest", proc.stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
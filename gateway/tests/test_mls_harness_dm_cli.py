@@ -0,0 +1,1132 @@
+import base64
+import hashlib
+import json
+import shutil
+import subprocess
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+from typing import Dict, Sequence
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+
+class TestMLSHarnessDMCli(unittest.TestCase):
+    """Group lifecycle through the dm-* commands, without a gateway in between."""
+
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def setUp(self) -> None:
+        self._tmp = tempfile.TemporaryDirectory()
+        self.addCleanup(self._tmp.cleanup)
+
+    def _invoke(self, args: Sequence[str], **env: str):
+        return run_harness(
+            args,
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env={**make_harness_env(), **env},
+            timeout_s=60.0,
+        )
+
+    def _run(self, args: Sequence[str]) -> str:
+        proc = self._invoke(args)
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness {args[0]} failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        return proc.stdout.strip()
+
+    def _group(self, *names: str) -> Dict[str, str]:
+        """Creates a group owned by the first name with every other name joined."""
+        dirs = {name: str(Path(self._tmp.name) / name) for name in names}
+        kps = {}
+        for seed, name in enumerate(names, start=1):
+            kps[name] = self._run(["dm-keypackage", "--state-dir", dirs[name], "--name", name, "--seed", str(seed)])
+        owner, *members = names
+        args = ["group-init" if len(members) > 1 else "dm-init", "--state-dir", dirs[owner]]
+        for name in members:
+            args += ["--peer-keypackage", kps[name]]
+        init = json.loads(self._run(args))
+        self._run(["dm-commit-apply", "--state-dir", dirs[owner], "--commit", init["commit"]])
+        for name in members:
+            self._run(["dm-join", "--state-dir", dirs[name], "--welcome", init["welcome"]])
+        return dirs
+
+    def _assert_reads(self, sender_dir: str, receiver_dir: str, plaintext: str) -> None:
+        ct = self._run(["dm-encrypt", "--state-dir", sender_dir, "--plaintext", plaintext])
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", receiver_dir, "--ciphertext", ct]), plaintext)
+
+    def test_remove_member(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+
+        removed = json.loads(self._run(["dm-remove", "--state-dir", dirs["alice"], "--member", "carol"]))
+        self.assertEqual(len(removed["proposals"]), 1)
+        for name in ("bob", "carol"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", removed["proposals"][0]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", removed["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", removed["commit"]])
+
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["carol"], "--commit", removed["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("removed from group", proc.stderr)
+
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-remove")
+        self._assert_reads(dirs["bob"], dirs["alice"], "reply")
+
+    def test_messages_from_removed_member_are_refused(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        self._run(["dm-retention", "--state-dir", dirs["bob"], "--max-past-epochs", "1"])
+        late = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "sent-before-remove"])
+
+        removed = json.loads(self._run(["dm-remove", "--state-dir", dirs["alice"], "--member", "carol"]))
+        self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", removed["proposals"][0]])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", removed["commit"]])
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["carol"], "--commit", removed["commit"]])
+        self.assertEqual(proc.returncode, 1)
+
+        # Carol still holds the epoch bob retains, and keeps writing into it.
+        kicked = self._run(["dm-encrypt", "--state-dir", dirs["carol"], "--plaintext", "after-remove"])
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", kicked])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=sender_removed", proc.stderr)
+        self.assertNotIn("after-remove", proc.stdout + proc.stderr)
+
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", late]), "sent-before-remove")
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-remove")
+
+    def test_admins_gate_adds_and_removes(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        kick = json.loads(self._run(["dm-propose-remove", "--state-dir", dirs["bob"], "--member", "carol"]))
+        self._run(["dm-handle-proposal", "--state-dir", dirs["alice"], "--proposal", kick["proposal"]])
+
+        for name in ("alice", "carol"):
+            admins = json.loads(self._run(["dm-admins", "--state-dir", dirs[name], "--admins", "alice,alice"]))
+            self.assertEqual(admins, {"admins": ["alice"]})
+        self.assertEqual(json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))["admins"], ["alice"])
+
+        proc = self._invoke(["dm-handle-proposal", "--state-dir", dirs["carol"], "--proposal", kick["proposal"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=not_admin", proc.stderr)
+        # Alice queued the proposal before naming admins; the commit covering it
+        # is checked again.
+        committed = json.loads(self._run(["dm-commit-pending", "--state-dir", dirs["bob"]]))
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", committed["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=not_admin", proc.stderr)
+        self._run(["dm-pending-discard", "--state-dir", dirs["bob"]])
+
+        self._run(["dm-admins", "--state-dir", dirs["bob"], "--admins", "alice"])
+        proc = self._invoke(["dm-remove", "--state-dir", dirs["bob"], "--member", "carol"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=not_admin", proc.stderr)
+
+        # Leaving needs no admin.
+        left = json.loads(self._run(["dm-leave", "--state-dir", dirs["carol"]]))
+        self._run(["dm-handle-proposal", "--state-dir", dirs["alice"], "--proposal", left["proposal"]])
+        self._assert_reads(dirs["alice"], dirs["bob"], "admins-set")
+
+    def test_update_rotates_leaf(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        stale = str(Path(self._tmp.name) / "bob-before-update")
+        shutil.copytree(dirs["bob"], stale)
+
+        updated = json.loads(self._run(["dm-update", "--state-dir", dirs["bob"], "--seed", "99"]))
+        self.assertEqual(len(updated["proposals"]), 1)
+        for name in ("alice", "carol"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", updated["proposals"][0]])
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", updated["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", updated["commit"]])
+
+        update = json.loads(self._run(["inspect", "--type", "plaintext", "--value", updated["proposals"][0]]))
+        self.assertEqual(update["proposal"]["type"], "update")
+
+        self._assert_reads(dirs["bob"], dirs["alice"], "after-update")
+        self._assert_reads(dirs["carol"], dirs["bob"], "to-bob")
+        ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "not-for-stale"])
+        proc = self._invoke(["dm-decrypt", "--state-dir", stale, "--ciphertext", ct])
+        self.assertNotEqual(proc.returncode, 0)
+
+    def test_rotation_policy_triggers_update(self) -> None:
+        dirs = self._group("alice", "bob")
+        policy = json.loads(self._run(["dm-rotation", "--state-dir", dirs["bob"], "--max-messages", "2"]))
+        self.assertEqual(policy, {"max_messages": 2, "max_epochs": 0})
+
+        self._assert_reads(dirs["bob"], dirs["alice"], "first")
+        idle = json.loads(self._run(["dm-maybe-rotate", "--state-dir", dirs["bob"]]))
+        self.assertEqual(idle, {"rotated": False, "commit": "", "proposals": []})
+        self._assert_reads(dirs["bob"], dirs["alice"], "second")
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))
+        self.assertEqual(info["sent_since_rotation"], 2)
+
+        rotated = json.loads(self._run(["dm-maybe-rotate", "--state-dir", dirs["bob"], "--seed", "42"]))
+        self.assertTrue(rotated["rotated"])
+        self.assertEqual(len(rotated["proposals"]), 1)
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", rotated["proposals"][0]])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", rotated["commit"]])
+
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))
+        self.assertEqual(info["sent_since_rotation"], 0)
+        self.assertEqual(info["rotated_epoch"], info["epoch"])
+        self.assertEqual(info["rotation"], {"max_messages": 2, "max_epochs": 0})
+        self.assertFalse(json.loads(self._run(["dm-maybe-rotate", "--state-dir", dirs["bob"]]))["rotated"])
+        self._assert_reads(dirs["bob"], dirs["alice"], "after-rotation")
+
+    def test_destroy_tombstones_participant(self) -> None:
+        dirs = self._group("alice", "bob")
+        self._run(["dm-destroy", "--state-dir", dirs["bob"]])
+        tombstone = base64.b64decode((Path(dirs["bob"]) / "participant.gob").read_text().strip())
+        self.assertEqual(tombstone, b"MLSD\x01")
+
+        for args in (["dm-encrypt", "--plaintext", "after-destroy"], ["dm-info"]):
+            proc = self._invoke([args[0], "--state-dir", dirs["bob"], *args[1:]])
+            self.assertEqual(proc.returncode, 1)
+            self.assertIn("code=destroyed", proc.stderr)
+        # Destroying again is harmless, so a panic-delete flow can retry.
+        self._run(["dm-destroy", "--state-dir", dirs["bob"]])
+        self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "still-usable"])
+
+    def test_leave_group(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        before = self._run(["dm-encrypt", "--state-dir", dirs["carol"], "--plaintext", "before-leave"])
+
+        left = json.loads(self._run(["dm-leave", "--state-dir", dirs["carol"], "--seed", "21"]))
+        self.assertEqual(json.loads(self._run(["dm-leave", "--state-dir", dirs["carol"]])), left)
+        proposal = json.loads(self._run(["inspect", "--type", "plaintext", "--value", left["proposal"]]))
+        self.assertEqual(proposal["proposal"]["type"], "remove")
+        self.assertTrue(json.loads(self._run(["dm-info", "--state-dir", dirs["carol"]]))["left"])
+
+        proc = self._invoke(["dm-encrypt", "--state-dir", dirs["carol"], "--plaintext", "after-leave"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("has left the group", proc.stderr)
+        self._assert_reads(dirs["alice"], dirs["carol"], "still-readable")
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", before]), "before-leave")
+
+        for name in ("alice", "bob"):
+            self._run(["dm-handle-proposal", "--state-dir", dirs[name], "--proposal", left["proposal"]])
+        committed = json.loads(self._run(["dm-commit-pending", "--state-dir", dirs["bob"], "--seed", "22"]))
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", committed["commit"]])
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["carol"], "--commit", committed["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("removed from group", proc.stderr)
+
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))
+        self.assertEqual([member["identity"] for member in info["members"]], ["alice", "bob"])
+        self._assert_reads(dirs["bob"], dirs["alice"], "after-leave")
+
+    def test_commit_batches_proposals_from_several_members(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        dirs["dave"] = str(Path(self._tmp.name) / "dave")
+        dave_kp = self._run(["dm-keypackage", "--state-dir", dirs["dave"], "--name", "dave", "--seed", "4"])
+
+        # Proposals reach every other member in delivery-service order.
+        sent = [
+            ("alice", json.loads(self._run(["dm-propose-add", "--state-dir", dirs["alice"], "--peer-keypackage", dave_kp]))),
+            ("bob", json.loads(self._run(["dm-propose-update", "--state-dir", dirs["bob"], "--seed", "11"]))),
+        ]
+        for author, proposal in sent:
+            for name in ("alice", "bob", "carol"):
+                if name != author:
+                    self._run(["dm-handle-proposal", "--state-dir", dirs[name], "--proposal", proposal["proposal"]])
+
+        committed = json.loads(self._run(["dm-commit-pending", "--state-dir", dirs["carol"], "--seed", "12"]))
+        commit = json.loads(self._run(["inspect", "--type", "plaintext", "--value", committed["commit"]]))
+        self.assertEqual(len(commit["commit"]["adds"]), 1)
+        self.assertEqual(len(commit["commit"]["updates"]), 1)
+        for name in ("alice", "bob", "carol"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", committed["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["dave"], "--welcome", committed["welcome"]])
+
+        self._assert_reads(dirs["bob"], dirs["dave"], "bob-to-dave")
+        self._assert_reads(dirs["dave"], dirs["alice"], "dave-to-alice")
+
+        proc = self._invoke(["dm-commit-pending", "--state-dir", dirs["alice"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("no pending proposals", proc.stderr)
+
+    def test_handle_proposal_while_own_commit_pending(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+
+        alice_update = json.loads(self._run(["dm-update", "--state-dir", dirs["alice"], "--seed", "21"]))
+        bob_update = json.loads(self._run(["dm-propose-update", "--state-dir", dirs["bob"], "--seed", "22"]))
+        handled = json.loads(self._run(["dm-handle-proposal", "--state-dir", dirs["alice"], "--proposal", bob_update["proposal"]]))
+        self.assertFalse(handled["noop"])
+
+        # The delivery service orders alice's commit first; bob's proposal is superseded.
+        for name in ("bob", "carol"):
+            self._run(["dm-handle-proposal", "--state-dir", dirs[name], "--proposal", alice_update["proposals"][0]])
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", alice_update["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", alice_update["commit"]])
+
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-race")
+        self._assert_reads(dirs["carol"], dirs["alice"], "reply")
+
+    def test_handle_proposal_echo_is_noop(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+
+        proposal = json.loads(self._run(["dm-propose-remove", "--state-dir", dirs["alice"], "--member", "carol"]))["proposal"]
+        echo = json.loads(self._run(["dm-handle-proposal", "--state-dir", dirs["alice"], "--proposal", proposal]))
+        self.assertTrue(echo["noop"])
+        first = json.loads(self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", proposal]))
+        self.assertFalse(first["noop"])
+        again = json.loads(self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", proposal]))
+        self.assertTrue(again["noop"])
+
+        committed = json.loads(self._run(["dm-commit-pending", "--state-dir", dirs["bob"]]))
+        commit = json.loads(self._run(["inspect", "--type", "plaintext", "--value", committed["commit"]]))
+        self.assertEqual(len(commit["commit"]["removes"]), 1)
+
+    def test_handle_proposal_rejects_commit(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+
+        updated = json.loads(self._run(["dm-update", "--state-dir", dirs["alice"]]))
+        proc = self._invoke(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", updated["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("not a proposal", proc.stderr)
+
+    def test_one_participant_in_two_groups(self) -> None:
+        dirs = self._group("alice", "bob")
+        dirs["carol"] = str(Path(self._tmp.name) / "carol")
+        carol_kp = self._run(["dm-keypackage", "--state-dir", dirs["carol"], "--name", "carol", "--seed", "3"])
+        dm_group = json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))["group_id"]
+        team_group = base64.b64encode(b"team").decode()
+
+        init = json.loads(self._run([
+            "dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", carol_kp,
+            "--group-id", team_group, "--seed", "21",
+        ]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]])
+        proc = self._invoke(["dm-join", "--state-dir", dirs["carol"], "--welcome", init["welcome"], "--group-id", dm_group])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("welcome is for group", proc.stderr)
+        self._run(["dm-join", "--state-dir", dirs["carol"], "--welcome", init["welcome"], "--group-id", team_group])
+
+        groups = json.loads(self._run(["dm-groups", "--state-dir", dirs["alice"]]))["groups"]
+        self.assertEqual(groups, sorted([dm_group, team_group]))
+        proc = self._invoke(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "which group?"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("pass a group id", proc.stderr)
+
+        # Ciphertexts name their group, so the receiver needs no --group-id.
+        for group, peer, plaintext in ((dm_group, "bob", "to-bob"), (team_group, "carol", "to-carol")):
+            ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--group-id", group, "--plaintext", plaintext])
+            self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs[peer], "--ciphertext", ct]), plaintext)
+            reply = self._run(["dm-encrypt", "--state-dir", dirs[peer], "--plaintext", "reply-" + peer])
+            self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["alice"], "--ciphertext", reply]), "reply-" + peer)
+
+        ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--group-id", team_group, "--plaintext", "team only"])
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["alice"], "--group-id", dm_group, "--ciphertext", ct])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("message is for group", proc.stderr)
+
+        team = json.loads(self._run(["dm-info", "--state-dir", dirs["carol"]]))
+        self.assertEqual(team["group_id"], team_group)
+        self.assertEqual(team["joined_epoch"], 1)
+        self.assertEqual([m["identity"] for m in team["members"]], ["alice", "carol"])
+
+    def test_content_addressed_group_ids(self) -> None:
+        alice = str(Path(self._tmp.name) / "alice")
+        bob = str(Path(self._tmp.name) / "bob")
+        self._run(["dm-keypackage", "--state-dir", alice, "--name", "alice", "--seed", "1"])
+        bob_kp = self._run(["dm-keypackage", "--state-dir", bob, "--name", "bob", "--seed", "2"])
+        nonce = base64.b64encode(bytes(range(16))).decode()
+
+        def group_id(state_dir: str, nonce: str) -> str:
+            return json.loads(self._run(["dm-group-id", "--state-dir", state_dir, "--nonce", nonce]))["group_id"]
+
+        gid = group_id(alice, nonce)
+        self.assertEqual(len(base64.b64decode(gid)), 32)
+        self.assertEqual(group_id(alice, nonce), gid)
+        self.assertNotEqual(group_id(bob, nonce), gid)
+        self.assertNotEqual(group_id(alice, base64.b64encode(bytes(range(1, 17))).decode()), gid)
+        fresh = json.loads(self._run(["dm-group-id", "--state-dir", alice]))
+        self.assertEqual(group_id(alice, fresh["nonce"]), fresh["group_id"])
+        proc = self._invoke(["dm-group-id", "--state-dir", alice, "--nonce", "c2hvcnQ="])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("nonce must be between 16 and 255 bytes", proc.stderr)
+
+        for bad in ("", "QUJD\n", "QUI=x", base64.b64encode(bytes(256)).decode()):
+            proc = self._invoke(["dm-init", "--state-dir", alice, "--peer-keypackage", bob_kp, "--group-id", bad])
+            self.assertEqual(proc.returncode, 1, bad)
+            self.assertIn("code=bad_group_id", proc.stderr)
+
+        init = json.loads(self._run(["dm-init", "--state-dir", alice, "--peer-keypackage", bob_kp, "--group-id", gid]))
+        self._run(["dm-commit-apply", "--state-dir", alice, "--commit", init["commit"]])
+        proc = self._invoke(["dm-join", "--state-dir", bob, "--group-id", "QUJD\n", "--welcome", init["welcome"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=bad_group_id", proc.stderr)
+        self._run(["dm-join", "--state-dir", bob, "--group-id", gid, "--welcome", init["welcome"]])
+        self.assertEqual(json.loads(self._run(["dm-groups", "--state-dir", bob])), {"groups": [gid]})
+
+    def test_split_welcome_per_new_member(self) -> None:
+        dirs = self._group("alice", "bob")
+        kps = {}
+        for seed, name in ((3, "carol"), (4, "dave")):
+            dirs[name] = str(Path(self._tmp.name) / name)
+            kps[name] = self._run(["dm-keypackage", "--state-dir", dirs[name], "--name", name, "--seed", str(seed)])
+        added = json.loads(
+            self._run(["group-add", "--state-dir", dirs["alice"], "--peer-keypackage", kps["carol"], "--peer-keypackage", kps["dave"]])
+        )
+        split = json.loads(self._run(["dm-split-welcome", "--welcome", added["welcome"]]))["welcomes"]
+        hashes = {name: self._run(["dm-keypackage-hash", "--keypackage", kps[name]]) for name in ("carol", "dave")}
+        self.assertEqual(set(split), set(hashes.values()))
+        for welcome in split.values():
+            self.assertLess(len(welcome), len(added["welcome"]))
+
+        proc = self._invoke(["dm-join", "--state-dir", dirs["dave"], "--welcome", split[hashes["carol"]]])
+        self.assertEqual(proc.returncode, 1)
+        for proposal in added["proposals"]:
+            self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", proposal])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", added["commit"]])
+        for name in ("carol", "dave"):
+            self._run(["dm-join", "--state-dir", dirs[name], "--welcome", split[hashes[name]]])
+        self._assert_reads(dirs["carol"], dirs["dave"], "split welcomes")
+
+    def test_second_add_queues_behind_pending_commit(self) -> None:
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "bob", "carol")}
+        kps = {
+            name: self._run(["dm-keypackage", "--state-dir", dirs[name], "--name", name, "--seed", str(seed)])
+            for seed, name in enumerate(dirs, start=1)
+        }
+        first = json.loads(self._run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", kps["bob"]]))
+        second = json.loads(self._run(["group-add", "--state-dir", dirs["alice"], "--peer-keypackage", kps["carol"]]))
+
+        pending = json.loads(self._run(["dm-pending", "--state-dir", dirs["alice"]]))["pending"]
+        self.assertEqual([entry["epoch"] for entry in pending], [0, 1])
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", second["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("must be applied first", proc.stderr)
+
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", first["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", first["welcome"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", second["proposals"][0]])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", second["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["carol"], "--welcome", second["welcome"]])
+
+        self.assertEqual(json.loads(self._run(["dm-pending", "--state-dir", dirs["alice"]]))["pending"], [])
+        self._assert_reads(dirs["alice"], dirs["carol"], "to-carol")
+        self._assert_reads(dirs["carol"], dirs["bob"], "to-bob")
+
+    def test_conflicting_commit_needs_discard(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        self._run(["dm-update", "--state-dir", dirs["alice"], "--seed", "31"])
+        bob = json.loads(self._run(["dm-update", "--state-dir", dirs["bob"], "--seed", "32"]))
+
+        # The delivery service orders bob's commit first.
+        for name in ("alice", "carol"):
+            self._run(["dm-handle-proposal", "--state-dir", dirs[name], "--proposal", bob["proposals"][0]])
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", bob["commit"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("conflicts with a pending commit", proc.stderr)
+
+        pending = json.loads(self._run(["dm-pending", "--state-dir", dirs["alice"]]))["pending"]
+        self.assertEqual(len(pending), 1)
+        discarded = self._run(["dm-pending-discard", "--state-dir", dirs["alice"], "--commit-hash", pending[0]["commit_hash"]])
+        self.assertEqual(json.loads(discarded), {"discarded": 1})
+        for name in ("alice", "bob", "carol"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", bob["commit"]])
+        self._assert_reads(dirs["alice"], dirs["carol"], "after-race")
+        self._assert_reads(dirs["bob"], dirs["alice"], "from-winner")
+
+    def test_reflected_commit_with_different_framing(self) -> None:
+        dirs = self._group("alice", "bob")
+        updated = json.loads(self._run(["dm-update", "--state-dir", dirs["alice"], "--seed", "33"]))
+        self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", updated["proposals"][0]])
+        commit = updated["commit"]
+        # A relay that pads what it forwards changes the bytes but not the commit.
+        reframed = base64.b64encode(base64.b64decode(commit) + b"\x00relay").decode()
+        self.assertNotEqual(reframed, commit)
+
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", reframed])
+        self.assertEqual(json.loads(self._run(["dm-pending", "--state-dir", dirs["alice"]]))["pending"], [])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", commit])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", commit])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", reframed])
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-reframe")
+
+    def test_history_key_shared_with_new_member(self) -> None:
+        dirs = self._group("alice", "bob")
+        dirs["carol"] = str(Path(self._tmp.name) / "carol")
+        carol_kp = self._run(["dm-keypackage", "--state-dir", dirs["carol"], "--name", "carol", "--seed", "3"])
+        added = json.loads(self._run(["group-add", "--state-dir", dirs["alice"], "--peer-keypackage", carol_kp]))
+        self._run(["dm-join", "--state-dir", dirs["carol"], "--welcome", added["welcome"]])
+        for proposal in added["proposals"]:
+            self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", proposal])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", added["commit"]])
+
+        keys = {name: json.loads(self._run(["dm-history-key", "--state-dir", dirs[name]])) for name in dirs}
+        self.assertEqual(keys["carol"], keys["alice"])
+        self.assertEqual(keys["carol"], keys["bob"])
+        self.assertEqual(keys["carol"]["epoch"], 2)
+        self.assertEqual(len(base64.b64decode(keys["carol"]["key"])), 32)
+
+        export = ["dm-export-secret", "--label", "app", "--context", base64.b64encode(b"ctx").decode(), "--length", "48"]
+        secrets = {name: json.loads(self._run(export + ["--state-dir", dirs[name]])) for name in dirs}
+        self.assertEqual(secrets["alice"], secrets["carol"])
+        self.assertEqual(len(base64.b64decode(secrets["alice"]["secret"])), 48)
+        other = json.loads(self._run(export[:2] + ["other"] + export[3:] + ["--state-dir", dirs["alice"]]))
+        self.assertNotEqual(other["secret"], secrets["alice"]["secret"])
+        proc = self._invoke(export[:-1] + ["0", "--state-dir", dirs["alice"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("length must be between", proc.stderr)
+
+        updated = json.loads(self._run(["dm-update", "--state-dir", dirs["bob"]]))
+        for name in ("alice", "carol"):
+            self._run(["dm-handle-proposal", "--state-dir", dirs[name], "--proposal", updated["proposals"][0]])
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", updated["commit"]])
+        later = json.loads(self._run(["dm-history-key", "--state-dir", dirs["alice"]]))
+        self.assertEqual(later["epoch"], 3)
+        self.assertNotEqual(later["key"], keys["alice"]["key"])
+
+    def test_artifact_envelopes(self) -> None:
+        dirs = self._group("alice", "bob")
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))
+        enveloped = {"MLS_HARNESS_ARTIFACT_ENVELOPES": "1"}
+
+        proc = self._invoke(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "boxed"], **enveloped)
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        ct = proc.stdout.strip()
+        header = json.loads(self._run(["dm-artifact", "--artifact", ct]))
+        raw = base64.b64decode(ct)
+        self.assertEqual(header["type"], "ciphertext")
+        self.assertEqual(header["version"], 1)
+        self.assertEqual(header["group_id"], info["group_id"])
+        self.assertEqual(header["epoch"], info["epoch"])
+        self.assertEqual(header["sha256"], hashlib.sha256(raw[-header["size"]:]).hexdigest())
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", ct]), "boxed")
+
+        bare = self._run(["dm-encrypt", "--state-dir", dirs["bob"], "--plaintext", "bare"])
+        proc = self._invoke(["dm-artifact", "--artifact", bare])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=bad_artifact", proc.stderr)
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["alice"], "--ciphertext", bare], **enveloped)
+        self.assertEqual((proc.returncode, proc.stdout.strip()), (0, "bare"))
+
+        proc = self._invoke(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "bent"], **enveloped)
+        tampered = bytearray(base64.b64decode(proc.stdout.strip()))
+        tampered[-1] ^= 1
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", base64.b64encode(tampered).decode()])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("does not match its SHA-256", proc.stderr)
+        self.assertIn("code=bad_artifact", proc.stderr)
+        proc = self._invoke(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", ct])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("got a ciphertext, want a commit", proc.stderr)
+
+        carol = str(Path(self._tmp.name) / "carol")
+        proc = self._invoke(["dm-keypackage", "--state-dir", carol, "--name", "carol", "--seed", "3"], **enveloped)
+        carol_kp = proc.stdout.strip()
+        self.assertEqual(json.loads(self._run(["dm-artifact", "--artifact", carol_kp]))["type"], "keypackage")
+        proc = self._invoke(["group-add", "--state-dir", dirs["alice"], "--peer-keypackage", carol_kp], **enveloped)
+        added = json.loads(proc.stdout)
+        commit = json.loads(self._run(["dm-artifact", "--artifact", added["commit"]]))
+        welcome = json.loads(self._run(["dm-artifact", "--artifact", added["welcome"]]))
+        self.assertEqual((commit["type"], commit["epoch"]), ("commit", info["epoch"]))
+        self.assertEqual((welcome["type"], welcome["epoch"]), ("welcome", info["epoch"] + 1))
+        split = json.loads(self._run(["dm-split-welcome", "--welcome", added["welcome"]]))["welcomes"]
+        self.assertEqual(list(split), [self._run(["dm-keypackage-hash", "--keypackage", carol_kp])])
+        self._run(["dm-join", "--state-dir", carol, "--welcome", next(iter(split.values()))])
+        self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", added["proposals"][0]])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", added["commit"]])
+        self._assert_reads(dirs["bob"], carol, "after-envelopes")
+
+    def test_validate_keypackage(self) -> None:
+        alice = str(Path(self._tmp.name) / "alice")
+        bob = str(Path(self._tmp.name) / "bob")
+        self._run(["dm-keypackage", "--state-dir", alice, "--name", "alice", "--seed", "1"])
+        bob_kp = self._run(["dm-keypackage", "--state-dir", bob, "--name", "bob", "--seed", "2"])
+
+        report = json.loads(self._run(["dm-validate-keypackage", "--keypackage", bob_kp]))
+        self.assertTrue(report["valid"])
+        self.assertEqual(report["identity"], "bob")
+        self.assertEqual(report["not_before"], 0)
+        names = ["version", "cipher_suite", "credential", "extensions", "lifetime", "signature", "identity_binding"]
+        self.assertEqual([check["name"] for check in report["checks"]], names)
+        self.assertTrue(all(check["ok"] for check in report["checks"]))
+
+        forged = bytearray(base64.b64decode(bob_kp))
+        forged[-1] ^= 1
+        forged_kp = base64.b64encode(forged).decode()
+        proc = self._invoke(["dm-validate-keypackage", "--keypackage", forged_kp])
+        self.assertEqual(proc.returncode, 1)
+        report = json.loads(proc.stdout)
+        self.assertFalse(report["valid"])
+        failed = {check["name"]: check["error"] for check in report["checks"] if not check["ok"]}
+        self.assertEqual(failed, {"signature": "signature does not verify"})
+
+        proc = self._invoke(["dm-init", "--state-dir", alice, "--peer-keypackage", forged_kp])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("signature: signature does not verify", proc.stderr)
+        self.assertIn("code=bad_keypackage", proc.stderr)
+        proc = self._invoke(["dm-validate-keypackage", "--keypackage", "bm90IGEga2V5cGFja2FnZQ=="])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("unmarshal keypackage", proc.stderr)
+
+    def test_out_of_order_messages_within_epoch(self) -> None:
+        dirs = self._group("alice", "bob")
+        cts = [self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", f"m{i}"]) for i in range(3)]
+        for i in (2, 0, 1):
+            self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[i]]), f"m{i}")
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[0]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("outside the retention window", proc.stderr)
+
+        policy = self._run(["dm-retention", "--state-dir", dirs["bob"], "--max-skipped-generations", "1"])
+        self.assertEqual(json.loads(policy), {"max_skipped_generations": 1, "max_past_epochs": 0})
+        cts = [self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", f"n{i}"]) for i in range(3)]
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[2]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("outside the retention window", proc.stderr)
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[1]]), "n1")
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[0]]), "n0")
+
+    def test_batch_encrypt_and_decrypt(self) -> None:
+        dirs = self._group("alice", "bob")
+        batch = ["dm-encrypt-batch", "--state-dir", dirs["alice"]]
+        for body in ("one", "two", "three"):
+            batch += ["--plaintext", body]
+        cts = json.loads(self._run(batch))["ciphertexts"]
+        self.assertEqual(len(cts), 3)
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-batch")
+
+        batch = ["dm-decrypt-batch", "--state-dir", dirs["bob"]]
+        for ct in (cts[2], "AAAA", cts[0], cts[1], cts[0]):
+            batch += ["--ciphertext", ct]
+        messages = json.loads(self._run(batch))["messages"]
+        self.assertEqual([m.get("message", {}).get("body") for m in messages], ["three", None, "one", "two", None])
+        self.assertIn("error", messages[1])
+        self.assertEqual(messages[4]["code"], "outside_window")
+        self.assertEqual(messages[2]["message"]["sender"], "alice")
+
+    def test_generations_show_gaps_without_changing_state(self) -> None:
+        dirs = self._group("alice", "bob")
+
+        def generations(name: str) -> Dict:
+            return json.loads(self._run(["dm-generations", "--state-dir", dirs[name]]))
+
+        self.assertEqual(generations("bob"), {"epoch": 1, "next_outgoing_generation": 0, "senders": []})
+        cts = [self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", f"m{i}"]) for i in range(3)]
+        self.assertEqual(generations("alice")["next_outgoing_generation"], 3)
+
+        self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[2]])
+        blob = (Path(dirs["bob"]) / "participant.gob").read_bytes()
+        self.assertEqual(generations("bob")["senders"], [{"leaf": 0, "last": 2, "missing": [0, 1]}])
+        self.assertEqual((Path(dirs["bob"]) / "participant.gob").read_bytes(), blob)
+        self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", cts[0]])
+        self.assertEqual(generations("bob")["senders"], [{"leaf": 0, "last": 2, "missing": [1]}])
+
+    def test_retention_keeps_past_epochs(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+        self._run(["dm-retention", "--state-dir", dirs["bob"], "--max-past-epochs", "1"])
+        late = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "sent-before-update"])
+
+        updated = json.loads(self._run(["dm-update", "--state-dir", dirs["alice"], "--seed", "41"]))
+        for name in ("bob", "carol"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", updated["proposals"][0]])
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", updated["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", updated["commit"]])
+
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))
+        self.assertEqual(info["past_epochs"], [info["epoch"] - 1])
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", late]), "sent-before-update")
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["carol"], "--ciphertext", late])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("outside the retention window", proc.stderr)
+        self._assert_reads(dirs["alice"], dirs["bob"], "after-update")
+
+    def test_framed_message_metadata(self) -> None:
+        dirs = self._group("alice", "bob")
+        framed = self._run([
+            "dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "hello",
+            "--framed", "--content-type", "application/json", "--timestamp-ms", "1700000000000", "--padding", "16",
+        ])
+        bare = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "plain"])
+        self.assertGreater(len(base64.b64decode(framed)), len(base64.b64decode(bare)) + 16)
+
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", framed, "--metadata"]))
+        self.assertEqual(message["body"], "hello")
+        self.assertEqual(message["content_type"], "application/json")
+        self.assertEqual(message["timestamp_ms"], 1700000000000)
+        self.assertEqual(message["padding"], 16)
+        self.assertEqual(message["sender"], "alice")
+        self.assertEqual(message["sender_leaf"], 0)
+        self.assertTrue(message["framed"])
+
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", bare, "--metadata"]))
+        self.assertEqual((message["body"], message["content_type"], message["framed"]), ("plain", "text/plain", False))
+
+        reply = self._run(["dm-encrypt", "--state-dir", dirs["bob"], "--plaintext", "reply", "--framed"])
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["alice"], "--ciphertext", reply]), "reply")
+
+    def test_future_app_version_is_refused(self) -> None:
+        dirs = self._group("alice", "bob")
+        self.assertEqual(json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))["app_version"], 1)
+        kp = self._run(["dm-keypackage", "--state-dir", dirs["bob"]])
+        self.assertTrue(json.loads(self._run(["dm-validate-keypackage", "--keypackage", kp]))["valid"])
+
+        # A bare body that opens like a frame is read as one, so this is a frame
+        # from version 2.
+        future = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "MLSM\x02from-later"])
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", future])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("code=unsupported_version", proc.stderr)
+        self._assert_reads(dirs["alice"], dirs["bob"], "still-version-1")
+
+    def test_typed_content(self) -> None:
+        dirs = self._group("alice", "bob")
+        chat = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--content", json.dumps({"kind": "chat", "text": "hi"})])
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", chat, "--metadata"]))
+        self.assertEqual(message["content"], {"kind": "chat", "text": "hi"})
+        self.assertTrue(message["framed"])
+        chat_id = hashlib.sha256(base64.b64decode(chat)).hexdigest()
+        self.assertEqual(message["message_id"], chat_id)
+
+        receipt = {"kind": "receipt", "receipt": {"status": "read", "message_ids": [chat_id]}}
+        ct = self._run(["dm-encrypt", "--state-dir", dirs["bob"], "--content", json.dumps(receipt)])
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["alice"], "--ciphertext", ct, "--metadata"]))
+        self.assertEqual(message["content_type"], "application/vnd.polycentric.receipt+json")
+        self.assertEqual(message["content"], receipt)
+
+        for content in (
+            {"kind": "typing", "typing": {"active": True}},
+            {"kind": "control", "control": {"action": "rename", "params": {"name": "team"}}},
+        ):
+            ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--content", json.dumps(content)])
+            message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", ct, "--metadata"]))
+            self.assertEqual(message["content"], content)
+
+        bad = {"kind": "receipt", "receipt": {"status": "read", "message_ids": ["not-a-hash"]}}
+        proc = self._invoke(["dm-encrypt", "--state-dir", dirs["alice"], "--content", json.dumps(bad)])
+        self.assertEqual(proc.returncode, 2)
+        self.assertIn("not a lowercase hex SHA-256", proc.stderr)
+
+        # A body that claims a schema but does not fit it is refused on receipt.
+        forged = self._run([
+            "dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "{}",
+            "--framed", "--content-type", "application/vnd.polycentric.receipt+json",
+        ])
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", forged])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("receipt status", proc.stderr)
+
+        plain = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "x", "--framed", "--content-type", "application/json"])
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", plain, "--metadata"]))
+        self.assertNotIn("content", message)
+
+    def test_padding_buckets_hide_lengths(self) -> None:
+        dirs = self._group("alice", "bob")
+        policy = self._run(["dm-padding", "--state-dir", dirs["alice"], "--buckets", "256,1024"])
+        self.assertEqual(json.loads(policy), {"buckets": [256, 1024]})
+
+        sizes = {}
+        for body in ("a", "b" * 200, "c" * 300, "d" * 1500):
+            ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", body])
+            sizes[body[0]] = len(base64.b64decode(ct))
+            message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", ct, "--metadata"]))
+            self.assertEqual(message["body"], body)
+            self.assertTrue(message["framed"])
+        self.assertEqual(sizes["a"], sizes["b"])
+        self.assertEqual(sizes["c"] - sizes["a"], 1024 - 256)
+        self.assertEqual(sizes["d"] - sizes["a"], 2048 - 256)
+
+        proc = self._invoke(["dm-padding", "--state-dir", dirs["alice"], "--buckets", "1024,256"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("ascending", proc.stderr)
+        self._run(["dm-padding", "--state-dir", dirs["alice"], "--buckets", ""])
+        ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "a"])
+        self.assertLess(len(base64.b64decode(ct)), sizes["a"])
+
+    def test_keypackage_pool_is_one_time_use(self) -> None:
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "bob", "carol")}
+        self._run(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--seed", "1"])
+        self._run(["dm-keypackage", "--state-dir", dirs["carol"], "--name", "carol", "--seed", "3"])
+        bob_kp = self._run(["dm-keypackage", "--state-dir", dirs["bob"], "--name", "bob", "--seed", "2"])
+        pool = json.loads(self._run(["dm-keypackage-pool", "--state-dir", dirs["bob"], "--count", "3", "--seed", "20"]))
+        self.assertEqual((pool["available"], pool["consumed"]), (3, 0))
+        kps = pool["keypackages"]
+        self.assertEqual(len(set(kps + [bob_kp])), 4)
+
+        init = json.loads(self._run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", kps[0]]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", init["welcome"]])
+        self._assert_reads(dirs["alice"], dirs["bob"], "from the pool")
+        pool = json.loads(self._run(["dm-keypackage-pool", "--state-dir", dirs["bob"], "--count", "0"]))
+        self.assertEqual((pool["keypackages"], pool["available"], pool["consumed"]), ([], 2, 1))
+
+        reuse = json.loads(self._run(["dm-init", "--state-dir", dirs["carol"], "--peer-keypackage", kps[0], "--group-id", "b3RoZXI="]))
+        proc = self._invoke(["dm-join", "--state-dir", dirs["bob"], "--welcome", reuse["welcome"]])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("already consumed", proc.stderr)
+
+        proc = self._invoke(["dm-keypackage-pool", "--state-dir", dirs["bob"], "--count", "1", "--seed", "20"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("already in the pool", proc.stderr)
+
+    def test_secure_mode_refuses_seeds(self) -> None:
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "alice-again", "bob")}
+        kps = {
+            name: self._run(["dm-keypackage", "--state-dir", dirs[name], "--name", "alice", "--mode", "secure"])
+            for name in ("alice", "alice-again")
+        }
+        self.assertNotEqual(kps["alice"], kps["alice-again"])
+        self.assertEqual(self._run(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice"]), kps["alice"])
+
+        def lifetime(kp: str) -> Dict[str, str]:
+            inspected = json.loads(self._run(["inspect", "--type", "keypackage", "--value", kp]))
+            return next(ext["decoded"] for ext in inspected["extensions"] if ext["name"] == "lifetime")
+
+        self.assertNotEqual(lifetime(kps["alice"])["not_after"], "2100-01-01T00:00:00Z")
+        bob_kp = self._run(["dm-keypackage", "--state-dir", dirs["bob"], "--name", "bob"])
+        self.assertEqual(lifetime(bob_kp)["not_after"], "2100-01-01T00:00:00Z")
+
+        for args in (
+            ["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--seed", "1337"],
+            ["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp, "--seed", "7331"],
+        ):
+            proc = self._invoke(args)
+            self.assertEqual(proc.returncode, 1)
+            self.assertIn("secure participants refuse seeds", proc.stderr)
+        proc = self._invoke(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--mode", "deterministic"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("participant is in secure mode", proc.stderr)
+
+        init = json.loads(self._run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", init["welcome"]])
+        self.assertEqual(json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))["mode"], "secure")
+        self.assertEqual(json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))["mode"], "deterministic")
+        self._assert_reads(dirs["alice"], dirs["bob"], "secure-to-deterministic")
+        self._assert_reads(dirs["bob"], dirs["alice"], "reply")
+
+    def test_frozen_clock_dates_keypackages_and_messages(self) -> None:
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "bob")}
+        frozen = {"MLS_HARNESS_CLOCK": "2030-01-01T00:00:00Z"}
+
+        def run(args: Sequence[str], **env: str) -> str:
+            proc = self._invoke(args, **env)
+            self.assertEqual(proc.returncode, 0, proc.stderr)
+            return proc.stdout.strip()
+
+        def lifetime(kp: str) -> Dict[str, str]:
+            inspected = json.loads(self._run(["inspect", "--type", "keypackage", "--value", kp]))
+            return next(ext["decoded"] for ext in inspected["extensions"] if ext["name"] == "lifetime")
+
+        alice_kp = run(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--mode", "secure"], **frozen)
+        self.assertEqual(
+            (lifetime(alice_kp)["not_before"], lifetime(alice_kp)["not_after"]),
+            ("2029-12-31T23:00:00Z", "2030-04-01T00:00:00Z"),
+        )
+        self.assertEqual(run(["dm-keypackage", "--state-dir", dirs["alice"]], **frozen), alice_kp)
+        rotated = run(["dm-keypackage", "--state-dir", dirs["alice"]], MLS_HARNESS_CLOCK="2030-04-01T00:00:00Z")
+        self.assertNotEqual(rotated, alice_kp)
+        self.assertEqual(lifetime(rotated)["not_after"], "2030-06-30T00:00:00Z")
+
+        bob_kp = self._run(["dm-keypackage", "--state-dir", dirs["bob"], "--name", "bob", "--seed", "2"])
+        init = json.loads(run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp], **frozen))
+        run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]], **frozen)
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", init["welcome"]])
+        framed = run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "hi", "--framed"], **frozen)
+        message = json.loads(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", framed, "--metadata"]))
+        self.assertEqual(message["timestamp_ms"], 1893456000000)
+
+        proc = self._invoke(["dm-info", "--state-dir", dirs["alice"]], MLS_HARNESS_CLOCK="tomorrow")
+        self.assertEqual(proc.returncode, 2)
+        self.assertIn("MLS_HARNESS_CLOCK", proc.stderr)
+
+    def test_chacha_suite_and_mixed_suites(self) -> None:
+        chacha = "X25519_CHACHA20POLY1305_SHA256_Ed25519"
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "bob", "carol")}
+        alice_kp = self._run(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--seed", "1", "--cipher-suite", chacha])
+        bob_kp = self._run(["dm-keypackage", "--state-dir", dirs["bob"], "--name", "bob", "--seed", "2", "--cipher-suite", chacha])
+        carol_kp = self._run(["dm-keypackage", "--state-dir", dirs["carol"], "--name", "carol", "--seed", "3"])
+        self.assertTrue(alice_kp)
+
+        proc = self._invoke(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", carol_kp])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("mixed-suite groups are not supported", proc.stderr)
+        proc = self._invoke(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp, "--cipher-suite", "X25519_AES128GCM_SHA256_Ed25519"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("participant uses cipher suite", proc.stderr)
+
+        init = json.loads(self._run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", init["welcome"]])
+        self._assert_reads(dirs["alice"], dirs["bob"], "over chacha")
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))
+        self.assertEqual(info["cipher_suite"]["name"], chacha)
+
+        proc = self._invoke(["dm-keypackage", "--state-dir", str(Path(self._tmp.name) / "dave"), "--cipher-suite", "P256_AES128GCM_SHA256_P256"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("unsupported cipher suite", proc.stderr)
+
+    def test_identity_binding_names_user_in_roster(self) -> None:
+        from gateway import crypto_ed25519
+
+        dirs = {name: str(Path(self._tmp.name) / name) for name in ("alice", "bob")}
+        self._run(["dm-keypackage", "--state-dir", dirs["alice"], "--name", "alice", "--seed", "1"])
+        self._run(["dm-keypackage", "--state-dir", dirs["bob"], "--name", "bob", "--seed", "2"])
+        user_seed = bytes(range(32))
+        user_id = base64.urlsafe_b64encode(crypto_ed25519.derive_public_key(user_seed)).decode().rstrip("=")
+        message = base64.b64decode(self._run(["dm-identity-message", "--state-dir", dirs["bob"]]))
+
+        forged = base64.b64encode(crypto_ed25519.sign(bytes(32), message)).decode()
+        proc = self._invoke(["dm-bind-identity", "--state-dir", dirs["bob"], "--user-id", user_id, "--signature", forged])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("does not verify", proc.stderr)
+
+        signature = base64.b64encode(crypto_ed25519.sign(user_seed, message)).decode()
+        bob_kp = self._run(["dm-bind-identity", "--state-dir", dirs["bob"], "--user-id", user_id, "--signature", signature])
+        init = json.loads(self._run(["dm-init", "--state-dir", dirs["alice"], "--peer-keypackage", bob_kp]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", init["commit"]])
+        self._run(["dm-join", "--state-dir", dirs["bob"], "--welcome", init["welcome"]])
+
+        # An update keeps the credential key, so the new leaf keeps the binding.
+        update = json.loads(self._run(["dm-update", "--state-dir", dirs["bob"]]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", update["proposals"][0]])
+        for name in ("alice", "bob"):
+            self._run(["dm-commit-apply", "--state-dir", dirs[name], "--commit", update["commit"]])
+            info = json.loads(self._run(["dm-info", "--state-dir", dirs[name]]))
+            self.assertEqual(info["members"], [{"leaf": 0, "identity": "alice"}, {"leaf": 1, "identity": "bob", "user_id": user_id}])
+
+    def test_info_lists_roster(self) -> None:
+        dirs = self._group("alice", "bob", "carol")
+
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["bob"]]))
+        self.assertEqual(info["epoch"], 1)
+        self.assertEqual(info["own_leaf"], 1)
+        self.assertEqual(info["member_count"], 3)
+        self.assertEqual([m["identity"] for m in info["members"]], ["alice", "bob", "carol"])
+        self.assertEqual(info["cipher_suite"]["name"], "X25519_AES128GCM_SHA256_Ed25519")
+
+        removed = json.loads(self._run(["dm-remove", "--state-dir", dirs["alice"], "--member", "bob"]))
+        self.assertTrue(json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))["pending_commit"])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", removed["commit"]])
+
+        info = json.loads(self._run(["dm-info", "--state-dir", dirs["alice"]]))
+        self.assertEqual(info["epoch"], 2)
+        self.assertFalse(info["pending_commit"])
+        self.assertEqual(info["members"], [{"leaf": 0, "identity": "alice"}, {"leaf": 2, "identity": "carol"}])
+
+    def test_sealed_state_at_rest(self) -> None:
+        key = {"MLS_HARNESS_STATE_KEY": base64.b64encode(bytes(range(32))).decode()}
+        alice = str(Path(self._tmp.name) / "alice")
+        bob = str(Path(self._tmp.name) / "bob")
+
+        def run(args, **env):
+            proc = self._invoke(args, **env)
+            self.assertEqual(proc.returncode, 0, proc.stderr)
+            return proc.stdout.strip()
+
+        run(["dm-keypackage", "--state-dir", alice, "--name", "alice", "--seed", "1"], **key)
+        bob_kp = run(["dm-keypackage", "--state-dir", bob, "--name", "bob", "--seed", "2"])
+        init = json.loads(run(["dm-init", "--state-dir", alice, "--peer-keypackage", bob_kp], **key))
+        run(["dm-commit-apply", "--state-dir", alice, "--commit", init["commit"]], **key)
+        run(["dm-join", "--state-dir", bob, "--welcome", init["welcome"]])
+
+        blob = base64.b64decode((Path(alice) / "participant.gob").read_text())
+        self.assertTrue(blob.startswith(b"MLSS"))
+        self.assertNotIn(b"alice", blob)
+
+        ct = run(["dm-encrypt", "--state-dir", alice, "--plaintext", "sealed-hello"], **key)
+        self.assertEqual(run(["dm-decrypt", "--state-dir", bob, "--ciphertext", ct]), "sealed-hello")
+
+        proc = self._invoke(["dm-encrypt", "--state-dir", alice, "--plaintext", "no-key"])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("sealed", proc.stderr)
+        proc = self._invoke(
+            ["dm-encrypt", "--state-dir", alice, "--plaintext", "wrong-key"],
+            MLS_HARNESS_STATE_PASSPHRASE="not-the-key",
+        )
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("other kind of key", proc.stderr)
+
+        # An unsealed participant is sealed on its next write.
+        run(["dm-encrypt", "--state-dir", bob, "--plaintext", "migrate"], MLS_HARNESS_STATE_PASSPHRASE="bob-pass")
+        self.assertTrue(base64.b64decode((Path(bob) / "participant.gob").read_text()).startswith(b"MLSS"))
+
+    def test_sealed_state_refuses_inflated_iterations(self) -> None:
+        passphrase = {"MLS_HARNESS_STATE_PASSPHRASE": "alice-pass"}
+        alice = str(Path(self._tmp.name) / "alice")
+        proc = self._invoke(["dm-keypackage", "--state-dir", alice, "--name", "alice", "--seed", "1"], **passphrase)
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        state = Path(alice) / "participant.gob"
+        blob = bytearray(base64.b64decode(state.read_text()))
+        self.assertEqual(int.from_bytes(blob[6:10], "big"), 600_000)
+
+        # The count is read before the AEAD checks anything, so the harness
+        # must refuse it rather than run four billion PBKDF2 rounds.
+        for iterations in (600_001, 0xFFFFFFFF, 0):
+            blob[6:10] = iterations.to_bytes(4, "big")
+            state.write_text(base64.b64encode(bytes(blob)).decode())
+            proc = self._invoke(["dm-keypackage", "--state-dir", alice, "--name", "alice"], **passphrase)
+            self.assertEqual(proc.returncode, 1, proc.stdout)
+            self.assertIn(f"asks for {iterations} PBKDF2 iterations", proc.stderr)
+
+    def test_handle_under_race_detector(self) -> None:
+        go_bin = shutil.which("go")
+        if not go_bin:
+            raise unittest.SkipTest("go is required for the race build")
+        env = make_harness_env({"CGO_ENABLED": "1", "GOMAXPROCS": "4"})
+        cc = subprocess.run([go_bin, "env", "CC"], env=env, capture_output=True, text=True, timeout=60).stdout.split()
+        if not cc or not shutil.which(cc[0]):
+            raise unittest.SkipTest("the race detector needs cgo and a C compiler")
+        binary = Path(self._tmp.name) / "mls-harness-race"
+        build = subprocess.run(
+            [go_bin, "build", "-race", "-o", str(binary), "./cmd/mls-harness"],
+            cwd=HARNESS_DIR,
+            env=env,
+            capture_output=True,
+            text=True,
+            timeout=600,
+        )
+        self.assertEqual(build.returncode, 0, build.stderr)
+
+        # The race detector fails the run with exit code 66 and a report on
+        # stderr, even when every message went through.
+        proc = subprocess.run(
+            [str(binary), "handle-stress", "--workers", "4", "--messages", "10"],
+            cwd=HARNESS_DIR,
+            env=env,
+            capture_output=True,
+            text=True,
+            timeout=300,
+        )
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        self.assertNotIn("WARNING: DATA RACE", proc.stderr)
+        self.assertEqual(proc.stdout.strip(), "handle-stress: PASS (workers=4 messages=80)")
+
+    def test_backup_moves_participant_to_new_device(self) -> None:
+        dirs = self._group("alice", "bob")
+        key = {"MLS_HARNESS_BACKUP_KEY": base64.b64encode(bytes(range(32, 64))).decode()}
+        laptop = str(Path(self._tmp.name) / "bob-laptop")
+        backup = Path(self._tmp.name) / "bob.backup"
+        seen = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "seen-on-phone"])
+        self.assertEqual(self._run(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", seen]), "seen-on-phone")
+
+        proc = self._invoke(["dm-backup-export", "--state-dir", dirs["bob"], "--out", str(backup)])
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("MLS_HARNESS_BACKUP_KEY", proc.stderr)
+        proc = self._invoke(["dm-backup-export", "--state-dir", dirs["bob"], "--out", str(backup)], **key)
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        blob = base64.b64decode(backup.read_text())
+        self.assertTrue(blob.startswith(b"MLSB"))
+        self.assertNotIn(b"bob", blob)
+
+        proc = self._invoke(
+            ["dm-backup-import", "--state-dir", laptop, "--backup", str(backup)],
+            MLS_HARNESS_BACKUP_PASSPHRASE="not-the-key",
+        )
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("other kind of key", proc.stderr)
+        proc = self._invoke(["dm-backup-import", "--state-dir", laptop, "--backup", str(backup)], **key)
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        self.assertEqual(json.loads(proc.stdout), json.loads(self._run(["dm-groups", "--state-dir", dirs["bob"]])))
+        proc = self._invoke(["dm-backup-import", "--state-dir", laptop, "--backup", str(backup)], **key)
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("already holds a participant", proc.stderr)
+
+        # The backup carries no key for what the phone already read.
+        proc = self._invoke(["dm-decrypt", "--state-dir", laptop, "--ciphertext", seen])
+        self.assertEqual(proc.returncode, 1)
+
+        # The laptop re-enters with an Update, which leaves the phone behind.
+        updated = json.loads(self._run(["dm-update", "--state-dir", laptop, "--seed", "77"]))
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", updated["proposals"][0]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", updated["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", laptop, "--commit", updated["commit"]])
+        self._assert_reads(dirs["alice"], laptop, "to-laptop")
+        self._assert_reads(laptop, dirs["alice"], "from-laptop")
+        ct = self._run(["dm-encrypt", "--state-dir", dirs["alice"], "--plaintext", "not-for-phone"])
+        proc = self._invoke(["dm-decrypt", "--state-dir", dirs["bob"], "--ciphertext", ct])
+        self.assertNotEqual(proc.returncode, 0)
+
+    def test_seeded_calls_draw_from_their_own_drbg(self) -> None:
+        def seeded_init(tag: str, **env: str):
+            alice = str(Path(self._tmp.name) / f"alice-{tag}")
+            bob = str(Path(self._tmp.name) / f"bob-{tag}")
+            outputs = []
+            for args in (
+                ["dm-keypackage", "--state-dir", alice, "--name", "alice", "--seed", "1"],
+                ["dm-keypackage", "--state-dir", bob, "--name", "bob", "--seed", "2"],
+            ):
+                proc = self._invoke(args, **env)
+                self.assertEqual(proc.returncode, 0, proc.stderr)
+                outputs.append(proc.stdout.strip())
+            proc = self._invoke(
+                ["dm-init", "--state-dir", alice, "--peer-keypackage", outputs[1], "--seed", "3"], **env
+            )
+            self.assertEqual(proc.returncode, 0, proc.stderr)
+            return outputs, json.loads(proc.stdout)["welcome"]
+
+        # The seed fixes every secret, so KeyPackages repeat; go-mls picks the
+        # Welcome's HPKE ephemeral from crypto/rand.
+        first_kps, first_welcome = seeded_init("a")
+        second_kps, second_welcome = seeded_init("b")
+        self.assertEqual(first_kps, second_kps)
+        self.assertNotEqual(first_welcome, second_welcome)
+
+        legacy = {"MLS_HARNESS_LEGACY_SEEDED_RAND": "1"}
+        self.assertEqual(seeded_init("c", **legacy), seeded_init("d", **legacy))
+
+    def test_remove_rejects_unknown_member(self) -> None:
+        dirs = self._group("alice", "bob")
+        for member, message in (("mallory", "no member"), ("7", "not an occupied leaf"), ("0", "own leaf")):
+            proc = self._invoke(["dm-remove", "--state-dir", dirs["alice"], "--member", member])
+            self.assertEqual(proc.returncode, 1, member)
+            self.assertIn(message, proc.stderr)
+
+
+    def test_failures_log_error_codes(self) -> None:
+        dirs = self._group("alice", "bob")
+
+        def fails_with(code: str, args: Sequence[str]) -> None:
+            proc = self._invoke(args)
+            self.assertEqual(proc.returncode, 1, args)
+            self.assertIn(f"code={code}", proc.stderr)
+
+        def update(apply_bob: bool) -> Dict[str, str]:
+            updated = json.loads(self._run(["dm-update", "--state-dir", dirs["alice"]]))
+            self._run(["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", updated["commit"]])
+            if apply_bob:
+                self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", updated["proposals"][0]])
+                self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", updated["commit"]])
+            return updated
+
+        # Both update in the same epoch; bob drops his and applies alice's, so
+        # his commit lost and is stale for alice.
+        lost = json.loads(self._run(["dm-update", "--state-dir", dirs["bob"]]))
+        won = update(apply_bob=False)
+        self._run(["dm-pending-discard", "--state-dir", dirs["bob"]])
+        self._run(["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", won["proposals"][0]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", won["commit"]])
+        self._run(["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", won["commit"]])
+        fails_with("stale_commit", ["dm-commit-apply", "--state-dir", dirs["alice"], "--commit", lost["commit"]])
+        update(apply_bob=False)
+        ahead = update(apply_bob=False)
+        fails_with("epoch_mismatch", ["dm-handle-proposal", "--state-dir", dirs["bob"], "--proposal", ahead["proposals"][0]])
+        fails_with("epoch_mismatch", ["dm-commit-apply", "--state-dir", dirs["bob"], "--commit", ahead["commit"]])
+
+        fails_with("not_member", ["dm-encrypt", "--state-dir", dirs["bob"], "--group-id", "Zm9v", "--plaintext", "x"])
+        fails_with("bad_welcome", ["dm-join", "--state-dir", dirs["bob"], "--welcome", "AAAA"])
+        (Path(dirs["bob"]) / "participant.gob").write_text("TUxTUAoBAA==")
+        fails_with("state_corrupt", ["dm-info", "--state-dir", dirs["bob"]])
+
+
+if __name__ == "__main__":
+    unittest.main()
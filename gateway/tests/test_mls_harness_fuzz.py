@@ -0,0 +1,44 @@
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+
+class TestMLSHarnessFuzz(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def test_decoders_survive_mutated_artifacts(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            proc = run_harness(
+                ["fuzz", "--iterations", "500", "--crash-dir", str(Path(tmp) / "crashes")],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+        self.assertEqual(proc.returncode, 0, msg=f"stdout:\n{proc.stdout}\nstderr:\n{proc.stderr}")
+        for target in ("keypackage", "welcome", "ciphertext", "participant"):
+            self.assertIn(f"fuzz: {target} ok (500 inputs", proc.stdout)
+
+    def test_rejects_unknown_target(self) -> None:
+        proc = run_harness(
+            ["fuzz", "--targets", "commit"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=60.0,
+        )
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("unknown fuzz target", proc.stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
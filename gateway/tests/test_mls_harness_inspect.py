@@ -0,0 +1,69 @@
+import json
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+from typing import Sequence
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+
+class TestMLSHarnessInspect(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def _run(self, args: Sequence[str]) -> str:
+        proc = run_harness(
+            args,
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=60.0,
+        )
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness {args[0]} failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        return proc.stdout
+
+    def test_inspect_decodes_dm_artifacts(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            alice_dir = str(Path(tmp) / "alice")
+            bob_dir = str(Path(tmp) / "bob")
+            self._run(["dm-keypackage", "--state-dir", alice_dir, "--name", "alice", "--seed", "1"])
+            bob_kp = self._run(["dm-keypackage", "--state-dir", bob_dir, "--name", "bob", "--seed", "2"]).strip()
+            init = json.loads(self._run(["dm-init", "--state-dir", alice_dir, "--peer-keypackage", bob_kp]))
+
+        kp = json.loads(self._run(["inspect", "--value", bob_kp]))
+        self.assertEqual(kp["type"], "keypackage")
+        self.assertEqual(kp["credential"]["identity"], "bob")
+
+        welcome = json.loads(self._run(["inspect", "--value", init["welcome"]]))
+        self.assertEqual(welcome["type"], "welcome")
+        self.assertEqual(welcome["secrets"][0]["key_package_hash"], kp["hash"])
+
+        commit = json.loads(self._run(["inspect", "--type", "plaintext", "--value", init["commit"]]))
+        self.assertEqual(commit["content_type"], "commit")
+        self.assertEqual(len(commit["commit"]["adds"]), 1)
+
+    def test_inspect_rejects_garbage(self) -> None:
+        proc = run_harness(
+            ["inspect", "--value", "abcd"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=60.0,
+        )
+        self.assertNotEqual(proc.returncode, 0)
+        self.assertIn("did not decode", proc.stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
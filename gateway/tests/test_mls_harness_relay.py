@@ -0,0 +1,95 @@
+import re
+import subprocess
+import sys
+import unittest
+from pathlib import Path
+from typing import List, Tuple
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env
+
+
+class TestMLSHarnessRelay(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def _start(self, args: List[str], pattern: str) -> Tuple[subprocess.Popen, str]:
+        proc = subprocess.Popen(
+            [str(self._harness_bin), *args],
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            stdout=subprocess.PIPE,
+            stderr=subprocess.PIPE,
+            text=True,
+        )
+        self.addCleanup(self._stop, proc)
+        assert proc.stderr is not None
+        line = proc.stderr.readline()
+        match = re.search(pattern, line)
+        if not match:
+            self.fail(f"{args[0]} did not report its address: {line!r}")
+        return proc, match.group(1)
+
+    def _stop(self, proc: subprocess.Popen) -> None:
+        if proc.poll() is None:
+            proc.terminate()
+        try:
+            proc.wait(timeout=10)
+        except subprocess.TimeoutExpired:
+            proc.kill()
+            proc.wait()
+        for stream in (proc.stdout, proc.stderr):
+            if stream is not None:
+                stream.close()
+
+    def _run_peer(self, role: str, url: str) -> subprocess.Popen:
+        proc = subprocess.Popen(
+            [str(self._harness_bin), "relay", "run", "--role", role, "--url", url, "--iterations", "20", "--timeout", "60s"],
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            stdout=subprocess.PIPE,
+            stderr=subprocess.PIPE,
+            text=True,
+        )
+        self.addCleanup(self._stop, proc)
+        return proc
+
+    def _assert_pass(self, proc: subprocess.Popen, role: str) -> None:
+        stdout, stderr = proc.communicate(timeout=120)
+        self.assertEqual(proc.returncode, 0, msg=f"stdout:\n{stdout}\nstderr:\n{stderr}")
+        self.assertIn(f"relay: PASS (role={role}", stdout)
+
+    def test_peers_converge_through_relay(self) -> None:
+        _, addr = self._start(["relay", "serve", "--addr", "127.0.0.1:0"], r"ws://(\S+)/")
+        url = f"ws://{addr}/relay-test"
+
+        # bob connects first, so his KeyPackage waits at the relay until alice joins.
+        bob = self._run_peer("bob", url)
+        alice = self._run_peer("alice", url)
+
+        self._assert_pass(alice, "alice")
+        self._assert_pass(bob, "bob")
+
+    def test_peers_converge_directly(self) -> None:
+        alice, addr = self._start(
+            ["relay", "run", "--role", "alice", "--listen", "127.0.0.1:0", "--iterations", "20", "--timeout", "60s"],
+            r"relay peer listening.*ws://(\S+)/",
+        )
+        bob = self._run_peer("bob", f"ws://{addr}/")
+
+        self._assert_pass(bob, "bob")
+        self._assert_pass(alice, "alice")
+
+    def test_rejects_unknown_role(self) -> None:
+        proc = self._run_peer("carol", "ws://127.0.0.1:1/")
+        _, stderr = proc.communicate(timeout=30)
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("role must be", stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
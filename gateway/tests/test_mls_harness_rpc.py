@@ -0,0 +1,182 @@
+import base64
+import json
+import shutil
+import subprocess
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+from typing import Any, Dict, List, Optional, Tuple
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+# Runs the wasip1 build under Node's WASI, which needs no syscall/js.
+WASI_RUNNER = """
+const fs = require('fs');
+const { WASI } = require('node:wasi');
+const wasi = new WASI({ version: 'preview1', args: ['mls-harness', 'rpc'], env: {} });
+(async () => {
+  const module = await WebAssembly.compile(fs.readFileSync(process.argv[2]));
+  const instance = await WebAssembly.instantiate(module, wasi.getImportObject());
+  process.exitCode = wasi.start(instance);
+})();
+"""
+
+
+class RPCClient:
+    def __init__(self, command: List[str]) -> None:
+        self._proc = subprocess.Popen(
+            command,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            stdin=subprocess.PIPE,
+            stdout=subprocess.PIPE,
+            stderr=subprocess.DEVNULL,
+            text=True,
+        )
+        self._next_id = 0
+
+    def send_line(self, line: str) -> Dict[str, Any]:
+        assert self._proc.stdin is not None and self._proc.stdout is not None
+        self._proc.stdin.write(line + "\n")
+        self._proc.stdin.flush()
+        return json.loads(self._proc.stdout.readline())
+
+    def call(self, method: str, **params: Any) -> Dict[str, Any]:
+        self._next_id += 1
+        response = self.send_line(json.dumps({"id": self._next_id, "method": method, "params": params}))
+        assert response["id"] == self._next_id, response
+        return response["result"]
+
+    def close(self) -> int:
+        assert self._proc.stdin is not None
+        self._proc.stdin.close()
+        try:
+            return self._proc.wait(timeout=30)
+        finally:
+            if self._proc.stdout is not None:
+                self._proc.stdout.close()
+
+
+def dm_round_trip(test: unittest.TestCase, client: RPCClient) -> str:
+    def ok(method: str, **params: Any) -> Dict[str, Any]:
+        result = client.call(method, **params)
+        test.assertTrue(result["ok"], result)
+        return result
+
+    alice = ok("dmCreateParticipant", name="alice", seed_int=1)
+    bob = ok("dmCreateParticipant", name="bob", seed_int=2)
+    group_id = base64.b64encode(b"rpc-test").decode("ascii")
+    init = ok(
+        "dmInit",
+        participant_b64=alice["participant_b64"],
+        peer_keypackage_b64=bob["keypackage_b64"],
+        group_id_b64=group_id,
+        seed_int=3,
+    )
+    joined = ok("dmJoin", participant_b64=bob["participant_b64"], welcome_b64=init["welcome_b64"])
+    alice_b64 = ok("dmCommitApply", participant_b64=init["participant_b64"], commit_b64=init["commit_b64"])["participant_b64"]
+    bob_b64 = ok("dmCommitApply", participant_b64=joined["participant_b64"], commit_b64=init["commit_b64"])["participant_b64"]
+    sent = ok("dmEncrypt", participant_b64=alice_b64, plaintext="hello over rpc")
+    received = ok("dmDecrypt", participant_b64=bob_b64, ciphertext_b64=sent["ciphertext_b64"])
+    test.assertEqual(received["plaintext"], "hello over rpc")
+    return sent["ciphertext_b64"]
+
+
+class TestMLSHarnessRPC(unittest.TestCase):
+    _wasi_dir: Optional[tempfile.TemporaryDirectory] = None
+
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    @classmethod
+    def tearDownClass(cls) -> None:
+        if cls._wasi_dir is not None:
+            cls._wasi_dir.cleanup()
+
+    def _wasi_module(self) -> Tuple[str, Path]:
+        """Returns node and the wasip1 build of the harness, built once per class."""
+        go_bin = shutil.which("go")
+        node_bin = shutil.which("node")
+        if not go_bin or not node_bin:
+            raise unittest.SkipTest("go and node are required for the wasip1 check")
+        probe = subprocess.run([node_bin, "-e", "require('node:wasi')"], capture_output=True, timeout=30)
+        if probe.returncode != 0:
+            raise unittest.SkipTest("node has no WASI support")
+
+        cls = type(self)
+        if cls._wasi_dir is None:
+            wasi_dir = tempfile.TemporaryDirectory(prefix="mls-harness-wasi-")
+            build = subprocess.run(
+                [go_bin, "build", "-p", "1", "-o", str(Path(wasi_dir.name) / "mls-harness.wasm"), "./cmd/mls-harness"],
+                cwd=HARNESS_DIR,
+                env=make_harness_env({"GOOS": "wasip1", "GOARCH": "wasm"}),
+                capture_output=True,
+                text=True,
+                timeout=300,
+            )
+            if build.returncode != 0:
+                wasi_dir.cleanup()
+                self.fail(build.stderr)
+            cls._wasi_dir = wasi_dir
+        return node_bin, Path(cls._wasi_dir.name) / "mls-harness.wasm"
+
+    def test_dm_round_trip_over_rpc(self) -> None:
+        client = RPCClient([str(self._harness_bin), "rpc"])
+        dm_round_trip(self, client)
+        self.assertEqual(client.close(), 0)
+
+    def test_errors_keep_the_loop_running(self) -> None:
+        client = RPCClient([str(self._harness_bin), "rpc"])
+        unknown = client.call("dmNope")
+        self.assertFalse(unknown["ok"])
+        self.assertIn("unknown method", unknown["error"])
+        invalid = client.call("dmEncrypt", bogus=1)
+        self.assertFalse(invalid["ok"])
+        self.assertIn("unknown field", invalid["error"])
+        corrupt = client.call("dmDecrypt", participant_b64="AAAA", ciphertext_b64="AAAA")
+        self.assertEqual(corrupt["code"], "state_corrupt")
+        malformed = client.send_line("not json")
+        self.assertIsNone(malformed["id"])
+        self.assertFalse(malformed["result"]["ok"])
+        self.assertTrue(client.call("dmCreateParticipant", name="alice", seed_int=1)["ok"])
+        self.assertEqual(client.close(), 0)
+
+    def test_wasip1_build_matches_native(self) -> None:
+        node_bin, module = self._wasi_module()
+        with tempfile.TemporaryDirectory(prefix="mls-harness-wasi-runner-") as tmp:
+            runner = Path(tmp) / "wasi_runner.js"
+            runner.write_text(WASI_RUNNER, encoding="utf-8")
+            wasi = RPCClient([node_bin, "--no-warnings", str(runner), str(module)])
+            wasi_ciphertext = dm_round_trip(self, wasi)
+            self.assertEqual(wasi.close(), 0)
+
+        native = RPCClient([str(self._harness_bin), "rpc"])
+        native_ciphertext = dm_round_trip(self, native)
+        self.assertEqual(native.close(), 0)
+        # Seeded participants make both builds produce the same ciphertext.
+        self.assertEqual(wasi_ciphertext, native_ciphertext)
+
+    def test_wasm_exchange_crosses_builds(self) -> None:
+        node_bin, module = self._wasi_module()
+        proc = run_harness(
+            ["wasm-exchange", "--module", module, "--node", node_bin, "--iterations", "3"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            timeout_s=120.0,
+        )
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        self.assertIn("wasm-exchange: PASS (layouts=2 calls=36 fields_compared=108 messages=12)", proc.stdout)
+
+        proc = run_harness(["wasm-exchange", "--module", self._harness_bin, "--node", node_bin], harness_bin=self._harness_bin, cwd=HARNESS_DIR)
+        self.assertEqual(proc.returncode, 1)
+        self.assertIn("module exited without answering", proc.stderr)
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,203 @@
+import json
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+from typing import Sequence
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+
+class TestMLSHarnessScenarios(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def _run_scenario(self, args: Sequence[str]) -> str:
+        proc = run_harness(
+            args,
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness {args[0]} failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        return proc.stdout
+
+    def test_commit_race_converges_for_either_winner(self) -> None:
+        for winner in ("alice", "bob"):
+            with self.subTest(winner=winner):
+                stdout = self._run_scenario(["commit-race", "--iterations", "5", "--winner", winner])
+                self.assertIn(f"winner={winner}", stdout)
+
+    def test_welcome_loss_recovers_with_fresh_keypackage(self) -> None:
+        stdout = self._run_scenario(["welcome-loss", "--epochs", "3", "--iterations", "2"])
+        self.assertIn("welcome-loss: stale join at epoch 2 refused at epoch 5", stdout)
+        self.assertIn("welcome-loss: recovered", stdout)
+
+    def test_delivery_order_permutations_converge(self) -> None:
+        # committer, two members and two joiners, each served the 6 orders of
+        # two commits and two Welcomes.
+        stdout = self._run_scenario(["delivery-order", "--members", "3", "--joiners", "2"])
+        self.assertIn("delivery-order: PASS (recipients=5 orders=6 runs=30 rejected=96 epoch=2)", stdout)
+
+        stdout = self._run_scenario(["delivery-order", "--members", "2", "--joiners", "1", "--duplicates=false"])
+        self.assertIn("delivery-order: PASS (recipients=3 orders=2 runs=6 rejected=8 epoch=2)", stdout)
+
+    def test_chaos_heals_dropped_commits(self) -> None:
+        stdout = self._run_scenario(
+            ["chaos", "--epochs", "12", "--iterations", "2", "--drop-commit-rate", "0.5", "--heal"]
+        )
+        self.assertIn("epoch desync", stdout)
+        self.assertRegex(stdout, r"dropped=(\d+) detected=\1 healed=\1")
+
+    def test_chaos_reports_epoch_desync_without_heal(self) -> None:
+        proc = run_harness(
+            ["chaos", "--epochs", "12", "--iterations", "2", "--drop-commit-rate", "1"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+        self.assertEqual(proc.returncode, 1, proc.stdout)
+        self.assertIn("epoch desync", proc.stderr)
+
+    def test_forward_secrecy_snapshot_cannot_read_later_epochs(self) -> None:
+        stdout = self._run_scenario(["forward-secrecy", "--epochs", "3", "--iterations", "2"])
+        self.assertIn("forward-secrecy: PASS (snapshot epoch=1 final epoch=4 rejected=6)", stdout)
+
+    def test_post_compromise_update_locks_out_leaked_state(self) -> None:
+        for committer in ("bob", "alice"):
+            with self.subTest(committer=committer):
+                stdout = self._run_scenario(["post-compromise", "--iterations", "2", "--committer", committer])
+                self.assertIn("snapshot bob-leaked (epoch 1): pre-update 2/2 decrypted, post-update 0/2 decrypted", stdout)
+                self.assertIn("snapshot bob-healed (epoch 2): pre-update 0/2 decrypted, post-update 2/2 decrypted", stdout)
+                self.assertIn(f"post-compromise: PASS (committer={committer}", stdout)
+
+    def test_multi_device_user_survives_device_churn(self) -> None:
+        stdout = self._run_scenario(["multi-device", "--iterations", "2"])
+        self.assertIn("multi-device: alice devices=2 members=3", stdout)
+
+    def test_scale_grows_group_in_batches(self) -> None:
+        # Timing-based growth limits are loose here; the subcommand default is the real gate.
+        stdout = self._run_scenario(["scale", "--max-members", "48", "--batch", "16", "--max-growth", "10"])
+        self.assertIn("members=48", stdout)
+        self.assertIn("scale: PASS", stdout)
+
+    def test_sizes_reports_every_artifact(self) -> None:
+        stdout = self._run_scenario(
+            ["sizes", "--suites", "X25519_AES128GCM_SHA256_Ed25519", "--group-sizes", "2,8", "--format", "json"]
+        )
+        report = json.loads(stdout)
+        self.assertEqual([row["members"] for row in report["rows"]], [2, 8])
+        small, large = report["rows"]
+        for field in ("keypackage_bytes", "welcome_bytes", "add_commit_bytes", "update_commit_bytes", "ciphertext_bytes"):
+            self.assertGreater(small[field], 0, field)
+        self.assertEqual(small["keypackage_bytes"], large["keypackage_bytes"])
+        self.assertGreater(large["welcome_bytes"], small["welcome_bytes"])
+
+    def test_kp_expiry_rejects_expired_and_future_keypackages(self) -> None:
+        stdout = self._run_scenario(["kp-expiry", "--lifetime", "10m", "--time-travel", "1h"])
+        self.assertIn("valid: PASS", stdout)
+        self.assertIn("expired: PASS (add rejected by lifetime validation)", stdout)
+        self.assertIn("not-yet-valid: PASS (add rejected by lifetime validation)", stdout)
+
+    def test_coverage_report_lists_exercised_operations(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            report_path = Path(tmp) / "coverage.json"
+            self._run_scenario(
+                ["welcome-loss", "--epochs", "2", "--iterations", "1", "--coverage-report", str(report_path)]
+            )
+            report = json.loads(report_path.read_text(encoding="utf-8"))
+
+        self.assertEqual(report["scenario"], "welcome-loss")
+        self.assertGreater(report["operations"]["add"], 0)
+        self.assertGreater(report["operations"]["remove"], 0)
+        self.assertGreater(report["operations"]["app_message"], 0)
+        self.assertIn("psk", report["missing"])
+        self.assertNotIn("remove", report["missing"])
+
+    def test_epoch_chain_matches_golden(self) -> None:
+        golden = HARNESS_DIR / "vectors" / "epoch-chain" / "chaos_heal_v1.json"
+        stdout = self._run_scenario(["chaos", "--heal", "--verify-epoch-chain", str(golden)])
+        self.assertIn("epoch-chain: PASS (21 epochs match", stdout)
+
+    def test_epoch_chain_detects_divergence(self) -> None:
+        golden = json.loads(
+            (HARNESS_DIR / "vectors" / "epoch-chain" / "chaos_heal_v1.json").read_text(encoding="utf-8")
+        )
+        golden["epochs"][3]["authenticator_hex"] = "00" * 32
+        with tempfile.TemporaryDirectory() as tmp:
+            tampered = Path(tmp) / "tampered.json"
+            tampered.write_text(json.dumps(golden), encoding="utf-8")
+            proc = run_harness(
+                ["chaos", "--heal", "--verify-epoch-chain", str(tampered)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+
+        self.assertNotEqual(proc.returncode, 0)
+        self.assertIn("epoch chain mismatch", proc.stderr)
+        self.assertIn("epoch 4: authenticator", proc.stderr)
+
+    def test_epoch_chain_records_every_epoch(self) -> None:
+        with tempfile.TemporaryDirectory() as tmp:
+            chain_path = Path(tmp) / "chain.json"
+            self._run_scenario(["multi-device", "--iterations", "1", "--epoch-chain", str(chain_path)])
+            chain = json.loads(chain_path.read_text(encoding="utf-8"))
+
+        self.assertEqual(chain["scenario"], "multi-device")
+        self.assertEqual([entry["epoch"] for entry in chain["epochs"]], [0, 1, 2, 3])
+
+
+    def test_scripted_scenario_reproduces_its_digest(self) -> None:
+        script = HARNESS_DIR / "vectors" / "scenarios" / "group_churn_v1.json"
+        stdout = self._run_scenario(["scenario", "--scenario-file", str(script)])
+        self.assertIn("checkpoint before-removal: members=4 epoch=4", stdout)
+        self.assertIn("scenario: PASS (name=group_churn_v1 steps=11 members=3 removed=1 epoch=5", stdout)
+
+    def test_scripted_scenario_checkpoints_to_state_dir(self) -> None:
+        script = HARNESS_DIR / "vectors" / "scenarios" / "group_churn_v1.json"
+        with tempfile.TemporaryDirectory() as tmp:
+            stdout = self._run_scenario(["scenario", "--scenario-file", str(script), "--state-dir", tmp])
+            files = sorted(path.name for path in Path(tmp).iterdir())
+
+        self.assertIn("scenario: PASS (name=group_churn_v1", stdout)
+        self.assertEqual(files, ["alice.gob", "bob.gob", "carol.gob", "dave.gob"])
+
+    def test_property_cases_keep_invariants(self) -> None:
+        stdout = self._run_scenario(["property", "--cases", "5", "--seed", "3", "--steps", "15"])
+        self.assertIn("property: PASS (cases=5 seeds=3..7", stdout)
+
+    def test_scripted_scenario_reports_the_failing_step(self) -> None:
+        script = json.loads(
+            (HARNESS_DIR / "vectors" / "scenarios" / "group_churn_v1.json").read_text(encoding="utf-8")
+        )
+        script["steps"][-1]["epoch"] = 6
+        with tempfile.TemporaryDirectory() as tmp:
+            path = Path(tmp) / "scenario.json"
+            path.write_text(json.dumps(script), encoding="utf-8")
+            proc = run_harness(
+                ["scenario", "--scenario-file", str(path)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+
+        self.assertEqual(proc.returncode, 1, proc.stdout)
+        self.assertIn("step 10 (assert epoch 6): alice is in epoch 5", proc.stderr)
+
+if __name__ == "__main__":
+    unittest.main()
@@ -0,0 +1,114 @@
+import base64
+import json
+import re
+import subprocess
+import sys
+import unittest
+import urllib.error
+import urllib.request
+from pathlib import Path
+from typing import Any, Dict, Optional, Tuple
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env
+
+
+class TestMLSHarnessServe(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def setUp(self) -> None:
+        self._proc = subprocess.Popen(
+            [str(self._harness_bin), "serve", "--addr", "127.0.0.1:0"],
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            stdout=subprocess.DEVNULL,
+            stderr=subprocess.PIPE,
+            text=True,
+        )
+        self.addCleanup(self._stop)
+        assert self._proc.stderr is not None
+        line = self._proc.stderr.readline()
+        match = re.search(r"http://(\S+)/v1/participants", line)
+        if not match:
+            self.fail(f"serve did not report its address: {line!r}")
+        self._base = f"http://{match.group(1)}/v1/participants"
+
+    def _stop(self) -> None:
+        self._proc.terminate()
+        try:
+            self._proc.wait(timeout=10)
+        except subprocess.TimeoutExpired:
+            self._proc.kill()
+            self._proc.wait()
+        if self._proc.stderr is not None:
+            self._proc.stderr.close()
+
+    def _post(self, path: str, body: Optional[Dict[str, Any]]) -> Tuple[int, Dict[str, Any]]:
+        data = json.dumps(body).encode("utf-8") if body is not None else None
+        request = urllib.request.Request(self._base + path, data=data, method="POST" if data is not None else "GET")
+        try:
+            with urllib.request.urlopen(request, timeout=30) as response:
+                return response.status, json.loads(response.read())
+        except urllib.error.HTTPError as exc:
+            return exc.code, json.loads(exc.read())
+
+    def _ok(self, path: str, body: Optional[Dict[str, Any]] = None) -> Dict[str, Any]:
+        status, payload = self._post(path, body)
+        self.assertEqual(status, 200, payload)
+        self.assertTrue(payload["ok"], payload)
+        return payload
+
+    def test_dm_round_trip_over_http(self) -> None:
+        self._ok("", {"name": "alice", "seed_int": 1})
+        bob_kp = self._ok("", {"name": "bob", "seed_int": 2})["keypackage_b64"]
+
+        group_id = base64.b64encode(b"serve-test").decode("ascii")
+        init = self._ok(
+            "/alice/dm-init",
+            {"peer_keypackage_b64": bob_kp, "group_id_b64": group_id, "seed_int": 3},
+        )
+        self._ok("/bob/join", {"welcome_b64": init["welcome_b64"]})
+        applied = self._ok("/alice/commit-apply", {"commit_b64": init["commit_b64"]})
+        self.assertFalse(applied["noop"])
+        self.assertFalse(applied["already_applied"])
+        # At-least-once delivery hands both members the commit, some of it twice.
+        for name in ("alice", "bob"):
+            again = self._ok(f"/{name}/commit-apply", {"commit_b64": init["commit_b64"]})
+            self.assertTrue(again["already_applied"])
+
+        ciphertext = self._ok("/alice/encrypt", {"plaintext": "hello over http"})["ciphertext_b64"]
+        decrypted = self._ok("/bob/decrypt", {"ciphertext_b64": ciphertext})
+        self.assertEqual(decrypted["plaintext"], "hello over http")
+
+        listing = self._ok("")
+        self.assertEqual(listing["participants"], ["alice", "bob"])
+
+    def test_errors_are_reported_as_json(self) -> None:
+        status, payload = self._post("/nobody/encrypt", {"plaintext": "x"})
+        self.assertEqual(status, 404)
+        self.assertFalse(payload["ok"])
+
+        self._ok("", {"name": "alice", "seed_int": 1})
+        status, payload = self._post("", {"name": "alice", "seed_int": 1})
+        self.assertEqual(status, 409)
+
+        status, payload = self._post("/alice/encrypt", {"unexpected": True})
+        self.assertEqual(status, 400)
+        self.assertIn("unknown field", payload["error"])
+
+        status, payload = self._post("/alice/decrypt", {"ciphertext_b64": "AAAA"})
+        self.assertEqual(status, 422)
+        self.assertFalse(payload["ok"])
+
+        status, payload = self._post("/alice/join", {"welcome_b64": "AAAA"})
+        self.assertEqual(status, 422)
+        self.assertEqual(payload["code"], "bad_welcome")
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -42,6 +42,30 @@ class TestMLSHarnessSmoke(unittest.TestCase):
                 f"stderr:\n{proc.stderr}\n"
             )
 
+    def test_smoke_runs_over_each_transport(self) -> None:
+        env = make_harness_env()
+
+        for transport in ("file", "tcp", "websocket"):
+            with self.subTest(transport=transport), tempfile.TemporaryDirectory() as state_dir:
+                proc = run_harness(
+                    [
+                        "smoke",
+                        "--iterations",
+                        "20",
+                        "--save-every",
+                        "10",
+                        "--state-dir",
+                        state_dir,
+                        "--transport",
+                        transport,
+                    ],
+                    harness_bin=self._harness_bin,
+                    cwd=HARNESS_DIR,
+                    env=env,
+                    timeout_s=120.0,
+                )
+                self.assertEqual(proc.returncode, 0, proc.stderr)
+
 
 if __name__ == "__main__":
     unittest.main()
@@ -1,6 +1,10 @@
+import json
+import subprocess
 import sys
 import tempfile
+import time
 import unittest
+import urllib.request
 from pathlib import Path
 
 TESTS_DIR = Path(__file__).resolve().parent
@@ -10,6 +14,17 @@ if str(TESTS_DIR) not in sys.path:
 from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
 
 
+def _scrape(url: str) -> dict:
+    with urllib.request.urlopen(url, timeout=5) as resp:
+        text = resp.read().decode()
+    series = {}
+    for line in text.splitlines():
+        if line and not line.startswith("#"):
+            name, _, value = line.rpartition(" ")
+            series[name] = float(value)
+    return series
+
+
 class TestMLSHarnessSoakLite(unittest.TestCase):
     @classmethod
     def setUpClass(cls) -> None:
@@ -42,6 +57,173 @@ class TestMLSHarnessSoakLite(unittest.TestCase):
                 f"stderr:\n{proc.stderr}\n"
             )
 
+    def test_soak_retains_limited_checkpoints(self) -> None:
+        env = make_harness_env()
+
+        with tempfile.TemporaryDirectory() as state_dir:
+            proc = run_harness(
+                [
+                    "soak",
+                    "--iterations",
+                    "30",
+                    "--save-every",
+                    "5",
+                    "--state-dir",
+                    state_dir,
+                    "--keep-checkpoints",
+                    "2",
+                    "--compress-checkpoints",
+                ],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=env,
+                timeout_s=120.0,
+            )
+            if proc.returncode != 0:
+                self.fail(
+                    f"mls-harness soak with checkpoints failed with code {proc.returncode}\n"
+                    f"stdout:\n{proc.stdout}\n"
+                    f"stderr:\n{proc.stderr}\n"
+                )
+
+            listed = run_harness(
+                ["checkpoints", "list", "--state-dir", state_dir],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=env,
+                timeout_s=60.0,
+            )
+
+        self.assertEqual(listed.returncode, 0, listed.stderr)
+        lines = listed.stdout.strip().splitlines()
+        self.assertEqual(len(lines), 2)
+        self.assertIn("iteration=25", lines[0])
+        self.assertIn("iteration=30", lines[1])
+        self.assertIn("alice.gob.gz", lines[1])
+
+    def test_soak_metrics_come_from_harness_sink(self) -> None:
+        with tempfile.TemporaryDirectory() as state_dir:
+            proc = subprocess.Popen(
+                [
+                    str(self._harness_bin),
+                    "soak",
+                    "--iterations",
+                    "20",
+                    "--save-every",
+                    "10",
+                    "--state-dir",
+                    state_dir,
+                    "--metrics-addr",
+                    "127.0.0.1:0",
+                    "--metrics-linger",
+                    "30s",
+                    "--log-format",
+                    "json",
+                ],
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                stdout=subprocess.DEVNULL,
+                stderr=subprocess.PIPE,
+                text=True,
+            )
+            try:
+                url = None
+                for line in proc.stderr:
+                    record = json.loads(line)
+                    if record["msg"] == "serving soak metrics":
+                        url = record["url"]
+                        break
+                self.assertIsNotNone(url, "metrics server never started")
+
+                deadline = time.monotonic() + 60
+                series = _scrape(url)
+                while series["mls_harness_soak_iterations_total"] < 20 and time.monotonic() < deadline:
+                    time.sleep(0.1)
+                    series = _scrape(url)
+            finally:
+                proc.kill()
+                proc.wait()
+
+        self.assertEqual(series["mls_harness_soak_iterations_total"], 20)
+        self.assertEqual(series["mls_harness_soak_commits_total"], 1)
+        self.assertEqual(series["mls_harness_soak_commit_seconds_count"], 1)
+        self.assertEqual(series["mls_harness_soak_join_seconds_count"], 1)
+        self.assertEqual(series["mls_harness_soak_protect_seconds_count"], 40)
+        self.assertEqual(series["mls_harness_soak_unprotect_seconds_count"], 40)
+        self.assertGreater(series["mls_harness_soak_ciphertext_bytes_total"], 40 * 16)
+
+    def test_soak_fails_when_state_outgrows_its_limits(self) -> None:
+        # go-mls keeps the key of every message a member sends, one per
+        # iteration in a soak that never commits.
+        with tempfile.TemporaryDirectory() as state_dir:
+            proc = run_harness(
+                ["soak", "--iterations", "20", "--save-every", "5", "--state-dir", state_dir, "--max-own-cached-keys", "8"],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+        self.assertEqual(proc.returncode, 1, proc.stderr)
+        self.assertIn("iteration 8: state in epoch 1 over its limits", proc.stderr)
+        self.assertIn("keys cached by the application ratchet of leaf 0 9 > 8", proc.stderr)
+
+    def test_soak_logs_json_records(self) -> None:
+        env = make_harness_env()
+
+        with tempfile.TemporaryDirectory() as state_dir:
+            proc = run_harness(
+                [
+                    "soak",
+                    "--iterations",
+                    "10",
+                    "--save-every",
+                    "5",
+                    "--state-dir",
+                    state_dir,
+                    "--log-format",
+                    "json",
+                    "--log-level=debug",
+                ],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=env,
+                timeout_s=120.0,
+            )
+
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        records = [json.loads(line) for line in proc.stderr.splitlines()]
+        self.assertTrue(all(r["command"] == "soak" for r in records))
+        checkpoints = [r for r in records if r["msg"] == "checkpoint saved"]
+        self.assertEqual([r["iteration"] for r in checkpoints], [5, 10])
+        iterations = [r for r in records if r["level"] == "DEBUG"]
+        self.assertEqual(len(iterations), 10)
+        self.assertEqual(iterations[0]["participant"], "alice")
+        self.assertIn("epoch", iterations[0])
+
+    def test_failure_is_logged_as_json_error(self) -> None:
+        proc = run_harness(
+            ["soak", "--log-format=json", "--iterations", "0", "--state-dir", "unused"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=60.0,
+        )
+        self.assertEqual(proc.returncode, 1)
+        record = json.loads(proc.stderr.strip().splitlines()[-1])
+        self.assertEqual(record["level"], "ERROR")
+        self.assertEqual(record["msg"], "scenario failed")
+        self.assertIn("iterations must be positive", record["err"])
+
+        bad = run_harness(
+            ["soak", "--log-level", "loud"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=60.0,
+        )
+        self.assertEqual(bad.returncode, 2)
+        self.assertIn("log-level must be", bad.stderr)
+
 
 if __name__ == "__main__":
     unittest.main()
@@ -0,0 +1,77 @@
+import base64
+import json
+import shutil
+import sys
+import tempfile
+import unittest
+from pathlib import Path
+
+TESTS_DIR = Path(__file__).resolve().parent
+if str(TESTS_DIR) not in sys.path:
+    sys.path.insert(0, str(TESTS_DIR))
+
+from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
+
+
+class TestMLSHarnessStateCompat(unittest.TestCase):
+    @classmethod
+    def setUpClass(cls) -> None:
+        cls._harness_bin = ensure_harness_binary(timeout_s=180.0)
+
+    def test_state_compat_fixtures_decode(self) -> None:
+        env = make_harness_env()
+
+        proc = run_harness(
+            ["state-compat", "--iterations", "3"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=env,
+            timeout_s=120.0,
+        )
+
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness state-compat failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        self.assertIn("state-compat: PASS", proc.stdout)
+        self.assertIn("gob_v1: PASS", proc.stdout)
+        self.assertIn("mlsp_v1: PASS", proc.stdout)
+
+    def test_gob_participant_migrates_on_write(self) -> None:
+        fixture = HARNESS_DIR / "vectors" / "state-compat" / "gob_v1" / "initiator.participant"
+        with tempfile.TemporaryDirectory() as tmp:
+            participant = Path(tmp) / "participant.gob"
+            shutil.copyfile(fixture, participant)
+            self.assertFalse(base64.b64decode(participant.read_text()).startswith(b"MLSP"))
+
+            proc = run_harness(
+                ["dm-encrypt", "--state-dir", tmp, "--plaintext", "migrate"],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=60.0,
+            )
+            self.assertEqual(proc.returncode, 0, proc.stderr)
+            self.assertTrue(base64.b64decode(participant.read_text()).startswith(b"MLSP\x01\x01"))
+
+    def test_shared_secret_splits_on_keypackage(self) -> None:
+        fixture = HARNESS_DIR / "vectors" / "state-compat" / "gob_v1" / "initiator.participant"
+        with tempfile.TemporaryDirectory() as tmp:
+            shutil.copyfile(fixture, Path(tmp) / "participant.gob")
+
+            def run(args):
+                proc = run_harness(args, harness_bin=self._harness_bin, cwd=HARNESS_DIR, env=make_harness_env(), timeout_s=60.0)
+                self.assertEqual(proc.returncode, 0, proc.stderr)
+                return proc.stdout.strip()
+
+            first = run(["dm-keypackage", "--state-dir", tmp, "--name", "initiator", "--seed", "5"])
+            self.assertEqual(run(["dm-keypackage", "--state-dir", tmp, "--name", "initiator", "--seed", "6"]), first)
+            pool = json.loads(run(["dm-keypackage-pool", "--state-dir", tmp, "--count", "0"]))
+            self.assertEqual((pool["available"], pool["consumed"]), (1, 0))
+            run(["dm-encrypt", "--state-dir", tmp, "--plaintext", "still a member"])
+
+
+if __name__ == "__main__":
+    unittest.main()
@@ -1,4 +1,8 @@
+import hashlib
+import json
+import struct
 import sys
+import tempfile
 import unittest
 from pathlib import Path
 
@@ -9,6 +13,26 @@ if str(TESTS_DIR) not in sys.path:
 from mls_harness_util import HARNESS_DIR, ensure_harness_binary, make_harness_env, run_harness
 
 
+def _read_transcript(data: bytes) -> list:
+    # Parsed from the format spec rather than the Go code, the way a port would.
+    if data[:5] != b"MLST\x01":
+        raise ValueError("not a version 1 transcript")
+    (body_len,) = struct.unpack(">I", data[5:9])
+    if 9 + body_len != len(data):
+        raise ValueError("transcript length mismatch")
+    entries = []
+    offset = 9
+    while offset < len(data):
+        entry_type = data[offset]
+        label_len = data[offset + 1]
+        label = data[offset + 2 : offset + 2 + label_len]
+        offset += 2 + label_len
+        (data_len,) = struct.unpack(">I", data[offset : offset + 4])
+        entries.append((entry_type, label, data[offset + 4 : offset + 4 + data_len]))
+        offset += 4 + data_len
+    return entries
+
+
 class TestMLSHarnessVectors(unittest.TestCase):
     @classmethod
     def setUpClass(cls) -> None:
@@ -32,6 +56,179 @@ class TestMLSHarnessVectors(unittest.TestCase):
                 f"stderr:\n{proc.stderr}\n"
             )
 
+    def test_vectors_generate_reproduces_committed_vector(self) -> None:
+        proc = run_harness(
+            ["vectors", "--generate", "dm_smoke_v1", "--iterations", "20"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness vectors --generate failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        committed = (Path(HARNESS_DIR) / "vectors" / "dm_smoke_v1.json").read_text(encoding="utf-8")
+        self.assertEqual(proc.stdout.strip(), committed.strip())
+
+    def test_vectors_v2_digest_matches(self) -> None:
+        proc = run_harness(
+            ["vectors", "--vector-file", "./vectors/dm_group_v2.json"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        self.assertEqual(proc.stdout.strip(), "ok")
+
+    def test_vectors_generate_reproduces_committed_v2_vector(self) -> None:
+        committed = json.loads((Path(HARNESS_DIR) / "vectors" / "dm_group_v2.json").read_text(encoding="utf-8"))
+        payload = committed["payload"]
+        proc = run_harness(
+            [
+                "vectors",
+                "--generate",
+                committed["name"],
+                "--format-version",
+                "2",
+                "--cipher-suite",
+                committed["cipher_suite"],
+                "--participants",
+                str(committed["participants"]),
+                "--iterations",
+                str(committed["iterations"]),
+                "--payload",
+                payload["kind"],
+                "--payload-min-bytes",
+                str(payload["min_bytes"]),
+                "--payload-max-bytes",
+                str(payload["max_bytes"]),
+                "--payload-seed",
+                str(payload["seed"]),
+                "--entry-digests",
+            ],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+
+        self.assertEqual(proc.returncode, 0, proc.stderr)
+        self.assertEqual(json.loads(proc.stdout), committed)
+
+    def test_vectors_v1_rejects_v2_fields(self) -> None:
+        spec = json.loads((Path(HARNESS_DIR) / "vectors" / "dm_smoke_v1.json").read_text(encoding="utf-8"))
+        spec["participants"] = 3
+        with tempfile.TemporaryDirectory() as tmp:
+            path = Path(tmp) / "vector.json"
+            path.write_text(json.dumps(spec), encoding="utf-8")
+            proc = run_harness(
+                ["vectors", "--vector-file", str(path)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+
+        self.assertNotEqual(proc.returncode, 0)
+        self.assertIn("participants and payload need format_version 2", proc.stderr)
+
+    def test_vectors_mismatch_reports_first_diverging_entry(self) -> None:
+        spec = json.loads((Path(HARNESS_DIR) / "vectors" / "dm_group_v2.json").read_text(encoding="utf-8"))
+        spec["payload"]["seed"] += 1
+        with tempfile.TemporaryDirectory() as tmp:
+            path = Path(tmp) / "vector.json"
+            path.write_text(json.dumps(spec), encoding="utf-8")
+            proc = run_harness(
+                ["vectors", "--vector-file", str(path)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+
+        self.assertNotEqual(proc.returncode, 0)
+        # The bootstrap matches; the first payload is the first entry to differ.
+        self.assertIn("first divergence at entry 10 (iter-0-member-0)", proc.stderr)
+
+    def test_vectors_mismatch_diagnosed_against_reference_transcript(self) -> None:
+        spec = json.loads((Path(HARNESS_DIR) / "vectors" / "dm_smoke_v1.json").read_text(encoding="utf-8"))
+        spec["iterations"] -= 1
+        with tempfile.TemporaryDirectory() as tmp:
+            reference = Path(tmp) / "reference.bin"
+            proc = run_harness(
+                ["vectors", "--vector-file", "./vectors/dm_smoke_v1.json", "--transcript-out", str(reference)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+            self.assertEqual(proc.returncode, 0, proc.stderr)
+            path = Path(tmp) / "vector.json"
+            path.write_text(json.dumps(spec), encoding="utf-8")
+            proc = run_harness(
+                ["vectors", "--vector-file", str(path), "--reference-transcript", str(reference)],
+                harness_bin=self._harness_bin,
+                cwd=HARNESS_DIR,
+                env=make_harness_env(),
+                timeout_s=120.0,
+            )
+
+        self.assertNotEqual(proc.returncode, 0)
+        self.assertIn("computed transcript ends after 44 entries, expected 46", proc.stderr)
+
+    def test_vectors_transcript_recomputes_digest(self) -> None:
+        for name in ("dm_smoke_v1.json", "dm_group_v2.json"):
+            spec = json.loads((Path(HARNESS_DIR) / "vectors" / name).read_text(encoding="utf-8"))
+            with tempfile.TemporaryDirectory() as tmp:
+                path = Path(tmp) / "transcript.bin"
+                proc = run_harness(
+                    ["vectors", "--vector-file", f"./vectors/{name}", "--transcript-out", str(path)],
+                    harness_bin=self._harness_bin,
+                    cwd=HARNESS_DIR,
+                    env=make_harness_env(),
+                    timeout_s=120.0,
+                )
+                self.assertEqual(proc.returncode, 0, proc.stderr)
+                entries = _read_transcript(path.read_bytes())
+
+                check = run_harness(
+                    ["transcript", "--file", str(path), "--digest", spec["digest_sha256_hex"]],
+                    harness_bin=self._harness_bin,
+                    cwd=HARNESS_DIR,
+                    env=make_harness_env(),
+                    timeout_s=120.0,
+                )
+                self.assertEqual(check.returncode, 0, check.stderr)
+
+            digest = hashlib.sha256()
+            for _, label, data in entries:
+                digest.update(label + struct.pack(">I", len(data)) + data)
+            self.assertEqual(digest.hexdigest(), spec["digest_sha256_hex"], name)
+            self.assertTrue(all(1 <= entry_type <= 5 for entry_type, _, _ in entries), name)
+
+    def test_diff_crypto_matches_go_mls(self) -> None:
+        proc = run_harness(
+            ["diff-crypto", "--cases", "50", "--seed", "7"],
+            harness_bin=self._harness_bin,
+            cwd=HARNESS_DIR,
+            env=make_harness_env(),
+            timeout_s=120.0,
+        )
+
+        if proc.returncode != 0:
+            self.fail(
+                f"mls-harness diff-crypto failed with code {proc.returncode}\n"
+                f"stdout:\n{proc.stdout}\n"
+                f"stderr:\n{proc.stderr}\n"
+            )
+        self.assertIn("diff-crypto: PASS", proc.stdout)
+
 
 if __name__ == "__main__":
     unittest.main()
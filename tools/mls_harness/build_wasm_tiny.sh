@@ -0,0 +1,26 @@
+#!/usr/bin/env bash
+set -euo pipefail
+
+script_dir="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+repo_root="$(cd "${script_dir}/../.." && pwd)"
+vendor_dir="${repo_root}/clients/web/vendor"
+
+mkdir -p "${vendor_dir}"
+
+: "${GOFLAGS:=-mod=vendor}"
+: "${GOTOOLCHAIN:=local}"
+
+export GOFLAGS
+export GOTOOLCHAIN
+
+# The wasm target sets GOOS=js GOARCH=wasm and the tinygo build tag, which
+# selects the reduced binding set in cmd/mls-wasm/register_tiny.go.
+(cd "${script_dir}" && tinygo build -target wasm -opt z -no-debug -o "${vendor_dir}/mls_harness_tiny.wasm" ./cmd/mls-wasm)
+
+# A TinyGo module needs TinyGo's wasm_exec.js, not the Go release's.
+tinygo_exec="$(tinygo env TINYGOROOT)/targets/wasm_exec.js"
+if [[ ! -f "${tinygo_exec}" ]]; then
+echo "wasm_exec.js not found in ${tinygo_exec}" >&2
+exit 1
+fi
+cp "${tinygo_exec}" "${vendor_dir}/wasm_exec_tiny.js"
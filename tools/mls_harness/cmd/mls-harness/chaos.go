@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// epochDesyncError is the diagnosis reported when a member cannot decrypt because
+// it never applied a commit the sender already moved past.
+type epochDesyncError struct {
+	member       string
+	memberEpoch  mls.Epoch
+	messageEpoch mls.Epoch
+	cause        error
+}
+
+func (e *epochDesyncError) Error() string {
+	return fmt.Sprintf("epoch desync: %s is at epoch %d but received a message for epoch %d (missing %d commit(s)): %v",
+		e.member, e.memberEpoch, e.messageEpoch, int64(e.messageEpoch)-int64(e.memberEpoch), e.cause)
+}
+
+func (e *epochDesyncError) Unwrap() error { return e.cause }
+
+// chaosStats summarises what a chaos run injected and recovered from.
+type chaosStats struct {
+	commits  int
+	dropped  int
+	detected int
+	healed   int
+}
+
+// runChaos advances a pair through epochs, randomly withholding commits from the
+// non-committing member. Every drop must surface as an epoch desync on the next
+// message; with heal set the withheld commit is re-delivered and the run continues.
+func runChaos(epochs, iterations int, dropRate float64, heal bool, seed int64) error {
+	if epochs <= 0 {
+		return fmt.Errorf("epochs must be positive (got %d)", epochs)
+	}
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+	if dropRate < 0 || dropRate > 1 {
+		return fmt.Errorf("drop-commit-rate must be between 0 and 1 (got %g)", dropRate)
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	// Chaos decisions use their own stream so the crypto RNG sequence only
+	// depends on which operations run, not on how the dice were rolled.
+	chaos := rand.New(rand.NewSource(seed))
+
+	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
+	members := []*harness.Participant{alice, bob}
+
+	stats := chaosStats{}
+	for epoch := 0; epoch < epochs; epoch++ {
+		committerIdx := chaos.Intn(len(members))
+		committer, other := members[committerIdx], members[1-committerIdx]
+
+		commit, _, next, err := committer.State.Commit(harness.RandomBytes(rng, 32))
+		if err != nil {
+			return fmt.Errorf("epoch %d %s commit: %w", epoch, committer.Name, err)
+		}
+		committer.State = next
+		epochChain.observe(committer)
+		stats.commits++
+		coverage.record(opCommit)
+
+		var withheld *mls.MLSPlaintext
+		if chaos.Float64() < dropRate {
+			withheld = commit
+			stats.dropped++
+		} else if err := deliverCommit(other, commit); err != nil {
+			return fmt.Errorf("epoch %d: %w", epoch, err)
+		}
+
+		for i := 0; i < iterations; i++ {
+			payload := []byte(fmt.Sprintf("chaos-%d-%d", epoch, i))
+			err := exchangeWithDiagnosis(committer, other, payload)
+			var desync *epochDesyncError
+			switch {
+			case err == nil && withheld != nil:
+				return fmt.Errorf("epoch %d: %s decrypted despite a withheld commit", epoch, other.Name)
+			case err == nil:
+			case errors.As(err, &desync) && withheld != nil:
+				stats.detected++
+				if !heal {
+					return fmt.Errorf("epoch %d: %w", epoch, desync)
+				}
+				fmt.Printf("chaos: epoch %d: %v; re-delivering commit\n", epoch, desync)
+				if err := deliverCommit(other, withheld); err != nil {
+					return fmt.Errorf("epoch %d heal: %w", epoch, err)
+				}
+				withheld = nil
+				stats.healed++
+				if err := exchangeWithDiagnosis(committer, other, payload); err != nil {
+					return fmt.Errorf("epoch %d after heal: %w", epoch, err)
+				}
+			default:
+				return fmt.Errorf("epoch %d iteration %d: %w", epoch, i, err)
+			}
+
+			if err := exchangeWithDiagnosis(other, committer, payload); err != nil {
+				return fmt.Errorf("epoch %d iteration %d: %w", epoch, i, err)
+			}
+		}
+	}
+
+	if alice.State.Epoch != bob.State.Epoch {
+		return fmt.Errorf("epochs did not converge: %s=%d %s=%d", alice.Name, alice.State.Epoch, bob.Name, bob.State.Epoch)
+	}
+	fmt.Printf("chaos: commits=%d dropped=%d detected=%d healed=%d epoch=%d\n", stats.commits, stats.dropped, stats.detected, stats.healed, alice.State.Epoch)
+	return nil
+}
+
+func deliverCommit(receiver *harness.Participant, commit *mls.MLSPlaintext) error {
+	if err := harness.DeliverCommit([]*harness.Participant{receiver}, commit); err != nil {
+		return err
+	}
+	epochChain.observe(receiver)
+	return nil
+}
+
+// exchangeWithDiagnosis is harness.ExchangeOnce that turns an unprotect failure
+// across mismatched epochs into an epochDesyncError.
+func exchangeWithDiagnosis(sender, receiver *harness.Participant, msg []byte) error {
+	ct, err := sender.State.Protect(msg)
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
+	}
+	pt, err := receiver.State.Unprotect(ct)
+	if err != nil {
+		if ct.Epoch != receiver.State.Epoch {
+			return &epochDesyncError{member: receiver.Name, memberEpoch: receiver.State.Epoch, messageEpoch: ct.Epoch, cause: err}
+		}
+		return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
+	}
+	if string(pt) != string(msg) {
+		return fmt.Errorf("plaintext mismatch for %s -> %s", sender.Name, receiver.Name)
+	}
+	coverage.appMessage(sender.State.Epoch)
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+func runCheckpointsList(stateDir string) error {
+	if stateDir == "" {
+		return errors.New("state-dir is required")
+	}
+	checkpoints, err := harness.ListCheckpoints(stateDir)
+	if err != nil {
+		return err
+	}
+	if len(checkpoints) == 0 {
+		fmt.Println("no checkpoints")
+		return nil
+	}
+	for _, cp := range checkpoints {
+		fmt.Printf("%s\titeration=%d\ttaken=%s\tbytes=%d\tfiles=%s\n", cp.Name, cp.Iteration, cp.Taken.Format(time.RFC3339), cp.Bytes, strings.Join(cp.Files, ","))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// racingCommit is a commit a member has sent but not yet seen confirmed by the
+// delivery service, mirroring dm.PendingCommit for harness-level participants.
+type racingCommit struct {
+	commit    *mls.MLSPlaintext
+	nextState *mls.State
+}
+
+// runCommitRace has both members of a pair commit in the same epoch. The delivery
+// service orders the winner's commit first; the loser must discard its pending
+// state and apply the winner's commit before messaging can converge.
+func runCommitRace(iterations int, winnerName string) error {
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
+
+	var winner, loser *harness.Participant
+	switch winnerName {
+	case "", alice.Name:
+		winner, loser = alice, bob
+	case bob.Name:
+		winner, loser = bob, alice
+	default:
+		return fmt.Errorf("winner must be %q or %q (got %q)", alice.Name, bob.Name, winnerName)
+	}
+
+	startEpoch := alice.State.Epoch
+	if bob.State.Epoch != startEpoch {
+		return fmt.Errorf("bootstrap epoch mismatch: %s=%d %s=%d", alice.Name, alice.State.Epoch, bob.Name, bob.State.Epoch)
+	}
+
+	winnerPending, err := commitInPlace(rng, winner)
+	if err != nil {
+		return err
+	}
+	loserPending, err := commitInPlace(rng, loser)
+	if err != nil {
+		return err
+	}
+	if winnerPending.commit.Epoch != loserPending.commit.Epoch {
+		return fmt.Errorf("racing commits target different epochs: %d vs %d", winnerPending.commit.Epoch, loserPending.commit.Epoch)
+	}
+
+	// The winner sees its own commit reflected and adopts the cached next state.
+	winner.State = winnerPending.nextState
+	epochChain.observe(winner)
+
+	// A loser that kept its pending state would diverge; prove it cannot talk to the winner.
+	diverged := &harness.Participant{Name: loser.Name + "-stale", Session: harness.Session{State: loserPending.nextState}}
+	if err := harness.ExchangeOnce(diverged, winner, []byte("stale-pending")); err == nil {
+		return errors.New("stale pending state unexpectedly decrypted by winner")
+	}
+
+	// The loser discards its pending commit and applies the winner's commit instead.
+	next, err := loser.State.Handle(winnerPending.commit)
+	if err != nil {
+		return fmt.Errorf("%s apply winning commit: %w", loser.Name, err)
+	}
+	if next == nil {
+		return fmt.Errorf("%s apply winning commit: no state transition", loser.Name)
+	}
+	loser.State = next
+	epochChain.observe(loser)
+
+	// The losing commit is now stale for the winner and must be rejected.
+	if _, err := winner.State.Handle(loserPending.commit); err == nil {
+		return fmt.Errorf("%s accepted the losing commit from %s", winner.Name, loser.Name)
+	}
+
+	if winner.State.Epoch != startEpoch+1 || loser.State.Epoch != startEpoch+1 {
+		return fmt.Errorf("epochs did not converge: %s=%d %s=%d", winner.Name, winner.State.Epoch, loser.Name, loser.State.Epoch)
+	}
+
+	for i := 0; i < iterations; i++ {
+		payload := []byte(fmt.Sprintf("race-%d", i))
+		if err := exchangeOnce(winner, loser, payload); err != nil {
+			return fmt.Errorf("iteration %d %s->%s: %w", i, winner.Name, loser.Name, err)
+		}
+		if err := exchangeOnce(loser, winner, payload); err != nil {
+			return fmt.Errorf("iteration %d %s->%s: %w", i, loser.Name, winner.Name, err)
+		}
+	}
+
+	fmt.Printf("commit-race: winner=%s loser=%s epoch=%d\n", winner.Name, loser.Name, winner.State.Epoch)
+	return nil
+}
+
+func commitInPlace(rng io.Reader, participant *harness.Participant) (*racingCommit, error) {
+	commit, _, next, err := participant.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return nil, fmt.Errorf("%s commit: %w", participant.Name, err)
+	}
+	coverage.record(opCommit)
+	return &racingCommit{commit: commit, nextState: next}, nil
+}
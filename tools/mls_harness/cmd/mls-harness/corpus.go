@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// defaultCorpusScenarios is where the bundled scenarios live, relative to the
+// harness module.
+const defaultCorpusScenarios = "vectors/scenarios/*.json"
+
+// runCorpus writes a golden artifact corpus to outDir for every suite and
+// scenario and prints a count of samples per kind.
+func runCorpus(outDir, suiteNames, scenarioFiles string) error {
+	if outDir == "" {
+		return errors.New("out is required")
+	}
+	suites, err := parseDoctorSuites(suiteNames)
+	if err != nil {
+		return err
+	}
+	scenarios, err := loadCorpusScenarios(scenarioFiles)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return fmt.Errorf("create corpus dir: %w", err)
+	}
+	manifest, err := harness.GenerateCorpus(outDir, suites, scenarios)
+	if manifest != nil {
+		fmt.Printf("corpus: %s (samples=%d %s)\n", filepath.Join(outDir, harness.CorpusManifestName), len(manifest.Samples), corpusKindCounts(manifest))
+	}
+	return err
+}
+
+// runVerifyCorpus re-reads a corpus, checking every sample against the
+// manifest and decoding it with this build's go-mls.
+func runVerifyCorpus(dir string) error {
+	manifest, samples, err := harness.LoadCorpus(dir)
+	if err != nil {
+		return err
+	}
+	for i, sample := range manifest.Samples {
+		if err := harness.DecodeCorpusSample(sample.Kind, samples[i]); err != nil {
+			return fmt.Errorf("%s: %w", sample.Path, err)
+		}
+	}
+	fmt.Printf("corpus: PASS (samples=%d %s)\n", len(manifest.Samples), corpusKindCounts(manifest))
+	return nil
+}
+
+// loadCorpusScenarios loads the comma-separated scenario files, each of which
+// may be a glob, defaulting to the bundled scenarios.
+func loadCorpusScenarios(files string) ([]*harness.Scenario, error) {
+	if files == "" {
+		files = defaultCorpusScenarios
+	}
+	var scenarios []*harness.Scenario
+	for _, pattern := range strings.Split(files, ",") {
+		paths, err := filepath.Glob(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("scenario files %q: %w", pattern, err)
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("no scenario files match %q", pattern)
+		}
+		for _, path := range paths {
+			scenario, err := harness.LoadScenario(path)
+			if err != nil {
+				return nil, err
+			}
+			scenarios = append(scenarios, scenario)
+		}
+	}
+	return scenarios, nil
+}
+
+func corpusKindCounts(manifest *harness.CorpusManifest) string {
+	counts := map[string]int{}
+	for _, sample := range manifest.Samples {
+		counts[sample.Kind]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, 0, len(kinds))
+	for _, kind := range kinds {
+		parts = append(parts, fmt.Sprintf("%s=%d", kind, counts[kind]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// corpusFuzzSeeds returns the samples of a corpus keyed by the fuzz target
+// that reads their kind.
+func corpusFuzzSeeds(dir string) (map[string][][]byte, error) {
+	manifest, samples, err := harness.LoadCorpus(dir)
+	if err != nil {
+		return nil, err
+	}
+	seeds := map[string][][]byte{}
+	for i, sample := range manifest.Samples {
+		switch sample.Kind {
+		case harness.CorpusKeyPackage, harness.CorpusWelcome, harness.CorpusCiphertext:
+			seeds[sample.Kind] = append(seeds[sample.Kind], samples[i])
+		}
+	}
+	return seeds, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// MLS operations tracked by --coverage-report. Operations go-mls cannot perform
+// (PSK, external join, reinit) are still listed so the report shows them as gaps.
+const (
+	opAdd          = "add"
+	opUpdate       = "update"
+	opRemove       = "remove"
+	opPSK          = "psk"
+	opExternalJoin = "external_join"
+	opReinit       = "reinit"
+	opCommit       = "commit"
+	opWelcomeJoin  = "welcome_join"
+	opAppMessage   = "app_message"
+)
+
+var coverageOperations = []string{opAdd, opUpdate, opRemove, opPSK, opExternalJoin, opReinit, opCommit, opWelcomeJoin, opAppMessage}
+
+// coverageRecorder counts the MLS operations a run exercised. Like soakMetrics,
+// a nil recorder ignores every call so scenarios can record unconditionally.
+type coverageRecorder struct {
+	scenario    string
+	operations  map[string]int
+	appPerEpoch map[mls.Epoch]int
+}
+
+type coverageReport struct {
+	Scenario            string         `json:"scenario"`
+	Operations          map[string]int `json:"operations"`
+	AppMessagesPerEpoch map[string]int `json:"app_messages_per_epoch"`
+	Missing             []string       `json:"missing"`
+}
+
+// coverage is the recorder for the current subcommand; nil unless --coverage-report is set.
+var coverage *coverageRecorder
+
+func startCoverage(scenario, path string) {
+	if path == "" {
+		return
+	}
+	coverage = &coverageRecorder{
+		scenario:    scenario,
+		operations:  map[string]int{},
+		appPerEpoch: map[mls.Epoch]int{},
+	}
+}
+
+func (c *coverageRecorder) record(op string) {
+	if c == nil {
+		return
+	}
+	c.operations[op]++
+}
+
+func (c *coverageRecorder) appMessage(epoch mls.Epoch) {
+	if c == nil {
+		return
+	}
+	c.operations[opAppMessage]++
+	c.appPerEpoch[epoch]++
+}
+
+// bootstrap records the operations harness.BootstrapPairWithDigest performs.
+func (c *coverageRecorder) bootstrap() {
+	c.record(opAdd)
+	c.record(opCommit)
+	c.record(opWelcomeJoin)
+}
+
+func (c *coverageRecorder) report() coverageReport {
+	out := coverageReport{
+		Scenario:            c.scenario,
+		Operations:          map[string]int{},
+		AppMessagesPerEpoch: map[string]int{},
+		Missing:             []string{},
+	}
+	for _, op := range coverageOperations {
+		out.Operations[op] = c.operations[op]
+		if c.operations[op] == 0 {
+			out.Missing = append(out.Missing, op)
+		}
+	}
+	epochs := make([]mls.Epoch, 0, len(c.appPerEpoch))
+	for epoch := range c.appPerEpoch {
+		epochs = append(epochs, epoch)
+	}
+	sort.Slice(epochs, func(i, j int) bool { return epochs[i] < epochs[j] })
+	for _, epoch := range epochs {
+		out.AppMessagesPerEpoch[strconv.FormatUint(uint64(epoch), 10)] = c.appPerEpoch[epoch]
+	}
+	return out
+}
+
+// finishCoverage writes the report for the current run, if one was requested.
+// It runs whether or not the scenario passed so failures still show what was reached.
+func finishCoverage(path string) {
+	if coverage == nil || path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(coverage.report(), "", "  ")
+	if err == nil {
+		data = append(data, '\n')
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		logger.Error("failed to write coverage report", "err", err)
+	}
+}
+
+// exchangeOnce is harness.ExchangeOnce that also records the application message.
+func exchangeOnce(sender, receiver *harness.Participant, msg []byte) error {
+	if err := harness.ExchangeOnce(sender, receiver, msg); err != nil {
+		return err
+	}
+	coverage.appMessage(sender.State.Epoch)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// runDeliveryOrder builds a group of members, has its first member add
+// joiners in one commit, and hands that commit and its Welcome to everyone in
+// every order, with duplicates if asked, checking each run converges or
+// rejects what it must.
+func runDeliveryOrder(members, joiners int, duplicates bool, suiteName string) error {
+	if members < 2 {
+		return fmt.Errorf("members must be at least 2 (got %d)", members)
+	}
+	if joiners < 1 {
+		return fmt.Errorf("joiners must be at least 1 (got %d)", joiners)
+	}
+	suite, ok := harness.CipherSuiteByName(suiteName)
+	if !ok {
+		return fmt.Errorf("unknown cipher suite %q", suiteName)
+	}
+
+	rng := harness.DeterministicRNG()
+	names := make([]string, members)
+	for i := range names {
+		names[i] = fmt.Sprintf("member-%d", i)
+	}
+	group, err := harness.BootstrapGroupWithDigest(rng, suite, names, nil)
+	if err != nil {
+		return fmt.Errorf("bootstrap: %w", err)
+	}
+	committer, others := group[0], group[1:]
+
+	newcomers := make([]*harness.Participant, joiners)
+	for i := range newcomers {
+		if newcomers[i], err = newMember(rng, suite, fmt.Sprintf("joiner-%d", i)); err != nil {
+			return fmt.Errorf("joiner-%d init: %w", i, err)
+		}
+		add, err := committer.State.Add(newcomers[i].KeyPackage)
+		if err != nil {
+			return fmt.Errorf("add %s: %w", newcomers[i].Name, err)
+		}
+		if _, err := committer.State.Handle(add); err != nil {
+			return fmt.Errorf("handle add: %w", err)
+		}
+		// The other members hold the proposals the commit covers.
+		if err := harness.DeliverProposal(others, add); err != nil {
+			return err
+		}
+	}
+	commit, welcome, next, err := committer.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	committer.State = next
+
+	report, err := harness.CheckDeliveryOrders(committer, others, newcomers, commit, welcome, duplicates)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("delivery-order: PASS (recipients=%d orders=%d runs=%d rejected=%d epoch=%d)\n",
+		report.Recipients, report.Orders, report.Runs, report.Rejected, next.Epoch)
+	return nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"math/rand"
+
+	mls "github.com/cisco/go-mls"
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// epochSecretLabels are the deriveSecret labels go-mls applies to each epoch secret.
+var epochSecretLabels = []string{"sender data", "handshake", "app", "exporter", "confirm", "init"}
+
+// runDiffCrypto cross-checks the local HKDF helpers used by wg-vectors against the
+// derivations inside go-mls. go-mls keeps its HKDF functions private, so the check
+// drives them through the exported key schedule: Export covers HKDF-Expand-Label
+// and DeriveSecret, Next covers HKDF-Extract and the epoch secret tree. Inputs are
+// random but seeded, so a mismatch is reproducible from --seed and the case number.
+func runDiffCrypto(suiteNames string, cases int, seed int64) error {
+	if cases <= 0 {
+		return fmt.Errorf("cases must be positive (got %d)", cases)
+	}
+	suites, err := parseDoctorSuites(suiteNames)
+	if err != nil {
+		return err
+	}
+	for _, suite := range suites {
+		if err := diffCryptoSuite(suite, cases, seed); err != nil {
+			return fmt.Errorf("%s: %w", suite.String(), err)
+		}
+		fmt.Printf("diff-crypto: %s ok (%d export, %d key schedule, %d aead cases)\n", suite.String(), cases, cases, cases)
+	}
+	fmt.Println("diff-crypto: PASS")
+	return nil
+}
+
+func diffCryptoSuite(suite mls.CipherSuite, cases int, seed int64) error {
+	rng := harness.DeterministicRNGWithSeed(seed)
+
+	// The key schedule needs no signatures, so a group on an Ed25519 suite carries
+	// it for every suite; ECDSA key generation is not needed to reach the derivations.
+	p, err := newMember(rng, mls.X25519_AES128GCM_SHA256_Ed25519, "diff")
+	if err != nil {
+		return fmt.Errorf("participant init: %w", err)
+	}
+	state, err := mls.NewEmptyState([]byte("diff-crypto"), p.InitSecret, p.SigningKey, p.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("create group: %w", err)
+	}
+	keys := state.Keys
+	if err := compareEpochSecrets(keys.Suite, keys.EpochSecret, keys.GroupContext, map[string][]byte{
+		"sender data": keys.SenderDataSecret, "handshake": keys.HandshakeSecret, "app": keys.ApplicationSecret,
+		"exporter": keys.ExporterSecret, "confirm": keys.ConfirmationKey, "init": keys.InitSecret,
+	}, keys.SenderDataKey); err != nil {
+		return fmt.Errorf("group epoch: %w", err)
+	}
+	keys.Suite = suite
+
+	secretSize := suite.Constants().SecretSize
+	for i := 0; i < cases; i++ {
+		// Export: our ExpandLabel(DeriveSecret(exporter, label, group context), "exporter", H(ctx)).
+		keys.ExporterSecret = harness.RandomBytes(rng, 1+rng.Intn(2*secretSize))
+		keys.GroupContext = harness.RandomBytes(rng, rng.Intn(128))
+		label := randomLabel(rng)
+		context := harness.RandomBytes(rng, rng.Intn(256))
+		length := 1 + rng.Intn(4*secretSize)
+		theirs := keys.Export(label, context, length)
+		base, err := harness.DeriveSecret(suite, keys.ExporterSecret, label, keys.GroupContext)
+		if err != nil {
+			return err
+		}
+		ours, err := harness.HKDFExpandLabel(suite, base, "exporter", suite.Digest(context), length)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(theirs, ours) {
+			return fmt.Errorf("export case %d (label %q, length %d): go-mls %x, local %x", i, label, length, theirs, ours)
+		}
+
+		// Next: Extract(psk, init) -> DeriveSecret("derived") -> Extract(commit secret).
+		keys.InitSecret = harness.RandomBytes(rng, secretSize)
+		psk := []byte{}
+		if rng.Intn(2) == 0 {
+			psk = harness.RandomBytes(rng, 1+rng.Intn(2*secretSize))
+		}
+		commitSecret := harness.RandomBytes(rng, secretSize)
+		groupContext := harness.RandomBytes(rng, rng.Intn(128))
+		next := keys.Next(mls.LeafCount(1+rng.Intn(32)), psk, commitSecret, groupContext)
+		epochSecret, err := localEpochSecret(suite, keys.InitSecret, psk, commitSecret, groupContext)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(next.EpochSecret, epochSecret) {
+			return fmt.Errorf("key schedule case %d: epoch secret go-mls %x, local %x", i, next.EpochSecret, epochSecret)
+		}
+		if err := compareEpochSecrets(suite, next.EpochSecret, groupContext, map[string][]byte{
+			"sender data": next.SenderDataSecret, "handshake": next.HandshakeSecret, "app": next.ApplicationSecret,
+			"exporter": next.ExporterSecret, "confirm": next.ConfirmationKey, "init": next.InitSecret,
+		}, next.SenderDataKey); err != nil {
+			return fmt.Errorf("key schedule case %d: %w", i, err)
+		}
+
+		if err := diffAEAD(rng, suite); err != nil {
+			return fmt.Errorf("aead case %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func localEpochSecret(suite mls.CipherSuite, initSecret, psk, commitSecret, context []byte) ([]byte, error) {
+	h, err := harness.HashForSuite(suite)
+	if err != nil {
+		return nil, err
+	}
+	if len(psk) == 0 {
+		psk = make([]byte, h().Size())
+	}
+	early, err := harness.HKDFExtract(suite, psk, initSecret)
+	if err != nil {
+		return nil, err
+	}
+	preEpoch, err := harness.DeriveSecret(suite, early, "derived", context)
+	if err != nil {
+		return nil, err
+	}
+	return harness.HKDFExtract(suite, commitSecret, preEpoch)
+}
+
+// compareEpochSecrets re-derives every secret of an epoch from its epoch secret.
+func compareEpochSecrets(suite mls.CipherSuite, epochSecret, context []byte, theirs map[string][]byte, senderDataKey []byte) error {
+	for _, label := range epochSecretLabels {
+		ours, err := harness.DeriveSecret(suite, epochSecret, label, context)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(theirs[label], ours) {
+			return fmt.Errorf("%q secret: go-mls %x, local %x", label, theirs[label], ours)
+		}
+	}
+	ours, err := harness.HKDFExpandLabel(suite, theirs["sender data"], "sd key", []byte{}, suite.Constants().KeySize)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(senderDataKey, ours) {
+		return fmt.Errorf("sender data key: go-mls %x, local %x", senderDataKey, ours)
+	}
+	return nil
+}
+
+// diffAEAD seals the same random message with the suite's AEAD and with one built
+// from the local suite table, then opens the go-mls ciphertext locally.
+func diffAEAD(rng *rand.Rand, suite mls.CipherSuite) error {
+	constants := suite.Constants()
+	key := harness.RandomBytes(rng, constants.KeySize)
+	nonce := harness.RandomBytes(rng, constants.NonceSize)
+	aad := harness.RandomBytes(rng, rng.Intn(64))
+	plaintext := harness.RandomBytes(rng, rng.Intn(512))
+
+	theirs, err := suite.NewAEAD(key)
+	if err != nil {
+		return fmt.Errorf("go-mls aead: %w", err)
+	}
+	ours, err := localAEAD(suite, key)
+	if err != nil {
+		return err
+	}
+	if ours.NonceSize() != constants.NonceSize {
+		return fmt.Errorf("nonce size: suite says %d, local aead uses %d", constants.NonceSize, ours.NonceSize())
+	}
+
+	sealed := theirs.Seal(nil, nonce, plaintext, aad)
+	if local := ours.Seal(nil, nonce, plaintext, aad); !bytes.Equal(sealed, local) {
+		return fmt.Errorf("ciphertext: go-mls %x, local %x", sealed, local)
+	}
+	opened, err := ours.Open(nil, nonce, sealed, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		return fmt.Errorf("local aead cannot open go-mls ciphertext: %v", err)
+	}
+	return nil
+}
+
+func localAEAD(suite mls.CipherSuite, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case mls.X25519_AES128GCM_SHA256_Ed25519, mls.P256_AES128GCM_SHA256_P256:
+		if len(key) != 16 {
+			return nil, fmt.Errorf("suite key size %d, want 16 for AES-128-GCM", len(key))
+		}
+	case mls.P521_AES256GCM_SHA512_P521:
+		if len(key) != 32 {
+			return nil, fmt.Errorf("suite key size %d, want 32 for AES-256-GCM", len(key))
+		}
+	case mls.X25519_CHACHA20POLY1305_SHA256_Ed25519:
+		return chacha20poly1305.New(key)
+	default:
+		return nil, fmt.Errorf("no local aead for suite %s", suite.String())
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomLabel(rng *rand.Rand) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz -"
+	label := make([]byte, rng.Intn(40))
+	for i := range label {
+		label[i] = alphabet[rng.Intn(len(alphabet))]
+	}
+	return string(label)
+}
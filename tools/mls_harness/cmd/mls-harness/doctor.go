@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	crand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// pinnedGoMLSVersion is the go-mls pseudo-version recorded in go.mod and vendor/modules.txt.
+const pinnedGoMLSVersion = "v0.0.0-20210331162924-158a3829b839"
+
+const goMLSModulePath = "github.com/cisco/go-mls"
+
+// doctorSuites are the cipher suites the harness claims to support; see cipherSuiteSupported.
+var doctorSuites = []mls.CipherSuite{
+	mls.X25519_AES128GCM_SHA256_Ed25519,
+	mls.P256_AES128GCM_SHA256_P256,
+	mls.X25519_CHACHA20POLY1305_SHA256_Ed25519,
+	mls.P521_AES256GCM_SHA512_P521,
+}
+
+type doctorCheck struct {
+	name string
+	run  func() (string, error)
+}
+
+// runDoctor executes fast self-checks of the build and the bundled data so long
+// soaks and CI jobs fail up front instead of minutes in.
+func runDoctor(vectorsDir, suiteNames string) error {
+	if vectorsDir == "" {
+		vectorsDir = "vectors"
+	}
+	suites, err := parseDoctorSuites(suiteNames)
+	if err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{
+		{name: "go-mls-version", run: checkGoMLSVersion},
+		{name: "crypto-rand-override", run: checkCryptoRandOverride},
+	}
+	for _, suite := range suites {
+		suite := suite
+		checks = append(checks, doctorCheck{
+			name: "suite " + suite.String(),
+			run:  func() (string, error) { return checkSuiteRoundTrip(suite) },
+		})
+	}
+	checks = append(checks, doctorCheck{
+		name: "bundled-vectors",
+		run:  func() (string, error) { return checkBundledVectors(vectorsDir) },
+	})
+
+	failed := false
+	for _, check := range checks {
+		detail, err := runDoctorCheck(check)
+		if err != nil {
+			fmt.Printf("%s: FAIL (%v)\n", check.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: PASS (%s)\n", check.name, detail)
+	}
+
+	if failed {
+		return errors.New("doctor checks failed")
+	}
+	fmt.Println("doctor: PASS")
+	return nil
+}
+
+// runDoctorCheck converts a panic inside the vendored library into a failed check
+// so one broken suite does not hide the remaining results.
+func runDoctorCheck(check doctorCheck) (detail string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			detail, err = "", fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return check.run()
+}
+
+func parseDoctorSuites(names string) ([]mls.CipherSuite, error) {
+	if names == "" || names == "all" {
+		return doctorSuites, nil
+	}
+	suites := []mls.CipherSuite{}
+	for _, name := range strings.Split(names, ",") {
+		suite, ok := harness.CipherSuiteByName(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		suites = append(suites, suite)
+	}
+	return suites, nil
+}
+
+func checkGoMLSVersion() (string, error) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "", errors.New("build info unavailable")
+	}
+	for _, dep := range info.Deps {
+		if dep.Path != goMLSModulePath {
+			continue
+		}
+		if dep.Replace != nil {
+			return "", fmt.Errorf("%s is replaced by %s %s", goMLSModulePath, dep.Replace.Path, dep.Replace.Version)
+		}
+		if dep.Version != pinnedGoMLSVersion {
+			return "", fmt.Errorf("%s is %s, expected %s", goMLSModulePath, dep.Version, pinnedGoMLSVersion)
+		}
+		return dep.Version, nil
+	}
+	return "", fmt.Errorf("%s not linked into this binary", goMLSModulePath)
+}
+
+func checkCryptoRandOverride() (string, error) {
+	original := crand.Reader
+
+	read := func(seed int64) ([]byte, error) {
+		restore := harness.OverrideCryptoRand(harness.DeterministicRNGWithSeed(seed))
+		defer restore()
+		buf := make([]byte, 32)
+		if _, err := io.ReadFull(crand.Reader, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+
+	first, err := read(7)
+	if err != nil {
+		return "", fmt.Errorf("read overridden reader: %w", err)
+	}
+	second, err := read(7)
+	if err != nil {
+		return "", fmt.Errorf("read overridden reader: %w", err)
+	}
+	if !bytes.Equal(first, second) {
+		return "", errors.New("same seed produced different bytes")
+	}
+	other, err := read(8)
+	if err != nil {
+		return "", fmt.Errorf("read overridden reader: %w", err)
+	}
+	if bytes.Equal(first, other) {
+		return "", errors.New("different seeds produced identical bytes")
+	}
+	if crand.Reader != original {
+		return "", errors.New("restore did not reinstate the original reader")
+	}
+	return "deterministic and restorable", nil
+}
+
+func checkSuiteRoundTrip(suite mls.CipherSuite) (string, error) {
+	rng := harness.DeterministicRNG()
+
+	alice, err := newMember(rng, suite, "alice")
+	if err != nil {
+		return "", fmt.Errorf("alice init: %w", err)
+	}
+	bob, err := newMember(rng, suite, "bob")
+	if err != nil {
+		return "", fmt.Errorf("bob init: %w", err)
+	}
+
+	alice.State, err = mls.NewEmptyState([]byte("doctor"), alice.InitSecret, alice.SigningKey, alice.KeyPackage)
+	if err != nil {
+		return "", fmt.Errorf("create group: %w", err)
+	}
+	add, err := alice.State.Add(bob.KeyPackage)
+	if err != nil {
+		return "", fmt.Errorf("add bob: %w", err)
+	}
+	welcome, err := commitProposals(rng, alice, nil, []*mls.MLSPlaintext{add})
+	if err != nil {
+		return "", err
+	}
+	bob.State, err = mls.NewJoinedState(bob.InitSecret, []mls.SignaturePrivateKey{bob.SigningKey}, []mls.KeyPackage{bob.KeyPackage}, *welcome)
+	if err != nil {
+		return "", fmt.Errorf("bob join: %w", err)
+	}
+
+	if err := harness.ExchangeOnce(alice, bob, []byte("doctor")); err != nil {
+		return "", err
+	}
+	if err := harness.ExchangeOnce(bob, alice, []byte("doctor")); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("epoch %d", alice.State.Epoch), nil
+}
+
+func checkBundledVectors(dir string) (string, error) {
+	parsed := 0
+
+	specs, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return "", fmt.Errorf("vectors: %w", err)
+	}
+	for _, path := range specs {
+		if _, err := harness.LoadVectorSpec(path); err != nil {
+			return "", fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+		parsed++
+	}
+
+	wgFiles := map[string]interface{}{
+		"crypto-basics.json": &harness.CryptoBasicsFile{},
+		"tree-math.json":     &harness.TreeMathFile{},
+	}
+	for name, target := range wgFiles {
+		raw, err := readVectorFile(filepath.Join(dir, "mlswg", name), defaultWGMaxBytes)
+		if err != nil {
+			return "", fmt.Errorf("mlswg/%s: %w", name, err)
+		}
+		if err := json.Unmarshal(raw, target); err != nil {
+			return "", fmt.Errorf("mlswg/%s: %w", name, err)
+		}
+		parsed++
+	}
+
+	manifests, err := filepath.Glob(filepath.Join(dir, "state-compat", "*", stateCompatManifestName))
+	if err != nil {
+		return "", fmt.Errorf("state-compat: %w", err)
+	}
+	for _, path := range manifests {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		var manifest stateCompatManifest
+		if err := json.Unmarshal(raw, &manifest); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		parsed++
+	}
+
+	chains, err := filepath.Glob(filepath.Join(dir, "epoch-chain", "*.json"))
+	if err != nil {
+		return "", fmt.Errorf("epoch-chain: %w", err)
+	}
+	for _, path := range chains {
+		if _, err := harness.LoadEpochChain(path); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		parsed++
+	}
+
+	scenarios, err := filepath.Glob(filepath.Join(dir, "scenarios", "*.json"))
+	if err != nil {
+		return "", fmt.Errorf("scenarios: %w", err)
+	}
+	for _, path := range scenarios {
+		if _, err := harness.LoadScenario(path); err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		parsed++
+	}
+
+	return fmt.Sprintf("%d files", parsed), nil
+}
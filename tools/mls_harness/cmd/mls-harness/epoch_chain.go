@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// epochChainRecorder collects the epoch authenticators of every member state a
+// scenario moves into. Like coverageRecorder, a nil recorder ignores every call.
+// The first disagreement is kept and reported when the run finishes.
+type epochChainRecorder struct {
+	chain harness.EpochChain
+	err   error
+}
+
+// epochChain is the recorder for the current subcommand; nil unless
+// --epoch-chain or --verify-epoch-chain is set.
+var epochChain *epochChainRecorder
+
+func startEpochChain(scenario, recordPath, verifyPath string) {
+	if recordPath == "" && verifyPath == "" {
+		return
+	}
+	epochChain = &epochChainRecorder{chain: harness.EpochChain{Scenario: scenario}}
+}
+
+func (r *epochChainRecorder) observe(members ...*harness.Participant) {
+	if r == nil || r.err != nil {
+		return
+	}
+	for _, member := range members {
+		if err := r.chain.Observe(member.State); err != nil {
+			r.err = fmt.Errorf("%s: %w", member.Name, err)
+			return
+		}
+	}
+}
+
+// pinCryptoRand swaps crypto/rand for rng while a chain is recorded or
+// verified, and returns the func that swaps it back. Commits carry HPKE
+// ciphertexts go-mls encrypts with crypto/rand, and their bytes enter the
+// transcript hash every later epoch derives from, so a chain only repeats when
+// go-mls draws from the scenario's stream too. Runs without a chain leave
+// crypto/rand alone.
+func (r *epochChainRecorder) pinCryptoRand(rng io.Reader) func() {
+	if r == nil {
+		return func() {}
+	}
+	return harness.OverrideCryptoRand(rng)
+}
+
+// finishEpochChain writes the recorded chain, if requested, and checks it against
+// the golden file. The recording is written even when the scenario failed so the
+// divergence can be inspected; verification only applies to completed runs.
+func finishEpochChain(recordPath, verifyPath string, runErr error) error {
+	if epochChain == nil {
+		return runErr
+	}
+	if recordPath != "" {
+		if err := epochChain.chain.WriteFile(recordPath); err != nil {
+			logger.Error("failed to write epoch chain", "err", err)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if epochChain.err != nil {
+		return fmt.Errorf("epoch chain: %w", epochChain.err)
+	}
+	if verifyPath == "" {
+		return nil
+	}
+
+	golden, err := harness.LoadEpochChain(verifyPath)
+	if err != nil {
+		return fmt.Errorf("load golden epoch chain: %w", err)
+	}
+	if err := epochChain.chain.Compare(golden); err != nil {
+		return fmt.Errorf("epoch chain mismatch against %s: %w", verifyPath, err)
+	}
+	fmt.Printf("epoch-chain: PASS (%d epochs match %s)\n", len(golden.Epochs), verifyPath)
+	return nil
+}
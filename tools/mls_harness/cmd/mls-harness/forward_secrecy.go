@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// stateSnapshot is a participant's state persisted at one point in the scenario,
+// as an attacker who copied the state file would hold it.
+type stateSnapshot struct {
+	name  string
+	store harness.StateStore
+	epoch mls.Epoch
+}
+
+func takeSnapshot(store harness.StateStore, label string, p *harness.Participant) (stateSnapshot, error) {
+	snap := stateSnapshot{name: p.Name + "-" + label, store: store, epoch: p.State.Epoch}
+	named := &harness.Participant{Name: snap.name, Session: harness.Session{State: p.State}}
+	if err := named.Checkpoint(store); err != nil {
+		return stateSnapshot{}, fmt.Errorf("snapshot %s: %w", snap.name, err)
+	}
+	return snap, nil
+}
+
+// open loads a fresh copy of the snapshot, so an attempt with one copy cannot
+// advance the ratchets seen by the next attempt.
+func (s stateSnapshot) open() (*harness.Participant, error) {
+	p := &harness.Participant{Name: s.name}
+	if err := p.Restore(s.store); err != nil {
+		return nil, fmt.Errorf("load snapshot %s: %w", s.name, err)
+	}
+	return p, nil
+}
+
+// decrypts reports whether a fresh copy of the snapshot can read ct.
+func (s stateSnapshot) decrypts(ct *mls.MLSCiphertext) (bool, error) {
+	p, err := s.open()
+	if err != nil {
+		return false, err
+	}
+	_, err = p.State.Unprotect(ct)
+	return err == nil, nil
+}
+
+// scenarioStateStore returns a store of files under stateDir, or one in memory
+// when stateDir is empty.
+func scenarioStateStore(stateDir string) (harness.StateStore, error) {
+	if stateDir == "" {
+		return harness.NewMemoryStore(), nil
+	}
+	if err := os.MkdirAll(stateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	return harness.FileStore{Dir: stateDir}, nil
+}
+
+// runForwardSecrecy snapshots bob's state, advances the group several epochs and
+// asserts that the snapshot cannot decrypt any ciphertext from a later epoch, while
+// the live bob can. The snapshot is first shown to decrypt traffic from its own
+// epoch, so the later failures cannot come from a snapshot that never worked.
+// A second snapshot taken after bob has read a message shows that the consumed
+// message key is gone from persisted state.
+func runForwardSecrecy(epochs, iterations int, stateDir string) error {
+	if epochs <= 0 {
+		return fmt.Errorf("epochs must be positive (got %d)", epochs)
+	}
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+	store, err := scenarioStateStore(stateDir)
+	if err != nil {
+		return err
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
+
+	snapshot, err := takeSnapshot(store, "leaked", bob)
+	if err != nil {
+		return err
+	}
+	ct, err := alice.State.Protect([]byte("before-advance"))
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", alice.Name, err)
+	}
+	if ok, err := snapshot.decrypts(ct); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("snapshot cannot decrypt traffic from its own epoch %d", snapshot.epoch)
+	}
+	if _, err := bob.State.Unprotect(ct); err != nil {
+		return fmt.Errorf("unprotect failed for %s: %w", bob.Name, err)
+	}
+	coverage.appMessage(bob.State.Epoch)
+
+	consumed, err := takeSnapshot(store, "after-read", bob)
+	if err != nil {
+		return err
+	}
+	if ok, err := consumed.decrypts(ct); err != nil {
+		return err
+	} else if ok {
+		return errors.New("state saved after reading a message can still decrypt it")
+	}
+
+	members := []*harness.Participant{alice, bob}
+	rejected := 0
+	for epoch := 0; epoch < epochs; epoch++ {
+		committer, other := members[epoch%2], members[(epoch+1)%2]
+		if _, err := commitProposals(rng, committer, []*harness.Participant{other}, nil); err != nil {
+			return fmt.Errorf("epoch %d: %w", epoch, err)
+		}
+		for i := 0; i < iterations; i++ {
+			ct, err := alice.State.Protect([]byte(fmt.Sprintf("epoch-%d-msg-%d", epoch, i)))
+			if err != nil {
+				return fmt.Errorf("protect failed for %s: %w", alice.Name, err)
+			}
+			if ok, err := snapshot.decrypts(ct); err != nil {
+				return err
+			} else if ok {
+				return fmt.Errorf("snapshot from epoch %d decrypted a ciphertext from epoch %d", snapshot.epoch, alice.State.Epoch)
+			}
+			rejected++
+			if _, err := bob.State.Unprotect(ct); err != nil {
+				return fmt.Errorf("epoch %d iteration %d: unprotect failed for %s: %w", epoch, i, bob.Name, err)
+			}
+			coverage.appMessage(bob.State.Epoch)
+		}
+	}
+
+	fmt.Printf("forward-secrecy: PASS (snapshot epoch=%d final epoch=%d rejected=%d)\n", snapshot.epoch, alice.State.Epoch, rejected)
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// fuzzInteresting are byte values that tend to hit length and bounds checks.
+var fuzzInteresting = []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}
+
+// runFuzz mutates the generated seed artifacts and feeds them to each dm fuzz
+// target without needing the Go toolchain. A panic or an input that runs longer
+// than timeout fails the run and is written to crashDir for replay. The samples
+// of the corpus at corpusDir, if named, are added to the seeds.
+func runFuzz(targets string, iterations int, seed int64, timeout time.Duration, crashDir, corpusDir string) error {
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+	selected, err := selectFuzzTargets(targets)
+	if err != nil {
+		return err
+	}
+	seeds, err := dm.FuzzSeeds()
+	if err != nil {
+		return fmt.Errorf("generate seeds: %w", err)
+	}
+	if corpusDir != "" {
+		extra, err := corpusFuzzSeeds(corpusDir)
+		if err != nil {
+			return fmt.Errorf("load corpus: %w", err)
+		}
+		for name, inputs := range extra {
+			seeds[name] = append(seeds[name], inputs...)
+		}
+	}
+
+	for _, target := range selected {
+		corpus := seeds[target.Name]
+		if len(corpus) == 0 {
+			return fmt.Errorf("no seeds for target %s", target.Name)
+		}
+		rng := rand.New(rand.NewSource(seed))
+		rejected := 0
+		for i := 0; i < iterations; i++ {
+			input := fuzzMutate(rng, corpus[rng.Intn(len(corpus))])
+			crash, err := runFuzzInput(target, input, timeout)
+			if crash != "" {
+				path, writeErr := writeFuzzCrash(crashDir, target.Name, i, input)
+				if writeErr != nil {
+					return fmt.Errorf("%s input %d: %s (saving input: %v)", target.Name, i, crash, writeErr)
+				}
+				return fmt.Errorf("%s input %d: %s (input saved to %s)", target.Name, i, crash, path)
+			}
+			if err != nil {
+				rejected++
+			}
+		}
+		fmt.Printf("fuzz: %s ok (%d inputs, %d rejected)\n", target.Name, iterations, rejected)
+	}
+	return nil
+}
+
+func selectFuzzTargets(targets string) ([]dm.FuzzTarget, error) {
+	if targets == "" || targets == "all" {
+		return dm.FuzzTargets, nil
+	}
+	var selected []dm.FuzzTarget
+	for _, name := range strings.Split(targets, ",") {
+		target, ok := dm.FuzzTargetByName(strings.TrimSpace(name))
+		if !ok {
+			names := make([]string, 0, len(dm.FuzzTargets))
+			for _, t := range dm.FuzzTargets {
+				names = append(names, t.Name)
+			}
+			return nil, fmt.Errorf("unknown fuzz target %q (want all or %s)", name, strings.Join(names, ", "))
+		}
+		selected = append(selected, target)
+	}
+	return selected, nil
+}
+
+// runFuzzInput returns a non-empty crash description when the target panicked or
+// did not return within timeout, and otherwise the target's own error.
+func runFuzzInput(target dm.FuzzTarget, input []byte, timeout time.Duration) (string, error) {
+	type outcome struct {
+		err   error
+		panic string
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{panic: fmt.Sprintf("panic: %v\n%s", r, debug.Stack())}
+			}
+		}()
+		done <- outcome{err: target.Run(input)}
+	}()
+
+	select {
+	case out := <-done:
+		return out.panic, out.err
+	case <-time.After(timeout):
+		return fmt.Sprintf("no result after %s", timeout), nil
+	}
+}
+
+// fuzzMutate applies one to four random edits to a copy of seed.
+func fuzzMutate(rng *rand.Rand, seed []byte) []byte {
+	data := append([]byte(nil), seed...)
+	for n := 1 + rng.Intn(4); n > 0; n-- {
+		if len(data) == 0 {
+			data = append(data, byte(rng.Intn(256)))
+			continue
+		}
+		pos := rng.Intn(len(data))
+		switch rng.Intn(6) {
+		case 0:
+			data[pos] ^= 1 << uint(rng.Intn(8))
+		case 1:
+			data[pos] = byte(rng.Intn(256))
+		case 2:
+			data[pos] = fuzzInteresting[rng.Intn(len(fuzzInteresting))]
+		case 3:
+			data = append(data[:pos], data[pos+1:]...)
+		case 4:
+			data = append(data[:pos], append([]byte{byte(rng.Intn(256))}, data[pos:]...)...)
+		case 5:
+			data = data[:pos]
+		}
+	}
+	return data
+}
+
+func writeFuzzCrash(dir, target string, iteration int, input []byte) (string, error) {
+	if dir == "" {
+		return "", errors.New("no crash directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%d.bin", target, iteration))
+	if err := os.WriteFile(path, input, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// Fixed inputs for the DM the handle-stress scenario runs in.
+const (
+	handleStressAliceSeed     int64 = 9201
+	handleStressBobSeed       int64 = 9202
+	handleStressInitSeed      int64 = 9203
+	handleStressGroupIDBase64       = "aGFuZGxlLXN0cmVzcw==" // "handle-stress"
+)
+
+// runHandleStress holds each side of a DM in a dm.Handle and has workers
+// goroutines send messages each way at once while the same goroutines run
+// Apply and Save on both handles. Every message must decrypt, and
+// afterwards each handle must count exactly the messages it sent, so a lost
+// update under the handle's mutex shows up even without the race detector.
+// It then destroys one handle, requires every method to fail with
+// ErrDestroyed, and loads the last saved state back into it.
+func runHandleStress(workers, messages int) error {
+	if workers <= 0 {
+		return fmt.Errorf("workers must be positive (got %d)", workers)
+	}
+	if messages <= 0 {
+		return fmt.Errorf("messages must be positive (got %d)", messages)
+	}
+
+	alice, bob, err := handleStressDM()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*workers)
+	for w := 0; w < workers; w++ {
+		for _, pair := range [][2]*dm.Handle{{alice, bob}, {bob, alice}} {
+			wg.Add(1)
+			go func(w int, sender, receiver *dm.Handle) {
+				defer wg.Done()
+				if err := handleStressWorker(w, messages, sender, receiver); err != nil {
+					errs <- fmt.Errorf("worker %d: %w", w, err)
+				}
+			}(w, pair[0], pair[1])
+		}
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+
+	for name, h := range map[string]*dm.Handle{"alice": alice, "bob": bob} {
+		info, err := h.Info(handleStressGroupIDBase64)
+		if err != nil {
+			return fmt.Errorf("%s info: %w", name, err)
+		}
+		var counts struct {
+			SentSinceRotation int `json:"sent_since_rotation"`
+		}
+		if err := json.Unmarshal([]byte(info), &counts); err != nil {
+			return fmt.Errorf("%s info: %w", name, err)
+		}
+		if want := workers * messages; counts.SentSinceRotation != want {
+			return fmt.Errorf("%s counted %d messages sent, want %d", name, counts.SentSinceRotation, want)
+		}
+	}
+
+	if err := handleStressDestroy(alice, bob); err != nil {
+		return err
+	}
+	fmt.Printf("handle-stress: PASS (workers=%d messages=%d)\n", workers, 2*workers*messages)
+	return nil
+}
+
+func handleStressDM() (*dm.Handle, *dm.Handle, error) {
+	alice, _, err := dm.KeyPackage("", "alice", "", handleStressAliceSeed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("alice keypackage: %w", err)
+	}
+	bob, bobKP, err := dm.KeyPackage("", "bob", "", handleStressBobSeed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bob keypackage: %w", err)
+	}
+	alice, welcome, commit, err := dm.Init(alice, bobKP, handleStressGroupIDBase64, "", handleStressInitSeed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("init: %w", err)
+	}
+	if bob, err = dm.Join(bob, handleStressGroupIDBase64, welcome); err != nil {
+		return nil, nil, fmt.Errorf("join: %w", err)
+	}
+	if alice, _, err = dm.CommitApply(alice, handleStressGroupIDBase64, commit); err != nil {
+		return nil, nil, fmt.Errorf("commit apply: %w", err)
+	}
+	aliceHandle, err := dm.LoadHandle(alice)
+	if err != nil {
+		return nil, nil, fmt.Errorf("alice handle: %w", err)
+	}
+	bobHandle, err := dm.LoadHandle(bob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bob handle: %w", err)
+	}
+	return aliceHandle, bobHandle, nil
+}
+
+// handleStressWorker sends messages from sender to receiver, and between them
+// runs a blob entry point through Apply on one handle and saves the other.
+func handleStressWorker(w, messages int, sender, receiver *dm.Handle) error {
+	for i := 0; i < messages; i++ {
+		plaintext := fmt.Sprintf("worker-%d-msg-%d", w, i)
+		ciphertext, err := sender.Encrypt(handleStressGroupIDBase64, plaintext)
+		if err != nil {
+			return fmt.Errorf("encrypt %d: %w", i, err)
+		}
+		received, err := receiver.Decrypt(handleStressGroupIDBase64, ciphertext)
+		if err != nil {
+			return fmt.Errorf("decrypt %d: %w", i, err)
+		}
+		if received.Body != plaintext {
+			return fmt.Errorf("message %d read %q, want %q", i, received.Body, plaintext)
+		}
+		if err := sender.Apply(func(participant string) (string, error) {
+			return dm.SetRotation(participant, dm.RotationPolicy{})
+		}); err != nil {
+			return fmt.Errorf("apply %d: %w", i, err)
+		}
+		if _, err := receiver.Save(); err != nil {
+			return fmt.Errorf("save %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// handleStressDestroy checks that a destroyed handle refuses every method and
+// that Load brings it back with the state it last saved.
+func handleStressDestroy(alice, bob *dm.Handle) error {
+	saved, err := alice.Save()
+	if err != nil {
+		return fmt.Errorf("save before destroy: %w", err)
+	}
+	alice.Destroy()
+
+	if _, err := alice.Encrypt(handleStressGroupIDBase64, "after-destroy"); !errors.Is(err, dm.ErrDestroyed) {
+		return fmt.Errorf("encrypt after destroy: got %v, want ErrDestroyed", err)
+	}
+	if _, err := alice.Decrypt(handleStressGroupIDBase64, ""); !errors.Is(err, dm.ErrDestroyed) {
+		return fmt.Errorf("decrypt after destroy: got %v, want ErrDestroyed", err)
+	}
+	if _, err := alice.Save(); !errors.Is(err, dm.ErrDestroyed) {
+		return fmt.Errorf("save after destroy: got %v, want ErrDestroyed", err)
+	}
+	if _, err := alice.Info(handleStressGroupIDBase64); !errors.Is(err, dm.ErrDestroyed) {
+		return fmt.Errorf("info after destroy: got %v, want ErrDestroyed", err)
+	}
+	if err := alice.Apply(func(participant string) (string, error) { return participant, nil }); !errors.Is(err, dm.ErrDestroyed) {
+		return fmt.Errorf("apply after destroy: got %v, want ErrDestroyed", err)
+	}
+	if groups := alice.Groups(); len(groups) != 0 {
+		return fmt.Errorf("destroyed handle lists groups %v", groups)
+	}
+
+	if err := alice.Load(saved); err != nil {
+		return fmt.Errorf("load after destroy: %w", err)
+	}
+	ciphertext, err := alice.Encrypt(handleStressGroupIDBase64, "after-load")
+	if err != nil {
+		return fmt.Errorf("encrypt after load: %w", err)
+	}
+	received, err := bob.Decrypt(handleStressGroupIDBase64, ciphertext)
+	if err != nil {
+		return fmt.Errorf("decrypt after load: %w", err)
+	}
+	if received.Body != "after-load" {
+		return fmt.Errorf("after load bob read %q", received.Body)
+	}
+	return nil
+}
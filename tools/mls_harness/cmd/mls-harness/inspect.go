@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// inspectKinds lists the artifact types `inspect --type auto` tries, in order.
+var inspectKinds = []string{"keypackage", "welcome", "plaintext", "ciphertext"}
+
+// runInspect decodes a base64/hex MLS artifact and prints its structure as JSON.
+// Application payloads are reported by length only; inspect never prints plaintext.
+func runInspect(kind, encoding, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return errors.New("artifact is required")
+	}
+
+	data, err := decodeArtifact(encoding, value)
+	if err != nil {
+		return err
+	}
+
+	var summary interface{}
+	if kind == "" || kind == "auto" {
+		var errs []string
+		for _, candidate := range inspectKinds {
+			summary, err = inspectAs(candidate, data)
+			if err == nil {
+				break
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", candidate, err))
+		}
+		if err != nil {
+			return fmt.Errorf("artifact did not decode as any known type (%s)", strings.Join(errs, "; "))
+		}
+	} else {
+		summary, err = inspectAs(kind, data)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", kind, err)
+		}
+	}
+
+	out, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode json: %w", err)
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func decodeArtifact(encoding, value string) ([]byte, error) {
+	switch encoding {
+	case "hex":
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex: %w", err)
+		}
+		return data, nil
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		return data, nil
+	case "", "auto":
+		if data, err := hex.DecodeString(value); err == nil {
+			return data, nil
+		}
+		if data, err := base64.StdEncoding.DecodeString(value); err == nil {
+			return data, nil
+		}
+		if data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "=")); err == nil {
+			return data, nil
+		}
+		return nil, errors.New("artifact is neither hex nor base64")
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q (want auto, hex or base64)", encoding)
+	}
+}
+
+func inspectAs(kind string, data []byte) (summary interface{}, err error) {
+	// go-mls panics on some malformed inputs (unknown suites, empty credentials);
+	// report those as decode failures so auto-detection can move on.
+	defer func() {
+		if r := recover(); r != nil {
+			summary, err = nil, fmt.Errorf("malformed %s: %v", kind, r)
+		}
+	}()
+
+	switch kind {
+	case "keypackage":
+		var kp mls.KeyPackage
+		if err := unmarshalExact(data, &kp); err != nil {
+			return nil, err
+		}
+		return describeKeyPackage(kp), nil
+	case "welcome":
+		var welcome mls.Welcome
+		if err := unmarshalExact(data, &welcome); err != nil {
+			return nil, err
+		}
+		return describeWelcome(welcome), nil
+	case "plaintext":
+		var pt mls.MLSPlaintext
+		if err := unmarshalExact(data, &pt); err != nil {
+			return nil, err
+		}
+		return describePlaintext(pt), nil
+	case "ciphertext":
+		var ct mls.MLSCiphertext
+		if err := unmarshalExact(data, &ct); err != nil {
+			return nil, err
+		}
+		return describeCiphertext(ct), nil
+	default:
+		return nil, fmt.Errorf("unsupported type %q (want auto, %s)", kind, strings.Join(inspectKinds, ", "))
+	}
+}
+
+func unmarshalExact(data []byte, v interface{}) error {
+	n, err := syntax.Unmarshal(data, v)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("%d trailing bytes", len(data)-n)
+	}
+	return nil
+}
+
+type inspectCipherSuite struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+type inspectCredential struct {
+	Type            string `json:"type"`
+	Identity        string `json:"identity,omitempty"`
+	IdentityBase64  string `json:"identity_b64,omitempty"`
+	SignatureScheme uint16 `json:"signature_scheme,omitempty"`
+	PublicKey       string `json:"public_key,omitempty"`
+}
+
+type inspectExtension struct {
+	Type    uint16      `json:"type"`
+	Name    string      `json:"name"`
+	Length  int         `json:"length"`
+	Decoded interface{} `json:"decoded,omitempty"`
+}
+
+type inspectKeyPackage struct {
+	Type        string             `json:"type"`
+	Version     uint8              `json:"version"`
+	CipherSuite inspectCipherSuite `json:"cipher_suite"`
+	Hash        string             `json:"hash"`
+	InitKey     string             `json:"init_key"`
+	Credential  inspectCredential  `json:"credential"`
+	Extensions  []inspectExtension `json:"extensions"`
+	Signature   int                `json:"signature_len"`
+}
+
+type inspectGroupSecrets struct {
+	KeyPackageHash string `json:"key_package_hash"`
+	KEMOutputLen   int    `json:"kem_output_len"`
+	CiphertextLen  int    `json:"ciphertext_len"`
+}
+
+type inspectWelcome struct {
+	Type                  string                `json:"type"`
+	Version               uint8                 `json:"version"`
+	CipherSuite           inspectCipherSuite    `json:"cipher_suite"`
+	Secrets               []inspectGroupSecrets `json:"secrets"`
+	EncryptedGroupInfoLen int                   `json:"encrypted_group_info_len"`
+}
+
+type inspectSender struct {
+	Type  string `json:"type"`
+	Index uint32 `json:"index"`
+}
+
+type inspectProposal struct {
+	Type       string             `json:"type"`
+	KeyPackage *inspectKeyPackage `json:"key_package,omitempty"`
+	Removed    *uint32            `json:"removed,omitempty"`
+}
+
+type inspectCommit struct {
+	Updates         []string `json:"updates"`
+	Removes         []string `json:"removes"`
+	Adds            []string `json:"adds"`
+	PathPresent     bool     `json:"path_present"`
+	PathSteps       int      `json:"path_steps,omitempty"`
+	PathLeafKPHash  string   `json:"path_leaf_key_package_hash,omitempty"`
+	ConfirmationLen int      `json:"confirmation_len"`
+}
+
+type inspectPlaintext struct {
+	Type              string           `json:"type"`
+	GroupID           string           `json:"group_id"`
+	Epoch             uint64           `json:"epoch"`
+	Sender            inspectSender    `json:"sender"`
+	ContentType       string           `json:"content_type"`
+	AuthenticatedData int              `json:"authenticated_data_len"`
+	ApplicationLen    *int             `json:"application_data_len,omitempty"`
+	Proposal          *inspectProposal `json:"proposal,omitempty"`
+	Commit            *inspectCommit   `json:"commit,omitempty"`
+	SignatureLen      int              `json:"signature_len"`
+}
+
+type inspectCiphertext struct {
+	Type                   string `json:"type"`
+	GroupID                string `json:"group_id"`
+	Epoch                  uint64 `json:"epoch"`
+	ContentType            string `json:"content_type"`
+	SenderDataNonce        string `json:"sender_data_nonce"`
+	EncryptedSenderDataLen int    `json:"encrypted_sender_data_len"`
+	AuthenticatedDataLen   int    `json:"authenticated_data_len"`
+	CiphertextLen          int    `json:"ciphertext_len"`
+}
+
+func describeCipherSuite(cs mls.CipherSuite) inspectCipherSuite {
+	return inspectCipherSuite{ID: uint16(cs), Name: cs.String()}
+}
+
+func describeKeyPackage(kp mls.KeyPackage) inspectKeyPackage {
+	out := inspectKeyPackage{
+		Type:        "keypackage",
+		Version:     uint8(kp.Version),
+		CipherSuite: describeCipherSuite(kp.CipherSuite),
+		InitKey:     hex.EncodeToString(kp.InitKey.Data),
+		Extensions:  []inspectExtension{},
+		Signature:   len(kp.Signature.Data),
+	}
+	if data, err := syntax.Marshal(kp); err == nil {
+		out.Hash = hex.EncodeToString(kp.CipherSuite.Digest(data))
+	}
+
+	switch {
+	case kp.Credential.Basic != nil:
+		basic := kp.Credential.Basic
+		out.Credential = inspectCredential{
+			Type:            "basic",
+			IdentityBase64:  base64.StdEncoding.EncodeToString(basic.Identity),
+			SignatureScheme: uint16(basic.SignatureScheme),
+			PublicKey:       hex.EncodeToString(basic.PublicKey.Data),
+		}
+		if utf8.Valid(basic.Identity) {
+			out.Credential.Identity = string(basic.Identity)
+		}
+	case kp.Credential.X509 != nil:
+		out.Credential = inspectCredential{Type: "x509"}
+	default:
+		out.Credential = inspectCredential{Type: "unknown"}
+	}
+
+	for _, ext := range kp.Extensions.Entries {
+		out.Extensions = append(out.Extensions, describeExtension(ext))
+	}
+	return out
+}
+
+func describeExtension(ext mls.Extension) inspectExtension {
+	out := inspectExtension{Type: uint16(ext.ExtensionType), Length: len(ext.ExtensionData)}
+	switch ext.ExtensionType {
+	case mls.ExtensionTypeSupportedVersions:
+		out.Name = "supported_versions"
+		var body mls.SupportedVersionsExtension
+		if unmarshalExact(ext.ExtensionData, &body) == nil {
+			versions := make([]int, 0, len(body.SupportedVersions))
+			for _, v := range body.SupportedVersions {
+				versions = append(versions, int(v))
+			}
+			out.Decoded = versions
+		}
+	case mls.ExtensionTypeSupportedCipherSuites:
+		out.Name = "supported_cipher_suites"
+		var body mls.SupportedCipherSuitesExtension
+		if unmarshalExact(ext.ExtensionData, &body) == nil {
+			suites := make([]inspectCipherSuite, 0, len(body.SupportedCipherSuites))
+			for _, cs := range body.SupportedCipherSuites {
+				suites = append(suites, describeCipherSuite(cs))
+			}
+			out.Decoded = suites
+		}
+	case mls.ExtensionTypeLifetime:
+		out.Name = "lifetime"
+		var body mls.LifetimeExtension
+		if unmarshalExact(ext.ExtensionData, &body) == nil {
+			out.Decoded = map[string]string{
+				"not_before": time.Unix(int64(body.NotBefore), 0).UTC().Format(time.RFC3339),
+				"not_after":  time.Unix(int64(body.NotAfter), 0).UTC().Format(time.RFC3339),
+			}
+		}
+	case mls.ExtensionTypeKeyID:
+		out.Name = "key_id"
+	case mls.ExtensionTypeParentHash:
+		out.Name = "parent_hash"
+		var body mls.ParentHashExtension
+		if unmarshalExact(ext.ExtensionData, &body) == nil {
+			out.Decoded = hex.EncodeToString(body.ParentHash)
+		}
+	default:
+		out.Name = "unknown"
+	}
+	return out
+}
+
+func describeWelcome(welcome mls.Welcome) inspectWelcome {
+	out := inspectWelcome{
+		Type:                  "welcome",
+		Version:               uint8(welcome.Version),
+		CipherSuite:           describeCipherSuite(welcome.CipherSuite),
+		Secrets:               []inspectGroupSecrets{},
+		EncryptedGroupInfoLen: len(welcome.EncryptedGroupInfo),
+	}
+	for _, secret := range welcome.Secrets {
+		out.Secrets = append(out.Secrets, inspectGroupSecrets{
+			KeyPackageHash: hex.EncodeToString(secret.KeyPackageHash),
+			KEMOutputLen:   len(secret.EncryptedGroupSecrets.KEMOutput),
+			CiphertextLen:  len(secret.EncryptedGroupSecrets.Ciphertext),
+		})
+	}
+	return out
+}
+
+func describeSender(sender mls.Sender) inspectSender {
+	name := "invalid"
+	switch sender.Type {
+	case mls.SenderTypeMember:
+		name = "member"
+	case mls.SenderTypePreconfigured:
+		name = "preconfigured"
+	case mls.SenderTypeNewMember:
+		name = "new_member"
+	}
+	return inspectSender{Type: name, Index: sender.Sender}
+}
+
+func contentTypeName(ct mls.ContentType) string {
+	switch ct {
+	case mls.ContentTypeApplication:
+		return "application"
+	case mls.ContentTypeProposal:
+		return "proposal"
+	case mls.ContentTypeCommit:
+		return "commit"
+	default:
+		return "invalid"
+	}
+}
+
+func proposalIDs(ids []mls.ProposalID) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		out = append(out, id.String())
+	}
+	return out
+}
+
+func describePlaintext(pt mls.MLSPlaintext) inspectPlaintext {
+	out := inspectPlaintext{
+		Type:              "plaintext",
+		GroupID:           base64.StdEncoding.EncodeToString(pt.GroupID),
+		Epoch:             uint64(pt.Epoch),
+		Sender:            describeSender(pt.Sender),
+		ContentType:       contentTypeName(pt.Content.Type()),
+		AuthenticatedData: len(pt.AuthenticatedData),
+		SignatureLen:      len(pt.Signature.Data),
+	}
+
+	switch {
+	case pt.Content.Application != nil:
+		n := len(pt.Content.Application.Data)
+		out.ApplicationLen = &n
+	case pt.Content.Proposal != nil:
+		proposal := pt.Content.Proposal
+		desc := &inspectProposal{}
+		switch {
+		case proposal.Add != nil:
+			kp := describeKeyPackage(proposal.Add.KeyPackage)
+			desc.Type, desc.KeyPackage = "add", &kp
+		case proposal.Update != nil:
+			kp := describeKeyPackage(proposal.Update.KeyPackage)
+			desc.Type, desc.KeyPackage = "update", &kp
+		case proposal.Remove != nil:
+			removed := uint32(proposal.Remove.Removed)
+			desc.Type, desc.Removed = "remove", &removed
+		default:
+			desc.Type = "invalid"
+		}
+		out.Proposal = desc
+	case pt.Content.Commit != nil:
+		commit := pt.Content.Commit.Commit
+		desc := &inspectCommit{
+			Updates:         proposalIDs(commit.Updates),
+			Removes:         proposalIDs(commit.Removes),
+			Adds:            proposalIDs(commit.Adds),
+			PathPresent:     commit.Path != nil,
+			ConfirmationLen: len(pt.Content.Commit.Confirmation.Data),
+		}
+		if commit.Path != nil {
+			desc.PathSteps = len(commit.Path.Steps)
+			desc.PathLeafKPHash = describeKeyPackage(commit.Path.LeafKeyPackage).Hash
+		}
+		out.Commit = desc
+	}
+	return out
+}
+
+func describeCiphertext(ct mls.MLSCiphertext) inspectCiphertext {
+	return inspectCiphertext{
+		Type:                   "ciphertext",
+		GroupID:                base64.StdEncoding.EncodeToString(ct.GroupID),
+		Epoch:                  uint64(ct.Epoch),
+		ContentType:            contentTypeName(ct.ContentType),
+		SenderDataNonce:        hex.EncodeToString(ct.SenderDataNonce),
+		EncryptedSenderDataLen: len(ct.EncryptedSenderData),
+		AuthenticatedDataLen:   len(ct.AuthenticatedData),
+		CiphertextLen:          len(ct.Ciphertext),
+	}
+}
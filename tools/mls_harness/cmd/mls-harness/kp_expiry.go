@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// kpExpiryCase is one KeyPackage lifetime window offered to a fresh group.
+type kpExpiryCase struct {
+	name      string
+	notBefore time.Time
+	notAfter  time.Time
+	wantJoin  bool
+}
+
+// runKeyPackageExpiry checks that go-mls enforces the KeyPackage lifetime extension.
+// go-mls validates lifetimes against the real wall clock when an Add is applied, so
+// the scenario moves the issuing clock instead: KeyPackages are minted as if issued
+// travel in the past (already expired) or travel in the future (not yet valid).
+func runKeyPackageExpiry(lifetime, travel time.Duration, clock harness.Clock) error {
+	if lifetime <= 0 {
+		return fmt.Errorf("lifetime must be positive (got %s)", lifetime)
+	}
+	if travel <= lifetime {
+		return fmt.Errorf("time-travel (%s) must exceed lifetime (%s) for the expired case to expire", travel, lifetime)
+	}
+
+	issued := clock.Now()
+	cases := []kpExpiryCase{
+		{name: "valid", notBefore: issued.Add(-time.Minute), notAfter: issued.Add(lifetime), wantJoin: true},
+		{name: "expired", notBefore: issued.Add(-travel), notAfter: issued.Add(-travel).Add(lifetime)},
+		{name: "not-yet-valid", notBefore: issued.Add(travel), notAfter: issued.Add(travel).Add(lifetime)},
+	}
+
+	failed := false
+	for _, tc := range cases {
+		detail, err := runKeyPackageExpiryCase(tc)
+		if err != nil {
+			fmt.Printf("%s: FAIL (%v)\n", tc.name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: PASS (%s)\n", tc.name, detail)
+	}
+
+	if failed {
+		return errors.New("kp-expiry cases failed")
+	}
+	fmt.Println("kp-expiry: PASS")
+	return nil
+}
+
+func runKeyPackageExpiryCase(tc kpExpiryCase) (string, error) {
+	rng := harness.DeterministicRNG()
+
+	alice, err := newMember(rng, mls.X25519_AES128GCM_SHA256_Ed25519, "alice")
+	if err != nil {
+		return "", fmt.Errorf("alice init: %w", err)
+	}
+	bobID, err := harness.NewIdentity(rng, mls.X25519_AES128GCM_SHA256_Ed25519, "bob")
+	if err != nil {
+		return "", fmt.Errorf("bob init: %w", err)
+	}
+	bob, err := bobID.NewSessionWithLifetime(rng, "bob", tc.notBefore, tc.notAfter)
+	if err != nil {
+		return "", fmt.Errorf("bob init: %w", err)
+	}
+
+	alice.State, err = mls.NewEmptyState([]byte("kp-expiry"), alice.InitSecret, alice.SigningKey, alice.KeyPackage)
+	if err != nil {
+		return "", fmt.Errorf("create group: %w", err)
+	}
+	add, err := alice.State.Add(bob.KeyPackage)
+	if err != nil {
+		return "", fmt.Errorf("add bob: %w", err)
+	}
+	coverage.record(opAdd)
+
+	welcome, err := commitProposals(rng, alice, nil, []*mls.MLSPlaintext{add})
+	if !tc.wantJoin {
+		if err == nil {
+			return "", fmt.Errorf("KeyPackage valid %s..%s was accepted", tc.notBefore.UTC().Format(time.RFC3339), tc.notAfter.UTC().Format(time.RFC3339))
+		}
+		if !strings.Contains(err.Error(), "Invalid kp") {
+			return "", fmt.Errorf("add rejected for an unexpected reason: %w", err)
+		}
+		return "add rejected by lifetime validation", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	bob.State, err = mls.NewJoinedState(bob.InitSecret, []mls.SignaturePrivateKey{bob.SigningKey}, []mls.KeyPackage{bob.KeyPackage}, *welcome)
+	if err != nil {
+		return "", fmt.Errorf("bob join: %w", err)
+	}
+	coverage.record(opWelcomeJoin)
+	if err := exchangeOnce(alice, bob, []byte("kp-expiry")); err != nil {
+		return "", err
+	}
+	if err := exchangeOnce(bob, alice, []byte("kp-expiry")); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("joined at epoch %d", bob.State.Epoch), nil
+}
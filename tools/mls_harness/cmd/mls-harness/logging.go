@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// logger carries diagnostics on stderr. Output a caller parses (ciphertexts,
+// PASS lines, tables, JSON reports) stays on stdout and is never logged, and
+// neither is plaintext.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+
+// configureLogging strips --log-level and --log-format from args, wherever they
+// appear after the command name, and installs the logger they describe. Every
+// record carries the command name so lines from several runs can share a sink.
+func configureLogging(args []string) ([]string, error) {
+	level, format := "info", "text"
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") || (name != "log-level" && name != "log-format") {
+			rest = append(rest, args[i])
+			continue
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag needs an argument: -%s", name)
+			}
+			i++
+			value = args[i]
+		}
+		if name == "log-level" {
+			level = value
+		} else {
+			format = value
+		}
+	}
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log-level must be debug, info, warn or error (got %q)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return nil, fmt.Errorf("log-format must be text or json (got %q)", format)
+	}
+	logger = slog.New(handler)
+	if len(rest) > 0 {
+		logger = logger.With("command", rest[0])
+	}
+	return rest, nil
+}
+
+// fatal logs err at error level and exits with code: 2 for bad invocations, 1
+// for failed runs. A stepError anywhere in the chain contributes its fields.
+func fatal(code int, msg string, err error) {
+	attrs := []any{"err", err}
+	if code := dm.ErrorCode(err); code != "" {
+		attrs = append(attrs, "code", code)
+	}
+	var step *stepError
+	if errors.As(err, &step) {
+		attrs = append(attrs, "iteration", step.iteration, "participant", step.participant, "epoch", uint64(step.epoch))
+	}
+	logger.Error(msg, attrs...)
+	os.Exit(code)
+}
+
+// stepError records where in a scenario loop a failure happened, so the final
+// log line has iteration, participant and epoch as fields a pipeline can filter
+// on. Its message is the wrapped error's, unchanged.
+type stepError struct {
+	iteration   int
+	participant string
+	epoch       mls.Epoch
+	err         error
+}
+
+func (e *stepError) Error() string { return e.err.Error() }
+func (e *stepError) Unwrap() error { return e.err }
+
+func stepFailed(iteration int, p *harness.Participant, err error) error {
+	step := &stepError{iteration: iteration, participant: p.Name, err: err}
+	if p.State != nil {
+		step.epoch = p.State.Epoch
+	}
+	return step
+}
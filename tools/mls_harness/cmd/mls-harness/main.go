@@ -2,25 +2,42 @@ package main
 
 import (
 	"bytes"
-	"encoding/gob"
+	crand "crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	mls "github.com/cisco/go-mls"
+	"time"
 
 	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
 	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness/proptest"
 )
 
 func main() {
+	args, err := configureLogging(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	os.Args = append(os.Args[:1], args...)
 	if len(os.Args) < 2 {
 		usage()
 	}
+	if err := configureStateKey(); err != nil {
+		fatal(2, "invalid state key", err)
+	}
+	dm.SetLegacySeededRand(os.Getenv("MLS_HARNESS_LEGACY_SEEDED_RAND") == "1")
+	dm.SetArtifactEnvelopes(os.Getenv("MLS_HARNESS_ARTIFACT_ENVELOPES") == "1")
+	if err := configureClock(); err != nil {
+		fatal(2, "invalid clock", err)
+	}
 
 	switch os.Args[1] {
 	case "smoke":
@@ -28,28 +45,35 @@ func main() {
 		iterations := smoke.Int("iterations", 50, "number of message iterations per participant")
 		saveEvery := smoke.Int("save-every", 10, "checkpoint interval for persisting state")
 		stateDir := smoke.String("state-dir", "", "directory to store state snapshots")
+		transport := smoke.String("transport", "memory", "carry ciphertexts over memory, file, tcp or websocket")
+		coverageReport := smoke.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := smoke.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := smoke.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
 		if err := smoke.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse smoke flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
 
-		if err := runSmoke(*iterations, *saveEvery, *stateDir); err != nil {
-			fmt.Fprintf(os.Stderr, "smoke scenario failed: %v\n", err)
-			os.Exit(1)
+		startCoverage("smoke", *coverageReport)
+		startEpochChain("smoke", *recordChain, *verifyChain)
+		err := runSmoke(*iterations, *saveEvery, *stateDir, *transport)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
 		}
 	case "dm-keypackage":
 		dmKP := flag.NewFlagSet("dm-keypackage", flag.ExitOnError)
 		name := dmKP.String("name", "participant", "participant name for credential")
 		stateDir := dmKP.String("state-dir", "", "directory for participant state")
-		seed := dmKP.Int64("seed", 1337, "deterministic RNG seed")
+		suite := dmKP.String("cipher-suite", "", "cipher suite name (default X25519_AES128GCM_SHA256_Ed25519; must match an existing participant)")
+		mode := dmKP.String("mode", "", "deterministic or secure, fixed when the participant is created (default deterministic)")
+		seed := dmKP.Int64("seed", 1337, "deterministic RNG seed (refused in secure mode)")
 		if err := dmKP.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-keypackage flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		kp, err := runDMKeyPackage(*stateDir, *name, *seed)
+		kp, err := runDMKeyPackage(*stateDir, *name, *suite, *mode, dmSeed(dmKP, *stateDir, *seed))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dm-keypackage failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Println(kp)
 	case "dm-init":
@@ -57,157 +81,1501 @@ func main() {
 		stateDir := dmInit.String("state-dir", "", "directory for participant state")
 		peerKP := dmInit.String("peer-keypackage", "", "base64-encoded peer KeyPackage")
 		groupID := dmInit.String("group-id", "ZHMtZG0tZ3JvdXA=", "base64 group ID")
+		suite := dmInit.String("cipher-suite", "", "cipher suite name (default X25519_AES128GCM_SHA256_Ed25519; must match an existing participant)")
 		seed := dmInit.Int64("seed", 7331, "deterministic RNG seed for commit")
 		if err := dmInit.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-init flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		welcome, commit, err := runDMInit(*stateDir, *peerKP, *groupID, *seed)
+		welcome, commit, err := runDMInit(*stateDir, *peerKP, *groupID, *suite, dmSeed(dmInit, *stateDir, *seed))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dm-init failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Printf("{\"welcome\":\"%s\",\"commit\":\"%s\"}\n", welcome, commit)
 	case "group-init":
 		groupInit := flag.NewFlagSet("group-init", flag.ExitOnError)
 		stateDir := groupInit.String("state-dir", "", "directory for participant state")
 		groupID := groupInit.String("group-id", "ZHMtZG0tZ3JvdXA=", "base64 group ID")
+		suite := groupInit.String("cipher-suite", "", "cipher suite name (default X25519_AES128GCM_SHA256_Ed25519; must match an existing participant)")
 		seed := groupInit.Int64("seed", 7331, "deterministic RNG seed for commit")
 		var peerKPs stringSlice
 		groupInit.Var(&peerKPs, "peer-keypackage", "base64-encoded peer KeyPackage (repeatable)")
 		if err := groupInit.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse group-init flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		welcome, commit, err := runGroupInit(*stateDir, peerKPs, *groupID, *seed)
+		welcome, commit, err := runGroupInit(*stateDir, peerKPs, *groupID, *suite, dmSeed(groupInit, *stateDir, *seed))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "group-init failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Printf("{\"welcome\":\"%s\",\"commit\":\"%s\"}\n", welcome, commit)
 	case "group-add":
 		groupAdd := flag.NewFlagSet("group-add", flag.ExitOnError)
 		stateDir := groupAdd.String("state-dir", "", "directory for participant state")
+		groupID := groupAdd.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
 		seed := groupAdd.Int64("seed", 7331, "deterministic RNG seed for commit")
 		var peerKPs stringSlice
 		groupAdd.Var(&peerKPs, "peer-keypackage", "base64-encoded peer KeyPackage (repeatable)")
 		if err := groupAdd.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse group-add flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		welcome, commit, proposals, err := runGroupAdd(*stateDir, peerKPs, *seed)
+		welcome, commit, proposals, err := runGroupAdd(*stateDir, *groupID, peerKPs, dmSeed(groupAdd, *stateDir, *seed))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "group-add failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		proposalsJSON, err := json.Marshal(proposals)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "group-add failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Printf("{\"welcome\":\"%s\",\"commit\":\"%s\",\"proposals\":%s}\n", welcome, commit, proposalsJSON)
+	case "dm-remove":
+		dmRemove := flag.NewFlagSet("dm-remove", flag.ExitOnError)
+		stateDir := dmRemove.String("state-dir", "", "directory for participant state")
+		groupID := dmRemove.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		member := dmRemove.String("member", "", "leaf index or credential identity of the member to remove")
+		seed := dmRemove.Int64("seed", 7331, "deterministic RNG seed for commit")
+		if err := dmRemove.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		commit, proposals, err := runDMRemove(*stateDir, *groupID, *member, dmSeed(dmRemove, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		proposalsJSON, err := json.Marshal(proposals)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"commit\":\"%s\",\"proposals\":%s}\n", commit, proposalsJSON)
+	case "dm-update":
+		dmUpdate := flag.NewFlagSet("dm-update", flag.ExitOnError)
+		stateDir := dmUpdate.String("state-dir", "", "directory for participant state")
+		groupID := dmUpdate.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		seed := dmUpdate.Int64("seed", 7331, "deterministic RNG seed for the new leaf key and commit")
+		if err := dmUpdate.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		commit, proposals, err := runDMUpdate(*stateDir, *groupID, dmSeed(dmUpdate, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		proposalsJSON, err := json.Marshal(proposals)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"commit\":\"%s\",\"proposals\":%s}\n", commit, proposalsJSON)
+	case "dm-maybe-rotate":
+		dmMaybeRotate := flag.NewFlagSet("dm-maybe-rotate", flag.ExitOnError)
+		stateDir := dmMaybeRotate.String("state-dir", "", "directory for participant state")
+		groupID := dmMaybeRotate.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		seed := dmMaybeRotate.Int64("seed", 7331, "deterministic RNG seed for the new leaf key and commit")
+		if err := dmMaybeRotate.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		commit, proposals, err := runDMMaybeRotate(*stateDir, *groupID, dmSeed(dmMaybeRotate, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		if proposals == nil {
+			proposals = []string{}
+		}
+		out, err := json.Marshal(struct {
+			Rotated   bool     `json:"rotated"`
+			Commit    string   `json:"commit"`
+			Proposals []string `json:"proposals"`
+		}{commit != "", commit, proposals})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-propose-add", "dm-propose-remove", "dm-propose-update":
+		propose := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		stateDir := propose.String("state-dir", "", "directory for participant state")
+		groupID := propose.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		peerKP := propose.String("peer-keypackage", "", "base64-encoded KeyPackage to add (dm-propose-add)")
+		member := propose.String("member", "", "leaf index or credential identity to remove (dm-propose-remove)")
+		seed := propose.Int64("seed", 7331, "deterministic RNG seed for the new leaf key (dm-propose-update)")
+		if err := propose.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		proposal, err := runDMPropose(*stateDir, *groupID, strings.TrimPrefix(os.Args[1], "dm-propose-"), *peerKP, *member, dmSeed(propose, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"proposal\":\"%s\"}\n", proposal)
+	case "dm-leave":
+		dmLeave := flag.NewFlagSet("dm-leave", flag.ExitOnError)
+		stateDir := dmLeave.String("state-dir", "", "directory for participant state")
+		groupID := dmLeave.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		seed := dmLeave.Int64("seed", 7331, "deterministic RNG seed for the proposal")
+		if err := dmLeave.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		proposal, err := runDMLeave(*stateDir, *groupID, dmSeed(dmLeave, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"proposal\":\"%s\"}\n", proposal)
+	case "dm-commit-pending":
+		commitPending := flag.NewFlagSet("dm-commit-pending", flag.ExitOnError)
+		stateDir := commitPending.String("state-dir", "", "directory for participant state")
+		groupID := commitPending.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		seed := commitPending.Int64("seed", 7331, "deterministic RNG seed for commit")
+		if err := commitPending.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		welcome, commit, err := runDMCommitPending(*stateDir, *groupID, dmSeed(commitPending, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"welcome\":\"%s\",\"commit\":\"%s\"}\n", welcome, commit)
+	case "dm-handle-proposal":
+		handleProposal := flag.NewFlagSet("dm-handle-proposal", flag.ExitOnError)
+		stateDir := handleProposal.String("state-dir", "", "directory for participant state")
+		groupID := handleProposal.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		proposal := handleProposal.String("proposal", "", "base64-encoded proposal MLSPlaintext")
+		if err := handleProposal.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		noop, err := runDMHandleProposal(*stateDir, *groupID, *proposal)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"noop\":%t}\n", noop)
 	case "dm-join":
 		dmJoin := flag.NewFlagSet("dm-join", flag.ExitOnError)
 		stateDir := dmJoin.String("state-dir", "", "directory for participant state")
+		groupID := dmJoin.String("group-id", "", "base64 group ID the Welcome must be for (any when omitted)")
 		welcome := dmJoin.String("welcome", "", "base64-encoded Welcome message")
 		if err := dmJoin.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-join flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		if err := runDMJoin(*stateDir, *welcome); err != nil {
-			fmt.Fprintf(os.Stderr, "dm-join failed: %v\n", err)
-			os.Exit(1)
+		if err := runDMJoin(*stateDir, *groupID, *welcome); err != nil {
+			fatal(1, "command failed", err)
 		}
 	case "dm-commit-apply":
 		dmApply := flag.NewFlagSet("dm-commit-apply", flag.ExitOnError)
 		stateDir := dmApply.String("state-dir", "", "directory for participant state")
+		groupID := dmApply.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
 		commit := dmApply.String("commit", "", "base64-encoded commit MLSPlaintext")
 		if err := dmApply.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-commit-apply flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		if err := runDMCommitApply(*stateDir, *commit); err != nil {
-			fmt.Fprintf(os.Stderr, "dm-commit-apply failed: %v\n", err)
-			os.Exit(1)
+		if err := runDMCommitApply(*stateDir, *groupID, *commit); err != nil {
+			fatal(1, "command failed", err)
 		}
 	case "dm-encrypt":
 		dmEnc := flag.NewFlagSet("dm-encrypt", flag.ExitOnError)
 		stateDir := dmEnc.String("state-dir", "", "directory for participant state")
+		groupID := dmEnc.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
 		plaintext := dmEnc.String("plaintext", "", "plaintext to encrypt")
+		framed := dmEnc.Bool("framed", false, "send the plaintext in a message frame with the metadata below")
+		contentType := dmEnc.String("content-type", dm.DefaultContentType, "framed message content type")
+		timestampMs := dmEnc.Uint64("timestamp-ms", 0, "framed message timestamp in Unix milliseconds (default now)")
+		padding := dmEnc.Int("padding", 0, "zero bytes of padding in the framed message")
+		content := dmEnc.String("content", "", "typed content as JSON, e.g. {\"kind\":\"typing\",\"typing\":{\"active\":true}}, sent framed in place of --plaintext")
 		if err := dmEnc.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-encrypt flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
+		}
+		var message *dm.Message
+		if *content != "" {
+			var typed dm.Content
+			if err := json.Unmarshal([]byte(*content), &typed); err != nil {
+				fatal(2, "failed to parse flags", fmt.Errorf("content: %w", err))
+			}
+			encoded, err := dm.EncodeContent(typed)
+			if err != nil {
+				fatal(2, "failed to parse flags", err)
+			}
+			encoded.TimestampMs, encoded.Padding = *timestampMs, *padding
+			message = &encoded
+		} else if *framed {
+			message = &dm.Message{ContentType: *contentType, TimestampMs: *timestampMs, Padding: *padding, Body: *plaintext}
 		}
-		ct, err := runDMEncrypt(*stateDir, *plaintext)
+		if message != nil && message.TimestampMs == 0 {
+			message.TimestampMs = uint64(dm.Now().UnixMilli())
+		}
+		ct, err := runDMEncrypt(*stateDir, *groupID, *plaintext, message)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dm-encrypt failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Println(ct)
 	case "dm-decrypt":
 		dmDec := flag.NewFlagSet("dm-decrypt", flag.ExitOnError)
 		stateDir := dmDec.String("state-dir", "", "directory for participant state")
+		groupID := dmDec.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
 		ciphertext := dmDec.String("ciphertext", "", "base64-encoded MLSCiphertext")
+		metadata := dmDec.Bool("metadata", false, "print the message and its metadata as JSON instead of the body")
 		if err := dmDec.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse dm-decrypt flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
-		pt, err := runDMDecrypt(*stateDir, *ciphertext)
+		pt, err := runDMDecrypt(*stateDir, *groupID, *ciphertext, *metadata)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "dm-decrypt failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
 		}
 		fmt.Println(pt)
+	case "dm-encrypt-batch":
+		dmEncBatch := flag.NewFlagSet("dm-encrypt-batch", flag.ExitOnError)
+		stateDir := dmEncBatch.String("state-dir", "", "directory for participant state")
+		groupID := dmEncBatch.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		var plaintexts stringSlice
+		dmEncBatch.Var(&plaintexts, "plaintext", "plaintext to encrypt (repeatable, sent in order)")
+		if err := dmEncBatch.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		cts, err := runDMEncryptBatch(*stateDir, *groupID, plaintexts)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(map[string]interface{}{"ciphertexts": cts})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-decrypt-batch":
+		dmDecBatch := flag.NewFlagSet("dm-decrypt-batch", flag.ExitOnError)
+		stateDir := dmDecBatch.String("state-dir", "", "directory for participant state")
+		groupID := dmDecBatch.String("group-id", "", "base64 group ID (empty routes each message by its group)")
+		var ciphertexts stringSlice
+		dmDecBatch.Var(&ciphertexts, "ciphertext", "base64-encoded MLSCiphertext (repeatable, decrypted in order)")
+		if err := dmDecBatch.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		messages, err := runDMDecryptBatch(*stateDir, *groupID, ciphertexts)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(map[string]interface{}{"messages": messages})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-info":
+		dmInfo := flag.NewFlagSet("dm-info", flag.ExitOnError)
+		stateDir := dmInfo.String("state-dir", "", "directory for participant state")
+		groupID := dmInfo.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		if err := dmInfo.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		info, err := runDMInfo(*stateDir, *groupID)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(info)
+	case "dm-generations":
+		dmGenerations := flag.NewFlagSet("dm-generations", flag.ExitOnError)
+		stateDir := dmGenerations.String("state-dir", "", "directory for participant state")
+		groupID := dmGenerations.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		if err := dmGenerations.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		generations, err := runDMGenerations(*stateDir, *groupID)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(generations)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-export-secret", "dm-history-key":
+		dmExport := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		stateDir := dmExport.String("state-dir", "", "directory for participant state")
+		groupID := dmExport.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		var label, context *string
+		var length *int
+		if os.Args[1] == "dm-export-secret" {
+			label = dmExport.String("label", "", "exporter label")
+			context = dmExport.String("context", "", "base64 exporter context")
+			length = dmExport.Int("length", 32, "bytes to export")
+		}
+		if err := dmExport.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		var exported map[string]interface{}
+		var err error
+		if label != nil {
+			exported, err = runDMExportSecret(*stateDir, *groupID, *label, *context, *length)
+		} else {
+			exported, err = runDMHistoryKey(*stateDir, *groupID)
+		}
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(exported)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-pending", "dm-pending-apply", "dm-pending-discard":
+		pending := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+		stateDir := pending.String("state-dir", "", "directory for participant state")
+		groupID := pending.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		commitHash := pending.String("commit-hash", "", "hex commit hash from dm-pending (apply: the oldest; discard: all when omitted)")
+		if err := pending.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		out, err := runDMPending(*stateDir, *groupID, strings.TrimPrefix(os.Args[1], "dm-pending"), *commitHash)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(out)
+	case "dm-groups":
+		dmGroups := flag.NewFlagSet("dm-groups", flag.ExitOnError)
+		stateDir := dmGroups.String("state-dir", "", "directory for participant state")
+		if err := dmGroups.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		groups, err := runDMGroups(*stateDir)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		groupsJSON, err := json.Marshal(groups)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"groups\":%s}\n", groupsJSON)
+	case "dm-keypackage-pool":
+		dmPool := flag.NewFlagSet("dm-keypackage-pool", flag.ExitOnError)
+		stateDir := dmPool.String("state-dir", "", "directory for participant state")
+		count := dmPool.Int("count", 10, "number of one-time KeyPackages to add (0 only reports the pool)")
+		seed := dmPool.Int64("seed", 4242, "deterministic RNG seed for the init secrets")
+		if err := dmPool.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		kps, available, consumed, err := runDMKeyPackagePool(*stateDir, *count, dmSeed(dmPool, *stateDir, *seed))
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(map[string]interface{}{"keypackages": kps, "available": available, "consumed": consumed})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-split-welcome":
+		dmSplit := flag.NewFlagSet("dm-split-welcome", flag.ExitOnError)
+		welcome := dmSplit.String("welcome", "", "base64-encoded Welcome for one or more new members")
+		if err := dmSplit.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		split, err := dm.SplitWelcome(*welcome)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(map[string]interface{}{"welcomes": split})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-keypackage-hash":
+		dmHash := flag.NewFlagSet("dm-keypackage-hash", flag.ExitOnError)
+		kp := dmHash.String("keypackage", "", "base64-encoded KeyPackage")
+		if err := dmHash.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		hash, err := dm.KeyPackageHash(*kp)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(hash)
+	case "dm-validate-keypackage":
+		dmValidate := flag.NewFlagSet("dm-validate-keypackage", flag.ExitOnError)
+		kp := dmValidate.String("keypackage", "", "base64-encoded peer KeyPackage")
+		if err := dmValidate.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		report, err := dm.ValidateKeyPackage(*kp)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(report)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+		if !report.Valid {
+			os.Exit(1)
+		}
+	case "dm-artifact":
+		dmArtifact := flag.NewFlagSet("dm-artifact", flag.ExitOnError)
+		artifact := dmArtifact.String("artifact", "", "base64-encoded artifact in an envelope")
+		if err := dmArtifact.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		info, err := dm.InspectArtifact(*artifact)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(info)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-group-id":
+		dmGroupID := flag.NewFlagSet("dm-group-id", flag.ExitOnError)
+		stateDir := dmGroupID.String("state-dir", "", "directory for the founder's participant state")
+		nonce := dmGroupID.String("nonce", "", "base64 creation nonce of at least 16 bytes (random when omitted)")
+		if err := dmGroupID.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		groupID, nonceB64, err := runDMGroupID(*stateDir, *nonce)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		out, err := json.Marshal(map[string]string{"group_id": groupID, "nonce": nonceB64})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(out))
+	case "dm-identity-message":
+		dmMessage := flag.NewFlagSet("dm-identity-message", flag.ExitOnError)
+		stateDir := dmMessage.String("state-dir", "", "directory for participant state")
+		if err := dmMessage.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		message, err := runDMIdentityMessage(*stateDir)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(message)
+	case "dm-bind-identity":
+		dmBind := flag.NewFlagSet("dm-bind-identity", flag.ExitOnError)
+		stateDir := dmBind.String("state-dir", "", "directory for participant state")
+		userID := dmBind.String("user-id", "", "polycentric user ID (URL-safe base64 Ed25519 public key)")
+		signature := dmBind.String("signature", "", "base64 Ed25519 signature by the user key over dm-identity-message")
+		if err := dmBind.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		kp, err := runDMBindIdentity(*stateDir, *userID, *signature)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(kp)
+	case "dm-retention":
+		dmRetention := flag.NewFlagSet("dm-retention", flag.ExitOnError)
+		stateDir := dmRetention.String("state-dir", "", "directory for participant state")
+		maxSkipped := dmRetention.Uint("max-skipped-generations", uint(dm.DefaultRetention.MaxSkippedGenerations), "keys kept per sender for skipped messages, and how far ahead a message may be")
+		maxPast := dmRetention.Uint("max-past-epochs", uint(dm.DefaultRetention.MaxPastEpochs), "earlier epochs kept to decrypt late messages")
+		if err := dmRetention.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		policy := dm.RetentionPolicy{MaxSkippedGenerations: uint32(*maxSkipped), MaxPastEpochs: uint32(*maxPast)}
+		if err := runDMRetention(*stateDir, policy); err != nil {
+			fatal(1, "command failed", err)
+		}
+		policyJSON, err := json.Marshal(policy)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(policyJSON))
+	case "dm-padding":
+		dmPadding := flag.NewFlagSet("dm-padding", flag.ExitOnError)
+		stateDir := dmPadding.String("state-dir", "", "directory for participant state")
+		buckets := dmPadding.String("buckets", "", "comma-separated ascending frame sizes to pad sent messages to, e.g. 256,1024,4096 (empty turns padding off)")
+		if err := dmPadding.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		policy, err := parsePaddingBuckets(*buckets)
+		if err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		if err := runDMPadding(*stateDir, policy); err != nil {
+			fatal(1, "command failed", err)
+		}
+		policyJSON, err := json.Marshal(policy)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(policyJSON))
+	case "dm-rotation":
+		dmRotation := flag.NewFlagSet("dm-rotation", flag.ExitOnError)
+		stateDir := dmRotation.String("state-dir", "", "directory for participant state")
+		maxMessages := dmRotation.Uint("max-messages", 0, "messages sent in a group before dm-maybe-rotate refreshes the leaf (0 turns the bound off)")
+		maxEpochs := dmRotation.Uint("max-epochs", 0, "epochs since the leaf last changed before dm-maybe-rotate refreshes it (0 turns the bound off)")
+		if err := dmRotation.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		policy := dm.RotationPolicy{MaxMessages: uint32(*maxMessages), MaxEpochs: uint32(*maxEpochs)}
+		if err := runDMRotation(*stateDir, policy); err != nil {
+			fatal(1, "command failed", err)
+		}
+		policyJSON, err := json.Marshal(policy)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(policyJSON))
+	case "dm-destroy":
+		dmDestroy := flag.NewFlagSet("dm-destroy", flag.ExitOnError)
+		stateDir := dmDestroy.String("state-dir", "", "directory for participant state")
+		if err := dmDestroy.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		if err := runDMDestroy(*stateDir); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "dm-admins":
+		dmAdmins := flag.NewFlagSet("dm-admins", flag.ExitOnError)
+		stateDir := dmAdmins.String("state-dir", "", "directory for participant state")
+		groupID := dmAdmins.String("group-id", "", "base64 group ID (optional while the participant is in one group)")
+		admins := dmAdmins.String("admins", "", "comma-separated credential identities allowed to add and remove members (empty allows every member)")
+		if err := dmAdmins.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		list := []string{}
+		if *admins != "" {
+			list = strings.Split(*admins, ",")
+		}
+		set, err := runDMAdmins(*stateDir, *groupID, list)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		adminsJSON, err := json.Marshal(map[string][]string{"admins": set})
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Println(string(adminsJSON))
+	case "dm-backup-export":
+		dmExport := flag.NewFlagSet("dm-backup-export", flag.ExitOnError)
+		stateDir := dmExport.String("state-dir", "", "directory for participant state")
+		out := dmExport.String("out", "", "file to write the backup to (default stdout)")
+		if err := dmExport.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		backup, err := runDMBackupExport(*stateDir)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		if *out == "" {
+			fmt.Println(backup)
+		} else if err := os.WriteFile(*out, []byte(backup), 0o600); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "dm-backup-import":
+		dmImport := flag.NewFlagSet("dm-backup-import", flag.ExitOnError)
+		stateDir := dmImport.String("state-dir", "", "empty directory for the restored participant state")
+		backupPath := dmImport.String("backup", "", "file holding a backup from dm-backup-export")
+		if err := dmImport.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+		groups, err := runDMBackupImport(*stateDir, *backupPath)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		groupsJSON, err := json.Marshal(groups)
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+		fmt.Printf("{\"groups\":%s}\n", groupsJSON)
 	case "vectors":
 		vectors := flag.NewFlagSet("vectors", flag.ExitOnError)
 		vectorFile := vectors.String("vector-file", "", "path to vector JSON file")
+		generate := vectors.String("generate", "", "print a new vector with this name instead of verifying")
+		iterations := vectors.Int("iterations", 20, "message rounds of a generated vector")
+		formatVersion := vectors.Int("format-version", harness.VectorFormatV1, "format version of a generated vector (1 or 2)")
+		suite := vectors.String("cipher-suite", dm.DefaultCipherSuite.String(), "cipher suite of a generated version 2 vector")
+		participants := vectors.Int("participants", 2, "group size of a generated version 2 vector")
+		payloadKind := vectors.String("payload", "counter", "payload generator of a generated version 2 vector (counter or random)")
+		payloadMin := vectors.Int("payload-min-bytes", 0, "smallest random payload")
+		payloadMax := vectors.Int("payload-max-bytes", 0, "largest random payload")
+		payloadSeed := vectors.Int64("payload-seed", 0, "seed of the random payloads")
+		transcriptOut := vectors.String("transcript-out", "", "write the transcript the digest covers to this file")
+		reference := vectors.String("reference-transcript", "", "on a mismatch, report the first entry that differs from this transcript")
+		entryDigests := vectors.Bool("entry-digests", false, "record the running digest after every entry in a generated vector")
 		if err := vectors.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse vectors flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
 
-		if err := runVectors(*vectorFile); err != nil {
-			fmt.Fprintf(os.Stderr, "vector verification failed: %v\n", err)
-			os.Exit(1)
+		if *generate != "" {
+			template := &harness.VectorSpec{Name: *generate, Suite: *suite, Iterations: *iterations}
+			if *formatVersion != harness.VectorFormatV1 {
+				template.FormatVersion = *formatVersion
+				template.Participants = *participants
+				if *payloadKind != "counter" {
+					template.Payload = &harness.VectorPayload{Kind: *payloadKind, MinBytes: *payloadMin, MaxBytes: *payloadMax, Seed: *payloadSeed}
+				}
+			}
+			if err := runGenerateVectors(template, *entryDigests); err != nil {
+				fatal(1, "vector generation failed", err)
+			}
+		} else if err := runVectors(*vectorFile, *transcriptOut, *reference); err != nil {
+			fatal(1, "vector verification failed", err)
 		}
 	case "wg-vectors":
 		wgVectors := flag.NewFlagSet("wg-vectors", flag.ExitOnError)
 		dir := wgVectors.String("vectors-dir", defaultWGVectorsDir, "directory containing MLSWG JSON vectors")
 		maxBytes := wgVectors.Int64("max-bytes", defaultWGMaxBytes, "maximum size per vector file in bytes")
 		if err := wgVectors.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse wg-vectors flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
 		}
 
 		if err := runWGVectors(*dir, *maxBytes); err != nil {
-			fmt.Fprintf(os.Stderr, "wg-vectors failed: %v\n", err)
-			os.Exit(1)
+			fatal(1, "command failed", err)
+		}
+	case "diff-crypto":
+		diffCrypto := flag.NewFlagSet("diff-crypto", flag.ExitOnError)
+		suites := diffCrypto.String("suites", "all", "comma-separated cipher suites to cross-check, or all")
+		cases := diffCrypto.Int("cases", 200, "randomized cases per suite")
+		seed := diffCrypto.Int64("seed", 1, "seed for the randomized inputs")
+		if err := diffCrypto.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runDiffCrypto(*suites, *cases, *seed); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "commit-race":
+		commitRace := flag.NewFlagSet("commit-race", flag.ExitOnError)
+		iterations := commitRace.Int("iterations", 10, "message iterations after the race resolves")
+		winner := commitRace.String("winner", "alice", "member whose commit the delivery service orders first (alice or bob)")
+		coverageReport := commitRace.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := commitRace.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := commitRace.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := commitRace.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("commit-race", *coverageReport)
+		startEpochChain("commit-race", *recordChain, *verifyChain)
+		err := runCommitRace(*iterations, *winner)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "sizes":
+		sizesFlags := flag.NewFlagSet("sizes", flag.ExitOnError)
+		suites := sizesFlags.String("suites", "all", "comma-separated cipher suites to measure, or all")
+		groupSizes := sizesFlags.String("group-sizes", "2,10,50", "comma-separated group sizes to measure")
+		messageBytes := sizesFlags.Int("message-bytes", 100, "application message length used for the ciphertext size")
+		format := sizesFlags.String("format", "table", "output format: table or json")
+		if err := sizesFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runSizes(*suites, *groupSizes, *messageBytes, *format); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "inspect":
+		inspect := flag.NewFlagSet("inspect", flag.ExitOnError)
+		kind := inspect.String("type", "auto", "artifact type: auto, keypackage, welcome, plaintext or ciphertext")
+		encoding := inspect.String("encoding", "auto", "artifact encoding: auto, hex or base64")
+		value := inspect.String("value", "", "encoded artifact (read from stdin when omitted)")
+		if err := inspect.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		artifact := *value
+		if artifact == "" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fatal(1, "failed to read artifact from stdin", err)
+			}
+			artifact = string(data)
+		}
+		if err := runInspect(*kind, *encoding, artifact); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "fuzz":
+		fuzzFlags := flag.NewFlagSet("fuzz", flag.ExitOnError)
+		targets := fuzzFlags.String("targets", "all", "comma-separated fuzz targets (keypackage, welcome, ciphertext, participant) or all")
+		iterations := fuzzFlags.Int("iterations", 2000, "mutated inputs per target")
+		seed := fuzzFlags.Int64("seed", 1, "mutation RNG seed")
+		timeout := fuzzFlags.Duration("timeout", 5*time.Second, "longest a single input may run before it counts as a hang")
+		crashDir := fuzzFlags.String("crash-dir", "fuzz-crashes", "directory where failing inputs are written")
+		corpusDir := fuzzFlags.String("corpus", "", "corpus directory whose samples are added to the seeds")
+		if err := fuzzFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runFuzz(*targets, *iterations, *seed, *timeout, *crashDir, *corpusDir); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "corpus":
+		corpusFlags := flag.NewFlagSet("corpus", flag.ExitOnError)
+		outDir := corpusFlags.String("out", "", "directory to write the samples and manifest.json to")
+		suites := corpusFlags.String("suites", "all", "comma-separated cipher suites to generate samples for, or all")
+		scenarioFiles := corpusFlags.String("scenario-files", defaultCorpusScenarios, "comma-separated scenario files or globs to run")
+		verifyDir := corpusFlags.String("verify", "", "check the corpus in this directory against its manifest and decode every sample instead")
+		if err := corpusFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		var err error
+		if *verifyDir != "" {
+			err = runVerifyCorpus(*verifyDir)
+		} else {
+			err = runCorpus(*outDir, *suites, *scenarioFiles)
+		}
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "doctor":
+		doctor := flag.NewFlagSet("doctor", flag.ExitOnError)
+		vectorsDir := doctor.String("vectors-dir", "vectors", "directory containing the bundled vector files")
+		suites := doctor.String("suites", "all", "comma-separated cipher suites to round-trip, or all")
+		if err := doctor.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runDoctor(*vectorsDir, *suites); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "chaos":
+		chaosFlags := flag.NewFlagSet("chaos", flag.ExitOnError)
+		epochs := chaosFlags.Int("epochs", 20, "number of commits to issue")
+		iterations := chaosFlags.Int("iterations", 3, "message iterations per epoch")
+		dropRate := chaosFlags.Float64("drop-commit-rate", 0.2, "probability that a commit is withheld from the non-committing member")
+		heal := chaosFlags.Bool("heal", false, "re-deliver a withheld commit once the desync is detected and keep going")
+		seed := chaosFlags.Int64("seed", 1, "seed for drop decisions")
+		coverageReport := chaosFlags.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := chaosFlags.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := chaosFlags.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := chaosFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("chaos", *coverageReport)
+		startEpochChain("chaos", *recordChain, *verifyChain)
+		err := runChaos(*epochs, *iterations, *dropRate, *heal, *seed)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "kp-expiry":
+		kpExpiry := flag.NewFlagSet("kp-expiry", flag.ExitOnError)
+		lifetime := kpExpiry.Duration("lifetime", time.Hour, "validity window of the short-lived KeyPackages")
+		timeTravel := kpExpiry.Duration("time-travel", 2*time.Hour, "how far in the past (expired case) or future (not-yet-valid case) KeyPackages are issued")
+		coverageReport := kpExpiry.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		if err := kpExpiry.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("kp-expiry", *coverageReport)
+		err := runKeyPackageExpiry(*lifetime, *timeTravel, harness.SystemClock{})
+		finishCoverage(*coverageReport)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "multi-device":
+		multiDevice := flag.NewFlagSet("multi-device", flag.ExitOnError)
+		iterations := multiDevice.Int("iterations", 5, "broadcast rounds after each membership change")
+		coverageReport := multiDevice.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := multiDevice.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := multiDevice.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := multiDevice.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("multi-device", *coverageReport)
+		startEpochChain("multi-device", *recordChain, *verifyChain)
+		err := runMultiDevice(*iterations)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "scale":
+		scale := flag.NewFlagSet("scale", flag.ExitOnError)
+		maxMembers := scale.Int("max-members", 128, "grow the group to this many members")
+		batch := scale.Int("batch", 16, "members added per commit")
+		maxGrowth := scale.Float64("max-growth", 3.0, "fail if a per-member cost at the final size exceeds this multiple of the first batch")
+		coverageReport := scale.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := scale.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := scale.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := scale.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("scale", *coverageReport)
+		startEpochChain("scale", *recordChain, *verifyChain)
+		err := runScale(*maxMembers, *batch, *maxGrowth)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "delivery-order":
+		deliveryOrder := flag.NewFlagSet("delivery-order", flag.ExitOnError)
+		members := deliveryOrder.Int("members", 3, "members of the group before the commit")
+		joiners := deliveryOrder.Int("joiners", 2, "members the commit adds")
+		duplicates := deliveryOrder.Bool("duplicates", true, "deliver the commit and the Welcome twice to each recipient")
+		suite := deliveryOrder.String("cipher-suite", dm.DefaultCipherSuite.String(), "cipher suite of the group")
+		if err := deliveryOrder.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runDeliveryOrder(*members, *joiners, *duplicates, *suite); err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "welcome-loss":
+		welcomeLoss := flag.NewFlagSet("welcome-loss", flag.ExitOnError)
+		epochs := welcomeLoss.Int("epochs", 3, "epochs the group advances before the lost member is re-invited")
+		iterations := welcomeLoss.Int("iterations", 5, "message iterations per epoch and after the re-invite")
+		coverageReport := welcomeLoss.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := welcomeLoss.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := welcomeLoss.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := welcomeLoss.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("welcome-loss", *coverageReport)
+		startEpochChain("welcome-loss", *recordChain, *verifyChain)
+		err := runWelcomeLoss(*epochs, *iterations)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "forward-secrecy":
+		forwardSecrecy := flag.NewFlagSet("forward-secrecy", flag.ExitOnError)
+		epochs := forwardSecrecy.Int("epochs", 5, "epochs the group advances after the snapshot")
+		iterations := forwardSecrecy.Int("iterations", 3, "ciphertexts checked against the snapshot per epoch")
+		stateDir := forwardSecrecy.String("state-dir", "", "directory for the state snapshots (kept in memory when empty)")
+		coverageReport := forwardSecrecy.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := forwardSecrecy.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := forwardSecrecy.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := forwardSecrecy.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("forward-secrecy", *coverageReport)
+		startEpochChain("forward-secrecy", *recordChain, *verifyChain)
+		err := runForwardSecrecy(*epochs, *iterations, *stateDir)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "post-compromise":
+		postCompromise := flag.NewFlagSet("post-compromise", flag.ExitOnError)
+		iterations := postCompromise.Int("iterations", 3, "ciphertexts captured before and after the update")
+		committer := postCompromise.String("committer", "bob", "member who commits bob's update (bob or alice)")
+		stateDir := postCompromise.String("state-dir", "", "directory for the state snapshots (kept in memory when empty)")
+		coverageReport := postCompromise.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := postCompromise.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := postCompromise.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		if err := postCompromise.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("post-compromise", *coverageReport)
+		startEpochChain("post-compromise", *recordChain, *verifyChain)
+		err := runPostCompromise(*iterations, *committer, *stateDir)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "scenario":
+		scenarioFlags := flag.NewFlagSet("scenario", flag.ExitOnError)
+		scenarioFile := scenarioFlags.String("scenario-file", "", "JSON scenario to run")
+		stateDir := scenarioFlags.String("state-dir", "", "directory checkpoint steps persist members to (kept in memory when empty)")
+		coverageReport := scenarioFlags.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := scenarioFlags.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := scenarioFlags.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
+		transcriptOut := scenarioFlags.String("transcript-out", "", "write the transcript the digest covers to this file")
+		if err := scenarioFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		startCoverage("scenario", *coverageReport)
+		startEpochChain("scenario", *recordChain, *verifyChain)
+		err := runScenario(*scenarioFile, *stateDir, *transcriptOut)
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "property":
+		property := flag.NewFlagSet("property", flag.ExitOnError)
+		cases := property.Int("cases", 20, "random scenarios to run")
+		seed := property.Int64("seed", 1, "seed of the first scenario; each next one adds 1")
+		suite := property.String("cipher-suite", dm.DefaultCipherSuite.String(), "cipher suite of the groups")
+		members := property.Int("members", 2, "members each group starts with")
+		maxMembers := property.Int("max-members", 8, "largest a group may grow")
+		steps := property.Int("steps", 20, "random operations per scenario")
+		payloadMin := property.Int("payload-min-bytes", 1, "smallest message")
+		payloadMax := property.Int("payload-max-bytes", 256, "largest message")
+		if err := property.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		cfg := proptest.Config{Members: *members, MaxMembers: *maxMembers, Steps: *steps, MinPayload: *payloadMin, MaxPayload: *payloadMax}
+		if err := runProperty(*cases, *seed, *suite, cfg); err != nil {
+			fatal(1, "property failed", err)
+		}
+	case "transcript":
+		transcriptFlags := flag.NewFlagSet("transcript", flag.ExitOnError)
+		file := transcriptFlags.String("file", "", "transcript written by --transcript-out")
+		digest := transcriptFlags.String("digest", "", "check the recomputed digest against this hex value")
+		reference := transcriptFlags.String("reference", "", "report the first entry that differs from this transcript")
+		if err := transcriptFlags.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runTranscript(*file, *digest, *reference); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "state-compat":
+		stateCompat := flag.NewFlagSet("state-compat", flag.ExitOnError)
+		dir := stateCompat.String("fixtures-dir", defaultStateCompatDir, "directory containing persisted-state fixtures from previous releases")
+		iterations := stateCompat.Int("iterations", 5, "message iterations to run after decoding each fixture")
+		generate := stateCompat.String("generate", "", "write a new fixture set with this name instead of verifying")
+		warmup := stateCompat.Int("warmup", 3, "messages exchanged before a generated fixture is persisted")
+		if err := stateCompat.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		var err error
+		if *generate != "" {
+			err = generateStateCompatFixture(*dir, *generate, *warmup)
+		} else {
+			err = runStateCompat(*dir, *iterations)
+		}
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "handle-stress":
+		handleStress := flag.NewFlagSet("handle-stress", flag.ExitOnError)
+		workers := handleStress.Int("workers", 8, "goroutines sending each way at once")
+		messages := handleStress.Int("messages", 25, "messages each goroutine sends")
+		if err := handleStress.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runHandleStress(*workers, *messages); err != nil {
+			fatal(1, "scenario failed", err)
 		}
 	case "soak":
 		soak := flag.NewFlagSet("soak", flag.ExitOnError)
 		iterations := soak.Int("iterations", 1000, "number of message iterations per participant")
 		saveEvery := soak.Int("save-every", 50, "checkpoint interval for persisting state")
 		stateDir := soak.String("state-dir", "", "directory to store state snapshots")
+		metricsAddr := soak.String("metrics-addr", "", "serve Prometheus metrics on this address (e.g. 127.0.0.1:9464) while the soak runs")
+		metricsLinger := soak.Duration("metrics-linger", 0, "keep serving metrics this long after the soak finishes so the final values can be scraped")
+		keepCheckpoints := soak.Int("keep-checkpoints", 0, "retain this many timestamped snapshot checkpoints under state-dir/checkpoints (0 keeps only the live snapshot)")
+		compressCheckpoints := soak.Bool("compress-checkpoints", false, "gzip retained checkpoints")
+		limits := harness.DefaultStateLimits()
+		maxCachedKeys := soak.Int("max-cached-keys", limits.MaxCachedKeys, "fail if the ratchet either participant keeps for the other holds more keys than this")
+		maxOwnCachedKeys := soak.Int("max-own-cached-keys", limits.MaxOwnCachedKeys, "fail if a participant's own ratchet holds more keys than this (0 does not check; go-mls keeps one per message sent in the epoch)")
+		transport := soak.String("transport", "memory", "carry ciphertexts over memory, file, tcp or websocket")
+		coverageReport := soak.String("coverage-report", "", "write a JSON summary of the MLS operations exercised to this file")
+		recordChain := soak.String("epoch-chain", "", "write the sequence of epoch authenticators to this file")
+		verifyChain := soak.String("verify-epoch-chain", "", "check the epoch authenticator sequence against this golden file")
 		if err := soak.Parse(os.Args[2:]); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse soak flags: %v\n", err)
-			os.Exit(2)
+			fatal(2, "failed to parse flags", err)
+		}
+
+		metrics, stopMetrics, err := serveSoakMetrics(*metricsAddr)
+		if err != nil {
+			fatal(1, "failed to start metrics server", err)
+		}
+		if *keepCheckpoints < 0 {
+			fatal(2, "invalid flags", fmt.Errorf("keep-checkpoints must not be negative (got %d)", *keepCheckpoints))
+		}
+		if *maxCachedKeys < 0 || *maxOwnCachedKeys < 0 {
+			fatal(2, "invalid flags", fmt.Errorf("max-cached-keys and max-own-cached-keys must not be negative (got %d, %d)", *maxCachedKeys, *maxOwnCachedKeys))
+		}
+		limits.MaxCachedKeys = *maxCachedKeys
+		limits.MaxOwnCachedKeys = *maxOwnCachedKeys
+		startCoverage("soak", *coverageReport)
+		startEpochChain("soak", *recordChain, *verifyChain)
+		err = runSoak(*iterations, *saveEvery, *stateDir, soakOptions{
+			metrics:     metrics,
+			checkpoints: harness.CheckpointPolicy{Keep: *keepCheckpoints, Compress: *compressCheckpoints},
+			transport:   *transport,
+			limits:      limits,
+		})
+		finishCoverage(*coverageReport)
+		err = finishEpochChain(*recordChain, *verifyChain, err)
+		if metrics != nil && *metricsLinger > 0 {
+			time.Sleep(*metricsLinger)
+		}
+		stopMetrics()
+		if err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "serve":
+		serve := flag.NewFlagSet("serve", flag.ExitOnError)
+		addr := serve.String("addr", "127.0.0.1:8089", "address to serve the dm JSON API on")
+		stateDir := serve.String("state-dir", "", "persist participant state in this directory (in memory only when omitted)")
+		if err := serve.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runServe(*addr, *stateDir); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "rpc":
+		if err := runRPC(os.Stdin, os.Stdout); err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "wasm-exchange":
+		wasmExchange := flag.NewFlagSet("wasm-exchange", flag.ExitOnError)
+		module := wasmExchange.String("module", "", "wasip1 build of the harness to run under Node")
+		node := wasmExchange.String("node", "node", "Node executable, 18 or later for node:wasi")
+		iterations := wasmExchange.Int("iterations", 5, "message round trips per layout")
+		if err := wasmExchange.Parse(os.Args[2:]); err != nil {
+			fatal(2, "failed to parse flags", err)
 		}
 
-		if err := runSmoke(*iterations, *saveEvery, *stateDir); err != nil {
-			fmt.Fprintf(os.Stderr, "soak scenario failed: %v\n", err)
-			os.Exit(1)
-		}
-	default:
-		usage()
+		if err := runWASMExchange(*module, *node, *iterations); err != nil {
+			fatal(1, "scenario failed", err)
+		}
+	case "relay":
+		if len(os.Args) < 3 || (os.Args[2] != "serve" && os.Args[2] != "run") {
+			fmt.Fprintf(os.Stderr, "usage: mls-harness relay serve --addr ADDR | relay run --role alice|bob (--url URL | --listen ADDR)\n")
+			os.Exit(2)
+		}
+		relay := flag.NewFlagSet("relay "+os.Args[2], flag.ExitOnError)
+		addr := relay.String("addr", "127.0.0.1:8090", "address the relay listens on (relay serve)")
+		role := relay.String("role", "", "participant this process plays: alice or bob (relay run)")
+		url := relay.String("url", "", "ws:// URL of a relay room or a listening peer (relay run)")
+		listen := relay.String("listen", "", "accept one direct peer connection on this address instead of dialing (relay run)")
+		iterations := relay.Int("iterations", 50, "message round trips once the group is formed (relay run)")
+		timeout := relay.Duration("timeout", 60*time.Second, "give up if the peer has not finished within this long (relay run)")
+		if err := relay.Parse(os.Args[3:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		var err error
+		if os.Args[2] == "serve" {
+			err = runRelayServe(*addr)
+		} else {
+			err = runRelayPeer(*role, *url, *listen, *iterations, *timeout)
+		}
+		if err != nil {
+			fatal(1, "command failed", err)
+		}
+	case "checkpoints":
+		if len(os.Args) < 3 || os.Args[2] != "list" {
+			fmt.Fprintf(os.Stderr, "usage: mls-harness checkpoints list --state-dir DIR\n")
+			os.Exit(2)
+		}
+		checkpoints := flag.NewFlagSet("checkpoints list", flag.ExitOnError)
+		stateDir := checkpoints.String("state-dir", "", "soak state directory whose checkpoints to list")
+		if err := checkpoints.Parse(os.Args[3:]); err != nil {
+			fatal(2, "failed to parse flags", err)
+		}
+
+		if err := runCheckpointsList(*stateDir); err != nil {
+			fatal(1, "command failed", err)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: mls-harness <smoke|doctor|vectors|wg-vectors|diff-crypto|soak|state-compat|handle-stress|commit-race|welcome-loss|delivery-order|forward-secrecy|post-compromise|multi-device|kp-expiry|chaos|scale|scenario|property|transcript|sizes|inspect|fuzz|corpus|checkpoints|serve|rpc|wasm-exchange|relay|dm-*|group-init|group-add> [flags] [--log-level debug|info|warn|error] [--log-format text|json]\n")
+	os.Exit(2)
+}
+
+func runDMKeyPackage(stateDir, name, suite, modeName string, seed int64) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	var kp string
+	if modeName == "" {
+		participantBlob, kp, err = dm.KeyPackage(participantBlob, name, suite, seed)
+	} else {
+		mode, parseErr := dm.ParseMode(modeName)
+		if parseErr != nil {
+			return "", parseErr
+		}
+		participantBlob, kp, err = dm.KeyPackageWithMode(participantBlob, name, suite, mode, seed)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", fmt.Errorf("save participant: %w", err)
+	}
+	return kp, nil
+}
+
+func runDMInit(stateDir, peerKPBase64, groupIDBase64, suite string, seed int64) (string, string, error) {
+	if stateDir == "" {
+		return "", "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", "", errors.New("participant state not initialized; run dm-keypackage first")
+	}
+	participantBlob, welcome, commit, err := dm.Init(participantBlob, peerKPBase64, groupIDBase64, suite, seed)
+	if err != nil {
+		return "", "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", "", fmt.Errorf("save participant: %w", err)
+	}
+	return welcome, commit, nil
+}
+
+func runGroupInit(stateDir string, peerKPs []string, groupIDBase64, suite string, seed int64) (string, string, error) {
+	if stateDir == "" {
+		return "", "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", "", errors.New("participant state not initialized; run dm-keypackage first")
+	}
+	participantBlob, welcome, commit, err := dm.InitMany(participantBlob, peerKPs, groupIDBase64, suite, seed)
+	if err != nil {
+		return "", "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", "", fmt.Errorf("save participant: %w", err)
+	}
+	return welcome, commit, nil
+}
+
+func runGroupAdd(stateDir, groupIDBase64 string, peerKPs []string, seed int64) (string, string, []string, error) {
+	if stateDir == "" {
+		return "", "", nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", "", nil, errors.New("participant state not initialized")
+	}
+	participantBlob, welcome, commit, proposals, err := dm.AddMany(participantBlob, groupIDBase64, peerKPs, seed)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", "", nil, fmt.Errorf("save participant: %w", err)
+	}
+	return welcome, commit, proposals, nil
+}
+
+func runDMRemove(stateDir, groupIDBase64, member string, seed int64) (string, []string, error) {
+	if stateDir == "" {
+		return "", nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", nil, errors.New("participant state not initialized")
+	}
+	participantBlob, commit, proposals, err := dm.Remove(participantBlob, groupIDBase64, member, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", nil, fmt.Errorf("save participant: %w", err)
+	}
+	return commit, proposals, nil
+}
+
+func runDMUpdate(stateDir, groupIDBase64 string, seed int64) (string, []string, error) {
+	if stateDir == "" {
+		return "", nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", nil, errors.New("participant state not initialized")
+	}
+	participantBlob, commit, proposals, err := dm.Update(participantBlob, groupIDBase64, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", nil, fmt.Errorf("save participant: %w", err)
+	}
+	return commit, proposals, nil
+}
+
+func runDMMaybeRotate(stateDir, groupIDBase64 string, seed int64) (string, []string, error) {
+	if stateDir == "" {
+		return "", nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", nil, errors.New("participant state not initialized")
+	}
+	participantBlob, commit, proposals, err := dm.MaybeRotate(participantBlob, groupIDBase64, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	if commit == "" {
+		return "", nil, nil
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", nil, fmt.Errorf("save participant: %w", err)
+	}
+	return commit, proposals, nil
+}
+
+func runDMPropose(stateDir, groupIDBase64, kind, peerKP, member string, seed int64) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	var proposal string
+	switch kind {
+	case "add":
+		participantBlob, proposal, err = dm.ProposeAdd(participantBlob, groupIDBase64, peerKP)
+	case "remove":
+		participantBlob, proposal, err = dm.ProposeRemove(participantBlob, groupIDBase64, member)
+	case "update":
+		participantBlob, proposal, err = dm.ProposeUpdate(participantBlob, groupIDBase64, seed)
+	default:
+		return "", fmt.Errorf("unknown proposal kind %q", kind)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", fmt.Errorf("save participant: %w", err)
+	}
+	return proposal, nil
+}
+
+func runDMLeave(stateDir, groupIDBase64 string, seed int64) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	participantBlob, proposal, err := dm.Leave(participantBlob, groupIDBase64, seed)
+	if err != nil {
+		return "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", fmt.Errorf("save participant: %w", err)
+	}
+	return proposal, nil
+}
+
+func runDMCommitPending(stateDir, groupIDBase64 string, seed int64) (string, string, error) {
+	if stateDir == "" {
+		return "", "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", "", errors.New("participant state not initialized")
+	}
+	participantBlob, welcome, commit, err := dm.CommitPending(participantBlob, groupIDBase64, seed)
+	if err != nil {
+		return "", "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", "", fmt.Errorf("save participant: %w", err)
+	}
+	return welcome, commit, nil
+}
+
+func runDMHandleProposal(stateDir, groupIDBase64, proposalBase64 string) (bool, error) {
+	if stateDir == "" {
+		return false, errors.New("state-dir is required")
+	}
+	if proposalBase64 == "" {
+		return false, errors.New("proposal is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return false, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return false, errors.New("participant state not initialized")
+	}
+	participantBlob, noop, err := dm.HandleProposal(participantBlob, groupIDBase64, proposalBase64)
+	if err != nil {
+		return false, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return false, fmt.Errorf("save participant: %w", err)
+	}
+	return noop, nil
+}
+
+func runDMJoin(stateDir, groupIDBase64, welcomeBase64 string) error {
+	if stateDir == "" {
+		return errors.New("state-dir is required")
+	}
+	if welcomeBase64 == "" {
+		return errors.New("welcome is required")
+	}
+
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return errors.New("participant state not initialized; run dm-keypackage first")
+	}
+	participantBlob, err = dm.Join(participantBlob, groupIDBase64, welcomeBase64)
+	if err != nil {
+		return err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return fmt.Errorf("save participant: %w", err)
+	}
+	return nil
+}
+
+func runDMCommitApply(stateDir, groupIDBase64, commitBase64 string) error {
+	if stateDir == "" {
+		return errors.New("state-dir is required")
+	}
+	if commitBase64 == "" {
+		return errors.New("commit is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return errors.New("participant state not initialized")
+	}
+	participantBlob, _, err = dm.CommitApply(participantBlob, groupIDBase64, commitBase64)
+	if err != nil {
+		return err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return fmt.Errorf("save participant: %w", err)
+	}
+	return nil
+}
+
+// runDMEncrypt sends plaintext bare, or message framed when it is not nil.
+func runDMEncrypt(stateDir, groupIDBase64, plaintext string, message *dm.Message) (string, error) {
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	var ciphertext string
+	if message != nil {
+		participantBlob, ciphertext, err = dm.EncryptMessage(participantBlob, groupIDBase64, *message)
+	} else {
+		participantBlob, ciphertext, err = dm.Encrypt(participantBlob, groupIDBase64, plaintext)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return "", fmt.Errorf("persist state: %w", err)
+	}
+	return ciphertext, nil
+}
+
+func runDMInfo(stateDir, groupIDBase64 string) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	return dm.Info(participantBlob, groupIDBase64)
+}
+
+// runDMGenerations gathers the epoch and message-sequence accessors into the
+// JSON object dm-generations prints.
+func runDMGenerations(stateDir, groupIDBase64 string) (map[string]interface{}, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	epoch, err := dm.Epoch(participantBlob, groupIDBase64)
+	if err != nil {
+		return nil, err
+	}
+	next, err := dm.NextOutgoingGeneration(participantBlob, groupIDBase64)
+	if err != nil {
+		return nil, err
+	}
+	senders, err := dm.LastDecryptedGeneration(participantBlob, groupIDBase64)
+	if err != nil {
+		return nil, err
 	}
+	return map[string]interface{}{"epoch": epoch, "next_outgoing_generation": next, "senders": senders}, nil
 }
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: mls-harness <smoke|vectors|wg-vectors|soak|dm-*|group-init|group-add> [flags]\n")
-	os.Exit(2)
+func runDMExportSecret(stateDir, groupIDBase64, label, contextBase64 string, length int) (map[string]interface{}, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	epoch, secret, err := dm.ExportSecret(participantBlob, groupIDBase64, label, contextBase64, length)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"epoch": epoch, "secret": secret}, nil
 }
 
-func runDMKeyPackage(stateDir, name string, seed int64) (string, error) {
+func runDMHistoryKey(stateDir, groupIDBase64 string) (map[string]interface{}, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	epoch, key, err := dm.HistoryKey(participantBlob, groupIDBase64)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"epoch": epoch, "key": key}, nil
+}
+
+// runDMPending lists (action ""), applies ("-apply") or discards ("-discard")
+// pending commits and returns the JSON line to print.
+func runDMPending(stateDir, groupIDBase64, action, commitHash string) (string, error) {
 	if stateDir == "" {
 		return "", errors.New("state-dir is required")
 	}
@@ -215,17 +1583,49 @@ func runDMKeyPackage(stateDir, name string, seed int64) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("load participant: %w", err)
 	}
-	participantBlob, kp, err := dm.KeyPackage(participantBlob, name, seed)
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	var out string
+	switch action {
+	case "":
+		pending, err := dm.PendingCommits(participantBlob, groupIDBase64)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("{\"pending\":%s}", pending), nil
+	case "-apply":
+		participantBlob, err = dm.ApplyPending(participantBlob, groupIDBase64, commitHash)
+		out = "{}"
+	case "-discard":
+		var discarded int
+		participantBlob, discarded, err = dm.DiscardPending(participantBlob, groupIDBase64, commitHash)
+		out = fmt.Sprintf("{\"discarded\":%d}", discarded)
+	}
 	if err != nil {
 		return "", err
 	}
 	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
 		return "", fmt.Errorf("save participant: %w", err)
 	}
-	return kp, nil
+	return out, nil
+}
+
+func runDMGroups(stateDir string) ([]string, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	return dm.Groups(participantBlob)
 }
 
-func runDMInit(stateDir, peerKPBase64, groupIDBase64 string, seed int64) (string, string, error) {
+func runDMGroupID(stateDir, nonceBase64 string) (string, string, error) {
 	if stateDir == "" {
 		return "", "", errors.New("state-dir is required")
 	}
@@ -236,74 +1636,202 @@ func runDMInit(stateDir, peerKPBase64, groupIDBase64 string, seed int64) (string
 	if participantBlob == "" {
 		return "", "", errors.New("participant state not initialized; run dm-keypackage first")
 	}
-	participantBlob, welcome, commit, err := dm.Init(participantBlob, peerKPBase64, groupIDBase64, seed)
+	if nonceBase64 == "" {
+		nonce := make([]byte, 16)
+		if _, err := io.ReadFull(crand.Reader, nonce); err != nil {
+			return "", "", fmt.Errorf("draw nonce: %w", err)
+		}
+		nonceBase64 = base64.StdEncoding.EncodeToString(nonce)
+	}
+	groupID, err := dm.DeriveGroupID(participantBlob, nonceBase64)
 	if err != nil {
 		return "", "", err
 	}
+	return groupID, nonceBase64, nil
+}
+
+func runDMBackupExport(stateDir string) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
+	}
+	key, err := backupKey()
+	if err != nil {
+		return "", err
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized")
+	}
+	return dm.ExportBackup(participantBlob, key)
+}
+
+func runDMBackupImport(stateDir, backupPath string) ([]string, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	if backupPath == "" {
+		return nil, errors.New("backup is required")
+	}
+	key, err := backupKey()
+	if err != nil {
+		return nil, err
+	}
+	existing, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if existing != "" {
+		return nil, errors.New("state-dir already holds a participant")
+	}
+	backup, err := os.ReadFile(backupPath)
+	if err != nil {
+		return nil, fmt.Errorf("read backup: %w", err)
+	}
+	participantBlob, groups, err := dm.ImportBackup(string(bytes.TrimSpace(backup)), key)
+	if err != nil {
+		return nil, err
+	}
 	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
-		return "", "", fmt.Errorf("save participant: %w", err)
+		return nil, fmt.Errorf("save participant: %w", err)
 	}
-	return welcome, commit, nil
+	return groups, nil
+}
+
+func parsePaddingBuckets(value string) (dm.PaddingPolicy, error) {
+	policy := dm.PaddingPolicy{Buckets: []uint32{}}
+	if value == "" {
+		return policy, nil
+	}
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+		if err != nil {
+			return dm.PaddingPolicy{}, fmt.Errorf("invalid padding bucket %q", field)
+		}
+		policy.Buckets = append(policy.Buckets, uint32(bucket))
+	}
+	return policy, nil
 }
 
-func runGroupInit(stateDir string, peerKPs []string, groupIDBase64 string, seed int64) (string, string, error) {
+func runDMKeyPackagePool(stateDir string, count int, seed int64) ([]string, int, int, error) {
 	if stateDir == "" {
-		return "", "", errors.New("state-dir is required")
+		return nil, 0, 0, errors.New("state-dir is required")
 	}
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
-		return "", "", fmt.Errorf("load participant: %w", err)
+		return nil, 0, 0, fmt.Errorf("load participant: %w", err)
 	}
 	if participantBlob == "" {
-		return "", "", errors.New("participant state not initialized; run dm-keypackage first")
+		return nil, 0, 0, errors.New("participant state not initialized; run dm-keypackage first")
 	}
-	participantBlob, welcome, commit, err := dm.InitMany(participantBlob, peerKPs, groupIDBase64, seed)
+	participantBlob, kps, err := dm.KeyPackagePool(participantBlob, count, seed)
 	if err != nil {
-		return "", "", err
+		return nil, 0, 0, err
 	}
 	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
-		return "", "", fmt.Errorf("save participant: %w", err)
+		return nil, 0, 0, fmt.Errorf("save participant: %w", err)
 	}
-	return welcome, commit, nil
+	available, consumed, err := dm.PoolCounts(participantBlob)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return kps, available, consumed, nil
 }
 
-func runGroupAdd(stateDir string, peerKPs []string, seed int64) (string, string, []string, error) {
+func runDMIdentityMessage(stateDir string) (string, error) {
 	if stateDir == "" {
-		return "", "", nil, errors.New("state-dir is required")
+		return "", errors.New("state-dir is required")
 	}
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
-		return "", "", nil, fmt.Errorf("load participant: %w", err)
+		return "", fmt.Errorf("load participant: %w", err)
 	}
 	if participantBlob == "" {
-		return "", "", nil, errors.New("participant state not initialized")
+		return "", errors.New("participant state not initialized; run dm-keypackage first")
+	}
+	return dm.IdentityBindingMessage(participantBlob)
+}
+
+func runDMBindIdentity(stateDir, userID, signature string) (string, error) {
+	if stateDir == "" {
+		return "", errors.New("state-dir is required")
 	}
-	participantBlob, welcome, commit, proposals, err := dm.AddMany(participantBlob, peerKPs, seed)
+	if userID == "" || signature == "" {
+		return "", errors.New("user-id and signature are required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
-		return "", "", nil, err
+		return "", fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return "", errors.New("participant state not initialized; run dm-keypackage first")
+	}
+	participantBlob, kp, err := dm.BindIdentity(participantBlob, userID, signature)
+	if err != nil {
+		return "", err
 	}
 	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
-		return "", "", nil, fmt.Errorf("save participant: %w", err)
+		return "", fmt.Errorf("save participant: %w", err)
 	}
-	return welcome, commit, proposals, nil
+	return kp, nil
 }
 
-func runDMJoin(stateDir, welcomeBase64 string) error {
+func runDMPadding(stateDir string, policy dm.PaddingPolicy) error {
 	if stateDir == "" {
 		return errors.New("state-dir is required")
 	}
-	if welcomeBase64 == "" {
-		return errors.New("welcome is required")
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return errors.New("participant state not initialized")
+	}
+	participantBlob, err = dm.SetPadding(participantBlob, policy)
+	if err != nil {
+		return err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return fmt.Errorf("save participant: %w", err)
+	}
+	return nil
+}
+
+func runDMAdmins(stateDir, groupIDBase64 string, admins []string) ([]string, error) {
+	if stateDir == "" {
+		return nil, errors.New("state-dir is required")
+	}
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	participantBlob, err = dm.SetAdmins(participantBlob, groupIDBase64, admins)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return nil, fmt.Errorf("save participant: %w", err)
 	}
+	return dm.Admins(participantBlob, groupIDBase64)
+}
 
+func runDMRetention(stateDir string, policy dm.RetentionPolicy) error {
+	if stateDir == "" {
+		return errors.New("state-dir is required")
+	}
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
 		return fmt.Errorf("load participant: %w", err)
 	}
 	if participantBlob == "" {
-		return errors.New("participant state not initialized; run dm-keypackage first")
+		return errors.New("participant state not initialized")
 	}
-	participantBlob, err = dm.Join(participantBlob, welcomeBase64)
+	participantBlob, err = dm.SetRetention(participantBlob, policy)
 	if err != nil {
 		return err
 	}
@@ -313,13 +1841,10 @@ func runDMJoin(stateDir, welcomeBase64 string) error {
 	return nil
 }
 
-func runDMCommitApply(stateDir, commitBase64 string) error {
+func runDMRotation(stateDir string, policy dm.RotationPolicy) error {
 	if stateDir == "" {
 		return errors.New("state-dir is required")
 	}
-	if commitBase64 == "" {
-		return errors.New("commit is required")
-	}
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
 		return fmt.Errorf("load participant: %w", err)
@@ -327,7 +1852,7 @@ func runDMCommitApply(stateDir, commitBase64 string) error {
 	if participantBlob == "" {
 		return errors.New("participant state not initialized")
 	}
-	participantBlob, _, err = dm.CommitApply(participantBlob, commitBase64)
+	participantBlob, err = dm.SetRotation(participantBlob, policy)
 	if err != nil {
 		return err
 	}
@@ -337,25 +1862,32 @@ func runDMCommitApply(stateDir, commitBase64 string) error {
 	return nil
 }
 
-func runDMEncrypt(stateDir, plaintext string) (string, error) {
+// runDMDestroy overwrites the participant file with the tombstone from
+// dm.Destroy. The state key, if set, lives only in the environment; unsetting
+// it is the caller's job.
+func runDMDestroy(stateDir string) error {
+	if stateDir == "" {
+		return errors.New("state-dir is required")
+	}
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
-		return "", fmt.Errorf("load participant: %w", err)
+		return fmt.Errorf("load participant: %w", err)
 	}
 	if participantBlob == "" {
-		return "", errors.New("participant state not initialized")
+		return errors.New("participant state not initialized")
 	}
-	participantBlob, ciphertext, err := dm.Encrypt(participantBlob, plaintext)
+	tombstone, err := dm.Destroy(participantBlob)
 	if err != nil {
-		return "", err
+		return err
 	}
-	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
-		return "", fmt.Errorf("persist state: %w", err)
+	if err := saveParticipantBlob(stateDir, tombstone); err != nil {
+		return fmt.Errorf("save participant: %w", err)
 	}
-	return ciphertext, nil
+	return nil
 }
 
-func runDMDecrypt(stateDir, ciphertextBase64 string) (string, error) {
+// runDMDecrypt returns the body, or the message JSON when metadata is set.
+func runDMDecrypt(stateDir, groupIDBase64, ciphertextBase64 string, metadata bool) (string, error) {
 	participantBlob, err := loadParticipantBlob(stateDir)
 	if err != nil {
 		return "", fmt.Errorf("load participant: %w", err)
@@ -363,7 +1895,11 @@ func runDMDecrypt(stateDir, ciphertextBase64 string) (string, error) {
 	if participantBlob == "" {
 		return "", errors.New("participant state not initialized")
 	}
-	participantBlob, plaintext, err := dm.Decrypt(participantBlob, ciphertextBase64)
+	decrypt := dm.Decrypt
+	if metadata {
+		decrypt = dm.DecryptMessage
+	}
+	participantBlob, plaintext, err := decrypt(participantBlob, groupIDBase64, ciphertextBase64)
 	if err != nil {
 		return "", err
 	}
@@ -373,7 +1909,58 @@ func runDMDecrypt(stateDir, ciphertextBase64 string) (string, error) {
 	return plaintext, nil
 }
 
-func runSmoke(iterations, saveEvery int, stateDir string) error {
+func runDMEncryptBatch(stateDir, groupIDBase64 string, plaintexts []string) ([]string, error) {
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	participantBlob, cts, err := dm.EncryptBatch(participantBlob, groupIDBase64, plaintexts)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return nil, fmt.Errorf("persist state: %w", err)
+	}
+	return cts, nil
+}
+
+func runDMDecryptBatch(stateDir, groupIDBase64 string, ciphertexts []string) ([]dm.BatchMessage, error) {
+	participantBlob, err := loadParticipantBlob(stateDir)
+	if err != nil {
+		return nil, fmt.Errorf("load participant: %w", err)
+	}
+	if participantBlob == "" {
+		return nil, errors.New("participant state not initialized")
+	}
+	participantBlob, messages, err := dm.DecryptBatch(participantBlob, groupIDBase64, ciphertexts)
+	if err != nil {
+		return nil, err
+	}
+	if err := saveParticipantBlob(stateDir, participantBlob); err != nil {
+		return nil, fmt.Errorf("persist state: %w", err)
+	}
+	return messages, nil
+}
+
+// soakOptions carries the soak-only extras; the zero value reproduces a plain smoke run.
+type soakOptions struct {
+	metrics     *soakMetrics
+	checkpoints harness.CheckpointPolicy
+	// transport is the kind passed to harness.NewTransport; empty means in-memory.
+	transport string
+	// limits bound each participant's state after every iteration.
+	limits harness.StateLimits
+}
+
+func runSmoke(iterations, saveEvery int, stateDir, transport string) error {
+	return runSoak(iterations, saveEvery, stateDir, soakOptions{transport: transport, limits: harness.DefaultStateLimits()})
+}
+
+func runSoak(iterations, saveEvery int, stateDir string, opts soakOptions) error {
+	metrics := opts.metrics
 	if iterations <= 0 {
 		return fmt.Errorf("iterations must be positive (got %d)", iterations)
 	}
@@ -388,42 +1975,96 @@ func runSmoke(iterations, saveEvery int, stateDir string) error {
 		return fmt.Errorf("failed to create state-dir: %w", err)
 	}
 
+	transport, err := harness.NewTransport(opts.transport, filepath.Join(stateDir, "transport"))
+	if err != nil {
+		return fmt.Errorf("failed to set up transport: %w", err)
+	}
+	defer transport.Close()
+
 	rng := harness.DeterministicRNG()
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
+	defer epochChain.pinCryptoRand(rng)()
 
-	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	alice, bob, err := harness.BootstrapPairWithMetrics(rng, nil, metrics.sink())
 	if err != nil {
+		metrics.failure("bootstrap")
 		return fmt.Errorf("failed to bootstrap participants: %w", err)
 	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
 
 	for i := 0; i < iterations; i++ {
 		payload := []byte(fmt.Sprintf("msg-%d", i))
 
-		if err := harness.ExchangeOnceWithDigest(alice, bob, payload, "", nil); err != nil {
-			return fmt.Errorf("iteration %d alice->bob: %w", i, err)
+		if err := harness.ExchangeViaWithMetrics(transport, alice, bob, payload, metrics.sink()); err != nil {
+			metrics.exchangeFailed(err)
+			return stepFailed(i, alice, fmt.Errorf("iteration %d alice->bob: %w", i, err))
 		}
+		coverage.appMessage(alice.State.Epoch)
 
-		if err := harness.ExchangeOnceWithDigest(bob, alice, payload, "", nil); err != nil {
-			return fmt.Errorf("iteration %d bob->alice: %w", i, err)
+		if err := harness.ExchangeViaWithMetrics(transport, bob, alice, payload, metrics.sink()); err != nil {
+			metrics.exchangeFailed(err)
+			return stepFailed(i, bob, fmt.Errorf("iteration %d bob->alice: %w", i, err))
+		}
+		coverage.appMessage(bob.State.Epoch)
+		// Decrypting both ways does not rule out a drifted key schedule.
+		if diff := harness.CompareEpochs(alice, bob); diff != nil {
+			metrics.failure("epoch_divergence")
+			return stepFailed(i, alice, fmt.Errorf("iteration %d: %s", i, diff))
+		}
+		for _, p := range []*harness.Participant{alice, bob} {
+			if err := harness.VerifyBounded(p.State, opts.limits); err != nil {
+				metrics.failure("unbounded_state")
+				return stepFailed(i, p, fmt.Errorf("iteration %d: %w", i, err))
+			}
 		}
+		logger.Debug("iteration exchanged", "iteration", i, "participant", alice.Name, "epoch", uint64(alice.State.Epoch))
 
 		if (i+1)%saveEvery == 0 {
-			if err := persistRoundTrip(stateDir, alice, bob); err != nil {
-				return fmt.Errorf("iteration %d persistence: %w", i, err)
+			if err := harness.PersistRoundTrip(harness.FileStore{Dir: stateDir}, alice, bob); err != nil {
+				metrics.failure("persist")
+				return stepFailed(i, alice, fmt.Errorf("iteration %d persistence: %w", i, err))
+			}
+			if diff := harness.CompareEpochs(alice, bob); diff != nil {
+				metrics.failure("epoch_divergence")
+				return stepFailed(i, alice, fmt.Errorf("iteration %d after reload: %s", i, diff))
 			}
+			epochChain.observe(alice, bob)
+			metrics.snapshotSize(alice.Name, harness.StatePath(stateDir, alice.Name))
+			metrics.snapshotSize(bob.Name, harness.StatePath(stateDir, bob.Name))
+			if err := harness.ArchiveCheckpoint(stateDir, i+1, opts.checkpoints, alice, bob); err != nil {
+				metrics.failure("checkpoint")
+				return stepFailed(i, alice, fmt.Errorf("iteration %d checkpoint: %w", i, err))
+			}
+			logger.Info("checkpoint saved", "iteration", i+1, "epoch", uint64(alice.State.Epoch))
 		}
+		metrics.iterationDone()
 	}
 
 	return nil
 }
 
-func runVectors(vectorPath string) error {
+func runVectors(vectorPath, transcriptPath, referencePath string) error {
 	if vectorPath == "" {
 		return errors.New("vector-file is required")
 	}
 
 	result, err := harness.VerifyVectorFile(vectorPath)
+	if result != nil {
+		// A mismatched run still writes its transcript, to compare entry by
+		// entry with a good one.
+		if werr := writeTranscript(transcriptPath, result.Transcript); werr != nil && err == nil {
+			err = werr
+		}
+		if err != nil && result.Divergence == nil && result.Transcript != nil && referencePath != "" {
+			divergence, derr := diagnoseAgainst(referencePath, result.Transcript)
+			if derr != nil {
+				return fmt.Errorf("%w (reference transcript: %v)", err, derr)
+			}
+			if divergence != nil {
+				return fmt.Errorf("%w: %s", err, divergence)
+			}
+		}
+	}
 	if err != nil {
 		return err
 	}
@@ -436,64 +2077,123 @@ func runVectors(vectorPath string) error {
 	return nil
 }
 
-func persistRoundTrip(stateDir string, alice, bob *harness.Participant) error {
-	if err := saveState(filepath.Join(stateDir, "alice.gob"), alice.State); err != nil {
-		return fmt.Errorf("alice persist: %w", err)
-	}
-	if err := saveState(filepath.Join(stateDir, "bob.gob"), bob.State); err != nil {
-		return fmt.Errorf("bob persist: %w", err)
-	}
-
-	restoredAlice, err := loadState(filepath.Join(stateDir, "alice.gob"))
+// runGenerateVectors prints a vector file for the seeded scenario template
+// describes, in the layout of the committed ones.
+func runGenerateVectors(template *harness.VectorSpec, entryDigests bool) error {
+	generate := harness.GenerateVector
+	if entryDigests {
+		generate = harness.GenerateVectorWithEntryDigests
+	}
+	spec, err := generate(template)
 	if err != nil {
-		return fmt.Errorf("alice reload: %w", err)
+		return err
 	}
-	restoredBob, err := loadState(filepath.Join(stateDir, "bob.gob"))
+	out, err := json.MarshalIndent(spec, "", "  ")
 	if err != nil {
-		return fmt.Errorf("bob reload: %w", err)
+		return err
 	}
-
-	alice.State = restoredAlice
-	bob.State = restoredBob
+	fmt.Println(string(out))
 	return nil
 }
 
-func saveState(path string, state *mls.State) error {
-	registerStateTypes(state)
-
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
-		return fmt.Errorf("encode: %w", err)
+// configureStateKey seals dm participant state at rest when the environment
+// names a key, so secrets never land on disk or in argv in the clear.
+// MLS_HARNESS_STATE_KEY is base64 key material; MLS_HARNESS_STATE_PASSPHRASE is
+// a passphrase.
+func configureStateKey() error {
+	key, err := sealKeyFromEnv("MLS_HARNESS_STATE_KEY", "MLS_HARNESS_STATE_PASSPHRASE")
+	if err != nil || key == nil {
+		return err
 	}
-	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
-		return fmt.Errorf("write: %w", err)
+	return dm.SetStateKey(key)
+}
+
+// configureClock freezes the dm clock at MLS_HARNESS_CLOCK, an RFC 3339 time,
+// if it is set.
+func configureClock() error {
+	value := os.Getenv("MLS_HARNESS_CLOCK")
+	if value == "" {
+		return nil
+	}
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("MLS_HARNESS_CLOCK: %w", err)
 	}
+	dm.SetClock(harness.FrozenClock{At: at})
 	return nil
 }
 
-func loadState(path string) (*mls.State, error) {
-	data, err := os.ReadFile(path)
+// sealKeyFromEnv reads a base64 key from keyVar or a passphrase from
+// passphraseVar, returning nil if neither is set.
+func sealKeyFromEnv(keyVar, passphraseVar string) (*dm.SealKey, error) {
+	keyB64, passphrase := os.Getenv(keyVar), os.Getenv(passphraseVar)
+	if keyB64 == "" && passphrase == "" {
+		return nil, nil
+	}
+	key := dm.SealKey{Passphrase: passphrase}
+	if keyB64 != "" {
+		raw, err := base64.StdEncoding.DecodeString(keyB64)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyVar, err)
+		}
+		key.Key = raw
+	}
+	return &key, nil
+}
+
+// backupKey is the key for dm-backup-export and dm-backup-import. It is kept
+// apart from the state key, which stays on the device.
+func backupKey() (dm.SealKey, error) {
+	key, err := sealKeyFromEnv("MLS_HARNESS_BACKUP_KEY", "MLS_HARNESS_BACKUP_PASSPHRASE")
 	if err != nil {
-		return nil, fmt.Errorf("read: %w", err)
+		return dm.SealKey{}, err
 	}
-	var state mls.State
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&state); err != nil {
-		return nil, fmt.Errorf("decode: %w", err)
+	if key == nil {
+		return dm.SealKey{}, errors.New("set MLS_HARNESS_BACKUP_KEY or MLS_HARNESS_BACKUP_PASSPHRASE")
 	}
-	return &state, nil
+	return *key, nil
 }
 
 func participantPath(stateDir string) string {
 	return filepath.Join(stateDir, "participant.gob")
 }
 
+// dmSeed is the seed a dm command passes for the participant in stateDir.
+// Secure participants refuse seeds, so they get 0 unless --seed was given,
+// which the dm package then refuses.
+func dmSeed(fs *flag.FlagSet, stateDir string, seed int64) int64 {
+	given := false
+	fs.Visit(func(f *flag.Flag) { given = given || f.Name == "seed" })
+	if given {
+		return seed
+	}
+	secure := false
+	if mode := fs.Lookup("mode"); mode != nil && mode.Value.String() != "" {
+		secure = mode.Value.String() == dm.ModeSecure.String()
+	} else if blob, err := loadParticipantBlob(stateDir); err == nil && blob != "" {
+		mode, err := dm.ParticipantMode(blob)
+		secure = err == nil && mode == dm.ModeSecure
+	}
+	if secure {
+		return 0
+	}
+	return seed
+}
+
 func loadParticipantBlob(stateDir string) (string, error) {
-	path := participantPath(stateDir)
-	data, err := os.ReadFile(path)
+	blob, err := readParticipantFile(participantPath(stateDir))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return "", nil
 		}
+		return "", err
+	}
+	return blob, nil
+}
+
+func readParticipantFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
 		return "", fmt.Errorf("read participant: %w", err)
 	}
 	return string(bytes.TrimSpace(data)), nil
@@ -512,34 +2212,6 @@ func saveParticipantBlob(stateDir, participantBlob string) error {
 	return nil
 }
 
-func registerStateTypes(state *mls.State) {
-	if state == nil {
-		return
-	}
-
-	registerValue(state.Keys)
-	registerValue(state.Keys.HandshakeBaseKeys)
-	registerValue(state.Keys.ApplicationBaseKeys)
-	registerValue(state.Keys.HandshakeRatchets)
-	registerValue(state.Keys.ApplicationRatchets)
-	registerValue(state.Keys.HandshakeKeys)
-	registerValue(state.Keys.ApplicationKeys)
-
-	for _, ratchet := range state.Keys.HandshakeRatchets {
-		registerValue(ratchet)
-	}
-	for _, ratchet := range state.Keys.ApplicationRatchets {
-		registerValue(ratchet)
-	}
-}
-
-func registerValue(v interface{}) {
-	if v == nil {
-		return
-	}
-	gob.Register(v)
-}
-
 type stringSlice []string
 
 func (s *stringSlice) String() string {
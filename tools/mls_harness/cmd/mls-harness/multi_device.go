@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// runMultiDevice models one logical user with several devices. Each device is its
+// own leaf but shares the user's identity credential. Devices are added and removed
+// individually while the remaining devices (and another user) keep messaging.
+func runMultiDevice(iterations int) error {
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	suite := mls.X25519_AES128GCM_SHA256_Ed25519
+	alice, err := harness.NewIdentity(rng, suite, "alice")
+	if err != nil {
+		return fmt.Errorf("alice init: %w", err)
+	}
+	phone, err := alice.NewSession(rng, "alice/phone")
+	if err != nil {
+		return fmt.Errorf("alice phone init: %w", err)
+	}
+	laptop, err := alice.NewSession(rng, "alice/laptop")
+	if err != nil {
+		return fmt.Errorf("alice laptop init: %w", err)
+	}
+	bob, err := newMember(rng, suite, "bob")
+	if err != nil {
+		return fmt.Errorf("bob init: %w", err)
+	}
+
+	phone.State, err = mls.NewEmptyState([]byte("multi-device"), phone.InitSecret, phone.SigningKey, phone.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("create group: %w", err)
+	}
+	epochChain.observe(phone)
+
+	// The phone invites its sibling device and bob in one commit.
+	if err := addMembers(rng, phone, nil, laptop, bob); err != nil {
+		return fmt.Errorf("initial adds: %w", err)
+	}
+	group := []*harness.Participant{phone, laptop, bob}
+	if err := checkDeviceLeaves(phone.State, alice.Credential, 2); err != nil {
+		return err
+	}
+	if err := broadcastRounds(group, iterations, "initial"); err != nil {
+		return err
+	}
+
+	// bob adds a third alice device; leaves with the same identity must coexist.
+	tablet, err := alice.NewSession(rng, "alice/tablet")
+	if err != nil {
+		return fmt.Errorf("alice tablet init: %w", err)
+	}
+	if err := addMembers(rng, bob, []*harness.Participant{phone, laptop}, tablet); err != nil {
+		return fmt.Errorf("add tablet: %w", err)
+	}
+	group = append(group, tablet)
+	if err := checkDeviceLeaves(bob.State, alice.Credential, 3); err != nil {
+		return err
+	}
+	if err := broadcastRounds(group, iterations, "tablet-added"); err != nil {
+		return err
+	}
+
+	// The phone removes the laptop (lost device); only that leaf goes away.
+	laptopLeaf, ok := phone.State.Tree.Find(laptop.KeyPackage)
+	if !ok {
+		return errors.New("laptop leaf not found")
+	}
+	remove, err := phone.State.Remove(laptopLeaf)
+	if err != nil {
+		return fmt.Errorf("remove laptop: %w", err)
+	}
+	coverage.record(opRemove)
+	removed := &harness.Participant{Name: laptop.Name, Session: harness.Session{State: laptop.State}}
+	if _, err := commitProposals(rng, phone, []*harness.Participant{bob, tablet}, []*mls.MLSPlaintext{remove}); err != nil {
+		return fmt.Errorf("remove laptop: %w", err)
+	}
+	group = []*harness.Participant{phone, bob, tablet}
+	if err := checkDeviceLeaves(tablet.State, alice.Credential, 2); err != nil {
+		return err
+	}
+
+	ct, err := phone.State.Protect([]byte("after-removal"))
+	if err != nil {
+		return fmt.Errorf("protect after removal: %w", err)
+	}
+	if _, err := removed.State.Unprotect(ct); err == nil {
+		return errors.New("removed device decrypted a message sent after its removal")
+	}
+	for _, member := range []*harness.Participant{bob, tablet} {
+		if _, err := member.State.Unprotect(ct); err != nil {
+			return fmt.Errorf("%s unprotect after removal: %w", member.Name, err)
+		}
+	}
+
+	if err := broadcastRounds(group, iterations, "laptop-removed"); err != nil {
+		return err
+	}
+
+	fmt.Printf("multi-device: alice devices=2 members=%d epoch=%d\n", len(group), phone.State.Epoch)
+	return nil
+}
+
+// addMembers has adder propose and commit Adds for every joiner, delivers the commit
+// to the existing members and joins each new member from the Welcome.
+func addMembers(rng io.Reader, adder *harness.Participant, existing []*harness.Participant, joiners ...*harness.Participant) error {
+	proposals := make([]*mls.MLSPlaintext, 0, len(joiners))
+	for _, joiner := range joiners {
+		add, err := adder.State.Add(joiner.KeyPackage)
+		if err != nil {
+			return fmt.Errorf("add %s: %w", joiner.Name, err)
+		}
+		coverage.record(opAdd)
+		proposals = append(proposals, add)
+	}
+	welcome, err := commitProposals(rng, adder, existing, proposals)
+	if err != nil {
+		return err
+	}
+	for _, joiner := range joiners {
+		joiner.State, err = mls.NewJoinedState(joiner.InitSecret, []mls.SignaturePrivateKey{joiner.SigningKey}, []mls.KeyPackage{joiner.KeyPackage}, *welcome)
+		if err != nil {
+			return fmt.Errorf("%s join: %w", joiner.Name, err)
+		}
+		coverage.record(opWelcomeJoin)
+		epochChain.observe(joiner)
+	}
+	return nil
+}
+
+// checkDeviceLeaves asserts how many leaves in the tree carry the given credential.
+func checkDeviceLeaves(state *mls.State, cred mls.Credential, want int) error {
+	got := 0
+	for i := 0; i < int(state.Tree.Size()); i++ {
+		kp, ok := state.Tree.KeyPackage(mls.LeafIndex(i))
+		if !ok {
+			continue
+		}
+		if bytes.Equal(kp.Credential.Identity(), cred.Identity()) && kp.Credential.Equals(cred) {
+			got++
+		}
+	}
+	if got != want {
+		return fmt.Errorf("expected %d device leaves for %q, found %d", want, cred.Identity(), got)
+	}
+	return nil
+}
+
+// broadcastRounds has every member send to all others for the given number of rounds.
+func broadcastRounds(group []*harness.Participant, iterations int, label string) error {
+	for i := 0; i < iterations; i++ {
+		for j, sender := range group {
+			receivers := make([]*harness.Participant, 0, len(group)-1)
+			receivers = append(receivers, group[:j]...)
+			receivers = append(receivers, group[j+1:]...)
+			if err := broadcastOnce(sender, receivers, []byte(fmt.Sprintf("%s-%d", label, i))); err != nil {
+				return fmt.Errorf("%s round %d: %w", label, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// newMember creates an identity named name with a single session, each from
+// a secret of its own, for a member that needs only one device.
+func newMember(rng io.Reader, suite mls.CipherSuite, name string) (*harness.Participant, error) {
+	id, err := harness.NewIdentity(rng, suite, name)
+	if err != nil {
+		return nil, err
+	}
+	return id.NewSession(rng, name)
+}
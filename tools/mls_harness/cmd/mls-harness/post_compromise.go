@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+const (
+	phasePreUpdate  = "pre-update"
+	phasePostUpdate = "post-update"
+)
+
+// capturedCiphertext is one application message as a passive attacker on the
+// delivery service would record it.
+type capturedCiphertext struct {
+	phase string
+	epoch mls.Epoch
+	ct    *mls.MLSCiphertext
+}
+
+// snapshotExpectation says which phases of captured traffic a snapshot must be
+// able to decrypt; every other phase must fail.
+type snapshotExpectation struct {
+	snapshot stateSnapshot
+	reads    map[string]bool
+}
+
+// runPostCompromise leaks a copy of bob's state, has bob heal with an Update that
+// is committed, and asserts that the leaked copy decrypts the traffic sent before
+// the update but none sent after it. A snapshot of the healed bob is checked the
+// other way round, and an attacker who also sees the handshake messages must be
+// unable to follow the commit with the leaked state. committer is bob (the Update
+// and a path-updating Commit both come from the compromised member) or alice (bob
+// proposes, alice commits).
+func runPostCompromise(iterations int, committer, stateDir string) error {
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+	if committer != "alice" && committer != "bob" {
+		return fmt.Errorf("committer must be alice or bob (got %q)", committer)
+	}
+	store, err := scenarioStateStore(stateDir)
+	if err != nil {
+		return err
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
+
+	leaked, err := takeSnapshot(store, "leaked", bob)
+	if err != nil {
+		return err
+	}
+	expectations := []snapshotExpectation{{snapshot: leaked, reads: map[string]bool{phasePreUpdate: true}}}
+
+	var captured []capturedCiphertext
+	exchange := func(phase string) error {
+		for i := 0; i < iterations; i++ {
+			ct, err := alice.State.Protect([]byte(fmt.Sprintf("%s-msg-%d", phase, i)))
+			if err != nil {
+				return fmt.Errorf("protect failed for %s: %w", alice.Name, err)
+			}
+			if _, err := bob.State.Unprotect(ct); err != nil {
+				return fmt.Errorf("%s iteration %d: unprotect failed for %s: %w", phase, i, bob.Name, err)
+			}
+			coverage.appMessage(bob.State.Epoch)
+			captured = append(captured, capturedCiphertext{phase: phase, epoch: alice.State.Epoch, ct: ct})
+		}
+		return nil
+	}
+	if err := exchange(phasePreUpdate); err != nil {
+		return err
+	}
+
+	// bob heals: a fresh leaf key in an Update proposal, then a Commit.
+	fresh, err := harness.NewDevice(rng, bob, bob.Name)
+	if err != nil {
+		return fmt.Errorf("fresh leaf: %w", err)
+	}
+	update, err := bob.State.Update(fresh.InitSecret, nil, fresh.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("update: %w", err)
+	}
+	coverage.record(opUpdate)
+	commitBy, other := bob, alice
+	if committer == "alice" {
+		commitBy, other = alice, bob
+	}
+	for _, member := range []*harness.Participant{commitBy, other} {
+		if _, err := member.State.Handle(update); err != nil {
+			return fmt.Errorf("%s handle update: %w", member.Name, err)
+		}
+	}
+	commit, _, next, err := commitBy.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return fmt.Errorf("%s commit: %w", commitBy.Name, err)
+	}
+	coverage.record(opCommit)
+	commitBy.State = next
+	if err := deliverCommit(other, commit); err != nil {
+		return err
+	}
+	epochChain.observe(alice, bob)
+
+	healed, err := takeSnapshot(store, "healed", bob)
+	if err != nil {
+		return err
+	}
+	expectations = append(expectations, snapshotExpectation{snapshot: healed, reads: map[string]bool{phasePostUpdate: true}})
+
+	if err := exchange(phasePostUpdate); err != nil {
+		return err
+	}
+
+	if err := followWithLeakedState(leaked, update, commit, captured); err != nil {
+		return err
+	}
+
+	var failures []string
+	for _, exp := range expectations {
+		counts := map[string][2]int{}
+		for _, c := range captured {
+			ok, err := exp.snapshot.decrypts(c.ct)
+			if err != nil {
+				return err
+			}
+			n := counts[c.phase]
+			n[1]++
+			if ok {
+				n[0]++
+			}
+			counts[c.phase] = n
+			if ok != exp.reads[c.phase] {
+				failures = append(failures, fmt.Sprintf("%s (epoch %d) decrypt of %s ciphertext at epoch %d: got %t, want %t",
+					exp.snapshot.name, exp.snapshot.epoch, c.phase, c.epoch, ok, exp.reads[c.phase]))
+			}
+		}
+		fmt.Printf("snapshot %s (epoch %d): %s %d/%d decrypted, %s %d/%d decrypted\n", exp.snapshot.name, exp.snapshot.epoch,
+			phasePreUpdate, counts[phasePreUpdate][0], counts[phasePreUpdate][1],
+			phasePostUpdate, counts[phasePostUpdate][0], counts[phasePostUpdate][1])
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+
+	fmt.Printf("post-compromise: PASS (committer=%s leaked epoch=%d healed epoch=%d)\n", committer, leaked.epoch, healed.epoch)
+	return nil
+}
+
+// followWithLeakedState plays an attacker who holds the leaked state and also
+// records the handshake messages. Applying the commit must fail; if the library
+// ever accepts it, the resulting state still must not read post-update traffic.
+func followWithLeakedState(leaked stateSnapshot, update, commit *mls.MLSPlaintext, captured []capturedCiphertext) error {
+	attacker, err := leaked.open()
+	if err != nil {
+		return err
+	}
+	if _, err := attacker.State.Handle(update); err != nil {
+		fmt.Printf("attacker cannot follow: update rejected (%v)\n", err)
+		return nil
+	}
+	next, err := attacker.State.Handle(commit)
+	if err != nil {
+		fmt.Printf("attacker cannot follow: commit rejected (%v)\n", err)
+		return nil
+	}
+	if next == nil {
+		return errors.New("attacker state did not advance on the commit")
+	}
+	for _, c := range captured {
+		if c.phase != phasePostUpdate {
+			continue
+		}
+		if _, err := next.Unprotect(c.ct); err == nil {
+			return fmt.Errorf("attacker following the commit decrypted %s traffic at epoch %d", c.phase, c.epoch)
+		}
+	}
+	fmt.Println("attacker cannot follow: advanced state reads no post-update traffic")
+	return nil
+}
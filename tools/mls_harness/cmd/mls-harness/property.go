@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness/proptest"
+)
+
+// runProperty runs cases random scenarios from consecutive seeds, checking the
+// proptest invariants after every membership change. A failure names the seed
+// that reproduces it with --seed and --cases 1.
+func runProperty(cases int, seed int64, suiteName string, cfg proptest.Config) error {
+	if cases <= 0 {
+		return fmt.Errorf("cases must be positive (got %d)", cases)
+	}
+	suite, ok := harness.CipherSuiteByName(suiteName)
+	if !ok {
+		return fmt.Errorf("unsupported cipher suite %q", suiteName)
+	}
+	cfg.Suite = suite
+
+	removed := 0
+	for i := 0; i < cases; i++ {
+		group, err := proptest.Check(seed+int64(i), cfg)
+		if err != nil {
+			return err
+		}
+		removed += len(group.Removed)
+	}
+	fmt.Printf("property: PASS (cases=%d seeds=%d..%d removed=%d)\n", cases, seed, seed+int64(cases)-1, removed)
+	return nil
+}
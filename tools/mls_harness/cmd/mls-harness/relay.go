@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// Artifact kinds exchanged between relay peers. Each message is one kind byte
+// followed by the TLS-serialized artifact, so the peers share nothing but bytes.
+const (
+	relayKeyPackage byte = 1
+	relayWelcome    byte = 2
+	relayCiphertext byte = 3
+)
+
+const (
+	relayRoleAlice = "alice"
+	relayRoleBob   = "bob"
+)
+
+// relaySeeds gives each process its own deterministic stream; in-process
+// scenarios get distinct keys by drawing alice and bob from one shared RNG.
+var relaySeeds = map[string]int64{relayRoleAlice: 1337, relayRoleBob: 7331}
+
+// relayHub forwards every frame a peer sends to the other peers in the same room.
+// Frames already carry the recipient name, so the hub never inspects them. Frames
+// sent while a peer is alone are held and delivered to the next peer that joins,
+// so the two processes may connect in either order.
+type relayHub struct {
+	mu    sync.Mutex
+	rooms map[string]*relayRoom
+}
+
+type relayRoom struct {
+	peers   map[*harness.WebSocketConn]bool
+	backlog [][]byte
+}
+
+func (h *relayHub) join(name string, conn *harness.WebSocketConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room := h.rooms[name]
+	if room == nil {
+		room = &relayRoom{peers: map[*harness.WebSocketConn]bool{}}
+		h.rooms[name] = room
+	}
+	room.peers[conn] = true
+	if len(room.peers) > 1 {
+		for _, frame := range room.backlog {
+			_ = conn.WriteFrame(frame)
+		}
+		room.backlog = nil
+	}
+}
+
+func (h *relayHub) leave(name string, conn *harness.WebSocketConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room := h.rooms[name]
+	delete(room.peers, conn)
+	if len(room.peers) == 0 {
+		delete(h.rooms, name)
+	}
+}
+
+func (h *relayHub) forward(name string, from *harness.WebSocketConn, frame []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room := h.rooms[name]
+	delivered := false
+	for peer := range room.peers {
+		if peer != from {
+			_ = peer.WriteFrame(frame)
+			delivered = true
+		}
+	}
+	if !delivered {
+		room.backlog = append(room.backlog, frame)
+	}
+}
+
+func (h *relayHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := harness.UpgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	room := r.URL.Path
+	h.join(room, conn)
+	logger.Info("relay peer joined", "room", room)
+	defer func() {
+		h.leave(room, conn)
+		conn.Close()
+		logger.Info("relay peer left", "room", room)
+	}()
+
+	for {
+		frame, err := conn.ReadFrame()
+		if err != nil {
+			return
+		}
+		h.forward(room, conn, frame)
+	}
+}
+
+// runRelayServe runs the bundled relay until the listener fails.
+func runRelayServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", addr, err)
+	}
+	logger.Info("relay listening", "url", fmt.Sprintf("ws://%s/", listener.Addr()))
+	server := &http.Server{
+		Handler:           &relayHub{rooms: map[string]*relayRoom{}},
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// runRelayPeer plays one side of the smoke scenario in this process. The peer
+// either dials url (a relay room or a listening peer) or, with listen set, accepts
+// a single direct connection from the other peer.
+func runRelayPeer(role, url, listen string, iterations int, timeout time.Duration) error {
+	if role != relayRoleAlice && role != relayRoleBob {
+		return fmt.Errorf("role must be %q or %q (got %q)", relayRoleAlice, relayRoleBob, role)
+	}
+	if (url == "") == (listen == "") {
+		return errors.New("exactly one of url or listen is required")
+	}
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+
+	conn, err := connectRelayPeer(url, listen, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("set deadline: %w", err)
+	}
+	transport := harness.NewWebSocketTransport(conn)
+
+	rng := harness.DeterministicRNGWithSeed(relaySeeds[role])
+
+	self, err := newMember(rng, mls.X25519_AES128GCM_SHA256_Ed25519, role)
+	if err != nil {
+		return fmt.Errorf("%s init: %w", role, err)
+	}
+	peer := relayRoleBob
+	if role == relayRoleBob {
+		peer = relayRoleAlice
+	}
+
+	if role == relayRoleAlice {
+		err = relayCreateGroup(transport, rng, self, peer)
+	} else {
+		err = relayJoinGroup(transport, self, peer)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < iterations; i++ {
+		payload := []byte(fmt.Sprintf("msg-%d", i))
+		// alice speaks first in every round, then answers come back the other way.
+		if role == relayRoleAlice {
+			err = relaySendMessage(transport, self, peer, payload)
+			if err == nil {
+				err = relayExpectMessage(transport, self, payload)
+			}
+		} else {
+			err = relayExpectMessage(transport, self, payload)
+			if err == nil {
+				err = relaySendMessage(transport, self, peer, payload)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("iteration %d: %w", i, err)
+		}
+	}
+
+	fmt.Printf("relay: PASS (role=%s iterations=%d epoch=%d)\n", role, iterations, self.State.Epoch)
+	return nil
+}
+
+func connectRelayPeer(url, listen string, timeout time.Duration) (*harness.WebSocketConn, error) {
+	if url != "" {
+		// The relay or listening peer may still be starting; retry until the deadline.
+		deadline := time.Now().Add(timeout)
+		for {
+			conn, err := harness.DialWebSocket(url)
+			if err == nil {
+				return conn, nil
+			}
+			if time.Now().After(deadline) {
+				return nil, err
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %w", listen, err)
+	}
+	logger.Info("relay peer listening", "url", fmt.Sprintf("ws://%s/", listener.Addr()))
+
+	accepted := make(chan *harness.WebSocketConn, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := harness.UpgradeWebSocket(w, r)
+			if err != nil {
+				return
+			}
+			select {
+			case accepted <- conn:
+			default:
+				conn.Close()
+			}
+		}),
+	}
+	go func() { _ = server.Serve(listener) }()
+	defer listener.Close()
+
+	select {
+	case conn := <-accepted:
+		return conn, nil
+	case <-time.After(timeout):
+		return nil, errors.New("timed out waiting for the peer to connect")
+	}
+}
+
+func relaySend(t harness.Transport, to string, kind byte, artifact interface{}) error {
+	data, err := syntax.Marshal(artifact)
+	if err != nil {
+		return fmt.Errorf("encode artifact: %w", err)
+	}
+	if err := t.Send(to, append([]byte{kind}, data...)); err != nil {
+		return fmt.Errorf("send to %s: %w", to, err)
+	}
+	return nil
+}
+
+func relayReceive(t harness.Transport, name string, kind byte, artifact interface{}) error {
+	data, err := t.Receive(name)
+	if err != nil {
+		return fmt.Errorf("receive for %s: %w", name, err)
+	}
+	if len(data) == 0 || data[0] != kind {
+		return fmt.Errorf("expected artifact kind %d", kind)
+	}
+	if _, err := syntax.Unmarshal(data[1:], artifact); err != nil {
+		return fmt.Errorf("decode artifact: %w", err)
+	}
+	return nil
+}
+
+// relayCreateGroup waits for the peer's KeyPackage, adds it and sends the Welcome.
+func relayCreateGroup(t harness.Transport, rng io.Reader, self *harness.Participant, peer string) error {
+	var kp mls.KeyPackage
+	if err := relayReceive(t, self.Name, relayKeyPackage, &kp); err != nil {
+		return fmt.Errorf("keypackage: %w", err)
+	}
+
+	var err error
+	self.State, err = mls.NewEmptyState([]byte("relay"), self.InitSecret, self.SigningKey, self.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("create group: %w", err)
+	}
+	add, err := self.State.Add(kp)
+	if err != nil {
+		return fmt.Errorf("add %s: %w", peer, err)
+	}
+	if _, err := self.State.Handle(add); err != nil {
+		return fmt.Errorf("handle add: %w", err)
+	}
+	_, welcome, next, err := self.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	self.State = next
+
+	if err := relaySend(t, peer, relayWelcome, *welcome); err != nil {
+		return fmt.Errorf("welcome: %w", err)
+	}
+	return nil
+}
+
+// relayJoinGroup publishes this peer's KeyPackage and joins from the returned Welcome.
+func relayJoinGroup(t harness.Transport, self *harness.Participant, peer string) error {
+	if err := relaySend(t, peer, relayKeyPackage, self.KeyPackage); err != nil {
+		return fmt.Errorf("keypackage: %w", err)
+	}
+	var welcome mls.Welcome
+	if err := relayReceive(t, self.Name, relayWelcome, &welcome); err != nil {
+		return fmt.Errorf("welcome: %w", err)
+	}
+	var err error
+	self.State, err = mls.NewJoinedState(self.InitSecret, []mls.SignaturePrivateKey{self.SigningKey}, []mls.KeyPackage{self.KeyPackage}, welcome)
+	if err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	return nil
+}
+
+func relaySendMessage(t harness.Transport, self *harness.Participant, peer string, payload []byte) error {
+	ct, err := self.State.Protect(payload)
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", self.Name, err)
+	}
+	return relaySend(t, peer, relayCiphertext, *ct)
+}
+
+func relayExpectMessage(t harness.Transport, self *harness.Participant, want []byte) error {
+	var ct mls.MLSCiphertext
+	if err := relayReceive(t, self.Name, relayCiphertext, &ct); err != nil {
+		return err
+	}
+	pt, err := self.State.Unprotect(&ct)
+	if err != nil {
+		return fmt.Errorf("unprotect failed for %s: %w", self.Name, err)
+	}
+	if !bytes.Equal(pt, want) {
+		return fmt.Errorf("plaintext mismatch for %s", self.Name)
+	}
+	return nil
+}
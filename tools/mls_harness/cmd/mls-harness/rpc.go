@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"time"
+)
+
+// rpc runs the serve API's dm operations over stdin and stdout, one JSON
+// request per line in and one JSON response per line out, so a host without
+// a network or syscall/js, such as Node's WASI or wasmtime running the
+// wasip1 build, embeds the same MLS logic as the browser. Like the WASM
+// bindings, and unlike serve, it keeps no state: a request carries its
+// participant_b64 and the response returns the updated one.
+//
+// A request is {"id", "method", "params"}, params holding the fields serve
+// takes plus participant_b64. The response is {"id", "result"}, result being
+// the body serve would have written.
+var rpcMethods = map[string]dmHandler{
+	"dmCreateParticipant": serveKeyPackage,
+	"dmKeyPackagePool":    serveKeyPackagePool,
+	"dmIdentityMessage":   serveIdentityMessage,
+	"dmBindIdentity":      serveBindIdentity,
+	"dmInit":              serveDMInit,
+	"groupInit":           serveGroupInit,
+	"groupAdd":            serveGroupAdd,
+	"dmJoin":              serveJoin,
+	"dmCommitApply":       serveCommitApply,
+	"dmEncrypt":           serveEncrypt,
+	"dmDecrypt":           serveDecrypt,
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+type rpcParams struct {
+	ParticipantB64 string `json:"participant_b64"`
+	serveRequest
+}
+
+type rpcResponse struct {
+	ID     json.RawMessage        `json:"id"`
+	Result map[string]interface{} `json:"result"`
+}
+
+// runRPC answers requests from in on out until in ends.
+func runRPC(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(blockingReader{in})
+	scanner.Buffer(make([]byte, 64<<10), maxServeRequestBytes)
+	encoder := json.NewEncoder(out)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var req rpcRequest
+		var result map[string]interface{}
+		if err := json.Unmarshal(line, &req); err != nil {
+			result = serveBody(nil, badRequest("invalid JSON request: %v", err))
+		} else {
+			result = rpcCall(req.Method, req.Params)
+		}
+		if req.ID == nil {
+			req.ID = json.RawMessage("null")
+		}
+		if err := encoder.Encode(rpcResponse{ID: req.ID, Result: result}); err != nil {
+			return fmt.Errorf("write response: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read request: %w", err)
+	}
+	return nil
+}
+
+// blockingReader retries reads that fail with EAGAIN. Node's WASI hands the
+// module its stdin pipe non-blocking, and Go's wasip1 files report the empty
+// pipe instead of waiting on it.
+type blockingReader struct {
+	r io.Reader
+}
+
+func (b blockingReader) Read(p []byte) (int, error) {
+	for {
+		n, err := b.r.Read(p)
+		if n > 0 || !errors.Is(err, syscall.EAGAIN) {
+			return n, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func rpcCall(method string, raw json.RawMessage) map[string]interface{} {
+	op, ok := rpcMethods[method]
+	if !ok {
+		return serveBody(nil, badRequest("unknown method %q", method))
+	}
+	var params rpcParams
+	if len(raw) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&params); err != nil {
+			return serveBody(nil, badRequest("invalid params: %v", err))
+		}
+	}
+	blob, fields, err := op(params.ParticipantB64, &params.serveRequest)
+	if err != nil {
+		return serveBody(nil, err)
+	}
+	fields["participant_b64"] = blob
+	return serveBody(fields, nil)
+}
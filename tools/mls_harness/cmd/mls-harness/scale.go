@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// scaleSample is the cost of one batched Add+Commit at a given group size.
+type scaleSample struct {
+	members      int
+	batch        int
+	commitBytes  int
+	welcomeBytes int
+	joinPerNew   time.Duration
+	observerLag  time.Duration
+}
+
+// runScale grows a group to maxMembers with batched Add+Commit and reports commit
+// size, Welcome size and per-joiner join time at every step. The run fails if any
+// per-member cost at the final size exceeds maxGrowth times the first step's cost,
+// i.e. if a metric grows super-linearly in the group size.
+func runScale(maxMembers, batch int, maxGrowth float64) error {
+	if maxMembers < 2 {
+		return fmt.Errorf("max-members must be at least 2 (got %d)", maxMembers)
+	}
+	if batch <= 0 {
+		return fmt.Errorf("batch must be positive (got %d)", batch)
+	}
+	if maxGrowth < 1 {
+		return fmt.Errorf("max-growth must be at least 1 (got %g)", maxGrowth)
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	suite := mls.X25519_AES128GCM_SHA256_Ed25519
+	creator, err := newMember(rng, suite, "member-0")
+	if err != nil {
+		return fmt.Errorf("creator init: %w", err)
+	}
+	creator.State, err = mls.NewEmptyState([]byte("scale"), creator.InitSecret, creator.SigningKey, creator.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("create group: %w", err)
+	}
+	epochChain.observe(creator)
+
+	// The first joiner stays in sync with every commit so the cost of processing
+	// a commit as an existing member is measured alongside the creator's cost.
+	var observer *harness.Participant
+	samples := []scaleSample{}
+	members := 1
+	for members < maxMembers {
+		n := batch
+		if members+n > maxMembers {
+			n = maxMembers - members
+		}
+		sample, joined, err := scaleStep(rng, creator, observer, members, n)
+		if err != nil {
+			return fmt.Errorf("growing past %d members: %w", members, err)
+		}
+		if observer == nil {
+			observer = joined
+		}
+		members += n
+		samples = append(samples, sample)
+		fmt.Printf("members=%d batch=%d commit_bytes=%d welcome_bytes=%d join_per_member_us=%d observer_apply_us=%d\n",
+			sample.members, sample.batch, sample.commitBytes, sample.welcomeBytes, sample.joinPerNew.Microseconds(), sample.observerLag.Microseconds())
+	}
+
+	if err := exchangeOnce(creator, observer, []byte("scale")); err != nil {
+		return fmt.Errorf("final exchange: %w", err)
+	}
+
+	if err := checkScaleGrowth(samples, maxGrowth); err != nil {
+		return err
+	}
+	fmt.Printf("scale: PASS (members=%d epoch=%d)\n", members, creator.State.Epoch)
+	return nil
+}
+
+// scaleStep adds n fresh members in one commit and returns the measurements and
+// the first new member, already joined.
+func scaleStep(rng io.Reader, creator, observer *harness.Participant, existing, n int) (scaleSample, *harness.Participant, error) {
+	proposals := make([]*mls.MLSPlaintext, 0, n)
+	joiners := make([]*harness.Participant, 0, n)
+	for i := 0; i < n; i++ {
+		joiner, err := newMember(rng, creator.KeyPackage.CipherSuite, fmt.Sprintf("member-%d", existing+i))
+		if err != nil {
+			return scaleSample{}, nil, fmt.Errorf("joiner init: %w", err)
+		}
+		add, err := creator.State.Add(joiner.KeyPackage)
+		if err != nil {
+			return scaleSample{}, nil, fmt.Errorf("add %s: %w", joiner.Name, err)
+		}
+		coverage.record(opAdd)
+		joiners = append(joiners, joiner)
+		proposals = append(proposals, add)
+	}
+
+	for _, proposal := range proposals {
+		if _, err := creator.State.Handle(proposal); err != nil {
+			return scaleSample{}, nil, fmt.Errorf("creator handle proposal: %w", err)
+		}
+		if observer != nil {
+			if _, err := observer.State.Handle(proposal); err != nil {
+				return scaleSample{}, nil, fmt.Errorf("observer handle proposal: %w", err)
+			}
+		}
+	}
+	commit, welcome, next, err := creator.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return scaleSample{}, nil, fmt.Errorf("commit: %w", err)
+	}
+	creator.State = next
+	coverage.record(opCommit)
+	epochChain.observe(creator)
+
+	sample := scaleSample{members: existing + n, batch: n}
+	if sample.commitBytes, err = encodedLen(commit); err != nil {
+		return scaleSample{}, nil, fmt.Errorf("encode commit: %w", err)
+	}
+	if sample.welcomeBytes, err = encodedLen(welcome); err != nil {
+		return scaleSample{}, nil, fmt.Errorf("encode welcome: %w", err)
+	}
+
+	if observer != nil {
+		start := time.Now()
+		if err := deliverCommit(observer, commit); err != nil {
+			return scaleSample{}, nil, err
+		}
+		sample.observerLag = time.Since(start)
+	}
+
+	start := time.Now()
+	for _, joiner := range joiners {
+		joiner.State, err = mls.NewJoinedState(joiner.InitSecret, []mls.SignaturePrivateKey{joiner.SigningKey}, []mls.KeyPackage{joiner.KeyPackage}, *welcome)
+		if err != nil {
+			return scaleSample{}, nil, fmt.Errorf("%s join: %w", joiner.Name, err)
+		}
+		coverage.record(opWelcomeJoin)
+	}
+	sample.joinPerNew = time.Since(start) / time.Duration(n)
+	epochChain.observe(joiners...)
+
+	return sample, joiners[0], nil
+}
+
+func encodedLen(v interface{}) (int, error) {
+	data, err := syntax.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// checkScaleGrowth compares the per-member cost of every metric at the final step
+// against the first step. Linear growth keeps the ratio near 1.
+func checkScaleGrowth(samples []scaleSample, maxGrowth float64) error {
+	if len(samples) < 2 {
+		return nil
+	}
+	first, last := samples[0], samples[len(samples)-1]
+
+	perMember := func(v float64, s scaleSample) float64 { return v / float64(s.members) }
+	metrics := []struct {
+		name        string
+		first, last float64
+	}{
+		{"commit_bytes", perMember(float64(first.commitBytes)/float64(first.batch), first), perMember(float64(last.commitBytes)/float64(last.batch), last)},
+		{"welcome_bytes", perMember(float64(first.welcomeBytes), first), perMember(float64(last.welcomeBytes), last)},
+		{"join_time", perMember(float64(first.joinPerNew), first), perMember(float64(last.joinPerNew), last)},
+	}
+
+	var failures []string
+	for _, m := range metrics {
+		if m.first <= 0 {
+			continue
+		}
+		growth := m.last / m.first
+		fmt.Printf("growth %s: %.2fx per member from %d to %d members\n", m.name, growth, first.members, last.members)
+		if growth > maxGrowth {
+			failures = append(failures, fmt.Sprintf("%s grew %.2fx per member (limit %.2fx)", m.name, growth, maxGrowth))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("super-linear growth: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
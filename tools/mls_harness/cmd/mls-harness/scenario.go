@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// runScenario runs a scripted scenario through the harness engine. Every
+// checkpoint step persists the members under stateDir, or in memory, and
+// carries on with the reloaded state, so a scenario also checks its group
+// survives persistence. The transcript the digest covers is written to transcriptPath, if named.
+func runScenario(path, stateDir, transcriptPath string) error {
+	if path == "" {
+		return errors.New("scenario-file is required")
+	}
+	scenario, err := harness.LoadScenario(path)
+	if err != nil {
+		return err
+	}
+	store, err := scenarioStateStore(stateDir)
+	if err != nil {
+		return err
+	}
+
+	// A pinned digest covers the bytes go-mls draws from crypto/rand, so like
+	// an epoch chain it only repeats with crypto/rand swapped for the stream.
+	rng := harness.DeterministicRNG()
+	if scenario.DigestHex != "" || epochChain != nil {
+		restore := harness.OverrideCryptoRand(rng)
+		defer restore()
+	}
+
+	dig := harness.NewTranscriptDigest()
+	group, err := scenario.Run(rng, dig, harness.ScenarioHooks{
+		Operation: func(op string, epoch mls.Epoch) {
+			if op == harness.OpAppMessage {
+				coverage.appMessage(epoch)
+				return
+			}
+			coverage.record(op)
+		},
+		Epoch: func(members ...*harness.Participant) {
+			epochChain.observe(members...)
+		},
+		Checkpoint: func(label string, members []*harness.Participant) error {
+			if err := harness.PersistRoundTrip(store, members...); err != nil {
+				return err
+			}
+			fmt.Printf("checkpoint %s: members=%d epoch=%d\n", label, len(members), members[0].State.Epoch)
+			return nil
+		},
+	})
+	if werr := writeTranscript(transcriptPath, dig.Transcript()); werr != nil && err == nil {
+		err = werr
+	}
+	if err != nil {
+		return fmt.Errorf("scenario %s: %w", scenario.Name, err)
+	}
+	fmt.Printf("scenario: PASS (name=%s steps=%d members=%d removed=%d epoch=%d digest=%s)\n",
+		scenario.Name, len(scenario.Steps), len(group.Members), len(group.Removed), group.Epoch(), dig.HexSum())
+	return nil
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// maxServeRequestBytes bounds a request body; Welcomes for large groups are the
+// biggest artifacts the API accepts.
+const maxServeRequestBytes = 4 << 20
+
+var participantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// participantStore keeps dm participant blobs server-side, keyed by participant
+// id. When dir is set every blob is also written to dir/<id>.b64 so a restarted
+// server picks up where it left off.
+//
+// Every dm operation runs under mu, so two operations never read and rewrite
+// the same blob at once.
+type participantStore struct {
+	mu    sync.Mutex
+	dir   string
+	blobs map[string]string
+}
+
+func newParticipantStore(dir string) (*participantStore, error) {
+	store := &participantStore{dir: dir, blobs: map[string]string{}}
+	if dir == "" {
+		return store, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create state-dir: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read state-dir: %w", err)
+	}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".b64")
+		if !ok || !participantIDPattern.MatchString(id) {
+			continue
+		}
+		blob, err := readParticipantFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		store.blobs[id] = blob
+	}
+	return store, nil
+}
+
+func (s *participantStore) save(id, blob string) error {
+	if s.dir != "" {
+		path := filepath.Join(s.dir, id+".b64")
+		tmp := path + ".tmp"
+		if err := os.WriteFile(tmp, []byte(blob), 0o600); err != nil {
+			return fmt.Errorf("write participant: %w", err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return fmt.Errorf("write participant: %w", err)
+		}
+	}
+	s.blobs[id] = blob
+	return nil
+}
+
+func (s *participantStore) remove(id string) error {
+	if s.dir != "" {
+		if err := os.Remove(filepath.Join(s.dir, id+".b64")); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove participant: %w", err)
+		}
+	}
+	delete(s.blobs, id)
+	return nil
+}
+
+// apiError carries the HTTP status for a failed request.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string { return e.msg }
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// serveRequest is the union of the fields the dm endpoints accept. Field names
+// match the WASM bridge so clients can switch between the two.
+type serveRequest struct {
+	ParticipantID      string   `json:"participant_id"`
+	Name               string   `json:"name"`
+	Seed               *int64   `json:"seed_int"`
+	PeerKeypackageB64  string   `json:"peer_keypackage_b64"`
+	PeerKeypackagesB64 []string `json:"peer_keypackages"`
+	GroupIDB64         string   `json:"group_id_b64"`
+	WelcomeB64         string   `json:"welcome_b64"`
+	CommitB64          string   `json:"commit_b64"`
+	Plaintext          *string  `json:"plaintext"`
+	CiphertextB64      string   `json:"ciphertext_b64"`
+	CipherSuite        string   `json:"cipher_suite"`
+	Count              int      `json:"count"`
+	UserID             string   `json:"user_id"`
+	SignatureB64       string   `json:"signature_b64"`
+	Mode               string   `json:"mode"`
+}
+
+func (r *serveRequest) seed() (int64, error) {
+	if r.Seed == nil {
+		return 0, badRequest("seed_int is required")
+	}
+	return *r.Seed, nil
+}
+
+// dmHandler runs one dm operation against the stored blob (empty for a new
+// participant) and returns the updated blob plus the response fields.
+type dmHandler func(blob string, req *serveRequest) (string, map[string]interface{}, error)
+
+type dmServer struct {
+	store *participantStore
+}
+
+func newServeMux(store *participantStore) *http.ServeMux {
+	s := &dmServer{store: store}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/participants", s.listParticipants)
+	mux.HandleFunc("POST /v1/participants", s.createParticipant)
+	mux.HandleFunc("DELETE /v1/participants/{id}", s.deleteParticipant)
+	mux.HandleFunc("POST /v1/participants/{id}/keypackage", s.participantOp(serveKeyPackage))
+	mux.HandleFunc("POST /v1/participants/{id}/keypackage-pool", s.participantOp(serveKeyPackagePool))
+	mux.HandleFunc("POST /v1/participants/{id}/identity-message", s.participantOp(serveIdentityMessage))
+	mux.HandleFunc("POST /v1/participants/{id}/identity-binding", s.participantOp(serveBindIdentity))
+	mux.HandleFunc("POST /v1/participants/{id}/dm-init", s.participantOp(serveDMInit))
+	mux.HandleFunc("POST /v1/participants/{id}/group-init", s.participantOp(serveGroupInit))
+	mux.HandleFunc("POST /v1/participants/{id}/group-add", s.participantOp(serveGroupAdd))
+	mux.HandleFunc("POST /v1/participants/{id}/join", s.participantOp(serveJoin))
+	mux.HandleFunc("POST /v1/participants/{id}/commit-apply", s.participantOp(serveCommitApply))
+	mux.HandleFunc("POST /v1/participants/{id}/encrypt", s.participantOp(serveEncrypt))
+	mux.HandleFunc("POST /v1/participants/{id}/decrypt", s.participantOp(serveDecrypt))
+	return mux
+}
+
+func (s *dmServer) listParticipants(w http.ResponseWriter, _ *http.Request) {
+	s.store.mu.Lock()
+	ids := sortedKeys(s.store.blobs)
+	s.store.mu.Unlock()
+	writeServeResponse(w, map[string]interface{}{"participants": ids}, nil)
+}
+
+func (s *dmServer) createParticipant(w http.ResponseWriter, r *http.Request) {
+	req, err := decodeServeRequest(r)
+	if err != nil {
+		writeServeResponse(w, nil, err)
+		return
+	}
+	id := req.ParticipantID
+	if id == "" {
+		id = req.Name
+	}
+	if !participantIDPattern.MatchString(id) {
+		writeServeResponse(w, nil, badRequest("participant_id must match %s", participantIDPattern))
+		return
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if _, exists := s.store.blobs[id]; exists {
+		writeServeResponse(w, nil, &apiError{status: http.StatusConflict, msg: fmt.Sprintf("participant %q already exists", id)})
+		return
+	}
+	blob, fields, err := serveKeyPackage("", req)
+	if err == nil {
+		err = s.store.save(id, blob)
+	}
+	if err != nil {
+		writeServeResponse(w, nil, err)
+		return
+	}
+	fields["participant_id"] = id
+	writeServeResponse(w, fields, nil)
+}
+
+func (s *dmServer) deleteParticipant(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	if _, ok := s.store.blobs[id]; !ok {
+		writeServeResponse(w, nil, &apiError{status: http.StatusNotFound, msg: fmt.Sprintf("unknown participant %q", id)})
+		return
+	}
+	writeServeResponse(w, map[string]interface{}{"participant_id": id}, s.store.remove(id))
+}
+
+func (s *dmServer) participantOp(op dmHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		req, err := decodeServeRequest(r)
+		if err != nil {
+			writeServeResponse(w, nil, err)
+			return
+		}
+		if req.ParticipantID == "" {
+			req.ParticipantID = id
+		}
+
+		s.store.mu.Lock()
+		defer s.store.mu.Unlock()
+		blob, ok := s.store.blobs[id]
+		if !ok {
+			writeServeResponse(w, nil, &apiError{status: http.StatusNotFound, msg: fmt.Sprintf("unknown participant %q", id)})
+			return
+		}
+		blob, fields, err := op(blob, req)
+		if err == nil {
+			err = s.store.save(id, blob)
+		}
+		if err != nil {
+			writeServeResponse(w, nil, err)
+			return
+		}
+		fields["participant_id"] = id
+		writeServeResponse(w, fields, nil)
+	}
+}
+
+func serveKeyPackage(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	seed, err := req.seed()
+	if err != nil {
+		return "", nil, err
+	}
+	name := req.Name
+	if name == "" {
+		name = req.ParticipantID
+	}
+	var kp string
+	if req.Mode == "" {
+		blob, kp, err = dm.KeyPackage(blob, name, req.CipherSuite, seed)
+	} else {
+		mode, parseErr := dm.ParseMode(req.Mode)
+		if parseErr != nil {
+			return "", nil, badRequest("%v", parseErr)
+		}
+		blob, kp, err = dm.KeyPackageWithMode(blob, name, req.CipherSuite, mode, seed)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"keypackage_b64": kp}, nil
+}
+
+func serveKeyPackagePool(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	seed, err := req.seed()
+	if err != nil {
+		return "", nil, err
+	}
+	blob, kps, err := dm.KeyPackagePool(blob, req.Count, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	available, consumed, err := dm.PoolCounts(blob)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"keypackages": kps, "available": available, "consumed": consumed}, nil
+}
+
+func serveIdentityMessage(blob string, _ *serveRequest) (string, map[string]interface{}, error) {
+	message, err := dm.IdentityBindingMessage(blob)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"message_b64": message}, nil
+}
+
+func serveBindIdentity(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	if req.UserID == "" || req.SignatureB64 == "" {
+		return "", nil, badRequest("user_id and signature_b64 are required")
+	}
+	blob, kp, err := dm.BindIdentity(blob, req.UserID, req.SignatureB64)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"keypackage_b64": kp}, nil
+}
+
+func serveDMInit(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	seed, err := req.seed()
+	if err != nil {
+		return "", nil, err
+	}
+	blob, welcome, commit, err := dm.Init(blob, req.PeerKeypackageB64, req.GroupIDB64, req.CipherSuite, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"welcome_b64": welcome, "commit_b64": commit}, nil
+}
+
+func serveGroupInit(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	seed, err := req.seed()
+	if err != nil {
+		return "", nil, err
+	}
+	blob, welcome, commit, err := dm.InitMany(blob, req.PeerKeypackagesB64, req.GroupIDB64, req.CipherSuite, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"welcome_b64": welcome, "commit_b64": commit}, nil
+}
+
+func serveGroupAdd(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	seed, err := req.seed()
+	if err != nil {
+		return "", nil, err
+	}
+	blob, welcome, commit, proposals, err := dm.AddMany(blob, req.GroupIDB64, req.PeerKeypackagesB64, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	if proposals == nil {
+		proposals = []string{}
+	}
+	return blob, map[string]interface{}{"welcome_b64": welcome, "commit_b64": commit, "proposals_b64": proposals}, nil
+}
+
+func serveJoin(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	blob, err := dm.Join(blob, req.GroupIDB64, req.WelcomeB64)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{}, nil
+}
+
+func serveCommitApply(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	blob, result, err := dm.CommitApplyWithResult(blob, req.GroupIDB64, req.CommitB64)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"noop": result.Noop, "already_applied": result.AlreadyApplied}, nil
+}
+
+func serveEncrypt(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	if req.Plaintext == nil {
+		return "", nil, badRequest("plaintext is required")
+	}
+	blob, ciphertext, err := dm.Encrypt(blob, req.GroupIDB64, *req.Plaintext)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"ciphertext_b64": ciphertext}, nil
+}
+
+func serveDecrypt(blob string, req *serveRequest) (string, map[string]interface{}, error) {
+	blob, plaintext, err := dm.Decrypt(blob, req.GroupIDB64, req.CiphertextB64)
+	if err != nil {
+		return "", nil, err
+	}
+	return blob, map[string]interface{}{"plaintext": plaintext}, nil
+}
+
+func decodeServeRequest(r *http.Request) (*serveRequest, error) {
+	var req serveRequest
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxServeRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, badRequest("invalid JSON body: %v", err)
+	}
+	return &req, nil
+}
+
+// writeServeResponse writes {"ok": true, ...fields} or {"ok": false, "error": ...,
+// "code": ...}. dm failures are reported as 422: the request was well formed but
+// the MLS operation rejected it. code is the dm.ErrorCode, empty for request
+// errors.
+func writeServeResponse(w http.ResponseWriter, fields map[string]interface{}, err error) {
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusUnprocessableEntity
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			status = apiErr.status
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(serveBody(fields, err))
+}
+
+// serveBody is the response body for fields, or for err when it is set.
+func serveBody(fields map[string]interface{}, err error) map[string]interface{} {
+	if err != nil {
+		return map[string]interface{}{"ok": false, "error": err.Error(), "code": dm.ErrorCode(err)}
+	}
+	body := map[string]interface{}{"ok": true}
+	for k, v := range fields {
+		body[k] = v
+	}
+	return body
+}
+
+// logRequests logs method, path and status only; bodies carry plaintext and state.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		logger.Info("request", "method", r.Method, "path", r.URL.Path, "status", rec.status)
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// runServe exposes the dm operations over a JSON HTTP API until the listener fails.
+func runServe(addr, stateDir string) error {
+	store, err := newParticipantStore(stateDir)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen %s: %w", addr, err)
+	}
+	logger.Info("serving dm API", "url", fmt.Sprintf("http://%s/v1/participants", listener.Addr()))
+
+	server := &http.Server{Handler: logRequests(newServeMux(store)), ReadHeaderTimeout: 5 * time.Second}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// sizeRow is the serialized size in bytes of each artifact for one suite and
+// group size. The add commit brings the group from one member to Members in a
+// single commit; the update commit is an empty path-updating commit at that size.
+type sizeRow struct {
+	Suite        string `json:"cipher_suite"`
+	Members      int    `json:"members"`
+	KeyPackage   int    `json:"keypackage_bytes"`
+	Welcome      int    `json:"welcome_bytes"`
+	AddCommit    int    `json:"add_commit_bytes"`
+	UpdateCommit int    `json:"update_commit_bytes"`
+	Ciphertext   int    `json:"ciphertext_bytes"`
+}
+
+type sizesReport struct {
+	MessageBytes int       `json:"message_bytes"`
+	Rows         []sizeRow `json:"rows"`
+}
+
+// runSizes builds a group of each requested size for each suite and reports the
+// serialized size of its artifacts. Runs are deterministic, so a change in any
+// number comes from the code or the library, not from randomness.
+func runSizes(suiteNames, groupSizes string, messageBytes int, format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("format must be table or json (got %q)", format)
+	}
+	if messageBytes < 0 {
+		return fmt.Errorf("message-bytes must not be negative (got %d)", messageBytes)
+	}
+	suites, err := parseDoctorSuites(suiteNames)
+	if err != nil {
+		return err
+	}
+	sizes, err := parseGroupSizes(groupSizes)
+	if err != nil {
+		return err
+	}
+
+	report := sizesReport{MessageBytes: messageBytes}
+	var failures []string
+	for _, suite := range suites {
+		for _, members := range sizes {
+			row, err := measureSizes(suite, members, messageBytes)
+			if err != nil {
+				// Larger groups of the same suite would fail the same way.
+				failures = append(failures, fmt.Sprintf("%s members=%d: %v", suite.String(), members, err))
+				break
+			}
+			report.Rows = append(report.Rows, row)
+		}
+	}
+
+	if format == "json" {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encode json: %w", err)
+		}
+		fmt.Println(string(out))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "cipher_suite\tmembers\tkeypackage\twelcome\tadd_commit\tupdate_commit\tciphertext")
+		for _, r := range report.Rows {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\n", r.Suite, r.Members, r.KeyPackage, r.Welcome, r.AddCommit, r.UpdateCommit, r.Ciphertext)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func parseGroupSizes(value string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("group sizes must be integers of at least 2 (got %q)", field)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+// measureSizes converts a panic inside the vendored library into an error, like
+// doctor does, so one unusable suite does not hide the others.
+func measureSizes(suite mls.CipherSuite, members, messageBytes int) (row sizeRow, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			row, err = sizeRow{}, fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	rng := harness.DeterministicRNG()
+
+	creator, err := newMember(rng, suite, "member-0")
+	if err != nil {
+		return sizeRow{}, fmt.Errorf("creator init: %w", err)
+	}
+	creator.State, err = mls.NewEmptyState([]byte("sizes"), creator.InitSecret, creator.SigningKey, creator.KeyPackage)
+	if err != nil {
+		return sizeRow{}, fmt.Errorf("create group: %w", err)
+	}
+
+	row = sizeRow{Suite: suite.String(), Members: members}
+	if row.KeyPackage, err = encodedLen(creator.KeyPackage); err != nil {
+		return sizeRow{}, fmt.Errorf("encode keypackage: %w", err)
+	}
+
+	for i := 1; i < members; i++ {
+		joiner, err := newMember(rng, suite, fmt.Sprintf("member-%d", i))
+		if err != nil {
+			return sizeRow{}, fmt.Errorf("joiner init: %w", err)
+		}
+		add, err := creator.State.Add(joiner.KeyPackage)
+		if err != nil {
+			return sizeRow{}, fmt.Errorf("add %s: %w", joiner.Name, err)
+		}
+		if _, err := creator.State.Handle(add); err != nil {
+			return sizeRow{}, fmt.Errorf("handle add: %w", err)
+		}
+	}
+	commit, welcome, next, err := creator.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return sizeRow{}, fmt.Errorf("add commit: %w", err)
+	}
+	creator.State = next
+	if row.AddCommit, err = encodedLen(commit); err != nil {
+		return sizeRow{}, fmt.Errorf("encode add commit: %w", err)
+	}
+	if row.Welcome, err = encodedLen(welcome); err != nil {
+		return sizeRow{}, fmt.Errorf("encode welcome: %w", err)
+	}
+
+	update, _, next, err := creator.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return sizeRow{}, fmt.Errorf("update commit: %w", err)
+	}
+	creator.State = next
+	if row.UpdateCommit, err = encodedLen(update); err != nil {
+		return sizeRow{}, fmt.Errorf("encode update commit: %w", err)
+	}
+
+	ct, err := creator.State.Protect(make([]byte, messageBytes))
+	if err != nil {
+		return sizeRow{}, fmt.Errorf("protect: %w", err)
+	}
+	if row.Ciphertext, err = encodedLen(ct); err != nil {
+		return sizeRow{}, fmt.Errorf("encode ciphertext: %w", err)
+	}
+	return row, nil
+}
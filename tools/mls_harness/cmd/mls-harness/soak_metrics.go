@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// latencyBuckets are the histogram upper bounds, in seconds, for operation timings.
+var latencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) observe(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// soakMetrics collects soak-run counters and serves them in the Prometheus text
+// exposition format. It is the harness.Metrics sink of the soak's exchanges
+// and bootstrap. A nil *soakMetrics records nothing, so runs without
+// --metrics-addr pay no cost.
+type soakMetrics struct {
+	mu              sync.Mutex
+	iterations      uint64
+	commits         uint64
+	failures        map[string]uint64
+	protect         *histogram
+	unprotect       *histogram
+	commit          *histogram
+	join            *histogram
+	ciphertextBytes uint64
+	snapshotBytes   map[string]int64
+}
+
+func newSoakMetrics() *soakMetrics {
+	return &soakMetrics{
+		failures:      map[string]uint64{},
+		protect:       newHistogram(),
+		unprotect:     newHistogram(),
+		commit:        newHistogram(),
+		join:          newHistogram(),
+		snapshotBytes: map[string]int64{},
+	}
+}
+
+// sink is m as a harness.Metrics, nil when m is, so the harness skips the
+// timing and encoding altogether.
+func (m *soakMetrics) sink() harness.Metrics {
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+// serveSoakMetrics starts an HTTP listener exposing /metrics on addr.
+func serveSoakMetrics(addr string) (*soakMetrics, func(), error) {
+	if addr == "" {
+		return nil, func() {}, nil
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listen %s: %w", addr, err)
+	}
+
+	metrics := newSoakMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server stopped", "err", err)
+		}
+	}()
+	logger.Info("serving soak metrics", "url", fmt.Sprintf("http://%s/metrics", listener.Addr()))
+
+	return metrics, func() { _ = server.Close() }, nil
+}
+
+func (m *soakMetrics) iterationDone() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.iterations++
+}
+
+func (m *soakMetrics) failure(stage string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[stage]++
+}
+
+// exchangeFailed counts an exchange failure the harness.Metrics hooks did not
+// see: the transport, or a message that decrypted to the wrong plaintext.
+func (m *soakMetrics) exchangeFailed(err error) {
+	switch {
+	case errors.Is(err, harness.ErrTransport):
+		m.failure("transport")
+	case errors.Is(err, harness.ErrPlaintextMismatch):
+		m.failure("plaintext_mismatch")
+	}
+}
+
+func (m *soakMetrics) OnProtect(_ string, elapsed time.Duration, ciphertextBytes int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.protect.observe(elapsed.Seconds())
+	if err != nil {
+		m.failures["protect"]++
+		return
+	}
+	m.ciphertextBytes += uint64(ciphertextBytes)
+}
+
+func (m *soakMetrics) OnUnprotect(_ string, elapsed time.Duration, _ int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.unprotect.observe(elapsed.Seconds())
+	if err != nil {
+		m.failures["unprotect"]++
+	}
+}
+
+func (m *soakMetrics) OnCommit(_ string, elapsed time.Duration, _, _ int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commit.observe(elapsed.Seconds())
+	if err != nil {
+		m.failures["commit"]++
+		return
+	}
+	m.commits++
+}
+
+func (m *soakMetrics) OnJoin(_ string, elapsed time.Duration, _ int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.join.observe(elapsed.Seconds())
+	if err != nil {
+		m.failures["join"]++
+	}
+}
+
+func (m *soakMetrics) snapshotSize(participant, path string) {
+	if m == nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshotBytes[participant] = info.Size()
+}
+
+func (m *soakMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	var buf bytes.Buffer
+	m.writeTo(&buf)
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (m *soakMetrics) writeTo(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP mls_harness_soak_iterations_total Completed soak iterations (one message each way).\n")
+	fmt.Fprintf(buf, "# TYPE mls_harness_soak_iterations_total counter\n")
+	fmt.Fprintf(buf, "mls_harness_soak_iterations_total %d\n", m.iterations)
+
+	fmt.Fprintf(buf, "# HELP mls_harness_soak_commits_total Commits applied to the soak group.\n")
+	fmt.Fprintf(buf, "# TYPE mls_harness_soak_commits_total counter\n")
+	fmt.Fprintf(buf, "mls_harness_soak_commits_total %d\n", m.commits)
+
+	fmt.Fprintf(buf, "# HELP mls_harness_soak_failures_total Soak failures by stage.\n")
+	fmt.Fprintf(buf, "# TYPE mls_harness_soak_failures_total counter\n")
+	for _, stage := range sortedKeys(m.failures) {
+		fmt.Fprintf(buf, "mls_harness_soak_failures_total{stage=%q} %d\n", stage, m.failures[stage])
+	}
+
+	writeHistogram(buf, "mls_harness_soak_protect_seconds", "Time spent in State.Protect.", m.protect)
+	writeHistogram(buf, "mls_harness_soak_unprotect_seconds", "Time spent in State.Unprotect.", m.unprotect)
+	writeHistogram(buf, "mls_harness_soak_commit_seconds", "Time spent in State.Commit.", m.commit)
+	writeHistogram(buf, "mls_harness_soak_join_seconds", "Time spent joining through a Welcome.", m.join)
+
+	fmt.Fprintf(buf, "# HELP mls_harness_soak_ciphertext_bytes_total Encoded size of the application ciphertexts sent.\n")
+	fmt.Fprintf(buf, "# TYPE mls_harness_soak_ciphertext_bytes_total counter\n")
+	fmt.Fprintf(buf, "mls_harness_soak_ciphertext_bytes_total %d\n", m.ciphertextBytes)
+
+	fmt.Fprintf(buf, "# HELP mls_harness_soak_snapshot_bytes Size of the most recent persisted state snapshot.\n")
+	fmt.Fprintf(buf, "# TYPE mls_harness_soak_snapshot_bytes gauge\n")
+	for _, participant := range sortedKeys(m.snapshotBytes) {
+		fmt.Fprintf(buf, "mls_harness_soak_snapshot_bytes{participant=%q} %d\n", participant, m.snapshotBytes[participant])
+	}
+}
+
+func writeHistogram(buf *bytes.Buffer, name, help string, h *histogram) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s histogram\n", name)
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(buf, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(buf, "%s_sum %s\n", name, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count %d\n", name, h.count)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
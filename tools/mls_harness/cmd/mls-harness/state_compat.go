@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+const defaultStateCompatDir = "vectors/state-compat"
+const stateCompatManifestName = "manifest.json"
+
+// Fixed inputs for the dm participants captured in a state-compat fixture.
+const (
+	stateCompatInitiatorSeed int64 = 9101
+	stateCompatJoinerSeed    int64 = 9102
+	stateCompatInitSeed      int64 = 9103
+	stateCompatGroupIDBase64       = "c3RhdGUtY29tcGF0" // "state-compat"
+)
+
+// stateCompatManifest describes one frozen snapshot set under the fixtures directory.
+// Each subdirectory is produced once by a release and never regenerated in place.
+type stateCompatManifest struct {
+	Name   string `json:"name"`
+	Format string `json:"format"`
+	// ParticipantFormat is the dm participant encoding; empty means gob, from
+	// before participants had their own format.
+	ParticipantFormat string            `json:"participant_format,omitempty"`
+	WarmupMessages    int               `json:"warmup_messages"`
+	SmokeStates       map[string]string `json:"smoke_states,omitempty"`
+	DMParticipants    map[string]string `json:"dm_participants"`
+	GeneratedBy       string            `json:"generated_by,omitempty"`
+}
+
+func runStateCompat(fixturesDir string, iterations int) error {
+	if fixturesDir == "" {
+		fixturesDir = defaultStateCompatDir
+	}
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+
+	entries, err := os.ReadDir(fixturesDir)
+	if err != nil {
+		return fmt.Errorf("read fixtures dir: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no state-compat fixtures found under %s", fixturesDir)
+	}
+
+	failed := false
+	for _, name := range names {
+		if err := verifyStateCompatFixture(filepath.Join(fixturesDir, name), iterations); err != nil {
+			fmt.Printf("%s: FAIL (%v)\n", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: PASS\n", name)
+	}
+
+	if failed {
+		return errors.New("state-compat fixtures failed")
+	}
+
+	fmt.Println("state-compat: PASS")
+	return nil
+}
+
+func verifyStateCompatFixture(dir string, iterations int) error {
+	raw, err := os.ReadFile(filepath.Join(dir, stateCompatManifestName))
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest stateCompatManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return fmt.Errorf("parse manifest: %w", err)
+	}
+	if manifest.Format != "gob" {
+		return fmt.Errorf("unsupported fixture format %q", manifest.Format)
+	}
+
+	// Only gob_v1 carries harness smoke snapshots; they do not change with
+	// the dm participant format.
+	if len(manifest.SmokeStates) > 0 {
+		if err := verifyStateCompatSmoke(dir, manifest, iterations); err != nil {
+			return fmt.Errorf("smoke states: %w", err)
+		}
+	}
+	if err := verifyStateCompatDM(dir, manifest, iterations); err != nil {
+		return fmt.Errorf("dm participants: %w", err)
+	}
+	return nil
+}
+
+func verifyStateCompatSmoke(dir string, manifest stateCompatManifest, iterations int) error {
+	alicePath, ok := manifest.SmokeStates["alice"]
+	if !ok {
+		return errors.New("manifest missing smoke_states.alice")
+	}
+	bobPath, ok := manifest.SmokeStates["bob"]
+	if !ok {
+		return errors.New("manifest missing smoke_states.bob")
+	}
+
+	aliceState, err := harness.LoadState(filepath.Join(dir, alicePath))
+	if err != nil {
+		return fmt.Errorf("alice decode: %w", err)
+	}
+	bobState, err := harness.LoadState(filepath.Join(dir, bobPath))
+	if err != nil {
+		return fmt.Errorf("bob decode: %w", err)
+	}
+
+	alice := &harness.Participant{Name: "alice", Session: harness.Session{State: aliceState}}
+	bob := &harness.Participant{Name: "bob", Session: harness.Session{State: bobState}}
+	for i := 0; i < iterations; i++ {
+		payload := []byte(fmt.Sprintf("compat-%d", i))
+		if err := harness.ExchangeOnce(alice, bob, payload); err != nil {
+			return fmt.Errorf("iteration %d alice->bob: %w", i, err)
+		}
+		if err := harness.ExchangeOnce(bob, alice, payload); err != nil {
+			return fmt.Errorf("iteration %d bob->alice: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func verifyStateCompatDM(dir string, manifest stateCompatManifest, iterations int) error {
+	initiatorPath, ok := manifest.DMParticipants["initiator"]
+	if !ok {
+		return errors.New("manifest missing dm_participants.initiator")
+	}
+	joinerPath, ok := manifest.DMParticipants["joiner"]
+	if !ok {
+		return errors.New("manifest missing dm_participants.joiner")
+	}
+
+	initiator, err := readParticipantFile(filepath.Join(dir, initiatorPath))
+	if err != nil {
+		return fmt.Errorf("initiator read: %w", err)
+	}
+	joiner, err := readParticipantFile(filepath.Join(dir, joinerPath))
+	if err != nil {
+		return fmt.Errorf("joiner read: %w", err)
+	}
+	if initiator == "" || joiner == "" {
+		return errors.New("participant fixture is empty")
+	}
+	want := manifest.ParticipantFormat
+	if want == "" {
+		want = dm.ParticipantFormatGob
+	}
+	for name, blob := range map[string]string{"initiator": initiator, "joiner": joiner} {
+		format, err := dm.ParticipantFormat(blob)
+		if err != nil {
+			return fmt.Errorf("%s format: %w", name, err)
+		}
+		if format != want {
+			return fmt.Errorf("%s is %s, manifest says %s", name, format, want)
+		}
+	}
+
+	for i := 0; i < iterations; i++ {
+		plaintext := fmt.Sprintf("compat-dm-%d", i)
+
+		var ciphertext, decrypted string
+		initiator, ciphertext, err = dm.Encrypt(initiator, "", plaintext)
+		if err != nil {
+			return fmt.Errorf("iteration %d initiator encrypt: %w", i, err)
+		}
+		joiner, decrypted, err = dm.Decrypt(joiner, "", ciphertext)
+		if err != nil {
+			return fmt.Errorf("iteration %d joiner decrypt: %w", i, err)
+		}
+		if decrypted != plaintext {
+			return fmt.Errorf("iteration %d initiator->joiner plaintext mismatch", i)
+		}
+
+		joiner, ciphertext, err = dm.Encrypt(joiner, "", plaintext)
+		if err != nil {
+			return fmt.Errorf("iteration %d joiner encrypt: %w", i, err)
+		}
+		initiator, decrypted, err = dm.Decrypt(initiator, "", ciphertext)
+		if err != nil {
+			return fmt.Errorf("iteration %d initiator decrypt: %w", i, err)
+		}
+		if decrypted != plaintext {
+			return fmt.Errorf("iteration %d joiner->initiator plaintext mismatch", i)
+		}
+	}
+	return nil
+}
+
+// generateStateCompatFixture captures the current dm participant encoding as a new
+// fixture set. It refuses to overwrite an existing snapshot so older releases stay frozen.
+func generateStateCompatFixture(fixturesDir, name string, warmup int) error {
+	if fixturesDir == "" {
+		fixturesDir = defaultStateCompatDir
+	}
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if warmup < 0 {
+		return fmt.Errorf("warmup must not be negative (got %d)", warmup)
+	}
+
+	dir := filepath.Join(fixturesDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("fixture %s already exists", dir)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create fixture dir: %w", err)
+	}
+
+	if err := writeStateCompatDM(dir, warmup); err != nil {
+		return fmt.Errorf("dm participants: %w", err)
+	}
+
+	manifest := stateCompatManifest{
+		Name:              name,
+		Format:            "gob",
+		ParticipantFormat: dm.ParticipantFormatMLSPv1,
+		WarmupMessages:    warmup,
+		DMParticipants:    map[string]string{"initiator": "initiator.participant", "joiner": "joiner.participant"},
+		GeneratedBy:       "mls-harness state-compat --generate",
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode manifest: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(filepath.Join(dir, stateCompatManifestName), data, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+
+	fmt.Println(dir)
+	return nil
+}
+
+// bindStateCompatIdentity binds the participant to a fixed test user key, so
+// the fixture holds an identity binding in the participant and in its leaf.
+func bindStateCompatIdentity(participant string) (string, error) {
+	messageB64, err := dm.IdentityBindingMessage(participant)
+	if err != nil {
+		return "", err
+	}
+	message, err := base64.StdEncoding.DecodeString(messageB64)
+	if err != nil {
+		return "", err
+	}
+	userKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{0x5c}, ed25519.SeedSize))
+	userID := base64.RawURLEncoding.EncodeToString(userKey.Public().(ed25519.PublicKey))
+	participant, _, err = dm.BindIdentity(participant, userID, base64.StdEncoding.EncodeToString(ed25519.Sign(userKey, message)))
+	return participant, err
+}
+
+func writeStateCompatDM(dir string, warmup int) error {
+	initiator, _, err := dm.KeyPackage("", "initiator", "", stateCompatInitiatorSeed)
+	if err != nil {
+		return fmt.Errorf("initiator keypackage: %w", err)
+	}
+	joiner, _, err := dm.KeyPackage("", "joiner", "", stateCompatJoinerSeed)
+	if err != nil {
+		return fmt.Errorf("joiner keypackage: %w", err)
+	}
+	// The joiner joins through one of two pooled KeyPackages, so the fixture
+	// holds a consumed and an available pool entry.
+	joiner, joinerKPs, err := dm.KeyPackagePool(joiner, 2, stateCompatJoinerSeed)
+	if err != nil {
+		return fmt.Errorf("joiner keypackage pool: %w", err)
+	}
+
+	initiator, err = bindStateCompatIdentity(initiator)
+	if err != nil {
+		return fmt.Errorf("initiator identity binding: %w", err)
+	}
+
+	initiator, welcome, commit, err := dm.Init(initiator, joinerKPs[0], stateCompatGroupIDBase64, "", stateCompatInitSeed)
+	if err != nil {
+		return fmt.Errorf("init: %w", err)
+	}
+	joiner, err = dm.Join(joiner, stateCompatGroupIDBase64, welcome)
+	if err != nil {
+		return fmt.Errorf("join: %w", err)
+	}
+	initiator, _, err = dm.CommitApply(initiator, stateCompatGroupIDBase64, commit)
+	if err != nil {
+		return fmt.Errorf("initiator commit apply: %w", err)
+	}
+	// Both sides name the initiator as the group's only admin, so the fixture
+	// holds an admin list in each session.
+	if initiator, err = dm.SetAdmins(initiator, stateCompatGroupIDBase64, []string{"initiator"}); err != nil {
+		return fmt.Errorf("initiator admins: %w", err)
+	}
+	if joiner, err = dm.SetAdmins(joiner, stateCompatGroupIDBase64, []string{"initiator"}); err != nil {
+		return fmt.Errorf("joiner admins: %w", err)
+	}
+	// A rotation policy loose enough not to trigger during the warmup, so the
+	// fixture holds one and the message count it keeps.
+	if initiator, err = dm.SetRotation(initiator, dm.RotationPolicy{MaxMessages: 1000, MaxEpochs: 100}); err != nil {
+		return fmt.Errorf("initiator rotation: %w", err)
+	}
+
+	for i := 0; i < warmup; i++ {
+		var ciphertext string
+		initiator, ciphertext, err = dm.Encrypt(initiator, stateCompatGroupIDBase64, fmt.Sprintf("warmup-%d", i))
+		if err != nil {
+			return fmt.Errorf("warmup %d encrypt: %w", i, err)
+		}
+		joiner, _, err = dm.Decrypt(joiner, stateCompatGroupIDBase64, ciphertext)
+		if err != nil {
+			return fmt.Errorf("warmup %d decrypt: %w", i, err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "initiator.participant"), []byte(initiator), 0o644); err != nil {
+		return fmt.Errorf("write initiator: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "joiner.participant"), []byte(joiner), 0o644); err != nil {
+		return fmt.Errorf("write joiner: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// writeTranscript writes the encoded transcript to path, if one is named.
+func writeTranscript(path string, transcript *harness.Transcript) error {
+	if path == "" || transcript == nil {
+		return nil
+	}
+	data, err := transcript.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write transcript: %w", err)
+	}
+	return nil
+}
+
+func readTranscript(path string) (*harness.Transcript, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read transcript: %w", err)
+	}
+	return harness.UnmarshalTranscript(data)
+}
+
+// diagnoseAgainst reports where transcript first differs from the one stored
+// at referencePath, or nil if it does not.
+func diagnoseAgainst(referencePath string, transcript *harness.Transcript) (*harness.TranscriptDivergence, error) {
+	reference, err := readTranscript(referencePath)
+	if err != nil {
+		return nil, err
+	}
+	return harness.DiagnoseDivergence(reference.RunningDigests(), transcript), nil
+}
+
+// runTranscript decodes a transcript file, lists its entries and recomputes
+// its digest, checking it against expected and comparing it with the
+// transcript at referencePath when they are given.
+func runTranscript(path, expected, referencePath string) error {
+	if path == "" {
+		return errors.New("file is required")
+	}
+	transcript, err := readTranscript(path)
+	if err != nil {
+		return err
+	}
+	for i, entry := range transcript.Entries {
+		fmt.Printf("%d %s %s %d\n", i, entry.Type, entry.Label, len(entry.Data))
+	}
+	digest := transcript.HexDigest()
+	if referencePath != "" {
+		divergence, err := diagnoseAgainst(referencePath, transcript)
+		if err != nil {
+			return err
+		}
+		if divergence != nil {
+			return fmt.Errorf("transcript differs from %s: %s", referencePath, divergence)
+		}
+	}
+	if expected != "" && !strings.EqualFold(expected, digest) {
+		return fmt.Errorf("digest mismatch: computed %s expected %s", digest, expected)
+	}
+	fmt.Printf("transcript: entries=%d digest=%s\n", len(transcript.Entries), digest)
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+)
+
+// wasiRunner starts the wasip1 build of the harness in rpc mode under Node's
+// WASI, handing it the MLS_HARNESS_ settings of the native side so both run
+// the same configuration.
+const wasiRunner = `
+const fs = require('fs');
+const { WASI } = require('node:wasi');
+const env = Object.fromEntries(Object.entries(process.env).filter(([k]) => k.startsWith('MLS_HARNESS_')));
+const wasi = new WASI({ version: 'preview1', args: ['mls-harness', 'rpc'], env });
+(async () => {
+  const module = await WebAssembly.compile(fs.readFileSync(process.argv[2]));
+  const instance = await WebAssembly.instantiate(module, wasi.getImportObject());
+  process.exitCode = wasi.start(instance);
+})();
+`
+
+const (
+	implNative = "native"
+	implWASM   = "wasm"
+)
+
+// unrepeatableFields are the results of an rpc method that differ between two
+// runs on the same build: go-mls encrypts a Welcome to its joiners with
+// crypto/rand, and the committer's participant keeps that Welcome.
+var unrepeatableFields = map[string]map[string]bool{
+	"dmInit":    {"welcome_b64": true, "participant_b64": true},
+	"groupInit": {"welcome_b64": true, "participant_b64": true},
+	"groupAdd":  {"welcome_b64": true, "participant_b64": true},
+}
+
+// rpcPeer answers the rpc methods of one build of the harness.
+type rpcPeer interface {
+	call(method string, params map[string]interface{}) (map[string]interface{}, error)
+}
+
+// nativePeer answers in process.
+type nativePeer struct{}
+
+func (nativePeer) call(method string, params map[string]interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	// Through JSON, so results compare with the module's as the same types.
+	data, err := json.Marshal(rpcCall(method, raw))
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// wasiPeer answers through the wasip1 module running under Node.
+type wasiPeer struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+	nextID int
+}
+
+func startWASIPeer(node, runner, module string) (*wasiPeer, error) {
+	cmd := exec.Command(node, "--no-warnings", runner, module)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start %s: %w", node, err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), maxServeRequestBytes)
+	return &wasiPeer{cmd: cmd, stdin: stdin, stdout: scanner}, nil
+}
+
+func (p *wasiPeer) call(method string, params map[string]interface{}) (map[string]interface{}, error) {
+	p.nextID++
+	line, err := json.Marshal(map[string]interface{}{"id": p.nextID, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		return nil, fmt.Errorf("write to module: %w", err)
+	}
+	if !p.stdout.Scan() {
+		if err := p.stdout.Err(); err != nil {
+			return nil, fmt.Errorf("read from module: %w", err)
+		}
+		return nil, errors.New("module exited without answering")
+	}
+	var response struct {
+		ID     int                    `json:"id"`
+		Result map[string]interface{} `json:"result"`
+	}
+	if err := json.Unmarshal(p.stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("module answered %q: %w", p.stdout.Text(), err)
+	}
+	if response.ID != p.nextID {
+		return nil, fmt.Errorf("module answered request %d, want %d", response.ID, p.nextID)
+	}
+	return response.Result, nil
+}
+
+func (p *wasiPeer) close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
+
+// wasmExchange runs each call on the build that owns the participant and
+// again, with the same params, on the other build, which must answer the
+// same bytes.
+type wasmExchange struct {
+	peers    map[string]rpcPeer
+	calls    int
+	compared int
+}
+
+func (x *wasmExchange) call(owner, method string, params map[string]interface{}) (map[string]interface{}, error) {
+	mirror := implWASM
+	if owner == implWASM {
+		mirror = implNative
+	}
+	result, err := x.peers[owner].call(method, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, owner, err)
+	}
+	if ok, _ := result["ok"].(bool); !ok {
+		return nil, fmt.Errorf("%s on %s failed: %v (%v)", method, owner, result["error"], result["code"])
+	}
+	again, err := x.peers[mirror].call(method, params)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, mirror, err)
+	}
+	if ok, _ := again["ok"].(bool); !ok {
+		return nil, fmt.Errorf("%s succeeded on %s and failed on %s: %v (%v)", method, owner, mirror, again["error"], again["code"])
+	}
+	fields := make([]string, 0, len(result))
+	for field := range result {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if unrepeatableFields[method][field] {
+			continue
+		}
+		if !reflect.DeepEqual(result[field], again[field]) {
+			return nil, fmt.Errorf("%s: %s and %s disagree on %s", method, owner, mirror, field)
+		}
+		x.compared++
+	}
+	x.calls++
+	return result, nil
+}
+
+// runWASMExchange forms a DM between a participant on the native build and
+// one on the wasip1 module under Node, and exchanges messages, then does it
+// again with the builds swapped. Every KeyPackage, Welcome, commit,
+// ciphertext and participant blob one build makes is consumed by the other,
+// and every call is repeated on the other build to check it gives the same
+// bytes, so behaviour that differs between targets, such as gob encoding or a
+// random stream, shows up here before it reaches a browser.
+func runWASMExchange(module, node string, iterations int) error {
+	if iterations < 1 {
+		return fmt.Errorf("iterations must be at least 1 (got %d)", iterations)
+	}
+	if module == "" {
+		return errors.New("--module is required")
+	}
+	if _, err := os.Stat(module); err != nil {
+		return fmt.Errorf("module: %w", err)
+	}
+	node, err := exec.LookPath(node)
+	if err != nil {
+		return fmt.Errorf("node: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "mls-harness-wasm-exchange-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	runner := filepath.Join(dir, "wasi_runner.js")
+	if err := os.WriteFile(runner, []byte(wasiRunner), 0o600); err != nil {
+		return err
+	}
+	wasi, err := startWASIPeer(node, runner, module)
+	if err != nil {
+		return err
+	}
+	x := &wasmExchange{peers: map[string]rpcPeer{implNative: nativePeer{}, implWASM: wasi}}
+
+	layouts := [][2]string{{implNative, implWASM}, {implWASM, implNative}}
+	for _, layout := range layouts {
+		if err := wasmExchangeDM(x, layout[0], layout[1], iterations); err != nil {
+			wasi.close()
+			return fmt.Errorf("alice on %s, bob on %s: %w", layout[0], layout[1], err)
+		}
+	}
+	if err := wasi.close(); err != nil {
+		return fmt.Errorf("module: %w", err)
+	}
+	fmt.Printf("wasm-exchange: PASS (layouts=%d calls=%d fields_compared=%d messages=%d)\n",
+		len(layouts), x.calls, x.compared, 2*iterations*len(layouts))
+	return nil
+}
+
+func wasmExchangeDM(x *wasmExchange, aliceOn, bobOn string, iterations int) error {
+	alice, err := x.call(aliceOn, "dmCreateParticipant", map[string]interface{}{"name": "alice", "seed_int": 1})
+	if err != nil {
+		return err
+	}
+	bob, err := x.call(bobOn, "dmCreateParticipant", map[string]interface{}{"name": "bob", "seed_int": 2})
+	if err != nil {
+		return err
+	}
+	init, err := x.call(aliceOn, "dmInit", map[string]interface{}{
+		"participant_b64":     alice["participant_b64"],
+		"peer_keypackage_b64": bob["keypackage_b64"],
+		"group_id_b64":        base64.StdEncoding.EncodeToString([]byte("wasm-exchange")),
+		"seed_int":            3,
+	})
+	if err != nil {
+		return err
+	}
+	joined, err := x.call(bobOn, "dmJoin", map[string]interface{}{
+		"participant_b64": bob["participant_b64"],
+		"welcome_b64":     init["welcome_b64"],
+	})
+	if err != nil {
+		return err
+	}
+	applied := map[string]map[string]interface{}{}
+	for _, side := range []struct {
+		name, on string
+		state    map[string]interface{}
+	}{{"alice", aliceOn, init}, {"bob", bobOn, joined}} {
+		if applied[side.name], err = x.call(side.on, "dmCommitApply", map[string]interface{}{
+			"participant_b64": side.state["participant_b64"],
+			"commit_b64":      init["commit_b64"],
+		}); err != nil {
+			return err
+		}
+	}
+
+	on := map[string]string{"alice": aliceOn, "bob": bobOn}
+	for i := 0; i < iterations; i++ {
+		for _, pair := range [][2]string{{"alice", "bob"}, {"bob", "alice"}} {
+			sender, receiver := pair[0], pair[1]
+			plaintext := fmt.Sprintf("%s-%d", sender, i)
+			sent, err := x.call(on[sender], "dmEncrypt", map[string]interface{}{
+				"participant_b64": applied[sender]["participant_b64"],
+				"plaintext":       plaintext,
+			})
+			if err != nil {
+				return err
+			}
+			received, err := x.call(on[receiver], "dmDecrypt", map[string]interface{}{
+				"participant_b64": applied[receiver]["participant_b64"],
+				"ciphertext_b64":  sent["ciphertext_b64"],
+			})
+			if err != nil {
+				return err
+			}
+			if received["plaintext"] != plaintext {
+				return fmt.Errorf("iteration %d: %s read %q from %s, want %q", i, receiver, received["plaintext"], sender, plaintext)
+			}
+			applied[sender], applied[receiver] = sent, received
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// runWelcomeLoss adds a third member whose Welcome is dropped in transit. The
+// group keeps committing for several epochs before the member is re-invited with
+// a fresh KeyPackage; the stale leaf is removed in the same commit so neither the
+// adder nor the other members carry the abandoned join forward.
+func runWelcomeLoss(epochs, iterations int) error {
+	if epochs <= 0 {
+		return fmt.Errorf("epochs must be positive (got %d)", epochs)
+	}
+	if iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", iterations)
+	}
+
+	rng := harness.DeterministicRNG()
+	defer epochChain.pinCryptoRand(rng)()
+
+	alice, bob, err := harness.BootstrapPairWithDigest(rng, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+	coverage.bootstrap()
+	epochChain.observe(alice, bob)
+	suite := alice.State.CipherSuite
+
+	carol, err := newMember(rng, suite, "carol")
+	if err != nil {
+		return fmt.Errorf("carol init: %w", err)
+	}
+
+	// alice adds carol; the commit reaches bob but the Welcome never reaches carol.
+	add, err := alice.State.Add(carol.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("add carol: %w", err)
+	}
+	coverage.record(opAdd)
+	lostWelcome, err := commitProposals(rng, alice, []*harness.Participant{bob}, []*mls.MLSPlaintext{add})
+	if err != nil {
+		return fmt.Errorf("first invite: %w", err)
+	}
+	staleLeaf, ok := alice.State.Tree.Find(carol.KeyPackage)
+	if !ok {
+		return errors.New("carol missing from tree after first invite")
+	}
+
+	members := []*harness.Participant{alice, bob}
+	for epoch := 0; epoch < epochs; epoch++ {
+		committer := members[epoch%len(members)]
+		others := []*harness.Participant{members[(epoch+1)%len(members)]}
+		if _, err := commitProposals(rng, committer, others, nil); err != nil {
+			return fmt.Errorf("epoch %d: %w", epoch, err)
+		}
+		for i := 0; i < iterations; i++ {
+			payload := []byte(fmt.Sprintf("epoch-%d-msg-%d", epoch, i))
+			if err := broadcastOnce(alice, []*harness.Participant{bob}, payload); err != nil {
+				return fmt.Errorf("epoch %d iteration %d: %w", epoch, i, err)
+			}
+			if err := broadcastOnce(bob, []*harness.Participant{alice}, payload); err != nil {
+				return fmt.Errorf("epoch %d iteration %d: %w", epoch, i, err)
+			}
+		}
+	}
+
+	// The lost Welcome shows up late. Joining with it yields a state stuck in the
+	// past that the live group must not accept traffic from. The Welcome is
+	// still well formed, so a join that fails means the scenario never put the
+	// stale member in front of the group.
+	staleState, err := mls.NewJoinedState(carol.InitSecret, []mls.SignaturePrivateKey{carol.SigningKey}, []mls.KeyPackage{carol.KeyPackage}, *lostWelcome)
+	if err != nil {
+		return fmt.Errorf("join with the lost Welcome: %w", err)
+	}
+	coverage.record(opWelcomeJoin)
+	stale := &harness.Participant{Name: "carol-stale", Session: harness.Session{State: staleState}}
+	err = broadcastOnce(stale, []*harness.Participant{alice}, []byte("stale-join"))
+	if err == nil {
+		return errors.New("group accepted traffic from a stale Welcome")
+	}
+	fmt.Printf("welcome-loss: stale join at epoch %d refused at epoch %d (%v)\n", staleState.Epoch, alice.State.Epoch, err)
+
+	// Re-invite carol with a fresh KeyPackage, removing the abandoned leaf in the same commit.
+	freshCarol, err := newMember(rng, suite, "carol")
+	if err != nil {
+		return fmt.Errorf("carol re-init: %w", err)
+	}
+	remove, err := alice.State.Remove(staleLeaf)
+	if err != nil {
+		return fmt.Errorf("remove stale leaf: %w", err)
+	}
+	coverage.record(opRemove)
+	readd, err := alice.State.Add(freshCarol.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("re-add carol: %w", err)
+	}
+	coverage.record(opAdd)
+	welcome, err := commitProposals(rng, alice, []*harness.Participant{bob}, []*mls.MLSPlaintext{remove, readd})
+	if err != nil {
+		return fmt.Errorf("re-invite: %w", err)
+	}
+	freshCarol.State, err = mls.NewJoinedState(freshCarol.InitSecret, []mls.SignaturePrivateKey{freshCarol.SigningKey}, []mls.KeyPackage{freshCarol.KeyPackage}, *welcome)
+	if err != nil {
+		return fmt.Errorf("carol join: %w", err)
+	}
+	coverage.record(opWelcomeJoin)
+	epochChain.observe(freshCarol)
+	if _, ok := alice.State.Tree.Find(carol.KeyPackage); ok {
+		return errors.New("stale carol leaf still present after re-invite")
+	}
+
+	group := []*harness.Participant{alice, bob, freshCarol}
+	for _, member := range group {
+		if member.State.Epoch != alice.State.Epoch {
+			return fmt.Errorf("epoch mismatch after re-invite: %s=%d %s=%d", member.Name, member.State.Epoch, alice.Name, alice.State.Epoch)
+		}
+	}
+	if err := broadcastRounds(group, iterations, "rejoined"); err != nil {
+		return fmt.Errorf("post re-invite: %w", err)
+	}
+
+	fmt.Printf("welcome-loss: recovered at epoch %d after %d lost epochs\n", alice.State.Epoch, epochs)
+	return nil
+}
+
+// commitProposals delivers proposals authored by committer to every member, commits
+// them, and applies the commit everywhere. It returns the Welcome for any joiners.
+func commitProposals(rng io.Reader, committer *harness.Participant, others []*harness.Participant, proposals []*mls.MLSPlaintext) (*mls.Welcome, error) {
+	for _, proposal := range proposals {
+		if _, err := committer.State.Handle(proposal); err != nil {
+			return nil, fmt.Errorf("%s handle proposal: %w", committer.Name, err)
+		}
+		for _, other := range others {
+			if _, err := other.State.Handle(proposal); err != nil {
+				return nil, fmt.Errorf("%s handle proposal: %w", other.Name, err)
+			}
+		}
+	}
+
+	commit, welcome, next, err := committer.State.Commit(harness.RandomBytes(rng, 32))
+	if err != nil {
+		return nil, fmt.Errorf("%s commit: %w", committer.Name, err)
+	}
+	coverage.record(opCommit)
+	for _, other := range others {
+		otherNext, err := other.State.Handle(commit)
+		if err != nil {
+			return nil, fmt.Errorf("%s apply commit: %w", other.Name, err)
+		}
+		other.State = otherNext
+	}
+	committer.State = next
+	epochChain.observe(committer)
+	epochChain.observe(others...)
+	return welcome, nil
+}
+
+// broadcastOnce protects one message from sender and requires every receiver to decrypt it.
+func broadcastOnce(sender *harness.Participant, receivers []*harness.Participant, msg []byte) error {
+	ct, err := sender.State.Protect(msg)
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
+	}
+	for _, receiver := range receivers {
+		pt, err := receiver.State.Unprotect(ct)
+		if err != nil {
+			return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
+		}
+		if string(pt) != string(msg) {
+			return fmt.Errorf("plaintext mismatch for %s -> %s", sender.Name, receiver.Name)
+		}
+	}
+	coverage.appMessage(sender.State.Epoch)
+	return nil
+}
@@ -0,0 +1,142 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+)
+
+// Every dm binding returns a Promise. The call only queues the operation and
+// returns, and each operation runs in a later turn of the event loop, so a
+// click handler that starts a commit in a large group returns at once and the
+// page can paint between operations. An operation still runs on the page's
+// thread once it starts; keep the module in a worker to take it off the UI
+// thread entirely. Operations run one at a time in call order, so a
+// dmSetStateKey followed by a dmEncrypt seals with the new key whether or not
+// the caller awaits the first. The Promise resolves with the same result
+// object the binding always returned, {ok: false, ...} included; a binding
+// that panics resolves with code internal, as guard reports it.
+//
+// The synchronous bindings stay available for callers written against them
+// under the prefix "sync": syncDmEncrypt is dmEncrypt as it was. They bypass
+// the queue, so do not mix them with Promises still pending.
+const syncPrefix = "sync"
+
+// queue holds the operations the Promise bindings have yet to run, in call
+// order; runQueue drains it on its own goroutine. A binding runs on
+// JavaScript's thread and runQueue waits for a turn of the event loop before
+// each operation, so appending must never block: a page that starts more
+// operations in one turn than a bounded queue holds would otherwise hang the
+// module. wake tells runQueue the queue is no longer empty.
+var (
+	queueMu sync.Mutex
+	queue   []func()
+	wake    = make(chan struct{}, 1)
+)
+
+func enqueue(op func()) {
+	queueMu.Lock()
+	queue = append(queue, op)
+	queueMu.Unlock()
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// dequeue takes the oldest queued operation, or returns false if none is left.
+func dequeue() (func(), bool) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	if len(queue) == 0 {
+		return nil, false
+	}
+	op := queue[0]
+	queue[0] = nil
+	queue = queue[1:]
+	return op, true
+}
+
+// queued reports how many operations are waiting to run.
+func queued() int {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	return len(queue)
+}
+
+func runQueue() {
+	for range wake {
+		for op, ok := dequeue(); ok; op, ok = dequeue() {
+			yield()
+			op()
+		}
+	}
+}
+
+// yield returns once JavaScript has had a turn of its event loop. Without it
+// the operation would run before the binding returned: the Go runtime only
+// hands control back to JavaScript once every goroutine is blocked.
+func yield() {
+	done := make(chan struct{})
+	var callback js.Func
+	callback = js.FuncOf(func(js.Value, []js.Value) interface{} {
+		callback.Release()
+		close(done)
+		return nil
+	})
+	js.Global().Call("setTimeout", callback, 0)
+	<-done
+}
+
+// setAsync sets name to the Promise form of fn, name+bytesSuffix to the
+// Promise form returning bytes, and syncName(name) to fn, each telling the
+// state listeners about a new participant, recording the call while a
+// transcript is recorded, and returning the result envelope.
+func setAsync(name string, fn func(js.Value, []js.Value) interface{}) {
+	fn = guard(fn)
+	operations[name] = []string{name, name + bytesSuffix, syncName(name)}
+	asyncBindings[name] = fn
+	js.Global().Set(name, js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return promise(func() interface{} { return recordStep(name, args, envelope(notifyState(fn, this, args, false))) })
+	}))
+	js.Global().Set(name+bytesSuffix, js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return promise(func() interface{} {
+			return recordStep(name+bytesSuffix, args, envelope(notifyState(fn, this, args, true)))
+		})
+	}))
+	js.Global().Set(syncName(name), js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		return recordStep(name, args, envelope(notifyState(fn, this, args, false)))
+	}))
+}
+
+// setSync sets name to fn, which runs at once, outside the queue.
+func setSync(name string, fn func(js.Value, []js.Value) interface{}) {
+	operations[name] = []string{name}
+	js.Global().Set(name, js.FuncOf(guard(fn)))
+}
+
+func syncName(name string) string {
+	return syncPrefix + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// promise returns a Promise that queues op and settles with its result.
+func promise(op func() interface{}) js.Value {
+	executor := js.FuncOf(func(_ js.Value, handlers []js.Value) interface{} {
+		resolve, reject := handlers[0], handlers[1]
+		enqueue(func() {
+			defer func() {
+				if r := recover(); r != nil {
+					reject.Invoke(js.Global().Get("Error").New(fmt.Sprint(r)))
+				}
+			}()
+			resolve.Invoke(op())
+		})
+		return nil
+	})
+	defer executor.Release()
+	return js.Global().Get("Promise").New(executor)
+}
@@ -0,0 +1,284 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall/js"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// benchOptions are mlsBench's options, with their defaults.
+type benchOptions struct {
+	iterations   int
+	messageBytes int
+	batch        int
+	suite        string
+}
+
+var defaultBenchOptions = benchOptions{iterations: 10, messageBytes: 256, batch: 16}
+
+// mlsBench(opts) times the dm operations a page runs, in this browser, through
+// the same entry points the bindings call, so the blob decode and encode each
+// call pays is part of every figure. opts is {iterations, message_bytes,
+// batch, cipher_suite}, each optional. It creates iterations participants
+// with a KeyPackage, starts iterations DMs between two of them, joins one,
+// then sends iterations messages of message_bytes one at a time and iterations
+// batches of batch messages, decrypting each on the other side. It returns
+// {options, environment, participant_bytes, results}: results has keypackage,
+// group_init, join, encrypt, decrypt, encrypt_batch and decrypt_batch, each
+// {runs, total_ms, mean_ms, median_ms, min_ms, max_ms}, the batch entries
+// with per_message_ms too. Participants follow the module mode, so a secure
+// module benchmarks secure ones.
+func mlsBench(_ js.Value, args []js.Value) interface{} {
+	opts := defaultBenchOptions
+	if len(args) > 0 && !args[0].IsUndefined() && !args[0].IsNull() {
+		var err error
+		if opts, err = readBenchOptions(args[0]); err != nil {
+			return errorResult(err)
+		}
+	}
+	results, participantBytes, err := runBench(opts)
+	if err != nil {
+		return errorResult(fmt.Errorf("bench: %w", err))
+	}
+	suite := opts.suite
+	if suite == "" {
+		suite = dm.DefaultCipherSuite.String()
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok": true,
+		"options": map[string]interface{}{
+			"iterations":    opts.iterations,
+			"message_bytes": opts.messageBytes,
+			"batch":         opts.batch,
+			"cipher_suite":  suite,
+		},
+		"environment":       benchEnvironment(),
+		"participant_bytes": participantBytes,
+		"results":           results,
+	})
+}
+
+func readBenchOptions(value js.Value) (benchOptions, error) {
+	if value.Type() != js.TypeObject {
+		return benchOptions{}, errors.New("opts must be an object")
+	}
+	opts := defaultBenchOptions
+	for _, field := range []struct {
+		name     string
+		target   *int
+		min, max int
+	}{
+		{"iterations", &opts.iterations, 1, 1000},
+		{"message_bytes", &opts.messageBytes, 1, maxMessageBytes / 4},
+		{"batch", &opts.batch, 1, 256},
+	} {
+		entry := value.Get(field.name)
+		if entry.IsUndefined() || entry.IsNull() {
+			continue
+		}
+		n, err := readCount(entry, field.name)
+		if err != nil || n < uint64(field.min) || n > uint64(field.max) {
+			return benchOptions{}, fmt.Errorf("%s must be an integer between %d and %d", field.name, field.min, field.max)
+		}
+		*field.target = int(n)
+	}
+	if suite := value.Get("cipher_suite"); !suite.IsUndefined() && !suite.IsNull() {
+		name, err := readString(suite, "cipher_suite")
+		if err != nil {
+			return benchOptions{}, err
+		}
+		if _, err := dm.ParseCipherSuite(name); err != nil {
+			return benchOptions{}, err
+		}
+		opts.suite = name
+	}
+	return opts, nil
+}
+
+// runBench runs the operations opts asks for and returns their timings and
+// the size of the sender's participant at the end.
+func runBench(opts benchOptions) (map[string]interface{}, int, error) {
+	mode := moduleMode()
+	// A secure module refuses every seed but 0; a deterministic one gets a
+	// fresh seed per call so no two runs share randomness.
+	next := int64(0)
+	seed := func() int64 {
+		if mode == dm.ModeSecure {
+			return 0
+		}
+		next++
+		return next
+	}
+	timings := map[string][]time.Duration{}
+	timed := func(name string, fn func() error) error {
+		start := time.Now()
+		err := fn()
+		timings[name] = append(timings[name], time.Since(start))
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ReplaceAll(name, "_", " "), err)
+		}
+		return nil
+	}
+
+	var participants, keypackages []string
+	for i := 0; i < opts.iterations || len(participants) < 2; i++ {
+		var participantB64, keypackageB64 string
+		err := timed("keypackage", func() (err error) {
+			participantB64, keypackageB64, err = dm.KeyPackageWithMode("", fmt.Sprintf("bench-%d", i), opts.suite, mode, seed())
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		participants = append(participants, participantB64)
+		keypackages = append(keypackages, keypackageB64)
+	}
+
+	// Each DM starts from the same creator, so every group_init run pays for
+	// a participant with no groups yet; the last one is kept.
+	var sender, welcome, commit, groupID string
+	for i := 0; i < opts.iterations; i++ {
+		groupID = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("mls-bench-%d", i)))
+		err := timed("group_init", func() (err error) {
+			sender, welcome, commit, err = dm.Init(participants[0], keypackages[1], groupID, opts.suite, seed())
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	var receiver string
+	err := timed("join", func() (err error) {
+		receiver, err = dm.Join(participants[1], groupID, welcome)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if sender, _, err = dm.CommitApply(sender, groupID, commit); err != nil {
+		return nil, 0, fmt.Errorf("apply commit: %w", err)
+	}
+	if receiver, _, err = dm.CommitApply(receiver, groupID, commit); err != nil {
+		return nil, 0, fmt.Errorf("apply commit: %w", err)
+	}
+
+	plaintext := strings.Repeat("m", opts.messageBytes)
+	for i := 0; i < opts.iterations; i++ {
+		var ciphertext string
+		err := timed("encrypt", func() (err error) {
+			sender, ciphertext, err = dm.Encrypt(sender, groupID, plaintext)
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		err = timed("decrypt", func() (err error) {
+			receiver, _, err = dm.Decrypt(receiver, groupID, ciphertext)
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	plaintexts := make([]string, opts.batch)
+	for i := range plaintexts {
+		plaintexts[i] = plaintext
+	}
+	for i := 0; i < opts.iterations; i++ {
+		var ciphertexts []string
+		err := timed("encrypt_batch", func() (err error) {
+			sender, ciphertexts, err = dm.EncryptBatch(sender, groupID, plaintexts)
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		var messages []dm.BatchMessage
+		err = timed("decrypt_batch", func() (err error) {
+			receiver, messages, err = dm.DecryptBatch(receiver, groupID, ciphertexts)
+			return err
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, message := range messages {
+			if message.Error != "" {
+				return nil, 0, fmt.Errorf("decrypt batch: %s", message.Error)
+			}
+		}
+	}
+
+	results := map[string]interface{}{}
+	for name, durations := range timings {
+		summary := benchSummary(durations)
+		if strings.HasSuffix(name, "_batch") {
+			summary["per_message_ms"] = math.Round(summary["mean_ms"].(float64)/float64(opts.batch)*1000) / 1000
+		}
+		results[name] = summary
+	}
+	raw, err := base64.StdEncoding.DecodeString(sender)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decode participant: %w", err)
+	}
+	return results, len(raw), nil
+}
+
+// benchSummary reduces the durations of one operation to milliseconds.
+// Browsers coarsen their clocks, to 100µs or even 1ms without cross-origin
+// isolation, so compare means over many runs rather than single figures.
+func benchSummary(durations []time.Duration) map[string]interface{} {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+	ms := func(d time.Duration) float64 {
+		return math.Round(float64(d.Microseconds())) / 1000
+	}
+	return map[string]interface{}{
+		"runs":      len(sorted),
+		"total_ms":  ms(total),
+		"mean_ms":   ms(total / time.Duration(len(sorted))),
+		"median_ms": ms(median),
+		"min_ms":    ms(sorted[0]),
+		"max_ms":    ms(sorted[len(sorted)-1]),
+	}
+}
+
+// benchEnvironment describes where the figures came from, as far as the
+// global scope tells: the user agent, the core count the browser reports and
+// whether the module runs in a worker.
+func benchEnvironment() map[string]interface{} {
+	env := map[string]interface{}{
+		"go_version": runtime.Version(),
+		"mode":       moduleMode().String(),
+	}
+	if navigator := js.Global().Get("navigator"); navigator.Type() == js.TypeObject {
+		if agent := navigator.Get("userAgent"); agent.Type() == js.TypeString {
+			env["user_agent"] = agent.String()
+		}
+		if cores := navigator.Get("hardwareConcurrency"); cores.Type() == js.TypeNumber {
+			env["hardware_concurrency"] = cores.Int()
+		}
+	}
+	scope := js.Global().Get("WorkerGlobalScope")
+	env["worker"] = scope.Type() == js.TypeFunction && js.Global().InstanceOf(scope)
+	return env
+}
@@ -0,0 +1,151 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// Every argument named *_b64, and the KeyPackage and ciphertext arrays, may be
+// passed as a Uint8Array instead of a base64 string. Each binding also has a
+// variant named with the suffix "Bytes", dmEncryptBytes for dmEncrypt, whose
+// result carries Uint8Arrays in place of base64: a field named *_b64 comes
+// back without the suffix, so participant_b64 becomes participant, and
+// proposals_b64 becomes proposals. keypackages, welcomes and ciphertexts keep
+// their names. A client that keeps participant state and artifacts as bytes
+// never builds the base64 strings, which take a third more memory and a copy
+// each way. Inside the module the dm package still works on base64.
+const bytesSuffix = "Bytes"
+
+// bytesFields are the result fields besides *_b64 that hold base64.
+var bytesFields = map[string]bool{"keypackages": true, "welcomes": true, "ciphertexts": true}
+
+// readBase64 reads a base64 argument given as a string, a Uint8Array or an
+// ArrayBuffer, refusing one checkBase64 or checkBytesLen refuses.
+func readBase64(value js.Value, name string) (string, error) {
+	value = viewArrayBuffer(value)
+	if isUint8Array(value) {
+		if err := checkBytesLen(name, value.Length()); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(copyBytesToGo(value)), nil
+	}
+	text, err := readString(value, name)
+	if err != nil {
+		return "", err
+	}
+	if err := checkBase64(name, text); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// readBase64Array reads an array whose entries are base64 strings or
+// Uint8Arrays.
+func readBase64Array(value js.Value, name string) ([]string, error) {
+	if !js.Global().Get("Array").Call("isArray", value).Bool() {
+		return nil, errors.New(name + " must be an array")
+	}
+	length := value.Length()
+	values := make([]string, 0, length)
+	for index := 0; index < length; index++ {
+		entry, err := readBase64(value.Index(index), name)
+		if errors.Is(err, dm.ErrInvalidInput) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, errors.New(name + " must be an array of strings or Uint8Arrays")
+		}
+		values = append(values, entry)
+	}
+	return values, nil
+}
+
+// bytesResult rewrites a binding's result for its Bytes variant.
+func bytesResult(result interface{}) interface{} {
+	value, ok := result.(js.Value)
+	if !ok || value.Type() != js.TypeObject || !value.Get("ok").Truthy() {
+		return result
+	}
+	keys := js.Global().Get("Object").Call("keys", value)
+	for index := 0; index < keys.Length(); index++ {
+		key := keys.Index(index).String()
+		name, base64Field := strings.CutSuffix(key, "_b64")
+		if !base64Field && !bytesFields[key] {
+			continue
+		}
+		converted, err := base64ToBytes(value.Get(key))
+		if err != nil {
+			return errorResult(err)
+		}
+		value.Delete(key)
+		value.Set(name, converted)
+	}
+	return value
+}
+
+// base64ToBytes converts a base64 string, or an array or object of them.
+func base64ToBytes(value js.Value) (js.Value, error) {
+	switch {
+	case value.Type() == js.TypeString:
+		data, err := base64.StdEncoding.DecodeString(value.String())
+		if err != nil {
+			return js.Undefined(), err
+		}
+		return copyBytesToJS(data), nil
+	case js.Global().Get("Array").Call("isArray", value).Bool():
+		out := js.Global().Get("Array").New(value.Length())
+		for index := 0; index < value.Length(); index++ {
+			entry, err := base64ToBytes(value.Index(index))
+			if err != nil {
+				return js.Undefined(), err
+			}
+			out.SetIndex(index, entry)
+		}
+		return out, nil
+	case value.Type() == js.TypeObject:
+		out := js.Global().Get("Object").New()
+		keys := js.Global().Get("Object").Call("keys", value)
+		for index := 0; index < keys.Length(); index++ {
+			key := keys.Index(index).String()
+			entry, err := base64ToBytes(value.Get(key))
+			if err != nil {
+				return js.Undefined(), err
+			}
+			out.Set(key, entry)
+		}
+		return out, nil
+	}
+	return value, nil
+}
+
+// viewArrayBuffer wraps an ArrayBuffer in a Uint8Array and returns any other
+// value as it is.
+func viewArrayBuffer(value js.Value) js.Value {
+	if value.Type() == js.TypeObject && value.InstanceOf(js.Global().Get("ArrayBuffer")) {
+		return js.Global().Get("Uint8Array").New(value)
+	}
+	return value
+}
+
+func isUint8Array(value js.Value) bool {
+	return value.Type() == js.TypeObject && value.InstanceOf(js.Global().Get("Uint8Array"))
+}
+
+func copyBytesToGo(value js.Value) []byte {
+	data := make([]byte, value.Length())
+	js.CopyBytesToGo(data, value)
+	return data
+}
+
+func copyBytesToJS(data []byte) js.Value {
+	value := js.Global().Get("Uint8Array").New(len(data))
+	js.CopyBytesToJS(value, data)
+	return value
+}
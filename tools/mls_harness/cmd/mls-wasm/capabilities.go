@@ -0,0 +1,61 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// operations are the bindings this build registered, each with the globals it
+// set: the Promise, Bytes and sync forms for a setAsync binding, the name
+// alone for a setSync one. A TinyGo build registers fewer.
+var operations = map[string][]string{}
+
+// mlsCapabilities() returns {version, revision, go_version,
+// app_protocol_version, state_format_version, cipher_suites, operations,
+// mode} without waiting behind the queue, so a page feature-detects the
+// module it loaded instead of calling a global and catching the TypeError.
+// version and revision are the module version and VCS revision Go recorded
+// at build time, or empty where it recorded none. state_format_version is the
+// participant blob version the module writes; it reads every earlier one.
+// cipher_suites are the names dmCreateParticipant takes, the default first.
+// operations maps each binding to the globals it set.
+func mlsCapabilities(_ js.Value, _ []js.Value) interface{} {
+	version, revision := "", ""
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				revision = setting.Value
+			}
+		}
+	}
+	suites := make([]interface{}, 0, len(dm.CipherSuites))
+	for _, suite := range dm.CipherSuites {
+		suites = append(suites, suite.String())
+	}
+	ops := make(map[string]interface{}, len(operations))
+	for name, globals := range operations {
+		forms := make([]interface{}, len(globals))
+		for i, global := range globals {
+			forms[i] = global
+		}
+		ops[name] = forms
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":                   true,
+		"version":              version,
+		"revision":             revision,
+		"go_version":           runtime.Version(),
+		"app_protocol_version": dm.AppProtocolVersion,
+		"state_format_version": dm.StateFormatVersion,
+		"cipher_suites":        suites,
+		"operations":           ops,
+		"mode":                 moduleMode().String(),
+	})
+}
@@ -0,0 +1,62 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"fmt"
+	"syscall/js"
+)
+
+// Every dm binding's result is an envelope {ok, code, error, data}. On
+// success code and error are "" and data holds the binding's fields; on
+// failure data is null and code is the dm.ErrorCode of the error, or
+// unknownCode for one without a stable code, such as a missing argument. A
+// retry or repair decision reads code alone. The fields data holds are also
+// set on the envelope itself, as every binding returned them before the
+// envelope existed.
+const unknownCode = "unknown"
+
+// internalCode is the code of a binding that panicked. Input the module
+// fails to parse must not take the page's Go runtime down with it, so guard
+// turns the panic into this failure; the operation's changes to its
+// participant are lost with it, as for any other failure.
+const internalCode = "internal"
+
+// guard returns fn, failing with internalCode where fn panics.
+func guard(fn func(js.Value, []js.Value) interface{}) func(js.Value, []js.Value) interface{} {
+	return func(this js.Value, args []js.Value) (result interface{}) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = js.ValueOf(map[string]interface{}{"ok": false, "error": fmt.Sprintf("internal error: %v", r), "code": internalCode})
+			}
+		}()
+		return fn(this, args)
+	}
+}
+
+// envelope fills in the envelope fields of a binding's result.
+func envelope(result interface{}) interface{} {
+	value, ok := result.(js.Value)
+	if !ok || value.Type() != js.TypeObject {
+		return result
+	}
+	if !value.Get("ok").Truthy() {
+		if code := value.Get("code"); code.Type() != js.TypeString || code.String() == "" {
+			value.Set("code", unknownCode)
+		}
+		value.Set("data", js.Null())
+		return value
+	}
+	data := js.Global().Get("Object").New()
+	keys := js.Global().Get("Object").Call("keys", value)
+	for index := 0; index < keys.Length(); index++ {
+		if key := keys.Index(index).String(); key != "ok" {
+			data.Set(key, value.Get(key))
+		}
+	}
+	value.Set("code", "")
+	value.Set("error", "")
+	value.Set("data", data)
+	return value
+}
@@ -0,0 +1,89 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// Welcomes, commits, proposals and ciphertexts reach the module from the
+// network, so every base64 argument is checked before dm decodes it: its
+// length against a limit for its kind, in decoded bytes, and its encoding
+// against canonical padded base64, so two strings never stand for the same
+// bytes. A refused argument fails with dm.ErrInvalidInput. A Uint8Array is
+// checked against the same limit.
+const (
+	// maxStateBytes bounds a participant or a backup, which hold every group
+	// and the past epochs retention keeps.
+	maxStateBytes = 64 << 20
+	// maxMessageBytes bounds a Welcome, commit, proposal or ciphertext, the
+	// same bound serve puts on a request. Larger data goes through the
+	// stream bindings.
+	maxMessageBytes = 4 << 20
+	// maxKeyPackageBytes bounds a KeyPackage.
+	maxKeyPackageBytes = 64 << 10
+	// maxSmallBytes bounds every other base64 argument: group IDs, nonces,
+	// contexts and signatures.
+	maxSmallBytes = 4 << 10
+)
+
+// inputLimits are the limits by argument name; a name missing from it gets
+// maxSmallBytes.
+var inputLimits = map[string]int{
+	"participant_b64":     maxStateBytes,
+	"backup_b64":          maxStateBytes,
+	"welcome_b64":         maxMessageBytes,
+	"commit_b64":          maxMessageBytes,
+	"proposal_b64":        maxMessageBytes,
+	"ciphertext_b64":      maxMessageBytes,
+	"ciphertexts":         maxMessageBytes,
+	"envelope_b64":        maxMessageBytes,
+	"artifact_b64":        maxMessageBytes,
+	"keypackage_b64":      maxKeyPackageBytes,
+	"peer_keypackage_b64": maxKeyPackageBytes,
+	"peer_keypackages":    maxKeyPackageBytes,
+}
+
+func inputLimit(name string) int {
+	if limit, ok := inputLimits[name]; ok {
+		return limit
+	}
+	return maxSmallBytes
+}
+
+// checkBytesLen refuses n bytes of argument name over its limit.
+func checkBytesLen(name string, n int) error {
+	if limit := inputLimit(name); n > limit {
+		return fmt.Errorf("%w: %s is %d bytes, more than %d", dm.ErrInvalidInput, name, n, limit)
+	}
+	return nil
+}
+
+// checkBase64 refuses value, argument name, unless it is canonical padded
+// base64 within its limit. It decodes in blocks into a fixed buffer, so a
+// participant is not copied to be checked.
+func checkBase64(name, value string) error {
+	if limit := inputLimit(name); len(value) > base64.StdEncoding.EncodedLen(limit) {
+		return fmt.Errorf("%w: %s is %d base64 characters, more than %d bytes", dm.ErrInvalidInput, name, len(value), limit)
+	}
+	// The decoder skips line breaks and Strict only rejects stray bits, so
+	// both are checked here, and padding, which blocks would let through
+	// at the end of any of them.
+	if pad := strings.IndexByte(value, '='); len(value)%4 != 0 || strings.ContainsAny(value, "\r\n") || (pad >= 0 && pad < len(value)-2) {
+		return fmt.Errorf("%w: %s is not canonical base64", dm.ErrInvalidInput, name)
+	}
+	var buf [3 << 10]byte
+	const block = 4 << 10
+	for start := 0; start < len(value); start += block {
+		end := min(start+block, len(value))
+		if _, err := base64.StdEncoding.Strict().Decode(buf[:], []byte(value[start:end])); err != nil {
+			return fmt.Errorf("%w: %s is not canonical base64", dm.ErrInvalidInput, name)
+		}
+	}
+	return nil
+}
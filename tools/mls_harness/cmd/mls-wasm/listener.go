@@ -0,0 +1,137 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"sync"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// A state listener hears about every participant a binding returns, so a page
+// persists state in one place instead of at each call site.
+// registerStateListener(callback) has the module call callback(group_id_b64,
+// epoch, participant) once a dm or group binding succeeds with a participant,
+// before its Promise resolves. participant is participant_b64, or the
+// Uint8Array for a Bytes binding. The group is the one the operation named or
+// the message it decrypted came from, else the participant's only group; when
+// neither settles it, as for a new participant or a commit routed by its group
+// in a participant with several, group_id_b64 and epoch are null. Sessions
+// hand back their participant only from dmSessionSave and dmCloseSession, so
+// only those notify. Telling listeners costs one more decode of the
+// participant, paid only while a listener is registered. A listener that
+// throws does not fail the operation.
+var (
+	listenersMu  sync.Mutex
+	listeners    = map[int]js.Value{}
+	nextListener = 1
+
+	// opGroup is the group the running operation named. Operations run one
+	// at a time, so one variable serves them all.
+	opGroup string
+)
+
+// registerStateListener(callback) returns {listener}, the id to unregister
+// it with.
+func registerStateListener(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeFunction {
+		return errorResult(errors.New("callback must be a function"))
+	}
+	listenersMu.Lock()
+	id := nextListener
+	nextListener++
+	listeners[id] = args[0]
+	listenersMu.Unlock()
+	return js.ValueOf(map[string]interface{}{"ok": true, "listener": id})
+}
+
+// unregisterStateListener(listener) removes a listener; it returns removed,
+// false for an id that is not registered.
+func unregisterStateListener(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return errorResult(errors.New("listener must be a number"))
+	}
+	listenersMu.Lock()
+	_, removed := listeners[args[0].Int()]
+	delete(listeners, args[0].Int())
+	listenersMu.Unlock()
+	return js.ValueOf(map[string]interface{}{"ok": true, "removed": removed})
+}
+
+// noteGroup records the group the running operation named.
+func noteGroup(groupIDB64 string) {
+	opGroup = groupIDB64
+}
+
+// notifyState runs fn, converting its result for a Bytes binding, and tells
+// the listeners about the participant it returns.
+func notifyState(fn func(js.Value, []js.Value) interface{}, this js.Value, args []js.Value, bytes bool) interface{} {
+	convert := func(result interface{}) interface{} {
+		if bytes {
+			return bytesResult(result)
+		}
+		return result
+	}
+	opGroup = ""
+	result := fn(this, args)
+	value, ok := result.(js.Value)
+	if !ok || value.Type() != js.TypeObject || !value.Get("ok").Truthy() {
+		return convert(result)
+	}
+	participantB64 := value.Get("participant_b64")
+	if participantB64.Type() != js.TypeString {
+		return convert(result)
+	}
+	listenersMu.Lock()
+	callbacks := make([]js.Value, 0, len(listeners))
+	for _, callback := range listeners {
+		callbacks = append(callbacks, callback)
+	}
+	listenersMu.Unlock()
+	if len(callbacks) == 0 {
+		return convert(result)
+	}
+	groupID, epoch := stateGroup(participantB64.String(), value)
+	result = convert(result)
+	participant := participantB64
+	if bytes {
+		participant = result.(js.Value).Get("participant")
+	}
+	for _, callback := range callbacks {
+		invokeListener(callback, groupID, epoch, participant)
+	}
+	return result
+}
+
+// stateGroup returns the group and epoch to report for participantB64, or
+// null for both.
+func stateGroup(participantB64 string, result js.Value) (interface{}, interface{}) {
+	epochs, err := dm.GroupEpochs(participantB64)
+	if err != nil {
+		return nil, nil
+	}
+	groupID := opGroup
+	if message := result.Get("message"); groupID == "" && message.Type() == js.TypeObject && message.Get("group_id").Type() == js.TypeString {
+		groupID = message.Get("group_id").String()
+	}
+	if groupID == "" && len(epochs) == 1 {
+		for id := range epochs {
+			groupID = id
+		}
+	}
+	epoch, ok := epochs[groupID]
+	if !ok {
+		return nil, nil
+	}
+	return groupID, epoch
+}
+
+func invokeListener(callback js.Value, groupID, epoch, participant interface{}) {
+	defer func() {
+		recover()
+	}()
+	callback.Invoke(groupID, epoch, participant)
+}
@@ -4,7 +4,13 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
 	"syscall/js"
 
 	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
@@ -12,21 +18,101 @@ import (
 )
 
 func main() {
-	js.Global().Set("verifyVectors", js.FuncOf(verifyVectors))
-	js.Global().Set("dmCreateParticipant", js.FuncOf(dmCreateParticipant))
-	js.Global().Set("dmInit", js.FuncOf(dmInit))
-	js.Global().Set("groupInit", js.FuncOf(groupInit))
-	js.Global().Set("dmJoin", js.FuncOf(dmJoin))
-	js.Global().Set("dmCommitApply", js.FuncOf(dmCommitApply))
-	js.Global().Set("groupAdd", js.FuncOf(groupAdd))
-	js.Global().Set("dmEncrypt", js.FuncOf(dmEncrypt))
-	js.Global().Set("dmDecrypt", js.FuncOf(dmDecrypt))
+	go runQueue()
+	register()
 	select {}
 }
 
+// registerAll sets every binding, the set a standard Go build registers.
+func registerAll() {
+	setSync("mlsInit", mlsInit)
+	setSync("mlsPing", mlsPing)
+	setSync("mlsCapabilities", mlsCapabilities)
+	setSync("registerStateListener", registerStateListener)
+	setSync("unregisterStateListener", unregisterStateListener)
+	setSync("verifyVectors", verifyVectors)
+	setSync("generateVectors", generateVectors)
+	setSync("verifyWGVectors", verifyWGVectors)
+	setAsync("mlsBench", mlsBench)
+	setSync("mlsRecordTranscript", mlsRecordTranscript)
+	setSync("mlsExportTranscript", mlsExportTranscript)
+	setAsync("replayTranscript", replayTranscript)
+	setAsync("dmCreateParticipant", dmCreateParticipant)
+	setAsync("dmInit", dmInit)
+	setAsync("groupInit", groupInit)
+	setAsync("dmJoin", dmJoin)
+	setAsync("dmCommitApply", dmCommitApply)
+	setAsync("groupAdd", groupAdd)
+	setAsync("dmRemove", dmRemove)
+	setAsync("dmUpdate", dmUpdate)
+	setAsync("dmMaybeRotate", dmMaybeRotate)
+	setAsync("dmProposeAdd", dmProposeAdd)
+	setAsync("dmProposeRemove", dmProposeRemove)
+	setAsync("dmProposeUpdate", dmProposeUpdate)
+	setAsync("dmLeave", dmLeave)
+	setAsync("dmCommitPending", dmCommitPending)
+	setAsync("dmHandleProposal", dmHandleProposal)
+	setAsync("dmInfo", dmInfo)
+	setAsync("dmEpoch", dmEpoch)
+	setAsync("dmNextOutgoingGeneration", dmNextOutgoingGeneration)
+	setAsync("dmLastDecryptedGeneration", dmLastDecryptedGeneration)
+	setAsync("dmExportSecret", dmExportSecret)
+	setAsync("dmHistoryKey", dmHistoryKey)
+	setAsync("dmGroups", dmGroups)
+	setAsync("dmListGroups", dmListGroups)
+	setAsync("dmPendingCommits", dmPendingCommits)
+	setAsync("dmApplyPending", dmApplyPending)
+	setAsync("dmDiscardPending", dmDiscardPending)
+	setAsync("dmSetRetention", dmSetRetention)
+	setAsync("dmSetPadding", dmSetPadding)
+	setAsync("dmSetRotation", dmSetRotation)
+	setAsync("dmSetAdmins", dmSetAdmins)
+	setAsync("dmKeyPackagePool", dmKeyPackagePool)
+	setAsync("dmSplitWelcome", dmSplitWelcome)
+	setAsync("dmKeyPackageHash", dmKeyPackageHash)
+	setAsync("dmValidateKeyPackage", dmValidateKeyPackage)
+	setAsync("dmInspectArtifact", dmInspectArtifact)
+	setAsync("dmDeriveGroupID", dmDeriveGroupID)
+	setAsync("dmIdentityMessage", dmIdentityMessage)
+	setAsync("dmBindIdentity", dmBindIdentity)
+	setAsync("dmSetStateKey", dmSetStateKey)
+	setAsync("dmDestroy", dmDestroy)
+	setAsync("dmSetLegacySeededRand", dmSetLegacySeededRand)
+	setAsync("dmSetArtifactEnvelopes", dmSetArtifactEnvelopes)
+	setAsync("dmSealParticipant", dmSealParticipant)
+	setAsync("dmOpenParticipant", dmOpenParticipant)
+	setAsync("dmExportBackup", dmExportBackup)
+	setAsync("dmImportBackup", dmImportBackup)
+	setAsync("dmEncrypt", dmEncrypt)
+	setAsync("dmDecrypt", dmDecrypt)
+	setAsync("dmEncryptMessage", dmEncryptMessage)
+	setAsync("dmDecryptMessage", dmDecryptMessage)
+	setAsync("dmEncryptContent", dmEncryptContent)
+	setAsync("dmEncryptBatch", dmEncryptBatch)
+	setAsync("dmDecryptBatch", dmDecryptBatch)
+	setAsync("dmEncryptStream", dmEncryptStream)
+	setAsync("dmDecryptStream", dmDecryptStream)
+	setAsync("dmEncryptStreamBegin", dmEncryptStreamBegin)
+	setAsync("dmSealStreamChunk", dmSealStreamChunk)
+	setAsync("dmOpenStreamChunk", dmOpenStreamChunk)
+	setAsync("dmOpenSession", dmOpenSession)
+	setAsync("dmSessionEncrypt", dmSessionEncrypt)
+	setAsync("dmSessionEncryptMessage", dmSessionEncryptMessage)
+	setAsync("dmSessionDecrypt", dmSessionDecrypt)
+	setAsync("dmSessionEncryptBatch", dmSessionEncryptBatch)
+	setAsync("dmSessionDecryptBatch", dmSessionDecryptBatch)
+	setAsync("dmSessionCommitApply", dmSessionCommitApply)
+	setAsync("dmSessionInfo", dmSessionInfo)
+	setAsync("dmSessionSave", dmSessionSave)
+	setAsync("dmCloseSession", dmCloseSession)
+}
+
+// verifyVectors(vector_json) checks a vector file and returns {ok, digest},
+// with transcript_b64, the encoded transcript the digest covers, so another
+// implementation can recompute the digest from the same artifacts.
 func verifyVectors(_ js.Value, args []js.Value) interface{} {
 	if len(args) == 0 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "vector input is required"})
+		return errorResult(errors.New("vector input is required"))
 	}
 
 	input := args[0].String()
@@ -38,6 +124,11 @@ func verifyVectors(_ js.Value, args []js.Value) interface{} {
 
 	if result != nil {
 		response["digest"] = result.Digest
+		if result.Transcript != nil {
+			if transcript, merr := result.Transcript.Marshal(); merr == nil {
+				response["transcript_b64"] = base64.StdEncoding.EncodeToString(transcript)
+			}
+		}
 	}
 	if err != nil {
 		response["error"] = err.Error()
@@ -46,33 +137,91 @@ func verifyVectors(_ js.Value, args []js.Value) interface{} {
 	return js.ValueOf(response)
 }
 
+// generateVectors(name, iterations[, opts]) runs the scenario verifyVectors
+// checks and returns {vector_json, digest}: a vector file recording its
+// digest, in the layout of the committed ones, that verifyVectors accepts as it
+// is. opts, a JSON string or object, sets the other fields of a version 2
+// vector: {format_version, cipher_suite, participants, payload}.
+func generateVectors(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("name and iterations are required"))
+	}
+	name, err := readString(args[0], "name")
+	if err != nil {
+		return errorResult(err)
+	}
+	if args[1].Type() != js.TypeNumber || args[1].Float() != math.Trunc(args[1].Float()) {
+		return errorResult(errors.New("iterations must be an integer"))
+	}
+	template := harness.VectorSpec{Suite: dm.DefaultCipherSuite.String()}
+	if len(args) > 2 && !args[2].IsUndefined() && !args[2].IsNull() {
+		raw, err := readJSONInput(args[2], "opts")
+		if err != nil {
+			return errorResult(err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&template); err != nil {
+			return errorResult(fmt.Errorf("decode opts: %w", err))
+		}
+	}
+	template.Name, template.Iterations = name, args[1].Int()
+	spec, err := harness.GenerateVector(&template)
+	if err != nil {
+		return errorResult(err)
+	}
+	vectorJSON, err := json.MarshalIndent(spec, "", "  ")
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":          true,
+		"vector_json": string(vectorJSON),
+		"digest":      spec.DigestHex,
+	})
+}
+
+// dmCreateParticipant takes (name, seed_int) or (participant_b64, name,
+// seed_int[, cipher_suite]). A secure module takes (name) too.
 func dmCreateParticipant(_ js.Value, args []js.Value) interface{} {
-	if len(args) != 2 && len(args) != 3 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "expected (name, seed_int) or (participant_b64, name, seed_int)"})
+	if len(args) < 1 || len(args) > 4 {
+		return errorResult(errors.New("expected (name, seed_int) or (participant_b64, name, seed_int[, cipher_suite])"))
 	}
 	participantB64 := ""
 	nameValue := args[0]
-	seedValue := args[1]
-	if len(args) == 3 {
+	seedValue := js.Undefined()
+	if len(args) >= 2 {
+		seedValue = args[1]
+	}
+	if len(args) >= 3 {
 		var err error
-		participantB64, err = readString(args[0], "participant_b64")
+		participantB64, err = readBase64(args[0], "participant_b64")
 		if err != nil {
-			return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+			return errorResult(err)
 		}
 		nameValue = args[1]
 		seedValue = args[2]
 	}
 	name, err := readString(nameValue, "name")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	seedInt, err := readSeed(seedValue)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
+	}
+	suite, err := readCipherSuite(args, 3)
+	if err != nil {
+		return errorResult(err)
+	}
+	var keypackageB64 string
+	if participantB64 == "" {
+		participantB64, keypackageB64, err = dm.KeyPackageWithMode(participantB64, name, suite, moduleMode(), seedInt)
+	} else {
+		participantB64, keypackageB64, err = dm.KeyPackage(participantB64, name, suite, seedInt)
 	}
-	participantB64, keypackageB64, err := dm.KeyPackage(participantB64, name, seedInt)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
@@ -83,19 +232,33 @@ func dmCreateParticipant(_ js.Value, args []js.Value) interface{} {
 
 func dmInit(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 4 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant, peer keypackage, group_id, seed_int are required"})
+		return errorResult(errors.New("participant, peer keypackage, group_id, seed_int are required"))
 	}
-	participantB64 := args[0].String()
-	peerKeypackageB64 := args[1].String()
-	groupIDB64 := args[2].String()
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	peerKeypackageB64, err := readBase64(args[1], "peer_keypackage_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readBase64(args[2], "group_id_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	noteGroup(groupIDB64)
 	seedInt, err := readSeed(args[3])
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 
-	participantB64, welcomeB64, commitB64, err := dm.Init(participantB64, peerKeypackageB64, groupIDB64, seedInt)
+	suite, err := readCipherSuite(args, 4)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, welcomeB64, commitB64, err := dm.Init(participantB64, peerKeypackageB64, groupIDB64, suite, seedInt)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
@@ -107,31 +270,36 @@ func dmInit(_ js.Value, args []js.Value) interface{} {
 
 func groupInit(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 4 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant, peer_keypackages, group_id, seed_int are required"})
+		return errorResult(errors.New("participant, peer_keypackages, group_id, seed_int are required"))
 	}
-	participantB64, err := readString(args[0], "participant_b64")
+	participantB64, err := readBase64(args[0], "participant_b64")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
-	peerKeypackages, err := readStringArray(args[1], "peer_keypackages")
+	peerKeypackages, err := readBase64Array(args[1], "peer_keypackages")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	if len(peerKeypackages) < 2 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "peer_keypackages must include at least 2 entries"})
+		return errorResult(errors.New("peer_keypackages must include at least 2 entries"))
 	}
-	groupIDB64, err := readString(args[2], "group_id_b64")
+	groupIDB64, err := readBase64(args[2], "group_id_b64")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
+	noteGroup(groupIDB64)
 	seedInt, err := readSeed(args[3])
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 
-	participantB64, welcomeB64, commitB64, err := dm.InitMany(participantB64, peerKeypackages, groupIDB64, seedInt)
+	suite, err := readCipherSuite(args, 4)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
+	}
+	participantB64, welcomeB64, commitB64, err := dm.InitMany(participantB64, peerKeypackages, groupIDB64, suite, seedInt)
+	if err != nil {
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
@@ -143,13 +311,23 @@ func groupInit(_ js.Value, args []js.Value) interface{} {
 
 func dmJoin(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant and welcome are required"})
+		return errorResult(errors.New("participant and welcome are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	welcomeB64, err := readBase64(args[1], "welcome_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
 	}
-	participantB64 := args[0].String()
-	welcomeB64 := args[1].String()
-	participantB64, err := dm.Join(participantB64, welcomeB64)
+	participantB64, err = dm.Join(participantB64, groupIDB64, welcomeB64)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
@@ -159,117 +337,1411 @@ func dmJoin(_ js.Value, args []js.Value) interface{} {
 
 func dmCommitApply(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant and commit are required"})
+		return errorResult(errors.New("participant and commit are required"))
 	}
-	participantB64 := args[0].String()
-	commitB64 := args[1].String()
-	participantB64, noop, err := dm.CommitApply(participantB64, commitB64)
+	participantB64, err := readBase64(args[0], "participant_b64")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
+	}
+	commitB64, err := readBase64(args[1], "commit_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, result, err := dm.CommitApplyWithResult(participantB64, groupIDB64, commitB64)
+	if err != nil {
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
 		"participant_b64": participantB64,
-		"noop":            noop,
+		"noop":            result.Noop,
+		"already_applied": result.AlreadyApplied,
 	})
 }
 
 func groupAdd(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 3 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant, peer_keypackages, seed_int are required"})
+		return errorResult(errors.New("participant, peer_keypackages, seed_int are required"))
 	}
-	participantB64, err := readString(args[0], "participant_b64")
+	participantB64, err := readBase64(args[0], "participant_b64")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
-	peerKeypackages, err := readStringArray(args[1], "peer_keypackages")
+	peerKeypackages, err := readBase64Array(args[1], "peer_keypackages")
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	if len(peerKeypackages) < 1 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "peer_keypackages must include at least 1 entry"})
+		return errorResult(errors.New("peer_keypackages must include at least 1 entry"))
 	}
 	seedInt, err := readSeed(args[2])
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 
-	participantB64, welcomeB64, commitB64, proposalsB64, err := dm.AddMany(participantB64, peerKeypackages, seedInt)
+	groupIDB64, err := readGroupID(args, 3)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
-	if proposalsB64 == nil {
-		proposalsB64 = []string{}
+
+	participantB64, welcomeB64, commitB64, proposalsB64, err := dm.AddMany(participantB64, groupIDB64, peerKeypackages, seedInt)
+	if err != nil {
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":             true,
 		"participant_b64": participantB64,
 		"welcome_b64":    welcomeB64,
 		"commit_b64":     commitB64,
-		"proposals_b64":  proposalsB64,
+		"proposals_b64":  stringArray(proposalsB64),
 	})
 }
 
-func dmEncrypt(_ js.Value, args []js.Value) interface{} {
+func dmRemove(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errors.New("participant, member, seed_int are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	member, err := readMember(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[2])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	groupIDB64, err := readGroupID(args, 3)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	participantB64, commitB64, proposalsB64, err := dm.Remove(participantB64, groupIDB64, member, seedInt)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"commit_b64":      commitB64,
+		"proposals_b64":   stringArray(proposalsB64),
+	})
+}
+
+func dmUpdate(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant and plaintext are required"})
+		return errorResult(errors.New("participant and seed_int are required"))
 	}
-	participantB64 := args[0].String()
-	plaintext := args[1].String()
-	participantB64, ciphertextB64, err := dm.Encrypt(participantB64, plaintext)
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	participantB64, commitB64, proposalsB64, err := dm.Update(participantB64, groupIDB64, seedInt)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
 		"participant_b64": participantB64,
-		"ciphertext_b64":  ciphertextB64,
+		"commit_b64":      commitB64,
+		"proposals_b64":   stringArray(proposalsB64),
 	})
 }
 
-func dmDecrypt(_ js.Value, args []js.Value) interface{} {
+// dmMaybeRotate takes the arguments of dmUpdate and returns its result with
+// rotated set, or rotated unset and an empty commit when the rotation policy
+// has not triggered.
+func dmMaybeRotate(_ js.Value, args []js.Value) interface{} {
 	if len(args) < 2 {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": "participant and ciphertext are required"})
+		return errorResult(errors.New("participant and seed_int are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[1])
+	if err != nil {
+		return errorResult(err)
 	}
-	participantB64 := args[0].String()
-	ciphertextB64 := args[1].String()
-	participantB64, plaintext, err := dm.Decrypt(participantB64, ciphertextB64)
+	groupIDB64, err := readGroupID(args, 2)
 	if err != nil {
-		return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error()})
+		return errorResult(err)
+	}
+	participantB64, commitB64, proposalsB64, err := dm.MaybeRotate(participantB64, groupIDB64, seedInt)
+	if err != nil {
+		return errorResult(err)
 	}
 	return js.ValueOf(map[string]interface{}{
 		"ok":              true,
+		"rotated":         commitB64 != "",
 		"participant_b64": participantB64,
-		"plaintext":       plaintext,
+		"commit_b64":      commitB64,
+		"proposals_b64":   stringArray(proposalsB64),
 	})
 }
 
-func readSeed(value js.Value) (int64, error) {
-	if value.Type() != js.TypeNumber {
-		return 0, errors.New("seed_int must be a number")
+func dmProposeAdd(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and peer keypackage are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	peerKeypackageB64, err := readBase64(args[1], "peer_keypackage_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
 	}
-	return int64(value.Int()), nil
+	return proposalResult(dm.ProposeAdd(participantB64, groupIDB64, peerKeypackageB64))
 }
 
-func readString(value js.Value, name string) (string, error) {
-	if value.Type() != js.TypeString {
-		return "", errors.New(name + " must be a string")
+func dmProposeRemove(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and member are required"))
 	}
-	return value.String(), nil
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	member, err := readMember(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	return proposalResult(dm.ProposeRemove(participantB64, groupIDB64, member))
 }
 
-func readStringArray(value js.Value, name string) ([]string, error) {
-	if !js.Global().Get("Array").Call("isArray", value).Bool() {
-		return nil, errors.New(name + " must be an array")
+func dmProposeUpdate(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and seed_int are required"))
 	}
-	length := value.Length()
-	values := make([]string, 0, length)
-	for index := 0; index < length; index++ {
-		entry := value.Index(index)
-		if entry.Type() != js.TypeString {
-			return nil, errors.New(name + " must be an array of strings")
-		}
-		values = append(values, entry.String())
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	return proposalResult(dm.ProposeUpdate(participantB64, groupIDB64, seedInt))
+}
+
+func dmLeave(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and seed_int are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	return proposalResult(dm.Leave(participantB64, groupIDB64, seedInt))
+}
+
+func proposalResult(participantB64, proposalB64 string, err error) interface{} {
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"proposal_b64":    proposalB64,
+	})
+}
+
+func dmCommitPending(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and seed_int are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	seedInt, err := readSeed(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	participantB64, welcomeB64, commitB64, err := dm.CommitPending(participantB64, groupIDB64, seedInt)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"welcome_b64":     welcomeB64,
+		"commit_b64":      commitB64,
+	})
+}
+
+func dmHandleProposal(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and proposal are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	proposalB64, err := readBase64(args[1], "proposal_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	participantB64, noop, err := dm.HandleProposal(participantB64, groupIDB64, proposalB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"noop":            noop,
+	})
+}
+
+func dmInfo(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+	infoJSON, err := dm.Info(participantB64, groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	// Decoded JSON is all maps, slices, strings, float64s and bools, which
+	// js.ValueOf accepts, so the UI gets a plain object.
+	var info interface{}
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":   true,
+		"info": info,
+	})
+}
+
+// dmEpoch(participant_b64, group_id_b64?) returns {epoch}. It and the two
+// generation bindings below return no participant_b64; they change nothing.
+func dmEpoch(_ js.Value, args []js.Value) interface{} {
+	return sequenceBinding(args, func(participantB64, groupIDB64 string) (string, interface{}, error) {
+		epoch, err := dm.Epoch(participantB64, groupIDB64)
+		return "epoch", epoch, err
+	})
+}
+
+// dmNextOutgoingGeneration(participant_b64, group_id_b64?) returns
+// {generation}, the one the participant's next message will carry.
+func dmNextOutgoingGeneration(_ js.Value, args []js.Value) interface{} {
+	return sequenceBinding(args, func(participantB64, groupIDB64 string) (string, interface{}, error) {
+		generation, err := dm.NextOutgoingGeneration(participantB64, groupIDB64)
+		return "generation", generation, err
+	})
+}
+
+// dmLastDecryptedGeneration(participant_b64, group_id_b64?) returns
+// {senders: [{leaf, last, missing}]} for the current epoch.
+func dmLastDecryptedGeneration(_ js.Value, args []js.Value) interface{} {
+	return sequenceBinding(args, func(participantB64, groupIDB64 string) (string, interface{}, error) {
+		senders, err := dm.LastDecryptedGeneration(participantB64, groupIDB64)
+		return "senders", senders, err
+	})
+}
+
+func sequenceBinding(args []js.Value, run func(string, string) (string, interface{}, error)) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+	name, value, err := run(participantB64, groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	// A JSON round trip turns the Go values into ones js.ValueOf accepts.
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return errorResult(err)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(encoded, &plain); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok": true,
+		name: plain,
+	})
+}
+
+// dmExportSecret takes (participant_b64, label, context_b64, length,
+// group_id_b64) and returns {epoch, secret}, the current epoch and its
+// exporter output, so a page keys what it derives by the epoch it is bound
+// to. Like dmEpoch it only reads the participant.
+func dmExportSecret(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 4 {
+		return errorResult(errors.New("participant, label, context_b64 and length are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	label, err := readString(args[1], "label")
+	if err != nil {
+		return errorResult(err)
+	}
+	contextB64, err := readBase64(args[2], "context_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	if args[3].Type() != js.TypeNumber {
+		return errorResult(errors.New("length must be a number"))
+	}
+	groupIDB64, err := readGroupID(args, 4)
+	if err != nil {
+		return errorResult(err)
+	}
+	epoch, secret, err := dm.ExportSecret(participantB64, groupIDB64, label, contextB64, args[3].Int())
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":     true,
+		"epoch":  float64(epoch),
+		"secret": secret,
+	})
+}
+
+// dmHistoryKey takes (participant_b64, group_id_b64) and returns {epoch, key}
+// for message history handed to members who joined at the current epoch.
+func dmHistoryKey(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+	epoch, key, err := dm.HistoryKey(participantB64, groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":    true,
+		"epoch": float64(epoch),
+		"key":   key,
+	})
+}
+
+func dmGroups(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groups, err := dm.Groups(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":     true,
+		"groups": stringArray(groups),
+	})
+}
+
+// dmListGroups takes (participant_b64) and returns {groups}, one {group_id,
+// epoch, joined_epoch, pending, left} per group, sorted by group ID, so a page
+// keeps every conversation in one participant and passes each binding the
+// group it means.
+func dmListGroups(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	statuses, err := dm.ListGroups(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	groups, err := jsonValue(statuses)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":     true,
+		"groups": groups,
+	})
+}
+
+func dmPendingCommits(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+	pendingJSON, err := dm.PendingCommits(participantB64, groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	var pending interface{}
+	if err := json.Unmarshal([]byte(pendingJSON), &pending); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":      true,
+		"pending": pending,
+	})
+}
+
+// dmApplyPending and dmDiscardPending take (participant_b64, commit_hash,
+// group_id_b64); commit_hash may be "" for the oldest commit or, when
+// discarding, for all of them.
+func dmApplyPending(_ js.Value, args []js.Value) interface{} {
+	participantB64, commitHash, groupIDB64, err := readPendingArgs(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = dm.ApplyPending(participantB64, groupIDB64, commitHash)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+	})
+}
+
+func dmDiscardPending(_ js.Value, args []js.Value) interface{} {
+	participantB64, commitHash, groupIDB64, err := readPendingArgs(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, discarded, err := dm.DiscardPending(participantB64, groupIDB64, commitHash)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"discarded":       discarded,
+	})
+}
+
+func readPendingArgs(args []js.Value) (string, string, string, error) {
+	if len(args) < 2 {
+		return "", "", "", errors.New("participant and commit_hash are required")
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return "", "", "", err
+	}
+	commitHash, err := readString(args[1], "commit_hash")
+	if err != nil {
+		return "", "", "", err
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return "", "", "", err
+	}
+	return participantB64, commitHash, groupIDB64, nil
+}
+
+// dmSetRetention takes (participant_b64, {max_skipped_generations,
+// max_past_epochs}); an omitted field keeps its default.
+func dmSetRetention(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and policy are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	policy, err := readRetention(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = dm.SetRetention(participantB64, policy)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+	})
+}
+
+// dmSetRotation takes (participant_b64, {max_messages, max_epochs}); a
+// missing bound is off.
+func dmSetRotation(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and policy are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	policy, err := readRotation(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = dm.SetRotation(participantB64, policy)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+	})
+}
+
+// dmSetAdmins takes (participant_b64, admins, group_id_b64?), admins being the
+// credential identities allowed to add and remove members, and returns them
+// sorted. An empty array lets every member.
+func dmSetAdmins(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and admins are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	admins, err := readStringArray(args[1], "admins")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = dm.SetAdmins(participantB64, groupIDB64, admins)
+	if err != nil {
+		return errorResult(err)
+	}
+	set, err := dm.Admins(participantB64, groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"admins":          stringArray(set),
+	})
+}
+
+// dmKeyPackagePool takes (participant_b64, count, seed_int) and returns the new
+// one-time KeyPackages with the pool's available and consumed counts.
+func dmKeyPackagePool(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errors.New("participant, count, seed_int are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	if args[1].Type() != js.TypeNumber {
+		return errorResult(errors.New("count must be a number"))
+	}
+	seedInt, err := readSeed(args[2])
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, keypackages, err := dm.KeyPackagePool(participantB64, args[1].Int(), seedInt)
+	if err != nil {
+		return errorResult(err)
+	}
+	available, consumed, err := dm.PoolCounts(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"keypackages":     stringArray(keypackages),
+		"available":       available,
+		"consumed":        consumed,
+	})
+}
+
+// dmSplitWelcome returns {welcomes: {keypackage_hash_hex: welcome_b64}}.
+func dmSplitWelcome(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("welcome is required"))
+	}
+	welcomeB64, err := readBase64(args[0], "welcome_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	split, err := dm.SplitWelcome(welcomeB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	welcomes := map[string]interface{}{}
+	for hash, welcome := range split {
+		welcomes[hash] = welcome
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "welcomes": welcomes})
+}
+
+func dmKeyPackageHash(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("keypackage is required"))
+	}
+	kpB64, err := readBase64(args[0], "keypackage_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	hash, err := dm.KeyPackageHash(kpB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "keypackage_hash": hash})
+}
+
+// dmValidateKeyPackage returns {report} for a peer KeyPackage; ok is true
+// whenever it parses, and report.valid says whether every check passed.
+func dmValidateKeyPackage(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("keypackage is required"))
+	}
+	kpB64, err := readBase64(args[0], "keypackage_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	report, err := dm.ValidateKeyPackage(kpB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	encoded, err := json.Marshal(report)
+	if err != nil {
+		return errorResult(err)
+	}
+	var plain interface{}
+	if err := json.Unmarshal(encoded, &plain); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "report": plain})
+}
+
+// dmInspectArtifact returns the envelope of an artifact: {type, version,
+// group_id, epoch, sha256, size}.
+func dmInspectArtifact(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("artifact is required"))
+	}
+	artifactB64, err := readBase64(args[0], "artifact_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	info, err := dm.InspectArtifact(artifactB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":       true,
+		"type":     info.Type,
+		"version":  int(info.Version),
+		"group_id": info.GroupID,
+		"epoch":    float64(info.Epoch),
+		"sha256":   info.SHA256,
+		"size":     info.Size,
+	})
+}
+
+// dmDeriveGroupID(participant_b64, nonce_b64) returns {group_id_b64} for a
+// group the participant founds; the caller draws the nonce, 16 bytes or more.
+func dmDeriveGroupID(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and nonce are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	nonceB64, err := readBase64(args[1], "nonce_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := dm.DeriveGroupID(participantB64, nonceB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":           true,
+		"group_id_b64": groupIDB64,
+	})
+}
+
+func dmIdentityMessage(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	message, err := dm.IdentityBindingMessage(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "message_b64": message})
+}
+
+// dmBindIdentity takes (participant_b64, user_id, signature_b64), the
+// signature being the user key's over dmIdentityMessage.
+func dmBindIdentity(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errors.New("participant, user_id, signature_b64 are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	userID, err := readString(args[1], "user_id")
+	if err != nil {
+		return errorResult(err)
+	}
+	signatureB64, err := readBase64(args[2], "signature_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, keypackageB64, err := dm.BindIdentity(participantB64, userID, signatureB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "participant_b64": participantB64, "keypackage_b64": keypackageB64})
+}
+
+// dmSetPadding takes (participant_b64, {buckets: [256, 1024, 4096]}); an
+// empty list turns padding off.
+func dmSetPadding(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and policy are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	if args[1].Type() != js.TypeObject {
+		return errorResult(errors.New("policy must be an object"))
+	}
+	buckets, err := readUint32Array(args[1].Get("buckets"), "buckets")
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = dm.SetPadding(participantB64, dm.PaddingPolicy{Buckets: buckets})
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+	})
+}
+
+// dmSetStateKey makes every binding accept and return sealed participant_b64
+// values. Pass {key_b64} or {passphrase}, or null to stop sealing.
+func dmSetStateKey(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 || args[0].IsNull() || args[0].IsUndefined() {
+		if err := dm.SetStateKey(nil); err != nil {
+			return errorResult(err)
+		}
+		return js.ValueOf(map[string]interface{}{"ok": true})
+	}
+	key, err := readSealKey(args[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	if err := dm.SetStateKey(&key); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true})
+}
+
+// dmDestroy(participant_b64) returns a tombstone as participant_b64 for the
+// caller to store over the blob, and scrubs and forgets the state key set by
+// dmSetStateKey, so no blob sealed under it opens again in this module.
+func dmDestroy(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	tombstone, err := dm.Destroy(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": tombstone,
+	})
+}
+
+// dmSetLegacySeededRand(enabled) switches seeded bindings back to the
+// crypto/rand override the checked-in seeded vectors were recorded with.
+func dmSetLegacySeededRand(_ js.Value, args []js.Value) interface{} {
+	dm.SetLegacySeededRand(len(args) > 0 && args[0].Truthy())
+	return js.ValueOf(map[string]interface{}{"ok": true})
+}
+
+// dmSetArtifactEnvelopes(enabled) makes every binding return its artifacts in
+// envelopes; enveloped and bare inputs are accepted either way.
+func dmSetArtifactEnvelopes(_ js.Value, args []js.Value) interface{} {
+	dm.SetArtifactEnvelopes(len(args) > 0 && args[0].Truthy())
+	return js.ValueOf(map[string]interface{}{"ok": true})
+}
+
+func dmSealParticipant(_ js.Value, args []js.Value) interface{} {
+	return sealBinding(args, dm.SealParticipant)
+}
+
+func dmOpenParticipant(_ js.Value, args []js.Value) interface{} {
+	return sealBinding(args, dm.OpenParticipant)
+}
+
+// dmExportBackup(participant_b64, key) returns {backup_b64} for moving the
+// participant to another device or browser profile.
+func dmExportBackup(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and key are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	key, err := readSealKey(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	backupB64, err := dm.ExportBackup(participantB64, key)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":         true,
+		"backup_b64": backupB64,
+	})
+}
+
+// dmImportBackup(backup_b64, key) returns {participant_b64, groups}; the new
+// device should send dmUpdate in each group.
+func dmImportBackup(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("backup and key are required"))
+	}
+	backupB64, err := readBase64(args[0], "backup_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	key, err := readSealKey(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, groups, err := dm.ImportBackup(backupB64, key)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"groups":          stringArray(groups),
+	})
+}
+
+func sealBinding(args []js.Value, run func(string, dm.SealKey) (string, error)) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and key are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	key, err := readSealKey(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err = run(participantB64, key)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+	})
+}
+
+func dmEncrypt(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and plaintext are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	plaintext := args[1].String()
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, ciphertextB64, err := dm.Encrypt(participantB64, groupIDB64, plaintext)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"ciphertext_b64":  ciphertextB64,
+	})
+}
+
+func dmDecrypt(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and ciphertext are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertextB64, err := readBase64(args[1], "ciphertext_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, plaintext, err := dm.Decrypt(participantB64, groupIDB64, ciphertextB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"plaintext":       plaintext,
+	})
+}
+
+// dmEncryptMessage takes (participant_b64, {body, content_type, timestamp_ms,
+// padding}, group_id_b64) and sends a framed message; timestamp_ms defaults to
+// now and content_type to text/plain.
+func dmEncryptMessage(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and message are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	message, err := readMessage(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, ciphertextB64, err := dm.EncryptMessage(participantB64, groupIDB64, message)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"ciphertext_b64":  ciphertextB64,
+	})
+}
+
+// dmEncryptContent takes (participant_b64, {kind, text | receipt | typing |
+// control}, group_id_b64) and sends the typed content framed, stamped with
+// the current time. It returns the ciphertext and its message_id, which
+// receipts name it by.
+func dmEncryptContent(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and content are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	if args[1].Type() != js.TypeObject {
+		return errorResult(errors.New("content must be an object"))
+	}
+	var content dm.Content
+	if err := json.Unmarshal([]byte(js.Global().Get("JSON").Call("stringify", args[1]).String()), &content); err != nil {
+		return errorResult(errors.New("content: " + err.Error()))
+	}
+	message, err := dm.EncodeContent(content)
+	if err != nil {
+		return errorResult(err)
+	}
+	message.TimestampMs = uint64(dm.Now().UnixMilli())
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, ciphertextB64, err := dm.EncryptMessage(participantB64, groupIDB64, message)
+	if err != nil {
+		return errorResult(err)
+	}
+	messageID, err := dm.MessageID(ciphertextB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"ciphertext_b64":  ciphertextB64,
+		"message_id":      messageID,
+	})
+}
+
+// dmDecryptMessage returns the message with its metadata under message.
+func dmDecryptMessage(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and ciphertext are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertextB64, err := readBase64(args[1], "ciphertext_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, messageJSON, err := dm.DecryptMessage(participantB64, groupIDB64, ciphertextB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	var message interface{}
+	if err := json.Unmarshal([]byte(messageJSON), &message); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"message":         message,
+	})
+}
+
+// dmEncryptBatch(participant_b64, plaintexts, group_id_b64?) returns
+// {participant_b64, ciphertexts}, encoding the participant once for the lot.
+func dmEncryptBatch(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and plaintexts are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	plaintexts, err := readStringArray(args[1], "plaintexts")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, cts, err := dm.EncryptBatch(participantB64, groupIDB64, plaintexts)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"ciphertexts":     stringArray(cts),
+	})
+}
+
+// dmDecryptBatch(participant_b64, ciphertexts, group_id_b64?) returns
+// {participant_b64, messages}, one {message} or {error, code} per ciphertext.
+func dmDecryptBatch(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and ciphertexts are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertexts, err := readBase64Array(args[1], "ciphertexts")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, results, err := dm.DecryptBatch(participantB64, groupIDB64, ciphertexts)
+	if err != nil {
+		return errorResult(err)
+	}
+	encoded, err := json.Marshal(results)
+	if err != nil {
+		return errorResult(err)
+	}
+	var messages interface{}
+	if err := json.Unmarshal(encoded, &messages); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"messages":        messages,
+	})
+}
+
+func readString(value js.Value, name string) (string, error) {
+	if value.Type() != js.TypeString {
+		return "", errors.New(name + " must be a string")
+	}
+	return value.String(), nil
+}
+
+// readGroupID reads the optional trailing group_id_b64 argument; omitted,
+// null or "" means the participant's only group.
+func readGroupID(args []js.Value, index int) (string, error) {
+	if len(args) <= index || args[index].IsNull() || args[index].IsUndefined() {
+		return "", nil
+	}
+	groupIDB64, err := readBase64(args[index], "group_id_b64")
+	noteGroup(groupIDB64)
+	return groupIDB64, err
+}
+
+// readCipherSuite reads an optional trailing cipher suite name; empty means
+// the participant's suite.
+func readCipherSuite(args []js.Value, index int) (string, error) {
+	if len(args) <= index || args[index].IsNull() || args[index].IsUndefined() {
+		return "", nil
+	}
+	return readString(args[index], "cipher_suite")
+}
+
+// readSealKey reads {key_b64: "..."} or {passphrase: "..."}.
+func readSealKey(value js.Value) (dm.SealKey, error) {
+	if value.Type() != js.TypeObject {
+		return dm.SealKey{}, errors.New("key must be an object with key_b64 or passphrase")
+	}
+	var key dm.SealKey
+	if passphrase := value.Get("passphrase"); passphrase.Type() == js.TypeString {
+		key.Passphrase = passphrase.String()
+	}
+	if keyB64 := value.Get("key_b64"); keyB64.Type() == js.TypeString {
+		if err := checkBase64("key_b64", keyB64.String()); err != nil {
+			return dm.SealKey{}, err
+		}
+		raw, err := base64.StdEncoding.DecodeString(keyB64.String())
+		if err != nil {
+			return dm.SealKey{}, errors.New("key_b64 must be base64")
+		}
+		key.Key = raw
+	}
+	return key, nil
+}
+
+// readRetention reads {max_skipped_generations, max_past_epochs}.
+func readRetention(value js.Value) (dm.RetentionPolicy, error) {
+	if value.Type() != js.TypeObject {
+		return dm.RetentionPolicy{}, errors.New("policy must be an object")
+	}
+	policy := dm.DefaultRetention
+	for name, field := range map[string]*uint32{
+		"max_skipped_generations": &policy.MaxSkippedGenerations,
+		"max_past_epochs":         &policy.MaxPastEpochs,
+	} {
+		entry := value.Get(name)
+		if entry.IsUndefined() || entry.IsNull() {
+			continue
+		}
+		if entry.Type() != js.TypeNumber || entry.Int() < 0 {
+			return dm.RetentionPolicy{}, errors.New(name + " must be a non-negative number")
+		}
+		*field = uint32(entry.Int())
+	}
+	return policy, nil
+}
+
+func readRotation(value js.Value) (dm.RotationPolicy, error) {
+	if value.Type() != js.TypeObject {
+		return dm.RotationPolicy{}, errors.New("policy must be an object")
+	}
+	var policy dm.RotationPolicy
+	for name, field := range map[string]*uint32{
+		"max_messages": &policy.MaxMessages,
+		"max_epochs":   &policy.MaxEpochs,
+	} {
+		entry := value.Get(name)
+		if entry.IsUndefined() || entry.IsNull() {
+			continue
+		}
+		if entry.Type() != js.TypeNumber || entry.Int() < 0 {
+			return dm.RotationPolicy{}, errors.New(name + " must be a non-negative number")
+		}
+		*field = uint32(entry.Int())
+	}
+	return policy, nil
+}
+
+// readMessage reads {body, content_type, timestamp_ms, padding}; only body is
+// required.
+func readMessage(value js.Value) (dm.Message, error) {
+	if value.Type() != js.TypeObject {
+		return dm.Message{}, errors.New("message must be an object")
+	}
+	body, err := readString(value.Get("body"), "body")
+	if err != nil {
+		return dm.Message{}, err
+	}
+	message := dm.Message{Body: body, TimestampMs: uint64(dm.Now().UnixMilli())}
+	if contentType := value.Get("content_type"); !contentType.IsUndefined() && !contentType.IsNull() {
+		if message.ContentType, err = readString(contentType, "content_type"); err != nil {
+			return dm.Message{}, err
+		}
+	}
+	if timestamp := value.Get("timestamp_ms"); !timestamp.IsUndefined() && !timestamp.IsNull() {
+		if timestamp.Type() != js.TypeNumber || timestamp.Float() < 0 {
+			return dm.Message{}, errors.New("timestamp_ms must be a non-negative number")
+		}
+		message.TimestampMs = uint64(timestamp.Float())
+	}
+	if padding := value.Get("padding"); !padding.IsUndefined() && !padding.IsNull() {
+		if padding.Type() != js.TypeNumber {
+			return dm.Message{}, errors.New("padding must be a number")
+		}
+		message.Padding = padding.Int()
+	}
+	return message, nil
+}
+
+// errorResult reports a failure with its dm.ErrorCode, which JS callers branch
+// on instead of matching the message. Invalid arguments have the empty code.
+func errorResult(err error) interface{} {
+	return js.ValueOf(map[string]interface{}{"ok": false, "error": err.Error(), "code": dm.ErrorCode(err)})
+}
+
+// stringArray converts values for js.ValueOf, which accepts []interface{} but
+// panics on []string.
+func stringArray(values []string) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, value := range values {
+		out[i] = value
+	}
+	return out
+}
+
+// readMember accepts a leaf index as a number or a credential identity as a string.
+func readMember(value js.Value) (string, error) {
+	switch value.Type() {
+	case js.TypeNumber:
+		return strconv.Itoa(value.Int()), nil
+	case js.TypeString:
+		return value.String(), nil
+	default:
+		return "", errors.New("member must be a leaf index or a credential identity")
+	}
+}
+
+func readStringArray(value js.Value, name string) ([]string, error) {
+	if !js.Global().Get("Array").Call("isArray", value).Bool() {
+		return nil, errors.New(name + " must be an array")
+	}
+	length := value.Length()
+	values := make([]string, 0, length)
+	for index := 0; index < length; index++ {
+		entry := value.Index(index)
+		if entry.Type() != js.TypeString {
+			return nil, errors.New(name + " must be an array of strings")
+		}
+		values = append(values, entry.String())
+	}
+	return values, nil
+}
+
+func readUint32Array(value js.Value, name string) ([]uint32, error) {
+	if !js.Global().Get("Array").Call("isArray", value).Bool() {
+		return nil, errors.New(name + " must be an array")
+	}
+	length := value.Length()
+	values := make([]uint32, 0, length)
+	for index := 0; index < length; index++ {
+		entry := value.Index(index)
+		if entry.Type() != js.TypeNumber || entry.Float() < 0 || entry.Float() > math.MaxUint32 {
+			return nil, errors.New(name + " must be an array of non-negative numbers")
+		}
+		values = append(values, uint32(entry.Float()))
 	}
 	return values, nil
 }
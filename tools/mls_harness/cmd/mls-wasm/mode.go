@@ -0,0 +1,75 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// The module starts in deterministic mode, where dmCreateParticipant makes
+// seeded participants for vectors and tests. mlsInit({mode: "secure"}) turns
+// it into a module for real users: new participants are secure, drawing their
+// secrets from crypto/rand, which under js/wasm is the browser's
+// crypto.getRandomValues; every seed but 0 or an omitted one is refused; and
+// a deterministic participant is refused wherever it would draw secrets. There
+// is no way back to deterministic mode short of reloading the module.
+var secureModule atomic.Bool
+
+// mlsInit({mode}) sets the module mode, "deterministic" or "secure", and
+// returns it. Call it before any other binding.
+func mlsInit(_ js.Value, args []js.Value) interface{} {
+	name := "deterministic"
+	if len(args) > 0 && args[0].Type() == js.TypeObject {
+		if mode := args[0].Get("mode"); !mode.IsUndefined() && !mode.IsNull() {
+			var err error
+			if name, err = readString(mode, "mode"); err != nil {
+				return errorResult(err)
+			}
+		}
+	}
+	mode, err := dm.ParseMode(name)
+	if err != nil {
+		return errorResult(err)
+	}
+	if mode == dm.ModeDeterministic && secureModule.Load() {
+		return errorResult(errors.New("the module is in secure mode; reload it to leave"))
+	}
+	if mode == dm.ModeSecure {
+		secureModule.Store(true)
+		dm.SetSecureOnly(true)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "mode": mode.String()})
+}
+
+// moduleMode is the mode new participants are created in.
+func moduleMode() dm.Mode {
+	if secureModule.Load() {
+		return dm.ModeSecure
+	}
+	return dm.ModeDeterministic
+}
+
+func readSeed(value js.Value) (int64, error) {
+	if secureModule.Load() {
+		if value.IsUndefined() || value.IsNull() {
+			return 0, nil
+		}
+		if value.Type() != js.TypeNumber {
+			return 0, errors.New("seed_int must be a number")
+		}
+		if seed := int64(value.Int()); seed != 0 {
+			return 0, fmt.Errorf("%w (got seed %d)", dm.ErrSeedRefused, seed)
+		}
+		return 0, nil
+	}
+	if value.Type() != js.TypeNumber {
+		return 0, errors.New("seed_int must be a number")
+	}
+	return int64(value.Int()), nil
+}
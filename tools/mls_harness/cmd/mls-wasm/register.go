@@ -0,0 +1,9 @@
+//go:build js && wasm && !tinygo
+// +build js,wasm,!tinygo
+
+package main
+
+// register sets the bindings of the standard Go build, all of them.
+func register() {
+	registerAll()
+}
@@ -0,0 +1,22 @@
+//go:build js && wasm && tinygo
+// +build js,wasm,tinygo
+
+package main
+
+// A TinyGo build registers only what a page needs to hold a conversation:
+// the mode, creating a participant, starting a group, joining it, applying
+// its commit and encrypting and decrypting text. Everything registerAll sets
+// besides is left unreferenced, so TinyGo drops it, the vector runners with
+// it. The dm and harness packages leave out encoding/gob and net/http under
+// the tinygo tag, so gob participant blobs from before the versioned format
+// are refused rather than migrated.
+func register() {
+	setSync("mlsInit", mlsInit)
+	setSync("mlsCapabilities", mlsCapabilities)
+	setAsync("dmCreateParticipant", dmCreateParticipant)
+	setAsync("dmInit", dmInit)
+	setAsync("dmJoin", dmJoin)
+	setAsync("dmCommitApply", dmCommitApply)
+	setAsync("dmEncrypt", dmEncrypt)
+	setAsync("dmDecrypt", dmDecrypt)
+}
@@ -0,0 +1,289 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// A session is a participant decoded once and kept in Go memory behind a
+// numeric handle, a dm.Handle. Messages run against it without decoding and
+// encoding the participant each call, which dominates the cost of a busy
+// conversation; commits still round-trip it once. dmCloseSession returns the
+// participant to store and scrubs the held copy. A page that never closes a
+// session loses whatever happened since its last dmSessionSave.
+var (
+	sessionsMu  sync.Mutex
+	sessions    = map[int]*dm.Handle{}
+	nextSession = 1
+)
+
+// dmOpenSession(participant_b64) returns {session}.
+func dmOpenSession(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 1 {
+		return errorResult(errors.New("participant is required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	handle, err := dm.LoadHandle(participantB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	sessionsMu.Lock()
+	id := nextSession
+	nextSession++
+	sessions[id] = handle
+	sessionsMu.Unlock()
+	return js.ValueOf(map[string]interface{}{"ok": true, "session": id})
+}
+
+// dmSessionSave(session) returns the participant as participant_b64 and
+// keeps the session open.
+func dmSessionSave(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err := handle.Save()
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "participant_b64": participantB64})
+}
+
+// dmCloseSession(session) returns the participant as participant_b64,
+// scrubs the session's copy and forgets the handle.
+func dmCloseSession(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, err := handle.Save()
+	if err != nil {
+		return errorResult(err)
+	}
+	handle.Destroy()
+	sessionsMu.Lock()
+	delete(sessions, args[0].Int())
+	sessionsMu.Unlock()
+	return js.ValueOf(map[string]interface{}{"ok": true, "participant_b64": participantB64})
+}
+
+// dmSessionEncrypt(session, plaintext, group_id_b64?) returns ciphertext_b64.
+func dmSessionEncrypt(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and plaintext are required"))
+	}
+	plaintext, err := readString(args[1], "plaintext")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertextB64, err := handle.Encrypt(groupIDB64, plaintext)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "ciphertext_b64": ciphertextB64})
+}
+
+// dmSessionEncryptMessage(session, message, group_id_b64?) takes the message
+// dmEncryptMessage does and returns ciphertext_b64.
+func dmSessionEncryptMessage(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and message are required"))
+	}
+	message, err := readMessage(args[1])
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertextB64, err := handle.EncryptMessage(groupIDB64, message)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "ciphertext_b64": ciphertextB64})
+}
+
+// dmSessionDecrypt(session, ciphertext_b64, group_id_b64?) returns the body as
+// plaintext and the message as dmDecryptMessage does.
+func dmSessionDecrypt(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and ciphertext are required"))
+	}
+	ciphertextB64, err := readBase64(args[1], "ciphertext_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	received, err := handle.Decrypt(groupIDB64, ciphertextB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	message, err := jsonValue(received)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "plaintext": received.Body, "message": message})
+}
+
+// dmSessionDecryptBatch(session, ciphertexts, group_id_b64?) returns messages
+// as dmDecryptBatch does.
+func dmSessionDecryptBatch(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and ciphertexts are required"))
+	}
+	ciphertexts, err := readBase64Array(args[1], "ciphertexts")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	messages, err := jsonValue(handle.DecryptBatch(groupIDB64, ciphertexts))
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "messages": messages})
+}
+
+// dmSessionEncryptBatch(session, plaintexts, group_id_b64?) returns
+// ciphertexts, one per plaintext in order.
+func dmSessionEncryptBatch(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and plaintexts are required"))
+	}
+	plaintexts, err := readStringArray(args[1], "plaintexts")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	ciphertexts, err := handle.EncryptBatch(groupIDB64, plaintexts)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "ciphertexts": stringArray(ciphertexts)})
+}
+
+// dmSessionCommitApply(session, commit_b64, group_id_b64?) applies a commit
+// to the session and returns noop and already_applied as dmCommitApply does.
+func dmSessionCommitApply(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	if len(args) < 2 {
+		return errorResult(errors.New("session and commit are required"))
+	}
+	commitB64, err := readBase64(args[1], "commit_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	var result dm.ApplyResult
+	err = handle.Apply(func(participantB64 string) (string, error) {
+		var err error
+		participantB64, result, err = dm.CommitApplyWithResult(participantB64, groupIDB64, commitB64)
+		return participantB64, err
+	})
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"noop":            result.Noop,
+		"already_applied": result.AlreadyApplied,
+	})
+}
+
+// dmSessionInfo(session, group_id_b64?) returns info as dmInfo does.
+func dmSessionInfo(_ js.Value, args []js.Value) interface{} {
+	handle, err := readSession(args)
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 1)
+	if err != nil {
+		return errorResult(err)
+	}
+	infoJSON, err := handle.Info(groupIDB64)
+	if err != nil {
+		return errorResult(err)
+	}
+	var info interface{}
+	if err := json.Unmarshal([]byte(infoJSON), &info); err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "info": info})
+}
+
+// readSession reads the session handle in args[0].
+func readSession(args []js.Value) (*dm.Handle, error) {
+	if len(args) < 1 || args[0].Type() != js.TypeNumber {
+		return nil, errors.New("session must be a number")
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	handle, ok := sessions[args[0].Int()]
+	if !ok {
+		return nil, errors.New("unknown or closed session")
+	}
+	return handle, nil
+}
+
+// jsonValue converts v to the plain values js.ValueOf accepts through its
+// JSON form.
+func jsonValue(v interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(encoded, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
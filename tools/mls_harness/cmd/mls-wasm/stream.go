@@ -0,0 +1,219 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// Attachments go through dm's attachment encryption rather than one Protect:
+// the bindings return the small key envelope, an application message to send
+// like any other, separately from the sealed chunks, which a client uploads
+// wherever it keeps blobs. Attachment data is always a Uint8Array, or an
+// ArrayBuffer on the way in, never base64. dmEncryptStream and dmDecryptStream
+// take the whole buffer; a client that reads a file piece by piece calls
+// dmEncryptStreamBegin, then dmSealStreamChunk per chunk, and a receiver
+// passes the attachment from the envelope's content to dmOpenStreamChunk.
+
+// dmEncryptStream(participant_b64, data, group_id_b64?, chunk_size?) returns
+// {participant_b64, envelope_b64, ciphertext}, ciphertext being the sealed
+// chunks as one Uint8Array.
+func dmEncryptStream(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and data are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	data, err := readData(args[1], "data")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	chunkSize, err := readChunkSize(args, 3)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, envelopeB64, sealed, err := dm.EncryptAttachment(participantB64, groupIDB64, data, chunkSize)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"envelope_b64":    envelopeB64,
+		"ciphertext":      copyBytesToJS(sealed),
+	})
+}
+
+// dmDecryptStream(participant_b64, envelope_b64, ciphertext, group_id_b64?)
+// returns {participant_b64, plaintext, message}, message being the envelope as
+// dmDecryptMessage returns it.
+func dmDecryptStream(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 3 {
+		return errorResult(errors.New("participant, envelope and ciphertext are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	envelopeB64, err := readBase64(args[1], "envelope_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	sealed, err := readData(args[2], "ciphertext")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 3)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, data, message, err := dm.DecryptAttachment(participantB64, groupIDB64, envelopeB64, sealed)
+	if err != nil {
+		return errorResult(err)
+	}
+	messageValue, err := jsonValue(message)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"plaintext":       copyBytesToJS(data),
+		"message":         messageValue,
+	})
+}
+
+// dmEncryptStreamBegin(participant_b64, size, group_id_b64?, chunk_size?)
+// returns {participant_b64, envelope_b64, attachment, chunks}.
+func dmEncryptStreamBegin(_ js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return errorResult(errors.New("participant and size are required"))
+	}
+	participantB64, err := readBase64(args[0], "participant_b64")
+	if err != nil {
+		return errorResult(err)
+	}
+	size, err := readCount(args[1], "size")
+	if err != nil {
+		return errorResult(err)
+	}
+	groupIDB64, err := readGroupID(args, 2)
+	if err != nil {
+		return errorResult(err)
+	}
+	chunkSize, err := readChunkSize(args, 3)
+	if err != nil {
+		return errorResult(err)
+	}
+	participantB64, envelopeB64, attachment, err := dm.BeginAttachment(participantB64, groupIDB64, size, chunkSize)
+	if err != nil {
+		return errorResult(err)
+	}
+	attachmentValue, err := jsonValue(attachment)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"participant_b64": participantB64,
+		"envelope_b64":    envelopeB64,
+		"attachment":      attachmentValue,
+		"chunks":          float64(attachment.Chunks()),
+	})
+}
+
+// dmSealStreamChunk(attachment, index, chunk) returns {chunk}, sealed.
+func dmSealStreamChunk(_ js.Value, args []js.Value) interface{} {
+	return streamChunk(args, dm.SealAttachmentChunk)
+}
+
+// dmOpenStreamChunk(attachment, index, chunk) returns {chunk}, opened.
+func dmOpenStreamChunk(_ js.Value, args []js.Value) interface{} {
+	return streamChunk(args, dm.OpenAttachmentChunk)
+}
+
+func streamChunk(args []js.Value, run func(dm.Attachment, uint64, []byte) ([]byte, error)) interface{} {
+	if len(args) < 3 {
+		return errorResult(errors.New("attachment, index and chunk are required"))
+	}
+	attachment, err := readAttachment(args[0])
+	if err != nil {
+		return errorResult(err)
+	}
+	index, err := readCount(args[1], "index")
+	if err != nil {
+		return errorResult(err)
+	}
+	chunk, err := readData(args[2], "chunk")
+	if err != nil {
+		return errorResult(err)
+	}
+	out, err := run(attachment, index, chunk)
+	if err != nil {
+		return errorResult(err)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": true, "chunk": copyBytesToJS(out)})
+}
+
+// readData reads attachment bytes given as a Uint8Array or an ArrayBuffer.
+func readData(value js.Value, name string) ([]byte, error) {
+	value = viewArrayBuffer(value)
+	if !isUint8Array(value) {
+		return nil, errors.New(name + " must be a Uint8Array or an ArrayBuffer")
+	}
+	return copyBytesToGo(value), nil
+}
+
+// readChunkSize reads the optional chunk_size argument; omitted or null means
+// dm's default.
+func readChunkSize(args []js.Value, index int) (int, error) {
+	if len(args) <= index || args[index].IsNull() || args[index].IsUndefined() {
+		return 0, nil
+	}
+	chunkSize, err := readCount(args[index], "chunk_size")
+	if err != nil || chunkSize < 1 || chunkSize > dm.MaxAttachmentChunkSize {
+		return 0, fmt.Errorf("chunk_size must be an integer between 1 and %d", dm.MaxAttachmentChunkSize)
+	}
+	return int(chunkSize), nil
+}
+
+// readAttachment reads an attachment object as an envelope's content carries
+// it: {key, size, chunk_size}.
+func readAttachment(value js.Value) (dm.Attachment, error) {
+	if value.Type() != js.TypeObject {
+		return dm.Attachment{}, errors.New("attachment must be an object")
+	}
+	key, err := readString(value.Get("key"), "attachment key")
+	if err != nil {
+		return dm.Attachment{}, err
+	}
+	size, err := readCount(value.Get("size"), "attachment size")
+	if err != nil {
+		return dm.Attachment{}, err
+	}
+	chunkSize, err := readCount(value.Get("chunk_size"), "attachment chunk_size")
+	if err != nil || chunkSize > dm.MaxAttachmentChunkSize {
+		return dm.Attachment{}, fmt.Errorf("attachment chunk_size must be an integer up to %d", dm.MaxAttachmentChunkSize)
+	}
+	return dm.Attachment{Key: key, Size: size, ChunkSize: int(chunkSize)}, nil
+}
+
+// readCount reads a non-negative integer.
+func readCount(value js.Value, name string) (uint64, error) {
+	if value.Type() != js.TypeNumber || value.Float() < 0 || value.Float() != math.Trunc(value.Float()) {
+		return 0, errors.New(name + " must be a non-negative integer")
+	}
+	return uint64(value.Float()), nil
+}
@@ -0,0 +1,322 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// A transcript is the dm and group binding calls a page made, each with its
+// arguments and the outcome it got, so a user attaches the calls that led up
+// to a failure to a bug report and replayTranscript runs them again in the
+// same runtime. Every argument is recorded as passed, participant blobs
+// included, so each step replays on its own and the failing step reproduces
+// without the ones before it having to come out the same. Those blobs hold
+// the participant's secrets and the plaintexts are the user's messages, so
+// only a deterministic module records; a secure one refuses, and stops a
+// recording mlsInit switches it out of.
+//
+// A transcript is {version, mode, app_protocol_version, state_format_version,
+// steps}, each step {op, args, ok, code, error}. op is the global called, a
+// Bytes form included. An argument is JSON, with a Uint8Array or ArrayBuffer
+// written as {"$bytes": base64}. A transcript written by hand may instead
+// pass {"$step": n, "field": name} for the field of step n's result, so
+// participants chain from one step to the next.
+const transcriptVersion = 1
+
+// maxTranscriptSteps bounds a recording, which keeps the latest steps, those
+// nearest the failure.
+const maxTranscriptSteps = 1000
+
+type transcript struct {
+	Version            int              `json:"version"`
+	Mode               string           `json:"mode"`
+	AppProtocolVersion int              `json:"app_protocol_version"`
+	StateFormatVersion int              `json:"state_format_version"`
+	Steps              []transcriptStep `json:"steps"`
+}
+
+type transcriptStep struct {
+	Op    string        `json:"op"`
+	Args  []interface{} `json:"args"`
+	OK    bool          `json:"ok"`
+	Code  string        `json:"code,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+var (
+	transcriptMu sync.Mutex
+	recording    bool
+	recorded     []transcriptStep
+
+	// asyncBindings are the setAsync bindings by name, which a replay calls
+	// directly, so it neither waits behind the queue nor tells the state
+	// listeners about participants it makes.
+	asyncBindings = map[string]func(js.Value, []js.Value) interface{}{}
+)
+
+// mlsRecordTranscript(enabled) starts a new recording, dropping the last one,
+// or stops the current one, keeping its steps for mlsExportTranscript. It
+// returns {recording}.
+func mlsRecordTranscript(_ js.Value, args []js.Value) interface{} {
+	enabled := len(args) > 0 && args[0].Truthy()
+	if enabled && moduleMode() == dm.ModeSecure {
+		return errorResult(errors.New("a secure module does not record transcripts: they hold participant secrets"))
+	}
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+	if enabled {
+		recorded = nil
+	}
+	recording = enabled
+	return js.ValueOf(map[string]interface{}{"ok": true, "recording": recording})
+}
+
+// mlsExportTranscript() returns {transcript_json, steps}, the current or last
+// recording.
+func mlsExportTranscript(_ js.Value, _ []js.Value) interface{} {
+	transcriptMu.Lock()
+	steps := append([]transcriptStep{}, recorded...)
+	transcriptMu.Unlock()
+	out, err := json.Marshal(transcript{
+		Version:            transcriptVersion,
+		Mode:               moduleMode().String(),
+		AppProtocolVersion: dm.AppProtocolVersion,
+		StateFormatVersion: dm.StateFormatVersion,
+		Steps:              steps,
+	})
+	if err != nil {
+		return errorResult(fmt.Errorf("encode transcript: %w", err))
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":              true,
+		"transcript_json": string(out),
+		"steps":           len(steps),
+	})
+}
+
+// recordStep adds the call op(args) and its result to the recording, if one
+// runs, and returns result.
+func recordStep(op string, args []js.Value, result interface{}) interface{} {
+	if !strings.HasPrefix(op, "dm") && !strings.HasPrefix(op, "group") {
+		return result
+	}
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+	if !recording {
+		return result
+	}
+	if moduleMode() == dm.ModeSecure {
+		recording = false
+		return result
+	}
+	step := transcriptStep{Op: op, Args: make([]interface{}, len(args))}
+	for i, arg := range args {
+		step.Args[i] = encodeArg(arg)
+	}
+	if value, ok := result.(js.Value); ok && value.Type() == js.TypeObject {
+		step.OK = value.Get("ok").Truthy()
+		if !step.OK {
+			step.Code = stringField(value, "code")
+			step.Error = stringField(value, "error")
+		}
+	}
+	if len(recorded) == maxTranscriptSteps {
+		recorded = append(recorded[:0], recorded[1:]...)
+	}
+	recorded = append(recorded, step)
+	return result
+}
+
+// replayTranscript(transcript) runs every step of a transcript, given as JSON
+// or an object, in this module, which must be in the mode it was recorded in.
+// It returns {steps, results, failed_step, diverged_step, recorded_failure,
+// reproduced}: results holds {step, op, ok, code, error} per step;
+// failed_step is the first step that failed now, diverged_step the first
+// whose ok or code differs from the recording, recorded_failure the first
+// step the recording has failing, each null where there is none; reproduced
+// is whether the recorded failure failed again, with the same code.
+func replayTranscript(_ js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return errorResult(errors.New("transcript is required"))
+	}
+	raw, err := readJSONInput(args[0], "transcript")
+	if err != nil {
+		return errorResult(err)
+	}
+	var script transcript
+	if err := json.Unmarshal(raw, &script); err != nil {
+		return errorResult(fmt.Errorf("decode transcript: %w", err))
+	}
+	if script.Version != transcriptVersion {
+		return errorResult(fmt.Errorf("unsupported transcript version %d", script.Version))
+	}
+	if mode := moduleMode().String(); script.Mode != mode {
+		return errorResult(fmt.Errorf("transcript was recorded in %s mode but the module is in %s mode", script.Mode, mode))
+	}
+
+	var failed, diverged, recordedFailure interface{}
+	results := make([]js.Value, 0, len(script.Steps))
+	report := make([]interface{}, 0, len(script.Steps))
+	for i, step := range script.Steps {
+		fn, ok := asyncBindings[strings.TrimSuffix(step.Op, bytesSuffix)]
+		if !ok {
+			return errorResult(fmt.Errorf("step %d: unknown operation %q", i, step.Op))
+		}
+		callArgs := make([]js.Value, len(step.Args))
+		for j, arg := range step.Args {
+			if callArgs[j], err = decodeArg(arg, results); err != nil {
+				return errorResult(fmt.Errorf("step %d: argument %d: %w", i, j, err))
+			}
+		}
+		result := fn(js.Undefined(), callArgs)
+		if strings.HasSuffix(step.Op, bytesSuffix) {
+			result = bytesResult(result)
+		}
+		value, _ := envelope(result).(js.Value)
+		results = append(results, value)
+
+		stepOK := value.Type() == js.TypeObject && value.Get("ok").Truthy()
+		code, message := "", ""
+		if !stepOK {
+			code, message = stringField(value, "code"), stringField(value, "error")
+		}
+		report = append(report, map[string]interface{}{"step": i, "op": step.Op, "ok": stepOK, "code": code, "error": message})
+		if !stepOK && failed == nil {
+			failed = i
+		}
+		if (stepOK != step.OK || code != step.Code) && diverged == nil {
+			diverged = i
+		}
+		if !step.OK && recordedFailure == nil {
+			recordedFailure = i
+		}
+	}
+	reproduced := false
+	if index, ok := recordedFailure.(int); ok {
+		again := report[index].(map[string]interface{})
+		reproduced = !again["ok"].(bool) && again["code"] == script.Steps[index].Code
+	}
+	return js.ValueOf(map[string]interface{}{
+		"ok":               true,
+		"steps":            len(script.Steps),
+		"results":          report,
+		"failed_step":      failed,
+		"diverged_step":    diverged,
+		"recorded_failure": recordedFailure,
+		"reproduced":       reproduced,
+	})
+}
+
+// encodeArg writes a binding argument as transcript JSON.
+func encodeArg(value js.Value) interface{} {
+	value = viewArrayBuffer(value)
+	if isUint8Array(value) {
+		return map[string]interface{}{"$bytes": base64.StdEncoding.EncodeToString(copyBytesToGo(value))}
+	}
+	switch value.Type() {
+	case js.TypeString:
+		return value.String()
+	case js.TypeNumber:
+		return value.Float()
+	case js.TypeBoolean:
+		return value.Bool()
+	case js.TypeObject:
+		if js.Global().Get("Array").Call("isArray", value).Bool() {
+			entries := make([]interface{}, value.Length())
+			for i := range entries {
+				entries[i] = encodeArg(value.Index(i))
+			}
+			return entries
+		}
+		fields := map[string]interface{}{}
+		keys := js.Global().Get("Object").Call("keys", value)
+		for i := 0; i < keys.Length(); i++ {
+			key := keys.Index(i).String()
+			fields[key] = encodeArg(value.Get(key))
+		}
+		return fields
+	}
+	return nil
+}
+
+// decodeArg turns transcript JSON back into a binding argument, looking
+// {"$step"} references up in the results of the steps before.
+func decodeArg(arg interface{}, results []js.Value) (js.Value, error) {
+	switch arg := arg.(type) {
+	case nil:
+		return js.Null(), nil
+	case string, float64, bool:
+		return js.ValueOf(arg), nil
+	case []interface{}:
+		array := js.Global().Get("Array").New(len(arg))
+		for i, entry := range arg {
+			value, err := decodeArg(entry, results)
+			if err != nil {
+				return js.Value{}, err
+			}
+			array.SetIndex(i, value)
+		}
+		return array, nil
+	case map[string]interface{}:
+		if encoded, ok := arg["$bytes"].(string); ok {
+			data, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return js.Value{}, errors.New("$bytes must be base64")
+			}
+			return copyBytesToJS(data), nil
+		}
+		if step, ok := arg["$step"].(float64); ok {
+			return stepField(results, int(step), arg["field"])
+		}
+		object := js.Global().Get("Object").New()
+		for key, entry := range arg {
+			value, err := decodeArg(entry, results)
+			if err != nil {
+				return js.Value{}, err
+			}
+			object.Set(key, value)
+		}
+		return object, nil
+	}
+	return js.Value{}, fmt.Errorf("unsupported argument %v", arg)
+}
+
+// stepField returns field of the result of an earlier, successful step.
+func stepField(results []js.Value, step int, field interface{}) (js.Value, error) {
+	name, ok := field.(string)
+	if !ok || name == "" {
+		return js.Value{}, errors.New("$step needs a field")
+	}
+	if step < 0 || step >= len(results) {
+		return js.Value{}, fmt.Errorf("$step %d is not an earlier step", step)
+	}
+	result := results[step]
+	if result.Type() != js.TypeObject || !result.Get("ok").Truthy() {
+		return js.Value{}, fmt.Errorf("step %d failed, so has no %s", step, name)
+	}
+	value := result.Get(name)
+	if value.IsUndefined() {
+		return js.Value{}, fmt.Errorf("step %d has no %s", step, name)
+	}
+	return value, nil
+}
+
+func stringField(value js.Value, name string) string {
+	if value.Type() != js.TypeObject {
+		return ""
+	}
+	if field := value.Get(name); field.Type() == js.TypeString {
+		return field.String()
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// wgChecks are the MLSWG files verifyWGVectors runs, keyed as its input and
+// result name them, in the order the CLI's wg-vectors runs them.
+var wgChecks = []struct {
+	key    string
+	label  string
+	verify func([]byte) (string, error)
+}{
+	{"crypto_basics", "crypto-basics", harness.VerifyCryptoBasics},
+	{"tree_math", "tree-math", harness.VerifyTreeMath},
+}
+
+// verifyWGVectors(input) runs the MLSWG crypto-basics and tree-math checks
+// the CLI's wg-vectors runs, so a page proves the module's crypto matches the
+// native build's. input is a JSON string or an object: either one vector file,
+// told apart by its vectors, or {crypto_basics, tree_math} holding a file
+// each, as a string or an object. It returns {ok, results}, results holding
+// the wg-vectors line for each file it ran, such as "PASS (4 cases)".
+func verifyWGVectors(_ js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return errorResult(errors.New("vector input is required"))
+	}
+	raw, err := readJSONInput(args[0], "vector input")
+	if err != nil {
+		return errorResult(err)
+	}
+	files, err := wgFiles(raw)
+	if err != nil {
+		return errorResult(err)
+	}
+	ok := true
+	results := map[string]interface{}{}
+	for _, check := range wgChecks {
+		file, present := files[check.key]
+		if !present {
+			continue
+		}
+		summary, err := check.verify(file)
+		if err != nil {
+			ok = false
+			results[check.key] = fmt.Sprintf("FAIL (%v)", err)
+			continue
+		}
+		results[check.key] = fmt.Sprintf("PASS (%s)", summary)
+	}
+	return js.ValueOf(map[string]interface{}{"ok": ok, "results": results})
+}
+
+// wgFiles splits input into the vector files it holds, keyed by check.
+func wgFiles(raw []byte) (map[string][]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, fmt.Errorf("decode vector input: %w", err)
+	}
+	if _, single := fields["vectors"]; single {
+		key, err := wgKind(raw)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{key: raw}, nil
+	}
+	files := map[string][]byte{}
+	for _, check := range wgChecks {
+		field, present := fields[check.key]
+		if !present {
+			continue
+		}
+		// A file given as a string arrives as a JSON string of its JSON.
+		var text string
+		if json.Unmarshal(field, &text) == nil {
+			field = []byte(text)
+		}
+		files[check.key] = field
+	}
+	if len(files) == 0 {
+		return nil, errors.New("vector input must be a vector file or hold crypto_basics or tree_math")
+	}
+	return files, nil
+}
+
+// wgKind tells a crypto-basics file, whose vectors name a cipher suite, from
+// a tree-math one, whose vectors name a leaf count.
+func wgKind(raw []byte) (string, error) {
+	var file struct {
+		Vectors []map[string]json.RawMessage `json:"vectors"`
+	}
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return "", fmt.Errorf("decode vector file: %w", err)
+	}
+	if len(file.Vectors) > 0 {
+		if _, ok := file.Vectors[0]["cipher_suite"]; ok {
+			return "crypto_basics", nil
+		}
+		if _, ok := file.Vectors[0]["leaf_count"]; ok {
+			return "tree_math", nil
+		}
+	}
+	return "", errors.New("vector file is neither crypto-basics nor tree-math")
+}
+
+// readJSONInput reads a JSON string, or an object to serialize with
+// JSON.stringify.
+func readJSONInput(value js.Value, name string) ([]byte, error) {
+	switch value.Type() {
+	case js.TypeString:
+		return []byte(value.String()), nil
+	case js.TypeObject:
+		return []byte(js.Global().Get("JSON").Call("stringify", value).String()), nil
+	}
+	return nil, errors.New(name + " must be a JSON string or an object")
+}
@@ -0,0 +1,39 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/dm"
+)
+
+// The module registers its bindings on globalThis, which is self in a Web
+// Worker, and needs nothing from window or the DOM; setTimeout, which the
+// Promise queue yields through, and crypto.getRandomValues exist in workers
+// too. clients/web/mls_worker.js runs it in a worker and relays calls by
+// name. Every Uint8Array a Bytes binding returns owns its whole ArrayBuffer,
+// so a worker can transfer it to the page instead of copying, and arguments
+// taken as Uint8Array also accept the ArrayBuffer a transfer delivers.
+
+// mlsPing() returns {mode, app_protocol_version, sessions, queued, worker}
+// without waiting behind the queue: the module mode, the dm application
+// protocol version, how many sessions are open, how many Promise operations
+// wait to start, and whether the module runs in a worker. A page uses it to
+// check a worker it started is alive and ready before sending it work.
+func mlsPing(_ js.Value, _ []js.Value) interface{} {
+	sessionsMu.Lock()
+	open := len(sessions)
+	sessionsMu.Unlock()
+	scope := js.Global().Get("WorkerGlobalScope")
+	worker := scope.Type() == js.TypeFunction && js.Global().InstanceOf(scope)
+	return js.ValueOf(map[string]interface{}{
+		"ok":                   true,
+		"mode":                 moduleMode().String(),
+		"app_protocol_version": dm.AppProtocolVersion,
+		"sessions":             open,
+		"queued":               queued(),
+		"worker":               worker,
+	})
+}
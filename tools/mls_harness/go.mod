@@ -2,13 +2,15 @@ module github.com/polycentric/fictional-octo-umbrella/tools/mls_harness
 
 go 1.22
 
-require github.com/cisco/go-mls v0.0.0-20210331162924-158a3829b839
+require (
+	github.com/cisco/go-mls v0.0.0-20210331162924-158a3829b839
+	github.com/cisco/go-tls-syntax v0.0.0-20200615170901-cc95af012391
+	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9
+)
 
 require (
 	git.schwanenlied.me/yawning/x448.git v0.0.0-20170617130356-01b048fb03d6 // indirect
 	github.com/cisco/go-hpke v0.0.0-20200603153819-0a6c8374cd9a // indirect
-	github.com/cisco/go-tls-syntax v0.0.0-20200615170901-cc95af012391 // indirect
 	github.com/cloudflare/circl v1.0.0 // indirect
-	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9 // indirect
 	golang.org/x/sys v0.0.0-20190602015325-4c4f7f33c9ed // indirect
 )
@@ -0,0 +1,123 @@
+package dm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// MLS has no roles, and the vendored go-mls cannot carry group metadata in the
+// group context, so the admins of a group are a policy each member sets for
+// itself. Members given the same list accept and reject the same proposals,
+// and a commit that covers a rejected one fails for all of them alike.
+
+// ErrNotAdmin is an Add or Remove proposed by a member the group's admin list
+// does not name.
+var ErrNotAdmin = errors.New("proposer is not a group admin")
+
+// SetAdmins replaces the admins of one group, the credential identities whose
+// Add and Remove proposals the participant accepts. With none, as every group
+// starts, any member may add and remove. A member may always propose removing
+// itself. Proposals already queued are checked again when a commit covers
+// them.
+func SetAdmins(participant_b64, group_id_b64 string, admins []string) (string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", err
+	}
+	defer Zeroize(participant)
+	seen := map[string]bool{}
+	session.Admins = nil
+	for _, admin := range admins {
+		if admin == "" {
+			return "", errors.New("admin identity is empty")
+		}
+		if !seen[admin] {
+			seen[admin] = true
+			session.Admins = append(session.Admins, admin)
+		}
+	}
+	sort.Strings(session.Admins)
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, nil
+}
+
+// Admins returns the group's admins, sorted.
+func Admins(participant_b64, group_id_b64 string) ([]string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(participant)
+	return append([]string{}, session.Admins...), nil
+}
+
+// check_admin fails unless the group has no admins or the credential at leaf
+// is one of them.
+func check_admin(session *Session, state *mls.State, leaf mls.LeafIndex) error {
+	if len(session.Admins) == 0 {
+		return nil
+	}
+	kp, ok := state.Tree.KeyPackage(leaf)
+	if !ok {
+		return fmt.Errorf("%w: leaf %d is blank", ErrNotAdmin, leaf)
+	}
+	identity := string(kp.Credential.Identity())
+	for _, admin := range session.Admins {
+		if admin == identity {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %q", ErrNotAdmin, identity)
+}
+
+// check_proposal_admin applies the admin list to one proposal in state's
+// epoch. Updates and self-removals need no admin.
+func check_proposal_admin(session *Session, state *mls.State, proposal_pt *mls.MLSPlaintext) error {
+	proposal := proposal_pt.Content.Proposal
+	if proposal == nil || proposal_pt.Sender.Type != mls.SenderTypeMember {
+		return nil
+	}
+	sender := mls.LeafIndex(proposal_pt.Sender.Sender)
+	switch {
+	case proposal.Add != nil:
+	case proposal.Remove != nil && proposal.Remove.Removed != sender:
+	default:
+		return nil
+	}
+	return check_admin(session, state, sender)
+}
+
+// check_commit_admins applies the admin list to every queued Add and Remove
+// the commit covers, whoever sends the commit.
+func check_commit_admins(session *Session, state *mls.State, commit_pt *mls.MLSPlaintext) error {
+	if len(session.Admins) == 0 || commit_pt.Content.Commit == nil {
+		return nil
+	}
+	commit := commit_pt.Content.Commit.Commit
+	covered := append(append([]mls.ProposalID{}, commit.Adds...), commit.Removes...)
+	for i := range state.PendingProposals {
+		pending := &state.PendingProposals[i]
+		data, err := syntax.Marshal(*pending)
+		if err != nil {
+			return fmt.Errorf("marshal proposal: %w", err)
+		}
+		id := state.CipherSuite.Digest(data)
+		for _, ref := range covered {
+			if !bytes.Equal(ref.Hash, id) {
+				continue
+			}
+			if err := check_proposal_admin(session, state, pending); err != nil {
+				return fmt.Errorf("commit: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package dm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// An artifact envelope frames a KeyPackage, Welcome, proposal, commit or
+// application message so the delivery layer can route it, and a debugging
+// tool check it, without parsing MLS:
+//
+//	magic "\x00MLA" | version uint8 | type uint8 | epoch uint64 | group_id_len uint8 | group_id | sha256[32] | body
+//
+// The group ID and epoch are hints copied from the body (empty and 0 for a
+// KeyPackage, the new epoch for a Welcome); the SHA-256 covers the body only.
+// The tag catches truncation and mixed-up blobs, not forgery: anyone can
+// rewrite an envelope, and MLS still authenticates what is inside. A bare
+// KeyPackage or Welcome opens with two zero bytes, its protocol version and the
+// high byte of its suite, and a bare message with the non-zero length of its
+// group ID, so none starts with the magic.
+const (
+	artifact_magic      = "\x00MLA"
+	artifact_version_v1 = 1
+	artifact_header_len = len(artifact_magic) + 2 + 8 + 1
+)
+
+type artifact_type uint8
+
+const (
+	artifact_keypackage artifact_type = 1
+	artifact_welcome    artifact_type = 2
+	artifact_proposal   artifact_type = 3
+	artifact_commit     artifact_type = 4
+	artifact_ciphertext artifact_type = 5
+)
+
+var artifact_names = map[artifact_type]string{
+	artifact_keypackage: "keypackage",
+	artifact_welcome:    "welcome",
+	artifact_proposal:   "proposal",
+	artifact_commit:     "commit",
+	artifact_ciphertext: "ciphertext",
+}
+
+func (kind artifact_type) String() string {
+	if name, ok := artifact_names[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("artifact(%d)", uint8(kind))
+}
+
+// ErrBadArtifact is an artifact envelope that is malformed, of the wrong type,
+// fails its SHA-256 or whose hints disagree with its body.
+var ErrBadArtifact = errors.New("bad artifact envelope")
+
+var artifact_envelopes atomic.Bool
+
+// SetArtifactEnvelopes makes every dm entry point return its KeyPackages,
+// Welcomes, proposals, commits and ciphertexts in artifact envelopes. Inputs
+// are accepted with or without one either way, and an envelope is always
+// checked, so peers can switch one at a time.
+func SetArtifactEnvelopes(enabled bool) {
+	artifact_envelopes.Store(enabled)
+}
+
+// ArtifactInfo is the envelope of an artifact, as InspectArtifact reads it.
+type ArtifactInfo struct {
+	Type    string `json:"type"`
+	Version uint8  `json:"version"`
+	GroupID string `json:"group_id"`
+	Epoch   uint64 `json:"epoch"`
+	SHA256  string `json:"sha256"`
+	Size    int    `json:"size"`
+}
+
+// InspectArtifact reads an artifact's envelope and checks its SHA-256 without
+// parsing the body. GroupID is base64 and SHA256 hex; Size is the body length.
+func InspectArtifact(artifact_b64 string) (ArtifactInfo, error) {
+	data, err := base64.StdEncoding.DecodeString(artifact_b64)
+	if err != nil {
+		return ArtifactInfo{}, fmt.Errorf("decode artifact: %w", err)
+	}
+	if !is_artifact(data) {
+		return ArtifactInfo{}, fmt.Errorf("%w: no envelope", ErrBadArtifact)
+	}
+	header, body, err := open_artifact(data)
+	if err != nil {
+		return ArtifactInfo{}, err
+	}
+	return ArtifactInfo{
+		Type:    header.kind.String(),
+		Version: artifact_version_v1,
+		GroupID: base64.StdEncoding.EncodeToString(header.group_id),
+		Epoch:   header.epoch,
+		SHA256:  hex.EncodeToString(header.digest),
+		Size:    len(body),
+	}, nil
+}
+
+type artifact_header struct {
+	kind     artifact_type
+	epoch    uint64
+	group_id []byte
+	digest   []byte
+}
+
+// check fails unless the hints match what the body says. A nil header, from
+// an input without an envelope, passes.
+func (header *artifact_header) check(group_id []byte, epoch mls.Epoch) error {
+	if header == nil {
+		return nil
+	}
+	if !bytes.Equal(header.group_id, group_id) {
+		return fmt.Errorf("%w: %s envelope names group %s, body is for %s", ErrBadArtifact, header.kind, base64.StdEncoding.EncodeToString(header.group_id), base64.StdEncoding.EncodeToString(group_id))
+	}
+	if header.epoch != uint64(epoch) {
+		return fmt.Errorf("%w: %s envelope names epoch %d, body is for %d", ErrBadArtifact, header.kind, header.epoch, epoch)
+	}
+	return nil
+}
+
+// encode_artifact returns body base64-encoded, in an envelope when
+// SetArtifactEnvelopes is on.
+func encode_artifact(kind artifact_type, group_id []byte, epoch mls.Epoch, body []byte) string {
+	if !artifact_envelopes.Load() {
+		return base64.StdEncoding.EncodeToString(body)
+	}
+	return base64.StdEncoding.EncodeToString(seal_artifact(kind, group_id, uint64(epoch), body))
+}
+
+func seal_artifact(kind artifact_type, group_id []byte, epoch uint64, body []byte) []byte {
+	out := make([]byte, 0, artifact_header_len+len(group_id)+sha256.Size+len(body))
+	out = append(out, artifact_magic...)
+	out = append(out, artifact_version_v1, byte(kind))
+	out = binary.BigEndian.AppendUint64(out, epoch)
+	out = append(out, byte(len(group_id)))
+	out = append(out, group_id...)
+	digest := sha256.Sum256(body)
+	out = append(out, digest[:]...)
+	return append(out, body...)
+}
+
+// decode_artifact returns the body of an artifact of the given kind and its
+// envelope, or a nil header for a bare body.
+func decode_artifact(kind artifact_type, artifact_b64 string) ([]byte, *artifact_header, error) {
+	data, err := base64.StdEncoding.DecodeString(artifact_b64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !is_artifact(data) {
+		return data, nil, nil
+	}
+	header, body, err := open_artifact(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header.kind != kind {
+		return nil, nil, fmt.Errorf("%w: got a %s, want a %s", ErrBadArtifact, header.kind, kind)
+	}
+	return body, header, nil
+}
+
+func open_artifact(data []byte) (*artifact_header, []byte, error) {
+	if len(data) < artifact_header_len {
+		return nil, nil, fmt.Errorf("%w: truncated header", ErrBadArtifact)
+	}
+	if version := data[len(artifact_magic)]; version != artifact_version_v1 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrBadArtifact, version)
+	}
+	header := &artifact_header{kind: artifact_type(data[len(artifact_magic)+1])}
+	if _, ok := artifact_names[header.kind]; !ok {
+		return nil, nil, fmt.Errorf("%w: unknown type %d", ErrBadArtifact, header.kind)
+	}
+	header.epoch = binary.BigEndian.Uint64(data[len(artifact_magic)+2:])
+	rest := data[artifact_header_len:]
+	group_id_len := int(data[artifact_header_len-1])
+	if len(rest) < group_id_len+sha256.Size {
+		return nil, nil, fmt.Errorf("%w: truncated header", ErrBadArtifact)
+	}
+	header.group_id = rest[:group_id_len]
+	header.digest = rest[group_id_len : group_id_len+sha256.Size]
+	body := rest[group_id_len+sha256.Size:]
+	if digest := sha256.Sum256(body); !bytes.Equal(digest[:], header.digest) {
+		return nil, nil, fmt.Errorf("%w: %s body does not match its SHA-256", ErrBadArtifact, header.kind)
+	}
+	return header, body, nil
+}
+
+func is_artifact(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(artifact_magic))
+}
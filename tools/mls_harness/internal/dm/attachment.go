@@ -0,0 +1,274 @@
+package dm
+
+import (
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// An attachment too large to send as one application message travels outside
+// MLS, encrypted in chunks under a content key, and only its key envelope, an
+// attachment content message, goes through Protect. The envelope carries the
+// key, the plaintext size and the chunk size:
+//
+//	chunk i = XChaCha20-Poly1305(key, nonce(i, last), plaintext[i*chunk_size:], ad "MLSA" | version)
+//
+// nonce(i, last) is i as a big-endian uint64, then 1 for the final chunk and
+// 0 for the others, then zeros. Every chunk but the last holds chunk_size
+// plaintext bytes, so a receiver finds the chunks without a framing header,
+// and the final flag makes a truncated attachment fail rather than open short.
+// An empty attachment is one empty chunk.
+//
+// The content key is the epoch's exporter under attachment_key_label with 32
+// fresh random bytes as context, whatever the participant's mode, so no two
+// attachments share a key and counter nonces never repeat. Because the
+// envelope holds the key itself, a member opens the attachment in whatever
+// epoch it decrypts the envelope.
+const (
+	attachment_magic      = "MLSA"
+	attachment_version_v1 = 1
+	attachment_key_label  = "polycentric mls attachment v1"
+	attachment_key_len    = chacha20poly1305.KeySize
+	attachment_context    = 32
+	attachment_tag_len    = 16
+
+	// DefaultAttachmentChunkSize is the chunk size of an attachment that does
+	// not name one.
+	DefaultAttachmentChunkSize = 64 << 10
+	// MaxAttachmentChunkSize bounds the chunk size, and so the memory one
+	// chunk takes on either side.
+	MaxAttachmentChunkSize = 4 << 20
+	// MaxAttachmentSize bounds the plaintext size an envelope may name, so
+	// the sealed size of any valid attachment fits in a uint64 and an int.
+	MaxAttachmentSize = 1 << 40
+)
+
+// ErrAttachmentCorrupt is an attachment chunk that does not open under its
+// envelope's key, or ciphertext that does not split into the envelope's chunks.
+var ErrAttachmentCorrupt = errors.New("attachment is corrupt")
+
+// Attachment is the body of an attachment envelope. Key is the base64 content
+// key, Size the plaintext length and ChunkSize the plaintext bytes per chunk.
+type Attachment struct {
+	Key       string `json:"key"`
+	Size      uint64 `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+// Chunks returns how many chunks the attachment is sealed in.
+func (attachment Attachment) Chunks() uint64 {
+	if attachment.Size == 0 || attachment.ChunkSize < 1 {
+		return 1
+	}
+	chunks := attachment.Size / uint64(attachment.ChunkSize)
+	if attachment.Size%uint64(attachment.ChunkSize) != 0 {
+		chunks++
+	}
+	return chunks
+}
+
+// SealedSize returns the length of the whole sealed attachment, or
+// math.MaxUint64 for an envelope naming more than a uint64 can hold, which no
+// sealed attachment matches.
+func (attachment Attachment) SealedSize() uint64 {
+	hi, tags := bits.Mul64(attachment.Chunks(), attachment_tag_len)
+	if hi != 0 {
+		return math.MaxUint64
+	}
+	size, carry := bits.Add64(attachment.Size, tags, 0)
+	if carry != 0 {
+		return math.MaxUint64
+	}
+	return size
+}
+
+func (attachment *Attachment) validate() error {
+	if attachment.Size > MaxAttachmentSize {
+		return fmt.Errorf("attachment size must be at most %d bytes (got %d)", uint64(MaxAttachmentSize), attachment.Size)
+	}
+	if attachment.ChunkSize < 1 || attachment.ChunkSize > MaxAttachmentChunkSize {
+		return fmt.Errorf("attachment chunk size must be between 1 and %d bytes (got %d)", MaxAttachmentChunkSize, attachment.ChunkSize)
+	}
+	key, err := base64.StdEncoding.DecodeString(attachment.Key)
+	if err != nil || len(key) != attachment_key_len {
+		return fmt.Errorf("attachment key must be %d bytes of base64", attachment_key_len)
+	}
+	return nil
+}
+
+// BeginAttachment derives a content key for an attachment of size bytes and
+// encrypts its envelope in the group. It returns the participant, the envelope
+// ciphertext and the Attachment to seal chunks under with SealAttachmentChunk.
+// A chunk_size of 0 means DefaultAttachmentChunkSize.
+func BeginAttachment(participant_b64, group_id_b64 string, size uint64, chunk_size int) (string, string, Attachment, error) {
+	if participant_b64 == "" {
+		return "", "", Attachment{}, errors.New("participant is required")
+	}
+	if chunk_size == 0 {
+		chunk_size = DefaultAttachmentChunkSize
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", "", Attachment{}, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return "", "", Attachment{}, err
+	}
+	context := make([]byte, attachment_context)
+	if _, err := io.ReadFull(system_entropy, context); err != nil {
+		return "", "", Attachment{}, fmt.Errorf("attachment context: %w", err)
+	}
+	key := session.State.Keys.Export(attachment_key_label, context, attachment_key_len)
+	attachment := Attachment{Key: base64.StdEncoding.EncodeToString(key), Size: size, ChunkSize: chunk_size}
+	clear(key)
+	message, err := EncodeContent(Content{Kind: ContentAttachment, Attachment: &attachment})
+	if err != nil {
+		return "", "", Attachment{}, err
+	}
+	envelope_b64, err := protect_one(participant, session, message, true)
+	if err != nil {
+		return "", "", Attachment{}, err
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", "", Attachment{}, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, envelope_b64, attachment, nil
+}
+
+// SealAttachmentChunk encrypts chunk index of the attachment. Every chunk but
+// the last must be ChunkSize bytes, and the last what remains of Size.
+func SealAttachmentChunk(attachment Attachment, index uint64, chunk []byte) ([]byte, error) {
+	aead, last, err := attachment_chunk(attachment, index)
+	if err != nil {
+		return nil, err
+	}
+	if want := attachment.chunk_len(index); len(chunk) != want {
+		return nil, fmt.Errorf("attachment chunk %d must be %d bytes (got %d)", index, want, len(chunk))
+	}
+	return aead.Seal(nil, attachment_nonce(index, last), chunk, attachment_ad()), nil
+}
+
+// OpenAttachmentChunk decrypts chunk index of the attachment.
+func OpenAttachmentChunk(attachment Attachment, index uint64, sealed []byte) ([]byte, error) {
+	aead, last, err := attachment_chunk(attachment, index)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) != attachment.chunk_len(index)+attachment_tag_len {
+		return nil, fmt.Errorf("%w: chunk %d is %d bytes", ErrAttachmentCorrupt, index, len(sealed))
+	}
+	chunk, err := aead.Open(nil, attachment_nonce(index, last), sealed, attachment_ad())
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d: %w", ErrAttachmentCorrupt, index, err)
+	}
+	return chunk, nil
+}
+
+// EncryptAttachment is BeginAttachment for data followed by sealing every
+// chunk. It returns the participant, the envelope ciphertext and the sealed
+// attachment.
+func EncryptAttachment(participant_b64, group_id_b64 string, data []byte, chunk_size int) (string, string, []byte, error) {
+	participant_b64, envelope_b64, attachment, err := BeginAttachment(participant_b64, group_id_b64, uint64(len(data)), chunk_size)
+	if err != nil {
+		return "", "", nil, err
+	}
+	sealed := make([]byte, 0, attachment.SealedSize())
+	for index := uint64(0); index < attachment.Chunks(); index++ {
+		start := index * uint64(attachment.ChunkSize)
+		chunk, err := SealAttachmentChunk(attachment, index, data[start:start+uint64(attachment.chunk_len(index))])
+		if err != nil {
+			return "", "", nil, err
+		}
+		sealed = append(sealed, chunk...)
+	}
+	return participant_b64, envelope_b64, sealed, nil
+}
+
+// OpenAttachment decrypts a whole sealed attachment under the envelope a
+// receiver already decrypted, such as the Attachment in a ReceivedMessage.
+func OpenAttachment(attachment Attachment, sealed []byte) ([]byte, error) {
+	if err := attachment.validate(); err != nil {
+		return nil, err
+	}
+	if uint64(len(sealed)) != attachment.SealedSize() {
+		return nil, fmt.Errorf("%w: %d bytes, the envelope names %d", ErrAttachmentCorrupt, len(sealed), attachment.SealedSize())
+	}
+	// The check above makes this Size, but counting it from sealed bounds the
+	// allocation by what the caller holds rather than what the envelope says.
+	data := make([]byte, 0, len(sealed)-int(attachment.Chunks())*attachment_tag_len)
+	for index := uint64(0); index < attachment.Chunks(); index++ {
+		length := attachment.chunk_len(index) + attachment_tag_len
+		chunk, err := OpenAttachmentChunk(attachment, index, sealed[:length])
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, chunk...)
+		sealed = sealed[length:]
+	}
+	return data, nil
+}
+
+// DecryptAttachment decrypts an envelope like DecryptMessage, then the sealed
+// attachment under it. It returns the participant, the attachment and the
+// envelope message.
+func DecryptAttachment(participant_b64, group_id_b64, envelope_b64 string, sealed []byte) (string, []byte, *ReceivedMessage, error) {
+	participant_b64, message, err := open_message(participant_b64, group_id_b64, envelope_b64)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if message.Content == nil || message.Content.Kind != ContentAttachment {
+		return "", nil, nil, fmt.Errorf("message is %s, not an attachment envelope", message.ContentType)
+	}
+	data, err := OpenAttachment(*message.Content.Attachment, sealed)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return participant_b64, data, message, nil
+}
+
+// chunk_len returns the plaintext length of chunk index.
+func (attachment Attachment) chunk_len(index uint64) int {
+	if index+1 < attachment.Chunks() {
+		return attachment.ChunkSize
+	}
+	return int(attachment.Size - index*uint64(attachment.ChunkSize))
+}
+
+func attachment_chunk(attachment Attachment, index uint64) (cipher.AEAD, bool, error) {
+	if err := attachment.validate(); err != nil {
+		return nil, false, err
+	}
+	if index >= attachment.Chunks() {
+		return nil, false, fmt.Errorf("attachment has %d chunks (got index %d)", attachment.Chunks(), index)
+	}
+	key, _ := base64.StdEncoding.DecodeString(attachment.Key)
+	defer clear(key)
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("attachment key: %w", err)
+	}
+	return aead, index+1 == attachment.Chunks(), nil
+}
+
+func attachment_nonce(index uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[8] = 1
+	}
+	return nonce
+}
+
+func attachment_ad() []byte {
+	return append([]byte(attachment_magic), attachment_version_v1)
+}
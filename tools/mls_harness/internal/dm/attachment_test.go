@@ -0,0 +1,25 @@
+package dm
+
+import (
+	"encoding/base64"
+	"math"
+	"testing"
+)
+
+func TestOpenAttachmentRefusesForgedSize(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, attachment_key_len))
+	// 17 * 0xf0f0f0f0f0f0f0f2 wraps to 18, so with one-byte chunks unchecked
+	// arithmetic takes 18 sealed bytes for an attachment of that size.
+	forged := Attachment{Key: key, Size: 0xf0f0f0f0f0f0f0f2, ChunkSize: 1}
+	if _, err := OpenAttachment(forged, make([]byte, 18)); err == nil {
+		t.Fatal("opened an attachment whose size overflows its sealed length")
+	}
+	if got := forged.SealedSize(); got != math.MaxUint64 {
+		t.Fatalf("sealed size of a forged envelope is %d, want math.MaxUint64", got)
+	}
+
+	too_large := Attachment{Key: key, Size: MaxAttachmentSize + 1, ChunkSize: MaxAttachmentChunkSize}
+	if _, err := OpenAttachment(too_large, make([]byte, 16)); err == nil {
+		t.Fatal("opened an attachment larger than MaxAttachmentSize")
+	}
+}
@@ -0,0 +1,105 @@
+package dm
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// Backups move a participant to a new device or browser profile:
+//
+//	magic "MLSB" | version uint8 | kdf uint8 | iterations uint32 | salt[16] | nonce[24] | ciphertext
+//
+// The envelope is the sealed participant one from seal.go under its own magic
+// and HKDF info, so a state key cannot open a backup or the other way round.
+// The ciphertext is a participant blob from codec.go cut down to what the new
+// device needs: the identity and init secrets, the policies, the KeyPackage
+// pool, the identity binding and each group's current epoch. Retained past
+// epochs and the message keys the ratchets still cache are left out, so a
+// backup cannot decrypt a message the old device already read or skipped.
+// The new device picks up at the ratchets' current generations and should
+// send an Update in every group before anything else, replacing the leaf
+// secrets it shares with the old device.
+const backup_magic = "MLSB"
+
+var backup_seal = seal_format{magic: backup_magic, info: "mls-harness backup v1", noun: "backup"}
+
+// ExportBackup returns the participant's backup sealed with key. A group with
+// pending commits is refused: the commit's next epoch would not survive the
+// move, so apply or discard them first.
+func ExportBackup(participant_b64 string, key SealKey) (string, error) {
+	s, err := new_sealer(key, backup_seal)
+	if err != nil {
+		return "", err
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	for _, id := range group_ids(participant) {
+		session := participant.Sessions[id]
+		if len(session.Pending) > 0 {
+			return "", fmt.Errorf("group %s has %d pending commits; apply or discard them first", id, len(session.Pending))
+		}
+		session.PastEpochs = nil
+		drop_cached_keys(session.State)
+	}
+	data, err := marshal_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	sealed, err := s.seal(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// ImportBackup opens a backup from ExportBackup and returns the participant it
+// holds, sealed under the state key if one is set, with the groups it is in.
+// Each of those groups wants an Update from the new device.
+func ImportBackup(backup_b64 string, key SealKey) (string, []string, error) {
+	s, err := new_sealer(key, backup_seal)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(backup_b64)
+	if err != nil {
+		return "", nil, fmt.Errorf("decode backup: %w", err)
+	}
+	plain, err := s.open(data)
+	if err != nil {
+		return "", nil, err
+	}
+	participant, err := unmarshal_participant(plain)
+	if err != nil {
+		return "", nil, fmt.Errorf("backup: %w: %w", ErrStateCorrupt, err)
+	}
+	participant_b64, err := encode_participant(participant)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, group_ids(participant), nil
+}
+
+// drop_cached_keys erases the keys each sender's ratchet holds for generations
+// it has already stepped past: ones the participant used and ones it skipped.
+// The ratchets themselves stay where they are.
+func drop_cached_keys(state *mls.State) {
+	if state.Keys.ApplicationKeys != nil {
+		for _, ratchet := range state.Keys.ApplicationKeys.Ratchets {
+			clear(ratchet.Cache)
+		}
+	}
+	if state.Keys.HandshakeKeys != nil {
+		for _, ratchet := range state.Keys.HandshakeKeys.Ratchets {
+			clear(ratchet.Cache)
+		}
+	}
+}
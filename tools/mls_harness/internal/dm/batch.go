@@ -0,0 +1,99 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Every single-message entry point decodes and re-encodes the whole
+// participant, which dominates the cost of syncing a chat history. The batch
+// entry points below decode it once, run every message against the same state
+// and encode it once.
+
+// BatchMessage is one result of DecryptBatch: the message, or the error and
+// its ErrorCode.
+type BatchMessage struct {
+	Message *ReceivedMessage `json:"message,omitempty"`
+	Error   string           `json:"error,omitempty"`
+	Code    string           `json:"code,omitempty"`
+}
+
+// EncryptBatch encrypts each plaintext in order as Encrypt would and returns
+// the ciphertexts. It is all or nothing: on error no participant comes back,
+// and the caller keeps the one it passed in.
+func EncryptBatch(participant_b64, group_id_b64 string, plaintexts []string) (string, []string, error) {
+	if participant_b64 == "" {
+		return "", nil, errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return "", nil, err
+	}
+	cts, err := protect_batch(participant, session, plaintexts)
+	if err != nil {
+		return "", nil, err
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, cts, nil
+}
+
+// protect_batch encrypts plaintexts in order in session, stopping at the first
+// that fails.
+func protect_batch(participant *Participant, session *Session, plaintexts []string) ([]string, error) {
+	cts := make([]string, 0, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		ct, err := protect_one(participant, session, Message{Body: plaintext}, false)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		cts = append(cts, ct)
+	}
+	return cts, nil
+}
+
+// DecryptBatch decrypts each ciphertext in order as DecryptMessage would. An
+// empty group_id_b64 routes every message by the group it names, so one batch
+// may span groups. A message that fails gets an error entry and the others go
+// on; the returned participant keeps what every message did to it, so a
+// ciphertext that fails after its sender data decrypts may have spent its
+// generation's key.
+func DecryptBatch(participant_b64, group_id_b64 string, ciphertexts_b64 []string) (string, []BatchMessage, error) {
+	if participant_b64 == "" {
+		return "", nil, errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", nil, errors.New("participant state not initialized")
+	}
+	results := open_batch(participant, group_id_b64, ciphertexts_b64)
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, results, nil
+}
+
+func open_batch(participant *Participant, group_id_b64 string, ciphertexts_b64 []string) []BatchMessage {
+	results := make([]BatchMessage, 0, len(ciphertexts_b64))
+	for _, ciphertext_b64 := range ciphertexts_b64 {
+		message, err := open_one(participant, group_id_b64, ciphertext_b64)
+		if err != nil {
+			results = append(results, BatchMessage{Error: err.Error(), Code: ErrorCode(err)})
+			continue
+		}
+		results = append(results, BatchMessage{Message: message})
+	}
+	return results
+}
@@ -0,0 +1,409 @@
+package dm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Participant blobs are a fixed envelope followed by a TLS-syntax body:
+//
+//	magic "MLSP" | version uint8 | format uint8 | body
+//
+// Version 1 with format 1 is participant_v1 below: the identity and init
+// secrets, the cipher suite, the retention, padding and rotation policies, the
+// KeyPackage pool, the optional identity binding, the mode and the reusable
+// KeyPackage's lifetime, and one session per group with a queue of pending
+// commits, the retained past epochs, whether the participant has left, the
+// identities of the commits it applied last, the group's admins and what the
+// rotation policy counts. A consumed pool entry keeps its KeyPackage with an
+// empty init secret. The body only carries the fields the dm package needs,
+// each with an explicit wire type, so it does not change with the Go release
+// or with unrelated go-mls struct fields. Blobs written before the envelope
+// existed are gob; decode_participant still reads them, and the next
+// encode_participant rewrites them as version 1 with DefaultCipherSuite, the
+// default policies and their one secret as both the identity and init secret.
+const (
+	participant_magic      = "MLSP"
+	participant_version_v1 = 1
+	participant_format_tls = 1
+)
+
+// StateFormatVersion is the participant blob version this build writes.
+const StateFormatVersion = participant_version_v1
+
+type participant_v1 struct {
+	Name            []byte `tls:"head=2"`
+	IdentitySecret  []byte `tls:"head=1"`
+	InitSecret      []byte `tls:"head=1"`
+	Suite           mls.CipherSuite
+	Retention       retention_v1
+	Padding         padding_v1
+	Pool            []keypackage_v1      `tls:"head=4"`
+	IdentityBinding *identity_binding_v1 `tls:"optional"`
+	Mode            uint8
+	Lifetime        lifetime_v1
+	Rotation        rotation_v1
+	Sessions        []session_v1 `tls:"head=4"`
+}
+
+type retention_v1 struct {
+	MaxSkippedGenerations uint32
+	MaxPastEpochs         uint32
+}
+
+type padding_v1 struct {
+	Buckets []uint32 `tls:"head=1"`
+}
+
+type keypackage_v1 struct {
+	KeyPackage []byte `tls:"head=4"`
+	InitSecret []byte `tls:"head=1"`
+}
+
+type identity_binding_v1 struct {
+	UserKey   []byte `tls:"head=1"`
+	Signature []byte `tls:"head=1"`
+}
+
+type lifetime_v1 struct {
+	NotBefore uint64
+	NotAfter  uint64
+}
+
+type rotation_v1 struct {
+	MaxMessages uint32
+	MaxEpochs   uint32
+}
+
+type session_v1 struct {
+	State             state_v1
+	Pending           []pending_v1 `tls:"head=4"`
+	PastEpochs        []state_v1   `tls:"head=4"`
+	JoinedEpoch       uint64
+	Left              uint8
+	Applied           []applied_commit_v1 `tls:"head=4"`
+	Admins            []admin_v1          `tls:"head=4"`
+	SentSinceRotation uint32
+	RotatedEpoch      uint64
+}
+
+type applied_commit_v1 struct {
+	Hash []byte `tls:"head=1"`
+}
+
+type admin_v1 struct {
+	Identity []byte `tls:"head=2"`
+}
+
+type pending_v1 struct {
+	Commit    []byte    `tls:"head=4"`
+	Welcome   []byte    `tls:"head=4"`
+	NextState *state_v1 `tls:"optional"`
+}
+
+// state_v1 is the shared group state (what a Welcome's GroupInfo carries) plus
+// the member's secrets in go-mls's own StateSecrets carrier.
+type state_v1 struct {
+	CipherSuite             mls.CipherSuite
+	GroupID                 []byte `tls:"head=1"`
+	Epoch                   mls.Epoch
+	Tree                    mls.TreeKEMPublicKey
+	ConfirmedTranscriptHash []byte `tls:"head=1"`
+	InterimTranscriptHash   []byte `tls:"head=1"`
+	Extensions              mls.ExtensionList
+	Secrets                 mls.StateSecrets
+}
+
+// Names ParticipantFormat reports for the encodings decode_participant reads.
+const (
+	ParticipantFormatGob       = "gob"
+	ParticipantFormatMLSPv1    = "mlsp_v1"
+	ParticipantFormatSealed    = "sealed"
+	ParticipantFormatDestroyed = "destroyed"
+)
+
+// ParticipantFormat reports which encoding a participant blob uses without
+// decoding its state, so callers can tell blobs still awaiting migration apart.
+// A sealed blob reports only that it is sealed.
+func ParticipantFormat(participant_b64 string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode base64: %w", err)
+	}
+	if is_destroyed(data) {
+		return ParticipantFormatDestroyed, nil
+	}
+	if is_sealed(data) {
+		return ParticipantFormatSealed, nil
+	}
+	if !bytes.HasPrefix(data, []byte(participant_magic)) {
+		return ParticipantFormatGob, nil
+	}
+	if _, err := check_envelope(data); err != nil {
+		return "", err
+	}
+	return ParticipantFormatMLSPv1, nil
+}
+
+func check_envelope(data []byte) (byte, error) {
+	if len(data) < len(participant_magic)+2 {
+		return 0, errors.New("truncated participant header")
+	}
+	version, format := data[len(participant_magic)], data[len(participant_magic)+1]
+	if version != participant_version_v1 || format != participant_format_tls {
+		return 0, fmt.Errorf("unsupported participant version %d format %d", version, format)
+	}
+	return version, nil
+}
+
+func marshal_participant(participant *Participant) ([]byte, error) {
+	body := participant_v1{
+		Name:           []byte(participant.Name),
+		IdentitySecret: participant.IdentitySecret,
+		InitSecret:     participant.InitSecret,
+		Suite:          participant.Suite,
+		Retention:      retention_v1(participant.Retention),
+		Padding:        padding_v1{Buckets: participant.Padding.Buckets},
+		Pool:           []keypackage_v1{},
+		Mode:           uint8(participant.Mode),
+		Lifetime:       lifetime_v1(participant.Lifetime),
+		Rotation:       rotation_v1(participant.Rotation),
+		Sessions:       []session_v1{},
+	}
+	if body.Padding.Buckets == nil {
+		body.Padding.Buckets = []uint32{}
+	}
+	if binding := participant.IdentityBinding; binding != nil {
+		body.IdentityBinding = &identity_binding_v1{UserKey: binding.UserKey, Signature: binding.Signature}
+	}
+	for _, pooled := range participant.Pool {
+		wire := keypackage_v1{KeyPackage: pooled.KeyPackage, InitSecret: pooled.InitSecret}
+		if pooled.Consumed {
+			wire.InitSecret = []byte{}
+		}
+		body.Pool = append(body.Pool, wire)
+	}
+	for _, id := range group_ids(participant) {
+		session := participant.Sessions[id]
+		if session == nil || session.State == nil {
+			return nil, fmt.Errorf("group %s has no state", id)
+		}
+		wire := session_v1{State: *to_state_v1(session.State), Pending: []pending_v1{}, PastEpochs: []state_v1{}, JoinedEpoch: session.JoinedEpoch, Applied: []applied_commit_v1{}, Admins: []admin_v1{}, SentSinceRotation: session.SentSinceRotation, RotatedEpoch: session.RotatedEpoch}
+		if session.Left {
+			wire.Left = 1
+		}
+		for _, hash := range session.Applied {
+			wire.Applied = append(wire.Applied, applied_commit_v1{Hash: hash})
+		}
+		for _, admin := range session.Admins {
+			wire.Admins = append(wire.Admins, admin_v1{Identity: []byte(admin)})
+		}
+		for _, pending := range session.Pending {
+			wire.Pending = append(wire.Pending, *to_pending_v1(pending))
+		}
+		for _, past := range session.PastEpochs {
+			wire.PastEpochs = append(wire.PastEpochs, *to_state_v1(past))
+		}
+		body.Sessions = append(body.Sessions, wire)
+	}
+	data, err := syntax.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(participant_magic), participant_version_v1, participant_format_tls)
+	return append(header, data...), nil
+}
+
+func to_pending_v1(pending *PendingCommit) *pending_v1 {
+	if pending == nil {
+		return nil
+	}
+	body := &pending_v1{Commit: pending.Commit, Welcome: pending.Welcome}
+	if pending.NextState != nil {
+		body.NextState = to_state_v1(pending.NextState)
+	}
+	return body
+}
+
+func from_pending_v1(body *pending_v1) (*PendingCommit, error) {
+	if body == nil {
+		return nil, nil
+	}
+	pending := &PendingCommit{Commit: body.Commit, Welcome: body.Welcome}
+	if body.NextState != nil {
+		var err error
+		if pending.NextState, err = from_state_v1(body.NextState); err != nil {
+			return nil, fmt.Errorf("pending state: %w", err)
+		}
+	}
+	return pending, nil
+}
+
+func unmarshal_participant(data []byte) (participant *Participant, err error) {
+	// go-tls-syntax and go-mls panic on some malformed inputs (truncated
+	// optionals, inconsistent trees); a corrupt blob is an error, not a crash.
+	defer func() {
+		if r := recover(); r != nil {
+			participant, err = nil, fmt.Errorf("malformed participant: %v", r)
+		}
+	}()
+
+	if !bytes.HasPrefix(data, []byte(participant_magic)) {
+		return unmarshal_gob_participant(data)
+	}
+	header := len(participant_magic) + 2
+	if _, err := check_envelope(data); err != nil {
+		return nil, err
+	}
+	var body participant_v1
+	read, err := syntax.Unmarshal(data[header:], &body)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal participant: %w", err)
+	}
+	if header+read != len(data) {
+		return nil, errors.New("trailing bytes after participant")
+	}
+
+	if !supported_suite(body.Suite) {
+		return nil, fmt.Errorf("unsupported participant cipher suite %s", body.Suite)
+	}
+	if mode := Mode(body.Mode); mode != ModeDeterministic && mode != ModeSecure {
+		return nil, fmt.Errorf("unsupported participant mode %d", body.Mode)
+	}
+	participant = &Participant{
+		Name:           string(body.Name),
+		IdentitySecret: body.IdentitySecret,
+		InitSecret:     body.InitSecret,
+		Suite:          body.Suite,
+		Sessions:       map[string]*Session{},
+		Retention:      RetentionPolicy(body.Retention),
+		Mode:           Mode(body.Mode),
+		Lifetime:       Lifetime(body.Lifetime),
+		Rotation:       RotationPolicy(body.Rotation),
+	}
+	if len(body.Padding.Buckets) > 0 {
+		participant.Padding.Buckets = body.Padding.Buckets
+	}
+	if err := participant.Padding.validate(); err != nil {
+		return nil, err
+	}
+	if binding := body.IdentityBinding; binding != nil {
+		participant.IdentityBinding = &IdentityBinding{UserKey: binding.UserKey, Signature: binding.Signature}
+	}
+	for _, wire := range body.Pool {
+		participant.Pool = append(participant.Pool, &PooledKeyPackage{KeyPackage: wire.KeyPackage, InitSecret: wire.InitSecret, Consumed: len(wire.InitSecret) == 0})
+	}
+	for i := range body.Sessions {
+		state, err := from_state_v1(&body.Sessions[i].State)
+		if err != nil {
+			return nil, fmt.Errorf("session %d state: %w", i, err)
+		}
+		id := base64.StdEncoding.EncodeToString(state.GroupID)
+		if _, ok := participant.Sessions[id]; ok {
+			return nil, fmt.Errorf("duplicate session for group %s", id)
+		}
+		session := &Session{State: state, JoinedEpoch: body.Sessions[i].JoinedEpoch, Left: body.Sessions[i].Left != 0, SentSinceRotation: body.Sessions[i].SentSinceRotation, RotatedEpoch: body.Sessions[i].RotatedEpoch}
+		for _, applied := range body.Sessions[i].Applied {
+			session.Applied = append(session.Applied, applied.Hash)
+		}
+		for _, admin := range body.Sessions[i].Admins {
+			session.Admins = append(session.Admins, string(admin.Identity))
+		}
+		for j := range body.Sessions[i].Pending {
+			pending, err := from_pending_v1(&body.Sessions[i].Pending[j])
+			if err != nil {
+				return nil, fmt.Errorf("session %d: %w", i, err)
+			}
+			session.Pending = append(session.Pending, pending)
+		}
+		for j := range body.Sessions[i].PastEpochs {
+			past, err := from_state_v1(&body.Sessions[i].PastEpochs[j])
+			if err != nil {
+				return nil, fmt.Errorf("session %d past epoch %d: %w", i, j, err)
+			}
+			session.PastEpochs = append(session.PastEpochs, past)
+		}
+		participant.Sessions[id] = session
+	}
+	return participant, nil
+}
+
+func to_state_v1(state *mls.State) *state_v1 {
+	// A state that went through gob holds separate copies of the key sources
+	// the ratchets actually advanced; make the serialized fields those copies.
+	keys := reflect.ValueOf(&state.Keys).Elem()
+	for _, kind := range []string{"Handshake", "Application"} {
+		live := keys.FieldByName(kind + "Keys")
+		if live.IsNil() {
+			continue
+		}
+		keys.FieldByName(kind + "BaseKeys").Set(live.Elem().FieldByName("Base").Elem())
+		keys.FieldByName(kind + "Ratchets").Set(live.Elem().FieldByName("Ratchets"))
+	}
+
+	return &state_v1{
+		CipherSuite:             state.CipherSuite,
+		GroupID:                 state.GroupID,
+		Epoch:                   state.Epoch,
+		Tree:                    state.Tree,
+		ConfirmedTranscriptHash: state.ConfirmedTranscriptHash,
+		InterimTranscriptHash:   state.InterimTranscriptHash,
+		Extensions:              state.Extensions,
+		Secrets:                 state.GetSecrets(),
+	}
+}
+
+func from_state_v1(body *state_v1) (*mls.State, error) {
+	if body.Secrets.CipherSuite != body.CipherSuite {
+		return nil, errors.New("secrets suite does not match group suite")
+	}
+	state := &mls.State{
+		CipherSuite:             body.CipherSuite,
+		GroupID:                 body.GroupID,
+		Epoch:                   body.Epoch,
+		Tree:                    body.Tree,
+		ConfirmedTranscriptHash: body.ConfirmedTranscriptHash,
+		InterimTranscriptHash:   body.InterimTranscriptHash,
+		Extensions:              body.Extensions,
+		NewCredentials:          map[mls.LeafIndex]bool{},
+	}
+	state.Tree.Suite = body.CipherSuite
+	if err := state.Tree.SetHashAll(); err != nil {
+		return nil, fmt.Errorf("tree hash: %w", err)
+	}
+	state.SetSecrets(body.Secrets)
+	if err := link_key_sources(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// link_key_sources rebuilds the handshake and application key sources, which
+// go-mls only wires up when it derives an epoch and does not serialize. They
+// share the base keys and ratchet maps with the serialized fields, as they do
+// in a freshly derived epoch.
+func link_key_sources(state *mls.State) error {
+	keys := reflect.ValueOf(&state.Keys).Elem()
+	for _, kind := range []string{"Handshake", "Application"} {
+		base := keys.FieldByName(kind + "BaseKeys")
+		ratchets := keys.FieldByName(kind + "Ratchets")
+		if base.IsNil() {
+			return fmt.Errorf("missing %s base keys", kind)
+		}
+		if ratchets.IsNil() {
+			ratchets.Set(reflect.MakeMap(ratchets.Type()))
+		}
+		live := keys.FieldByName(kind + "Keys")
+		source := reflect.New(live.Type().Elem())
+		source.Elem().FieldByName("Base").Set(base)
+		source.Elem().FieldByName("Ratchets").Set(ratchets)
+		live.Set(source)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package dm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Typed content gives the traffic clients exchange besides chat text one
+// schema each, named by the frame's content type, so receipts and typing
+// indicators ride the MLS channel without every client inventing its own
+// encoding. Chat is plain text; the other kinds are JSON objects. Receivers
+// decode every kind they know and leave other content types to the caller.
+const (
+	ContentTypeChat       = DefaultContentType
+	ContentTypeReceipt    = "application/vnd.polycentric.receipt+json"
+	ContentTypeTyping     = "application/vnd.polycentric.typing+json"
+	ContentTypeControl    = "application/vnd.polycentric.control+json"
+	ContentTypeAttachment = "application/vnd.polycentric.attachment+json"
+)
+
+// ContentKind names one of the typed content schemas.
+type ContentKind string
+
+const (
+	ContentChat       ContentKind = "chat"
+	ContentReceipt    ContentKind = "receipt"
+	ContentTyping     ContentKind = "typing"
+	ContentControl    ContentKind = "control"
+	ContentAttachment ContentKind = "attachment"
+)
+
+// max_receipt_messages bounds the messages one receipt acknowledges.
+const max_receipt_messages = 256
+
+// Content is one typed message. Kind picks which of the other fields is set.
+type Content struct {
+	Kind       ContentKind `json:"kind"`
+	Text       string      `json:"text,omitempty"`
+	Receipt    *Receipt    `json:"receipt,omitempty"`
+	Typing     *Typing     `json:"typing,omitempty"`
+	Control    *Control    `json:"control,omitempty"`
+	Attachment *Attachment `json:"attachment,omitempty"`
+}
+
+// Receipt acknowledges messages by their MessageID. Status is "delivered" or
+// "read".
+type Receipt struct {
+	Status     string   `json:"status"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// Typing reports whether the sender is composing a message.
+type Typing struct {
+	Active bool `json:"active"`
+}
+
+// Control is an application-defined action, such as renaming the
+// conversation, with string parameters. dm does not interpret it.
+type Control struct {
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// MessageID is the hex SHA-256 of an application message's ciphertext, not
+// counting any artifact envelope. The sender computes it from what it sent and
+// receivers get it in ReceivedMessage, so receipts can name messages without
+// revealing them to the delivery service.
+func MessageID(ciphertext_b64 string) (string, error) {
+	ct_bytes, _, err := decode_artifact(artifact_ciphertext, ciphertext_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode ciphertext: %w", err)
+	}
+	return message_id(ct_bytes), nil
+}
+
+func message_id(ct_bytes []byte) string {
+	sum := sha256.Sum256(ct_bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// EncodeContent checks content against its kind's schema and returns it as a
+// Message for EncryptMessage.
+func EncodeContent(content Content) (Message, error) {
+	if err := content.validate(); err != nil {
+		return Message{}, err
+	}
+	var message Message
+	var body interface{}
+	switch content.Kind {
+	case ContentChat:
+		return Message{ContentType: ContentTypeChat, Body: content.Text}, nil
+	case ContentReceipt:
+		message.ContentType, body = ContentTypeReceipt, content.Receipt
+	case ContentTyping:
+		message.ContentType, body = ContentTypeTyping, content.Typing
+	case ContentControl:
+		message.ContentType, body = ContentTypeControl, content.Control
+	case ContentAttachment:
+		message.ContentType, body = ContentTypeAttachment, content.Attachment
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return Message{}, fmt.Errorf("encode %s: %w", content.Kind, err)
+	}
+	message.Body = string(data)
+	return message, nil
+}
+
+// DecodeContent returns the typed content of a message, or nil for a content
+// type outside the schemas. A known content type with a body that does not
+// fit its schema is an error.
+func DecodeContent(message Message) (*Content, error) {
+	content := &Content{}
+	var target interface{}
+	switch message.ContentType {
+	case ContentTypeChat:
+		return &Content{Kind: ContentChat, Text: message.Body}, nil
+	case ContentTypeReceipt:
+		content.Kind, content.Receipt = ContentReceipt, &Receipt{}
+		target = content.Receipt
+	case ContentTypeTyping:
+		content.Kind, content.Typing = ContentTyping, &Typing{}
+		target = content.Typing
+	case ContentTypeControl:
+		content.Kind, content.Control = ContentControl, &Control{}
+		target = content.Control
+	case ContentTypeAttachment:
+		content.Kind, content.Attachment = ContentAttachment, &Attachment{}
+		target = content.Attachment
+	default:
+		return nil, nil
+	}
+	if err := json.Unmarshal([]byte(message.Body), target); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", content.Kind, err)
+	}
+	if err := content.validate(); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func (content Content) validate() error {
+	fields := []struct {
+		kind    ContentKind
+		present bool
+	}{
+		{ContentChat, content.Text != ""},
+		{ContentReceipt, content.Receipt != nil},
+		{ContentTyping, content.Typing != nil},
+		{ContentControl, content.Control != nil},
+		{ContentAttachment, content.Attachment != nil},
+	}
+	known := false
+	for _, field := range fields {
+		known = known || field.kind == content.Kind
+		if field.present && field.kind != content.Kind {
+			return fmt.Errorf("%s content has a %s field", content.Kind, field.kind)
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown content kind %q", content.Kind)
+	}
+	switch {
+	case content.Kind == ContentReceipt && content.Receipt == nil:
+		return errors.New("receipt content needs a receipt")
+	case content.Kind == ContentReceipt:
+		return content.Receipt.validate()
+	case content.Kind == ContentTyping && content.Typing == nil:
+		return errors.New("typing content needs a typing state")
+	case content.Kind == ContentControl && content.Control == nil:
+		return errors.New("control content needs a control")
+	case content.Kind == ContentControl && content.Control.Action == "":
+		return errors.New("control action is required")
+	case content.Kind == ContentAttachment && content.Attachment == nil:
+		return errors.New("attachment content needs an attachment")
+	case content.Kind == ContentAttachment:
+		return content.Attachment.validate()
+	}
+	return nil
+}
+
+func (receipt *Receipt) validate() error {
+	if receipt.Status != "delivered" && receipt.Status != "read" {
+		return fmt.Errorf("receipt status %q (want delivered or read)", receipt.Status)
+	}
+	if len(receipt.MessageIDs) == 0 || len(receipt.MessageIDs) > max_receipt_messages {
+		return fmt.Errorf("receipt must name between 1 and %d messages", max_receipt_messages)
+	}
+	for _, id := range receipt.MessageIDs {
+		if raw, err := hex.DecodeString(id); err != nil || len(raw) != sha256.Size || hex.EncodeToString(raw) != id {
+			return fmt.Errorf("receipt message id %q is not a lowercase hex SHA-256", id)
+		}
+	}
+	return nil
+}
@@ -1,12 +1,12 @@
 package dm
 
 import (
-	"bytes"
 	"encoding/base64"
-	"encoding/gob"
 	"errors"
 	"fmt"
-	"strings"
+	"math"
+	"sort"
+	"time"
 
 	mls "github.com/cisco/go-mls"
 	syntax "github.com/cisco/go-tls-syntax"
@@ -14,51 +14,155 @@ import (
 	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
 )
 
+// Participant is one identity and every group it is in. Sessions is keyed by
+// the base64 group ID; the entry points take that key as group_id_b64, where an
+// empty value means the participant's only group.
 type Participant struct {
-	Name       string
-	InitSecret []byte
-	State      *mls.State
-	Pending    *PendingCommit
+	Name string
+	// IdentitySecret derives the signature key behind the participant's
+	// credential; InitSecret derives the init key of its reusable KeyPackage.
+	IdentitySecret []byte
+	InitSecret     []byte
+	// Suite is the cipher suite of the participant's KeyPackage and of every
+	// group it creates or joins.
+	Suite     mls.CipherSuite
+	Sessions  map[string]*Session
+	Retention RetentionPolicy
+	Padding   PaddingPolicy
+	Pool      []*PooledKeyPackage
+	// IdentityBinding, once set by BindIdentity, goes into every KeyPackage
+	// the participant builds.
+	IdentityBinding *IdentityBinding
+	// Mode is set when the participant is created and never changes.
+	Mode Mode
+	// Lifetime is the validity of the reusable KeyPackage, drawn with
+	// InitSecret. It is zero in ModeDeterministic.
+	Lifetime Lifetime
+	Rotation RotationPolicy
 }
 
+// Session is the participant's state in one group. Pending holds the
+// participant's own commits in the order they were made, each built on the
+// previous one's next state, until they are echoed back and applied.
+type Session struct {
+	State   *mls.State
+	Pending []*PendingCommit
+	// PastEpochs keeps earlier epochs, newest first, for late application
+	// messages, as the participant's RetentionPolicy allows.
+	PastEpochs []*mls.State
+	// JoinedEpoch is the epoch the participant entered the group at: 0 for the
+	// creator, the Welcome's epoch for a joiner.
+	JoinedEpoch uint64
+	// Left is set by Leave. The participant still reads the group until a
+	// commit removes it, but sends nothing more.
+	Left bool
+	// Applied holds the identities (see commit_id) of the latest commits
+	// applied in the group, newest first, so one delivered again is
+	// recognised however it was framed.
+	Applied [][]byte
+	// Admins are the credential identities SetAdmins allows to add and
+	// remove members, sorted; empty lets every member.
+	Admins []string
+	// SentSinceRotation counts the application messages sent since the
+	// participant's leaf last changed, and RotatedEpoch is the epoch that
+	// change took effect in, or 0 if it has not changed since joining.
+	SentSinceRotation uint32
+	RotatedEpoch      uint64
+}
+
+// ErrRemoved is returned by CommitApply for a commit that removes the caller.
+// The group state is left as it was; the participant can only rejoin through a
+// new Welcome.
+var ErrRemoved = errors.New("participant removed from group")
+
+// ErrLeft is returned for a message or commit the participant tries to send to
+// a group it has left through Leave.
+var ErrLeft = errors.New("participant has left the group")
+
 type PendingCommit struct {
 	Commit    []byte
 	Welcome   []byte
 	NextState *mls.State
 }
 
-func init() {
-	gob.Register(&mls.State{})
-	gob.Register(&mls.MLSPlaintext{})
-	gob.Register(&mls.Welcome{})
-	gob.Register(&PendingCommit{})
-	gob.Register(&Participant{})
-	prime_gob_registrations()
+// KeyPackage creates the participant if participant_b64 is empty and returns
+// its KeyPackage. suite_name picks a new participant's cipher suite (empty for
+// DefaultCipherSuite); for an existing participant it must be empty or match.
+// A new participant is in ModeDeterministic; KeyPackageWithMode picks.
+func KeyPackage(participant_b64, name, suite_name string, seed int64) (string, string, error) {
+	return keypackage(participant_b64, name, suite_name, nil, seed)
 }
 
-func KeyPackage(participant_b64, name string, seed int64) (string, string, error) {
+// KeyPackageWithMode is KeyPackage creating the participant in mode. For an
+// existing participant mode must match the one it was created in.
+func KeyPackageWithMode(participant_b64, name, suite_name string, mode Mode, seed int64) (string, string, error) {
+	if mode != ModeDeterministic && mode != ModeSecure {
+		return "", "", fmt.Errorf("unknown mode %d", mode)
+	}
+	return keypackage(participant_b64, name, suite_name, &mode, seed)
+}
+
+// scrub_keypackage is the Zeroize keypackage runs on its way out; tests swap
+// it to see which participant was wiped.
+var scrub_keypackage = Zeroize
+
+func keypackage(participant_b64, name, suite_name string, mode *Mode, seed int64) (string, string, error) {
 	if name == "" {
 		return "", "", errors.New("participant name is required")
 	}
-	rng := harness.DeterministicRNGWithSeed(seed)
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
 
 	participant, err := decode_participant(participant_b64)
 	if err != nil {
 		return "", "", fmt.Errorf("decode participant: %w", err)
 	}
+	// participant is replaced below for a new identity, so the scrub reads it
+	// when it runs rather than when it is deferred.
+	defer func() { scrub_keypackage(participant) }()
 	if participant == nil {
-		participant = &Participant{Name: name, InitSecret: harness.RandomBytes(rng, 32)}
+		suite := DefaultCipherSuite
+		if suite_name != "" {
+			if suite, err = ParseCipherSuite(suite_name); err != nil {
+				return "", "", err
+			}
+		}
+		participant = &Participant{Name: name, Suite: suite, Retention: DefaultRetention}
+		if mode != nil {
+			participant.Mode = *mode
+		}
+	} else if err := check_suite(participant, suite_name); err != nil {
+		return "", "", err
+	} else if mode != nil && *mode != participant.Mode {
+		return "", "", fmt.Errorf("participant is in %s mode, not %s", participant.Mode, *mode)
 	}
-	if len(participant.InitSecret) == 0 {
-		participant.InitSecret = harness.RandomBytes(rng, 32)
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", err
 	}
+	defer release()
+
 	if participant.Name == "" {
 		participant.Name = name
 	}
+	if len(participant.IdentitySecret) == 0 {
+		participant.IdentitySecret = fresh_secret(rng, identity_secret_label)
+	}
+	if shares_secret(participant) {
+		if err := split_shared_secret(participant, rng); err != nil {
+			return "", "", err
+		}
+	}
+	if len(participant.InitSecret) == 0 {
+		participant.InitSecret = fresh_secret(rng, init_secret_label)
+		participant.Lifetime = new_lifetime(participant)
+	} else if participant.Lifetime.expired(Now()) {
+		// Peers refuse to add an expired KeyPackage; the pool keeps it for
+		// a Welcome made before it ran out.
+		if err := retire_keypackage(participant, participant.IdentityBinding, rng); err != nil {
+			return "", "", err
+		}
+	}
 
-	_, kp, err := build_identity_and_keypackage(participant.InitSecret, participant.Name)
+	_, kp, err := build_identity_and_keypackage(participant)
 	if err != nil {
 		return "", "", fmt.Errorf("create keypackage: %w", err)
 	}
@@ -72,30 +176,32 @@ func KeyPackage(participant_b64, name string, seed int64) (string, string, error
 		return "", "", fmt.Errorf("encode participant: %w", err)
 	}
 
-	return participant_b64, base64.StdEncoding.EncodeToString(kp_bytes), nil
+	return participant_b64, encode_artifact(artifact_keypackage, nil, 0, kp_bytes), nil
 }
 
-func Init(participant_b64, peer_kp_b64, group_id_b64 string, seed int64) (string, string, string, error) {
+// Init creates a group with one peer. suite_name, if given, must be the
+// participant's cipher suite, and the peer's KeyPackage must be in it too.
+func Init(participant_b64, peer_kp_b64, group_id_b64, suite_name string, seed int64) (string, string, string, error) {
 	if participant_b64 == "" {
 		return "", "", "", errors.New("participant is required")
 	}
 	if peer_kp_b64 == "" {
 		return "", "", "", errors.New("peer keypackage is required")
 	}
-	return initWithPeers(participant_b64, []string{peer_kp_b64}, group_id_b64, seed)
+	return initWithPeers(participant_b64, []string{peer_kp_b64}, group_id_b64, suite_name, seed)
 }
 
-func InitMany(participant_b64 string, peer_kps_b64 []string, group_id_b64 string, seed int64) (string, string, string, error) {
+func InitMany(participant_b64 string, peer_kps_b64 []string, group_id_b64, suite_name string, seed int64) (string, string, string, error) {
 	if participant_b64 == "" {
 		return "", "", "", errors.New("participant is required")
 	}
 	if err := validatePeerKeyPackages(peer_kps_b64, 2); err != nil {
 		return "", "", "", err
 	}
-	return initWithPeers(participant_b64, peer_kps_b64, group_id_b64, seed)
+	return initWithPeers(participant_b64, peer_kps_b64, group_id_b64, suite_name, seed)
 }
 
-func AddMany(participant_b64 string, peer_kps_b64 []string, seed int64) (string, string, string, []string, error) {
+func AddMany(participant_b64, group_id_b64 string, peer_kps_b64 []string, seed int64) (string, string, string, []string, error) {
 	if participant_b64 == "" {
 		return "", "", "", nil, errors.New("participant is required")
 	}
@@ -107,13 +213,22 @@ func AddMany(participant_b64 string, peer_kps_b64 []string, seed int64) (string,
 	if err != nil {
 		return "", "", "", nil, fmt.Errorf("decode participant: %w", err)
 	}
-	if participant == nil || participant.State == nil {
-		return "", "", "", nil, errors.New("participant state not initialized")
+	defer Zeroize(participant)
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return "", "", "", nil, err
 	}
 
-	rng := harness.DeterministicRNGWithSeed(seed)
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
+	// A commit still pending is not overwritten; this one builds on it.
+	state := working_state(session)
+	if err := check_admin(session, state, state.Index); err != nil {
+		return "", "", "", nil, err
+	}
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", "", nil, err
+	}
+	defer release()
 
 	proposals := make([]string, 0, len(peer_kps_b64))
 	for _, peer_kp_b64 := range peer_kps_b64 {
@@ -121,8 +236,17 @@ func AddMany(participant_b64 string, peer_kps_b64 []string, seed int64) (string,
 		if err != nil {
 			return "", "", "", nil, fmt.Errorf("parse peer keypackage: %w", err)
 		}
+		if err := check_peer_suite(peer_kp, state.CipherSuite); err != nil {
+			return "", "", "", nil, err
+		}
+		if err := check_peer_keypackage(peer_kp); err != nil {
+			return "", "", "", nil, fmt.Errorf("peer keypackage: %w", err)
+		}
+		if err := check_peer_version(peer_kp, state); err != nil {
+			return "", "", "", nil, err
+		}
 
-		add, err := participant.State.Add(peer_kp)
+		add, err := state.Add(peer_kp)
 		if err != nil {
 			return "", "", "", nil, fmt.Errorf("add peer: %w", err)
 		}
@@ -130,14 +254,14 @@ func AddMany(participant_b64 string, peer_kps_b64 []string, seed int64) (string,
 		if err != nil {
 			return "", "", "", nil, fmt.Errorf("marshal add proposal: %w", err)
 		}
-		proposals = append(proposals, base64.StdEncoding.EncodeToString(add_bytes))
-		if _, err := participant.State.Handle(add); err != nil {
+		proposals = append(proposals, encode_artifact(artifact_proposal, add.GroupID, add.Epoch, add_bytes))
+		if _, err := state.Handle(add); err != nil {
 			return "", "", "", nil, fmt.Errorf("handle add: %w", err)
 		}
 	}
 
-	commit_secret := harness.RandomBytes(rng, 32)
-	commit_pt, welcome, next_state, err := participant.State.Commit(commit_secret)
+	commit_secret := random_bytes(rng, 32)
+	commit_pt, welcome, next_state, err := state.Commit(commit_secret)
 	if err != nil {
 		return "", "", "", nil, fmt.Errorf("commit: %w", err)
 	}
@@ -151,49 +275,72 @@ func AddMany(participant_b64 string, peer_kps_b64 []string, seed int64) (string,
 		return "", "", "", nil, fmt.Errorf("marshal welcome: %w", err)
 	}
 
-	participant.Pending = &PendingCommit{Commit: commit_bytes, Welcome: welcome_bytes, NextState: next_state}
+	push_pending(session, commit_bytes, welcome_bytes, next_state)
 
 	participant_b64, err = encode_participant(participant)
 	if err != nil {
 		return "", "", "", nil, fmt.Errorf("encode participant: %w", err)
 	}
 
-	return participant_b64, base64.StdEncoding.EncodeToString(welcome_bytes), base64.StdEncoding.EncodeToString(commit_bytes), proposals, nil
+	return participant_b64, encode_artifact(artifact_welcome, next_state.GroupID, next_state.Epoch, welcome_bytes), encode_artifact(artifact_commit, commit_pt.GroupID, commit_pt.Epoch, commit_bytes), proposals, nil
 }
 
-func initWithPeers(participant_b64 string, peer_kps_b64 []string, group_id_b64 string, seed int64) (string, string, string, error) {
-	group_id, err := base64.StdEncoding.DecodeString(group_id_b64)
+func initWithPeers(participant_b64 string, peer_kps_b64 []string, group_id_b64, suite_name string, seed int64) (string, string, string, error) {
+	group_id, err := parse_group_id(group_id_b64)
 	if err != nil {
-		return "", "", "", fmt.Errorf("decode group-id: %w", err)
+		return "", "", "", err
 	}
 
 	participant, err := decode_participant(participant_b64)
 	if err != nil {
 		return "", "", "", fmt.Errorf("decode participant: %w", err)
 	}
+	defer Zeroize(participant)
 	if participant == nil {
 		return "", "", "", errors.New("participant state not initialized")
 	}
-	rng := harness.DeterministicRNGWithSeed(seed)
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
-
-	sig_priv, kp, err := build_identity_and_keypackage(participant.InitSecret, participant.Name)
+	if err := check_suite(participant, suite_name); err != nil {
+		return "", "", "", err
+	}
+	if _, ok := participant.Sessions[base64.StdEncoding.EncodeToString(group_id)]; ok {
+		return "", "", "", fmt.Errorf("already in group %s", group_id_b64)
+	}
+	rng, release, err := participant_random(participant, seed)
 	if err != nil {
-		return "", "", "", fmt.Errorf("build identity: %w", err)
+		return "", "", "", err
 	}
+	defer release()
 
-	state, err := mls.NewEmptyState(group_id, participant.InitSecret, sig_priv, *kp)
+	sig_priv, kp, err := build_identity_and_keypackage(participant)
 	if err != nil {
-		return "", "", "", fmt.Errorf("create group: %w", err)
+		return "", "", "", fmt.Errorf("build identity: %w", err)
 	}
 
+	peer_kps := make([]mls.KeyPackage, 0, len(peer_kps_b64))
 	for _, peer_kp_b64 := range peer_kps_b64 {
 		peer_kp, err := parse_keypackage(peer_kp_b64)
 		if err != nil {
 			return "", "", "", fmt.Errorf("parse peer keypackage: %w", err)
 		}
+		if err := check_peer_suite(peer_kp, kp.CipherSuite); err != nil {
+			return "", "", "", err
+		}
+		if err := check_peer_keypackage(peer_kp); err != nil {
+			return "", "", "", fmt.Errorf("peer keypackage: %w", err)
+		}
+		peer_kps = append(peer_kps, peer_kp)
+	}
+	extensions, err := new_group_extensions(peer_kps)
+	if err != nil {
+		return "", "", "", err
+	}
 
+	state, err := mls.NewEmptyStateWithExtensions(group_id, participant.InitSecret, sig_priv, *kp, extensions)
+	if err != nil {
+		return "", "", "", fmt.Errorf("create group: %w", err)
+	}
+
+	for _, peer_kp := range peer_kps {
 		add, err := state.Add(peer_kp)
 		if err != nil {
 			return "", "", "", fmt.Errorf("add peer: %w", err)
@@ -203,7 +350,7 @@ func initWithPeers(participant_b64 string, peer_kps_b64 []string, group_id_b64 s
 		}
 	}
 
-	commit_secret := harness.RandomBytes(rng, 32)
+	commit_secret := random_bytes(rng, 32)
 	commit_pt, welcome, next_state, err := state.Commit(commit_secret)
 	if err != nil {
 		return "", "", "", fmt.Errorf("commit: %w", err)
@@ -218,15 +365,14 @@ func initWithPeers(participant_b64 string, peer_kps_b64 []string, group_id_b64 s
 		return "", "", "", fmt.Errorf("marshal welcome: %w", err)
 	}
 
-	participant.State = state
-	participant.Pending = &PendingCommit{Commit: commit_bytes, Welcome: welcome_bytes, NextState: next_state}
+	push_pending(add_session(participant, state), commit_bytes, welcome_bytes, next_state)
 
 	participant_b64, err = encode_participant(participant)
 	if err != nil {
 		return "", "", "", fmt.Errorf("encode participant: %w", err)
 	}
 
-	return participant_b64, base64.StdEncoding.EncodeToString(welcome_bytes), base64.StdEncoding.EncodeToString(commit_bytes), nil
+	return participant_b64, encode_artifact(artifact_welcome, next_state.GroupID, next_state.Epoch, welcome_bytes), encode_artifact(artifact_commit, commit_pt.GroupID, commit_pt.Epoch, commit_bytes), nil
 }
 
 func validatePeerKeyPackages(peer_kps_b64 []string, minCount int) error {
@@ -244,47 +390,88 @@ func validatePeerKeyPackages(peer_kps_b64 []string, minCount int) error {
 	return nil
 }
 
-func Join(participant_b64, welcome_b64 string) (string, error) {
+// Join adds the group the Welcome is for. A non-empty group_id_b64 must match
+// it, so a client can refuse a Welcome for a group it did not expect.
+func Join(participant_b64, group_id_b64, welcome_b64 string) (string, error) {
 	if participant_b64 == "" {
 		return "", errors.New("participant is required")
 	}
 	if welcome_b64 == "" {
 		return "", errors.New("welcome is required")
 	}
+	if group_id_b64 != "" {
+		if _, err := parse_group_id(group_id_b64); err != nil {
+			return "", err
+		}
+	}
 
 	participant, err := decode_participant(participant_b64)
 	if err != nil {
 		return "", fmt.Errorf("decode participant: %w", err)
 	}
+	defer Zeroize(participant)
 	if participant == nil {
 		return "", errors.New("participant state not initialized")
 	}
 
-	welcome_bytes, err := base64.StdEncoding.DecodeString(welcome_b64)
+	welcome_bytes, header, err := decode_artifact(artifact_welcome, welcome_b64)
 	if err != nil {
-		return "", fmt.Errorf("decode welcome: %w", err)
+		return "", fmt.Errorf("%w: decode welcome: %w", ErrBadWelcome, err)
 	}
 	var welcome mls.Welcome
 	if _, err := syntax.Unmarshal(welcome_bytes, &welcome); err != nil {
-		return "", fmt.Errorf("unmarshal welcome: %w", err)
+		return "", fmt.Errorf("%w: unmarshal welcome: %w", ErrBadWelcome, err)
+	}
+	if welcome.CipherSuite != participant.Suite {
+		return "", fmt.Errorf("%w: welcome uses cipher suite %s but the participant uses %s; mixed-suite groups are not supported", ErrBadWelcome, welcome.CipherSuite, participant.Suite)
 	}
 
-	sig_priv, kp, err := build_identity_and_keypackage(participant.InitSecret, participant.Name)
+	sig_priv, kp, err := build_identity_and_keypackage(participant)
 	if err != nil {
 		return "", fmt.Errorf("build identity: %w", err)
 	}
+	init_secret := participant.InitSecret
+	pooled := welcome_pool_entry(participant, &welcome)
+	if pooled != nil {
+		if pooled.Consumed {
+			return "", fmt.Errorf("%w: welcome is for a pooled keypackage that was already consumed", ErrBadWelcome)
+		}
+		var pooled_kp mls.KeyPackage
+		if _, err := syntax.Unmarshal(pooled.KeyPackage, &pooled_kp); err != nil {
+			return "", fmt.Errorf("unmarshal pooled keypackage: %w", err)
+		}
+		kp, init_secret = &pooled_kp, pooled.InitSecret
+	} else if kp, err = reusable_keypackage_for(participant, &welcome, kp); err != nil {
+		return "", fmt.Errorf("build identity: %w", err)
+	}
 
-	rng := harness.DeterministicRNG()
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
-
-	state, err := mls.NewJoinedState(participant.InitSecret, []mls.SignaturePrivateKey{sig_priv}, []mls.KeyPackage{*kp}, welcome)
+	state, err := mls.NewJoinedState(init_secret, []mls.SignaturePrivateKey{sig_priv}, []mls.KeyPackage{*kp}, welcome)
 	if err != nil {
-		return "", fmt.Errorf("join state: %w", err)
+		return "", fmt.Errorf("%w: join state: %w", ErrBadWelcome, err)
+	}
+	if err := check_app_version("welcome", group_app_version(state)); err != nil {
+		return "", err
+	}
+	if err := check_tree_bindings(state); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBadWelcome, err)
+	}
+	if err := check_group_id(state.GroupID); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBadWelcome, err)
+	}
+	if err := header.check(state.GroupID, state.Epoch); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBadWelcome, err)
+	}
+	joined := base64.StdEncoding.EncodeToString(state.GroupID)
+	if group_id_b64 != "" && group_id_b64 != joined {
+		return "", fmt.Errorf("%w: welcome is for group %s, not %s", ErrBadWelcome, joined, group_id_b64)
+	}
+	if _, ok := participant.Sessions[joined]; ok {
+		return "", fmt.Errorf("already in group %s", joined)
+	}
+	add_session(participant, state)
+	if pooled != nil {
+		pooled.Consumed, pooled.InitSecret = true, nil
 	}
-
-	participant.State = state
-	participant.Pending = nil
 
 	participant_b64, err = encode_participant(participant)
 	if err != nil {
@@ -294,63 +481,125 @@ func Join(participant_b64, welcome_b64 string) (string, error) {
 	return participant_b64, nil
 }
 
-func CommitApply(participant_b64, commit_b64 string) (string, bool, error) {
+// ApplyResult is what CommitApplyWithResult did with a message.
+type ApplyResult struct {
+	// Noop is set when the message changed nothing: a proposal already queued
+	// or a commit already applied.
+	Noop bool
+	// AlreadyApplied is set for a commit the participant applied before, or
+	// one from before it joined, which its Welcome covers.
+	AlreadyApplied bool
+}
+
+// CommitApply is CommitApplyWithResult reporting only whether the message was
+// a noop.
+func CommitApply(participant_b64, group_id_b64, commit_b64 string) (string, bool, error) {
+	participant_b64, result, err := CommitApplyWithResult(participant_b64, group_id_b64, commit_b64)
+	return participant_b64, result.Noop, err
+}
+
+// CommitApplyWithResult applies a commit, ours once it is echoed back or
+// another member's. A commit delivered again, which at-least-once delivery
+// services do, is recognised by its identity and reported as already applied
+// without touching the state. Any other commit for an epoch the participant
+// has moved past fails with ErrStaleCommit.
+func CommitApplyWithResult(participant_b64, group_id_b64, commit_b64 string) (string, ApplyResult, error) {
 	if participant_b64 == "" {
-		return "", false, errors.New("participant is required")
+		return "", ApplyResult{}, errors.New("participant is required")
 	}
 	if commit_b64 == "" {
-		return "", false, errors.New("commit is required")
+		return "", ApplyResult{}, errors.New("commit is required")
 	}
 
 	participant, err := decode_participant(participant_b64)
 	if err != nil {
-		return "", false, fmt.Errorf("decode participant: %w", err)
-	}
-	if participant == nil || participant.State == nil {
-		return "", false, errors.New("participant state not initialized")
+		return "", ApplyResult{}, fmt.Errorf("decode participant: %w", err)
 	}
+	defer Zeroize(participant)
 
-	commit_bytes, err := base64.StdEncoding.DecodeString(commit_b64)
+	commit_bytes, header, err := decode_artifact(artifact_commit, commit_b64)
 	if err != nil {
-		return "", false, fmt.Errorf("decode commit: %w", err)
+		return "", ApplyResult{}, fmt.Errorf("decode commit: %w", err)
 	}
 	var commit_pt mls.MLSPlaintext
 	if _, err := syntax.Unmarshal(commit_bytes, &commit_pt); err != nil {
-		return "", false, fmt.Errorf("unmarshal commit: %w", err)
+		return "", ApplyResult{}, fmt.Errorf("unmarshal commit: %w", err)
+	}
+	if err := header.check(commit_pt.GroupID, commit_pt.Epoch); err != nil {
+		return "", ApplyResult{}, err
+	}
+	session, err := message_session(participant, group_id_b64, commit_pt.GroupID)
+	if err != nil {
+		return "", ApplyResult{}, err
 	}
 
-	noop := false
-	if participant.Pending != nil {
-		if !bytes.Equal(participant.Pending.Commit, commit_bytes) {
-			return "", false, errors.New("commit mismatch for pending apply")
+	var result ApplyResult
+	if commit_pt.Content.Proposal != nil {
+		// Proposals used to share this entry point; route them to the same
+		// checks as HandleProposal, even while our own commit is pending.
+		if result.Noop, err = handle_proposal(session, &commit_pt); err != nil {
+			return "", ApplyResult{}, err
 		}
-		if participant.Pending.NextState == nil {
-			return "", false, errors.New("pending commit missing next state")
+	} else if id, err := plaintext_commit_id(&commit_pt); err != nil {
+		return "", ApplyResult{}, err
+	} else if applied_before(session, id, commit_bytes) || uint64(commit_pt.Epoch) < session.JoinedEpoch {
+		result = ApplyResult{Noop: true, AlreadyApplied: true}
+	} else if index := pending_index(session, id); index == 0 {
+		if err := apply_next_pending(session, participant.Retention); err != nil {
+			return "", ApplyResult{}, err
 		}
-		participant.State = participant.Pending.NextState
-		participant.Pending = nil
+	} else if index > 0 {
+		return "", ApplyResult{}, fmt.Errorf("pending commit %s (epoch %d) must be applied first", commit_hash(session.Pending[0].Commit), pending_epoch(session.Pending[0]))
+	} else if len(session.Pending) > 0 && commit_pt.Epoch == session.State.Epoch {
+		return "", ApplyResult{}, fmt.Errorf("%w: epoch %d commit %s; discard it to apply this one", ErrPendingConflict, commit_pt.Epoch, commit_hash(session.Pending[0].Commit))
+	} else if commit_pt.Epoch < session.State.Epoch {
+		return "", ApplyResult{}, fmt.Errorf("%w: epoch %d commit %s, current epoch %d", ErrStaleCommit, commit_pt.Epoch, commit_hash(commit_bytes), session.State.Epoch)
+	} else if commit_pt.Epoch > session.State.Epoch {
+		return "", ApplyResult{}, fmt.Errorf("%w: commit is for epoch %d, ahead of current epoch %d", ErrEpochMismatch, commit_pt.Epoch, session.State.Epoch)
 	} else {
-		next_state, err := participant.State.Handle(&commit_pt)
+		if err := check_commit_admins(session, session.State, &commit_pt); err != nil {
+			return "", ApplyResult{}, err
+		}
+		if removes_own_leaf(session.State, &commit_pt) {
+			return "", ApplyResult{}, fmt.Errorf("%w (epoch %d)", ErrRemoved, commit_pt.Epoch)
+		}
+		next_state, err := session.State.Handle(&commit_pt)
 		if err != nil {
-			if strings.Contains(err.Error(), "epoch mismatch") && participant.State.Epoch == commit_pt.Epoch+1 {
-				noop = true
-			} else {
-				return "", false, fmt.Errorf("handle commit: %w", err)
-			}
-		} else if next_state != nil {
-			participant.State = next_state
+			return "", ApplyResult{}, fmt.Errorf("handle commit: %w", err)
+		}
+		if next_state != nil {
+			advance_state(session, next_state, participant.Retention)
+			record_applied(session, id)
 		}
 	}
 
 	participant_b64, err = encode_participant(participant)
 	if err != nil {
-		return "", noop, fmt.Errorf("encode participant: %w", err)
+		return "", result, fmt.Errorf("encode participant: %w", err)
 	}
 
-	return participant_b64, noop, nil
+	return participant_b64, result, nil
+}
+
+// Encrypt sends plaintext as an application message, framed and padded if the
+// participant has a PaddingPolicy.
+func Encrypt(participant_b64, group_id_b64, plaintext string) (string, string, error) {
+	return protect(participant_b64, group_id_b64, Message{Body: plaintext}, false)
 }
 
-func Encrypt(participant_b64, plaintext string) (string, string, error) {
+// Decrypt returns the body of an application message. Framed messages from
+// EncryptMessage yield their body; DecryptMessage also returns the metadata.
+func Decrypt(participant_b64, group_id_b64, ciphertext_b64 string) (string, string, error) {
+	participant_b64, message, err := open_message(participant_b64, group_id_b64, ciphertext_b64)
+	if err != nil {
+		return "", "", err
+	}
+	return participant_b64, message.Body, nil
+}
+
+// protect frames message when framed is set or the padding policy needs a
+// frame, and otherwise sends its body bare.
+func protect(participant_b64, group_id_b64 string, message Message, framed bool) (string, string, error) {
 	if participant_b64 == "" {
 		return "", "", errors.New("participant is required")
 	}
@@ -358,52 +607,161 @@ func Encrypt(participant_b64, plaintext string) (string, string, error) {
 	if err != nil {
 		return "", "", fmt.Errorf("decode participant: %w", err)
 	}
-	if participant == nil || participant.State == nil {
-		return "", "", errors.New("participant state not initialized")
-	}
-	ct, err := participant.State.Protect([]byte(plaintext))
+	defer Zeroize(participant)
+	session, err := find_session(participant, group_id_b64)
 	if err != nil {
-		return "", "", fmt.Errorf("protect: %w", err)
+		return "", "", err
 	}
-	ct_bytes, err := syntax.Marshal(*ct)
+	ct, err := protect_one(participant, session, message, framed)
 	if err != nil {
-		return "", "", fmt.Errorf("marshal ciphertext: %w", err)
+		return "", "", err
 	}
 	participant_b64, err = encode_participant(participant)
 	if err != nil {
 		return "", "", fmt.Errorf("encode participant: %w", err)
 	}
-	return participant_b64, base64.StdEncoding.EncodeToString(ct_bytes), nil
+	return participant_b64, ct, nil
+}
+
+// protect_one encrypts one message in session, advancing the sender ratchet
+// of the decoded participant.
+func protect_one(participant *Participant, session *Session, message Message, framed bool) (string, error) {
+	if session.Left {
+		return "", ErrLeft
+	}
+	data := []byte(message.Body)
+	if framed || len(participant.Padding.Buckets) > 0 {
+		var err error
+		if data, err = frame_message(message, participant.Padding); err != nil {
+			return "", err
+		}
+	}
+	ct, err := session.State.Protect(data)
+	if err != nil {
+		return "", fmt.Errorf("protect: %w", err)
+	}
+	ct_bytes, err := syntax.Marshal(*ct)
+	if err != nil {
+		return "", fmt.Errorf("marshal ciphertext: %w", err)
+	}
+	if session.SentSinceRotation < math.MaxUint32 {
+		session.SentSinceRotation++
+	}
+	return encode_artifact(artifact_ciphertext, ct.GroupID, ct.Epoch, ct_bytes), nil
 }
 
-func Decrypt(participant_b64, ciphertext_b64 string) (string, string, error) {
+func open_message(participant_b64, group_id_b64, ciphertext_b64 string) (string, *ReceivedMessage, error) {
 	if participant_b64 == "" {
-		return "", "", errors.New("participant is required")
+		return "", nil, errors.New("participant is required")
 	}
 	participant, err := decode_participant(participant_b64)
 	if err != nil {
-		return "", "", fmt.Errorf("decode participant: %w", err)
+		return "", nil, fmt.Errorf("decode participant: %w", err)
 	}
-	if participant == nil || participant.State == nil {
-		return "", "", errors.New("participant state not initialized")
+	defer Zeroize(participant)
+	message, err := open_one(participant, group_id_b64, ciphertext_b64)
+	if err != nil {
+		return "", nil, err
 	}
-	ct_bytes, err := base64.StdEncoding.DecodeString(ciphertext_b64)
+	participant_b64, err = encode_participant(participant)
 	if err != nil {
-		return "", "", fmt.Errorf("decode ciphertext: %w", err)
+		return "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, message, nil
+}
+
+// open_one decrypts one application message against the decoded participant.
+func open_one(participant *Participant, group_id_b64, ciphertext_b64 string) (*ReceivedMessage, error) {
+	ct_bytes, header, err := decode_artifact(artifact_ciphertext, ciphertext_b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode ciphertext: %w", err)
 	}
 	var ct mls.MLSCiphertext
 	if _, err := syntax.Unmarshal(ct_bytes, &ct); err != nil {
-		return "", "", fmt.Errorf("unmarshal ciphertext: %w", err)
+		return nil, fmt.Errorf("unmarshal ciphertext: %w", err)
+	}
+	if err := header.check(ct.GroupID, ct.Epoch); err != nil {
+		return nil, err
 	}
-	pt, err := participant.State.Unprotect(&ct)
+	session, err := message_session(participant, group_id_b64, ct.GroupID)
 	if err != nil {
-		return "", "", fmt.Errorf("unprotect: %w", err)
+		return nil, err
 	}
-	participant_b64, err = encode_participant(participant)
+	pt, sender, state, err := unprotect(session, &ct, participant.Retention)
 	if err != nil {
-		return "", "", fmt.Errorf("encode participant: %w", err)
+		return nil, err
 	}
-	return participant_b64, string(pt), nil
+	message, err := received_message(state, sender, pt)
+	if err != nil {
+		return nil, err
+	}
+	message.MessageID = message_id(ct_bytes)
+	return message, nil
+}
+
+// Groups lists the base64 group IDs the participant has a session for, sorted.
+func Groups(participant_b64 string) ([]string, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return nil, errors.New("participant is required")
+	}
+	return group_ids(participant), nil
+}
+
+func group_ids(participant *Participant) []string {
+	ids := make([]string, 0, len(participant.Sessions))
+	for id := range participant.Sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// find_session returns the session for group_id_b64, or the only session when
+// it is empty.
+func find_session(participant *Participant, group_id_b64 string) (*Session, error) {
+	if participant == nil || len(participant.Sessions) == 0 {
+		return nil, errors.New("participant state not initialized")
+	}
+	if group_id_b64 == "" {
+		if len(participant.Sessions) > 1 {
+			return nil, fmt.Errorf("participant is in %d groups; pass a group id", len(participant.Sessions))
+		}
+		for _, session := range participant.Sessions {
+			return session, nil
+		}
+	}
+	session, ok := participant.Sessions[group_id_b64]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNotMember, group_id_b64)
+	}
+	return session, nil
+}
+
+// message_session finds the session for a message that names its group. An
+// empty group_id_b64 routes by the message; otherwise the two must agree.
+func message_session(participant *Participant, group_id_b64 string, message_group []byte) (*Session, error) {
+	target := base64.StdEncoding.EncodeToString(message_group)
+	if group_id_b64 != "" && group_id_b64 != target {
+		return nil, fmt.Errorf("message is for group %s, not %s", target, group_id_b64)
+	}
+	if participant == nil || len(participant.Sessions) == 0 {
+		return nil, errors.New("participant state not initialized")
+	}
+	return find_session(participant, target)
+}
+
+func add_session(participant *Participant, state *mls.State) *Session {
+	if participant.Sessions == nil {
+		participant.Sessions = map[string]*Session{}
+	}
+	session := &Session{State: state, JoinedEpoch: uint64(state.Epoch)}
+	participant.Sessions[base64.StdEncoding.EncodeToString(state.GroupID)] = session
+	return session
 }
 
 func decode_participant(participant_b64 string) (*Participant, error) {
@@ -412,78 +770,112 @@ func decode_participant(participant_b64 string) (*Participant, error) {
 	}
 	data, err := base64.StdEncoding.DecodeString(participant_b64)
 	if err != nil {
-		return nil, fmt.Errorf("decode base64: %w", err)
+		return nil, fmt.Errorf("%w: decode base64: %w", ErrStateCorrupt, err)
 	}
-	var participant Participant
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&participant); err != nil {
-		return nil, fmt.Errorf("decode gob: %w", err)
+	if is_destroyed(data) {
+		return nil, ErrDestroyed
 	}
-
-	register_state_types(participant.State)
-	if participant.Pending != nil {
-		register_state_types(participant.Pending.NextState)
+	if is_sealed(data) {
+		key := current_state_key()
+		if key == nil {
+			return nil, ErrSealed
+		}
+		if data, err = key.open(data); err != nil {
+			return nil, err
+		}
 	}
-
-	return &participant, nil
+	participant, err := unmarshal_participant(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrStateCorrupt, err)
+	}
+	return participant, nil
 }
 
 func encode_participant(participant *Participant) (string, error) {
 	if participant == nil {
 		return "", errors.New("nil participant")
 	}
-	register_state_types(participant.State)
-	if participant.Pending != nil {
-		register_state_types(participant.Pending.NextState)
-		register_value(participant.Pending)
-	}
-
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(participant); err != nil {
+	data, err := marshal_participant(participant)
+	if err != nil {
 		return "", fmt.Errorf("encode participant: %w", err)
 	}
-	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	if key := current_state_key(); key != nil {
+		if data, err = key.seal(data); err != nil {
+			return "", fmt.Errorf("seal participant: %w", err)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
 }
 
-func prime_gob_registrations() {
-	rng := harness.DeterministicRNG()
-	restore := harness.OverrideCryptoRand(rng)
-	defer restore()
-
-	secret := harness.RandomBytes(rng, 32)
-	sig_priv, kp, err := build_identity_and_keypackage(secret, "prime")
+// build_identity_and_keypackage returns the participant's identity key and its
+// reusable KeyPackage.
+func build_identity_and_keypackage(participant *Participant) (mls.SignaturePrivateKey, *mls.KeyPackage, error) {
+	sig_priv, cred, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
 	if err != nil {
-		return
+		return mls.SignaturePrivateKey{}, nil, err
 	}
-	state, err := mls.NewEmptyState([]byte{0xAA}, secret, sig_priv, *kp)
+	if len(participant.InitSecret) == 0 {
+		return mls.SignaturePrivateKey{}, nil, errors.New("init secret required")
+	}
+	kp, err := build_keypackage(participant.Suite, participant.InitSecret, cred, sig_priv, participant.IdentityBinding, participant.Lifetime)
 	if err != nil {
-		return
+		return mls.SignaturePrivateKey{}, nil, err
 	}
-	register_state_types(state)
+	return sig_priv, kp, nil
 }
 
-func build_identity_and_keypackage(secret []byte, name string) (mls.SignaturePrivateKey, *mls.KeyPackage, error) {
+func build_identity(secret []byte, name string, suite mls.CipherSuite) (mls.SignaturePrivateKey, *mls.Credential, error) {
 	if len(secret) == 0 {
-		return mls.SignaturePrivateKey{}, nil, errors.New("init secret required")
+		return mls.SignaturePrivateKey{}, nil, errors.New("identity secret required")
 	}
-	suite := mls.X25519_AES128GCM_SHA256_Ed25519
 	scheme := suite.Scheme()
 	sig_priv, err := scheme.Derive(secret)
 	if err != nil {
 		return mls.SignaturePrivateKey{}, nil, fmt.Errorf("derive identity key: %w", err)
 	}
-	cred := mls.NewBasicCredential([]byte(name), scheme, sig_priv.PublicKey)
-	kp, err := mls.NewKeyPackageWithSecret(suite, secret, cred, sig_priv)
+	return sig_priv, mls.NewBasicCredential([]byte(name), scheme, sig_priv.PublicKey), nil
+}
+
+// build_keypackage signs a KeyPackage whose HPKE init key is derived from
+// init_secret, advertising AppProtocolVersion and carrying binding if it is
+// set, valid for lifetime or, when that is zero, the fixed deterministic
+// window.
+func build_keypackage(suite mls.CipherSuite, init_secret []byte, cred *mls.Credential, sig_priv mls.SignaturePrivateKey, binding *IdentityBinding, lifetime Lifetime) (*mls.KeyPackage, error) {
+	return build_keypackage_versioned(suite, init_secret, cred, sig_priv, binding, lifetime, true)
+}
+
+// build_keypackage_versioned is build_keypackage, leaving out the app version
+// when advertise is unset, as builds before AppProtocolVersion did.
+func build_keypackage_versioned(suite mls.CipherSuite, init_secret []byte, cred *mls.Credential, sig_priv mls.SignaturePrivateKey, binding *IdentityBinding, lifetime Lifetime, advertise bool) (*mls.KeyPackage, error) {
+	kp, err := mls.NewKeyPackageWithSecret(suite, init_secret, cred, sig_priv)
 	if err != nil {
-		return mls.SignaturePrivateKey{}, nil, fmt.Errorf("create key package: %w", err)
+		return nil, fmt.Errorf("create key package: %w", err)
+	}
+	if advertise {
+		if err := kp.Extensions.Add(app_version{Version: AppProtocolVersion}); err != nil {
+			return nil, fmt.Errorf("add app version: %w", err)
+		}
+	}
+	if binding != nil {
+		if err := kp.Extensions.Add(*binding); err != nil {
+			return nil, fmt.Errorf("add identity binding: %w", err)
+		}
+	}
+	if lifetime != (Lifetime{}) {
+		not_before, not_after := time.Unix(int64(lifetime.NotBefore), 0), time.Unix(int64(lifetime.NotAfter), 0)
+		if err := harness.MakeKeyPackageWithLifetime(kp, sig_priv, not_before, not_after); err != nil {
+			return nil, fmt.Errorf("set key package lifetime: %w", err)
+		}
+		return kp, nil
 	}
 	if err := harness.MakeKeyPackageDeterministic(kp, sig_priv); err != nil {
-		return mls.SignaturePrivateKey{}, nil, fmt.Errorf("stabilize key package: %w", err)
+		return nil, fmt.Errorf("stabilize key package: %w", err)
 	}
-	return sig_priv, kp, nil
+	return kp, nil
 }
 
 func parse_keypackage(b64 string) (mls.KeyPackage, error) {
-	data, err := base64.StdEncoding.DecodeString(b64)
+	data, _, err := decode_artifact(artifact_keypackage, b64)
 	if err != nil {
 		return mls.KeyPackage{}, fmt.Errorf("decode keypackage: %w", err)
 	}
@@ -493,31 +885,3 @@ func parse_keypackage(b64 string) (mls.KeyPackage, error) {
 	}
 	return kp, nil
 }
-
-func register_state_types(state *mls.State) {
-	if state == nil {
-		return
-	}
-
-	register_value(state.Keys)
-	register_value(state.Keys.HandshakeBaseKeys)
-	register_value(state.Keys.ApplicationBaseKeys)
-	register_value(state.Keys.HandshakeRatchets)
-	register_value(state.Keys.ApplicationRatchets)
-	register_value(state.Keys.HandshakeKeys)
-	register_value(state.Keys.ApplicationKeys)
-
-	for _, ratchet := range state.Keys.HandshakeRatchets {
-		register_value(ratchet)
-	}
-	for _, ratchet := range state.Keys.ApplicationRatchets {
-		register_value(ratchet)
-	}
-}
-
-func register_value(v interface{}) {
-	if v == nil {
-		return
-	}
-	gob.Register(v)
-}
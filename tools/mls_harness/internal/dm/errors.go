@@ -0,0 +1,74 @@
+package dm
+
+import "errors"
+
+// Failures a caller may branch on wrap one of the sentinel errors below or
+// ErrRemoved, ErrLeft, ErrPendingConflict, ErrOutsideWindow, ErrSealed,
+// ErrBadGroupID, ErrNotAdmin, ErrBadArtifact, ErrBadKeyPackage,
+// ErrUnsupportedVersion, ErrDestroyed, ErrSeedRefused, ErrDeterministicRefused,
+// ErrAttachmentCorrupt and ErrInvalidInput, so errors.Is finds them. ErrorCode names each with
+// a stable string for callers outside Go: the WASM bindings return it as
+// `code` and the HTTP API as `code` in the error body. Error messages may be
+// reworded; codes are not.
+var (
+	// ErrEpochMismatch is a commit, proposal or message for an epoch the
+	// participant is not in yet, or a proposal for one it has left.
+	ErrEpochMismatch = errors.New("epoch mismatch")
+	// ErrStaleCommit is a commit for an epoch the participant has moved past
+	// that it did not apply, such as the loser of a conflict.
+	ErrStaleCommit = errors.New("stale commit")
+	// ErrNotMember is an operation on a group the participant has no session
+	// for.
+	ErrNotMember = errors.New("not in group")
+	// ErrBadWelcome is a Welcome the participant cannot join through.
+	ErrBadWelcome = errors.New("bad welcome")
+	// ErrStateCorrupt is a participant blob that does not decode.
+	ErrStateCorrupt = errors.New("participant state is corrupt")
+	// ErrSenderRemoved is a message from a leaf that is not, or is no longer,
+	// a member of the group.
+	ErrSenderRemoved = errors.New("sender is not a member of the group")
+	// ErrInvalidInput is an argument refused before any MLS processing: over
+	// its size limit, or base64 that is not canonical.
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+var error_codes = []struct {
+	err  error
+	code string
+}{
+	{ErrEpochMismatch, "epoch_mismatch"},
+	{ErrStaleCommit, "stale_commit"},
+	{ErrNotMember, "not_member"},
+	{ErrBadWelcome, "bad_welcome"},
+	{ErrStateCorrupt, "state_corrupt"},
+	{ErrSenderRemoved, "sender_removed"},
+	{ErrRemoved, "removed"},
+	{ErrLeft, "left"},
+	{ErrPendingConflict, "pending_conflict"},
+	{ErrOutsideWindow, "outside_window"},
+	{ErrSealed, "sealed"},
+	{ErrBadGroupID, "bad_group_id"},
+	{ErrNotAdmin, "not_admin"},
+	{ErrBadArtifact, "bad_artifact"},
+	{ErrBadKeyPackage, "bad_keypackage"},
+	{ErrUnsupportedVersion, "unsupported_version"},
+	{ErrDestroyed, "destroyed"},
+	{ErrSeedRefused, "seed_refused"},
+	{ErrDeterministicRefused, "deterministic_refused"},
+	{ErrAttachmentCorrupt, "attachment_corrupt"},
+	{ErrInvalidInput, "invalid_input"},
+}
+
+// ErrorCode returns the stable code of the first sentinel err wraps, or "" for
+// nil and for errors outside the taxonomy, such as invalid arguments.
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, entry := range error_codes {
+		if errors.Is(err, entry.err) {
+			return entry.code
+		}
+	}
+	return ""
+}
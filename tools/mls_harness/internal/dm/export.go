@@ -0,0 +1,60 @@
+package dm
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// history_key_label is the exporter label of HistoryKey.
+const history_key_label = "polycentric mls history v1"
+
+// ExportSecret returns the group's current epoch and length bytes of its MLS
+// exporter under label and context, base64-encoded. Every member in the epoch
+// derives the same bytes and nobody outside it can, so a key derived from
+// them, for attachments, a search index or a presence channel, is bound to
+// that epoch. Past epochs keep no exporter secret, so a value for an epoch
+// must be exported while in it.
+func ExportSecret(participant_b64, group_id_b64, label, context_b64 string, length int) (uint64, string, error) {
+	if label == "" {
+		return 0, "", errors.New("label is required")
+	}
+	context, err := base64.StdEncoding.DecodeString(context_b64)
+	if err != nil {
+		return 0, "", fmt.Errorf("decode context: %w", err)
+	}
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return 0, "", err
+	}
+	defer Zeroize(participant)
+	// HKDF-Expand yields at most 255 hash lengths, and the label carries the
+	// length in 16 bits.
+	limit := 255 * session.State.CipherSuite.Constants().SecretSize
+	if limit > math.MaxUint16 {
+		limit = math.MaxUint16
+	}
+	if length < 1 || length > limit {
+		return 0, "", fmt.Errorf("length must be between 1 and %d bytes (got %d)", limit, length)
+	}
+	secret := session.State.Keys.Export(label, context, length)
+	return uint64(session.State.Epoch), base64.StdEncoding.EncodeToString(secret), nil
+}
+
+// HistoryKey returns the group's current epoch and a key for the message
+// history a member hands to those who joined at that epoch. The member who
+// adds someone derives it once it has applied the Add commit and encrypts the
+// history bundle under it; the new member derives the same key right after
+// Join, before it applies any later commit, and decrypts the bundle. Members
+// who joined later cannot.
+func HistoryKey(participant_b64, group_id_b64 string) (uint64, string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return 0, "", err
+	}
+	defer Zeroize(participant)
+	state := session.State
+	key := state.Keys.Export(history_key_label, state.GroupID, state.CipherSuite.Constants().SecretSize)
+	return uint64(state.Epoch), base64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,112 @@
+package dm
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// FuzzTarget is one byte-level entry point that receives untrusted input from the
+// network or from storage. Run returns an error for malformed input and must never
+// panic; the native fuzz tests and the harness fuzz subcommand both drive it.
+type FuzzTarget struct {
+	Name string
+	Run  func(data []byte) error
+}
+
+// FuzzTargets lists the decoders reachable from the WASM bridge, in the order the
+// fuzz subcommand runs them.
+var FuzzTargets = []FuzzTarget{
+	{Name: "keypackage", Run: fuzzKeyPackage},
+	{Name: "welcome", Run: fuzzWelcome},
+	{Name: "ciphertext", Run: fuzzCiphertext},
+	{Name: "participant", Run: fuzzParticipant},
+}
+
+// FuzzTargetByName looks up a target from FuzzTargets.
+func FuzzTargetByName(name string) (FuzzTarget, bool) {
+	for _, target := range FuzzTargets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return FuzzTarget{}, false
+}
+
+func fuzzKeyPackage(data []byte) error {
+	_, err := parse_keypackage(base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+func fuzzWelcome(data []byte) error {
+	var welcome mls.Welcome
+	_, err := syntax.Unmarshal(data, &welcome)
+	return err
+}
+
+func fuzzCiphertext(data []byte) error {
+	var ct mls.MLSCiphertext
+	_, err := syntax.Unmarshal(data, &ct)
+	return err
+}
+
+func fuzzParticipant(data []byte) error {
+	_, err := decode_participant(base64.StdEncoding.EncodeToString(data))
+	return err
+}
+
+// FuzzSeeds runs a deterministic DM exchange and returns the raw bytes of every
+// artifact it produced, keyed by target name, so fuzzing starts from valid inputs.
+func FuzzSeeds() (map[string][][]byte, error) {
+	alice, _, err := KeyPackage("", "alice", "", 1)
+	if err != nil {
+		return nil, fmt.Errorf("alice keypackage: %w", err)
+	}
+	bob, bob_kp, err := KeyPackage("", "bob", "", 2)
+	if err != nil {
+		return nil, fmt.Errorf("bob keypackage: %w", err)
+	}
+	group_id := base64.StdEncoding.EncodeToString([]byte("fuzz-seed"))
+	alice, welcome, commit, err := Init(alice, bob_kp, group_id, "", 3)
+	if err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+	alice_pending := alice
+	bob, err = Join(bob, group_id, welcome)
+	if err != nil {
+		return nil, fmt.Errorf("join: %w", err)
+	}
+	alice, _, err = CommitApply(alice, group_id, commit)
+	if err != nil {
+		return nil, fmt.Errorf("commit apply: %w", err)
+	}
+	alice, ciphertext, err := Encrypt(alice, group_id, "fuzz seed")
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	seeds := map[string][][]byte{}
+	add := func(target, b64 string) error {
+		data, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return fmt.Errorf("decode %s seed: %w", target, err)
+		}
+		seeds[target] = append(seeds[target], data)
+		return nil
+	}
+	for _, seed := range []struct{ target, b64 string }{
+		{"keypackage", bob_kp},
+		{"welcome", welcome},
+		{"ciphertext", ciphertext},
+		{"participant", alice},
+		{"participant", alice_pending},
+		{"participant", bob},
+	} {
+		if err := add(seed.target, seed.b64); err != nil {
+			return nil, err
+		}
+	}
+	return seeds, nil
+}
@@ -0,0 +1,32 @@
+package dm
+
+import "testing"
+
+// Native fuzz targets. Without -fuzz they replay the generated seeds, so
+// `go test ./...` stays fast; run one with e.g.
+//
+//	go test -run '^$' -fuzz '^FuzzWelcome$' -fuzztime 60s ./internal/dm
+func FuzzParseKeyPackage(f *testing.F) { fuzzTarget(f, "keypackage") }
+func FuzzWelcome(f *testing.F)         { fuzzTarget(f, "welcome") }
+func FuzzCiphertext(f *testing.F)      { fuzzTarget(f, "ciphertext") }
+func FuzzParticipant(f *testing.F)     { fuzzTarget(f, "participant") }
+
+func fuzzTarget(f *testing.F, name string) {
+	target, ok := FuzzTargetByName(name)
+	if !ok {
+		f.Fatalf("unknown fuzz target %q", name)
+	}
+	seeds, err := FuzzSeeds()
+	if err != nil {
+		f.Fatalf("generate seeds: %v", err)
+	}
+	for _, seed := range seeds[name] {
+		if err := target.Run(seed); err != nil {
+			f.Fatalf("seed rejected by %s: %v", name, err)
+		}
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_ = target.Run(data)
+	})
+}
@@ -0,0 +1,100 @@
+//go:build !tinygo
+// +build !tinygo
+
+package dm
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// The gob registrations below are only needed to read participant blobs written
+// before the versioned format in codec.go.
+func init() {
+	gob.Register(&mls.State{})
+	gob.Register(&mls.MLSPlaintext{})
+	gob.Register(&mls.Welcome{})
+	gob.Register(&PendingCommit{})
+	gob.Register(&Participant{})
+	gob.Register(&Session{})
+	prime_gob_registrations()
+}
+
+// gob_participant is the Participant layout gob blobs were written with.
+type gob_participant struct {
+	Name       string
+	InitSecret []byte
+	State      *mls.State
+	Pending    *PendingCommit
+}
+
+// unmarshal_gob_participant reads the format used before the envelope.
+func unmarshal_gob_participant(data []byte) (*Participant, error) {
+	var legacy gob_participant
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&legacy); err != nil {
+		return nil, fmt.Errorf("decode gob: %w", err)
+	}
+	return single_session_participant(legacy.Name, legacy.InitSecret, legacy.State, legacy.Pending), nil
+}
+
+// single_session_participant lifts a gob participant, which held one group,
+// into the session map. The epoch it joined at was not recorded, so the
+// current one stands in.
+func single_session_participant(name string, init_secret []byte, state *mls.State, pending *PendingCommit) *Participant {
+	participant := &Participant{Name: name, IdentitySecret: init_secret, InitSecret: init_secret, Suite: DefaultCipherSuite, Sessions: map[string]*Session{}, Retention: DefaultRetention}
+	if state != nil {
+		session := add_session(participant, state)
+		if pending != nil {
+			session.Pending = []*PendingCommit{pending}
+		}
+	}
+	return participant
+}
+
+func prime_gob_registrations() {
+	rng := harness.DeterministicRNG()
+	secret := random_bytes(rng, 32)
+	prime := &Participant{Name: "prime", IdentitySecret: secret, InitSecret: secret, Suite: DefaultCipherSuite}
+	sig_priv, kp, err := build_identity_and_keypackage(prime)
+	if err != nil {
+		return
+	}
+	state, err := mls.NewEmptyState([]byte{0xAA}, secret, sig_priv, *kp)
+	if err != nil {
+		return
+	}
+	register_state_types(state)
+}
+
+func register_state_types(state *mls.State) {
+	if state == nil {
+		return
+	}
+
+	register_value(state.Keys)
+	register_value(state.Keys.HandshakeBaseKeys)
+	register_value(state.Keys.ApplicationBaseKeys)
+	register_value(state.Keys.HandshakeRatchets)
+	register_value(state.Keys.ApplicationRatchets)
+	register_value(state.Keys.HandshakeKeys)
+	register_value(state.Keys.ApplicationKeys)
+
+	for _, ratchet := range state.Keys.HandshakeRatchets {
+		register_value(ratchet)
+	}
+	for _, ratchet := range state.Keys.ApplicationRatchets {
+		register_value(ratchet)
+	}
+}
+
+func register_value(v interface{}) {
+	if v == nil {
+		return
+	}
+	gob.Register(v)
+}
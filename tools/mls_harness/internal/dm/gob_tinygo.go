@@ -0,0 +1,13 @@
+//go:build tinygo
+// +build tinygo
+
+package dm
+
+import "fmt"
+
+// TinyGo builds leave out encoding/gob, whose reflection TinyGo does not
+// support, so they refuse participant blobs from before the versioned format
+// instead of migrating them. Every participant written since is MLSP.
+func unmarshal_gob_participant([]byte) (*Participant, error) {
+	return nil, fmt.Errorf("%w: gob participant blobs are not readable in this build", ErrStateCorrupt)
+}
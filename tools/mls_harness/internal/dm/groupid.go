@@ -0,0 +1,91 @@
+package dm
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// ErrBadGroupID is a group ID Init or Join will not use: not canonical
+// base64, empty, or longer than the 255 bytes an MLS group ID can hold.
+var ErrBadGroupID = errors.New("invalid group id")
+
+const (
+	group_id_label         = "polycentric mls group id v1"
+	group_id_min_nonce_len = 16
+)
+
+// group_id_input is what DeriveGroupID hashes. Each field is length-prefixed,
+// so no founder key and nonce can run together into another pair.
+type group_id_input struct {
+	Label   []byte `tls:"head=1"`
+	Founder []byte `tls:"head=2"`
+	Nonce   []byte `tls:"head=1"`
+}
+
+// DeriveGroupID returns the base64 SHA-256 of the participant's credential
+// key and a creation nonce of at least 16 bytes. A founder who draws a fresh
+// random nonce per conversation gets an ID no other founder can collide with,
+// and anyone holding the key and nonce can recompute it.
+func DeriveGroupID(participant_b64, nonce_b64 string) (string, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(nonce_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode nonce: %w", err)
+	}
+	if len(nonce) < group_id_min_nonce_len || len(nonce) > math.MaxUint8 {
+		return "", fmt.Errorf("nonce must be between %d and %d bytes (got %d)", group_id_min_nonce_len, math.MaxUint8, len(nonce))
+	}
+	sig_priv, _, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return "", fmt.Errorf("build identity: %w", err)
+	}
+	input, err := syntax.Marshal(group_id_input{
+		Label:   []byte(group_id_label),
+		Founder: sig_priv.PublicKey.Data,
+		Nonce:   nonce,
+	})
+	if err != nil {
+		return "", fmt.Errorf("marshal group id input: %w", err)
+	}
+	id := sha256.Sum256(input)
+	return base64.StdEncoding.EncodeToString(id[:]), nil
+}
+
+// parse_group_id decodes a group ID, insisting on the canonical encoding:
+// sessions are keyed by the base64 string, so two spellings of one ID would
+// be two groups.
+func parse_group_id(group_id_b64 string) ([]byte, error) {
+	group_id, err := base64.StdEncoding.Strict().DecodeString(group_id_b64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBadGroupID, err)
+	}
+	if err := check_group_id(group_id); err != nil {
+		return nil, err
+	}
+	if base64.StdEncoding.EncodeToString(group_id) != group_id_b64 {
+		return nil, fmt.Errorf("%w: %s is not canonical base64", ErrBadGroupID, group_id_b64)
+	}
+	return group_id, nil
+}
+
+func check_group_id(group_id []byte) error {
+	switch {
+	case len(group_id) == 0:
+		return fmt.Errorf("%w: group id is empty", ErrBadGroupID)
+	case len(group_id) > math.MaxUint8:
+		return fmt.Errorf("%w: group id is %d bytes, more than %d", ErrBadGroupID, len(group_id), math.MaxUint8)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Handle keeps one decoded participant in memory for Go callers, such as
+// tests and server-side bots, that would otherwise pass a blob through every
+// call and pay to decode and encode it each time. Its methods are safe to call
+// from several goroutines; they run one at a time. Encoding happens only in
+// Save, and Load replaces the held state. Messages go through the in-memory
+// state directly and keep what they did to it as DecryptBatch does. Any other
+// entry point runs through Apply. Destroy scrubs the held state; after it,
+// every method but Load fails with ErrDestroyed.
+type Handle struct {
+	mu          sync.Mutex
+	participant *Participant
+	destroyed   bool
+}
+
+// LoadHandle decodes a participant into a new Handle.
+func LoadHandle(participant_b64 string) (*Handle, error) {
+	h := &Handle{}
+	if err := h.Load(participant_b64); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Load replaces the handle's participant with a decoded one.
+func (h *Handle) Load(participant_b64 string) error {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return fmt.Errorf("decode participant: %w", err)
+	}
+	if participant == nil {
+		return errors.New("participant state not initialized")
+	}
+	h.mu.Lock()
+	Zeroize(h.participant)
+	h.participant = participant
+	h.destroyed = false
+	h.mu.Unlock()
+	return nil
+}
+
+// Destroy scrubs the held participant as Zeroize does and drops it. It does
+// not touch any blob Save returned; store the tombstone from dm.Destroy over
+// that.
+func (h *Handle) Destroy() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	Zeroize(h.participant)
+	h.participant = nil
+	h.destroyed = true
+}
+
+// Save encodes the participant, sealed if a state key is set.
+func (h *Handle) Save() (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return "", ErrDestroyed
+	}
+	return encode_participant(h.participant)
+}
+
+// Groups returns the group IDs the participant has sessions for, sorted, and
+// none once the handle is destroyed.
+func (h *Handle) Groups() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return []string{}
+	}
+	groups := make([]string, 0, len(h.participant.Sessions))
+	for group_id := range h.participant.Sessions {
+		groups = append(groups, group_id)
+	}
+	sort.Strings(groups)
+	return groups
+}
+
+// Info is Info on the held participant.
+func (h *Handle) Info(group_id_b64 string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return "", ErrDestroyed
+	}
+	return participant_info(h.participant, group_id_b64)
+}
+
+// Encrypt is Encrypt on the held participant.
+func (h *Handle) Encrypt(group_id_b64, plaintext string) (string, error) {
+	return h.protect(group_id_b64, Message{Body: plaintext}, false)
+}
+
+// EncryptMessage is EncryptMessage on the held participant.
+func (h *Handle) EncryptMessage(group_id_b64 string, message Message) (string, error) {
+	return h.protect(group_id_b64, message, true)
+}
+
+func (h *Handle) protect(group_id_b64 string, message Message, framed bool) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return "", ErrDestroyed
+	}
+	session, err := find_session(h.participant, group_id_b64)
+	if err != nil {
+		return "", err
+	}
+	return protect_one(h.participant, session, message, framed)
+}
+
+// Decrypt is DecryptMessage on the held participant, returning the message
+// itself rather than JSON.
+func (h *Handle) Decrypt(group_id_b64, ciphertext_b64 string) (*ReceivedMessage, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return nil, ErrDestroyed
+	}
+	return open_one(h.participant, group_id_b64, ciphertext_b64)
+}
+
+// EncryptBatch is EncryptBatch on the held participant. A handle has no
+// earlier state to fall back to, so when a message fails the held participant
+// keeps the generations the messages before it spent, and their ciphertexts
+// are dropped; only a group the participant has left fails before the first.
+func (h *Handle) EncryptBatch(group_id_b64 string, plaintexts []string) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return nil, ErrDestroyed
+	}
+	session, err := find_session(h.participant, group_id_b64)
+	if err != nil {
+		return nil, err
+	}
+	return protect_batch(h.participant, session, plaintexts)
+}
+
+// DecryptBatch is DecryptBatch on the held participant.
+func (h *Handle) DecryptBatch(group_id_b64 string, ciphertexts_b64 []string) []BatchMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		results := make([]BatchMessage, len(ciphertexts_b64))
+		for i := range results {
+			results[i] = BatchMessage{Error: ErrDestroyed.Error(), Code: ErrorCode(ErrDestroyed)}
+		}
+		return results
+	}
+	return open_batch(h.participant, group_id_b64, ciphertexts_b64)
+}
+
+// Apply runs a blob entry point, such as Join or CommitApply, against the
+// held participant: op gets it encoded and returns the participant to hold
+// next. When op fails the handle keeps what it had, as a blob caller would.
+// op runs with the handle's mutex held, so it must not call any method of the
+// same Handle; that call would wait on the mutex forever.
+func (h *Handle) Apply(op func(participant_b64 string) (string, error)) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.destroyed {
+		return ErrDestroyed
+	}
+	participant_b64, err := encode_participant(h.participant)
+	if err != nil {
+		return err
+	}
+	if participant_b64, err = op(participant_b64); err != nil {
+		return err
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return fmt.Errorf("decode participant: %w", err)
+	}
+	if participant == nil {
+		return errors.New("operation returned no participant")
+	}
+	Zeroize(h.participant)
+	h.participant = participant
+	return nil
+}
@@ -0,0 +1,146 @@
+package dm
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// IdentityBinding ties a participant's MLS credential key to a polycentric user
+// ID: UserKey is the user's Ed25519 public key and Signature is that key's
+// signature over identity_binding_message of the credential key. A bound
+// participant carries it in every KeyPackage it builds, as an extension in the
+// private-use range, so members can check which user each leaf belongs to.
+type IdentityBinding struct {
+	UserKey   []byte `tls:"head=1"`
+	Signature []byte `tls:"head=1"`
+}
+
+const (
+	identity_binding_extension mls.ExtensionType = 0xff01
+	identity_binding_label                       = "polycentric mls identity binding v1"
+)
+
+func (IdentityBinding) Type() mls.ExtensionType {
+	return identity_binding_extension
+}
+
+func identity_binding_message(credential_key *mls.SignaturePublicKey) []byte {
+	return append([]byte(identity_binding_label), credential_key.Data...)
+}
+
+func (binding IdentityBinding) verify(credential_key *mls.SignaturePublicKey) error {
+	if len(binding.UserKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("identity binding user key is %d bytes, want %d", len(binding.UserKey), ed25519.PublicKeySize)
+	}
+	if credential_key == nil || !ed25519.Verify(binding.UserKey, identity_binding_message(credential_key), binding.Signature) {
+		return errors.New("identity binding signature does not verify")
+	}
+	return nil
+}
+
+// user_id encodes a user key the way polycentric writes user IDs: URL-safe
+// base64 without padding.
+func user_id(user_key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(user_key)
+}
+
+// keypackage_user_id returns the user ID a KeyPackage's identity binding
+// vouches for, or "" if it carries none. A binding that does not verify
+// against the KeyPackage's credential is an error.
+func keypackage_user_id(kp mls.KeyPackage) (string, error) {
+	var binding IdentityBinding
+	found, err := kp.Extensions.Find(&binding)
+	if !found {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("parse identity binding: %w", err)
+	}
+	if err := binding.verify(kp.Credential.PublicKey()); err != nil {
+		return "", fmt.Errorf("%s: %w", kp.Credential.Identity(), err)
+	}
+	return user_id(binding.UserKey), nil
+}
+
+// check_tree_bindings verifies the identity binding of every leaf in a group
+// the participant is joining.
+func check_tree_bindings(state *mls.State) error {
+	for i := mls.LeafIndex(0); i < mls.LeafIndex(state.Tree.Size()); i++ {
+		kp, ok := state.Tree.KeyPackage(i)
+		if !ok {
+			continue
+		}
+		if _, err := keypackage_user_id(kp); err != nil {
+			return fmt.Errorf("leaf %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// IdentityBindingMessage returns, base64-encoded, the bytes a polycentric user
+// key signs to bind itself to the participant's credential key.
+func IdentityBindingMessage(participant_b64 string) (string, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	sig_priv, _, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return "", fmt.Errorf("build identity: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(identity_binding_message(&sig_priv.PublicKey)), nil
+}
+
+// BindIdentity records the user key's signature over IdentityBindingMessage in
+// the participant and returns its KeyPackage, which now carries the binding.
+// KeyPackages published earlier, pooled ones included, stay unbound.
+func BindIdentity(participant_b64, user_id_b64, signature_b64 string) (string, string, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", "", errors.New("participant state not initialized")
+	}
+	user_key, err := base64.RawURLEncoding.DecodeString(user_id_b64)
+	if err != nil {
+		return "", "", fmt.Errorf("decode user id: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signature_b64)
+	if err != nil {
+		return "", "", fmt.Errorf("decode signature: %w", err)
+	}
+	sig_priv, _, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return "", "", fmt.Errorf("build identity: %w", err)
+	}
+	binding := &IdentityBinding{UserKey: user_key, Signature: signature}
+	if err := binding.verify(&sig_priv.PublicKey); err != nil {
+		return "", "", err
+	}
+	participant.IdentityBinding = binding
+
+	_, kp, err := build_identity_and_keypackage(participant)
+	if err != nil {
+		return "", "", fmt.Errorf("create keypackage: %w", err)
+	}
+	kp_bytes, err := syntax.Marshal(*kp)
+	if err != nil {
+		return "", "", fmt.Errorf("marshal keypackage: %w", err)
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, encode_artifact(artifact_keypackage, nil, 0, kp_bytes), nil
+}
@@ -0,0 +1,245 @@
+package dm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// GroupInfo is the roster view Info returns. It describes the participant's
+// current epoch; commits still pending are only counted, not reflected.
+type GroupInfo struct {
+	GroupID           string          `json:"group_id"`
+	Epoch             uint64          `json:"epoch"`
+	CipherSuite       InfoCipherSuite `json:"cipher_suite"`
+	OwnLeaf           uint32          `json:"own_leaf"`
+	MemberCount       int             `json:"member_count"`
+	Members           []Member        `json:"members"`
+	PendingCommit     bool            `json:"pending_commit"`
+	PendingCount      int             `json:"pending_commits"`
+	JoinedEpoch       uint64          `json:"joined_epoch"`
+	Left              bool            `json:"left"`
+	PastEpochs        []uint64        `json:"past_epochs"`
+	Retention         RetentionPolicy `json:"retention"`
+	Padding           PaddingPolicy   `json:"padding"`
+	Mode              string          `json:"mode"`
+	Admins            []string        `json:"admins"`
+	Rotation          RotationPolicy  `json:"rotation"`
+	SentSinceRotation uint32          `json:"sent_since_rotation"`
+	RotatedEpoch      uint64          `json:"rotated_epoch"`
+	AppVersion        uint8           `json:"app_version"`
+}
+
+type InfoCipherSuite struct {
+	ID   uint16 `json:"id"`
+	Name string `json:"name"`
+}
+
+// Member is one occupied leaf. Identity is the credential identity, so one user
+// with several devices appears once per leaf. UserID is the polycentric user
+// the leaf's identity binding verifies for, if it has one.
+type Member struct {
+	Leaf     uint32 `json:"leaf"`
+	Identity string `json:"identity"`
+	UserID   string `json:"user_id,omitempty"`
+}
+
+// Info returns one of the participant's groups as JSON: group ID (base64),
+// epoch, cipher suite, own leaf index and the identity of every occupied leaf.
+func Info(participant_b64, group_id_b64 string) (string, error) {
+	if participant_b64 == "" {
+		return "", errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	return participant_info(participant, group_id_b64)
+}
+
+func participant_info(participant *Participant, group_id_b64 string) (string, error) {
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return "", err
+	}
+
+	info := group_info(session.State)
+	info.PendingCommit = len(session.Pending) > 0
+	info.PendingCount = len(session.Pending)
+	info.JoinedEpoch = session.JoinedEpoch
+	info.Left = session.Left
+	info.PastEpochs = []uint64{}
+	for _, past := range session.PastEpochs {
+		info.PastEpochs = append(info.PastEpochs, uint64(past.Epoch))
+	}
+	info.Retention = participant.Retention
+	info.Padding = PaddingPolicy{Buckets: []uint32{}}
+	if len(participant.Padding.Buckets) > 0 {
+		info.Padding = participant.Padding
+	}
+	info.Mode = participant.Mode.String()
+	info.Admins = append([]string{}, session.Admins...)
+	info.Rotation = participant.Rotation
+	info.SentSinceRotation = session.SentSinceRotation
+	info.RotatedEpoch = session.RotatedEpoch
+	info.AppVersion = group_app_version(session.State)
+	out, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("encode info: %w", err)
+	}
+	return string(out), nil
+}
+
+func group_info(state *mls.State) GroupInfo {
+	info := GroupInfo{
+		GroupID: base64.StdEncoding.EncodeToString(state.GroupID),
+		Epoch:   uint64(state.Epoch),
+		CipherSuite: InfoCipherSuite{
+			ID:   uint16(state.CipherSuite),
+			Name: state.CipherSuite.String(),
+		},
+		OwnLeaf: uint32(state.Index),
+		Members: []Member{},
+	}
+	for i := mls.LeafIndex(0); i < mls.LeafIndex(state.Tree.Size()); i++ {
+		kp, ok := state.Tree.KeyPackage(i)
+		if !ok {
+			continue
+		}
+		member := Member{Leaf: uint32(i), Identity: string(kp.Credential.Identity())}
+		member.UserID, _ = keypackage_user_id(kp)
+		info.Members = append(info.Members, member)
+	}
+	info.MemberCount = len(info.Members)
+	return info
+}
+
+// SenderGeneration is how far the participant has read one sender in the
+// current epoch. Last is the highest application generation decrypted from the
+// leaf; Missing lists the lower ones not yet decrypted whose keys are still
+// held, so a gap the retention policy already gave up on does not show.
+type SenderGeneration struct {
+	Leaf    uint32   `json:"leaf"`
+	Last    uint32   `json:"last"`
+	Missing []uint32 `json:"missing"`
+}
+
+// Epoch returns the participant's current epoch in a group. It and the two
+// accessors below only read the participant, so a UI can poll them to order
+// messages without handing back a new blob.
+func Epoch(participant_b64, group_id_b64 string) (uint64, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return 0, err
+	}
+	defer Zeroize(participant)
+	return uint64(session.State.Epoch), nil
+}
+
+// GroupEpochs returns the participant's current epoch in each of its groups,
+// keyed by base64 group ID.
+func GroupEpochs(participant_b64 string) (map[string]uint64, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return nil, errors.New("participant is required")
+	}
+	epochs := make(map[string]uint64, len(participant.Sessions))
+	for id, session := range participant.Sessions {
+		epochs[id] = uint64(session.State.Epoch)
+	}
+	return epochs, nil
+}
+
+// GroupStatus is one of the participant's groups as ListGroups reports it.
+type GroupStatus struct {
+	GroupID     string `json:"group_id"`
+	Epoch       uint64 `json:"epoch"`
+	JoinedEpoch uint64 `json:"joined_epoch"`
+	// Pending counts the participant's own commits awaiting their echo.
+	Pending int  `json:"pending"`
+	Left    bool `json:"left"`
+}
+
+// ListGroups returns the status of each of the participant's groups, sorted
+// by group ID, so a client holding one participant for all its conversations
+// reads every conversation's epoch and pending commits in one decode.
+func ListGroups(participant_b64 string) ([]GroupStatus, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return nil, errors.New("participant is required")
+	}
+	statuses := make([]GroupStatus, 0, len(participant.Sessions))
+	for _, id := range group_ids(participant) {
+		session := participant.Sessions[id]
+		statuses = append(statuses, GroupStatus{
+			GroupID:     id,
+			Epoch:       uint64(session.State.Epoch),
+			JoinedEpoch: session.JoinedEpoch,
+			Pending:     len(session.Pending),
+			Left:        session.Left,
+		})
+	}
+	return statuses, nil
+}
+
+// NextOutgoingGeneration returns the application generation the participant's
+// next message in the group's current epoch will carry.
+func NextOutgoingGeneration(participant_b64, group_id_b64 string) (uint32, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return 0, err
+	}
+	defer Zeroize(participant)
+	state := session.State
+	if state.Keys.ApplicationKeys == nil {
+		return 0, nil
+	}
+	ratchet, ok := state.Keys.ApplicationKeys.Ratchets[state.Index]
+	if !ok {
+		return 0, nil
+	}
+	return ratchet.NextGeneration, nil
+}
+
+// LastDecryptedGeneration returns, by leaf, every other member the participant
+// has decrypted an application message from in the current epoch.
+func LastDecryptedGeneration(participant_b64, group_id_b64 string) ([]SenderGeneration, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return nil, err
+	}
+	defer Zeroize(participant)
+	state := session.State
+	senders := []SenderGeneration{}
+	if state.Keys.ApplicationKeys == nil {
+		return senders, nil
+	}
+	for leaf, ratchet := range state.Keys.ApplicationKeys.Ratchets {
+		if leaf == state.Index || ratchet.NextGeneration == 0 {
+			continue
+		}
+		sender := SenderGeneration{Leaf: uint32(leaf), Last: ratchet.NextGeneration - 1, Missing: []uint32{}}
+		for generation := range ratchet.Cache {
+			if generation < sender.Last {
+				sender.Missing = append(sender.Missing, generation)
+			}
+		}
+		sort.Slice(sender.Missing, func(i, j int) bool { return sender.Missing[i] < sender.Missing[j] })
+		senders = append(senders, sender)
+	}
+	sort.Slice(senders, func(i, j int) bool { return senders[i].Leaf < senders[j].Leaf })
+	return senders, nil
+}
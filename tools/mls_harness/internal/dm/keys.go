@@ -0,0 +1,62 @@
+package dm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// A participant's identity key signs its credential and everything it sends,
+// and the init keys of its KeyPackages open the Welcomes that add it. Each
+// comes from its own secret, expanded from fresh randomness under its own
+// label, so a leaked init secret says nothing about the identity key and the
+// other way round. Participants stored before the split hold their one secret
+// in both fields; KeyPackage gives them a separate init secret.
+const (
+	identity_secret_label = "mls-harness dm identity secret v1"
+	init_secret_label     = "mls-harness dm init secret v1"
+)
+
+func fresh_secret(rng io.Reader, label string) []byte {
+	return hkdf_sha256(random_bytes(rng, 32), nil, []byte(label), 32)
+}
+
+// shares_secret reports whether the participant still derives its identity
+// key and its KeyPackage's init key from the same secret.
+func shares_secret(participant *Participant) bool {
+	return len(participant.InitSecret) > 0 && bytes.Equal(participant.IdentitySecret, participant.InitSecret)
+}
+
+// split_shared_secret gives a participant from before the split its own init
+// secret. The KeyPackage it may already have published, which predates
+// identity bindings, moves to the pool, so one Welcome for it can still be
+// joined.
+func split_shared_secret(participant *Participant, rng io.Reader) error {
+	return retire_keypackage(participant, nil, rng)
+}
+
+// retire_keypackage moves the participant's reusable KeyPackage, built with
+// binding, to the pool and draws a new init secret and lifetime for the next
+// one.
+func retire_keypackage(participant *Participant, binding *IdentityBinding, rng io.Reader) error {
+	sig_priv, cred, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return fmt.Errorf("build identity: %w", err)
+	}
+	kp, err := build_keypackage(participant.Suite, participant.InitSecret, cred, sig_priv, binding, participant.Lifetime)
+	if err != nil {
+		return err
+	}
+	kp_bytes, err := syntax.Marshal(*kp)
+	if err != nil {
+		return fmt.Errorf("marshal keypackage: %w", err)
+	}
+	if pooled_keypackage(participant, kp_bytes) == nil {
+		participant.Pool = append(participant.Pool, &PooledKeyPackage{KeyPackage: kp_bytes, InitSecret: participant.InitSecret})
+	}
+	participant.InitSecret = fresh_secret(rng, init_secret_label)
+	participant.Lifetime = new_lifetime(participant)
+	return nil
+}
@@ -0,0 +1,180 @@
+package dm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Framed application messages carry metadata inside the MLS ciphertext, so
+// only members see it:
+//
+//	magic "MLSM" | version uint8 | message_v1
+//
+// The version is the AppProtocolVersion the sender wrote the frame in, and a
+// later one fails with ErrUnsupportedVersion. The padding is zero bytes whose
+// length is part of the frame; it hides the body length from anyone who only
+// sees ciphertext sizes. Encrypt sends bare
+// bodies unless the participant has a PaddingPolicy, and every decrypt entry
+// point reads both.
+const (
+	message_magic      = "MLSM"
+	message_version_v1 = 1
+)
+
+type message_v1 struct {
+	ContentType []byte `tls:"head=1"`
+	TimestampMs uint64
+	Body        []byte `tls:"head=4"`
+	Padding     []byte `tls:"head=2"`
+}
+
+// DefaultContentType is the content type of messages that do not name one,
+// including bare messages from Encrypt.
+const DefaultContentType = "text/plain"
+
+// Message is the sender's side of a framed message. TimestampMs is the sender's
+// clock in Unix milliseconds; receivers should treat it as a claim, not a fact.
+type Message struct {
+	ContentType string `json:"content_type"`
+	TimestampMs uint64 `json:"timestamp_ms"`
+	Padding     int    `json:"padding"`
+	Body        string `json:"body"`
+}
+
+// ReceivedMessage is what DecryptMessage returns: the frame plus what MLS
+// authenticates about the message. Framed is false for a bare message, which
+// reports DefaultContentType and no timestamp. Content is the decoded typed
+// content, nil for a content type outside the schemas in content.go.
+type ReceivedMessage struct {
+	Message
+	GroupID    string   `json:"group_id"`
+	Epoch      uint64   `json:"epoch"`
+	SenderLeaf uint32   `json:"sender_leaf"`
+	Sender     string   `json:"sender"`
+	Framed     bool     `json:"framed"`
+	MessageID  string   `json:"message_id"`
+	Content    *Content `json:"content,omitempty"`
+}
+
+// EncryptMessage frames message and encrypts it like Encrypt. The
+// participant's PaddingPolicy pads on top of message.Padding.
+func EncryptMessage(participant_b64, group_id_b64 string, message Message) (string, string, error) {
+	return protect(participant_b64, group_id_b64, message, true)
+}
+
+// DecryptMessage decrypts like Decrypt and returns the message as JSON.
+func DecryptMessage(participant_b64, group_id_b64, ciphertext_b64 string) (string, string, error) {
+	participant_b64, message, err := open_message(participant_b64, group_id_b64, ciphertext_b64)
+	if err != nil {
+		return "", "", err
+	}
+	out, err := json.Marshal(message)
+	if err != nil {
+		return "", "", fmt.Errorf("encode message: %w", err)
+	}
+	return participant_b64, string(out), nil
+}
+
+// frame_message encodes message, then grows its padding to the policy's
+// bucket. The padding length has a fixed-size prefix, so growing it adds
+// exactly the padded bytes to the frame.
+func frame_message(message Message, policy PaddingPolicy) ([]byte, error) {
+	if message.ContentType == "" {
+		message.ContentType = DefaultContentType
+	}
+	if len(message.ContentType) > math.MaxUint8 {
+		return nil, fmt.Errorf("content type is %d bytes, more than %d", len(message.ContentType), math.MaxUint8)
+	}
+	if message.Padding < 0 || message.Padding > math.MaxUint16 {
+		return nil, fmt.Errorf("padding must be between 0 and %d bytes", math.MaxUint16)
+	}
+	frame, err := marshal_frame(message)
+	if err != nil || len(policy.Buckets) == 0 {
+		return frame, err
+	}
+	message.Padding += policy.pad_to(len(frame)) - len(frame)
+	if message.Padding > math.MaxUint16 {
+		return nil, fmt.Errorf("padding to the %d-byte bucket needs more than %d bytes", policy.pad_to(len(frame)), math.MaxUint16)
+	}
+	return marshal_frame(message)
+}
+
+func marshal_frame(message Message) ([]byte, error) {
+	body, err := syntax.Marshal(message_v1{
+		ContentType: []byte(message.ContentType),
+		TimestampMs: message.TimestampMs,
+		Body:        []byte(message.Body),
+		Padding:     make([]byte, message.Padding),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal message: %w", err)
+	}
+	header := append([]byte(message_magic), message_version_v1)
+	return append(header, body...), nil
+}
+
+// received_message parses a decrypted application payload and decodes its
+// typed content.
+func received_message(state *mls.State, sender mls.LeafIndex, data []byte) (*ReceivedMessage, error) {
+	message := &ReceivedMessage{
+		GroupID:    base64.StdEncoding.EncodeToString(state.GroupID),
+		Epoch:      uint64(state.Epoch),
+		SenderLeaf: uint32(sender),
+	}
+	if kp, ok := state.Tree.KeyPackage(sender); ok {
+		message.Sender = string(kp.Credential.Identity())
+	}
+	if err := parse_frame(message, data); err != nil {
+		return nil, err
+	}
+	content, err := DecodeContent(message.Message)
+	if err != nil {
+		return nil, err
+	}
+	message.Content = content
+	return message, nil
+}
+
+// parse_frame fills in message from a payload, framed or bare.
+func parse_frame(message *ReceivedMessage, data []byte) error {
+	if !bytes.HasPrefix(data, []byte(message_magic)) {
+		message.ContentType = DefaultContentType
+		message.Body = string(data)
+		return nil
+	}
+	header := len(message_magic) + 1
+	if len(data) < header {
+		return errors.New("truncated message frame")
+	}
+	if version := data[len(message_magic)]; version > AppProtocolVersion {
+		return check_app_version("message frame", version)
+	} else if version != message_version_v1 {
+		return fmt.Errorf("unsupported message frame version %d", version)
+	}
+	var body message_v1
+	read, err := syntax.Unmarshal(data[header:], &body)
+	if err != nil {
+		return fmt.Errorf("unmarshal message frame: %w", err)
+	}
+	if header+read != len(data) {
+		return errors.New("trailing bytes after message frame")
+	}
+	for _, b := range body.Padding {
+		if b != 0 {
+			return errors.New("message frame padding is not zero")
+		}
+	}
+	message.Framed = true
+	message.ContentType = string(body.ContentType)
+	message.TimestampMs = body.TimestampMs
+	message.Padding = len(body.Padding)
+	message.Body = string(body.Body)
+	return nil
+}
@@ -0,0 +1,149 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// Mode is how a participant draws its secrets and dates its KeyPackages. It is
+// fixed when the participant is created.
+type Mode uint8
+
+const (
+	// ModeDeterministic takes every secret from the seed each operation is
+	// given and signs KeyPackages with the fixed lifetime of the vectors, so a
+	// run can be replayed byte for byte. It is for tests and vectors only.
+	// Participants stored before modes existed read as deterministic.
+	ModeDeterministic Mode = 0
+	// ModeSecure refuses seeds, draws from crypto/rand and gives each new
+	// KeyPackage a lifetime of SecureKeyPackageLifetime from the time it is
+	// made.
+	ModeSecure Mode = 1
+)
+
+const (
+	// SecureKeyPackageLifetime is how long a secure participant's KeyPackages
+	// stay valid.
+	SecureKeyPackageLifetime = 90 * 24 * time.Hour
+	// secure_clock_skew backdates a secure KeyPackage's start, so a peer whose
+	// clock runs slow does not reject it as not yet valid.
+	secure_clock_skew = time.Hour
+)
+
+// ErrSeedRefused is a seed passed for a participant in ModeSecure.
+var ErrSeedRefused = errors.New("secure participants refuse seeds")
+
+// ErrDeterministicRefused is a deterministic participant used while
+// SetSecureOnly is on.
+var ErrDeterministicRefused = errors.New("deterministic participants are refused in secure-only mode")
+
+var secure_only atomic.Bool
+
+// SetSecureOnly makes every operation that draws secrets refuse deterministic
+// participants, including the creation of one, so a process meant for real
+// users cannot fall back to seeded secrets by loading the wrong blob.
+func SetSecureOnly(enabled bool) {
+	secure_only.Store(enabled)
+}
+
+// clock_box holds the clock in an atomic.Value, which needs one concrete type.
+type clock_box struct{ harness.Clock }
+
+var clock atomic.Value
+
+// SetClock sets the clock that dates secure KeyPackages, decides when one has
+// expired and stamps messages; nil restores the system clock. A frozen clock
+// makes a run repeat the same lifetimes and timestamps.
+func SetClock(c harness.Clock) {
+	if c == nil {
+		c = harness.SystemClock{}
+	}
+	clock.Store(clock_box{c})
+}
+
+// Now is the time on the clock SetClock set.
+func Now() time.Time {
+	if box, ok := clock.Load().(clock_box); ok {
+		return box.Now()
+	}
+	return time.Now()
+}
+
+// Lifetime is the validity window of a KeyPackage in Unix seconds. The zero
+// value stands for the fixed window deterministic KeyPackages carry.
+type Lifetime struct {
+	NotBefore uint64
+	NotAfter  uint64
+}
+
+func (lifetime Lifetime) expired(now time.Time) bool {
+	return lifetime != (Lifetime{}) && uint64(now.Unix()) >= lifetime.NotAfter
+}
+
+// ParseMode accepts "deterministic" and "secure".
+func ParseMode(name string) (Mode, error) {
+	switch name {
+	case "deterministic":
+		return ModeDeterministic, nil
+	case "secure":
+		return ModeSecure, nil
+	}
+	return 0, fmt.Errorf("unknown mode %q (want deterministic or secure)", name)
+}
+
+func (mode Mode) String() string {
+	switch mode {
+	case ModeDeterministic:
+		return "deterministic"
+	case ModeSecure:
+		return "secure"
+	}
+	return fmt.Sprintf("mode(%d)", uint8(mode))
+}
+
+// ParticipantMode returns the mode of a participant.
+func ParticipantMode(participant_b64 string) (Mode, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return 0, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return 0, errors.New("participant state not initialized")
+	}
+	return participant.Mode, nil
+}
+
+// participant_random returns the random source of one operation for
+// participant, as seeded_random does, and the func to call once it is done. A
+// secure participant gets crypto/rand and refuses any seed but 0.
+func participant_random(participant *Participant, seed int64) (io.Reader, func(), error) {
+	if participant.Mode != ModeSecure {
+		if secure_only.Load() {
+			return nil, nil, ErrDeterministicRefused
+		}
+		rng, release := seeded_random(seed)
+		return rng, release, nil
+	}
+	if seed != 0 {
+		return nil, nil, fmt.Errorf("%w (got seed %d)", ErrSeedRefused, seed)
+	}
+	return system_entropy, func() {}, nil
+}
+
+// new_lifetime is the lifetime of a KeyPackage the participant makes now.
+func new_lifetime(participant *Participant) Lifetime {
+	if participant.Mode != ModeSecure {
+		return Lifetime{}
+	}
+	now := Now()
+	return Lifetime{
+		NotBefore: uint64(now.Add(-secure_clock_skew).Unix()),
+		NotAfter:  uint64(now.Add(SecureKeyPackageLifetime).Unix()),
+	}
+}
@@ -0,0 +1,69 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// PaddingPolicy rounds the messages a participant sends up to bucket sizes, so
+// the delivery service sees which bucket a message fell in rather than its
+// length. Buckets are frame sizes in bytes, ascending; a frame larger than the
+// last bucket is rounded up to a multiple of it. With no buckets Encrypt sends
+// bare messages, and with buckets every message it sends is framed.
+type PaddingPolicy struct {
+	Buckets []uint32 `json:"buckets"`
+}
+
+// max_padding_bucket keeps the padding a bucket can need within the frame's
+// 16-bit padding length.
+const max_padding_bucket = math.MaxUint16 + 1
+
+func (policy PaddingPolicy) validate() error {
+	for i, bucket := range policy.Buckets {
+		switch {
+		case bucket == 0:
+			return errors.New("padding buckets must be positive")
+		case bucket > max_padding_bucket:
+			return fmt.Errorf("padding bucket %d is larger than %d", bucket, max_padding_bucket)
+		case i > 0 && bucket <= policy.Buckets[i-1]:
+			return errors.New("padding buckets must be ascending")
+		}
+	}
+	return nil
+}
+
+// pad_to returns the frame size a frame of length size is padded to.
+func (policy PaddingPolicy) pad_to(size int) int {
+	for _, bucket := range policy.Buckets {
+		if size <= int(bucket) {
+			return int(bucket)
+		}
+	}
+	last := int(policy.Buckets[len(policy.Buckets)-1])
+	return (size + last - 1) / last * last
+}
+
+// SetPadding replaces the participant's padding policy.
+func SetPadding(participant_b64 string, policy PaddingPolicy) (string, error) {
+	if participant_b64 == "" {
+		return "", errors.New("participant is required")
+	}
+	if err := policy.validate(); err != nil {
+		return "", err
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	participant.Padding = policy
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, nil
+}
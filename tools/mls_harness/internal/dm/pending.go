@@ -0,0 +1,246 @@
+package dm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// A participant's own commits wait in Session.Pending until the delivery
+// service echoes them back. Each one is built on the next state of the one
+// before, so they must be applied in order; discarding one discards every
+// commit built on it. Commits are named by the hex SHA-256 of their encoding.
+
+// ErrPendingConflict is returned by CommitApply for another member's commit to
+// an epoch the participant has its own commit pending in. Only one of them can
+// take effect: discard the pending commit with DiscardPending, then apply.
+var ErrPendingConflict = errors.New("commit conflicts with a pending commit")
+
+// PendingInfo describes one pending commit.
+type PendingInfo struct {
+	Epoch      uint64 `json:"epoch"`
+	CommitHash string `json:"commit_hash"`
+	Welcome    bool   `json:"welcome"`
+}
+
+// PendingCommits lists the group's pending commits as a JSON array, oldest
+// first.
+func PendingCommits(participant_b64, group_id_b64 string) (string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", err
+	}
+	defer Zeroize(participant)
+	infos := make([]PendingInfo, 0, len(session.Pending))
+	for _, pending := range session.Pending {
+		infos = append(infos, PendingInfo{
+			Epoch:      pending_epoch(pending),
+			CommitHash: commit_hash(pending.Commit),
+			Welcome:    len(pending.Welcome) > 0,
+		})
+	}
+	out, err := json.Marshal(infos)
+	if err != nil {
+		return "", fmt.Errorf("encode pending commits: %w", err)
+	}
+	return string(out), nil
+}
+
+// ApplyPending applies the oldest pending commit without waiting for its echo,
+// for delivery services that confirm a commit rather than send it back. A
+// non-empty commit_hash must name that commit.
+func ApplyPending(participant_b64, group_id_b64, commit_hash_hex string) (string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", err
+	}
+	defer Zeroize(participant)
+	if len(session.Pending) == 0 {
+		return "", errors.New("no pending commit")
+	}
+	if commit_hash_hex != "" {
+		index, err := find_pending(session, commit_hash_hex)
+		if err != nil {
+			return "", err
+		}
+		if index > 0 {
+			return "", fmt.Errorf("pending commit %s (epoch %d) must be applied first", commit_hash(session.Pending[0].Commit), pending_epoch(session.Pending[0]))
+		}
+	}
+	if err := apply_next_pending(session, participant.Retention); err != nil {
+		return "", err
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, nil
+}
+
+// DiscardPending drops the named pending commit and every commit built on it,
+// or all of them when commit_hash is empty, and returns how many it dropped.
+// Proposals the dropped commits covered stay queued, so a later commit can
+// include them again.
+func DiscardPending(participant_b64, group_id_b64, commit_hash_hex string) (string, int, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", 0, err
+	}
+	defer Zeroize(participant)
+	if len(session.Pending) == 0 {
+		return "", 0, errors.New("no pending commit")
+	}
+	index := 0
+	if commit_hash_hex != "" {
+		if index, err = find_pending(session, commit_hash_hex); err != nil {
+			return "", 0, err
+		}
+	}
+	discarded := len(session.Pending) - index
+	session.Pending = session.Pending[:index]
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", 0, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, discarded, nil
+}
+
+func load_session(participant_b64, group_id_b64 string) (*Participant, *Session, error) {
+	if participant_b64 == "" {
+		return nil, nil, errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decode participant: %w", err)
+	}
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return nil, nil, err
+	}
+	return participant, session, nil
+}
+
+// working_state is the state a new commit builds on: the next state of the
+// newest pending commit, or the current state when nothing is pending.
+func working_state(session *Session) *mls.State {
+	if len(session.Pending) == 0 {
+		return session.State
+	}
+	return session.Pending[len(session.Pending)-1].NextState
+}
+
+func push_pending(session *Session, commit_bytes, welcome_bytes []byte, next_state *mls.State) {
+	session.Pending = append(session.Pending, &PendingCommit{Commit: commit_bytes, Welcome: welcome_bytes, NextState: next_state})
+}
+
+func apply_next_pending(session *Session, policy RetentionPolicy) error {
+	next := session.Pending[0]
+	if next.NextState == nil {
+		return errors.New("pending commit missing next state")
+	}
+	id, err := commit_id(next.Commit)
+	if err != nil {
+		return fmt.Errorf("pending commit: %w", err)
+	}
+	advance_state(session, next.NextState, policy)
+	record_applied(session, id)
+	session.Pending = session.Pending[1:]
+	return nil
+}
+
+// max_applied_commits bounds Session.Applied. A commit delivered again after
+// this many later ones counts as stale.
+const max_applied_commits = 32
+
+func record_applied(session *Session, id []byte) {
+	session.Applied = append([][]byte{id}, session.Applied...)
+	if len(session.Applied) > max_applied_commits {
+		session.Applied = session.Applied[:max_applied_commits]
+	}
+}
+
+// applied_before also takes the SHA-256 of the commit's bytes, which is what
+// state written before commits had identities recorded.
+func applied_before(session *Session, id, commit_bytes []byte) bool {
+	sum := sha256.Sum256(commit_bytes)
+	for _, hash := range session.Applied {
+		if bytes.Equal(hash, id) || bytes.Equal(hash, sum[:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// pending_index is the queue position of the commit with identity id, or -1.
+func pending_index(session *Session, id []byte) int {
+	for i, pending := range session.Pending {
+		other, err := commit_id(pending.Commit)
+		if err == nil && bytes.Equal(other, id) {
+			return i
+		}
+	}
+	return -1
+}
+
+// commit_identity is what commit_id hashes.
+type commit_identity struct {
+	GroupID      []byte `tls:"head=1"`
+	Epoch        mls.Epoch
+	Sender       mls.Sender
+	Confirmation []byte `tls:"head=1"`
+}
+
+// commit_id is the SHA-256 of the commit's identity. Two encodings of one
+// commit share it.
+func commit_id(commit_bytes []byte) ([]byte, error) {
+	var commit_pt mls.MLSPlaintext
+	if _, err := syntax.Unmarshal(commit_bytes, &commit_pt); err != nil {
+		return nil, fmt.Errorf("unmarshal commit: %w", err)
+	}
+	return plaintext_commit_id(&commit_pt)
+}
+
+func plaintext_commit_id(commit_pt *mls.MLSPlaintext) ([]byte, error) {
+	if commit_pt.Content.Commit == nil {
+		return nil, errors.New("message is not a commit")
+	}
+	data, err := syntax.Marshal(commit_identity{
+		GroupID:      commit_pt.GroupID,
+		Epoch:        commit_pt.Epoch,
+		Sender:       commit_pt.Sender,
+		Confirmation: commit_pt.Content.Commit.Confirmation.Data,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal commit identity: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+func find_pending(session *Session, commit_hash_hex string) (int, error) {
+	for i, pending := range session.Pending {
+		if commit_hash(pending.Commit) == commit_hash_hex {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no pending commit %s", commit_hash_hex)
+}
+
+func commit_hash(commit_bytes []byte) string {
+	sum := sha256.Sum256(commit_bytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// pending_epoch is the epoch the commit was made in.
+func pending_epoch(pending *PendingCommit) uint64 {
+	if pending.NextState == nil {
+		return 0
+	}
+	return uint64(pending.NextState.Epoch) - 1
+}
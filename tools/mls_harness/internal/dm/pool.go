@@ -0,0 +1,124 @@
+package dm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// PooledKeyPackage is one KeyPackage of a participant's publishable pool. Each
+// has its own HPKE init key, and a Welcome may consume it once: joining erases
+// its init secret and marks it consumed, and a later Welcome for it is
+// refused. The KeyPackage from KeyPackage stays the participant's reusable
+// last resort.
+type PooledKeyPackage struct {
+	KeyPackage []byte
+	InitSecret []byte
+	Consumed   bool
+}
+
+// max_pool_batch bounds one KeyPackagePool call.
+const max_pool_batch = 1000
+
+// KeyPackagePool adds count KeyPackages to the participant's pool and returns
+// them. The seed drives their init secrets, so a seed that repeats an earlier
+// batch is refused rather than publishing the same init keys twice. A secure
+// participant takes no seed and dates each KeyPackage from now.
+func KeyPackagePool(participant_b64 string, count int, seed int64) (string, []string, error) {
+	if participant_b64 == "" {
+		return "", nil, errors.New("participant is required")
+	}
+	if count < 0 || count > max_pool_batch {
+		return "", nil, fmt.Errorf("count must be between 0 and %d", max_pool_batch)
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", nil, errors.New("participant state not initialized")
+	}
+
+	sig_priv, cred, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return "", nil, fmt.Errorf("build identity: %w", err)
+	}
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
+	kps := []string{}
+	for i := 0; i < count; i++ {
+		init_secret := fresh_secret(rng, init_secret_label)
+		kp, err := build_keypackage(participant.Suite, init_secret, cred, sig_priv, participant.IdentityBinding, new_lifetime(participant))
+		if err != nil {
+			return "", nil, err
+		}
+		kp_bytes, err := syntax.Marshal(*kp)
+		if err != nil {
+			return "", nil, fmt.Errorf("marshal keypackage: %w", err)
+		}
+		if pooled_keypackage(participant, kp_bytes) != nil {
+			return "", nil, errors.New("keypackage already in the pool; use another seed")
+		}
+		participant.Pool = append(participant.Pool, &PooledKeyPackage{KeyPackage: kp_bytes, InitSecret: init_secret})
+		kps = append(kps, encode_artifact(artifact_keypackage, nil, 0, kp_bytes))
+	}
+
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, kps, nil
+}
+
+// PoolCounts reports how many pooled KeyPackages are still available and how
+// many have been consumed by Welcomes.
+func PoolCounts(participant_b64 string) (int, int, error) {
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return 0, 0, errors.New("participant state not initialized")
+	}
+	available, consumed := 0, 0
+	for _, pooled := range participant.Pool {
+		if pooled.Consumed {
+			consumed++
+		} else {
+			available++
+		}
+	}
+	return available, consumed, nil
+}
+
+func pooled_keypackage(participant *Participant, kp_bytes []byte) *PooledKeyPackage {
+	for _, pooled := range participant.Pool {
+		if bytes.Equal(pooled.KeyPackage, kp_bytes) {
+			return pooled
+		}
+	}
+	return nil
+}
+
+// welcome_pool_entry finds the pooled KeyPackage a Welcome was encrypted to,
+// or nil if it names none of them.
+func welcome_pool_entry(participant *Participant, welcome *mls.Welcome) *PooledKeyPackage {
+	for _, pooled := range participant.Pool {
+		hash := welcome.CipherSuite.Digest(pooled.KeyPackage)
+		for _, secrets := range welcome.Secrets {
+			if bytes.Equal(hash, secrets.KeyPackageHash) {
+				return pooled
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,545 @@
+package dm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// The Propose* functions queue one proposal in the caller's state and return it
+// for the delivery service; nothing changes epoch until CommitPending. Members
+// apply a peer's proposal through HandleProposal before the commit that covers it,
+// so any member can commit the batch, as a delivery service that collects
+// proposals from several members expects.
+
+// ProposeAdd queues an Add for the peer's KeyPackage.
+func ProposeAdd(participant_b64, group_id_b64, peer_kp_b64 string) (string, string, error) {
+	if peer_kp_b64 == "" {
+		return "", "", errors.New("peer keypackage is required")
+	}
+	participant, session, err := load_proposer(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", err
+	}
+	defer Zeroize(participant)
+	peer_kp, err := parse_keypackage(peer_kp_b64)
+	if err != nil {
+		return "", "", fmt.Errorf("parse peer keypackage: %w", err)
+	}
+	if err := check_peer_keypackage(peer_kp); err != nil {
+		return "", "", fmt.Errorf("peer keypackage: %w", err)
+	}
+	if err := check_peer_version(peer_kp, session.State); err != nil {
+		return "", "", err
+	}
+	if err := check_admin(session, session.State, session.State.Index); err != nil {
+		return "", "", err
+	}
+	add, err := session.State.Add(peer_kp)
+	if err != nil {
+		return "", "", fmt.Errorf("add peer: %w", err)
+	}
+	return queue_and_encode(participant, session.State, add)
+}
+
+// ProposeRemove queues a Remove for member, a leaf index or the credential
+// identity of exactly one occupied leaf.
+func ProposeRemove(participant_b64, group_id_b64, member string) (string, string, error) {
+	participant, session, err := load_proposer(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", err
+	}
+	defer Zeroize(participant)
+	if err := check_admin(session, session.State, session.State.Index); err != nil {
+		return "", "", err
+	}
+	remove, err := new_remove_proposal(session.State, member)
+	if err != nil {
+		return "", "", err
+	}
+	return queue_and_encode(participant, session.State, remove)
+}
+
+// ProposeUpdate queues an Update that gives the caller's leaf a fresh HPKE key.
+// The new leaf secret stays in the state until a commit covering the proposal
+// is applied, whoever sends that commit.
+func ProposeUpdate(participant_b64, group_id_b64 string, seed int64) (string, string, error) {
+	participant, session, err := load_proposer(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", err
+	}
+	defer Zeroize(participant)
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	update, err := new_update_proposal(session.State, rng)
+	if err != nil {
+		return "", "", err
+	}
+	return queue_and_encode(participant, session.State, update)
+}
+
+// Leave proposes removing the caller's own leaf, for another member to commit,
+// and marks the session left: Encrypt and the other send paths fail with
+// ErrLeft from then on, while messages sent before the commit still decrypt.
+// The commit that removes the caller fails its CommitApply with ErrRemoved. If
+// the epoch moves on without it, Leave again returns a proposal for the new
+// epoch; within one epoch it returns the queued one.
+func Leave(participant_b64, group_id_b64 string, seed int64) (string, string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", err
+	}
+	defer Zeroize(participant)
+	if len(session.Pending) > 0 {
+		return "", "", errors.New("a pending commit must be applied first")
+	}
+	session.Left = true
+	if leave := queued_leave(session.State); leave != nil {
+		leave_bytes, err := syntax.Marshal(*leave)
+		if err != nil {
+			return "", "", fmt.Errorf("marshal proposal: %w", err)
+		}
+		participant_b64, err = encode_participant(participant)
+		if err != nil {
+			return "", "", fmt.Errorf("encode participant: %w", err)
+		}
+		return participant_b64, encode_artifact(artifact_proposal, leave.GroupID, leave.Epoch, leave_bytes), nil
+	}
+	// An Ed25519 signature draws no randomness; the seed only pins crypto/rand
+	// in legacy mode.
+	_, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", err
+	}
+	defer release()
+
+	leave, err := session.State.Remove(session.State.Index)
+	if err != nil {
+		return "", "", fmt.Errorf("remove own leaf: %w", err)
+	}
+	return queue_and_encode(participant, session.State, leave)
+}
+
+// queued_leave returns the caller's own queued Remove of its leaf, if any.
+func queued_leave(state *mls.State) *mls.MLSPlaintext {
+	for i, pending := range state.PendingProposals {
+		remove := pending.Content.Proposal
+		if remove == nil || remove.Remove == nil || remove.Remove.Removed != state.Index {
+			continue
+		}
+		if pending.Sender.Type == mls.SenderTypeMember && mls.LeafIndex(pending.Sender.Sender) == state.Index {
+			return &state.PendingProposals[i]
+		}
+	}
+	return nil
+}
+
+// CommitPending commits every queued proposal, our own and those applied from
+// peers. It returns the participant, a Welcome when the batch adds members
+// (empty otherwise) and the commit, which stays pending until it is echoed back
+// through CommitApply.
+func CommitPending(participant_b64, group_id_b64 string, seed int64) (string, string, string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer Zeroize(participant)
+	if session.Left {
+		return "", "", "", ErrLeft
+	}
+	state := working_state(session)
+	if len(state.PendingProposals) == 0 {
+		return "", "", "", errors.New("no pending proposals to commit")
+	}
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer release()
+
+	welcome_b64, commit_b64, err := commit_pending(session, state, random_bytes(rng, 32))
+	if err != nil {
+		return "", "", "", err
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", "", "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, welcome_b64, commit_b64, nil
+}
+
+// HandleProposal validates a proposal authored by another member and queues it,
+// so that a later commit covering it applies, whoever sends that commit. A
+// proposal that is already queued, such as our own echoed back by the delivery
+// service, is reported as a noop. It may arrive while our own commit is still
+// pending; that commit then supersedes it.
+func HandleProposal(participant_b64, group_id_b64, proposal_b64 string) (string, bool, error) {
+	if participant_b64 == "" {
+		return "", false, errors.New("participant is required")
+	}
+	if proposal_b64 == "" {
+		return "", false, errors.New("proposal is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", false, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	proposal_bytes, header, err := decode_artifact(artifact_proposal, proposal_b64)
+	if err != nil {
+		return "", false, fmt.Errorf("decode proposal: %w", err)
+	}
+	var proposal_pt mls.MLSPlaintext
+	if _, err := syntax.Unmarshal(proposal_bytes, &proposal_pt); err != nil {
+		return "", false, fmt.Errorf("unmarshal proposal: %w", err)
+	}
+	if err := header.check(proposal_pt.GroupID, proposal_pt.Epoch); err != nil {
+		return "", false, err
+	}
+
+	session, err := message_session(participant, group_id_b64, proposal_pt.GroupID)
+	if err != nil {
+		return "", false, err
+	}
+	noop, err := handle_proposal(session, &proposal_pt)
+	if err != nil {
+		return "", false, err
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", false, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, noop, nil
+}
+
+// handle_proposal checks what go-mls would otherwise only find out when the
+// commit is applied (and, for an Update in another suite, panic on) and queues
+// the proposal, after the session's admin list. Group, epoch, sender and
+// signature are checked by State.Handle.
+func handle_proposal(session *Session, proposal_pt *mls.MLSPlaintext) (bool, error) {
+	state := session.State
+	proposal := proposal_pt.Content.Proposal
+	if proposal == nil {
+		return false, errors.New("message is not a proposal")
+	}
+	if queued(state, proposal_pt) {
+		return true, nil
+	}
+	if proposal_pt.Sender.Type != mls.SenderTypeMember {
+		return false, errors.New("proposal from non-member")
+	}
+	if proposal_pt.Epoch != state.Epoch {
+		return false, fmt.Errorf("%w: proposal is for epoch %d, current epoch %d", ErrEpochMismatch, proposal_pt.Epoch, state.Epoch)
+	}
+
+	switch {
+	case proposal.Add != nil:
+		if err := check_keypackage(state, proposal.Add.KeyPackage); err != nil {
+			return false, fmt.Errorf("add: %w", err)
+		}
+	case proposal.Update != nil:
+		sender := mls.LeafIndex(proposal_pt.Sender.Sender)
+		if sender == state.Index {
+			// The leaf secret for our own Update lives only in the state that
+			// created it, which would have it queued already.
+			return false, errors.New("update: own update proposal is not queued in this state")
+		}
+		if err := check_keypackage(state, proposal.Update.KeyPackage); err != nil {
+			return false, fmt.Errorf("update: %w", err)
+		}
+		if uint64(sender) < uint64(state.Tree.Size()) {
+			current, ok := state.Tree.KeyPackage(sender)
+			if ok && !current.Credential.Equals(proposal.Update.KeyPackage.Credential) {
+				return false, errors.New("update: credential differs from the sender's leaf")
+			}
+		}
+	case proposal.Remove != nil:
+		if _, err := find_leaf(state, strconv.FormatUint(uint64(proposal.Remove.Removed), 10)); err != nil {
+			return false, fmt.Errorf("remove: %w", err)
+		}
+	default:
+		return false, errors.New("unsupported proposal type")
+	}
+	if err := check_proposal_admin(session, state, proposal_pt); err != nil {
+		return false, err
+	}
+
+	if _, err := state.Handle(proposal_pt); err != nil {
+		return false, fmt.Errorf("handle proposal: %w", err)
+	}
+	return false, nil
+}
+
+func check_keypackage(state *mls.State, kp mls.KeyPackage) error {
+	if kp.CipherSuite != state.CipherSuite {
+		return fmt.Errorf("keypackage suite %s does not match group suite %s", kp.CipherSuite.String(), state.CipherSuite.String())
+	}
+	if !kp.Verify() {
+		return errors.New("invalid keypackage")
+	}
+	return nil
+}
+
+// queued reports whether the exact proposal is already in the state's queue.
+func queued(state *mls.State, proposal_pt *mls.MLSPlaintext) bool {
+	data, err := syntax.Marshal(*proposal_pt)
+	if err != nil {
+		return false
+	}
+	for _, pending := range state.PendingProposals {
+		other, err := syntax.Marshal(pending)
+		if err == nil && bytes.Equal(data, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove proposes removing one member and commits it. member is a leaf index or
+// the credential identity of exactly one occupied leaf. The remaining members
+// must apply the returned proposal and then the commit through CommitApply.
+func Remove(participant_b64, group_id_b64, member string, seed int64) (string, string, []string, error) {
+	if member == "" {
+		return "", "", nil, errors.New("member to remove is required")
+	}
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer Zeroize(participant)
+	state := working_state(session)
+	if err := check_admin(session, state, state.Index); err != nil {
+		return "", "", nil, err
+	}
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer release()
+
+	remove, err := new_remove_proposal(state, member)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return commit_own_proposal(participant, session, state, remove, random_bytes(rng, 32))
+}
+
+// Update replaces the caller's leaf with a fresh HPKE key under the same
+// credential and commits it, so a client can rotate its leaf key periodically.
+// Other members apply the returned proposal and then the commit through
+// CommitApply.
+func Update(participant_b64, group_id_b64 string, seed int64) (string, string, []string, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer Zeroize(participant)
+	state := working_state(session)
+	rng, release, err := participant_random(participant, seed)
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer release()
+
+	update, err := new_update_proposal(state, rng)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return commit_own_proposal(participant, session, state, update, random_bytes(rng, 32))
+}
+
+// load_proposer loads a session with no commit of our own pending; a proposal
+// made now would be for an epoch that is about to end.
+func load_proposer(participant_b64, group_id_b64 string) (*Participant, *Session, error) {
+	participant, session, err := load_session(participant_b64, group_id_b64)
+	if err != nil {
+		return nil, nil, err
+	}
+	if session.Left {
+		return nil, nil, ErrLeft
+	}
+	if len(session.Pending) > 0 {
+		return nil, nil, errors.New("a pending commit must be applied first")
+	}
+	return participant, session, nil
+}
+
+func new_remove_proposal(state *mls.State, member string) (*mls.MLSPlaintext, error) {
+	target, err := find_leaf(state, member)
+	if err != nil {
+		return nil, err
+	}
+	if target == state.Index {
+		return nil, errors.New("cannot remove own leaf")
+	}
+	remove, err := state.Remove(target)
+	if err != nil {
+		return nil, fmt.Errorf("remove member: %w", err)
+	}
+	return remove, nil
+}
+
+func new_update_proposal(state *mls.State, rng io.Reader) (*mls.MLSPlaintext, error) {
+	current, ok := state.Tree.KeyPackage(state.Index)
+	if !ok {
+		return nil, errors.New("own leaf is blank")
+	}
+	leaf_secret := random_bytes(rng, 32)
+	kp, err := mls.NewKeyPackageWithSecret(state.CipherSuite, leaf_secret, &current.Credential, state.IdentityPriv)
+	if err != nil {
+		return nil, fmt.Errorf("create key package: %w", err)
+	}
+	if err := kp.Extensions.Add(app_version{Version: AppProtocolVersion}); err != nil {
+		return nil, fmt.Errorf("add app version: %w", err)
+	}
+	// The credential key is unchanged, so the leaf's identity binding still holds.
+	var binding IdentityBinding
+	if found, err := current.Extensions.Find(&binding); found && err == nil {
+		if err := kp.Extensions.Add(binding); err != nil {
+			return nil, fmt.Errorf("add identity binding: %w", err)
+		}
+	}
+	if err := harness.MakeKeyPackageDeterministic(kp, state.IdentityPriv); err != nil {
+		return nil, fmt.Errorf("stabilize key package: %w", err)
+	}
+	update, err := state.Update(leaf_secret, nil, *kp)
+	if err != nil {
+		return nil, fmt.Errorf("update leaf: %w", err)
+	}
+	return update, nil
+}
+
+// queue_proposal adds a proposal the participant just created to its own queue
+// and returns it encoded for the other members.
+func queue_proposal(state *mls.State, proposal *mls.MLSPlaintext) (string, error) {
+	proposal_bytes, err := syntax.Marshal(*proposal)
+	if err != nil {
+		return "", fmt.Errorf("marshal proposal: %w", err)
+	}
+	if _, err := state.Handle(proposal); err != nil {
+		return "", fmt.Errorf("handle proposal: %w", err)
+	}
+	return encode_artifact(artifact_proposal, proposal.GroupID, proposal.Epoch, proposal_bytes), nil
+}
+
+func queue_and_encode(participant *Participant, state *mls.State, proposal *mls.MLSPlaintext) (string, string, error) {
+	proposal_b64, err := queue_proposal(state, proposal)
+	if err != nil {
+		return "", "", err
+	}
+	participant_b64, err := encode_participant(participant)
+	if err != nil {
+		return "", "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, proposal_b64, nil
+}
+
+// commit_pending commits the queued proposals and keeps the commit pending until
+// it is echoed back. The Welcome is empty when no member is added.
+func commit_pending(session *Session, state *mls.State, commit_secret []byte) (string, string, error) {
+	commit_pt, welcome, next_state, err := state.Commit(commit_secret)
+	if err != nil {
+		return "", "", fmt.Errorf("commit: %w", err)
+	}
+	commit_bytes, err := syntax.Marshal(*commit_pt)
+	if err != nil {
+		return "", "", fmt.Errorf("marshal commit: %w", err)
+	}
+	var welcome_bytes []byte
+	if len(commit_pt.Content.Commit.Commit.Adds) > 0 {
+		if welcome_bytes, err = syntax.Marshal(*welcome); err != nil {
+			return "", "", fmt.Errorf("marshal welcome: %w", err)
+		}
+	}
+
+	push_pending(session, commit_bytes, welcome_bytes, next_state)
+
+	welcome_b64 := ""
+	if welcome_bytes != nil {
+		welcome_b64 = encode_artifact(artifact_welcome, next_state.GroupID, next_state.Epoch, welcome_bytes)
+	}
+	return welcome_b64, encode_artifact(artifact_commit, commit_pt.GroupID, commit_pt.Epoch, commit_bytes), nil
+}
+
+// commit_own_proposal queues a proposal the participant just created and
+// commits it on its own. It returns the encoded participant, the commit and the
+// proposal for the other members.
+func commit_own_proposal(participant *Participant, session *Session, state *mls.State, proposal *mls.MLSPlaintext, commit_secret []byte) (string, string, []string, error) {
+	proposal_b64, err := queue_proposal(state, proposal)
+	if err != nil {
+		return "", "", nil, err
+	}
+	_, commit_b64, err := commit_pending(session, state, commit_secret)
+	if err != nil {
+		return "", "", nil, err
+	}
+	participant_b64, err := encode_participant(participant)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, commit_b64, []string{proposal_b64}, nil
+}
+
+// find_leaf resolves a leaf index or a credential identity to an occupied leaf.
+func find_leaf(state *mls.State, member string) (mls.LeafIndex, error) {
+	if member == "" {
+		return 0, errors.New("member is required")
+	}
+	size := state.Tree.Size()
+	if index, err := strconv.ParseUint(member, 10, 32); err == nil {
+		if index < uint64(size) {
+			if _, ok := state.Tree.KeyPackage(mls.LeafIndex(index)); ok {
+				return mls.LeafIndex(index), nil
+			}
+		}
+		return 0, fmt.Errorf("leaf %d is not an occupied leaf", index)
+	}
+
+	var matches []mls.LeafIndex
+	for i := mls.LeafIndex(0); i < mls.LeafIndex(size); i++ {
+		kp, ok := state.Tree.KeyPackage(i)
+		if ok && string(kp.Credential.Identity()) == member {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no member with credential %q", member)
+	case 1:
+		return matches[0], nil
+	default:
+		return 0, fmt.Errorf("credential %q matches leaves %v; pass a leaf index", member, matches)
+	}
+}
+
+// removes_own_leaf reports whether commit_pt commits a queued Remove proposal
+// for the state's own leaf.
+func removes_own_leaf(state *mls.State, commit_pt *mls.MLSPlaintext) bool {
+	if commit_pt.Content.Commit == nil {
+		return false
+	}
+	for _, id := range commit_pt.Content.Commit.Commit.Removes {
+		for _, pending := range state.PendingProposals {
+			remove := pending.Content.Proposal
+			if remove == nil || remove.Remove == nil || remove.Remove.Removed != state.Index {
+				continue
+			}
+			data, err := syntax.Marshal(pending)
+			if err == nil && bytes.Equal(state.CipherSuite.Digest(data), id.Hash) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package dm
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// A seeded dm operation draws its secrets from its own DRBG: a ChaCha20
+// keystream under a key expanded from the seed with HKDF. The DRBG is passed
+// down to the helpers that pick secrets and nothing process-wide changes, so
+// operations can run concurrently. go-mls still takes HPKE ephemeral keys and
+// nonces from crypto/rand, so a seed fixes every secret and KeyPackage but not
+// every byte of a Commit or Welcome.
+//
+// Vectors recorded before the DRBG came from swapping crypto/rand.Reader for a
+// math/rand stream. SetLegacySeededRand brings that back to reproduce them;
+// legacy operations run one at a time.
+const seeded_drbg_label = "mls-harness dm seeded drbg v1"
+
+type seeded_drbg struct {
+	stream *chacha20.Cipher
+}
+
+func new_seeded_drbg(seed int64) *seeded_drbg {
+	var seed_bytes [8]byte
+	binary.BigEndian.PutUint64(seed_bytes[:], uint64(seed))
+	key := hkdf_sha256(seed_bytes[:], nil, []byte(seeded_drbg_label), chacha20.KeySize)
+	stream, err := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+	if err != nil {
+		panic(err)
+	}
+	return &seeded_drbg{stream: stream}
+}
+
+func (d *seeded_drbg) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	d.stream.XORKeyStream(p, p)
+	return len(p), nil
+}
+
+// system_entropy is crypto/rand as it was at startup. Legacy seeded operations
+// swap crypto/rand.Reader for a deterministic stream, which must never pick
+// nonces or a secure participant's secrets.
+var system_entropy = crand.Reader
+
+var (
+	legacy_rand    atomic.Bool
+	legacy_rand_mu sync.Mutex
+)
+
+// SetLegacySeededRand makes seeded dm operations swap crypto/rand.Reader for a
+// math/rand stream again, as they did when the checked-in seeded vectors were
+// recorded. It is for reproducing those vectors only.
+func SetLegacySeededRand(enabled bool) {
+	legacy_rand.Store(enabled)
+}
+
+// seeded_random returns the random source of one seeded operation and the func
+// to call once the operation is done.
+func seeded_random(seed int64) (io.Reader, func()) {
+	if !legacy_rand.Load() {
+		return new_seeded_drbg(seed), func() {}
+	}
+	legacy_rand_mu.Lock()
+	rng := harness.DeterministicRNGWithSeed(seed)
+	restore := harness.OverrideCryptoRand(rng)
+	return rng, func() {
+		restore()
+		legacy_rand_mu.Unlock()
+	}
+}
+
+func random_bytes(rng io.Reader, n int) []byte {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rng, b); err != nil {
+		panic(err)
+	}
+	return b
+}
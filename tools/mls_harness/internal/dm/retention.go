@@ -0,0 +1,332 @@
+package dm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// RetentionPolicy bounds the key material kept for application messages that
+// arrive out of order. Within an epoch, go-mls keeps the keys of generations a
+// sender skipped; MaxSkippedGenerations caps both how far ahead of a sender's
+// ratchet a message may be and how many such keys stay cached per sender.
+// MaxPastEpochs keeps that many earlier epochs able to decrypt late messages.
+// Every retained key is one a stolen state could use, so the defaults keep no
+// past epochs.
+type RetentionPolicy struct {
+	MaxSkippedGenerations uint32 `json:"max_skipped_generations"`
+	MaxPastEpochs         uint32 `json:"max_past_epochs"`
+}
+
+// DefaultRetention is the policy of new participants and of participants
+// stored before the policy existed.
+var DefaultRetention = RetentionPolicy{MaxSkippedGenerations: 1000, MaxPastEpochs: 0}
+
+// ErrOutsideWindow is returned by Decrypt for a message the retention policy
+// no longer keeps keys for, or would have to skip too far ahead to reach.
+var ErrOutsideWindow = errors.New("message outside the retention window")
+
+// SetRetention replaces the participant's retention policy. Keys and epochs
+// beyond a tighter policy are dropped immediately.
+func SetRetention(participant_b64 string, policy RetentionPolicy) (string, error) {
+	if participant_b64 == "" {
+		return "", errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	participant.Retention = policy
+	for _, session := range participant.Sessions {
+		trim_past_epochs(session, policy)
+		trim_key_caches(session.State, policy)
+		for _, past := range session.PastEpochs {
+			trim_key_caches(past, policy)
+		}
+	}
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, nil
+}
+
+// advance_state moves the session to next, keeping the current epoch for late
+// messages if the policy asks for past epochs.
+func advance_state(session *Session, next *mls.State, policy RetentionPolicy) {
+	if policy.MaxPastEpochs > 0 && next.Epoch != session.State.Epoch {
+		session.PastEpochs = append([]*mls.State{strip_past_state(session.State)}, session.PastEpochs...)
+	}
+	note_rotation(session, next)
+	session.State = next
+	trim_past_epochs(session, policy)
+}
+
+func trim_past_epochs(session *Session, policy RetentionPolicy) {
+	if uint32(len(session.PastEpochs)) > policy.MaxPastEpochs {
+		session.PastEpochs = session.PastEpochs[:policy.MaxPastEpochs]
+	}
+}
+
+// strip_past_state drops what a past epoch does not need to decrypt and verify
+// application messages: the path secrets and the secrets that lead to later
+// epochs or to exports.
+func strip_past_state(state *mls.State) *mls.State {
+	state.TreePriv = mls.TreeKEMPrivateKey{Suite: state.CipherSuite, Index: state.Index, PathSecrets: map[mls.NodeIndex]mls.Bytes1{}}
+	state.PendingProposals = nil
+	// go-mls clones share this map with the next epoch, so replace it rather
+	// than clear it.
+	updates := reflect.ValueOf(&state.PendingUpdates).Elem()
+	updates.Set(reflect.MakeMap(updates.Type()))
+	state.Keys.EpochSecret = nil
+	state.Keys.InitSecret = nil
+	state.Keys.ExporterSecret = nil
+	state.Keys.ConfirmationKey = nil
+	return state
+}
+
+// unprotect decrypts an application message with the state for its epoch,
+// after checking that the claimed generation is inside the window so go-mls
+// never ratchets further ahead than the policy allows. It also returns the
+// sender and the state that decrypted the message.
+func unprotect(session *Session, ct *mls.MLSCiphertext, policy RetentionPolicy) ([]byte, mls.LeafIndex, *mls.State, error) {
+	state, err := epoch_state(session, ct, policy)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	sender, generation, guard, err := sender_data(state, ct)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if err := check_sender(session, state, sender); err != nil {
+		return nil, 0, nil, err
+	}
+	next, cached := ratchet_position(state, ct.ContentType, sender, generation)
+	switch {
+	case cached:
+	case generation < next:
+		return nil, 0, nil, fmt.Errorf("%w: generation %d from leaf %d was already used or dropped", ErrOutsideWindow, generation, sender)
+	case generation-next > policy.MaxSkippedGenerations:
+		return nil, 0, nil, fmt.Errorf("%w: generation %d from leaf %d skips %d, more than %d", ErrOutsideWindow, generation, sender, generation-next, policy.MaxSkippedGenerations)
+	}
+
+	var pt []byte
+	if cached && ct.ContentType == mls.ContentTypeApplication {
+		pt, err = open_cached(state, ct, sender, generation, guard)
+	} else if pt, err = state.Unprotect(ct); err != nil {
+		err = fmt.Errorf("unprotect: %w", err)
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	trim_key_caches(state, policy)
+	return pt, sender, state, nil
+}
+
+// check_sender refuses a message whose sender leaf is blank in the epoch it
+// was sent in, or has since been emptied or taken by another credential. Any
+// member can derive every leaf's message keys, so without this one could speak
+// as a member who is gone, and a removed member who never applied its removal
+// could keep writing into epochs the others retain. The check runs before the
+// ratchet moves, so a refused message spends no key.
+func check_sender(session *Session, state *mls.State, sender mls.LeafIndex) error {
+	kp, ok := state.Tree.KeyPackage(sender)
+	if !ok {
+		return fmt.Errorf("%w: leaf %d is blank in epoch %d", ErrSenderRemoved, sender, state.Epoch)
+	}
+	if state == session.State {
+		return nil
+	}
+	current, ok := session.State.Tree.KeyPackage(sender)
+	if !ok || !current.Credential.Equals(kp.Credential) {
+		return fmt.Errorf("%w: leaf %d of epoch %d is no longer in the group at epoch %d", ErrSenderRemoved, sender, state.Epoch, session.State.Epoch)
+	}
+	return nil
+}
+
+// epoch_state picks the current state or the retained past epoch ct is from.
+func epoch_state(session *Session, ct *mls.MLSCiphertext, policy RetentionPolicy) (*mls.State, error) {
+	if ct.Epoch == session.State.Epoch {
+		return session.State, nil
+	}
+	for _, past := range session.PastEpochs {
+		if past.Epoch == ct.Epoch {
+			return past, nil
+		}
+	}
+	if ct.Epoch > session.State.Epoch {
+		return nil, fmt.Errorf("%w: message is from epoch %d, ahead of current epoch %d", ErrEpochMismatch, ct.Epoch, session.State.Epoch)
+	}
+	return nil, fmt.Errorf("%w: epoch %d is not retained (current %d, keeping %d past)", ErrOutsideWindow, ct.Epoch, session.State.Epoch, policy.MaxPastEpochs)
+}
+
+// sender_data opens the sender data the way State.Unprotect does, to learn the
+// sender, generation and reuse guard a ciphertext claims.
+func sender_data(state *mls.State, ct *mls.MLSCiphertext) (mls.LeafIndex, uint32, [4]byte, error) {
+	var guard [4]byte
+	aad, err := syntax.Marshal(struct {
+		GroupID         []byte `tls:"head=1"`
+		Epoch           mls.Epoch
+		ContentType     mls.ContentType
+		SenderDataNonce []byte `tls:"head=1"`
+	}{ct.GroupID, ct.Epoch, ct.ContentType, ct.SenderDataNonce})
+	if err != nil {
+		return 0, 0, guard, fmt.Errorf("sender data aad: %w", err)
+	}
+	aead, err := state.CipherSuite.NewAEAD(state.Keys.SenderDataKey)
+	if err != nil {
+		return 0, 0, guard, fmt.Errorf("sender data key: %w", err)
+	}
+	if len(ct.SenderDataNonce) != aead.NonceSize() {
+		return 0, 0, guard, errors.New("sender data nonce has the wrong length")
+	}
+	data, err := aead.Open(nil, ct.SenderDataNonce, ct.EncryptedSenderData, aad)
+	if err != nil {
+		return 0, 0, guard, errors.New("sender data decryption failed")
+	}
+	var sender_data struct {
+		Sender     mls.LeafIndex
+		Generation uint32
+		ReuseGuard [4]byte
+	}
+	if _, err := syntax.Unmarshal(data, &sender_data); err != nil {
+		return 0, 0, guard, fmt.Errorf("sender data: %w", err)
+	}
+	return sender_data.Sender, sender_data.Generation, sender_data.ReuseGuard, nil
+}
+
+// open_cached decrypts an application message whose key go-mls cached when it
+// skipped the generation. State.Unprotect cannot: it erases a cached key before
+// using it, zeroing the copy it is about to decrypt with. This repeats its
+// content decryption and signature check, then erases the key itself.
+func open_cached(state *mls.State, ct *mls.MLSCiphertext, sender mls.LeafIndex, generation uint32, guard [4]byte) ([]byte, error) {
+	ratchet := state.Keys.ApplicationKeys.Ratchets[sender]
+	cached := ratchet.Cache[generation]
+	defer func() {
+		for _, secret := range [][]byte{cached.Key, cached.Nonce} {
+			for i := range secret {
+				secret[i] = 0
+			}
+		}
+		delete(ratchet.Cache, generation)
+	}()
+
+	aad, err := syntax.Marshal(struct {
+		GroupID             []byte `tls:"head=1"`
+		Epoch               mls.Epoch
+		ContentType         mls.ContentType
+		AuthenticatedData   []byte `tls:"head=4"`
+		SenderDataNonce     []byte `tls:"head=1"`
+		EncryptedSenderData []byte `tls:"head=1"`
+	}{ct.GroupID, ct.Epoch, ct.ContentType, ct.AuthenticatedData, ct.SenderDataNonce, ct.EncryptedSenderData})
+	if err != nil {
+		return nil, fmt.Errorf("content aad: %w", err)
+	}
+	aead, err := state.CipherSuite.NewAEAD(cached.Key)
+	if err != nil {
+		return nil, fmt.Errorf("content key: %w", err)
+	}
+	nonce := append([]byte(nil), cached.Nonce...)
+	for i := range guard {
+		nonce[i] ^= guard[i]
+	}
+	data, err := aead.Open(nil, nonce, ct.Ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("unprotect: content decryption failed")
+	}
+
+	var content mls.MLSPlaintextContent
+	var signature mls.Signature
+	if _, err := syntax.NewReadStream(data).ReadAll(&content, &signature); err != nil {
+		return nil, fmt.Errorf("unprotect: content: %w", err)
+	}
+	if content.Type() != mls.ContentTypeApplication {
+		return nil, errors.New("unprotect: not an application message")
+	}
+	kp, ok := state.Tree.KeyPackage(sender)
+	if !ok {
+		return nil, errors.New("unprotect: message from a blank leaf")
+	}
+	tbs, err := syntax.Marshal(struct {
+		Context           mls.GroupContext
+		GroupID           []byte `tls:"head=1"`
+		Epoch             mls.Epoch
+		Sender            mls.Sender
+		AuthenticatedData []byte `tls:"head=4"`
+		Content           mls.MLSPlaintextContent
+	}{
+		Context: mls.GroupContext{
+			GroupID:                 state.GroupID,
+			Epoch:                   state.Epoch,
+			TreeHash:                state.Tree.RootHash(),
+			ConfirmedTranscriptHash: state.ConfirmedTranscriptHash,
+			Extensions:              state.Extensions,
+		},
+		GroupID:           state.GroupID,
+		Epoch:             state.Epoch,
+		Sender:            mls.Sender{Type: mls.SenderTypeMember, Sender: uint32(sender)},
+		AuthenticatedData: ct.AuthenticatedData,
+		Content:           content,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unprotect: signed content: %w", err)
+	}
+	if !state.Scheme.Verify(kp.Credential.PublicKey(), tbs, signature.Data) {
+		return nil, errors.New("unprotect: invalid message signature")
+	}
+	return content.Application.Data, nil
+}
+
+// ratchet_position reports the sender's next unused generation and whether the
+// key for generation is cached.
+func ratchet_position(state *mls.State, content mls.ContentType, sender mls.LeafIndex, generation uint32) (uint32, bool) {
+	keys := state.Keys.HandshakeKeys
+	if content == mls.ContentTypeApplication {
+		keys = state.Keys.ApplicationKeys
+	}
+	if keys == nil {
+		return 0, false
+	}
+	ratchet, ok := keys.Ratchets[sender]
+	if !ok {
+		return 0, false
+	}
+	_, cached := ratchet.Cache[generation]
+	return ratchet.NextGeneration, cached
+}
+
+// trim_key_caches keeps the newest MaxSkippedGenerations cached keys per sender.
+func trim_key_caches(state *mls.State, policy RetentionPolicy) {
+	if state.Keys.ApplicationKeys != nil {
+		for _, ratchet := range state.Keys.ApplicationKeys.Ratchets {
+			trim_generations(ratchet.Cache, policy.MaxSkippedGenerations)
+		}
+	}
+	if state.Keys.HandshakeKeys != nil {
+		for _, ratchet := range state.Keys.HandshakeKeys.Ratchets {
+			trim_generations(ratchet.Cache, policy.MaxSkippedGenerations)
+		}
+	}
+}
+
+func trim_generations[V any](cache map[uint32]V, keep uint32) {
+	if uint32(len(cache)) <= keep {
+		return
+	}
+	generations := make([]uint32, 0, len(cache))
+	for generation := range cache {
+		generations = append(generations, generation)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	for _, generation := range generations[:uint32(len(generations))-keep] {
+		delete(cache, generation)
+	}
+}
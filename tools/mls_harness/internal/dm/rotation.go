@@ -0,0 +1,94 @@
+package dm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// RotationPolicy says when MaybeRotate refreshes the participant's leaf in a
+// group: once it has sent MaxMessages application messages, or MaxEpochs
+// epochs have passed, since the leaf last changed. Zero turns a bound off, so
+// the zero policy, which new participants start with, never rotates. Any
+// commit that replaces the leaf counts, whether the participant's own Update
+// or another member committing its proposal.
+type RotationPolicy struct {
+	MaxMessages uint32 `json:"max_messages"`
+	MaxEpochs   uint32 `json:"max_epochs"`
+}
+
+// SetRotation replaces the participant's rotation policy. It applies to every
+// group the participant is in; what the policy counts is kept per group.
+func SetRotation(participant_b64 string, policy RotationPolicy) (string, error) {
+	if participant_b64 == "" {
+		return "", errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", errors.New("participant state not initialized")
+	}
+	participant.Rotation = policy
+	participant_b64, err = encode_participant(participant)
+	if err != nil {
+		return "", fmt.Errorf("encode participant: %w", err)
+	}
+	return participant_b64, nil
+}
+
+// MaybeRotate is Update when the rotation policy has triggered in the group,
+// and otherwise returns the participant unchanged with an empty commit and no
+// proposals. It waits while a commit of the participant's own is pending, and
+// never rotates a group the participant has left.
+func MaybeRotate(participant_b64, group_id_b64 string, seed int64) (string, string, []string, error) {
+	if participant_b64 == "" {
+		return "", "", nil, errors.New("participant is required")
+	}
+	participant, err := decode_participant(participant_b64)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("decode participant: %w", err)
+	}
+	defer Zeroize(participant)
+	if participant == nil {
+		return "", "", nil, errors.New("participant state not initialized")
+	}
+	session, err := find_session(participant, group_id_b64)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if !rotation_due(participant.Rotation, session) {
+		return participant_b64, "", nil, nil
+	}
+	return Update(participant_b64, group_id_b64, seed)
+}
+
+func rotation_due(policy RotationPolicy, session *Session) bool {
+	if session.Left || len(session.Pending) > 0 {
+		return false
+	}
+	if policy.MaxMessages > 0 && session.SentSinceRotation >= policy.MaxMessages {
+		return true
+	}
+	since := session.RotatedEpoch
+	if session.JoinedEpoch > since {
+		since = session.JoinedEpoch
+	}
+	return policy.MaxEpochs > 0 && uint64(session.State.Epoch)-since >= uint64(policy.MaxEpochs)
+}
+
+// note_rotation restarts what the rotation policy counts when next gives the
+// participant's leaf a new init key.
+func note_rotation(session *Session, next *mls.State) {
+	before, _ := session.State.Tree.KeyPackage(session.State.Index)
+	after, ok := next.Tree.KeyPackage(next.Index)
+	if !ok || bytes.Equal(before.InitKey.Data, after.InitKey.Data) {
+		return
+	}
+	session.SentSinceRotation = 0
+	session.RotatedEpoch = uint64(next.Epoch)
+}
@@ -0,0 +1,328 @@
+package dm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Sealed participant blobs keep ratchet secrets out of storage the caller does
+// not trust (browser localStorage, a state directory on a shared disk):
+//
+//	magic "MLSS" | version uint8 | kdf uint8 | iterations uint32 | salt[16] | nonce[24] | ciphertext
+//
+// The ciphertext is XChaCha20-Poly1305 over the unsealed participant bytes with
+// everything before it as associated data. A raw key is stretched with
+// HKDF-SHA256 and a passphrase with PBKDF2-HMAC-SHA256; Argon2id would be the
+// better passphrase KDF but is not in the vendored x/crypto, and the kdf byte
+// leaves room to add it without breaking stored blobs.
+const (
+	sealed_magic      = "MLSS"
+	sealed_version_v1 = 1
+	seal_kdf_hkdf     = 1
+	seal_kdf_pbkdf2   = 2
+	seal_salt_len     = 16
+	seal_header_len   = len(sealed_magic) + 2 + 4 + seal_salt_len
+	seal_min_key_len  = 32
+
+	// SealPassphraseIterations is the PBKDF2 work factor for new blobs.
+	SealPassphraseIterations = 600_000
+)
+
+// ErrSealed is returned by the dm entry points for a sealed participant when no
+// state key is set.
+var ErrSealed = errors.New("participant state is sealed; set a state key")
+
+// SealKey is the caller's secret for sealing participants: either Key, at least
+// 32 bytes of key material, or a Passphrase.
+type SealKey struct {
+	Key        []byte
+	Passphrase string
+}
+
+func (key SealKey) validate() error {
+	switch {
+	case len(key.Key) > 0 && key.Passphrase != "":
+		return errors.New("seal key: pass a key or a passphrase, not both")
+	case key.Passphrase != "":
+		return nil
+	case len(key.Key) >= seal_min_key_len:
+		return nil
+	case len(key.Key) > 0:
+		return fmt.Errorf("seal key: key must be at least %d bytes (got %d)", seal_min_key_len, len(key.Key))
+	default:
+		return errors.New("seal key: key or passphrase is required")
+	}
+}
+
+// seal_format is an envelope the sealer writes: its magic, which must be as
+// long as sealed_magic, the HKDF info that keeps keys for one envelope from
+// opening another, and the noun its errors use.
+type seal_format struct {
+	magic string
+	info  string
+	noun  string
+}
+
+var participant_seal = seal_format{magic: sealed_magic, info: "mls-harness participant seal v1", noun: "participant"}
+
+// sealer derives AEAD keys for one SealKey. Derivations are cached by salt and
+// new blobs reuse the first salt seen, so a passphrase costs one PBKDF2 run per
+// process rather than one per operation; nonces stay random per blob.
+type sealer struct {
+	key     SealKey
+	format  seal_format
+	mu      sync.Mutex
+	derived map[string][]byte
+	salt    []byte
+}
+
+func new_sealer(key SealKey, format seal_format) (*sealer, error) {
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	// destroy clears the key, which must not reach into the caller's slice.
+	key.Key = bytes.Clone(key.Key)
+	return &sealer{key: key, format: format, derived: map[string][]byte{}}, nil
+}
+
+func (s *sealer) kdf() (byte, uint32) {
+	if s.key.Passphrase != "" {
+		return seal_kdf_pbkdf2, SealPassphraseIterations
+	}
+	return seal_kdf_hkdf, 0
+}
+
+func (s *sealer) derive(kdf byte, iterations uint32, salt []byte) ([]byte, error) {
+	want, _ := s.kdf()
+	if kdf != want {
+		return nil, fmt.Errorf("%s was sealed with the other kind of key", s.format.noun)
+	}
+	// The count comes from the blob, so an inflated one would pin the
+	// process in PBKDF2 before the AEAD could reject it; no blob this code
+	// wrote asks for more than SealPassphraseIterations.
+	if kdf == seal_kdf_pbkdf2 && (iterations == 0 || iterations > SealPassphraseIterations) {
+		return nil, fmt.Errorf("sealed %s asks for %d PBKDF2 iterations (want 1 to %d)", s.format.noun, iterations, SealPassphraseIterations)
+	}
+	cache_key := fmt.Sprintf("%d/%x", iterations, salt)
+	if key, ok := s.derived[cache_key]; ok {
+		return key, nil
+	}
+	var key []byte
+	switch kdf {
+	case seal_kdf_hkdf:
+		key = hkdf_sha256(s.key.Key, salt, []byte(s.format.info), chacha20poly1305.KeySize)
+	case seal_kdf_pbkdf2:
+		key = pbkdf2_sha256([]byte(s.key.Passphrase), salt, int(iterations), chacha20poly1305.KeySize)
+	}
+	s.derived[cache_key] = key
+	return key, nil
+}
+
+// destroy scrubs the key material and every key derived from it.
+func (s *sealer) destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.key.Key)
+	for salt, key := range s.derived {
+		clear(key)
+		delete(s.derived, salt)
+	}
+	s.salt = nil
+}
+
+func (s *sealer) seal(plain []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.salt == nil {
+		s.salt = make([]byte, seal_salt_len)
+		if _, err := io.ReadFull(system_entropy, s.salt); err != nil {
+			return nil, fmt.Errorf("seal salt: %w", err)
+		}
+	}
+	kdf, iterations := s.kdf()
+	key, err := s.derive(kdf, iterations, s.salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, seal_header_len+aead.NonceSize())
+	header = append(header, s.format.magic...)
+	header = append(header, sealed_version_v1, kdf)
+	header = binary.BigEndian.AppendUint32(header, iterations)
+	header = append(header, s.salt...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(system_entropy, nonce); err != nil {
+		return nil, fmt.Errorf("seal nonce: %w", err)
+	}
+	return aead.Seal(append(header, nonce...), nonce, plain, header), nil
+}
+
+func (s *sealer) open(sealed []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(sealed) < seal_header_len+chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("truncated sealed %s", s.format.noun)
+	}
+	if !bytes.HasPrefix(sealed, []byte(s.format.magic)) {
+		return nil, fmt.Errorf("not a sealed %s", s.format.noun)
+	}
+	if sealed[len(sealed_magic)] != sealed_version_v1 {
+		return nil, fmt.Errorf("unsupported sealed %s version %d", s.format.noun, sealed[len(sealed_magic)])
+	}
+	kdf := sealed[len(sealed_magic)+1]
+	iterations := binary.BigEndian.Uint32(sealed[len(sealed_magic)+2:])
+	salt := sealed[seal_header_len-seal_salt_len : seal_header_len]
+	key, err := s.derive(kdf, iterations, salt)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	header := sealed[:seal_header_len]
+	nonce := sealed[seal_header_len : seal_header_len+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, sealed[seal_header_len+aead.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("open sealed %s: wrong key or corrupted blob", s.format.noun)
+	}
+	if s.salt == nil {
+		s.salt = append([]byte(nil), salt...)
+	}
+	return plain, nil
+}
+
+var (
+	state_key_mu sync.RWMutex
+	state_key    *sealer
+)
+
+// SetStateKey makes every dm entry point open sealed participants with key and
+// seal the participants it returns; unsealed input is still accepted, so
+// existing state is sealed on its next change. A nil key goes back to
+// returning unsealed participants.
+func SetStateKey(key *SealKey) error {
+	var next *sealer
+	if key != nil {
+		var err error
+		if next, err = new_sealer(*key, participant_seal); err != nil {
+			return err
+		}
+	}
+	state_key_mu.Lock()
+	state_key = next
+	state_key_mu.Unlock()
+	return nil
+}
+
+func current_state_key() *sealer {
+	state_key_mu.RLock()
+	defer state_key_mu.RUnlock()
+	return state_key
+}
+
+// SealParticipant seals one participant with key, independent of SetStateKey.
+func SealParticipant(participant_b64 string, key SealKey) (string, error) {
+	s, err := new_sealer(key, participant_seal)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(participant_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	if is_sealed(data) {
+		return "", errors.New("participant is already sealed")
+	}
+	sealed, err := s.seal(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenParticipant reverses SealParticipant.
+func OpenParticipant(sealed_b64 string, key SealKey) (string, error) {
+	s, err := new_sealer(key, participant_seal)
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode participant: %w", err)
+	}
+	if !is_sealed(data) {
+		return "", errors.New("participant is not sealed")
+	}
+	plain, err := s.open(data)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(plain), nil
+}
+
+// IsSealed reports whether a participant blob is sealed.
+func IsSealed(participant_b64 string) bool {
+	data, err := base64.StdEncoding.DecodeString(participant_b64)
+	return err == nil && is_sealed(data)
+}
+
+func is_sealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealed_magic))
+}
+
+// hkdf_sha256 is RFC 5869 extract-then-expand.
+func hkdf_sha256(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var out, block []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(block)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		block = expand.Sum(nil)
+		out = append(out, block...)
+	}
+	return out[:length]
+}
+
+// pbkdf2_sha256 is RFC 8018 PBKDF2 with HMAC-SHA256 as the PRF.
+func pbkdf2_sha256(password, salt []byte, iterations, length int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < length; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:length]
+}
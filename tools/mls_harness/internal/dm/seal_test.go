@@ -0,0 +1,32 @@
+package dm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDestroyLeavesCallerKey(t *testing.T) {
+	participant, _, err := KeyPackage("", "alice", "", 1)
+	if err != nil {
+		t.Fatalf("keypackage: %v", err)
+	}
+	key := bytes.Repeat([]byte{0x42}, seal_min_key_len)
+	if err := SetStateKey(&SealKey{Key: key}); err != nil {
+		t.Fatalf("set state key: %v", err)
+	}
+	defer SetStateKey(nil)
+	if _, err := Destroy(participant); err != nil {
+		t.Fatalf("destroy: %v", err)
+	}
+
+	if !bytes.Equal(key, bytes.Repeat([]byte{0x42}, seal_min_key_len)) {
+		t.Fatalf("destroying the state key cleared the caller's slice")
+	}
+	sealed, err := SealParticipant(participant, SealKey{Key: key})
+	if err != nil {
+		t.Fatalf("seal after destroy: %v", err)
+	}
+	if _, err := OpenParticipant(sealed, SealKey{Key: key}); err != nil {
+		t.Fatalf("open after destroy: %v", err)
+	}
+}
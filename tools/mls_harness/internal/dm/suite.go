@@ -0,0 +1,66 @@
+package dm
+
+import (
+	"fmt"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// DefaultCipherSuite is the suite of participants created without naming one,
+// and of participants stored before the suite was recorded.
+const DefaultCipherSuite = mls.X25519_AES128GCM_SHA256_Ed25519
+
+// CipherSuites are the suites a participant can be created with. The vendored
+// go-mls panics signing with the P-256 and P-521 suites on current Go releases
+// (see the doctor command), so only the Ed25519 suites are offered.
+var CipherSuites = []mls.CipherSuite{
+	mls.X25519_AES128GCM_SHA256_Ed25519,
+	mls.X25519_CHACHA20POLY1305_SHA256_Ed25519,
+}
+
+// ParseCipherSuite looks a suite up by the name go-mls prints for it.
+func ParseCipherSuite(name string) (mls.CipherSuite, error) {
+	names := make([]string, 0, len(CipherSuites))
+	for _, suite := range CipherSuites {
+		if suite.String() == name {
+			return suite, nil
+		}
+		names = append(names, suite.String())
+	}
+	return 0, fmt.Errorf("unsupported cipher suite %q (supported: %s)", name, strings.Join(names, ", "))
+}
+
+func supported_suite(suite mls.CipherSuite) bool {
+	for _, supported := range CipherSuites {
+		if suite == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// check_suite applies a caller's optional suite name to the participant's
+// suite: empty means the participant's, and anything else must match it, since
+// the identity key is bound to one signature scheme.
+func check_suite(participant *Participant, suite_name string) error {
+	if suite_name == "" {
+		return nil
+	}
+	suite, err := ParseCipherSuite(suite_name)
+	if err != nil {
+		return err
+	}
+	if suite != participant.Suite {
+		return fmt.Errorf("participant uses cipher suite %s, not %s", participant.Suite, suite)
+	}
+	return nil
+}
+
+// check_peer_suite rejects a KeyPackage that would make the group mixed-suite.
+func check_peer_suite(kp mls.KeyPackage, suite mls.CipherSuite) error {
+	if kp.CipherSuite != suite {
+		return fmt.Errorf("peer keypackage uses cipher suite %s but the group uses %s; mixed-suite groups are not supported", kp.CipherSuite, suite)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package dm
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// ErrBadKeyPackage is a peer KeyPackage that fails one of the checks
+// ValidateKeyPackage runs.
+var ErrBadKeyPackage = errors.New("invalid keypackage")
+
+// KeyPackageCheck is the outcome of one check ValidateKeyPackage runs. A check
+// that could not run because an earlier one failed is reported failed, with an
+// Error naming that check.
+type KeyPackageCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// KeyPackageReport is what ValidateKeyPackage found. Valid is set when every
+// check passed.
+type KeyPackageReport struct {
+	Valid       bool              `json:"valid"`
+	Identity    string            `json:"identity"`
+	UserID      string            `json:"user_id,omitempty"`
+	CipherSuite string            `json:"cipher_suite"`
+	NotBefore   uint64            `json:"not_before"`
+	NotAfter    uint64            `json:"not_after"`
+	Checks      []KeyPackageCheck `json:"checks"`
+}
+
+// known_keypackage_extensions are the extensions dm understands in a
+// KeyPackage. go-mls adds a parent hash to leaves it has placed in a tree.
+var known_keypackage_extensions = map[mls.ExtensionType]bool{
+	mls.ExtensionTypeSupportedVersions:     true,
+	mls.ExtensionTypeSupportedCipherSuites: true,
+	mls.ExtensionTypeLifetime:              true,
+	mls.ExtensionTypeKeyID:                 true,
+	mls.ExtensionTypeParentHash:            true,
+	identity_binding_extension:             true,
+	app_version_extension:                  true,
+}
+
+// ValidateKeyPackage checks a peer's KeyPackage before it is added: protocol
+// version, cipher suite, credential, extensions, lifetime, signature and any
+// identity binding, in that order. It fails only for input that does not
+// parse; a KeyPackage that parses but fails a check comes back with Valid
+// unset. Init, AddMany and ProposeAdd run the same checks and refuse a
+// KeyPackage that fails any of them.
+func ValidateKeyPackage(kp_b64 string) (KeyPackageReport, error) {
+	if kp_b64 == "" {
+		return KeyPackageReport{}, errors.New("keypackage is required")
+	}
+	kp, err := parse_keypackage(kp_b64)
+	if err != nil {
+		return KeyPackageReport{}, err
+	}
+	return validate_keypackage(kp, Now()), nil
+}
+
+// check_peer_keypackage returns the first check kp fails, wrapping
+// ErrBadKeyPackage.
+func check_peer_keypackage(kp mls.KeyPackage) error {
+	report := validate_keypackage(kp, Now())
+	for _, check := range report.Checks {
+		if !check.OK {
+			return fmt.Errorf("%w: %s: %s", ErrBadKeyPackage, check.Name, check.Error)
+		}
+	}
+	return nil
+}
+
+func validate_keypackage(kp mls.KeyPackage, now time.Time) KeyPackageReport {
+	report := KeyPackageReport{Valid: true, CipherSuite: kp.CipherSuite.String()}
+	check := func(name string, err error) bool {
+		result := KeyPackageCheck{Name: name, OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			report.Valid = false
+		}
+		report.Checks = append(report.Checks, result)
+		return err == nil
+	}
+
+	check("version", keypackage_version(kp))
+	suite_ok := check("cipher_suite", keypackage_suite(kp))
+	credential_ok := check("credential", keypackage_credential(kp))
+	if credential_ok {
+		report.Identity = string(kp.Credential.Identity())
+	}
+	check("extensions", keypackage_extensions(kp))
+	lifetime, err := keypackage_lifetime(kp, now)
+	report.NotBefore, report.NotAfter = lifetime.NotBefore, lifetime.NotAfter
+	check("lifetime", err)
+	switch {
+	case !suite_ok:
+		check("signature", errors.New("not checked: unsupported cipher suite"))
+	case !credential_ok:
+		check("signature", errors.New("not checked: unusable credential"))
+	default:
+		check("signature", keypackage_signature(kp))
+	}
+	if credential_ok {
+		user_id, err := keypackage_user_id(kp)
+		report.UserID = user_id
+		check("identity_binding", err)
+	} else {
+		check("identity_binding", errors.New("not checked: unusable credential"))
+	}
+	return report
+}
+
+func keypackage_version(kp mls.KeyPackage) error {
+	if kp.Version != mls.ProtocolVersionMLS10 {
+		return fmt.Errorf("protocol version %d is not MLS 1.0", kp.Version)
+	}
+	return nil
+}
+
+func keypackage_suite(kp mls.KeyPackage) error {
+	if !supported_suite(kp.CipherSuite) {
+		return fmt.Errorf("cipher suite %s is not supported", kp.CipherSuite)
+	}
+	return nil
+}
+
+func keypackage_credential(kp mls.KeyPackage) error {
+	if kp.Credential.Type() != mls.CredentialTypeBasic {
+		return errors.New("only basic credentials are supported")
+	}
+	identity := kp.Credential.Identity()
+	switch {
+	case len(identity) == 0:
+		return errors.New("credential identity is empty")
+	case !utf8.Valid(identity):
+		return errors.New("credential identity is not UTF-8")
+	case kp.Credential.Scheme() != kp.CipherSuite.Scheme():
+		return fmt.Errorf("credential scheme %v does not match cipher suite %s", kp.Credential.Scheme(), kp.CipherSuite)
+	case kp.Credential.Scheme() == mls.Ed25519 && len(kp.Credential.PublicKey().Data) != ed25519.PublicKeySize:
+		return fmt.Errorf("credential key is %d bytes, want %d", len(kp.Credential.PublicKey().Data), ed25519.PublicKeySize)
+	}
+	return nil
+}
+
+func keypackage_extensions(kp mls.KeyPackage) error {
+	for _, ext := range kp.Extensions.Entries {
+		if !known_keypackage_extensions[ext.ExtensionType] {
+			return fmt.Errorf("unsupported extension %#04x", uint16(ext.ExtensionType))
+		}
+	}
+	var versions mls.SupportedVersionsExtension
+	if found, err := kp.Extensions.Find(&versions); !found || err != nil {
+		return errors.New("supported versions extension is missing or malformed")
+	}
+	var suites mls.SupportedCipherSuitesExtension
+	if found, err := kp.Extensions.Find(&suites); !found || err != nil {
+		return errors.New("supported cipher suites extension is missing or malformed")
+	}
+	for _, suite := range suites.SupportedCipherSuites {
+		if suite == kp.CipherSuite {
+			return nil
+		}
+	}
+	return fmt.Errorf("supported cipher suites omit the keypackage's own suite %s", kp.CipherSuite)
+}
+
+func keypackage_lifetime(kp mls.KeyPackage, now time.Time) (Lifetime, error) {
+	var ext mls.LifetimeExtension
+	if found, err := kp.Extensions.Find(&ext); !found || err != nil {
+		return Lifetime{}, errors.New("lifetime extension is missing or malformed")
+	}
+	lifetime := Lifetime{NotBefore: ext.NotBefore, NotAfter: ext.NotAfter}
+	switch unix := uint64(now.Unix()); {
+	case unix < lifetime.NotBefore:
+		return lifetime, fmt.Errorf("not valid until %s", time.Unix(int64(lifetime.NotBefore), 0).UTC().Format(time.RFC3339))
+	case unix > lifetime.NotAfter:
+		return lifetime, fmt.Errorf("expired at %s", time.Unix(int64(lifetime.NotAfter), 0).UTC().Format(time.RFC3339))
+	}
+	return lifetime, nil
+}
+
+// keypackage_signature verifies the signature over the KeyPackage's other
+// fields, which go-mls only checks together with the lifetime.
+func keypackage_signature(kp mls.KeyPackage) error {
+	tbs, err := syntax.Marshal(struct {
+		Version     mls.ProtocolVersion
+		CipherSuite mls.CipherSuite
+		InitKey     mls.HPKEPublicKey
+		Credential  mls.Credential
+		Extensions  mls.ExtensionList
+	}{kp.Version, kp.CipherSuite, kp.InitKey, kp.Credential, kp.Extensions})
+	if err != nil {
+		return fmt.Errorf("marshal signed fields: %w", err)
+	}
+	if !kp.Credential.Scheme().Verify(kp.Credential.PublicKey(), tbs, kp.Signature.Data) {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package dm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// AppProtocolVersion is the version of the dm application protocol this build
+// reads and writes: what goes inside MLS, such as message frames and typed
+// content. It travels in two places. Every KeyPackage advertises it, and a
+// group whose founding KeyPackages all advertise one records it as a group
+// extension, which the Welcome carries to joiners in its GroupInfo. A message
+// frame's version byte is the version its sender wrote it in. A group without
+// the extension, founded by or with a build that predates it, is version 1.
+const AppProtocolVersion = 1
+
+// ErrUnsupportedVersion is a Welcome or message from a later application
+// protocol version than AppProtocolVersion, or a KeyPackage whose owner cannot
+// read the group's version.
+var ErrUnsupportedVersion = errors.New("unsupported application protocol version")
+
+const app_version_extension mls.ExtensionType = 0xff02
+
+// app_version is the extension, in the private-use range, that holds the
+// highest version a KeyPackage's owner reads, or a group's version.
+type app_version struct {
+	Version uint8
+}
+
+func (app_version) Type() mls.ExtensionType {
+	return app_version_extension
+}
+
+// keypackage_app_version returns the version kp advertises, and false for a
+// KeyPackage from a build that predates the extension.
+func keypackage_app_version(kp mls.KeyPackage) (uint8, bool) {
+	var ext app_version
+	if found, err := kp.Extensions.Find(&ext); !found || err != nil {
+		return 0, false
+	}
+	return ext.Version, true
+}
+
+// group_app_version returns the version state's group extension records, or 1
+// for a group without one.
+func group_app_version(state *mls.State) uint8 {
+	var ext app_version
+	if found, err := state.Extensions.Find(&ext); !found || err != nil {
+		return 1
+	}
+	return ext.Version
+}
+
+// check_app_version refuses a version later than this build reads.
+func check_app_version(what string, version uint8) error {
+	if version > AppProtocolVersion {
+		return fmt.Errorf("%w: %s is version %d, this build reads up to %d", ErrUnsupportedVersion, what, version, AppProtocolVersion)
+	}
+	return nil
+}
+
+// new_group_extensions returns the extensions of a group founded with peers:
+// the app version when every peer advertises one, and none when any peer's
+// build predates the extension, which go-mls would otherwise refuse to add.
+func new_group_extensions(peers []mls.KeyPackage) (mls.ExtensionList, error) {
+	list := mls.NewExtensionList()
+	for _, kp := range peers {
+		version, ok := keypackage_app_version(kp)
+		if !ok {
+			return list, nil
+		}
+		if version < AppProtocolVersion {
+			return list, fmt.Errorf("%w: %s reads up to version %d, the group is version %d", ErrUnsupportedVersion, kp.Credential.Identity(), version, AppProtocolVersion)
+		}
+	}
+	if err := list.Add(app_version{Version: AppProtocolVersion}); err != nil {
+		return list, fmt.Errorf("add app version: %w", err)
+	}
+	return list, nil
+}
+
+// check_peer_version refuses a KeyPackage whose owner could not read the
+// group state belongs to.
+func check_peer_version(kp mls.KeyPackage, state *mls.State) error {
+	if !state.Extensions.Has(app_version_extension) {
+		return nil
+	}
+	version, ok := keypackage_app_version(kp)
+	if !ok {
+		return fmt.Errorf("%w: %s advertises no version, the group is version %d", ErrUnsupportedVersion, kp.Credential.Identity(), group_app_version(state))
+	}
+	if group := group_app_version(state); version < group {
+		return fmt.Errorf("%w: %s reads up to version %d, the group is version %d", ErrUnsupportedVersion, kp.Credential.Identity(), version, group)
+	}
+	return nil
+}
+
+// reusable_keypackage_for returns kp, the participant's reusable KeyPackage,
+// or the same KeyPackage without the app version when welcome names that one
+// instead, so a Welcome for a KeyPackage published before the upgrade still
+// joins.
+func reusable_keypackage_for(participant *Participant, welcome *mls.Welcome, kp *mls.KeyPackage) (*mls.KeyPackage, error) {
+	if welcome_names(welcome, kp) {
+		return kp, nil
+	}
+	sig_priv, cred, err := build_identity(participant.IdentitySecret, participant.Name, participant.Suite)
+	if err != nil {
+		return nil, err
+	}
+	legacy, err := build_keypackage_versioned(participant.Suite, participant.InitSecret, cred, sig_priv, participant.IdentityBinding, participant.Lifetime, false)
+	if err != nil {
+		return nil, err
+	}
+	if welcome_names(welcome, legacy) {
+		return legacy, nil
+	}
+	return kp, nil
+}
+
+func welcome_names(welcome *mls.Welcome, kp *mls.KeyPackage) bool {
+	data, err := syntax.Marshal(*kp)
+	if err != nil {
+		return false
+	}
+	hash := welcome.CipherSuite.Digest(data)
+	for _, secrets := range welcome.Secrets {
+		if bytes.Equal(hash, secrets.KeyPackageHash) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package dm
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// SplitWelcome cuts a Welcome for several new members into one Welcome per
+// member, keyed by the hex hash of the KeyPackage it was encrypted to. Each
+// keeps the shared encrypted GroupInfo and only that member's group secrets,
+// so the delivery service can hand every joiner just its own copy. A Welcome
+// for one member comes back as a single entry.
+func SplitWelcome(welcome_b64 string) (map[string]string, error) {
+	if welcome_b64 == "" {
+		return nil, errors.New("welcome is required")
+	}
+	welcome_bytes, header, err := decode_artifact(artifact_welcome, welcome_b64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: decode welcome: %w", ErrBadWelcome, err)
+	}
+	var welcome mls.Welcome
+	if _, err := syntax.Unmarshal(welcome_bytes, &welcome); err != nil {
+		return nil, fmt.Errorf("%w: unmarshal welcome: %w", ErrBadWelcome, err)
+	}
+	if len(welcome.Secrets) == 0 {
+		return nil, fmt.Errorf("%w: welcome has no recipients", ErrBadWelcome)
+	}
+
+	split := map[string]string{}
+	for _, secrets := range welcome.Secrets {
+		hash := hex.EncodeToString(secrets.KeyPackageHash)
+		if _, ok := split[hash]; ok {
+			return nil, fmt.Errorf("%w: welcome names keypackage %s twice", ErrBadWelcome, hash)
+		}
+		one := mls.Welcome{
+			Version:            welcome.Version,
+			CipherSuite:        welcome.CipherSuite,
+			Secrets:            []mls.EncryptedGroupSecrets{secrets},
+			EncryptedGroupInfo: welcome.EncryptedGroupInfo,
+		}
+		one_bytes, err := syntax.Marshal(one)
+		if err != nil {
+			return nil, fmt.Errorf("marshal welcome: %w", err)
+		}
+		if header != nil {
+			one_bytes = seal_artifact(artifact_welcome, header.group_id, header.epoch, one_bytes)
+		}
+		split[hash] = base64.StdEncoding.EncodeToString(one_bytes)
+	}
+	return split, nil
+}
+
+// KeyPackageHash returns the hex hash a Welcome names the KeyPackage by, the
+// key SplitWelcome uses.
+func KeyPackageHash(kp_b64 string) (string, error) {
+	kp, err := parse_keypackage(kp_b64)
+	if err != nil {
+		return "", err
+	}
+	kp_bytes, _, err := decode_artifact(artifact_keypackage, kp_b64)
+	if err != nil {
+		return "", fmt.Errorf("decode keypackage: %w", err)
+	}
+	return hex.EncodeToString(kp.CipherSuite.Digest(kp_bytes)), nil
+}
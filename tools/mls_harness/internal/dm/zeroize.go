@@ -0,0 +1,107 @@
+package dm
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// A decoded participant holds its identity and init secrets, the pool's init
+// secrets and every group's epoch secrets, path secrets and ratchet keys. The
+// dm entry points scrub the participant they decoded once they have encoded
+// what they return, so those secrets do not sit on the heap until the garbage
+// collector reuses it. The scrub cannot reach copies the runtime made while
+// growing slices, nor the caller's blob; for the latter there is Destroy.
+//
+// A destroyed participant is a tombstone, the magic "MLSD" and a version byte,
+// that every entry point refuses with ErrDestroyed.
+const (
+	destroyed_magic      = "MLSD"
+	destroyed_version_v1 = 1
+)
+
+// ErrDestroyed is returned for a participant Destroy replaced, and by a Handle
+// after Destroy.
+var ErrDestroyed = errors.New("participant state was destroyed")
+
+// Zeroize overwrites every byte slice reachable from participant with zeros,
+// then drops its secrets, pool and sessions, so a participant used by mistake
+// afterwards fails instead of deriving keys from zeros. Strings, such as the
+// name, cannot be overwritten and are left as they are.
+func Zeroize(participant *Participant) {
+	if participant == nil {
+		return
+	}
+	zero_bytes(reflect.ValueOf(participant), map[uintptr]bool{})
+	participant.IdentitySecret = nil
+	participant.InitSecret = nil
+	participant.IdentityBinding = nil
+	participant.Pool = nil
+	participant.Sessions = nil
+}
+
+// zero_bytes clears the byte slices under v, following pointers, interfaces,
+// maps and unexported fields; go-mls keeps its key schedule behind the
+// latter. seen stops it walking a pointer twice.
+func zero_bytes(v reflect.Value, seen map[uintptr]bool) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() || seen[v.Pointer()] {
+			return
+		}
+		seen[v.Pointer()] = true
+		zero_bytes(v.Elem(), seen)
+	case reflect.Interface:
+		if !v.IsNil() {
+			zero_bytes(v.Elem(), seen)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			zero_bytes(v.Field(i), seen)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			clear(v.Bytes())
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			zero_bytes(v.Index(i), seen)
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			zero_bytes(v.Index(i), seen)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			zero_bytes(iter.Value(), seen)
+		}
+	}
+}
+
+// Destroy is the panic-delete operation. It returns a tombstone for the caller
+// to store over participant_b64, and scrubs and forgets the state key if one is
+// set, so that blob and every other one sealed under the key can no longer be
+// opened. A copy of an unsealed blob kept elsewhere stays readable; clients
+// that offer panic delete should seal their state, and drop their own copy of
+// the key material too.
+func Destroy(participant_b64 string) (string, error) {
+	if participant_b64 == "" {
+		return "", errors.New("participant is required")
+	}
+	if _, err := base64.StdEncoding.DecodeString(participant_b64); err != nil {
+		return "", fmt.Errorf("%w: decode base64: %w", ErrStateCorrupt, err)
+	}
+	state_key_mu.Lock()
+	if state_key != nil {
+		state_key.destroy()
+		state_key = nil
+	}
+	state_key_mu.Unlock()
+	return base64.StdEncoding.EncodeToString(append([]byte(destroyed_magic), destroyed_version_v1)), nil
+}
+
+func is_destroyed(data []byte) bool {
+	return len(data) == len(destroyed_magic)+1 && string(data[:len(destroyed_magic)]) == destroyed_magic
+}
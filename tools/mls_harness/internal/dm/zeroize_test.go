@@ -0,0 +1,22 @@
+package dm
+
+import "testing"
+
+func TestKeyPackageScrubsNewParticipant(t *testing.T) {
+	var scrubbed *Participant
+	scrub_keypackage = func(participant *Participant) {
+		scrubbed = participant
+		Zeroize(participant)
+	}
+	defer func() { scrub_keypackage = Zeroize }()
+
+	if _, _, err := KeyPackage("", "alice", "", 1); err != nil {
+		t.Fatalf("keypackage: %v", err)
+	}
+	if scrubbed == nil {
+		t.Fatal("keypackage did not scrub the participant it created")
+	}
+	if scrubbed.IdentitySecret != nil || scrubbed.InitSecret != nil || scrubbed.Pool != nil {
+		t.Fatalf("created participant still holds secrets after keypackage")
+	}
+}
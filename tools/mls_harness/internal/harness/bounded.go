@@ -0,0 +1,171 @@
+package harness
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// StateLimits bounds the caches an mls.State keeps between messages. The
+// ratchet, base-secret and path-secret limits left at zero are derived from
+// the group: a ratchet per leaf, a secret per tree node. MaxOwnCachedKeys left
+// at zero is not checked. The other limits are taken as given, zero included.
+type StateLimits struct {
+	MaxSenderRatchets int // handshake or application ratchets, one per sender
+	MaxCachedKeys     int // keys the ratchet of another sender holds: skipped or not yet erased
+	// MaxOwnCachedKeys bounds the member's own ratchets. go-mls caches the key
+	// of every message a member sends and never erases it, so these grow by
+	// one per message until the next epoch starts fresh ratchets.
+	MaxOwnCachedKeys    int
+	MaxBaseSecrets      int // application base secrets derived down the tree
+	MaxPathSecrets      int // the member's own path secrets
+	MaxPendingProposals int
+	MaxPendingUpdates   int
+}
+
+// DefaultStateLimits allow another sender's ratchet a window of skipped keys
+// and a handful of uncommitted proposals, and leave the member's own ratchets
+// unchecked. A two-party exchange with every message decrypted in order stays
+// far below them.
+func DefaultStateLimits() StateLimits {
+	return StateLimits{
+		MaxCachedKeys:       64,
+		MaxPendingProposals: 16,
+		MaxPendingUpdates:   16,
+	}
+}
+
+// StateUsage is how full each cache of a state is; for the per-ratchet
+// counts, the fullest ratchet.
+type StateUsage struct {
+	SenderRatchets   int `json:"sender_ratchets"`
+	CachedKeys       int `json:"cached_keys"`     // in another sender's ratchet
+	OwnCachedKeys    int `json:"own_cached_keys"` // in the member's own ratchets
+	BaseSecrets      int `json:"base_secrets"`
+	PathSecrets      int `json:"path_secrets"`
+	PendingProposals int `json:"pending_proposals"`
+	PendingUpdates   int `json:"pending_updates"`
+}
+
+// MeasureState reports the size of the caches in state that grow with use.
+func MeasureState(state *mls.State) StateUsage {
+	usage := StateUsage{
+		PathSecrets:      len(state.TreePriv.PathSecrets),
+		PendingProposals: len(state.PendingProposals),
+		PendingUpdates:   len(state.PendingUpdates),
+	}
+	for _, ratchets := range senderRatchets(state) {
+		if len(ratchets.byLeaf) > usage.SenderRatchets {
+			usage.SenderRatchets = len(ratchets.byLeaf)
+		}
+		for leaf, n := range ratchets.byLeaf {
+			if leaf == state.Index && n > usage.OwnCachedKeys {
+				usage.OwnCachedKeys = n
+			} else if leaf != state.Index && n > usage.CachedKeys {
+				usage.CachedKeys = n
+			}
+		}
+	}
+	if state.Keys.ApplicationBaseKeys != nil {
+		usage.BaseSecrets = len(state.Keys.ApplicationBaseKeys.Secrets)
+	}
+	return usage
+}
+
+// VerifyBounded fails if any cache of state is over its limit, naming every
+// one that is, so growth the vendored go-mls never prunes shows up in a long
+// run as an error rather than as the process running out of memory.
+func VerifyBounded(state *mls.State, limits StateLimits) error {
+	if state == nil {
+		return errors.New("state is nil")
+	}
+	leaves := int(state.Tree.Size())
+	nodes := 2*leaves - 1
+	if limits.MaxSenderRatchets == 0 {
+		limits.MaxSenderRatchets = leaves
+	}
+	if limits.MaxBaseSecrets == 0 {
+		limits.MaxBaseSecrets = nodes
+	}
+	if limits.MaxPathSecrets == 0 {
+		limits.MaxPathSecrets = nodes
+	}
+
+	var over []string
+	check := func(what string, n, limit int) {
+		if n > limit {
+			over = append(over, fmt.Sprintf("%s %d > %d", what, n, limit))
+		}
+	}
+	for _, ratchets := range senderRatchets(state) {
+		check(ratchets.name, len(ratchets.byLeaf), limits.MaxSenderRatchets)
+		for _, leaf := range sortedLeaves(ratchets.byLeaf) {
+			limit := limits.MaxCachedKeys
+			if leaf == state.Index {
+				if limits.MaxOwnCachedKeys == 0 {
+					continue
+				}
+				limit = limits.MaxOwnCachedKeys
+			}
+			check(fmt.Sprintf("keys cached by the %s of leaf %d", strings.TrimSuffix(ratchets.name, "s"), leaf), ratchets.byLeaf[leaf], limit)
+		}
+	}
+	usage := MeasureState(state)
+	check("application base secrets", usage.BaseSecrets, limits.MaxBaseSecrets)
+	check("path secrets", usage.PathSecrets, limits.MaxPathSecrets)
+	check("pending proposals", usage.PendingProposals, limits.MaxPendingProposals)
+	check("pending updates", usage.PendingUpdates, limits.MaxPendingUpdates)
+	if len(over) > 0 {
+		return fmt.Errorf("state in epoch %d over its limits: %s", state.Epoch, strings.Join(over, ", "))
+	}
+	return nil
+}
+
+type ratchetSet struct {
+	name   string
+	byLeaf map[mls.LeafIndex]int // keys cached per sender
+}
+
+// senderRatchets counts the keys each ratchet map of state caches. The key
+// sources hold the maps messages go through, which the key schedule fields
+// alias until the state is decoded from a gob snapshot; from then on they are
+// separate maps, and both are counted.
+func senderRatchets(state *mls.State) []ratchetSet {
+	sets := []ratchetSet{
+		{"handshake ratchets", map[mls.LeafIndex]int{}},
+		{"application ratchets", map[mls.LeafIndex]int{}},
+	}
+	for leaf, ratchet := range state.Keys.HandshakeRatchets {
+		sets[0].byLeaf[leaf] = len(ratchet.Cache)
+	}
+	for leaf, ratchet := range state.Keys.ApplicationRatchets {
+		sets[1].byLeaf[leaf] = len(ratchet.Cache)
+	}
+	if state.Keys.HandshakeKeys != nil {
+		for leaf, ratchet := range state.Keys.HandshakeKeys.Ratchets {
+			if n := len(ratchet.Cache); n > sets[0].byLeaf[leaf] {
+				sets[0].byLeaf[leaf] = n
+			}
+		}
+	}
+	if state.Keys.ApplicationKeys != nil {
+		for leaf, ratchet := range state.Keys.ApplicationKeys.Ratchets {
+			if n := len(ratchet.Cache); n > sets[1].byLeaf[leaf] {
+				sets[1].byLeaf[leaf] = n
+			}
+		}
+	}
+	return sets
+}
+
+func sortedLeaves[V any](m map[mls.LeafIndex]V) []mls.LeafIndex {
+	leaves := make([]mls.LeafIndex, 0, len(m))
+	for leaf := range m {
+		leaves = append(leaves, leaf)
+	}
+	sort.Slice(leaves, func(i, j int) bool { return leaves[i] < leaves[j] })
+	return leaves
+}
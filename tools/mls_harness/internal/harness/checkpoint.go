@@ -0,0 +1,166 @@
+//go:build !tinygo
+// +build !tinygo
+
+package harness
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const checkpointsDirName = "checkpoints"
+const checkpointPrefix = "checkpoint-"
+
+// CheckpointPolicy controls how many persisted snapshots a soak keeps besides the
+// live per-participant snapshots. Keep == 0 preserves the original overwrite-only behaviour.
+type CheckpointPolicy struct {
+	Keep     int
+	Compress bool
+}
+
+// CheckpointInfo describes one retained checkpoint directory.
+type CheckpointInfo struct {
+	Name      string
+	Iteration int
+	Taken     time.Time
+	Files     []string
+	Bytes     int64
+}
+
+// ArchiveCheckpoint copies the participants' live snapshots into a timestamped
+// checkpoint directory and prunes the oldest checkpoints beyond the retention limit.
+func ArchiveCheckpoint(stateDir string, iteration int, policy CheckpointPolicy, participants ...*Participant) error {
+	if policy.Keep <= 0 {
+		return nil
+	}
+
+	root := filepath.Join(stateDir, checkpointsDirName)
+	name := fmt.Sprintf("%s%08d-%s", checkpointPrefix, iteration, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("create checkpoint dir: %w", err)
+	}
+
+	for _, p := range participants {
+		src := StatePath(stateDir, p.Name)
+		dst := filepath.Join(dir, filepath.Base(src))
+		if policy.Compress {
+			dst += ".gz"
+		}
+		if err := copyCheckpointFile(src, dst, policy.Compress); err != nil {
+			return fmt.Errorf("checkpoint %s: %w", filepath.Base(src), err)
+		}
+	}
+
+	checkpoints, err := ListCheckpoints(stateDir)
+	if err != nil {
+		return err
+	}
+	for len(checkpoints) > policy.Keep {
+		if err := os.RemoveAll(filepath.Join(root, checkpoints[0].Name)); err != nil {
+			return fmt.Errorf("prune %s: %w", checkpoints[0].Name, err)
+		}
+		checkpoints = checkpoints[1:]
+	}
+	return nil
+}
+
+func copyCheckpointFile(src, dst string, compress bool) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+// ListCheckpoints returns the retained checkpoints under stateDir, oldest first.
+func ListCheckpoints(stateDir string) ([]CheckpointInfo, error) {
+	root := filepath.Join(stateDir, checkpointsDirName)
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read checkpoints dir: %w", err)
+	}
+
+	checkpoints := []CheckpointInfo{}
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), checkpointPrefix) {
+			continue
+		}
+		info, err := parseCheckpoint(root, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, info)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		if checkpoints[i].Iteration != checkpoints[j].Iteration {
+			return checkpoints[i].Iteration < checkpoints[j].Iteration
+		}
+		return checkpoints[i].Name < checkpoints[j].Name
+	})
+	return checkpoints, nil
+}
+
+func parseCheckpoint(root, name string) (CheckpointInfo, error) {
+	parts := strings.SplitN(strings.TrimPrefix(name, checkpointPrefix), "-", 2)
+	if len(parts) != 2 {
+		return CheckpointInfo{}, fmt.Errorf("malformed checkpoint name %q", name)
+	}
+	iteration, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return CheckpointInfo{}, fmt.Errorf("malformed checkpoint iteration in %q: %w", name, err)
+	}
+	taken, err := time.Parse("20060102T150405.000000000Z", parts[1])
+	if err != nil {
+		return CheckpointInfo{}, fmt.Errorf("malformed checkpoint timestamp in %q: %w", name, err)
+	}
+
+	info := CheckpointInfo{Name: name, Iteration: iteration, Taken: taken}
+	files, err := os.ReadDir(filepath.Join(root, name))
+	if err != nil {
+		return CheckpointInfo{}, fmt.Errorf("read %s: %w", name, err)
+	}
+	for _, file := range files {
+		fi, err := file.Info()
+		if err != nil {
+			return CheckpointInfo{}, fmt.Errorf("stat %s/%s: %w", name, file.Name(), err)
+		}
+		info.Files = append(info.Files, file.Name())
+		info.Bytes += fi.Size()
+	}
+	return info, nil
+}
@@ -0,0 +1,43 @@
+package harness
+
+import (
+	"time"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// Clock tells the time wherever the harness and dm set a lifetime or a
+// timestamp, so a run that must repeat byte for byte reads a frozen clock and
+// a real one reads the system clock. go-mls checks a KeyPackage's lifetime
+// against the system clock on its own when an Add is applied; no Clock
+// reaches that check.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, for secure participants.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// FrozenClock always reads At.
+type FrozenClock struct {
+	At time.Time
+}
+
+func (c FrozenClock) Now() time.Time { return c.At }
+
+// VectorClock is the frozen clock of vectors and deterministic participants,
+// stopped at the Unix epoch.
+var VectorClock Clock = FrozenClock{At: time.Unix(0, 0).UTC()}
+
+// VectorKeyPackageLifetime is how long a KeyPackage made on VectorClock stays
+// valid: until 2100-01-01 UTC, past the wall clock of any run that adds it.
+var VectorKeyPackageLifetime = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC).Sub(VectorClock.Now())
+
+// MakeKeyPackageAt gives kp a lifetime of validFor from clock's now and
+// re-signs it.
+func MakeKeyPackageAt(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey, clock Clock, validFor time.Duration) error {
+	now := clock.Now()
+	return MakeKeyPackageWithLifetime(kp, sigPriv, now, now.Add(validFor))
+}
@@ -0,0 +1,218 @@
+package harness
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// CorpusFormatVersion is the manifest layout GenerateCorpus writes.
+const CorpusFormatVersion = 1
+
+// CorpusManifestName is the manifest's file name in a corpus directory.
+const CorpusManifestName = "manifest.json"
+
+// Kinds of corpus sample. A proposal or a commit is an MLSPlaintext, which
+// inspect reads as type plaintext.
+const (
+	CorpusKeyPackage = "keypackage"
+	CorpusWelcome    = "welcome"
+	CorpusProposal   = "proposal"
+	CorpusCommit     = "commit"
+	CorpusCiphertext = "ciphertext"
+)
+
+// CorpusManifest lists every sample in a corpus directory, and the suites
+// that could not produce one.
+type CorpusManifest struct {
+	FormatVersion int            `json:"format_version"`
+	Samples       []CorpusSample `json:"samples"`
+	Failures      []string       `json:"failures,omitempty"`
+}
+
+// CorpusSample is one artifact, stored raw (TLS-syntax encoded) at Path,
+// relative to the corpus directory.
+type CorpusSample struct {
+	Suite     string `json:"cipher_suite"`
+	Scenario  string `json:"scenario"`
+	Kind      string `json:"kind"`
+	Label     string `json:"label"`
+	Path      string `json:"path"`
+	Bytes     int    `json:"bytes"`
+	SHA256Hex string `json:"sha256_hex"`
+}
+
+// GenerateCorpus runs every scenario in every suite and writes each
+// KeyPackage, Welcome, proposal, commit and ciphertext its transcript records
+// to dir/<suite>/<scenario>/, with a manifest listing them at
+// dir/manifest.json. Runs draw from DeterministicRNG, with crypto/rand
+// overridden too, so a corpus regenerates byte for byte. A suite go-mls
+// cannot run is listed in the manifest's failures and reported in the
+// returned error once the other suites are written.
+func GenerateCorpus(dir string, suites []mls.CipherSuite, scenarios []*Scenario) (*CorpusManifest, error) {
+	if len(suites) == 0 || len(scenarios) == 0 {
+		return nil, errors.New("at least one suite and one scenario are required")
+	}
+	manifest := &CorpusManifest{FormatVersion: CorpusFormatVersion, Samples: []CorpusSample{}}
+	for _, suite := range suites {
+		for _, scenario := range scenarios {
+			transcript, err := corpusTranscript(suite, scenario)
+			if err != nil {
+				manifest.Failures = append(manifest.Failures, fmt.Sprintf("%s %s: %v", suite, scenario.Name, err))
+				continue
+			}
+			samples, err := writeCorpusSamples(dir, suite.String(), scenario.Name, transcript)
+			if err != nil {
+				return nil, err
+			}
+			manifest.Samples = append(manifest.Samples, samples...)
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, CorpusManifestName), append(data, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("write manifest: %w", err)
+	}
+	if len(manifest.Failures) > 0 {
+		return manifest, errors.New(strings.Join(manifest.Failures, "; "))
+	}
+	return manifest, nil
+}
+
+// corpusTranscript runs scenario in suite and returns its transcript. go-mls
+// panics on some suites rather than failing, which is reported as an error.
+func corpusTranscript(suite mls.CipherSuite, scenario *Scenario) (transcript *Transcript, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	run := *scenario
+	run.Suite = suite
+	run.DigestHex = ""
+	rng := DeterministicRNG()
+	dig := NewTranscriptDigest()
+	if err := WithCryptoRand(rng, func() error {
+		_, err := run.Run(rng, dig, ScenarioHooks{})
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return dig.Transcript(), nil
+}
+
+func writeCorpusSamples(dir, suite, scenario string, transcript *Transcript) ([]CorpusSample, error) {
+	rel := filepath.Join(suite, scenario)
+	if err := os.MkdirAll(filepath.Join(dir, rel), 0o755); err != nil {
+		return nil, fmt.Errorf("create corpus dir: %w", err)
+	}
+	var samples []CorpusSample
+	for i, entry := range transcript.Entries {
+		kind := corpusKind(entry)
+		if kind == "" {
+			continue
+		}
+		path := filepath.Join(rel, fmt.Sprintf("%03d-%s.bin", i, kind))
+		if err := os.WriteFile(filepath.Join(dir, path), entry.Data, 0o644); err != nil {
+			return nil, fmt.Errorf("write sample: %w", err)
+		}
+		sum := sha256.Sum256(entry.Data)
+		samples = append(samples, CorpusSample{
+			Suite:     suite,
+			Scenario:  scenario,
+			Kind:      kind,
+			Label:     string(entry.Label),
+			Path:      filepath.ToSlash(path),
+			Bytes:     len(entry.Data),
+			SHA256Hex: hex.EncodeToString(sum[:]),
+		})
+	}
+	return samples, nil
+}
+
+// corpusKind is the sample kind of a transcript entry, empty for the raw
+// bytes a scenario digests that are not MLS artifacts.
+func corpusKind(entry TranscriptEntry) string {
+	switch entry.Type {
+	case TranscriptKeyPackage:
+		return CorpusKeyPackage
+	case TranscriptWelcome:
+		return CorpusWelcome
+	case TranscriptCiphertext:
+		return CorpusCiphertext
+	case TranscriptMLSPlaintext:
+		if string(entry.Label) == "commit" {
+			return CorpusCommit
+		}
+		return CorpusProposal
+	default:
+		return ""
+	}
+}
+
+// LoadCorpus reads the manifest of a corpus directory and the bytes of every
+// sample, in manifest order, checking each against its recorded hash.
+func LoadCorpus(dir string) (*CorpusManifest, [][]byte, error) {
+	data, err := os.ReadFile(filepath.Join(dir, CorpusManifestName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("read manifest: %w", err)
+	}
+	var manifest CorpusManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal manifest: %w", err)
+	}
+	if manifest.FormatVersion != CorpusFormatVersion {
+		return nil, nil, fmt.Errorf("unsupported corpus format_version %d", manifest.FormatVersion)
+	}
+	samples := make([][]byte, 0, len(manifest.Samples))
+	for _, sample := range manifest.Samples {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(sample.Path)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("read sample: %w", err)
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != sample.SHA256Hex {
+			return nil, nil, fmt.Errorf("%s: hash does not match the manifest", sample.Path)
+		}
+		samples = append(samples, data)
+	}
+	return &manifest, samples, nil
+}
+
+// DecodeCorpusSample decodes data as an artifact of kind with the vendored
+// go-mls, which must consume every byte, so a corpus written by one version
+// checks that another still reads it.
+func DecodeCorpusSample(kind string, data []byte) error {
+	var v interface{}
+	switch kind {
+	case CorpusKeyPackage:
+		v = new(mls.KeyPackage)
+	case CorpusWelcome:
+		v = new(mls.Welcome)
+	case CorpusProposal, CorpusCommit:
+		v = new(mls.MLSPlaintext)
+	case CorpusCiphertext:
+		v = new(mls.MLSCiphertext)
+	default:
+		return fmt.Errorf("unknown sample kind %q", kind)
+	}
+	read, err := syntax.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("decode %s: %w", kind, err)
+	}
+	if read != len(data) {
+		return fmt.Errorf("decode %s: %d trailing bytes", kind, len(data)-read)
+	}
+	return nil
+}
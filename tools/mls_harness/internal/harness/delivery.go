@@ -0,0 +1,187 @@
+//go:build !tinygo
+// +build !tinygo
+
+package harness
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// Delivery is one artifact of a commit arriving at a recipient.
+type Delivery uint8
+
+const (
+	DeliveryCommit Delivery = iota
+	DeliveryWelcome
+)
+
+func (d Delivery) String() string {
+	if d == DeliveryWelcome {
+		return "welcome"
+	}
+	return "commit"
+}
+
+// DeliveryOrders returns every distinct order in which a recipient can be
+// handed a commit and its Welcome, each once or, with duplicates, each twice.
+func DeliveryOrders(duplicates bool) [][]Delivery {
+	counts := []int{1, 1}
+	if duplicates {
+		counts = []int{2, 2}
+	}
+	var orders [][]Delivery
+	var walk func(prefix []Delivery)
+	walk = func(prefix []Delivery) {
+		if counts[DeliveryCommit] == 0 && counts[DeliveryWelcome] == 0 {
+			orders = append(orders, append([]Delivery(nil), prefix...))
+			return
+		}
+		for _, d := range []Delivery{DeliveryCommit, DeliveryWelcome} {
+			if counts[d] == 0 {
+				continue
+			}
+			counts[d]--
+			walk(append(prefix, d))
+			counts[d]++
+		}
+	}
+	walk(nil)
+	return orders
+}
+
+// DeliveryReport counts what CheckDeliveryOrders ran.
+type DeliveryReport struct {
+	Recipients int
+	Orders     int
+	Runs       int
+	// Rejected counts deliveries a recipient correctly refused or discarded:
+	// a commit for an epoch it is not in, a Welcome that is not for it or that
+	// it already joined through.
+	Rejected int
+}
+
+// CheckDeliveryOrders hands a commit and its Welcome to every recipient in
+// each order DeliveryOrders gives, on a copy of the recipient each time. The
+// committer is already in the commit's epoch; members are the other existing
+// members, still in the epoch before; joiners are the members the commit adds,
+// not yet in the group. Every recipient is sent both artifacts, as a delivery
+// service fanning a commit out to the group and its new members may do.
+//
+// Each run must end with the recipient in the committer's epoch, agreeing with
+// it by CompareEpochs, and every artifact it was handed must be applied or
+// correctly rejected: a commit is applied only by a member in the epoch it was
+// sent in, a Welcome only by a joiner not yet in the group, and a Welcome that
+// does open for a member already in it must reproduce the epoch it is in.
+// Recipients share no state, so the order in which different recipients are
+// served cannot matter and only each recipient's own order is permuted.
+func CheckDeliveryOrders(committer *Participant, members, joiners []*Participant, commit *mls.MLSPlaintext, welcome *mls.Welcome, duplicates bool) (*DeliveryReport, error) {
+	if commit == nil || welcome == nil {
+		return nil, errors.New("a commit and a Welcome are required")
+	}
+	if committer.State == nil || committer.State.Epoch != commit.Epoch+1 {
+		return nil, fmt.Errorf("%s must already be in the epoch its commit starts", committer.Name)
+	}
+	orders := DeliveryOrders(duplicates)
+	recipients := append(append([]*Participant{committer}, members...), joiners...)
+	report := &DeliveryReport{Recipients: len(recipients), Orders: len(orders)}
+	for _, recipient := range recipients {
+		for _, order := range orders {
+			rejected, err := deliverInOrder(committer, recipient, order, commit, welcome)
+			if err != nil {
+				return report, fmt.Errorf("%s, order %v: %w", recipient.Name, order, err)
+			}
+			report.Runs++
+			report.Rejected += rejected
+		}
+	}
+	return report, nil
+}
+
+func deliverInOrder(committer, recipient *Participant, order []Delivery, commit *mls.MLSPlaintext, welcome *mls.Welcome) (int, error) {
+	p, err := copyParticipant(recipient)
+	if err != nil {
+		return 0, err
+	}
+	rejected := 0
+	for i, d := range order {
+		applied, err := deliverOne(p, d, commit, welcome)
+		if err != nil {
+			return rejected, fmt.Errorf("delivery %d (%s): %w", i, d, err)
+		}
+		if !applied {
+			rejected++
+		}
+	}
+	if p.State == nil {
+		return rejected, errors.New("never joined")
+	}
+	if diff := CompareEpochs(committer, p); diff != nil {
+		return rejected, errors.New(diff.String())
+	}
+	return rejected, nil
+}
+
+// deliverOne hands p one artifact and reports whether p applied it. An error
+// means p applied what it should have rejected, or the other way round.
+func deliverOne(p *Participant, d Delivery, commit *mls.MLSPlaintext, welcome *mls.Welcome) (bool, error) {
+	switch d {
+	case DeliveryCommit:
+		if p.State == nil {
+			// Not in the group yet, so there is nothing to apply it to.
+			return false, nil
+		}
+		expected := p.State.Epoch == commit.Epoch
+		next, err := p.State.Handle(commit)
+		switch {
+		case expected && err != nil:
+			return false, fmt.Errorf("rejected a commit for its epoch %d: %w", p.State.Epoch, err)
+		case expected && next == nil:
+			return false, errors.New("commit made no state transition")
+		case !expected && err == nil:
+			return false, fmt.Errorf("applied a commit for epoch %d in epoch %d", commit.Epoch, p.State.Epoch)
+		case !expected:
+			return false, nil
+		}
+		p.State = next
+		return true, nil
+	default:
+		state, err := joinedState(p, welcome)
+		if p.State == nil {
+			if err != nil {
+				return false, fmt.Errorf("could not join: %w", err)
+			}
+			p.State = state
+			return true, nil
+		}
+		if err != nil {
+			return false, nil
+		}
+		// A Welcome replayed to a member that already joined through it opens
+		// again; it must be the epoch the member is in, and is discarded.
+		if diff := CompareEpochs(p, &Participant{Name: p.Name + " (rejoined)", Session: Session{State: state}}); diff != nil {
+			return false, fmt.Errorf("a Welcome opened for a member already in the group: %s", diff)
+		}
+		return false, nil
+	}
+}
+
+// copyParticipant copies p through a state snapshot, since mls.State.Clone
+// shares the key schedule's ratchets and drops the confirmed transcript hash.
+func copyParticipant(p *Participant) (*Participant, error) {
+	c := *p
+	if p.State == nil {
+		return &c, nil
+	}
+	data, err := EncodeState(p.State)
+	if err != nil {
+		return nil, fmt.Errorf("copy %s: %w", p.Name, err)
+	}
+	if c.State, err = DecodeState(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("copy %s: %w", p.Name, err)
+	}
+	return &c, nil
+}
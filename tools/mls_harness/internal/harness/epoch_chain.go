@@ -0,0 +1,131 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// epochAuthenticatorLabel is the exporter label used to derive a per-epoch
+// authenticator. The pinned go-mls draft predates the RFC 9420
+// epoch_authenticator secret, so the value comes from the exporter instead; it
+// is a function of the epoch's key schedule and group context either way.
+const epochAuthenticatorLabel = "epoch authenticator"
+
+type EpochChainEntry struct {
+	Epoch            uint64 `json:"epoch"`
+	AuthenticatorHex string `json:"authenticator_hex"`
+}
+
+// EpochChain is the ordered sequence of epoch authenticators a group moved through.
+type EpochChain struct {
+	Scenario string            `json:"scenario"`
+	Suite    string            `json:"cipher_suite"`
+	Epochs   []EpochChainEntry `json:"epochs"`
+}
+
+func EpochAuthenticator(state *mls.State) []byte {
+	return state.Keys.Export(epochAuthenticatorLabel, nil, state.CipherSuite.Constants().SecretSize)
+}
+
+// Observe records the authenticator of the epoch state is in. A state at an epoch
+// already in the chain must reproduce the recorded authenticator, which catches
+// members that agree on the epoch number but not on the key schedule.
+func (c *EpochChain) Observe(state *mls.State) error {
+	if state == nil {
+		return errors.New("observe epoch: state is nil")
+	}
+	if c.Suite == "" {
+		c.Suite = state.CipherSuite.String()
+	} else if c.Suite != state.CipherSuite.String() {
+		return fmt.Errorf("observe epoch: cipher suite %s does not match chain suite %s", state.CipherSuite, c.Suite)
+	}
+
+	epoch := uint64(state.Epoch)
+	authenticator := hex.EncodeToString(EpochAuthenticator(state))
+	for _, entry := range c.Epochs {
+		if entry.Epoch != epoch {
+			continue
+		}
+		if entry.AuthenticatorHex != authenticator {
+			return fmt.Errorf("epoch %d: authenticator %s does not match recorded %s", epoch, authenticator, entry.AuthenticatorHex)
+		}
+		return nil
+	}
+
+	if n := len(c.Epochs); n > 0 && epoch < c.Epochs[n-1].Epoch {
+		return fmt.Errorf("epoch %d observed after epoch %d", epoch, c.Epochs[n-1].Epoch)
+	}
+	c.Epochs = append(c.Epochs, EpochChainEntry{Epoch: epoch, AuthenticatorHex: authenticator})
+	return nil
+}
+
+// Compare checks the chain against a golden recording and reports the first
+// epoch at which they diverge.
+func (c *EpochChain) Compare(golden *EpochChain) error {
+	if golden.Scenario != "" && golden.Scenario != c.Scenario {
+		return fmt.Errorf("scenario %q does not match golden %q", c.Scenario, golden.Scenario)
+	}
+	if golden.Suite != c.Suite {
+		return fmt.Errorf("cipher suite %s does not match golden %s", c.Suite, golden.Suite)
+	}
+	for i := 0; i < len(c.Epochs) && i < len(golden.Epochs); i++ {
+		got, want := c.Epochs[i], golden.Epochs[i]
+		if got.Epoch != want.Epoch {
+			return fmt.Errorf("entry %d: epoch %d does not match golden epoch %d", i, got.Epoch, want.Epoch)
+		}
+		if got.AuthenticatorHex != want.AuthenticatorHex {
+			return fmt.Errorf("epoch %d: authenticator %s does not match golden %s", got.Epoch, got.AuthenticatorHex, want.AuthenticatorHex)
+		}
+	}
+	if len(c.Epochs) != len(golden.Epochs) {
+		return fmt.Errorf("chain has %d epochs, golden has %d", len(c.Epochs), len(golden.Epochs))
+	}
+	return nil
+}
+
+func LoadEpochChain(path string) (*EpochChain, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read epoch chain: %w", err)
+	}
+
+	var chain EpochChain
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&chain); err != nil {
+		return nil, fmt.Errorf("unmarshal epoch chain: %w", err)
+	}
+	if chain.Suite == "" {
+		return nil, errors.New("cipher_suite is required")
+	}
+	if len(chain.Epochs) == 0 {
+		return nil, errors.New("epoch chain is empty")
+	}
+	for i, entry := range chain.Epochs {
+		if _, err := hex.DecodeString(entry.AuthenticatorHex); err != nil || entry.AuthenticatorHex == "" {
+			return nil, fmt.Errorf("entry %d: invalid authenticator_hex", i)
+		}
+		if i > 0 && entry.Epoch <= chain.Epochs[i-1].Epoch {
+			return nil, fmt.Errorf("entry %d: epoch %d is not after epoch %d", i, entry.Epoch, chain.Epochs[i-1].Epoch)
+		}
+	}
+	return &chain, nil
+}
+
+func (c *EpochChain) WriteFile(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal epoch chain: %w", err)
+	}
+	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write epoch chain: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package harness
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Fields CompareEpochs checks.
+const (
+	EpochFieldEpoch         = "epoch"
+	EpochFieldAuthenticator = "epoch_authenticator"
+	EpochFieldInitSecret    = "init_secret"
+)
+
+// EpochFieldDiff is one field two members disagree on. Secrets are shown as
+// fingerprints, the first 8 bytes of their SHA-256, never as themselves.
+type EpochFieldDiff struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// EpochDiff lists the fields on which member A's epoch differs from B's.
+type EpochDiff struct {
+	A      string           `json:"a"`
+	B      string           `json:"b"`
+	Fields []EpochFieldDiff `json:"fields"`
+}
+
+func (d *EpochDiff) String() string {
+	parts := make([]string, 0, len(d.Fields))
+	for _, f := range d.Fields {
+		parts = append(parts, fmt.Sprintf("%s %s != %s", f.Field, f.A, f.B))
+	}
+	return fmt.Sprintf("%s and %s diverge: %s", d.A, d.B, strings.Join(parts, ", "))
+}
+
+// CompareEpochs checks that a and b are in the same epoch of the same key
+// schedule: the epoch number, the epoch authenticator and the init secret. It
+// returns nil if they agree. Decrypting each other's messages only shows the
+// application secrets line up for the senders tried; a member whose key
+// schedule drifted elsewhere can still do that, and fails here.
+//
+// The pinned go-mls draft predates the RFC 9420 resumption secret. The init
+// secret stands in for it: it too comes from the epoch secret alone, and it
+// seeds the next epoch, so a difference shows before any message does.
+func CompareEpochs(a, b *Participant) *EpochDiff {
+	d := &EpochDiff{A: a.Name, B: b.Name}
+	if a.State.Epoch != b.State.Epoch {
+		d.Fields = append(d.Fields, EpochFieldDiff{
+			Field: EpochFieldEpoch,
+			A:     strconv.FormatUint(uint64(a.State.Epoch), 10),
+			B:     strconv.FormatUint(uint64(b.State.Epoch), 10),
+		})
+	}
+	d.compareSecret(EpochFieldAuthenticator, EpochAuthenticator(a.State), EpochAuthenticator(b.State))
+	d.compareSecret(EpochFieldInitSecret, a.State.Keys.InitSecret, b.State.Keys.InitSecret)
+	if len(d.Fields) == 0 {
+		return nil
+	}
+	return d
+}
+
+func (d *EpochDiff) compareSecret(field string, a, b []byte) {
+	if !bytes.Equal(a, b) {
+		d.Fields = append(d.Fields, EpochFieldDiff{Field: field, A: secretFingerprint(a), B: secretFingerprint(b)})
+	}
+}
+
+func secretFingerprint(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:8])
+}
@@ -3,28 +3,56 @@ package harness
 import (
 	"bytes"
 	crand "crypto/rand"
-	"crypto/sha256"
-	"encoding/binary"
-	"encoding/hex"
+	"errors"
 	"fmt"
-	"hash"
+	"io"
 	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	mls "github.com/cisco/go-mls"
-	syntax "github.com/cisco/go-tls-syntax"
 )
 
+// Identity is who a participant is: the signature key and credential every
+// session it opens signs and authenticates with.
+type Identity struct {
+	Name       string
+	Suite      mls.CipherSuite
+	SigningKey mls.SignaturePrivateKey
+	Credential mls.Credential
+}
+
+// Session is one membership an identity holds: the init secret and KeyPackage
+// it joins with, and its group state once it has joined.
+type Session struct {
+	InitSecret []byte
+	KeyPackage mls.KeyPackage
+	State      *mls.State
+}
+
+// Participant is one session of an identity, such as one of a user's devices.
+// Name is the session's, which for a device differs from the identity's.
 type Participant struct {
-	Name        string
-	InitSecret  []byte
-	IdentityKey mls.SignaturePrivateKey
-	KeyPackage  mls.KeyPackage
-	State       *mls.State
+	Name string
+	Identity
+	Session
 }
 
-func RandomBytes(rng *rand.Rand, n int) []byte {
+// Every harness function that picks a secret (an init secret, a leaf secret
+// for a commit) takes the reader to draw it from, so a scenario fixes its
+// secrets by passing DeterministicRNG, or any seeded reader, without touching
+// anything process-wide. go-mls still draws HPKE ephemeral keys, ECDSA
+// signature nonces and message nonces from crypto/rand itself. A commit's HPKE
+// ciphertexts enter the transcript hash, so without WithCryptoRand two runs
+// agree on membership and epoch numbers but not on epoch secrets or bytes.
+
+// RandomBytes reads n bytes from rng and panics if it runs dry.
+func RandomBytes(rng io.Reader, n int) []byte {
 	b := make([]byte, n)
-	if _, err := rng.Read(b); err != nil {
+	if _, err := io.ReadFull(rng, b); err != nil {
 		panic(err)
 	}
 	return b
@@ -39,18 +67,88 @@ func DeterministicRNGWithSeed(seed int64) *rand.Rand {
 	return rand.New(rand.NewSource(seed))
 }
 
-func OverrideCryptoRand(rng *rand.Rand) func() {
+// cryptoRandMu is held by the goroutine in cryptoRandOwner from its outermost
+// pin of crypto/rand until that pin is restored, so pins in different
+// goroutines never interleave while one goroutine may nest them.
+var (
+	cryptoRandMu    sync.Mutex
+	cryptoRandOwner atomic.Uint64
+)
+
+// WithCryptoRand runs fn with crypto/rand.Reader replaced by rng, so go-mls
+// draws from rng as well and every byte of a run repeats, then puts back the
+// reader it replaced. Digests and golden files that pin the bytes go-mls draws
+// only reproduce this way. Calls in different goroutines run one at a time;
+// fn may call WithCryptoRand again, as GenerateCorpus does when a pinned run
+// calls it, and draws from its own rng until that call returns. Anything else
+// in the process that reads crypto/rand meanwhile gets rng's predictable
+// bytes.
+func WithCryptoRand(rng io.Reader, fn func() error) error {
+	restore := pinCryptoRand(rng)
+	defer restore()
+	return fn()
+}
+
+// OverrideCryptoRand replaces crypto/rand.Reader with rng until the returned
+// func is called, as WithCryptoRand does for the span of fn.
+//
+// Deprecated: use WithCryptoRand, which cannot leave crypto/rand swapped on
+// an early return, and pass the reader to the harness functions wherever
+// go-mls does not need to draw from it.
+func OverrideCryptoRand(rng io.Reader) func() {
+	return pinCryptoRand(rng)
+}
+
+func pinCryptoRand(rng io.Reader) func() {
+	id := goroutineID()
+	outermost := id == 0 || cryptoRandOwner.Load() != id
+	if outermost {
+		cryptoRandMu.Lock()
+		cryptoRandOwner.Store(id)
+	}
 	original := crand.Reader
 	crand.Reader = rng
 	return func() {
 		crand.Reader = original
+		if outermost {
+			cryptoRandOwner.Store(0)
+			cryptoRandMu.Unlock()
+		}
 	}
 }
 
+// goroutineID returns the running goroutine's number, which its stack trace
+// starts with: "goroutine 17 [running]:". No goroutine is number 0, so 0 means
+// the runtime did not say, as TinyGo's does not, and pins do not nest there.
+func goroutineID() uint64 {
+	var buf [32]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if end := bytes.IndexByte(header, ' '); end >= 0 {
+		header = header[:end]
+	}
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
+
+// MakeKeyPackageDeterministic gives kp the lifetime every vector was recorded
+// with, VectorKeyPackageLifetime from VectorClock, and re-signs it.
 func MakeKeyPackageDeterministic(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey) error {
-	const deterministicExpiry uint64 = 4_102_444_800 // 2100-01-01 00:00:00 UTC
+	return MakeKeyPackageAt(kp, sigPriv, VectorClock, VectorKeyPackageLifetime)
+}
 
-	lifetime := mls.LifetimeExtension{NotBefore: 0, NotAfter: deterministicExpiry}
+// MakeKeyPackageWithLifetime replaces the KeyPackage lifetime with [notBefore, notAfter]
+// and re-signs it. go-mls checks the lifetime against the wall clock when an Add is
+// applied, so callers time-travel by choosing the window rather than the clock.
+func MakeKeyPackageWithLifetime(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey, notBefore, notAfter time.Time) error {
+	if notAfter.Before(notBefore) {
+		return fmt.Errorf("lifetime ends (%s) before it starts (%s)", notAfter.UTC().Format(time.RFC3339), notBefore.UTC().Format(time.RFC3339))
+	}
+	return makeKeyPackageLifetime(kp, sigPriv, uint64(notBefore.Unix()), uint64(notAfter.Unix()))
+}
+
+func makeKeyPackageLifetime(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey, notBefore, notAfter uint64) error {
+	lifetime := mls.LifetimeExtension{NotBefore: notBefore, NotAfter: notAfter}
 	if err := kp.Extensions.Add(lifetime); err != nil {
 		return fmt.Errorf("set lifetime extension: %w", err)
 	}
@@ -62,201 +160,299 @@ func MakeKeyPackageDeterministic(kp *mls.KeyPackage, sigPriv mls.SignaturePrivat
 	return nil
 }
 
-func NewParticipant(rng *rand.Rand, suite mls.CipherSuite, name string) (*Participant, error) {
+// NewParticipant creates an identity and its first session from one secret,
+// which both derives the signature key and is the KeyPackage init secret.
+//
+// Deprecated: use NewIdentity and NewSession, which keep the two secrets
+// apart. Only the bootstraps and scenarios behind pinned digests still derive
+// both from one secret, so that those digests do not move.
+func NewParticipant(rng io.Reader, suite mls.CipherSuite, name string) (*Participant, error) {
+	return newParticipant(rng, suite, name, MakeKeyPackageDeterministic)
+}
+
+// NewParticipantWithLifetime is NewParticipant with a KeyPackage valid only in
+// [notBefore, notAfter], for exercising lifetime validation.
+//
+// Deprecated: use NewIdentity and NewSessionWithLifetime.
+func NewParticipantWithLifetime(rng io.Reader, suite mls.CipherSuite, name string, notBefore, notAfter time.Time) (*Participant, error) {
+	return newParticipant(rng, suite, name, func(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey) error {
+		return MakeKeyPackageWithLifetime(kp, sigPriv, notBefore, notAfter)
+	})
+}
+
+// newParticipant is NewParticipant with the KeyPackage stabilized by
+// stabilize. BootstrapGroupWithMetrics and AddMember call it directly: every
+// digest and golden file they feed was recorded with one shared secret.
+func newParticipant(rng io.Reader, suite mls.CipherSuite, name string, stabilize func(*mls.KeyPackage, mls.SignaturePrivateKey) error) (*Participant, error) {
 	secret := RandomBytes(rng, 32)
+	id, err := identityFromSecret(suite, name, secret)
+	if err != nil {
+		return nil, err
+	}
+	return id.newSession(secret, name, stabilize)
+}
+
+// NewIdentity creates an identity with a signature key of its own, drawn
+// from rng apart from any session's init secret.
+func NewIdentity(rng io.Reader, suite mls.CipherSuite, name string) (*Identity, error) {
+	return identityFromSecret(suite, name, RandomBytes(rng, 32))
+}
+
+func identityFromSecret(suite mls.CipherSuite, name string, secret []byte) (*Identity, error) {
 	scheme := suite.Scheme()
 	sigPriv, err := scheme.Derive(secret)
 	if err != nil {
 		return nil, fmt.Errorf("derive identity key: %w", err)
 	}
-	cred := mls.NewBasicCredential([]byte(name), scheme, sigPriv.PublicKey)
-	kp, err := mls.NewKeyPackageWithSecret(suite, secret, cred, sigPriv)
+	return &Identity{
+		Name:       name,
+		Suite:      suite,
+		SigningKey: sigPriv,
+		Credential: *mls.NewBasicCredential([]byte(name), scheme, sigPriv.PublicKey),
+	}, nil
+}
+
+// NewSession opens a session of the identity named name: a fresh init secret
+// and KeyPackage under the identity's credential. An identity may hold any
+// number of sessions, in one group or many.
+func (id *Identity) NewSession(rng io.Reader, name string) (*Participant, error) {
+	return id.newSession(RandomBytes(rng, 32), name, MakeKeyPackageDeterministic)
+}
+
+// NewSessionWithLifetime is NewSession with a KeyPackage valid only in
+// [notBefore, notAfter], for exercising lifetime validation.
+func (id *Identity) NewSessionWithLifetime(rng io.Reader, name string, notBefore, notAfter time.Time) (*Participant, error) {
+	return id.newSession(RandomBytes(rng, 32), name, func(kp *mls.KeyPackage, sigPriv mls.SignaturePrivateKey) error {
+		return MakeKeyPackageWithLifetime(kp, sigPriv, notBefore, notAfter)
+	})
+}
+
+func (id *Identity) newSession(secret []byte, name string, stabilize func(*mls.KeyPackage, mls.SignaturePrivateKey) error) (*Participant, error) {
+	cred := id.Credential
+	kp, err := mls.NewKeyPackageWithSecret(id.Suite, secret, &cred, id.SigningKey)
 	if err != nil {
 		return nil, fmt.Errorf("create key package: %w", err)
 	}
 
-	if err := MakeKeyPackageDeterministic(kp, sigPriv); err != nil {
+	if err := stabilize(kp, id.SigningKey); err != nil {
 		return nil, fmt.Errorf("stabilize key package: %w", err)
 	}
 
 	return &Participant{
-		Name:        name,
-		InitSecret:  secret,
-		IdentityKey: sigPriv,
-		KeyPackage:  *kp,
+		Name:     name,
+		Identity: *id,
+		Session: Session{
+			InitSecret: secret,
+			KeyPackage: *kp,
+		},
 	}, nil
 }
 
-func BootstrapPairWithDigest(rng *rand.Rand, dig *TranscriptDigest) (*Participant, *Participant, error) {
-	suite := mls.X25519_AES128GCM_SHA256_Ed25519
+// NewDevice creates another device for the same user: a new session of the
+// user's identity, so every device leaf in the tree authenticates as the same
+// identity.
+func NewDevice(rng io.Reader, user *Participant, deviceName string) (*Participant, error) {
+	return user.Identity.NewSession(rng, deviceName)
+}
+
+// bootstrapGroupID is the group ID every bootstrapped group uses.
+var bootstrapGroupID = []byte{0x01, 0x02, 0x03, 0x04}
 
-	alice, err := NewParticipant(rng, suite, "alice")
+// BootstrapPairWithDigest is BootstrapGroupWithDigest for alice and bob in
+// X25519_AES128GCM_SHA256_Ed25519.
+func BootstrapPairWithDigest(rng io.Reader, dig *TranscriptDigest) (*Participant, *Participant, error) {
+	return BootstrapPairWithMetrics(rng, dig, nil)
+}
+
+// BootstrapPairWithMetrics is BootstrapPairWithDigest reporting the commit and
+// join to metrics.
+func BootstrapPairWithMetrics(rng io.Reader, dig *TranscriptDigest, metrics Metrics) (*Participant, *Participant, error) {
+	members, err := BootstrapGroupWithMetrics(rng, mls.X25519_AES128GCM_SHA256_Ed25519, []string{"alice", "bob"}, dig, metrics)
 	if err != nil {
-		return nil, nil, fmt.Errorf("alice init: %w", err)
+		return nil, nil, err
 	}
-	bob, err := NewParticipant(rng, suite, "bob")
-	if err != nil {
-		return nil, nil, fmt.Errorf("bob init: %w", err)
+	return members[0], members[1], nil
+}
+
+// BootstrapGroupWithDigest creates a participant for each name and a group
+// the first creates and adds the rest to in one commit, which every other
+// participant joins through its Welcome. It returns the participants in the
+// order of names, all in epoch 1, or the creator alone in epoch 0 for a single
+// name. For two names it draws from rng and feeds dig exactly as two-party
+// bootstrap always has, so the vectors digest does not move; for the same
+// reason each participant's signature key and init secret still come from one
+// secret.
+func BootstrapGroupWithDigest(rng io.Reader, suite mls.CipherSuite, names []string, dig *TranscriptDigest) ([]*Participant, error) {
+	return BootstrapGroupWithMetrics(rng, suite, names, dig, nil)
+}
+
+// BootstrapGroupWithMetrics is BootstrapGroupWithDigest reporting the commit
+// and every join to metrics.
+func BootstrapGroupWithMetrics(rng io.Reader, suite mls.CipherSuite, names []string, dig *TranscriptDigest, metrics Metrics) ([]*Participant, error) {
+	if len(names) == 0 {
+		return nil, errors.New("at least one member name is required")
+	}
+	members := make([]*Participant, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("member name %q repeats", name)
+		}
+		seen[name] = true
+		member, err := newParticipant(rng, suite, name, MakeKeyPackageDeterministic)
+		if err != nil {
+			return nil, fmt.Errorf("%s init: %w", name, err)
+		}
+		members = append(members, member)
 	}
 
-	groupID := []byte{0x01, 0x02, 0x03, 0x04}
 	if dig != nil {
-		if err := dig.AddBytes("group-id", groupID); err != nil {
-			return nil, nil, fmt.Errorf("digest group id: %w", err)
-		}
-		if err := dig.AddKeyPackage("alice-key-package", alice.KeyPackage); err != nil {
-			return nil, nil, fmt.Errorf("digest alice key package: %w", err)
+		if err := dig.AddBytes("group-id", bootstrapGroupID); err != nil {
+			return nil, fmt.Errorf("digest group id: %w", err)
 		}
-		if err := dig.AddKeyPackage("bob-key-package", bob.KeyPackage); err != nil {
-			return nil, nil, fmt.Errorf("digest bob key package: %w", err)
+		for _, member := range members {
+			if err := dig.AddKeyPackage(member.Name+"-key-package", member.KeyPackage); err != nil {
+				return nil, fmt.Errorf("digest %s key package: %w", member.Name, err)
+			}
 		}
 	}
 
-	alice.State, err = mls.NewEmptyState(groupID, alice.InitSecret, alice.IdentityKey, alice.KeyPackage)
+	creator, joiners := members[0], members[1:]
+	var err error
+	creator.State, err = mls.NewEmptyState(bootstrapGroupID, creator.InitSecret, creator.SigningKey, creator.KeyPackage)
 	if err != nil {
-		return nil, nil, fmt.Errorf("create group: %w", err)
+		return nil, fmt.Errorf("create group: %w", err)
 	}
-
-	add, err := alice.State.Add(bob.KeyPackage)
-	if err != nil {
-		return nil, nil, fmt.Errorf("add bob: %w", err)
+	if len(joiners) == 0 {
+		return members, nil
 	}
-	if dig != nil {
-		if err := dig.AddMLSPlaintext("add", add); err != nil {
-			return nil, nil, fmt.Errorf("digest add: %w", err)
+
+	for _, joiner := range joiners {
+		add, err := creator.State.Add(joiner.KeyPackage)
+		if err != nil {
+			return nil, fmt.Errorf("add %s: %w", joiner.Name, err)
+		}
+		if dig != nil {
+			if err := dig.AddMLSPlaintext("add", add); err != nil {
+				return nil, fmt.Errorf("digest add: %w", err)
+			}
+		}
+		if _, err = creator.State.Handle(add); err != nil {
+			return nil, fmt.Errorf("handle add: %w", err)
 		}
-	}
-	if _, err = alice.State.Handle(add); err != nil {
-		return nil, nil, fmt.Errorf("handle add: %w", err)
 	}
 
 	commitSecret := RandomBytes(rng, 32)
-	commitPT, welcome, nextAlice, err := alice.State.Commit(commitSecret)
+	commitPT, welcome, next, err := commitMeasured(creator, commitSecret, metrics)
 	if err != nil {
-		return nil, nil, fmt.Errorf("commit: %w", err)
+		return nil, fmt.Errorf("commit: %w", err)
 	}
 	if dig != nil {
 		if err := dig.AddMLSPlaintext("commit", commitPT); err != nil {
-			return nil, nil, fmt.Errorf("digest commit: %w", err)
+			return nil, fmt.Errorf("digest commit: %w", err)
 		}
 		if err := dig.AddWelcome("welcome", welcome); err != nil {
-			return nil, nil, fmt.Errorf("digest welcome: %w", err)
+			return nil, fmt.Errorf("digest welcome: %w", err)
 		}
 	}
-	alice.State = nextAlice
+	creator.State = next
 
-	bob.State, err = mls.NewJoinedState(bob.InitSecret, []mls.SignaturePrivateKey{bob.IdentityKey}, []mls.KeyPackage{bob.KeyPackage}, *welcome)
-	if err != nil {
-		return nil, nil, fmt.Errorf("bob join: %w", err)
+	if err := JoinWelcomeWithMetrics(joiners, welcome, metrics); err != nil {
+		return nil, err
 	}
-
-	return alice, bob, nil
+	return members, nil
 }
 
-func ExchangeOnce(sender, receiver *Participant, msg []byte) error {
-	return ExchangeOnceWithDigest(sender, receiver, msg, "", nil)
+// JoinWelcome has each joiner join the group through welcome.
+func JoinWelcome(joiners []*Participant, welcome *mls.Welcome) error {
+	return JoinWelcomeWithMetrics(joiners, welcome, nil)
 }
 
-func ExchangeOnceWithDigest(sender, receiver *Participant, msg []byte, label string, dig *TranscriptDigest) error {
-	ct, err := sender.State.Protect(msg)
-	if err != nil {
-		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
-	}
-
-	if dig != nil {
-		if err := dig.AddCiphertext(label, ct); err != nil {
-			return fmt.Errorf("digest update failed: %w", err)
+// JoinWelcomeWithMetrics is JoinWelcome reporting each join to metrics.
+func JoinWelcomeWithMetrics(joiners []*Participant, welcome *mls.Welcome, metrics Metrics) error {
+	welcomeBytes := 0
+	if metrics != nil {
+		welcomeBytes = encodedSize(*welcome)
+	}
+	for _, joiner := range joiners {
+		start := time.Now()
+		state, err := joinedState(joiner, welcome)
+		if metrics != nil {
+			metrics.OnJoin(joiner.Name, time.Since(start), welcomeBytes, err)
 		}
+		if err != nil {
+			return fmt.Errorf("%s join: %w", joiner.Name, err)
+		}
+		joiner.State = state
 	}
-
-	pt, err := receiver.State.Unprotect(ct)
-	if err != nil {
-		return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
-	}
-
-	if !bytes.Equal(pt, msg) {
-		return fmt.Errorf("plaintext mismatch for %s -> %s", sender.Name, receiver.Name)
-	}
-
 	return nil
 }
 
-type TranscriptDigest struct {
-	h hash.Hash
+// joinedState is the state joiner enters the group in through welcome.
+func joinedState(joiner *Participant, welcome *mls.Welcome) (*mls.State, error) {
+	return mls.NewJoinedState(joiner.InitSecret, []mls.SignaturePrivateKey{joiner.SigningKey}, []mls.KeyPackage{joiner.KeyPackage}, *welcome)
 }
 
-func NewTranscriptDigest() *TranscriptDigest {
-	return &TranscriptDigest{h: sha256.New()}
-}
-
-func (t *TranscriptDigest) AddBytes(label string, data []byte) error {
-	if t == nil {
-		return nil
-	}
-	if _, err := t.h.Write([]byte(label)); err != nil {
-		return err
-	}
-
-	var lenBuf [4]byte
-	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
-	if _, err := t.h.Write(lenBuf[:]); err != nil {
-		return err
+// DeliverProposal has each receiver handle a proposal, so it can apply the
+// commit that covers it.
+func DeliverProposal(receivers []*Participant, proposal *mls.MLSPlaintext) error {
+	for _, receiver := range receivers {
+		if _, err := receiver.State.Handle(proposal); err != nil {
+			return fmt.Errorf("%s handle proposal: %w", receiver.Name, err)
+		}
 	}
+	return nil
+}
 
-	if _, err := t.h.Write(data); err != nil {
-		return err
+// DeliverCommit has each receiver apply a commit and move to its epoch.
+func DeliverCommit(receivers []*Participant, commit *mls.MLSPlaintext) error {
+	for _, receiver := range receivers {
+		next, err := receiver.State.Handle(commit)
+		if err != nil {
+			return fmt.Errorf("%s apply commit: %w", receiver.Name, err)
+		}
+		if next == nil {
+			return fmt.Errorf("%s apply commit: no state transition", receiver.Name)
+		}
+		receiver.State = next
 	}
-
 	return nil
 }
 
-func (t *TranscriptDigest) AddKeyPackage(label string, kp mls.KeyPackage) error {
-	if t == nil {
-		return nil
-	}
-	data, err := syntax.Marshal(kp)
-	if err != nil {
-		return err
-	}
-	return t.AddBytes(label, data)
+// ErrPlaintextMismatch is returned when a message decrypts to something other
+// than what was sent.
+var ErrPlaintextMismatch = errors.New("plaintext mismatch")
+
+func ExchangeOnce(sender, receiver *Participant, msg []byte) error {
+	return ExchangeOnceWithDigest(sender, receiver, msg, "", nil)
 }
 
-func (t *TranscriptDigest) AddMLSPlaintext(label string, pt *mls.MLSPlaintext) error {
-	if pt == nil {
-		return fmt.Errorf("nil MLSPlaintext for label %s", label)
-	}
-	data, err := syntax.Marshal(pt)
-	if err != nil {
-		return err
-	}
-	return t.AddBytes(label, data)
+func ExchangeOnceWithDigest(sender, receiver *Participant, msg []byte, label string, dig *TranscriptDigest) error {
+	return ExchangeOnceWithMetrics(sender, receiver, msg, label, dig, nil)
 }
 
-func (t *TranscriptDigest) AddWelcome(label string, welcome *mls.Welcome) error {
-	if welcome == nil {
-		return fmt.Errorf("nil welcome for label %s", label)
-	}
-	data, err := syntax.Marshal(*welcome)
+// ExchangeOnceWithMetrics is ExchangeOnceWithDigest reporting the protect and
+// unprotect to metrics.
+func ExchangeOnceWithMetrics(sender, receiver *Participant, msg []byte, label string, dig *TranscriptDigest, metrics Metrics) error {
+	ct, err := protectMeasured(sender, msg, metrics)
 	if err != nil {
-		return err
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
 	}
-	return t.AddBytes(label, data)
-}
 
-func (t *TranscriptDigest) AddCiphertext(label string, ct *mls.MLSCiphertext) error {
-	if ct == nil {
-		return fmt.Errorf("nil ciphertext for label %s", label)
+	if dig != nil {
+		if err := dig.AddCiphertext(label, ct); err != nil {
+			return fmt.Errorf("digest update failed: %w", err)
+		}
 	}
-	data, err := syntax.Marshal(*ct)
+
+	pt, err := unprotectMeasured(receiver, ct, metrics)
 	if err != nil {
-		return err
+		return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
 	}
-	return t.AddBytes(label, data)
-}
 
-func (t *TranscriptDigest) HexSum() string {
-	if t == nil {
-		return ""
+	if !bytes.Equal(pt, msg) {
+		return fmt.Errorf("%w for %s -> %s", ErrPlaintextMismatch, sender.Name, receiver.Name)
 	}
-	return hex.EncodeToString(t.h.Sum(nil))
+
+	return nil
 }
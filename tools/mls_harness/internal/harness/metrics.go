@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"time"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Metrics receives the timing and size of each MLS operation the harness runs
+// for a participant, so every consumer reads the same figures instead of
+// wrapping calls in its own timers. Durations cover the go-mls call alone.
+// Sizes are TLS-encoded bytes, zero when the operation failed. A nil Metrics
+// records nothing and costs nothing; functions that take one have a variant
+// without it that passes nil.
+type Metrics interface {
+	// OnProtect reports participant encrypting an application message.
+	OnProtect(participant string, elapsed time.Duration, ciphertextBytes int, err error)
+	// OnUnprotect reports participant decrypting one.
+	OnUnprotect(participant string, elapsed time.Duration, ciphertextBytes int, err error)
+	// OnCommit reports participant committing, with the size of the commit
+	// and of the Welcome, zero when it adds no one.
+	OnCommit(participant string, elapsed time.Duration, commitBytes, welcomeBytes int, err error)
+	// OnJoin reports participant joining through a Welcome.
+	OnJoin(participant string, elapsed time.Duration, welcomeBytes int, err error)
+}
+
+func protectMeasured(sender *Participant, msg []byte, metrics Metrics) (*mls.MLSCiphertext, error) {
+	if metrics == nil {
+		return sender.State.Protect(msg)
+	}
+	start := time.Now()
+	ct, err := sender.State.Protect(msg)
+	elapsed := time.Since(start)
+	size := 0
+	if err == nil {
+		size = encodedSize(*ct)
+	}
+	metrics.OnProtect(sender.Name, elapsed, size, err)
+	return ct, err
+}
+
+func unprotectMeasured(receiver *Participant, ct *mls.MLSCiphertext, metrics Metrics) ([]byte, error) {
+	if metrics == nil {
+		return receiver.State.Unprotect(ct)
+	}
+	start := time.Now()
+	pt, err := receiver.State.Unprotect(ct)
+	metrics.OnUnprotect(receiver.Name, time.Since(start), encodedSize(*ct), err)
+	return pt, err
+}
+
+func commitMeasured(committer *Participant, commitSecret []byte, metrics Metrics) (*mls.MLSPlaintext, *mls.Welcome, *mls.State, error) {
+	if metrics == nil {
+		return committer.State.Commit(commitSecret)
+	}
+	start := time.Now()
+	commit, welcome, next, err := committer.State.Commit(commitSecret)
+	elapsed := time.Since(start)
+	commitBytes, welcomeBytes := 0, 0
+	if err == nil {
+		commitBytes = encodedSize(commit)
+		if welcome != nil {
+			welcomeBytes = encodedSize(*welcome)
+		}
+	}
+	metrics.OnCommit(committer.Name, elapsed, commitBytes, welcomeBytes, err)
+	return commit, welcome, next, err
+}
+
+// encodedSize is the TLS-encoded length of v, or zero if it does not encode.
+func encodedSize(v interface{}) int {
+	data, err := syntax.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
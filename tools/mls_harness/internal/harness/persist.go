@@ -0,0 +1,206 @@
+//go:build !tinygo
+// +build !tinygo
+
+package harness
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// StatePath is where a participant's state snapshot lives under stateDir.
+func StatePath(stateDir, name string) string {
+	return filepath.Join(stateDir, name+".gob")
+}
+
+// ErrStateNotFound is returned by a StateStore holding no snapshot by a name.
+var ErrStateNotFound = errors.New("state not found")
+
+// StateStore holds encoded state snapshots by participant name, so the soak,
+// scripted scenarios and anything else persisting participants share one path
+// whether snapshots live in files, in memory or behind a remote service.
+type StateStore interface {
+	Put(name string, data []byte) error
+	Get(name string) ([]byte, error)
+}
+
+// FileStore keeps each snapshot at StatePath(Dir, name), the layout the soak's
+// checkpoints and the checkpoints command read.
+type FileStore struct {
+	Dir string
+}
+
+func (s FileStore) Put(name string, data []byte) error {
+	if err := os.WriteFile(StatePath(s.Dir, name), data, 0o600); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+func (s FileStore) Get(name string) ([]byte, error) {
+	data, err := os.ReadFile(StatePath(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrStateNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	return data, nil
+}
+
+// MemoryStore keeps snapshots in memory, for runs that only need state to
+// survive encoding and not the process.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Put(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.states[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *MemoryStore) Get(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.states[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", name, ErrStateNotFound)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Checkpoint stores the participant's state under its name.
+func (p *Participant) Checkpoint(store StateStore) error {
+	data, err := EncodeState(p.State)
+	if err != nil {
+		return err
+	}
+	return store.Put(p.Name, data)
+}
+
+// Restore replaces the participant's state with the snapshot stored under its
+// name.
+func (p *Participant) Restore(store StateStore) error {
+	data, err := store.Get(p.Name)
+	if err != nil {
+		return err
+	}
+	state, err := DecodeState(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	p.State = state
+	return nil
+}
+
+// EncodeState is the gob snapshot every StateStore holds.
+func EncodeState(state *mls.State) ([]byte, error) {
+	registerStateTypes(state)
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return nil, fmt.Errorf("encode: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeState reads a snapshot written by EncodeState.
+func DecodeState(r io.Reader) (*mls.State, error) {
+	var state mls.State
+	if err := gob.NewDecoder(r).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decode: %w", err)
+	}
+	return &state, nil
+}
+
+func SaveState(path string, state *mls.State) error {
+	data, err := EncodeState(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// LoadState decodes a gob snapshot written by SaveState. Paths ending in .gz are
+// decompressed first, so compressed soak checkpoints can be loaded directly when bisecting.
+func LoadState(path string) (*mls.State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read: %w", err)
+	}
+	var r io.Reader = bytes.NewReader(data)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("gunzip: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return DecodeState(r)
+}
+
+// PersistRoundTrip checkpoints every participant to store and replaces its state
+// with the restored copy, so later operations run on state that survived encoding.
+func PersistRoundTrip(store StateStore, participants ...*Participant) error {
+	for _, p := range participants {
+		if err := p.Checkpoint(store); err != nil {
+			return fmt.Errorf("%s persist: %w", p.Name, err)
+		}
+	}
+
+	for _, p := range participants {
+		if err := p.Restore(store); err != nil {
+			return fmt.Errorf("%s reload: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func registerStateTypes(state *mls.State) {
+	if state == nil {
+		return
+	}
+
+	registerValue(state.Keys)
+	registerValue(state.Keys.HandshakeBaseKeys)
+	registerValue(state.Keys.ApplicationBaseKeys)
+	registerValue(state.Keys.HandshakeRatchets)
+	registerValue(state.Keys.ApplicationRatchets)
+	registerValue(state.Keys.HandshakeKeys)
+	registerValue(state.Keys.ApplicationKeys)
+
+	for _, ratchet := range state.Keys.HandshakeRatchets {
+		registerValue(ratchet)
+	}
+	for _, ratchet := range state.Keys.ApplicationRatchets {
+		registerValue(ratchet)
+	}
+}
+
+func registerValue(v interface{}) {
+	if v == nil {
+		return
+	}
+	gob.Register(v)
+}
@@ -0,0 +1,207 @@
+package proptest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+
+	mls "github.com/cisco/go-mls"
+
+	"github.com/polycentric/fictional-octo-umbrella/tools/mls_harness/internal/harness"
+)
+
+// Config bounds the groups and operation sequences Generate produces. Zero
+// fields take the defaults below.
+type Config struct {
+	Suite      mls.CipherSuite
+	Members    int // members the group starts with, at least 2
+	MaxMembers int // largest the group may grow
+	Steps      int // operations after the bootstrap
+	MinPayload int // smallest message, in bytes
+	MaxPayload int // largest message, in bytes
+}
+
+const (
+	defaultMembers    = 2
+	defaultMaxMembers = 8
+	defaultSteps      = 20
+	defaultMinPayload = 1
+	defaultMaxPayload = 256
+)
+
+func (c Config) withDefaults() Config {
+	if c.Suite == 0 {
+		c.Suite = mls.X25519_AES128GCM_SHA256_Ed25519
+	}
+	if c.Members == 0 {
+		c.Members = defaultMembers
+	}
+	if c.MaxMembers == 0 {
+		c.MaxMembers = defaultMaxMembers
+	}
+	if c.Steps == 0 {
+		c.Steps = defaultSteps
+	}
+	if c.MinPayload == 0 {
+		c.MinPayload = defaultMinPayload
+	}
+	if c.MaxPayload == 0 {
+		c.MaxPayload = defaultMaxPayload
+	}
+	return c
+}
+
+func (c Config) validate() error {
+	if c.Members < 2 {
+		return fmt.Errorf("members must be at least 2 (got %d)", c.Members)
+	}
+	if c.MaxMembers < c.Members {
+		return fmt.Errorf("max members %d is below the starting %d", c.MaxMembers, c.Members)
+	}
+	if c.Steps < 0 {
+		return fmt.Errorf("steps must not be negative (got %d)", c.Steps)
+	}
+	if c.MinPayload < 1 || c.MaxPayload < c.MinPayload {
+		return fmt.Errorf("payload sizes must satisfy 1 <= min <= max (got %d, %d)", c.MinPayload, c.MaxPayload)
+	}
+	return nil
+}
+
+// PayloadSize returns a size in [minBytes, maxBytes], favouring the bounds,
+// where length handling tends to break.
+func PayloadSize(rng *rand.Rand, minBytes, maxBytes int) int {
+	switch rng.Intn(4) {
+	case 0:
+		return minBytes
+	case 1:
+		return maxBytes
+	default:
+		return minBytes + rng.Intn(maxBytes-minBytes+1)
+	}
+}
+
+// Payload returns random bytes of a PayloadSize length.
+func Payload(rng *rand.Rand, minBytes, maxBytes int) []byte {
+	return harness.RandomBytes(rng, PayloadSize(rng, minBytes, maxBytes))
+}
+
+// Operations returns n random steps that are valid, in order, for a group
+// that starts with members: adds of new members while the group is below
+// maxMembers, removes while it has more than two, updates committed by the
+// member or another one, and messages with payloads from Payload. Every
+// membership change is followed by an Invariants step.
+func Operations(rng *rand.Rand, members []string, n int, cfg Config) []harness.Step {
+	cfg = cfg.withDefaults()
+	current := append([]string(nil), members...)
+	next := len(members)
+	pick := func() string { return current[rng.Intn(len(current))] }
+
+	steps := make([]harness.Step, 0, 2*n)
+	for i := 0; i < n; i++ {
+		switch op := rng.Intn(4); {
+		case op == 0 && len(current) < cfg.MaxMembers:
+			name := fmt.Sprintf("member-%d", next)
+			next++
+			steps = append(steps, harness.AddMember{By: pick(), Name: name}, Invariants{})
+			current = append(current, name)
+		case op == 1 && len(current) > 2:
+			at := rng.Intn(len(current))
+			removed := current[at]
+			current = append(current[:at], current[at+1:]...)
+			steps = append(steps, harness.RemoveMember{By: pick(), Name: removed}, Invariants{})
+		case op == 2:
+			steps = append(steps, harness.Update{Member: pick(), By: pick()}, Invariants{})
+		default:
+			steps = append(steps, harness.SendMessage{From: pick(), Payload: string(Payload(rng, cfg.MinPayload, cfg.MaxPayload))})
+		}
+	}
+	return steps
+}
+
+// Generate returns a random scenario drawn from rng: a group of cfg.Members
+// members named member-0 onwards, then cfg.Steps Operations.
+func Generate(rng *rand.Rand, cfg Config) (*harness.Scenario, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	members := make([]string, cfg.Members)
+	for i := range members {
+		members[i] = fmt.Sprintf("member-%d", i)
+	}
+	steps := append([]harness.Step{Invariants{}}, Operations(rng, members, cfg.Steps, cfg)...)
+	return &harness.Scenario{Name: "property", Suite: cfg.Suite, Members: members, Steps: steps}, nil
+}
+
+// Check generates the scenario of seed and runs it, so a property test is a
+// loop over seeds. A failure names the seed, which reproduces it, and the
+// step that failed.
+func Check(seed int64, cfg Config) (*harness.Group, error) {
+	rng := rand.New(rand.NewSource(seed))
+	scenario, err := Generate(rng, cfg)
+	if err != nil {
+		return nil, err
+	}
+	group, err := scenario.Run(rng, nil, harness.ScenarioHooks{})
+	if err != nil {
+		return group, fmt.Errorf("seed %d: %w", seed, err)
+	}
+	return group, nil
+}
+
+// Invariants is a step that checks SharedAuthenticator over the current
+// members and RemovedCannotDecrypt with the first of them sending.
+type Invariants struct{}
+
+func (Invariants) String() string { return "check invariants" }
+
+func (Invariants) Apply(g *harness.Group) error {
+	if err := SharedAuthenticator(g.Members); err != nil {
+		return err
+	}
+	return RemovedCannotDecrypt(g.Members[0], g.Members, g.Removed)
+}
+
+// SharedAuthenticator requires members to be in one epoch and agree on its
+// epoch authenticator and init secret, which a divergent key schedule would
+// not. See harness.CompareEpochs.
+func SharedAuthenticator(members []*harness.Participant) error {
+	if len(members) == 0 {
+		return errors.New("no members")
+	}
+	for _, member := range members[1:] {
+		if diff := harness.CompareEpochs(members[0], member); diff != nil {
+			return errors.New(diff.String())
+		}
+	}
+	return nil
+}
+
+// RemovedCannotDecrypt has sender protect a probe message that every other
+// member must decrypt and no removed member may.
+func RemovedCannotDecrypt(sender *harness.Participant, members, removed []*harness.Participant) error {
+	probe := []byte(fmt.Sprintf("probe-%s-%d", sender.Name, sender.State.Epoch))
+	ct, err := sender.State.Protect(probe)
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
+	}
+	for _, member := range members {
+		if member == sender {
+			continue
+		}
+		pt, err := member.State.Unprotect(ct)
+		if err != nil {
+			return fmt.Errorf("unprotect failed for %s: %w", member.Name, err)
+		}
+		if !bytes.Equal(pt, probe) {
+			return fmt.Errorf("plaintext mismatch for %s -> %s", sender.Name, member.Name)
+		}
+	}
+	for _, member := range removed {
+		if _, err := member.State.Unprotect(ct); err == nil {
+			return fmt.Errorf("removed %s decrypted epoch %d traffic", member.Name, sender.State.Epoch)
+		}
+	}
+	return nil
+}
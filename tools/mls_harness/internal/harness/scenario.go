@@ -0,0 +1,446 @@
+package harness
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	mls "github.com/cisco/go-mls"
+)
+
+// MLS operations a scenario reports through ScenarioHooks.Operation.
+const (
+	OpAdd         = "add"
+	OpUpdate      = "update"
+	OpRemove      = "remove"
+	OpCommit      = "commit"
+	OpWelcomeJoin = "welcome_join"
+	OpAppMessage  = "app_message"
+)
+
+// Scenario is a script run against a group: the members BootstrapGroupWithDigest
+// starts it with, the first of them the creator, then Steps in order.
+type Scenario struct {
+	Name    string
+	Suite   mls.CipherSuite
+	Members []string
+	Steps   []Step
+	// DigestHex, if set, is the transcript digest the run must reproduce.
+	DigestHex string
+}
+
+// Step is one operation of a scenario.
+type Step interface {
+	Apply(g *Group) error
+	String() string
+}
+
+// ScenarioHooks let a driver watch a run. Every hook is optional.
+type ScenarioHooks struct {
+	// Operation is called for every MLS operation, with the epoch it ran in.
+	Operation func(op string, epoch mls.Epoch)
+	// Epoch is called with the members that moved into a new epoch.
+	Epoch func(members ...*Participant)
+	// Checkpoint is called by Checkpoint steps with the current members.
+	Checkpoint func(label string, members []*Participant) error
+}
+
+// Group is a scenario's group as it runs: the current members in join order
+// and those removed so far.
+type Group struct {
+	Members []*Participant
+	Removed []*Participant
+
+	rng   io.Reader
+	suite mls.CipherSuite
+	dig   *TranscriptDigest
+	hooks ScenarioHooks
+}
+
+// Run bootstraps the scenario's group and applies every step, feeding dig if
+// it is not nil. The group is returned as far as it got, also on error.
+func (s *Scenario) Run(rng io.Reader, dig *TranscriptDigest, hooks ScenarioHooks) (*Group, error) {
+	g := &Group{rng: rng, suite: s.Suite, dig: dig, hooks: hooks}
+	members, err := BootstrapGroupWithDigest(rng, s.Suite, s.Members, dig)
+	if err != nil {
+		return g, fmt.Errorf("bootstrap: %w", err)
+	}
+	g.Members = members
+	if len(members) > 1 {
+		for range members[1:] {
+			g.operation(OpAdd, 0)
+		}
+		g.operation(OpCommit, 0)
+		for range members[1:] {
+			g.operation(OpWelcomeJoin, members[0].State.Epoch)
+		}
+	}
+	g.epoch(members...)
+
+	for i, step := range s.Steps {
+		if err := step.Apply(g); err != nil {
+			return g, fmt.Errorf("step %d (%s): %w", i, step, err)
+		}
+	}
+	if s.DigestHex != "" && dig != nil && dig.HexSum() != s.DigestHex {
+		return g, fmt.Errorf("transcript digest %s does not match expected %s", dig.HexSum(), s.DigestHex)
+	}
+	return g, nil
+}
+
+// Member returns the current member called name.
+func (g *Group) Member(name string) (*Participant, error) {
+	for _, member := range g.Members {
+		if member.Name == name {
+			return member, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a member", name)
+}
+
+// Epoch is the epoch the group is in, that of its first member.
+func (g *Group) Epoch() mls.Epoch {
+	if len(g.Members) == 0 {
+		return 0
+	}
+	return g.Members[0].State.Epoch
+}
+
+// others returns the current members but skip.
+func (g *Group) others(skip *Participant) []*Participant {
+	out := make([]*Participant, 0, len(g.Members))
+	for _, member := range g.Members {
+		if member != skip {
+			out = append(out, member)
+		}
+	}
+	return out
+}
+
+func (g *Group) operation(op string, epoch mls.Epoch) {
+	if g.hooks.Operation != nil {
+		g.hooks.Operation(op, epoch)
+	}
+}
+
+func (g *Group) epoch(members ...*Participant) {
+	if g.hooks.Epoch != nil {
+		g.hooks.Epoch(members...)
+	}
+}
+
+// commit has every member handle proposals, committer commit them and the
+// rest apply the commit. It returns the Welcome, nil without Adds.
+func (g *Group) commit(committer *Participant, proposals ...*mls.MLSPlaintext) (*mls.Welcome, error) {
+	for _, proposal := range proposals {
+		if err := g.dig.AddMLSPlaintext("proposal", proposal); err != nil {
+			return nil, fmt.Errorf("digest proposal: %w", err)
+		}
+		if err := DeliverProposal(g.Members, proposal); err != nil {
+			return nil, err
+		}
+	}
+	commit, welcome, next, err := committer.State.Commit(RandomBytes(g.rng, 32))
+	if err != nil {
+		return nil, fmt.Errorf("%s commit: %w", committer.Name, err)
+	}
+	if err := g.dig.AddMLSPlaintext("commit", commit); err != nil {
+		return nil, fmt.Errorf("digest commit: %w", err)
+	}
+	g.operation(OpCommit, committer.State.Epoch)
+	committer.State = next
+	others := g.others(committer)
+	if err := DeliverCommit(others, commit); err != nil {
+		return nil, err
+	}
+	g.epoch(committer)
+	g.epoch(others...)
+	return welcome, nil
+}
+
+// AddMember has By add a new participant called Name in one commit, which
+// the new member joins through its Welcome.
+type AddMember struct {
+	By   string
+	Name string
+}
+
+func (s AddMember) String() string { return fmt.Sprintf("%s adds %s", s.By, s.Name) }
+
+func (s AddMember) Apply(g *Group) error {
+	adder, err := g.Member(s.By)
+	if err != nil {
+		return err
+	}
+	if _, err := g.Member(s.Name); err == nil {
+		return fmt.Errorf("%q is already a member", s.Name)
+	}
+	// Created as BootstrapGroupWithDigest creates members, so pinned scenario
+	// digests do not move.
+	joiner, err := newParticipant(g.rng, g.suite, s.Name, MakeKeyPackageDeterministic)
+	if err != nil {
+		return fmt.Errorf("%s init: %w", s.Name, err)
+	}
+	if err := g.dig.AddKeyPackage(joiner.Name+"-key-package", joiner.KeyPackage); err != nil {
+		return fmt.Errorf("digest %s key package: %w", joiner.Name, err)
+	}
+	add, err := adder.State.Add(joiner.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("add %s: %w", joiner.Name, err)
+	}
+	g.operation(OpAdd, adder.State.Epoch)
+	welcome, err := g.commit(adder, add)
+	if err != nil {
+		return err
+	}
+	if err := g.dig.AddWelcome("welcome", welcome); err != nil {
+		return fmt.Errorf("digest welcome: %w", err)
+	}
+	if err := JoinWelcome([]*Participant{joiner}, welcome); err != nil {
+		return err
+	}
+	g.operation(OpWelcomeJoin, joiner.State.Epoch)
+	g.Members = append(g.Members, joiner)
+	g.epoch(joiner)
+	return nil
+}
+
+// RemoveMember has By remove Name in one commit. The removed member keeps
+// its last state in Group.Removed.
+type RemoveMember struct {
+	By   string
+	Name string
+}
+
+func (s RemoveMember) String() string { return fmt.Sprintf("%s removes %s", s.By, s.Name) }
+
+func (s RemoveMember) Apply(g *Group) error {
+	remover, err := g.Member(s.By)
+	if err != nil {
+		return err
+	}
+	removed, err := g.Member(s.Name)
+	if err != nil {
+		return err
+	}
+	if removed == remover {
+		return fmt.Errorf("%s cannot remove itself", s.By)
+	}
+	remove, err := remover.State.Remove(removed.State.Index)
+	if err != nil {
+		return fmt.Errorf("remove %s: %w", removed.Name, err)
+	}
+	g.operation(OpRemove, remover.State.Epoch)
+	g.Members = g.others(removed)
+	g.Removed = append(g.Removed, removed)
+	_, err = g.commit(remover, remove)
+	return err
+}
+
+// Update has Member propose a fresh leaf key, committed by By, or by Member
+// itself when By is empty.
+type Update struct {
+	Member string
+	By     string
+}
+
+func (s Update) String() string {
+	if s.By == "" || s.By == s.Member {
+		return fmt.Sprintf("%s updates", s.Member)
+	}
+	return fmt.Sprintf("%s updates, %s commits", s.Member, s.By)
+}
+
+func (s Update) Apply(g *Group) error {
+	member, err := g.Member(s.Member)
+	if err != nil {
+		return err
+	}
+	committer := member
+	if s.By != "" {
+		if committer, err = g.Member(s.By); err != nil {
+			return err
+		}
+	}
+	fresh, err := NewDevice(g.rng, member, member.Name)
+	if err != nil {
+		return fmt.Errorf("fresh leaf: %w", err)
+	}
+	update, err := member.State.Update(fresh.InitSecret, nil, fresh.KeyPackage)
+	if err != nil {
+		return fmt.Errorf("%s update: %w", member.Name, err)
+	}
+	g.operation(OpUpdate, member.State.Epoch)
+	member.InitSecret, member.KeyPackage = fresh.InitSecret, fresh.KeyPackage
+	_, err = g.commit(committer, update)
+	return err
+}
+
+// SendMessage has From protect Payload, which every other member must
+// decrypt.
+type SendMessage struct {
+	From    string
+	Payload string
+}
+
+func (s SendMessage) String() string { return fmt.Sprintf("%s sends %q", s.From, s.Payload) }
+
+func (s SendMessage) Apply(g *Group) error {
+	sender, err := g.Member(s.From)
+	if err != nil {
+		return err
+	}
+	ct, err := sender.State.Protect([]byte(s.Payload))
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
+	}
+	if err := g.dig.AddCiphertext("message", ct); err != nil {
+		return fmt.Errorf("digest message: %w", err)
+	}
+	for _, receiver := range g.others(sender) {
+		pt, err := receiver.State.Unprotect(ct)
+		if err != nil {
+			return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
+		}
+		if string(pt) != s.Payload {
+			return fmt.Errorf("plaintext mismatch for %s -> %s", sender.Name, receiver.Name)
+		}
+	}
+	g.operation(OpAppMessage, sender.State.Epoch)
+	return nil
+}
+
+// Checkpoint hands the current members to ScenarioHooks.Checkpoint, which
+// may persist them or swap in reloaded state.
+type Checkpoint struct {
+	Label string
+}
+
+func (s Checkpoint) String() string { return fmt.Sprintf("checkpoint %s", s.Label) }
+
+func (s Checkpoint) Apply(g *Group) error {
+	if g.hooks.Checkpoint == nil {
+		return nil
+	}
+	return g.hooks.Checkpoint(s.Label, g.Members)
+}
+
+// AssertEpoch requires every member to be in Epoch and to agree on its
+// epoch authenticator.
+type AssertEpoch struct {
+	Epoch uint64
+}
+
+func (s AssertEpoch) String() string { return fmt.Sprintf("assert epoch %d", s.Epoch) }
+
+func (s AssertEpoch) Apply(g *Group) error {
+	var want []byte
+	for _, member := range g.Members {
+		if uint64(member.State.Epoch) != s.Epoch {
+			return fmt.Errorf("%s is in epoch %d", member.Name, member.State.Epoch)
+		}
+		authenticator := EpochAuthenticator(member.State)
+		if want == nil {
+			want = authenticator
+		} else if !bytes.Equal(authenticator, want) {
+			return fmt.Errorf("%s disagrees with %s on the epoch authenticator", member.Name, g.Members[0].Name)
+		}
+	}
+	return nil
+}
+
+// scenarioFile is the JSON form of a Scenario.
+type scenarioFile struct {
+	Name      string             `json:"name"`
+	Suite     string             `json:"cipher_suite"`
+	Members   []string           `json:"members"`
+	Steps     []scenarioFileStep `json:"steps"`
+	DigestHex string             `json:"digest_sha256_hex"`
+}
+
+// scenarioFileStep is one step: op names the kind, the other fields are the
+// ones that kind takes.
+type scenarioFileStep struct {
+	Op      string  `json:"op"`
+	By      string  `json:"by"`
+	Name    string  `json:"name"`
+	Member  string  `json:"member"`
+	From    string  `json:"from"`
+	Payload string  `json:"payload"`
+	Label   string  `json:"label"`
+	Epoch   *uint64 `json:"epoch"`
+}
+
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read scenario: %w", err)
+	}
+	return LoadScenarioFromJSON(data)
+}
+
+// LoadScenarioFromJSON decodes a scenario: {name, cipher_suite, members,
+// steps, digest_sha256_hex}, each step {op, ...} with op one of add_member
+// {by, name}, remove_member {by, name}, update {member, by}, send_message
+// {from, payload}, checkpoint {label} and assert_epoch {epoch}.
+func LoadScenarioFromJSON(data []byte) (*Scenario, error) {
+	var file scenarioFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("unmarshal scenario: %w", err)
+	}
+	if file.Name == "" {
+		return nil, errors.New("scenario name is required")
+	}
+	suite, ok := CipherSuiteByName(file.Suite)
+	if !ok {
+		return nil, fmt.Errorf("unsupported cipher_suite %q", file.Suite)
+	}
+	if len(file.Members) == 0 {
+		return nil, errors.New("members is required")
+	}
+
+	scenario := &Scenario{Name: file.Name, Suite: suite, Members: file.Members, DigestHex: file.DigestHex}
+	for i, entry := range file.Steps {
+		step, err := entry.step()
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i, err)
+		}
+		scenario.Steps = append(scenario.Steps, step)
+	}
+	return scenario, nil
+}
+
+func (e scenarioFileStep) step() (Step, error) {
+	var step Step
+	var required []string // field name, value pairs
+	switch e.Op {
+	case "add_member":
+		step, required = AddMember{By: e.By, Name: e.Name}, []string{"by", e.By, "name", e.Name}
+	case "remove_member":
+		step, required = RemoveMember{By: e.By, Name: e.Name}, []string{"by", e.By, "name", e.Name}
+	case "update":
+		step, required = Update{Member: e.Member, By: e.By}, []string{"member", e.Member}
+	case "send_message":
+		step, required = SendMessage{From: e.From, Payload: e.Payload}, []string{"from", e.From}
+	case "checkpoint":
+		step, required = Checkpoint{Label: e.Label}, []string{"label", e.Label}
+	case "assert_epoch":
+		if e.Epoch == nil {
+			return nil, errors.New("assert_epoch needs epoch")
+		}
+		return AssertEpoch{Epoch: *e.Epoch}, nil
+	default:
+		return nil, fmt.Errorf("unknown op %q", e.Op)
+	}
+	for i := 0; i < len(required); i += 2 {
+		if required[i+1] == "" {
+			return nil, fmt.Errorf("%s needs %s", e.Op, required[i])
+		}
+	}
+	return step, nil
+}
@@ -0,0 +1,299 @@
+package harness
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// A transcript is the sequence of artifacts a seeded scenario produced, in the
+// order it produced them. Each entry is a label, naming the step, and the
+// TLS-syntax encoding of the artifact, tagged with its type.
+//
+// The digest a vector or scenario pins is SHA-256 over every entry in order,
+// each as its label, the length of its data as a big-endian uint32, then the
+// data. The entry type and the transcript version are not hashed, so digests
+// pinned before the format had a name still verify.
+//
+// The encoded transcript is the ASCII magic MLST, a version byte (1), then a
+// TLS-syntax body:
+//
+//	struct {
+//	    uint8 type;
+//	    opaque label<0..255>;
+//	    opaque data<0..2^32-1>;
+//	} TranscriptEntry;
+//
+//	TranscriptEntry entries<0..2^32-1>;
+//
+// so another implementation can read the artifacts back and recompute the
+// digest from them.
+const (
+	transcriptMagic   = "MLST"
+	TranscriptVersion = 1
+)
+
+// TranscriptEntryType says what an entry's data encodes.
+type TranscriptEntryType uint8
+
+const (
+	TranscriptBytes        TranscriptEntryType = 1 // raw bytes, such as a vector header
+	TranscriptKeyPackage   TranscriptEntryType = 2 // mls.KeyPackage
+	TranscriptMLSPlaintext TranscriptEntryType = 3 // mls.MLSPlaintext, a proposal or commit
+	TranscriptWelcome      TranscriptEntryType = 4 // mls.Welcome
+	TranscriptCiphertext   TranscriptEntryType = 5 // mls.MLSCiphertext
+)
+
+func (t TranscriptEntryType) String() string {
+	switch t {
+	case TranscriptBytes:
+		return "bytes"
+	case TranscriptKeyPackage:
+		return "key_package"
+	case TranscriptMLSPlaintext:
+		return "mls_plaintext"
+	case TranscriptWelcome:
+		return "welcome"
+	case TranscriptCiphertext:
+		return "ciphertext"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(t))
+	}
+}
+
+type TranscriptEntry struct {
+	Type  TranscriptEntryType
+	Label []byte `tls:"head=1"`
+	Data  []byte `tls:"head=4"`
+}
+
+type Transcript struct {
+	Entries []TranscriptEntry
+}
+
+type transcriptBody struct {
+	Entries []TranscriptEntry `tls:"head=4"`
+}
+
+// Digest is the SHA-256 a vector or scenario pins for this transcript.
+func (t *Transcript) Digest() []byte {
+	h := sha256.New()
+	for _, entry := range t.Entries {
+		writeTranscriptEntry(h, entry.Label, entry.Data)
+	}
+	return h.Sum(nil)
+}
+
+func (t *Transcript) HexDigest() string {
+	return hex.EncodeToString(t.Digest())
+}
+
+// RunningDigests returns, for each entry, the hex digest of the transcript up
+// to and including it. The last is HexDigest.
+func (t *Transcript) RunningDigests() []string {
+	h := sha256.New()
+	running := make([]string, 0, len(t.Entries))
+	for _, entry := range t.Entries {
+		writeTranscriptEntry(h, entry.Label, entry.Data)
+		running = append(running, hex.EncodeToString(h.Sum(nil)))
+	}
+	return running
+}
+
+// FirstDivergence returns the index of the first entry at which two lists of
+// running digests differ, or -1 if they are the same. A running digest covers
+// every entry before it, so the lists agree up to some index and differ from
+// there on, and a binary search finds it. When one list is a prefix of the
+// other, the divergence is the first entry the shorter one lacks.
+func FirstDivergence(want, got []string) int {
+	n := len(want)
+	if len(got) < n {
+		n = len(got)
+	}
+	i := sort.Search(n, func(i int) bool { return !strings.EqualFold(want[i], got[i]) })
+	if i == n && len(want) == len(got) {
+		return -1
+	}
+	return i
+}
+
+// TranscriptDivergence is where a computed transcript first parts from the
+// expected one: the entry's index and, if the computed transcript has one
+// there, its label.
+type TranscriptDivergence struct {
+	Index int
+	Label string
+	// Entries and ExpectedEntries are the lengths of the two transcripts.
+	Entries         int
+	ExpectedEntries int
+}
+
+func (d *TranscriptDivergence) String() string {
+	if d.Index >= d.Entries {
+		return fmt.Sprintf("computed transcript ends after %d entries, expected %d", d.Entries, d.ExpectedEntries)
+	}
+	if d.Index >= d.ExpectedEntries {
+		return fmt.Sprintf("computed transcript continues past the expected %d entries with entry %d (%s)", d.ExpectedEntries, d.Index, d.Label)
+	}
+	return fmt.Sprintf("first divergence at entry %d (%s)", d.Index, d.Label)
+}
+
+// DiagnoseDivergence compares the running digests of got with want, the
+// running digests of the expected transcript, and returns where they first
+// differ, or nil if they do not.
+func DiagnoseDivergence(want []string, got *Transcript) *TranscriptDivergence {
+	index := FirstDivergence(want, got.RunningDigests())
+	if index < 0 {
+		return nil
+	}
+	d := &TranscriptDivergence{Index: index, Entries: len(got.Entries), ExpectedEntries: len(want)}
+	if index < len(got.Entries) {
+		d.Label = string(got.Entries[index].Label)
+	}
+	return d
+}
+
+// Marshal encodes the whole transcript in the format described above.
+func (t *Transcript) Marshal() ([]byte, error) {
+	body, err := syntax.Marshal(transcriptBody{Entries: t.Entries})
+	if err != nil {
+		return nil, fmt.Errorf("marshal transcript: %w", err)
+	}
+	out := make([]byte, 0, len(transcriptMagic)+1+len(body))
+	out = append(out, transcriptMagic...)
+	out = append(out, TranscriptVersion)
+	return append(out, body...), nil
+}
+
+// UnmarshalTranscript decodes what Marshal wrote, rejecting unknown versions
+// and entry types.
+func UnmarshalTranscript(data []byte) (*Transcript, error) {
+	header := len(transcriptMagic) + 1
+	if len(data) < header || !bytes.Equal(data[:len(transcriptMagic)], []byte(transcriptMagic)) {
+		return nil, errors.New("not a transcript")
+	}
+	if version := data[len(transcriptMagic)]; version != TranscriptVersion {
+		return nil, fmt.Errorf("unsupported transcript version %d", version)
+	}
+	var body transcriptBody
+	read, err := syntax.Unmarshal(data[header:], &body)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal transcript: %w", err)
+	}
+	if header+read != len(data) {
+		return nil, errors.New("trailing bytes after transcript")
+	}
+	for i, entry := range body.Entries {
+		if entry.Type < TranscriptBytes || entry.Type > TranscriptCiphertext {
+			return nil, fmt.Errorf("entry %d: unknown type %d", i, uint8(entry.Type))
+		}
+	}
+	return &Transcript{Entries: body.Entries}, nil
+}
+
+func writeTranscriptEntry(h hash.Hash, label, data []byte) {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	h.Write(label)
+	h.Write(lenBuf[:])
+	h.Write(data)
+}
+
+// TranscriptDigest builds a transcript as a scenario runs. A nil digest
+// records nothing, so callers that do not need one pass nil.
+type TranscriptDigest struct {
+	h       hash.Hash
+	entries []TranscriptEntry
+}
+
+func NewTranscriptDigest() *TranscriptDigest {
+	return &TranscriptDigest{h: sha256.New()}
+}
+
+func (t *TranscriptDigest) AddBytes(label string, data []byte) error {
+	return t.add(TranscriptBytes, label, data)
+}
+
+func (t *TranscriptDigest) add(typ TranscriptEntryType, label string, data []byte) error {
+	if t == nil {
+		return nil
+	}
+	if len(label) > 255 {
+		return fmt.Errorf("transcript label %q is longer than 255 bytes", label)
+	}
+	writeTranscriptEntry(t.h, []byte(label), data)
+	t.entries = append(t.entries, TranscriptEntry{
+		Type:  typ,
+		Label: []byte(label),
+		Data:  append([]byte(nil), data...),
+	})
+	return nil
+}
+
+func (t *TranscriptDigest) AddKeyPackage(label string, kp mls.KeyPackage) error {
+	if t == nil {
+		return nil
+	}
+	data, err := syntax.Marshal(kp)
+	if err != nil {
+		return err
+	}
+	return t.add(TranscriptKeyPackage, label, data)
+}
+
+func (t *TranscriptDigest) AddMLSPlaintext(label string, pt *mls.MLSPlaintext) error {
+	if pt == nil {
+		return fmt.Errorf("nil MLSPlaintext for label %s", label)
+	}
+	data, err := syntax.Marshal(pt)
+	if err != nil {
+		return err
+	}
+	return t.add(TranscriptMLSPlaintext, label, data)
+}
+
+func (t *TranscriptDigest) AddWelcome(label string, welcome *mls.Welcome) error {
+	if welcome == nil {
+		return fmt.Errorf("nil welcome for label %s", label)
+	}
+	data, err := syntax.Marshal(*welcome)
+	if err != nil {
+		return err
+	}
+	return t.add(TranscriptWelcome, label, data)
+}
+
+func (t *TranscriptDigest) AddCiphertext(label string, ct *mls.MLSCiphertext) error {
+	if ct == nil {
+		return fmt.Errorf("nil ciphertext for label %s", label)
+	}
+	data, err := syntax.Marshal(*ct)
+	if err != nil {
+		return err
+	}
+	return t.add(TranscriptCiphertext, label, data)
+}
+
+func (t *TranscriptDigest) HexSum() string {
+	if t == nil {
+		return ""
+	}
+	return hex.EncodeToString(t.h.Sum(nil))
+}
+
+// Transcript returns the entries recorded so far, whose Digest is HexSum.
+func (t *TranscriptDigest) Transcript() *Transcript {
+	if t == nil {
+		return nil
+	}
+	return &Transcript{Entries: append([]TranscriptEntry(nil), t.entries...)}
+}
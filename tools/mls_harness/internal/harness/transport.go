@@ -0,0 +1,398 @@
+//go:build !tinygo
+// +build !tinygo
+
+package harness
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mls "github.com/cisco/go-mls"
+	syntax "github.com/cisco/go-tls-syntax"
+)
+
+// Transport carries serialized MLS messages between named participants. Exchange
+// loops send every ciphertext through a Transport so the same scenario can run
+// in memory, across a spool directory or over a network connection.
+type Transport interface {
+	Send(to string, data []byte) error
+	Receive(name string) ([]byte, error)
+	Close() error
+}
+
+// ErrNoMessage is returned by Receive when nothing is queued for the participant.
+var ErrNoMessage = errors.New("no message queued")
+
+// maxTransportMessage bounds a single framed message so a corrupt length prefix
+// cannot trigger a huge allocation.
+const maxTransportMessage = 16 << 20
+
+// ErrTransport wraps the errors of carrying a ciphertext in ExchangeVia, to
+// tell them from MLS failures.
+var ErrTransport = errors.New("transport")
+
+// ExchangeVia is ExchangeOnce with the ciphertext serialized and carried by t.
+func ExchangeVia(t Transport, sender, receiver *Participant, msg []byte) error {
+	return ExchangeViaWithMetrics(t, sender, receiver, msg, nil)
+}
+
+// ExchangeViaWithMetrics is ExchangeVia reporting the protect and unprotect
+// to metrics.
+func ExchangeViaWithMetrics(t Transport, sender, receiver *Participant, msg []byte, metrics Metrics) error {
+	ct, err := protectMeasured(sender, msg, metrics)
+	if err != nil {
+		return fmt.Errorf("protect failed for %s: %w", sender.Name, err)
+	}
+	if err := SendCiphertext(t, receiver.Name, ct); err != nil {
+		return fmt.Errorf("%w: %w", ErrTransport, err)
+	}
+	received, err := ReceiveCiphertext(t, receiver.Name)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTransport, err)
+	}
+
+	pt, err := unprotectMeasured(receiver, received, metrics)
+	if err != nil {
+		return fmt.Errorf("unprotect failed for %s: %w", receiver.Name, err)
+	}
+	if !bytes.Equal(pt, msg) {
+		return fmt.Errorf("%w for %s -> %s", ErrPlaintextMismatch, sender.Name, receiver.Name)
+	}
+	return nil
+}
+
+func SendCiphertext(t Transport, to string, ct *mls.MLSCiphertext) error {
+	data, err := syntax.Marshal(*ct)
+	if err != nil {
+		return fmt.Errorf("encode ciphertext for %s: %w", to, err)
+	}
+	if err := t.Send(to, data); err != nil {
+		return fmt.Errorf("send to %s: %w", to, err)
+	}
+	return nil
+}
+
+func ReceiveCiphertext(t Transport, name string) (*mls.MLSCiphertext, error) {
+	data, err := t.Receive(name)
+	if err != nil {
+		return nil, fmt.Errorf("receive for %s: %w", name, err)
+	}
+	var ct mls.MLSCiphertext
+	if _, err := syntax.Unmarshal(data, &ct); err != nil {
+		return nil, fmt.Errorf("decode ciphertext for %s: %w", name, err)
+	}
+	return &ct, nil
+}
+
+// NewTransport builds a transport by kind: memory, file, tcp or websocket. dir is
+// the spool directory for the file transport; the network transports listen on
+// an ephemeral loopback port.
+func NewTransport(kind, dir string) (Transport, error) {
+	switch kind {
+	case "", "memory":
+		return NewMemoryTransport(), nil
+	case "file":
+		if dir == "" {
+			return nil, errors.New("file transport requires a directory")
+		}
+		return NewFileTransport(dir)
+	case "tcp":
+		return NewLoopbackTCPTransport()
+	case "websocket":
+		return NewLoopbackWebSocketTransport()
+	default:
+		return nil, fmt.Errorf("unknown transport %q (want memory, file, tcp or websocket)", kind)
+	}
+}
+
+// MemoryTransport queues messages per recipient in process memory.
+type MemoryTransport struct {
+	mu     sync.Mutex
+	queues map[string][][]byte
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{queues: map[string][][]byte{}}
+}
+
+func (m *MemoryTransport) Send(to string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[to] = append(m.queues[to], append([]byte(nil), data...))
+	return nil
+}
+
+func (m *MemoryTransport) Receive(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queue := m.queues[name]
+	if len(queue) == 0 {
+		return nil, ErrNoMessage
+	}
+	m.queues[name] = queue[1:]
+	return queue[0], nil
+}
+
+func (m *MemoryTransport) Close() error { return nil }
+
+// FileTransport spools each message as a file under dir/<recipient>/. Files are
+// written under a temporary name and renamed into place, so a reader in another
+// process never sees a partial message. Receive waits up to PollTimeout for a
+// message to appear.
+type FileTransport struct {
+	dir         string
+	seq         uint64
+	PollTimeout time.Duration
+}
+
+func NewFileTransport(dir string) (*FileTransport, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create transport dir: %w", err)
+	}
+	return &FileTransport{dir: dir}, nil
+}
+
+func (f *FileTransport) Send(to string, data []byte) error {
+	inbox, err := f.inbox(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(inbox, 0o700); err != nil {
+		return fmt.Errorf("create inbox: %w", err)
+	}
+	f.seq++
+	name := fmt.Sprintf("%020d-%08d.msg", time.Now().UnixNano(), f.seq)
+	tmp := filepath.Join(inbox, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write message: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(inbox, name)); err != nil {
+		return fmt.Errorf("publish message: %w", err)
+	}
+	return nil
+}
+
+func (f *FileTransport) Receive(name string) ([]byte, error) {
+	inbox, err := f.inbox(name)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(f.PollTimeout)
+	for {
+		path, err := oldestMessage(inbox)
+		if err != nil {
+			return nil, err
+		}
+		if path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("read message: %w", err)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, fmt.Errorf("consume message: %w", err)
+			}
+			return data, nil
+		}
+		if !time.Now().Before(deadline) {
+			return nil, ErrNoMessage
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (f *FileTransport) Close() error { return nil }
+
+func (f *FileTransport) inbox(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid participant name %q for file transport", name)
+	}
+	return filepath.Join(f.dir, name), nil
+}
+
+func oldestMessage(inbox string) (string, error) {
+	entries, err := os.ReadDir(inbox)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read inbox: %w", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".msg") && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", nil
+	}
+	sort.Strings(names)
+	return filepath.Join(inbox, names[0]), nil
+}
+
+// frameConn moves whole frames over a connection.
+type frameConn interface {
+	WriteFrame(data []byte) error
+	ReadFrame() ([]byte, error)
+	Close() error
+}
+
+// streamTransport addresses frames to participants over a frameConn. Frames read
+// for a participant other than the one being received for are queued for later.
+// Sends go out on out and receives read from in; for a connection between two
+// processes both are the same connection.
+type streamTransport struct {
+	out     frameConn
+	in      frameConn
+	pending map[string][][]byte
+	closers []io.Closer
+}
+
+func newStreamTransport(out, in frameConn, closers ...io.Closer) *streamTransport {
+	return &streamTransport{out: out, in: in, pending: map[string][][]byte{}, closers: closers}
+}
+
+// encodeEnvelope prefixes data with the recipient name: u16 name length, name, data.
+func encodeEnvelope(to string, data []byte) ([]byte, error) {
+	if len(to) > 0xffff {
+		return nil, fmt.Errorf("participant name too long (%d bytes)", len(to))
+	}
+	buf := make([]byte, 2+len(to)+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(to)))
+	copy(buf[2:], to)
+	copy(buf[2+len(to):], data)
+	return buf, nil
+}
+
+func decodeEnvelope(frame []byte) (string, []byte, error) {
+	if len(frame) < 2 {
+		return "", nil, errors.New("short frame")
+	}
+	n := int(binary.BigEndian.Uint16(frame))
+	if len(frame) < 2+n {
+		return "", nil, errors.New("truncated recipient")
+	}
+	return string(frame[2 : 2+n]), frame[2+n:], nil
+}
+
+func (s *streamTransport) Send(to string, data []byte) error {
+	frame, err := encodeEnvelope(to, data)
+	if err != nil {
+		return err
+	}
+	return s.out.WriteFrame(frame)
+}
+
+func (s *streamTransport) Receive(name string) ([]byte, error) {
+	if queue := s.pending[name]; len(queue) > 0 {
+		s.pending[name] = queue[1:]
+		return queue[0], nil
+	}
+	for {
+		frame, err := s.in.ReadFrame()
+		if err != nil {
+			return nil, err
+		}
+		to, data, err := decodeEnvelope(frame)
+		if err != nil {
+			return nil, err
+		}
+		if to == name {
+			return data, nil
+		}
+		s.pending[to] = append(s.pending[to], data)
+	}
+}
+
+func (s *streamTransport) Close() error {
+	var first error
+	for _, c := range append([]io.Closer{s.out, s.in}, s.closers...) {
+		if err := c.Close(); err != nil && first == nil && !errors.Is(err, net.ErrClosed) {
+			first = err
+		}
+	}
+	return first
+}
+
+// tcpFrameConn frames messages with a u32 big-endian length prefix.
+type tcpFrameConn struct {
+	conn net.Conn
+}
+
+func (c *tcpFrameConn) WriteFrame(data []byte) error {
+	if len(data) > maxTransportMessage {
+		return fmt.Errorf("frame of %d bytes exceeds limit", len(data))
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+func (c *tcpFrameConn) ReadFrame() ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(c.conn, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(lenBuf[:])
+	if n > maxTransportMessage {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.conn, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (c *tcpFrameConn) Close() error { return c.conn.Close() }
+
+// NewTCPTransport carries frames over an established connection to a peer process.
+func NewTCPTransport(conn net.Conn) Transport {
+	fc := &tcpFrameConn{conn: conn}
+	return newStreamTransport(fc, fc)
+}
+
+// NewLoopbackTCPTransport connects to itself over 127.0.0.1 so single-process
+// scenarios exercise real socket framing.
+func NewLoopbackTCPTransport() (Transport, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	acceptErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			acceptErr <- err
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	select {
+	case server := <-accepted:
+		return newStreamTransport(&tcpFrameConn{conn: client}, &tcpFrameConn{conn: server}), nil
+	case err := <-acceptErr:
+		client.Close()
+		return nil, fmt.Errorf("accept: %w", err)
+	}
+}
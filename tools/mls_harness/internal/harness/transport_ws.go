@@ -0,0 +1,301 @@
+//go:build !tinygo
+// +build !tinygo
+
+package harness
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is the fixed value from RFC 6455 section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const (
+	wsOpContinuation = 0x0
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// WebSocketConn is a minimal RFC 6455 connection carrying binary messages, enough for
+// the harness to talk to itself or to a relay without a third-party dependency.
+// Masking keys and handshake nonces come from a private math/rand source rather
+// than crypto/rand, which scenarios replace with their deterministic RNG.
+type WebSocketConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, client bool) *WebSocketConn {
+	return &WebSocketConn{conn: conn, br: br, client: client, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// DialWebSocket opens a client connection to a ws:// URL.
+func DialWebSocket(url string) (*WebSocketConn, error) {
+	hostPath := strings.TrimPrefix(url, "ws://")
+	if hostPath == url {
+		return nil, fmt.Errorf("unsupported websocket URL %q (want ws://)", url)
+	}
+	host, path := hostPath, "/"
+	if i := strings.IndexByte(hostPath, '/'); i >= 0 {
+		host, path = hostPath[:i], hostPath[i:]
+	}
+
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", host, err)
+	}
+	ws := newWebSocketConn(conn, bufio.NewReader(conn), true)
+
+	nonce := make([]byte, 16)
+	ws.rng.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", path, host, key)
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+
+	resp, err := http.ReadResponse(ws.br, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read handshake: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("handshake rejected: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		conn.Close()
+		return nil, errors.New("handshake returned a bad Sec-WebSocket-Accept")
+	}
+	return ws, nil
+}
+
+// UpgradeWebSocket completes the server side of the handshake on an HTTP request.
+func UpgradeWebSocket(w http.ResponseWriter, r *http.Request) (*WebSocketConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "websocket upgrade required", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return nil, errors.New("response writer cannot hijack")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("hijack: %w", err)
+	}
+	resp := fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", websocketAccept(key))
+	if _, err := io.WriteString(conn, resp); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("write handshake: %w", err)
+	}
+	return newWebSocketConn(conn, rw.Reader, false), nil
+}
+
+func (c *WebSocketConn) writeRaw(opcode byte, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch {
+	case len(data) < 126:
+		header = append(header, maskBit|byte(len(data)))
+	case len(data) <= 0xffff:
+		header = append(header, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	default:
+		header = append(header, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(data)))
+	}
+
+	payload := data
+	if c.client {
+		var mask [4]byte
+		c.rng.Read(mask[:])
+		header = append(header, mask[:]...)
+		payload = make([]byte, len(data))
+		for i := range data {
+			payload[i] = data[i] ^ mask[i%4]
+		}
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *WebSocketConn) WriteFrame(data []byte) error {
+	if len(data) > maxTransportMessage {
+		return fmt.Errorf("frame of %d bytes exceeds limit", len(data))
+	}
+	return c.writeRaw(wsOpBinary, data)
+}
+
+// ReadFrame returns the next complete binary message, answering pings and
+// reassembling fragments. A close frame ends the stream with io.EOF.
+func (c *WebSocketConn) ReadFrame() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readRaw()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeRaw(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			_ = c.writeRaw(wsOpClose, nil)
+			return nil, io.EOF
+		case wsOpBinary, wsOpContinuation:
+		default:
+			return nil, fmt.Errorf("unsupported websocket opcode %#x", opcode)
+		}
+		message = append(message, payload...)
+		if len(message) > maxTransportMessage {
+			return nil, fmt.Errorf("message of %d bytes exceeds limit", len(message))
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+func (c *WebSocketConn) readRaw() (bool, byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxTransportMessage {
+		return false, 0, nil, fmt.Errorf("frame of %d bytes exceeds limit", length)
+	}
+	if masked == c.client {
+		return false, 0, nil, errors.New("websocket frame masking does not match peer role")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *WebSocketConn) Close() error { return c.conn.Close() }
+
+// SetDeadline bounds every later read and write on the underlying connection.
+func (c *WebSocketConn) SetDeadline(t time.Time) error { return c.conn.SetDeadline(t) }
+
+// NewWebSocketTransport carries frames over an established WebSocket connection.
+func NewWebSocketTransport(conn *WebSocketConn) Transport {
+	return newStreamTransport(conn, conn)
+}
+
+// NewLoopbackWebSocketTransport serves a WebSocket endpoint on 127.0.0.1 and
+// connects to it, so single-process scenarios exercise the real handshake and framing.
+func NewLoopbackWebSocketTransport() (Transport, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	upgraded := make(chan *WebSocketConn, 1)
+	upgradeErr := make(chan error, 1)
+	server := &http.Server{
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := UpgradeWebSocket(w, r)
+			if err != nil {
+				upgradeErr <- err
+				return
+			}
+			upgraded <- conn
+		}),
+	}
+	go func() { _ = server.Serve(listener) }()
+
+	client, err := DialWebSocket("ws://" + listener.Addr().String() + "/")
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+	select {
+	case conn := <-upgraded:
+		return newStreamTransport(client, conn, serverCloser{server}), nil
+	case err := <-upgradeErr:
+		client.Close()
+		server.Close()
+		return nil, fmt.Errorf("upgrade: %w", err)
+	}
+}
+
+type serverCloser struct{ server *http.Server }
+
+func (s serverCloser) Close() error { return s.server.Close() }
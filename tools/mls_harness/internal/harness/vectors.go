@@ -1,26 +1,74 @@
 package harness
 
 import (
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
 	"strings"
 
 	mls "github.com/cisco/go-mls"
 )
 
+// Vector file format versions. Each version fixes how its digest is computed,
+// so a file verifies the same way for as long as its version is supported.
+//
+// Version 1, a file with no format_version, is the two-party scenario in
+// X25519_AES128GCM_SHA256_Ed25519: alice and bob bootstrap, then each round
+// alice sends "msg-<round>" to bob and bob the same back.
+//
+// Version 2 names any go-mls cipher suite, a participant count and a payload
+// generator. The digest starts with the format version and the suite, then
+// covers the bootstrap of member-0 to member-<participants-1>, after which
+// every round each member in turn sends one payload to all the others.
+const (
+	VectorFormatV1 = 1
+	VectorFormatV2 = 2
+)
+
+// Limits of a version 2 vector, which keep a pinned scenario quick to verify.
+const (
+	maxVectorParticipants = 64
+	maxVectorPayloadBytes = 64 * 1024
+)
+
 type VectorSpec struct {
-	Name       string `json:"name"`
-	Suite      string `json:"cipher_suite"`
-	Iterations int    `json:"iterations"`
-	DigestHex  string `json:"digest_sha256_hex"`
+	FormatVersion int            `json:"format_version,omitempty"`
+	Name          string         `json:"name"`
+	Suite         string         `json:"cipher_suite"`
+	Iterations    int            `json:"iterations"`
+	Participants  int            `json:"participants,omitempty"`
+	Payload       *VectorPayload `json:"payload,omitempty"`
+	DigestHex     string         `json:"digest_sha256_hex"`
+	// EntryDigests, if present, are the running digests of the transcript
+	// after each entry, so a mismatch reports the first entry that differs.
+	EntryDigests []string `json:"entry_digests_sha256_hex,omitempty"`
+}
+
+// VectorPayload is how a version 2 vector makes the messages it sends.
+// "counter", the default, sends "msg-<round>-<sender>". "random" sends
+// between MinBytes and MaxBytes bytes drawn from Seed, a stream of its own, so
+// the payload settings never move the secrets the scenario draws.
+type VectorPayload struct {
+	Kind     string `json:"kind"`
+	MinBytes int    `json:"min_bytes,omitempty"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+	Seed     int64  `json:"seed,omitempty"`
 }
 
+// VerifyResult is the outcome of a vector check. Transcript holds the
+// artifacts the digest was computed over, as far as the scenario got.
 type VerifyResult struct {
 	Digest         string
 	ExpectedDigest string
 	OK             bool
+	Transcript     *Transcript
+	// Divergence is where a mismatched transcript first differs, when the
+	// vector records its entry digests.
+	Divergence *TranscriptDivergence
 }
 
 func LoadVectorSpec(path string) (*VectorSpec, error) {
@@ -38,22 +86,76 @@ func LoadVectorSpecFromJSON(data []byte) (*VectorSpec, error) {
 		return nil, fmt.Errorf("unmarshal vector file: %w", err)
 	}
 
-	if spec.Name == "" {
-		return nil, errors.New("vector name is required")
-	}
-	if spec.Suite != mls.X25519_AES128GCM_SHA256_Ed25519.String() {
-		return nil, fmt.Errorf("unsupported cipher_suite %q", spec.Suite)
-	}
-	if spec.Iterations <= 0 {
-		return nil, fmt.Errorf("iterations must be positive (got %d)", spec.Iterations)
+	if err := spec.validate(); err != nil {
+		return nil, err
 	}
 	if spec.DigestHex == "" {
 		return nil, errors.New("digest_sha256_hex is required")
 	}
+	if n := len(spec.EntryDigests); n > 0 && !strings.EqualFold(spec.EntryDigests[n-1], spec.DigestHex) {
+		return nil, errors.New("entry_digests_sha256_hex must end with digest_sha256_hex")
+	}
 
 	return &spec, nil
 }
 
+// validate checks everything but the digest, which a spec being generated
+// does not have yet.
+func (s *VectorSpec) validate() error {
+	if s.Name == "" {
+		return errors.New("vector name is required")
+	}
+	if s.Iterations <= 0 {
+		return fmt.Errorf("iterations must be positive (got %d)", s.Iterations)
+	}
+
+	switch s.version() {
+	case VectorFormatV1:
+		if s.Suite != mls.X25519_AES128GCM_SHA256_Ed25519.String() {
+			return fmt.Errorf("unsupported cipher_suite %q", s.Suite)
+		}
+		if s.Participants != 0 || s.Payload != nil {
+			return errors.New("participants and payload need format_version 2")
+		}
+		return nil
+	case VectorFormatV2:
+	default:
+		return fmt.Errorf("unsupported format_version %d", s.FormatVersion)
+	}
+
+	suite, ok := CipherSuiteByName(s.Suite)
+	if !ok || !cipherSuiteSupported(suite) {
+		return fmt.Errorf("unsupported cipher_suite %q", s.Suite)
+	}
+	if s.Participants < 2 || s.Participants > maxVectorParticipants {
+		return fmt.Errorf("participants must be between 2 and %d (got %d)", maxVectorParticipants, s.Participants)
+	}
+	if s.Payload == nil {
+		return nil
+	}
+	switch s.Payload.Kind {
+	case "", "counter":
+		if s.Payload.MinBytes != 0 || s.Payload.MaxBytes != 0 || s.Payload.Seed != 0 {
+			return errors.New("a counter payload takes no sizes or seed")
+		}
+	case "random":
+		if s.Payload.MinBytes < 1 || s.Payload.MaxBytes < s.Payload.MinBytes || s.Payload.MaxBytes > maxVectorPayloadBytes {
+			return fmt.Errorf("random payload sizes must satisfy 1 <= min_bytes <= max_bytes <= %d", maxVectorPayloadBytes)
+		}
+	default:
+		return fmt.Errorf("unsupported payload kind %q", s.Payload.Kind)
+	}
+	return nil
+}
+
+// version is the spec's format version, 1 when the file names none.
+func (s *VectorSpec) version() int {
+	if s.FormatVersion == 0 {
+		return VectorFormatV1
+	}
+	return s.FormatVersion
+}
+
 func VerifyVectorFile(vectorPath string) (*VerifyResult, error) {
 	spec, err := LoadVectorSpec(vectorPath)
 	if err != nil {
@@ -77,35 +179,191 @@ func VerifyVectorSpec(spec *VectorSpec) (*VerifyResult, error) {
 		return nil, errors.New("vector spec is required")
 	}
 
+	expected := strings.ToLower(spec.DigestHex)
+	dig, err := runVectorSpec(spec)
+	result := &VerifyResult{Digest: dig.HexSum(), ExpectedDigest: expected, Transcript: dig.Transcript()}
+	if err != nil {
+		return result, err
+	}
+	if result.Digest != expected {
+		if len(spec.EntryDigests) > 0 && result.Transcript != nil {
+			result.Divergence = DiagnoseDivergence(spec.EntryDigests, result.Transcript)
+			return result, fmt.Errorf("digest mismatch: computed %s expected %s: %s", result.Digest, expected, result.Divergence)
+		}
+		return result, fmt.Errorf("digest mismatch: computed %s expected %s", result.Digest, expected)
+	}
+
+	result.OK = true
+	return result, nil
+}
+
+// GenerateVectorSpec runs the vector scenario for iterations rounds and returns
+// a spec named name that records its digest, ready to write out as a vector
+// file for VerifyVectorSpec to check later.
+func GenerateVectorSpec(name string, iterations int) (*VectorSpec, error) {
+	return GenerateVector(&VectorSpec{
+		Name:       name,
+		Suite:      mls.X25519_AES128GCM_SHA256_Ed25519.String(),
+		Iterations: iterations,
+	})
+}
+
+// GenerateVector runs the scenario template describes, of any format version,
+// and returns a copy that records its digest.
+func GenerateVector(template *VectorSpec) (*VectorSpec, error) {
+	return generateVector(template, false)
+}
+
+// GenerateVectorWithEntryDigests is GenerateVector, also recording the
+// running digest after every transcript entry.
+func GenerateVectorWithEntryDigests(template *VectorSpec) (*VectorSpec, error) {
+	return generateVector(template, true)
+}
+
+func generateVector(template *VectorSpec, entryDigests bool) (*VectorSpec, error) {
+	if template == nil {
+		return nil, errors.New("vector spec is required")
+	}
+	spec := *template
+	if spec.Payload != nil {
+		payload := *spec.Payload
+		spec.Payload = &payload
+	}
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	dig, err := runVectorSpec(&spec)
+	if err != nil {
+		return nil, err
+	}
+	spec.DigestHex = dig.HexSum()
+	spec.EntryDigests = nil
+	if entryDigests {
+		spec.EntryDigests = dig.Transcript().RunningDigests()
+	}
+	return &spec, nil
+}
+
+// runVectorSpec runs the scenario of spec's format version and returns the
+// transcript so far, even when it fails. go-mls panics on some suites rather
+// than failing, which is reported as an error.
+func runVectorSpec(spec *VectorSpec) (dig *TranscriptDigest, err error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	if spec.version() == VectorFormatV1 {
+		return runVectorScenario(spec.Iterations)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: panic: %v", spec.Suite, r)
+		}
+	}()
+	return runVectorScenarioV2(spec)
+}
+
+// runVectorScenario bootstraps the seeded pair and exchanges iterations rounds
+// of messages, returning the transcript so far even when it fails.
+func runVectorScenario(iterations int) (*TranscriptDigest, error) {
+	// The digest covers ciphertexts and commits, so go-mls has to draw from
+	// the same stream the vectors were recorded with.
 	rng := DeterministicRNG()
-	restore := OverrideCryptoRand(rng)
-	defer restore()
 	dig := NewTranscriptDigest()
+	err := WithCryptoRand(rng, func() error { return vectorScenario(rng, dig, iterations) })
+	return dig, err
+}
 
+func vectorScenario(rng io.Reader, dig *TranscriptDigest, iterations int) error {
 	alice, bob, err := BootstrapPairWithDigest(rng, dig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to bootstrap participants: %w", err)
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
 	}
 
-	for i := 0; i < spec.Iterations; i++ {
+	for i := 0; i < iterations; i++ {
 		payload := []byte(fmt.Sprintf("msg-%d", i))
 
 		aliceLabel := fmt.Sprintf("iter-%d-%s-%s", i, alice.Name, bob.Name)
 		if err := ExchangeOnceWithDigest(alice, bob, payload, aliceLabel, dig); err != nil {
-			return &VerifyResult{Digest: dig.HexSum(), ExpectedDigest: strings.ToLower(spec.DigestHex)}, fmt.Errorf("iteration %d alice->bob: %w", i, err)
+			return fmt.Errorf("iteration %d alice->bob: %w", i, err)
 		}
 
 		bobLabel := fmt.Sprintf("iter-%d-%s-%s", i, bob.Name, alice.Name)
 		if err := ExchangeOnceWithDigest(bob, alice, payload, bobLabel, dig); err != nil {
-			return &VerifyResult{Digest: dig.HexSum(), ExpectedDigest: strings.ToLower(spec.DigestHex)}, fmt.Errorf("iteration %d bob->alice: %w", i, err)
+			return fmt.Errorf("iteration %d bob->alice: %w", i, err)
 		}
 	}
 
-	computed := dig.HexSum()
-	expected := strings.ToLower(spec.DigestHex)
-	if computed != expected {
-		return &VerifyResult{Digest: computed, ExpectedDigest: expected}, fmt.Errorf("digest mismatch: computed %s expected %s", computed, expected)
+	return nil
+}
+
+// runVectorScenarioV2 is the version 2 scenario of a validated spec.
+func runVectorScenarioV2(spec *VectorSpec) (*TranscriptDigest, error) {
+	rng := DeterministicRNG()
+	dig := NewTranscriptDigest()
+	err := WithCryptoRand(rng, func() error { return vectorScenarioV2(spec, rng, dig) })
+	return dig, err
+}
+
+func vectorScenarioV2(spec *VectorSpec, rng io.Reader, dig *TranscriptDigest) error {
+	suite, _ := CipherSuiteByName(spec.Suite)
+	var header [2]byte
+	binary.BigEndian.PutUint16(header[:], uint16(suite))
+	if err := dig.AddBytes("format-version", []byte{VectorFormatV2}); err != nil {
+		return err
+	}
+	if err := dig.AddBytes("cipher-suite", header[:]); err != nil {
+		return err
+	}
+
+	names := make([]string, spec.Participants)
+	for i := range names {
+		names[i] = fmt.Sprintf("member-%d", i)
+	}
+	members, err := BootstrapGroupWithDigest(rng, suite, names, dig)
+	if err != nil {
+		return fmt.Errorf("failed to bootstrap participants: %w", err)
+	}
+
+	next := vectorPayloads(spec.Payload)
+	for i := 0; i < spec.Iterations; i++ {
+		for _, sender := range members {
+			payload := next(i, sender.Name)
+			ct, err := sender.State.Protect(payload)
+			if err != nil {
+				return fmt.Errorf("iteration %d: protect failed for %s: %w", i, sender.Name, err)
+			}
+			if err := dig.AddCiphertext(fmt.Sprintf("iter-%d-%s", i, sender.Name), ct); err != nil {
+				return fmt.Errorf("digest update failed: %w", err)
+			}
+			for _, receiver := range members {
+				if receiver == sender {
+					continue
+				}
+				pt, err := receiver.State.Unprotect(ct)
+				if err != nil {
+					return fmt.Errorf("iteration %d: unprotect failed for %s: %w", i, receiver.Name, err)
+				}
+				if string(pt) != string(payload) {
+					return fmt.Errorf("iteration %d: plaintext mismatch for %s -> %s", i, sender.Name, receiver.Name)
+				}
+			}
+		}
 	}
 
-	return &VerifyResult{Digest: computed, ExpectedDigest: expected, OK: true}, nil
+	return nil
+}
+
+// vectorPayloads returns the generator of payload, which gives the message
+// each sender sends in each round, in the order the scenario sends them.
+func vectorPayloads(payload *VectorPayload) func(round int, sender string) []byte {
+	if payload == nil || payload.Kind != "random" {
+		return func(round int, sender string) []byte {
+			return []byte(fmt.Sprintf("msg-%d-%s", round, sender))
+		}
+	}
+	rng := rand.New(rand.NewSource(payload.Seed))
+	return func(int, string) []byte {
+		n := payload.MinBytes + rng.Intn(payload.MaxBytes-payload.MinBytes+1)
+		return RandomBytes(rng, n)
+	}
 }